@@ -0,0 +1,188 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package about describes the third-party modules built into the Spacetime
+// binaries and their licenses, for the compliance reviews that need to know
+// what's being deployed.
+//
+// The licenses of the modules listed in go.mod are recorded in
+// licenses.json, which is embedded into every binary that imports this
+// package. Keep it in sync with go.mod when adding or upgrading dependencies;
+// the tests fail if the two differ.
+package about
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"time"
+)
+
+// mainModule is the module path the Spacetime binaries are built from.
+const mainModule = "aalyria.com/spacetime"
+
+// noAssertion is what SPDX uses for information that wasn't determined.
+const noAssertion = "NOASSERTION"
+
+//go:embed licenses.json
+var manifestJSON []byte
+
+// Module is a Go module built into the running binary.
+type Module struct {
+	Path    string `json:"path"`
+	Version string `json:"version"`
+	// License is the SPDX license expression of the module, or NOASSERTION
+	// if it isn't known.
+	License string `json:"license"`
+}
+
+func manifest() ([]Module, error) {
+	mods := []Module{}
+	if err := json.Unmarshal(manifestJSON, &mods); err != nil {
+		return nil, fmt.Errorf("parsing the embedded license manifest: %w", err)
+	}
+	return mods, nil
+}
+
+// Modules returns the main module followed by the modules it depends on,
+// sorted by path. The modules and their versions come from the build
+// information of the running binary when it has any, and from the embedded
+// manifest otherwise, which is the case for some Bazel builds.
+func Modules() ([]Module, error) {
+	known, err := manifest()
+	if err != nil {
+		return nil, err
+	}
+	licenses := map[string]string{}
+	for _, m := range known {
+		licenses[m.Path] = m.License
+	}
+
+	main := Module{Path: mainModule, Version: "(devel)", License: "Apache-2.0"}
+	deps := known
+	if bi, ok := debug.ReadBuildInfo(); ok && len(bi.Deps) > 0 {
+		if bi.Main.Version != "" {
+			main.Version = bi.Main.Version
+		}
+		deps = make([]Module, 0, len(bi.Deps))
+		for _, d := range bi.Deps {
+			if d.Replace != nil {
+				d = d.Replace
+			}
+			deps = append(deps, Module{Path: d.Path, Version: d.Version, License: licenses[d.Path]})
+		}
+	}
+	for i := range deps {
+		if deps[i].License == "" {
+			deps[i].License = noAssertion
+		}
+	}
+	slices.SortFunc(deps, func(a, b Module) int { return strings.Compare(a.Path, b.Path) })
+	return append([]Module{main}, deps...), nil
+}
+
+// SBOM returns an SPDX 2.3 document, encoded as JSON, that describes the
+// modules of the running binary, `name`. The main module is the package the
+// document describes, and it depends on each of the others.
+func SBOM(name string, created time.Time) ([]byte, error) {
+	mods, err := Modules()
+	if err != nil {
+		return nil, err
+	}
+	return sbom(name, created, mods)
+}
+
+type spdxDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	Name             string            `json:"name"`
+	SPDXID           string            `json:"SPDXID"`
+	VersionInfo      string            `json:"versionInfo"`
+	DownloadLocation string            `json:"downloadLocation"`
+	FilesAnalyzed    bool              `json:"filesAnalyzed"`
+	LicenseConcluded string            `json:"licenseConcluded"`
+	LicenseDeclared  string            `json:"licenseDeclared"`
+	CopyrightText    string            `json:"copyrightText"`
+	ExternalRefs     []spdxExternalRef `json:"externalRefs"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+// sbom returns the SPDX document of `mods`, the first of which is the main
+// module.
+func sbom(name string, created time.Time, mods []Module) ([]byte, error) {
+	doc := spdxDocument{
+		SPDXVersion: "SPDX-2.3",
+		DataLicense: "CC0-1.0",
+		SPDXID:      "SPDXRef-DOCUMENT",
+		Name:        name,
+		// The namespace only needs to be unique to this document, so it's
+		// derived from what the document describes.
+		DocumentNamespace: fmt.Sprintf("https://spdx.aalyria.com/%s/%s/%s", name, mods[0].Version, created.UTC().Format("20060102T150405Z")),
+		CreationInfo: spdxCreationInfo{
+			Created:  created.UTC().Format(time.RFC3339),
+			Creators: []string{"Organization: Aalyria Technologies, Inc.", "Tool: " + name},
+		},
+	}
+	for i, m := range mods {
+		id := fmt.Sprintf("SPDXRef-Package-%d", i)
+		doc.Packages = append(doc.Packages, spdxPackage{
+			Name:             m.Path,
+			SPDXID:           id,
+			VersionInfo:      m.Version,
+			DownloadLocation: noAssertion,
+			LicenseConcluded: m.License,
+			LicenseDeclared:  m.License,
+			CopyrightText:    noAssertion,
+			ExternalRefs: []spdxExternalRef{{
+				ReferenceCategory: "PACKAGE-MANAGER",
+				ReferenceType:     "purl",
+				ReferenceLocator:  "pkg:golang/" + m.Path + "@" + m.Version,
+			}},
+		})
+		if i == 0 {
+			doc.Relationships = append(doc.Relationships, spdxRelationship{doc.SPDXID, "DESCRIBES", id})
+		} else {
+			doc.Relationships = append(doc.Relationships, spdxRelationship{doc.Packages[0].SPDXID, "DEPENDS_ON", id})
+		}
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
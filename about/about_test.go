@@ -0,0 +1,133 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package about
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// requiredModules returns the modules required by the go.mod file at
+// `path`, keyed by path.
+func requiredModules(t *testing.T, path string) map[string]string {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mods := map[string]string{}
+	inBlock := false
+	for _, line := range strings.Split(string(data), "\n") {
+		line, _, _ = strings.Cut(line, "//")
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+		case inBlock && fields[0] == ")":
+			inBlock = false
+		case inBlock && len(fields) == 2:
+			mods[fields[0]] = fields[1]
+		case fields[0] == "require" && len(fields) == 2 && fields[1] == "(":
+			inBlock = true
+		case fields[0] == "require" && len(fields) == 3:
+			mods[fields[1]] = fields[2]
+		}
+	}
+	return mods
+}
+
+func TestManifestMatchesGoMod(t *testing.T) {
+	t.Parallel()
+
+	mods, err := manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, m := range mods {
+		got[m.Path] = m.Version
+		if m.License == "" {
+			t.Errorf("module %s has no license in licenses.json", m.Path)
+		}
+	}
+	if diff := cmp.Diff(requiredModules(t, "../go.mod"), got); diff != "" {
+		t.Errorf("licenses.json doesn't match the modules required by go.mod (-go.mod +licenses.json):\n%s", diff)
+	}
+}
+
+func TestModules(t *testing.T) {
+	t.Parallel()
+
+	mods, err := Modules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mods) < 2 {
+		t.Fatalf("expected the main module and its dependencies, got %v", mods)
+	}
+	if mods[0].Path != mainModule || mods[0].License != "Apache-2.0" {
+		t.Errorf("expected the main module first, got %+v", mods[0])
+	}
+	for _, m := range mods[1:] {
+		if m.License == "" {
+			t.Errorf("module %s has an empty license, expected %s if it isn't known", m.Path, noAssertion)
+		}
+	}
+}
+
+func TestSBOM(t *testing.T) {
+	t.Parallel()
+
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	data, err := sbom("nbictl", created, []Module{
+		{Path: mainModule, Version: "v1.2.3", License: "Apache-2.0"},
+		{Path: "github.com/urfave/cli/v2", Version: "v2.25.7", License: "MIT"},
+		{Path: "example.com/unknown", Version: "v0.1.0", License: noAssertion},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := spdxDocument{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.SPDXVersion != "SPDX-2.3" || doc.CreationInfo.Created != "2024-05-01T12:00:00Z" {
+		t.Errorf("unexpected document header: %+v", doc)
+	}
+	if len(doc.Packages) != 3 {
+		t.Fatalf("expected 3 packages, got %+v", doc.Packages)
+	}
+	if got, want := doc.Packages[1].ExternalRefs[0].ReferenceLocator, "pkg:golang/github.com/urfave/cli/v2@v2.25.7"; got != want {
+		t.Errorf("expected purl %q, got %q", want, got)
+	}
+	if got := doc.Packages[1].LicenseDeclared; got != "MIT" {
+		t.Errorf("expected the declared license to be MIT, got %q", got)
+	}
+
+	want := []spdxRelationship{
+		{"SPDXRef-DOCUMENT", "DESCRIBES", "SPDXRef-Package-0"},
+		{"SPDXRef-Package-0", "DEPENDS_ON", "SPDXRef-Package-1"},
+		{"SPDXRef-Package-0", "DEPENDS_ON", "SPDXRef-Package-2"},
+	}
+	if diff := cmp.Diff(want, doc.Relationships); diff != "" {
+		t.Errorf("unexpected relationships (-want +got):\n%s", diff)
+	}
+}
@@ -20,9 +20,16 @@ import (
 	"errors"
 	"expvar"
 	"fmt"
+	"net/http"
+	"slices"
 	"sync"
+	"time"
 
+	"aalyria.com/spacetime/agent/clocksync"
 	"aalyria.com/spacetime/agent/enactment"
+	"aalyria.com/spacetime/agent/eventlog"
+	"aalyria.com/spacetime/agent/internal/bandwidth"
+	"aalyria.com/spacetime/agent/internal/logging"
 	"aalyria.com/spacetime/agent/internal/task"
 	"aalyria.com/spacetime/agent/telemetry"
 
@@ -45,6 +52,7 @@ var (
 	errNoClock          = errors.New("no clock provided (see WithClock)")
 	errNoNodes          = errors.New("no nodes configured (see WithNode)")
 	errNoActiveServices = errors.New("no services configured for node (see WithEnactmentBackend and WithTelemetryBackend)")
+	errUnknownConn      = errors.New("no such shared connection (see WithSharedConnection)")
 )
 
 // AgentOption provides a well-typed and sound mechanism to configure an Agent.
@@ -67,19 +75,43 @@ func (fn nodeOptFunc) apply(n *node) { fn(n) }
 // Agent is a CDPI agent that coordinates change requests across multiple
 // nodes.
 type Agent struct {
-	clock clockwork.Clock
-	nodes map[string]*node
+	clock      clockwork.Clock
+	clockGuard *clocksync.Guard
+	events     *eventlog.Writer
+	nodes      map[string]*node
+	remoteOps  *remoteOpsConfig
+	// healthServer configures the health endpoints, if any.
+	healthServer *healthServerConfig
+	// conns are the connections shared by the nodes, by name.
+	conns map[string]*sharedConn
+	// notifier, if set, is notified of the Agent's state.
+	notifier         ServiceNotifier
+	watchdogInterval time.Duration
+	// drainTimeout is how long the nodes are drained for before they're
+	// stopped, if at all.
+	drainTimeout time.Duration
+
+	dailyBandwidthCap uint64
+	telemetryReduceAt float64
+	bandwidth         *bandwidth.Meter
+	// telemetryQueue configures each node's telemetry queue, if they have
+	// one.
+	telemetryQueue *TelemetryQueueConfig
 }
 
 // NewAgent creates a new Agent configured with the provided options.
 func NewAgent(opts ...AgentOption) (*Agent, error) {
 	a := &Agent{
 		nodes: map[string]*node{},
+		conns: map[string]*sharedConn{},
 	}
 
 	for _, opt := range opts {
 		opt.apply(a)
 	}
+	if a.clock != nil {
+		a.bandwidth = bandwidth.NewMeter(a.clock, a.dailyBandwidthCap, a.telemetryReduceAt)
+	}
 
 	return a, a.validate()
 }
@@ -96,6 +128,11 @@ func (a *Agent) validate() error {
 		if !n.enactmentsEnabled && !n.telemetryEnabled {
 			errs = append(errs, fmt.Errorf("node %q has no services enabled: %w", n.id, errNoActiveServices))
 		}
+		for _, conn := range []string{n.enactmentConn, n.telemetryConn} {
+			if _, ok := a.conns[conn]; conn != "" && !ok {
+				errs = append(errs, fmt.Errorf("node %q uses connection %q: %w", n.id, conn, errUnknownConn))
+			}
+		}
 	}
 	return errors.Join(errs...)
 }
@@ -112,6 +149,64 @@ func WithRealClock() AgentOption {
 	return WithClock(clockwork.NewRealClock())
 }
 
+// WithClockGuard configures the Agent to run the provided [clocksync.Guard]
+// and consult it before enacting time-critical changes, which are refused
+// while the local clock's offset exceeds the tolerated skew. Enactments are
+// also scheduled to compensate for the measured offset.
+func WithClockGuard(g *clocksync.Guard) AgentOption {
+	return agentOptFunc(func(a *Agent) {
+		a.clockGuard = g
+	})
+}
+
+// WithEventLog configures the Agent to record its decisions about each
+// update, such as accepting, enacting or rolling it back, in `w`. The Agent
+// doesn't close `w`.
+func WithEventLog(w *eventlog.Writer) AgentOption {
+	return agentOptFunc(func(a *Agent) {
+		a.events = w
+	})
+}
+
+// WithDailyBandwidthCap limits the bytes the Agent exchanges with the
+// controller, across all nodes, to `capBytes` per day (starting at midnight
+// UTC). Once usage passes `reduceAt`, a fraction of the cap, telemetry
+// reports are sent progressively less often, and they stop altogether when
+// the cap is reached. Enactment traffic is never restricted. If `reduceAt`
+// isn't in (0, 1], telemetry is reduced from 80% of the cap.
+//
+// Usage is accounted for per stream whether or not a cap is configured, and
+// is exported alongside the Agent's other stats.
+func WithDailyBandwidthCap(capBytes uint64, reduceAt float64) AgentOption {
+	return agentOptFunc(func(a *Agent) {
+		a.dailyBandwidthCap = capBytes
+		a.telemetryReduceAt = reduceAt
+	})
+}
+
+// WithSharedConnection configures a connection to `endpoint`, named `name`,
+// over which the streams of any number of nodes are multiplexed (see
+// [WithSharedEnactmentDriver] and [WithSharedTelemetryDriver]). This saves
+// dialing, authenticating and keeping alive a connection for each node and
+// service, which adds up on gateway hardware that represents many nodes.
+// Each node's streams still identify the node, so the controller treats
+// them as it would if they had their own connections.
+//
+// The connection is dialed when the Agent starts and closed once all of its
+// nodes have stopped. Its traffic is accounted for as a single stream,
+// "shared/<name>".
+func WithSharedConnection(name, endpoint string, dialOpts ...grpc.DialOption) AgentOption {
+	return agentOptFunc(func(a *Agent) {
+		a.conns[name] = &sharedConn{endpoint: endpoint, dialOpts: dialOpts}
+	})
+}
+
+type sharedConn struct {
+	endpoint string
+	dialOpts []grpc.DialOption
+	cc       *grpc.ClientConn
+}
+
 // WithNode configures a network node for the agent to represent.
 func WithNode(id string, opts ...NodeOption) AgentOption {
 	n := &node{id: id}
@@ -132,6 +227,14 @@ type node struct {
 	enactmentEndpoint, telemetryEndpoint string
 	enactmentsEnabled, telemetryEnabled  bool
 	enactmentDialOpts, telemetryDialOpts []grpc.DialOption
+	// enactmentConn and telemetryConn name the shared connections the
+	// services use, if any, instead of dialing their endpoints.
+	enactmentConn, telemetryConn string
+
+	enactmentHTTPFallbackURL string
+	enactmentHTTPClient      *http.Client
+
+	scheduleStateDir string
 }
 
 // WithEnactmentDriver configures the [enactment.Driver] for the given Node.
@@ -144,6 +247,43 @@ func WithEnactmentDriver(endpoint string, d enactment.Driver, dialOpts ...grpc.D
 	})
 }
 
+// WithSharedEnactmentDriver is like [WithEnactmentDriver], but the Node's
+// scheduling stream is multiplexed over the connection named `conn` (see
+// [WithSharedConnection]) rather than a connection of its own.
+func WithSharedEnactmentDriver(conn string, d enactment.Driver) NodeOption {
+	return nodeOptFunc(func(n *node) {
+		n.ed = d
+		n.enactmentConn = conn
+		n.enactmentsEnabled = true
+	})
+}
+
+// WithEnactmentHTTPFallback configures an HTTP/1.1 long-polling endpoint
+// that the Node's enactment service uses whenever the gRPC endpoint provided
+// to [WithEnactmentDriver] is unavailable, such as when a middlebox blocks
+// HTTP/2. The gRPC endpoint is tried again each time the service reconnects.
+// `client` is used to send the requests and is responsible for
+// authenticating them; if nil, [http.DefaultClient] is used.
+func WithEnactmentHTTPFallback(url string, client *http.Client) NodeOption {
+	return nodeOptFunc(func(n *node) {
+		n.enactmentHTTPFallbackURL = url
+		n.enactmentHTTPClient = client
+	})
+}
+
+// WithScheduleStateDir configures the Node to persist its pending schedule
+// entries in `dir`, so that they're still enacted if the agent restarts
+// before it can reconnect to the controller. Restored entries that the
+// controller doesn't re-send shortly after the agent resets its schedule are
+// dropped. The directory also records which entries have been dispatched,
+// so that entries the controller re-sends after a restart aren't enacted
+// again. Each Node needs its own directory.
+func WithScheduleStateDir(dir string) NodeOption {
+	return nodeOptFunc(func(n *node) {
+		n.scheduleStateDir = dir
+	})
+}
+
 // WithTelemetryDriver configures the [telemetry.Driver] for the given Node.
 func WithTelemetryDriver(endpoint string, d telemetry.Driver, dialOpts ...grpc.DialOption) NodeOption {
 	return nodeOptFunc(func(n *node) {
@@ -154,6 +294,17 @@ func WithTelemetryDriver(endpoint string, d telemetry.Driver, dialOpts ...grpc.D
 	})
 }
 
+// WithSharedTelemetryDriver is like [WithTelemetryDriver], but the Node's
+// telemetry is exported over the connection named `conn` (see
+// [WithSharedConnection]) rather than a connection of its own.
+func WithSharedTelemetryDriver(conn string, d telemetry.Driver) NodeOption {
+	return nodeOptFunc(func(n *node) {
+		n.td = d
+		n.telemetryConn = conn
+		n.telemetryEnabled = true
+	})
+}
+
 // Run starts the Agent and blocks until a fatal error is encountered or all
 // node controllers terminate.
 func (a *Agent) Run(ctx context.Context) error {
@@ -176,10 +327,69 @@ func (a *Agent) Run(ctx context.Context) error {
 	// not just the first one.
 	//
 	// TODO: switch this to sourcegraph's conc library
+	if a.clockGuard != nil {
+		guardCtx, stopGuard := context.WithCancel(ctx)
+		defer stopGuard()
+
+		agentMap.Set("clock", expvar.Func(a.clockGuard.Stats))
+		go task.Task(a.clockGuard.Run).
+			WithStartingStoppingLogs("clock guard", zerolog.DebugLevel).
+			WithLogModule("clocksync").
+			WithNewSpan("clock_guard").
+			WithPanicCatcher()(guardCtx)
+	}
+
+	agentMap.Set("bandwidth", expvar.Func(a.bandwidth.Stats))
+
+	if err := a.dialSharedConns(); err != nil {
+		return err
+	}
+	defer a.closeSharedConns(ctx)
+
+	// If they're drained, the nodes and whatever reports on them keep
+	// running for a while after `ctx` is canceled.
+	nodesCtx, stopNodes := ctx, func() {}
+	if a.drainTimeout > 0 {
+		nodesCtx, stopNodes = context.WithCancel(context.WithoutCancel(ctx))
+		defer stopNodes()
+	}
+
 	errCh := make(chan error)
-	if err := a.start(ctx, agentMap, errCh); err != nil {
+	running, err := a.start(nodesCtx, agentMap, errCh)
+	if err != nil {
 		return err
 	}
+	defer context.AfterFunc(ctx, func() {
+		if a.drainTimeout <= 0 {
+			a.notify(ctx, notifyStopping)
+			return
+		}
+		a.notify(ctx, notifyStopping, "STATUS=draining")
+		a.drain(running)
+		stopNodes()
+	})()
+
+	if a.remoteOps != nil {
+		opsCtx, stopOps := context.WithCancel(nodesCtx)
+		defer stopOps()
+
+		go a.serveRemoteOps(logging.Module(opsCtx, "remoteops"), agentMap, running)
+	}
+
+	if a.healthServer != nil || a.notifier != nil {
+		healthCtx, stopHealth := context.WithCancel(logging.Module(nodesCtx, "health"))
+		defer stopHealth()
+
+		hs := a.newHealthServer(running)
+		go hs.run(healthCtx)
+		if a.healthServer != nil {
+			go a.serveHealth(healthCtx, hs)
+		}
+		if a.notifier != nil && a.watchdogInterval > 0 {
+			go a.runWatchdog(healthCtx, hs)
+		}
+	}
+	a.notify(ctx, notifyReady, fmt.Sprintf("STATUS=running %d nodes", len(running)))
 
 	errs := []error{}
 	for err := range errCh {
@@ -190,15 +400,17 @@ func (a *Agent) Run(ctx context.Context) error {
 	return errors.Join(errs...)
 }
 
-func (a *Agent) start(ctx context.Context, agentMap *expvar.Map, errCh chan error) error {
+func (a *Agent) start(ctx context.Context, agentMap *expvar.Map, errCh chan error) (map[string]runningNode, error) {
+	running := map[string]runningNode{}
 	for _, n := range a.nodes {
 		ctx, done := context.WithCancel(ctx)
 
 		nc, err := a.newNodeController(n, done)
 		if err != nil {
-			return fmt.Errorf("node %q: %w", n.id, err)
+			return nil, fmt.Errorf("node %q: %w", n.id, err)
 		}
 		agentMap.Set(n.id, expvar.Func(nc.Stats))
+		running[n.id] = runningNode{nc: nc, ctx: ctx}
 
 		srv := task.Task(nc.run).
 			WithStartingStoppingLogs("node controller", zerolog.DebugLevel).
@@ -208,5 +420,33 @@ func (a *Agent) start(ctx context.Context, agentMap *expvar.Map, errCh chan erro
 
 		go func() { errCh <- srv(ctx) }()
 	}
+	return running, nil
+}
+
+// dialSharedConns creates the connections configured with
+// [WithSharedConnection]. Like the connections of individual nodes, they
+// connect lazily and reconnect as needed.
+func (a *Agent) dialSharedConns() error {
+	for name, c := range a.conns {
+		dialOpts := append(slices.Clip(c.dialOpts), grpc.WithStatsHandler(a.bandwidth.StatsHandler("shared/"+name)))
+		cc, err := grpc.NewClient(c.endpoint, dialOpts...)
+		if err != nil {
+			a.closeSharedConns(context.Background())
+			return fmt.Errorf("failed connecting to shared connection %q: %w", name, err)
+		}
+		c.cc = cc
+	}
 	return nil
 }
+
+func (a *Agent) closeSharedConns(ctx context.Context) {
+	for name, c := range a.conns {
+		if c.cc == nil {
+			continue
+		}
+		if err := c.cc.Close(); err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Str("connection", name).Msg("failed to close shared connection")
+		}
+		c.cc = nil
+	}
+}
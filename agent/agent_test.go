@@ -48,3 +48,17 @@ func TestAgentValidation_noServices(t *testing.T) {
 		t.Errorf("expected NewAgent with no nodes to cause %s, but got %v error instead", errNoActiveServices, err)
 	}
 }
+
+func TestAgentValidation_unknownSharedConnection(t *testing.T) {
+	t.Parallel()
+	_, err := NewAgent(
+		WithRealClock(),
+		WithSharedConnection("sbi", "localhost:1234"),
+		WithNode("a", WithSharedEnactmentDriver("sbi", nil)),
+		WithNode("b", WithSharedTelemetryDriver("telemetry", nil)),
+	)
+
+	if !errors.Is(err, errUnknownConn) {
+		t.Errorf("expected NewAgent with an unknown shared connection to cause %s, but got %v error instead", errUnknownConn, err)
+	}
+}
@@ -0,0 +1,252 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package clocksync guards against enacting time-critical changes while the
+// local clock has drifted from a reference clock, such as an NTP server.
+package clocksync
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// DefaultMaxSkew is the largest clock offset a [Guard] tolerates unless
+	// configured otherwise.
+	DefaultMaxSkew = 100 * time.Millisecond
+	// DefaultInterval is how often a [Guard] measures the clock offset
+	// unless configured otherwise.
+	DefaultInterval = 1 * time.Minute
+)
+
+// An OffsetSource measures the offset of the local clock from a reference
+// clock. The offset is positive when the local clock is ahead of the
+// reference.
+type OffsetSource interface {
+	Offset(context.Context) (time.Duration, error)
+}
+
+// OffsetSourceFunc is an adapter to allow the use of ordinary functions as
+// [OffsetSource] implementations, for example to use a reference provided
+// over the SBI.
+type OffsetSourceFunc func(context.Context) (time.Duration, error)
+
+func (f OffsetSourceFunc) Offset(ctx context.Context) (time.Duration, error) { return f(ctx) }
+
+// SkewError is returned by [Guard.Check] when the measured offset exceeds
+// the tolerated skew.
+type SkewError struct {
+	Offset, MaxSkew time.Duration
+}
+
+func (e *SkewError) Error() string {
+	return fmt.Sprintf("clock offset %s exceeds the maximum tolerated skew of %s", e.Offset, e.MaxSkew)
+}
+
+// GuardConfig configures a [Guard].
+type GuardConfig struct {
+	// Source measures the clock offset. Required.
+	Source OffsetSource
+	// MaxSkew is the largest offset, in either direction, at which
+	// time-critical changes are still enacted. Defaults to [DefaultMaxSkew].
+	MaxSkew time.Duration
+	// Interval is how often the offset is measured. Defaults to
+	// [DefaultInterval].
+	Interval time.Duration
+	// Clock defaults to the real clock.
+	Clock clockwork.Clock
+}
+
+// A Guard periodically measures the local clock's offset and reports
+// whether it's safe to enact time-critical changes. The latest successful
+// measurement decides: until the first one succeeds, and while the source
+// is unreachable, the Guard relies on the last offset it measured.
+type Guard struct {
+	src   OffsetSource
+	clock clockwork.Clock
+	// reconfigured wakes Run up when the interval changes.
+	reconfigured chan struct{}
+
+	mu           sync.Mutex
+	maxSkew      time.Duration
+	interval     time.Duration
+	measured     bool
+	offset       time.Duration
+	lastMeasured time.Time
+	lastErr      error
+}
+
+// NewGuard creates a [Guard] from the provided [GuardConfig]. The Guard
+// doesn't measure anything until [Guard.Run] is called.
+func NewGuard(c GuardConfig) *Guard {
+	g := &Guard{
+		src:          c.Source,
+		clock:        c.Clock,
+		reconfigured: make(chan struct{}, 1),
+	}
+	g.SetMaxSkew(c.MaxSkew)
+	g.SetInterval(c.Interval)
+	if g.clock == nil {
+		g.clock = clockwork.NewRealClock()
+	}
+	return g
+}
+
+// SetMaxSkew changes the largest offset at which time-critical changes are
+// still enacted. A non-positive `maxSkew` restores [DefaultMaxSkew].
+func (g *Guard) SetMaxSkew(maxSkew time.Duration) {
+	if maxSkew <= 0 {
+		maxSkew = DefaultMaxSkew
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.maxSkew = maxSkew
+}
+
+// SetInterval changes how often the offset is measured. A running Guard
+// waits for the new interval from the time of its last measurement. A
+// non-positive `interval` restores [DefaultInterval].
+func (g *Guard) SetInterval(interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	g.mu.Lock()
+	changed := g.interval != interval
+	g.interval = interval
+	g.mu.Unlock()
+
+	if changed {
+		select {
+		case g.reconfigured <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Run measures the clock offset every interval until `ctx` is canceled.
+// Failed measurements are logged rather than returned.
+func (g *Guard) Run(ctx context.Context) error {
+	for {
+		g.measure(ctx)
+		if err := g.wait(ctx, g.clock.Now()); err != nil {
+			return err
+		}
+	}
+}
+
+// wait blocks until the interval has passed since `last`, re-evaluating the
+// interval whenever it changes, or until `ctx` is canceled.
+func (g *Guard) wait(ctx context.Context, last time.Time) error {
+	for {
+		g.mu.Lock()
+		remaining := g.interval - g.clock.Since(last)
+		g.mu.Unlock()
+
+		timer := g.clock.NewTimer(remaining)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return context.Cause(ctx)
+		case <-g.reconfigured:
+			timer.Stop()
+		case <-timer.Chan():
+			return nil
+		}
+	}
+}
+
+func (g *Guard) measure(ctx context.Context) {
+	offset, err := g.src.Offset(ctx)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.lastErr = err
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to measure clock offset")
+		return
+	}
+	g.measured, g.offset, g.lastMeasured = true, offset, g.clock.Now()
+
+	if abs(offset) > g.maxSkew {
+		zerolog.Ctx(ctx).Error().Dur("offset", offset).Dur("maxSkew", g.maxSkew).Msg("clock skew exceeds tolerance; time-critical changes won't be enacted")
+	} else {
+		zerolog.Ctx(ctx).Debug().Dur("offset", offset).Msg("measured clock offset")
+	}
+}
+
+// Offset returns the latest measured offset, or 0 if the offset hasn't been
+// measured yet.
+func (g *Guard) Offset() time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.offset
+}
+
+// MaxSkew returns the largest offset at which time-critical changes are
+// still enacted.
+func (g *Guard) MaxSkew() time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.maxSkew
+}
+
+// Check returns a [*SkewError] if the latest measured offset exceeds the
+// tolerated skew.
+func (g *Guard) Check() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.measured && abs(g.offset) > g.maxSkew {
+		return &SkewError{Offset: g.offset, MaxSkew: g.maxSkew}
+	}
+	return nil
+}
+
+// Stats returns the Guard's latest measurement in a form suitable for
+// exporting with expvar.
+func (g *Guard) Stats() any {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	lastErr := ""
+	if g.lastErr != nil {
+		lastErr = g.lastErr.Error()
+	}
+	return struct {
+		OffsetSeconds  float64
+		MaxSkewSeconds float64
+		Measured       bool
+		LastMeasured   time.Time
+		LastError      string
+	}{
+		OffsetSeconds:  g.offset.Seconds(),
+		MaxSkewSeconds: g.maxSkew.Seconds(),
+		Measured:       g.measured,
+		LastMeasured:   g.lastMeasured,
+		LastError:      lastErr,
+	}
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
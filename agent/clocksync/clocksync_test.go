@@ -0,0 +1,193 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clocksync
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+)
+
+// serveNTP answers a single NTP request on `conn`, reporting `serverTime`
+// as both the receive and transmit timestamps.
+func serveNTP(t *testing.T, conn net.PacketConn, serverTime time.Time, stratum byte) {
+	t.Helper()
+
+	req := make([]byte, ntpPacketLen)
+	n, addr, err := conn.ReadFrom(req)
+	if err != nil {
+		t.Errorf("reading NTP request: %v", err)
+		return
+	}
+	if n != ntpPacketLen || req[0]&0x7 != ntpModeClient {
+		t.Errorf("unexpected NTP request: %x", req[:n])
+		return
+	}
+
+	rsp := make([]byte, ntpPacketLen)
+	rsp[0] = ntpVersion<<3 | ntpModeServer
+	rsp[1] = stratum
+	copy(rsp[12:16], "RATE")
+	copy(rsp[24:32], req[40:48])
+	binary.BigEndian.PutUint64(rsp[32:], toNTPTime(serverTime))
+	binary.BigEndian.PutUint64(rsp[40:], toNTPTime(serverTime))
+	if _, err := conn.WriteTo(rsp, addr); err != nil {
+		t.Errorf("writing NTP response: %v", err)
+	}
+}
+
+func TestNTPSource(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		desc       string
+		stratum    byte
+		wantOffset time.Duration
+		wantErr    string
+	}{
+		{desc: "local clock ahead", stratum: 2, wantOffset: 2 * time.Second},
+		{desc: "kiss-o'-death", stratum: 0, wantErr: `kiss-o'-death code "RATE"`},
+	} {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			clock := clockwork.NewFakeClockAt(time.Date(2024, time.March, 1, 12, 0, 0, 500_000_000, time.UTC))
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				serveNTP(t, conn, clock.Now().Add(-2*time.Second), tc.stratum)
+			}()
+
+			offset, err := NewNTPSource(conn.LocalAddr().String(), clock).Offset(context.Background())
+			<-done
+			switch {
+			case tc.wantErr != "":
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("Offset() returned error %v, want one containing %q", err, tc.wantErr)
+				}
+			case err != nil:
+				t.Errorf("Offset() failed: %v", err)
+			// NTP timestamps have sub-nanosecond precision, but the
+			// conversions truncate.
+			case (offset - tc.wantOffset).Abs() > time.Microsecond:
+				t.Errorf("Offset() = %v, want %v", offset, tc.wantOffset)
+			}
+		})
+	}
+}
+
+func TestNTPTimeRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	want := time.Date(2024, time.March, 1, 12, 0, 0, 123_456_789, time.UTC)
+	if got := fromNTPTime(toNTPTime(want)); got.Sub(want).Abs() > time.Nanosecond {
+		t.Errorf("fromNTPTime(toNTPTime(%v)) = %v", want, got.UTC())
+	}
+}
+
+func TestGuard(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	var offset time.Duration
+	var offsetErr error
+	g := NewGuard(GuardConfig{
+		Source:  OffsetSourceFunc(func(context.Context) (time.Duration, error) { return offset, offsetErr }),
+		MaxSkew: 50 * time.Millisecond,
+		Clock:   clockwork.NewFakeClock(),
+	})
+
+	if err := g.Check(); err != nil {
+		t.Errorf("Check() before any measurement returned %v, want nil", err)
+	}
+
+	offset = 10 * time.Millisecond
+	g.measure(ctx)
+	if err := g.Check(); err != nil {
+		t.Errorf("Check() with a tolerable offset returned %v, want nil", err)
+	}
+
+	offset = -80 * time.Millisecond
+	g.measure(ctx)
+	skewErr := &SkewError{}
+	if err := g.Check(); !errors.As(err, &skewErr) || skewErr.Offset != offset {
+		t.Errorf("Check() with an excessive offset returned %v, want a SkewError", err)
+	}
+
+	// Failed measurements leave the last offset in place.
+	offsetErr = errors.New("unreachable")
+	g.measure(ctx)
+	if got := g.Offset(); got != offset {
+		t.Errorf("Offset() after a failed measurement = %v, want %v", got, offset)
+	}
+	if err := g.Check(); err == nil {
+		t.Errorf("Check() after a failed measurement returned nil, want a SkewError")
+	}
+}
+
+func TestGuard_reconfigure(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := clockwork.NewFakeClock()
+	measured := make(chan struct{}, 10)
+	g := NewGuard(GuardConfig{
+		Source: OffsetSourceFunc(func(context.Context) (time.Duration, error) {
+			measured <- struct{}{}
+			return 80 * time.Millisecond, nil
+		}),
+		Interval: time.Hour,
+		Clock:    clock,
+	})
+	go g.Run(ctx)
+	<-measured
+
+	if err := g.Check(); err != nil {
+		t.Errorf("Check() with the default max skew returned %v, want nil", err)
+	}
+	g.SetMaxSkew(50 * time.Millisecond)
+	if err := g.Check(); err == nil {
+		t.Error("Check() after lowering the max skew returned nil, want a SkewError")
+	}
+
+	// Shortening the interval applies to the wait already in progress.
+	clock.BlockUntil(1)
+	clock.Advance(time.Minute)
+	g.SetInterval(2 * time.Minute)
+	// Wait for Run to start waiting on the new interval.
+	clock.BlockUntil(1)
+	select {
+	case <-measured:
+		t.Fatal("Guard measured the offset before the new interval passed")
+	default:
+	}
+	clock.Advance(time.Minute)
+	<-measured
+}
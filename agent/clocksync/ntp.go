@@ -0,0 +1,130 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clocksync
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+)
+
+const (
+	ntpPort       = "123"
+	ntpPacketLen  = 48
+	ntpTimeout    = 5 * time.Second
+	ntpEpochDelta = 2208988800 // Seconds between 1900-01-01 and 1970-01-01.
+
+	ntpVersion     = 4
+	ntpModeClient  = 3
+	ntpModeServer  = 4
+	ntpLeapUnknown = 3
+)
+
+// NewNTPSource returns an [OffsetSource] that queries `server`, given as
+// "host" or "host:port", using the Simple Network Time Protocol described in
+// RFC 4330. If `clock` is nil, the real clock is used.
+func NewNTPSource(server string, clock clockwork.Clock) OffsetSource {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, ntpPort)
+	}
+	if clock == nil {
+		clock = clockwork.NewRealClock()
+	}
+	return &ntpSource{addr: server, clock: clock}
+}
+
+type ntpSource struct {
+	addr  string
+	clock clockwork.Clock
+}
+
+func (s *ntpSource) Offset(ctx context.Context) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, ntpTimeout)
+	defer cancel()
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "udp", s.addr)
+	if err != nil {
+		return 0, fmt.Errorf("connecting to NTP server %s: %w", s.addr, err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	req := make([]byte, ntpPacketLen)
+	req[0] = ntpVersion<<3 | ntpModeClient
+	t1 := s.clock.Now()
+	// The transmit timestamp is echoed back as the originate timestamp,
+	// which lets us match the response to this request.
+	binary.BigEndian.PutUint64(req[40:], toNTPTime(t1))
+	if _, err := conn.Write(req); err != nil {
+		return 0, fmt.Errorf("querying NTP server %s: %w", s.addr, err)
+	}
+
+	rsp := make([]byte, ntpPacketLen)
+	n, err := conn.Read(rsp)
+	t4 := s.clock.Now()
+	if err != nil {
+		return 0, fmt.Errorf("reading response from NTP server %s: %w", s.addr, err)
+	}
+	offset, err := parseNTPResponse(rsp[:n], req[40:48], t1, t4)
+	if err != nil {
+		return 0, fmt.Errorf("NTP server %s: %w", s.addr, err)
+	}
+	return offset, nil
+}
+
+// parseNTPResponse validates an NTP response to a request whose transmit
+// timestamp was `origin`, sent at `t1` and received at `t4`, and returns the
+// offset of the local clock from the server's.
+func parseNTPResponse(rsp, origin []byte, t1, t4 time.Time) (time.Duration, error) {
+	if len(rsp) < ntpPacketLen {
+		return 0, fmt.Errorf("short response of %d bytes", len(rsp))
+	}
+	leap, mode, stratum := rsp[0]>>6, rsp[0]&0x7, rsp[1]
+	switch {
+	case mode != ntpModeServer:
+		return 0, fmt.Errorf("unexpected mode %d in response", mode)
+	case stratum == 0:
+		return 0, fmt.Errorf("server sent kiss-o'-death code %q", rsp[12:16])
+	case leap == ntpLeapUnknown:
+		return 0, errors.New("server clock isn't synchronized")
+	case string(rsp[24:32]) != string(origin):
+		return 0, errors.New("response doesn't match the request")
+	}
+
+	t2 := fromNTPTime(binary.BigEndian.Uint64(rsp[32:]))
+	t3 := fromNTPTime(binary.BigEndian.Uint64(rsp[40:]))
+	// This is the negation of the offset defined by RFC 4330, which is that
+	// of the server's clock relative to the local one.
+	return (t1.Sub(t2) + t4.Sub(t3)) / 2, nil
+}
+
+func toNTPTime(t time.Time) uint64 {
+	secs := uint64(t.Unix() + ntpEpochDelta)
+	frac := (uint64(t.Nanosecond()) << 32) / uint64(time.Second)
+	return secs<<32 | frac
+}
+
+func fromNTPTime(v uint64) time.Time {
+	secs := int64(v>>32) - ntpEpochDelta
+	nsecs := int64(((v & 0xffffffff) * uint64(time.Second)) >> 32)
+	return time.Unix(secs, nsecs)
+}
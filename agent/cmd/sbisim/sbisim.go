@@ -0,0 +1,284 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package main provides a standalone SBI simulator that agents can connect
+// to in place of a live Spacetime instance.
+//
+// The simulator reads a script of commands, one per line, from standard
+// input (or the file named by -script) and writes every message it receives
+// from agents to standard output as a JSON object per line. Blank lines and
+// lines starting with "#" are ignored. The commands are:
+//
+//	wait-hello NODE      wait for NODE to open a scheduling stream
+//	send NODE JSON       send a ReceiveRequestsMessageFromController, in its
+//	                     JSON form, to NODE; unset request IDs, schedule
+//	                     manipulation tokens and seqnos are filled in
+//	reset-stream NODE    break NODE's scheduling stream
+//	link-down NODE       take NODE's link to the controller down
+//	link-up NODE         bring NODE's link to the controller back up
+//	impair NODE [KEY=VALUE...]
+//	                     impair NODE's link to the controller; the keys are
+//	                     delay, jitter, loss, reorder and seed (see
+//	                     [sbisim.Impairment]), and none restores the link
+//	ack-delay DURATION   delay acknowledging resets and telemetry reports
+//	sleep DURATION       pause the script
+//
+// Alternatively, -scenario names a YAML timeline of events to play out
+// instead of a script (see [sbisim.Scenario]). Random choices in the
+// scenario, such as the jitter of its events, are seeded with the
+// scenario's seed, or with -seed if it's provided, so failures can be
+// reproduced by running the scenario again with the same seed. Telemetry
+// events aren't supported, since the simulator can't make agents report
+// telemetry.
+//
+// Once the script or scenario ends, the simulator keeps serving until it's
+// interrupted.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"aalyria.com/spacetime/agent/sbisim"
+	schedpb "aalyria.com/spacetime/api/scheduling/v1alpha"
+
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+func main() {
+	if err := run(context.Background(), os.Args[0], os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "fatal error: %v\n", err)
+		os.Exit(2)
+	}
+}
+
+func run(ctx context.Context, appName string, args []string) error {
+	fs := flag.NewFlagSet(appName, flag.ContinueOnError)
+	listen := fs.String("listen", "localhost:50051", "The address (host:port) to accept plaintext agent connections on.")
+	scriptPath := fs.String("script", "", "The file to read the script from. Defaults to standard input.")
+	scenarioPath := fs.String("scenario", "", "The file to read a YAML scenario from, instead of a script.")
+	seed := fs.Uint64("seed", 0, "The seed for the random choices of the scenario. Defaults to the scenario's own seed.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *scriptPath != "" && *scenarioPath != "" {
+		return errors.New("only one of -script and -scenario can be provided")
+	}
+
+	var scenario *sbisim.Scenario
+	if *scenarioPath != "" {
+		data, err := os.ReadFile(*scenarioPath)
+		if err != nil {
+			return err
+		}
+		if scenario, err = sbisim.ParseScenario(data); err != nil {
+			return err
+		}
+		fs.Visit(func(f *flag.Flag) {
+			if f.Name == "seed" {
+				scenario.Seed = *seed
+			}
+		})
+	}
+
+	script := io.Reader(os.Stdin)
+	if *scriptPath != "" {
+		f, err := os.Open(*scriptPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		script = f
+	}
+
+	lis, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "serving the SBI on %s\n", lis.Addr())
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+
+	srv := sbisim.New()
+	srv.SetObserver(newObserver(os.Stdout))
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.Go(func() error { return srv.Serve(ctx, lis) })
+	if scenario != nil {
+		fmt.Fprintf(os.Stderr, "running scenario %s with seed %d\n", *scenarioPath, scenario.Seed)
+		g.Go(func() error { return scenario.Run(ctx, srv) })
+	} else {
+		g.Go(func() error { return runScript(ctx, srv, script) })
+	}
+	return g.Wait()
+}
+
+// newObserver returns a [sbisim.Server] observer that writes the messages
+// it's given to `w`, one JSON object per line.
+func newObserver(w io.Writer) func(string, proto.Message) {
+	mu := &sync.Mutex{}
+	enc := json.NewEncoder(w)
+	return func(nodeID string, msg proto.Message) {
+		data, err := protojson.Marshal(msg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "encoding %T: %v\n", msg, err)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		enc.Encode(struct {
+			Time    time.Time       `json:"time"`
+			Node    string          `json:"node,omitempty"`
+			Type    string          `json:"type"`
+			Message json.RawMessage `json:"message"`
+		}{
+			Time:    time.Now().UTC(),
+			Node:    nodeID,
+			Type:    string(msg.ProtoReflect().Descriptor().FullName()),
+			Message: data,
+		})
+	}
+}
+
+func runScript(ctx context.Context, srv *sbisim.Server, script io.Reader) error {
+	sc := bufio.NewScanner(script)
+	sc.Buffer(nil, 1<<20)
+	for lineNo := 1; sc.Scan(); lineNo++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := runCommand(ctx, srv, line); err != nil {
+			return fmt.Errorf("script line %d: %w", lineNo, err)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("reading script: %w", err)
+	}
+	return nil
+}
+
+func runCommand(ctx context.Context, srv *sbisim.Server, line string) error {
+	cmd, rest, _ := strings.Cut(line, " ")
+	rest = strings.TrimSpace(rest)
+
+	switch cmd {
+	case "wait-hello":
+		_, err := srv.Node(rest).WaitForHello(ctx)
+		return err
+
+	case "send":
+		nodeID, data, ok := strings.Cut(rest, " ")
+		if !ok {
+			return errors.New("usage: send NODE JSON")
+		}
+		req := &schedpb.ReceiveRequestsMessageFromController{}
+		if err := protojson.Unmarshal([]byte(data), req); err != nil {
+			return fmt.Errorf("parsing request: %w", err)
+		}
+		_, err := srv.Node(nodeID).Send(ctx, req)
+		return err
+
+	case "reset-stream":
+		if !srv.Node(rest).ResetStream() {
+			fmt.Fprintf(os.Stderr, "node %q has no stream to reset\n", rest)
+		}
+		return nil
+
+	case "link-down", "link-up":
+		srv.Node(rest).SetLinkDown(cmd == "link-down")
+		return nil
+
+	case "impair":
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			return errors.New("usage: impair NODE [KEY=VALUE...]")
+		}
+		imp, err := parseImpairment(fields[1:])
+		if err != nil {
+			return err
+		}
+		return srv.Node(fields[0]).SetImpairment(imp)
+
+	case "ack-delay":
+		d, err := time.ParseDuration(rest)
+		if err != nil {
+			return err
+		}
+		srv.SetAckDelay(d)
+		return nil
+
+	case "sleep":
+		d, err := time.ParseDuration(rest)
+		if err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		case <-time.After(d):
+			return nil
+		}
+
+	default:
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+}
+
+// parseImpairment parses the KEY=VALUE settings of an impair command.
+func parseImpairment(settings []string) (sbisim.Impairment, error) {
+	imp := sbisim.Impairment{}
+	for _, setting := range settings {
+		key, value, ok := strings.Cut(setting, "=")
+		if !ok {
+			return imp, fmt.Errorf("impairment setting %q isn't of the form KEY=VALUE", setting)
+		}
+
+		var err error
+		switch key {
+		case "delay":
+			imp.Delay, err = time.ParseDuration(value)
+		case "jitter":
+			imp.Jitter, err = time.ParseDuration(value)
+		case "loss":
+			imp.Loss, err = strconv.ParseFloat(value, 64)
+		case "reorder":
+			imp.Reorder, err = strconv.ParseFloat(value, 64)
+		case "seed":
+			imp.Seed, err = strconv.ParseUint(value, 10, 64)
+		default:
+			return imp, fmt.Errorf("unknown impairment setting %q", key)
+		}
+		if err != nil {
+			return imp, fmt.Errorf("impairment setting %q: %w", key, err)
+		}
+	}
+	return imp, nil
+}
@@ -594,7 +594,6 @@ func (b *Driver) Apply(ctx context.Context, req *apipb.ScheduledControlUpdate) (
 			for _, r := range b.routesToRuleIDs {
 				if r.route.Equal(*route) {
 					log.Debug().Msgf("skipping adding flow rule %q because route is already installed", flowRuleID)
-					fmt.Printf("skipping adding flow rule %q because route is already installed\n", flowRuleID)
 					needsAdd = false
 					r.ruleIDs[flowRuleID] = flowRule
 				}
@@ -0,0 +1,193 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package enactment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	schedpb "aalyria.com/spacetime/api/scheduling/v1alpha"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// FailureDomain is the [errdetails.ErrorInfo] domain of the failures
+// reported by a [TransactionalDriver].
+const FailureDomain = "agent.spacetime.aalyria.com"
+
+// FailureClass classifies how far an enactment got before it failed.
+type FailureClass string
+
+const (
+	// FailurePrepare means the change was rejected before any step was
+	// applied, so the node's state is unchanged.
+	FailurePrepare FailureClass = "PREPARE_FAILED"
+	// FailureCommit means a step failed and the steps applied before it were
+	// rolled back, so the node's state is unchanged.
+	FailureCommit FailureClass = "COMMIT_FAILED"
+	// FailureRollback means a step failed and at least one of the steps
+	// applied before it couldn't be rolled back, so the node is left in a
+	// partially enacted state.
+	FailureRollback FailureClass = "ROLLBACK_FAILED"
+)
+
+// Step is a single sub-step of an enactment, such as installing one route,
+// that can be undone.
+type Step interface {
+	// Rule identifies what the step changes, such as the ID of a route or
+	// flow rule. It's used to report which rules a failure affected.
+	Rule() string
+	// Commit applies the step.
+	Commit(context.Context) error
+	// Rollback undoes a successful Commit.
+	Rollback(context.Context) error
+}
+
+// TransactionalBackend is a backend whose changes are made of steps that can
+// be rolled back. Wrap it with [NewTransactionalDriver] to use it as a
+// [Driver].
+type TransactionalBackend interface {
+	// Init initializes the backend.
+	Init(context.Context) error
+	// Prepare validates the provided [schedpb.CreateEntryRequest] and returns
+	// the steps that enact it, in order, without applying any of them.
+	Prepare(context.Context, *schedpb.CreateEntryRequest) ([]Step, error)
+	// Stats returns internal statistics for the backend in an unstructured
+	// form.
+	Stats() any
+	// Close closes the backend.
+	Close() error
+}
+
+// TransactionalDriver is a [Driver] that enacts changes as transactions: the
+// steps of a change are committed in order, and if one fails, the steps
+// already committed are rolled back in reverse order.
+type TransactionalDriver struct {
+	backend TransactionalBackend
+}
+
+// NewTransactionalDriver returns a [Driver] that enacts changes through `b`.
+func NewTransactionalDriver(b TransactionalBackend) *TransactionalDriver {
+	return &TransactionalDriver{backend: b}
+}
+
+func (d *TransactionalDriver) Init(ctx context.Context) error { return d.backend.Init(ctx) }
+func (d *TransactionalDriver) Stats() any                     { return d.backend.Stats() }
+func (d *TransactionalDriver) Close() error                   { return d.backend.Close() }
+
+// Dispatch enacts `req`. Any error it returns is a *[Failure].
+func (d *TransactionalDriver) Dispatch(ctx context.Context, req *schedpb.CreateEntryRequest) error {
+	steps, err := d.backend.Prepare(ctx, req)
+	if err != nil {
+		return &Failure{Class: FailurePrepare, EntryID: req.GetId(), Err: err}
+	}
+
+	for i, step := range steps {
+		err := step.Commit(ctx)
+		if err == nil {
+			continue
+		}
+
+		f := &Failure{Class: FailureCommit, EntryID: req.GetId(), FailedRule: step.Rule(), Err: err}
+		rollbackErrs := []error{}
+		for j := i - 1; j >= 0; j-- {
+			f.AffectedRules = append(f.AffectedRules, steps[j].Rule())
+			// The rollback has to be attempted even if the dispatch was
+			// canceled, otherwise the node is left half-enacted.
+			if err := steps[j].Rollback(context.WithoutCancel(ctx)); err != nil {
+				f.Class = FailureRollback
+				f.Inconsistent = append(f.Inconsistent, steps[j].Rule())
+				rollbackErrs = append(rollbackErrs, fmt.Errorf("rolling back %q: %w", steps[j].Rule(), err))
+			}
+		}
+		f.RollbackErr = errors.Join(rollbackErrs...)
+		return f
+	}
+	return nil
+}
+
+// Failure describes a change that a [TransactionalDriver] failed to enact.
+// It implements the gRPCStatus interface, so the status reported upstream
+// carries the failure class and the affected rules as an
+// [errdetails.ErrorInfo].
+type Failure struct {
+	Class   FailureClass
+	EntryID string
+	// FailedRule is the rule of the step that failed to commit, if any.
+	FailedRule string
+	// AffectedRules are the rules of the steps that were committed before
+	// the failure, in the order they were rolled back.
+	AffectedRules []string
+	// Inconsistent are the affected rules that couldn't be rolled back.
+	Inconsistent []string
+	// Err is the error that caused the failure.
+	Err error
+	// RollbackErr holds the errors encountered while rolling back, if any.
+	RollbackErr error
+}
+
+func (f *Failure) Error() string {
+	msg := fmt.Sprintf("enacting entry %q: %s", f.EntryID, f.Class)
+	if f.FailedRule != "" {
+		msg += fmt.Sprintf(" at rule %q", f.FailedRule)
+	}
+	msg += ": " + f.Err.Error()
+	if f.RollbackErr != nil {
+		msg += fmt.Sprintf(" (rollback failed: %v)", f.RollbackErr)
+	}
+	return msg
+}
+
+func (f *Failure) Unwrap() []error {
+	if f.RollbackErr == nil {
+		return []error{f.Err}
+	}
+	return []error{f.Err, f.RollbackErr}
+}
+
+// GRPCStatus returns the status reported upstream for the failure. Its code
+// is that of the original error if it has one, or Aborted if the node's
+// state is unchanged and Internal if it isn't.
+func (f *Failure) GRPCStatus() *status.Status {
+	code := status.Code(f.Err)
+	if code == codes.Unknown {
+		code = codes.Aborted
+		if f.Class == FailureRollback {
+			code = codes.Internal
+		}
+	}
+
+	st := status.New(code, f.Error())
+	detailed, err := st.WithDetails(&errdetails.ErrorInfo{
+		Reason: string(f.Class),
+		Domain: FailureDomain,
+		Metadata: map[string]string{
+			"entry_id":           f.EntryID,
+			"failed_rule":        f.FailedRule,
+			"affected_rules":     strings.Join(f.AffectedRules, ","),
+			"inconsistent_rules": strings.Join(f.Inconsistent, ","),
+			"rolled_back":        strconv.FormatBool(f.Class != FailureRollback),
+		},
+	})
+	if err != nil {
+		return st
+	}
+	return detailed
+}
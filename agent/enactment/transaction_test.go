@@ -0,0 +1,182 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package enactment
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	schedpb "aalyria.com/spacetime/api/scheduling/v1alpha"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/testing/protocmp"
+)
+
+// fakeStep records its commits and rollbacks in `log`.
+type fakeStep struct {
+	rule                   string
+	commitErr, rollbackErr error
+	log                    *[]string
+}
+
+func (s *fakeStep) Rule() string { return s.rule }
+
+func (s *fakeStep) Commit(context.Context) error {
+	*s.log = append(*s.log, "commit "+s.rule)
+	return s.commitErr
+}
+
+func (s *fakeStep) Rollback(context.Context) error {
+	*s.log = append(*s.log, "rollback "+s.rule)
+	return s.rollbackErr
+}
+
+type fakeBackend struct {
+	steps      []Step
+	prepareErr error
+}
+
+func (b *fakeBackend) Init(context.Context) error { return nil }
+func (b *fakeBackend) Stats() any                 { return nil }
+func (b *fakeBackend) Close() error               { return nil }
+
+func (b *fakeBackend) Prepare(context.Context, *schedpb.CreateEntryRequest) ([]Step, error) {
+	return b.steps, b.prepareErr
+}
+
+func TestTransactionalDriver(t *testing.T) {
+	t.Parallel()
+
+	errBoom := errors.New("boom")
+
+	for _, tc := range []struct {
+		desc       string
+		prepareErr error
+		commitErrs map[string]error
+		rbErrs     map[string]error
+		wantLog    []string
+		wantCode   codes.Code
+		wantInfo   *errdetails.ErrorInfo
+	}{
+		{
+			desc:     "all steps commit",
+			wantLog:  []string{"commit route-1", "commit route-2", "commit route-3"},
+			wantCode: codes.OK,
+		},
+		{
+			desc:       "prepare fails",
+			prepareErr: status.Error(codes.InvalidArgument, "bad route"),
+			wantLog:    []string{},
+			wantCode:   codes.InvalidArgument,
+			wantInfo: &errdetails.ErrorInfo{
+				Reason: string(FailurePrepare),
+				Domain: FailureDomain,
+				Metadata: map[string]string{
+					"entry_id":           "entry-1",
+					"failed_rule":        "",
+					"affected_rules":     "",
+					"inconsistent_rules": "",
+					"rolled_back":        "true",
+				},
+			},
+		},
+		{
+			desc:       "commit fails and is rolled back",
+			commitErrs: map[string]error{"route-3": errBoom},
+			wantLog: []string{
+				"commit route-1", "commit route-2", "commit route-3",
+				"rollback route-2", "rollback route-1",
+			},
+			wantCode: codes.Aborted,
+			wantInfo: &errdetails.ErrorInfo{
+				Reason: string(FailureCommit),
+				Domain: FailureDomain,
+				Metadata: map[string]string{
+					"entry_id":           "entry-1",
+					"failed_rule":        "route-3",
+					"affected_rules":     "route-2,route-1",
+					"inconsistent_rules": "",
+					"rolled_back":        "true",
+				},
+			},
+		},
+		{
+			desc:       "rollback fails",
+			commitErrs: map[string]error{"route-3": errBoom},
+			rbErrs:     map[string]error{"route-1": errBoom},
+			wantLog: []string{
+				"commit route-1", "commit route-2", "commit route-3",
+				"rollback route-2", "rollback route-1",
+			},
+			wantCode: codes.Internal,
+			wantInfo: &errdetails.ErrorInfo{
+				Reason: string(FailureRollback),
+				Domain: FailureDomain,
+				Metadata: map[string]string{
+					"entry_id":           "entry-1",
+					"failed_rule":        "route-3",
+					"affected_rules":     "route-2,route-1",
+					"inconsistent_rules": "route-1",
+					"rolled_back":        "false",
+				},
+			},
+		},
+	} {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			log := []string{}
+			b := &fakeBackend{prepareErr: tc.prepareErr}
+			for _, rule := range []string{"route-1", "route-2", "route-3"} {
+				b.steps = append(b.steps, &fakeStep{
+					rule:        rule,
+					commitErr:   tc.commitErrs[rule],
+					rollbackErr: tc.rbErrs[rule],
+					log:         &log,
+				})
+			}
+
+			err := NewTransactionalDriver(b).Dispatch(context.Background(), &schedpb.CreateEntryRequest{Id: "entry-1"})
+			if diff := cmp.Diff(tc.wantLog, log); diff != "" {
+				t.Errorf("unexpected steps (-want +got):\n%s", diff)
+			}
+
+			st := status.Convert(err)
+			if st.Code() != tc.wantCode {
+				t.Errorf("got status code %v, want %v (err: %v)", st.Code(), tc.wantCode, err)
+			}
+			if tc.wantInfo == nil {
+				return
+			}
+			if !errors.Is(err, tc.prepareErr) && !errors.Is(err, errBoom) {
+				t.Errorf("error %v doesn't wrap the underlying failure", err)
+			}
+			var got *errdetails.ErrorInfo
+			for _, d := range st.Details() {
+				if info, ok := d.(*errdetails.ErrorInfo); ok {
+					got = info
+				}
+			}
+			if diff := cmp.Diff(tc.wantInfo, got, protocmp.Transform()); diff != "" {
+				t.Errorf("unexpected ErrorInfo (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -17,21 +17,31 @@ package agent
 import (
 	"cmp"
 	"context"
+	"errors"
 	"fmt"
 	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/jonboulle/clockwork"
 	"github.com/rs/zerolog"
 	"golang.org/x/sync/errgroup"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
 	apipb "aalyria.com/spacetime/api/common"
 	schedpb "aalyria.com/spacetime/api/scheduling/v1alpha"
+	"aalyria.com/spacetime/agent/clocksync"
 	"aalyria.com/spacetime/agent/enactment"
+	"aalyria.com/spacetime/agent/eventlog"
 	"aalyria.com/spacetime/agent/internal/channels"
+	"aalyria.com/spacetime/agent/internal/health"
 	"aalyria.com/spacetime/agent/internal/loggable"
+	"aalyria.com/spacetime/agent/internal/schedstore"
 	"aalyria.com/spacetime/agent/internal/task"
 )
 
@@ -40,14 +50,35 @@ import (
 // applied.
 const attemptedUpdateKeepAliveTimeout = 1 * time.Minute
 
+// scheduleReconcileTimeout is how long the controller has to re-send the
+// entries restored from the schedule store after a reset. Restored entries
+// it doesn't re-send in time are assumed to have been deleted while the agent
+// was down.
+const scheduleReconcileTimeout = 1 * time.Minute
+
+// seqnoGapTimeout is how long requests can be held back waiting for a
+// missing seqno before they're rejected and the gap reported to the
+// controller.
+const seqnoGapTimeout = 30 * time.Second
+
+// dispatchLedgerRetention is how long after an entry's scheduled time its
+// dispatch is remembered across restarts.
+const dispatchLedgerRetention = 24 * time.Hour
+
+// errResyncRequested ends the scheduling stream when an operator asks for
+// the node's state to be resynchronized. The stream is re-established as if
+// it had broken, resetting the scheduling session.
+var errResyncRequested = errors.New("scheduling stream reset by an operator to resync the schedule")
+
 func OK() *status.Status { return status.New(codes.OK, "") }
 
 type enactmentService struct {
-	sc        schedpb.SchedulingClient
-	ed        enactment.Driver
-	clock     clockwork.Clock
-	initState *apipb.ControlPlaneState
-	nodeID    string
+	sc         schedpb.SchedulingClient
+	ed         enactment.Driver
+	clock      clockwork.Clock
+	clockGuard *clocksync.Guard
+	initState  *apipb.ControlPlaneState
+	nodeID     string
 
 	scheduleManipulationToken string
 	schedMgr                  *scheduleManager
@@ -55,13 +86,46 @@ type enactmentService struct {
 	rspsToController          chan *schedpb.ReceiveRequestsMessageToController
 	dispatchTimer             chan string
 	enactmentResults          chan *enactmentResult
+	seqnoGapTimer             chan struct{}
+	opsCommands               chan *opsCommand
+
+	// nextSeqno is the seqno of the next request to process. It outlives
+	// the scheduling stream, so requests the controller replays after
+	// reconnecting aren't processed twice.
+	nextSeqno uint64
+
+	// events records the service's decisions, if configured.
+	events *eventlog.Writer
+
+	// stream, backend and queueDepth feed the node's health checks.
+	stream     *health.Stream
+	backend    *health.Results
+	queueDepth atomic.Int64
+
+	// store persists the schedule across restarts, if configured.
+	store            *schedstore.Store
+	scheduleRestored bool
+	reconcileTimer   chan struct{}
+
+	// dispatchMu guards inFlight, the number of dispatches whose result
+	// hasn't been handled yet, and draining, which is set once the node is
+	// drained. No entries are dispatched while draining, and drained is
+	// closed once the dispatches in flight are done.
+	dispatchMu   sync.Mutex
+	inFlight     int
+	draining     bool
+	drained      chan struct{}
+	closeDrained func()
 }
 
 func (nc *nodeController) newEnactmentService(sc schedpb.SchedulingClient, ed enactment.Driver, manipToken string) *enactmentService {
+	drained := make(chan struct{})
 	return &enactmentService{
 		ed:                        ed,
 		sc:                        sc,
 		clock:                     nc.clock,
+		clockGuard:                nc.clockGuard,
+		events:                    nc.events,
 		nodeID:                    nc.id,
 		initState:                 nc.initState,
 		scheduleManipulationToken: manipToken,
@@ -70,6 +134,14 @@ func (nc *nodeController) newEnactmentService(sc schedpb.SchedulingClient, ed en
 		rspsToController:          make(chan *schedpb.ReceiveRequestsMessageToController),
 		dispatchTimer:             make(chan string),
 		enactmentResults:          make(chan *enactmentResult),
+		seqnoGapTimer:             make(chan struct{}),
+		opsCommands:               make(chan *opsCommand),
+		nextSeqno:                 1,
+		reconcileTimer:            make(chan struct{}),
+		stream:                    health.NewStream(nc.clock, healthStreamGrace),
+		backend:                   health.NewResults(healthResultWindow),
+		drained:                   drained,
+		closeDrained:              sync.OnceFunc(func() { close(drained) }),
 	}
 }
 
@@ -85,6 +157,44 @@ func (es *enactmentService) Stats() interface{} {
 	}
 }
 
+// opsAction is an action an operator can take on the enactment service
+// through the remote ops channel.
+type opsAction int
+
+const (
+	opsRestartBackend opsAction = iota
+	opsFlushQueue
+	opsResync
+)
+
+// opsCommand asks the main schedule loop to carry out an opsAction, so it
+// doesn't race with the loop's own use of the schedule and driver.
+type opsCommand struct {
+	action opsAction
+	result chan opsResult
+}
+
+type opsResult struct {
+	flushed []string
+	err     error
+}
+
+// do carries out `action` once the main schedule loop is ready for it.
+func (es *enactmentService) do(ctx context.Context, action opsAction) opsResult {
+	cmd := &opsCommand{action: action, result: make(chan opsResult, 1)}
+	select {
+	case <-ctx.Done():
+		return opsResult{err: status.FromContextError(ctx.Err()).Err()}
+	case es.opsCommands <- cmd:
+	}
+	select {
+	case <-ctx.Done():
+		return opsResult{err: status.FromContextError(ctx.Err()).Err()}
+	case r := <-cmd.result:
+		return r
+	}
+}
+
 type enactmentResult struct {
 	id     string
 	err    error
@@ -105,11 +215,14 @@ func newScheduleManager() *scheduleManager {
 
 type scheduleEvent struct {
 	DeletePending bool
-	timer         clockwork.Timer
-	StartTime     time.Time
-	EndTime       time.Time
-	Error         error
-	Req           *schedpb.ReceiveRequestsMessageFromController
+	// Provisional is set for entries restored from the schedule store that
+	// the controller hasn't re-sent since the agent restarted.
+	Provisional bool
+	timer       clockwork.Timer
+	StartTime   time.Time
+	EndTime     time.Time
+	Error       error
+	Req         *schedpb.ReceiveRequestsMessageFromController
 }
 
 func (sm *scheduleManager) createEntry(timer clockwork.Timer, req *schedpb.ReceiveRequestsMessageFromController) {
@@ -137,6 +250,15 @@ func (sm *scheduleManager) deleteEntry(id string) {
 	delete(sm.Entries, id)
 }
 
+func (sm *scheduleManager) hasProvisionalEntries() bool {
+	for _, entry := range sm.Entries {
+		if entry.Provisional {
+			return true
+		}
+	}
+	return false
+}
+
 func (sm *scheduleManager) finalizeEntries(upTo time.Time) {
 	// TODO:
 	//   for each entry:
@@ -160,23 +282,44 @@ func (sm *scheduleManager) recordResult(result *enactmentResult) {
 		// A DeleteEntryRequest arrived after the request had
 		// already been Dispatch()-ed.
 		sm.deleteEntry(result.id)
-		return
 	}
-	// TODO: somehow relay info to controler.
 }
 
-func (es *enactmentService) run(ctx context.Context) error {
+func (es *enactmentService) run(ctx context.Context) (err error) {
+	defer func() { es.stream.Disconnected(err) }()
+
 	g, ctx := errgroup.WithContext(ctx)
 
 	if err := es.ed.Init(ctx); err != nil {
+		es.backend.Record(err)
 		return fmt.Errorf("%T.Init() failed: %w", es.ed, err)
 	}
 
+	if es.store != nil && !es.scheduleRestored {
+		es.restoreSchedule(ctx)
+		es.scheduleRestored = true
+		if err := es.store.PruneDispatched(es.clock.Now().Add(-dispatchLedgerRetention)); err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Msg("failed to prune the dispatch ledger")
+		}
+	}
+	if es.schedMgr.hasProvisionalEntries() {
+		es.clock.AfterFunc(scheduleReconcileTimeout, func() {
+			select {
+			case <-ctx.Done():
+			case es.reconcileTimer <- struct{}{}:
+			}
+		})
+	}
+
 	schedulingRequestStream, err := es.sc.ReceiveRequests(ctx)
 	if err != nil {
 		return fmt.Errorf("error invoking the Scheduling ReceiveRequests interface: %w", err)
 	}
 
+	// The Hello is built before the main loop can process any request, so
+	// its last_seqno is where this stream resumes from.
+	hello := es.schedHello()
+
 	g.Go(channels.NewSource(es.rspsToController).ForwardTo(schedulingRequestStream.Send).
 		WithStartingStoppingLogs("toController", zerolog.TraceLevel).
 		WithLogField("task", "send").
@@ -205,8 +348,8 @@ func (es *enactmentService) run(ctx context.Context) error {
 		if _, err := es.sc.Reset(ctx, reset); err != nil {
 			return err
 		}
+		es.stream.Connected()
 
-		hello := es.schedHello()
 		zerolog.Ctx(ctx).Debug().Object("hello", loggable.Proto(hello)).Msg("sending hello")
 		select {
 		case es.rspsToController <- hello:
@@ -221,10 +364,87 @@ func (es *enactmentService) run(ctx context.Context) error {
 	return g.Wait()
 }
 
+// restoreSchedule re-creates the entries left in the schedule store by a
+// previous run of the agent, so they're enacted on time even if the
+// controller can't be reached. They stay provisional until the controller
+// re-sends them.
+func (es *enactmentService) restoreSchedule(ctx context.Context) {
+	entries, err := es.store.List()
+	if err != nil {
+		// Whatever could be read is still restored.
+		zerolog.Ctx(ctx).Error().Err(err).Msg("failed to read the persisted schedule")
+	}
+	for _, entry := range entries {
+		es.schedMgr.createEntry(es.newDispatchTimer(ctx, entry), &schedpb.ReceiveRequestsMessageFromController{
+			Request: &schedpb.ReceiveRequestsMessageFromController_CreateEntry{CreateEntry: entry},
+		})
+		es.schedMgr.Entries[entry.GetId()].Provisional = true
+		es.logEvent(ctx, eventlog.Event{Kind: eventlog.Reconciled, EntryID: entry.GetId(), Message: "restored from the schedule store"})
+	}
+	if len(entries) > 0 {
+		zerolog.Ctx(ctx).Info().Int("entries", len(entries)).Msg("restored persisted schedule")
+	}
+}
+
+// dropProvisionalEntries removes the restored entries that the controller
+// didn't re-send, unless they've already been dispatched.
+func (es *enactmentService) dropProvisionalEntries(ctx context.Context) {
+	dropped := 0
+	for id, entry := range es.schedMgr.Entries {
+		if !entry.Provisional || !entry.StartTime.IsZero() {
+			continue
+		}
+		entry.timer.Stop()
+		delete(es.schedMgr.Entries, id)
+		es.forgetEntry(ctx, id)
+		es.logEvent(ctx, eventlog.Event{Kind: eventlog.Reconciled, EntryID: id, Message: "dropped restored entry the controller didn't re-send"})
+		dropped++
+	}
+	if dropped > 0 {
+		zerolog.Ctx(ctx).Info().Int("entries", dropped).Msg("dropped restored entries the controller didn't re-send")
+	}
+}
+
+func (es *enactmentService) persistEntry(ctx context.Context, entry *schedpb.CreateEntryRequest) {
+	if es.store == nil {
+		return
+	}
+	if err := es.store.Put(entry); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Str("entry ID", entry.GetId()).Msg("failed to persist schedule entry")
+	}
+}
+
+func (es *enactmentService) forgetEntry(ctx context.Context, id string) {
+	if es.store == nil {
+		return
+	}
+	if err := es.store.Delete(id); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Str("entry ID", id).Msg("failed to remove persisted schedule entry")
+	}
+}
+
+func (es *enactmentService) newDispatchTimer(ctx context.Context, entry *schedpb.CreateEntryRequest) clockwork.Timer {
+	id := entry.GetId()
+	delay := entry.GetTime().AsTime().Sub(es.clock.Now())
+	if es.clockGuard != nil {
+		// A local clock that's ahead of the reference reaches the entry's
+		// time early, so wait that much longer.
+		delay += es.clockGuard.Offset()
+	}
+	return es.clock.AfterFunc(delay, func() {
+		select {
+		case <-ctx.Done():
+			return
+		case es.dispatchTimer <- id:
+		}
+	})
+}
+
 func (es *enactmentService) schedHello() *schedpb.ReceiveRequestsMessageToController {
 	return &schedpb.ReceiveRequestsMessageToController{
 		Hello: &schedpb.ReceiveRequestsMessageToController_Hello{
-			AgentId: es.nodeID,
+			AgentId:   es.nodeID,
+			LastSeqno: es.nextSeqno - 1,
 		},
 	}
 }
@@ -237,22 +457,38 @@ func (es *enactmentService) schedReset() *schedpb.ResetRequest {
 }
 
 func (es *enactmentService) mainScheduleLoop(ctx context.Context) error {
-	var nextSeqno uint64 = 1
+	// Requests held back by a gap in the seqnos. The controller replays them
+	// if the stream breaks, so they don't need to outlive it.
 	pendingRequests := []*schedpb.ReceiveRequestsMessageFromController{}
+	var gapSince time.Time
+	var gapTimer clockwork.Timer
+	defer func() {
+		if gapTimer != nil {
+			gapTimer.Stop()
+		}
+	}()
+	// The number of dispatches awaiting their result.
+	dispatching := 0
 
 	for {
+		es.queueDepth.Store(int64(len(pendingRequests) + dispatching))
+
 		select {
 		// [0] Handle context shutdown.
 		case <-ctx.Done():
 			return context.Cause(ctx)
 		// [1] Process requests from the controller.
 		case req := <-es.reqsFromController:
+			es.logEvent(ctx, requestEvent(eventlog.Received, req, nil))
+
 			// Check for correct schedule manipulation token, if present.
 			token, ok := getScheduleManipulationToken(req)
 			if ok && token != es.scheduleManipulationToken {
-				err := es.sendResponse(ctx, req.RequestId, status.Newf(
+				st := status.Newf(
 					codes.FailedPrecondition,
-					"received schedule manipulation token %q is not current (%s)", token, es.scheduleManipulationToken))
+					"received schedule manipulation token %q is not current (%s)", token, es.scheduleManipulationToken)
+				es.logEvent(ctx, requestEvent(eventlog.Rejected, req, st))
+				err := es.sendResponse(ctx, req.RequestId, st)
 				if err != nil {
 					return err
 				}
@@ -263,9 +499,11 @@ func (es *enactmentService) mainScheduleLoop(ctx context.Context) error {
 			_, ok = getSeqno(req)
 			if !ok {
 				// Presently, all requests supported here have a sequence number.
-				err := es.sendResponse(ctx, req.RequestId, status.Newf(
+				st := status.Newf(
 					codes.Unimplemented,
-					"unable to handle request %q which has no seqno", req.RequestId))
+					"unable to handle request %q which has no seqno", req.RequestId)
+				es.logEvent(ctx, requestEvent(eventlog.Rejected, req, st))
+				err := es.sendResponse(ctx, req.RequestId, st)
 				if err != nil {
 					return err
 				}
@@ -278,28 +516,56 @@ func (es *enactmentService) mainScheduleLoop(ctx context.Context) error {
 				return cmp.Compare(mustSeqno(l), mustSeqno(r))
 			})
 
-			// Process any old requests followed by all next expected requests.
-			// Any requests sequenced after the next expected are blocked until
-			// missing requests arrive.
+			// Acknowledge any replayed requests followed by processing all
+			// next expected requests. Any requests sequenced after the next
+			// expected are blocked until missing requests arrive.
 			for len(pendingRequests) > 0 {
 				req = pendingRequests[0]
 				seqno := mustSeqno(req)
-				if seqno > nextSeqno {
+				if seqno > es.nextSeqno {
 					zerolog.Ctx(ctx).Info().
 						Uint64("seqno.this", seqno).
-						Uint64("seqno.nextExpected", nextSeqno).
+						Uint64("seqno.nextExpected", es.nextSeqno).
 						Msg("seqno greater than next expected; delaying scheduling")
 					break
 				}
-				status := es.handleSchedulingRequest(ctx, req)
+				status := OK()
+				if seqno == es.nextSeqno {
+					status = es.handleSchedulingRequest(ctx, req)
+					es.nextSeqno++
+					if status.Code() == codes.OK {
+						es.logEvent(ctx, requestEvent(eventlog.Validated, req, nil))
+					} else {
+						es.logEvent(ctx, requestEvent(eventlog.Rejected, req, status))
+					}
+				} else {
+					zerolog.Ctx(ctx).Debug().
+						Uint64("seqno.this", seqno).
+						Uint64("seqno.nextExpected", es.nextSeqno).
+						Msg("seqno already processed; acknowledging replayed request")
+					ev := requestEvent(eventlog.Validated, req, nil)
+					ev.Message = "replay of an already processed seqno; acknowledged without being processed again"
+					es.logEvent(ctx, ev)
+				}
 				err := es.sendResponse(ctx, req.RequestId, status)
 				if err != nil {
 					return err
 				}
 				pendingRequests = pendingRequests[1:]
-				if seqno == nextSeqno {
-					nextSeqno++
-				}
+			}
+
+			switch {
+			case len(pendingRequests) == 0 && gapTimer != nil:
+				gapTimer.Stop()
+				gapTimer = nil
+			case len(pendingRequests) > 0 && gapTimer == nil:
+				gapSince = es.clock.Now()
+				gapTimer = es.clock.AfterFunc(seqnoGapTimeout, func() {
+					select {
+					case <-ctx.Done():
+					case es.seqnoGapTimer <- struct{}{}:
+					}
+				})
 			}
 		// [2] Handle timer firing to launch a call to the backend.
 		case id := <-es.dispatchTimer:
@@ -316,7 +582,34 @@ func (es *enactmentService) mainScheduleLoop(ctx context.Context) error {
 				zerolog.Ctx(ctx).Warn().Object("req", loggable.Proto(entry.Req)).Msg("Dispatch already completed")
 				continue
 			}
+			if !es.startDispatch() {
+				// The entry stays in the schedule store, if there is one, so
+				// it's restored once the agent restarts.
+				zerolog.Ctx(ctx).Warn().Str("entry ID", id).Msg("node is draining; not dispatching entry")
+				continue
+			}
 			entry.StartTime = es.clock.Now()
+			if es.alreadyDispatched(ctx, id) {
+				zerolog.Ctx(ctx).Info().Str("entry ID", id).Msg("entry was dispatched before the agent restarted; not enacting it again")
+				es.logEvent(ctx, eventlog.Event{Kind: eventlog.Reconciled, EntryID: id, Message: "dispatched before the agent restarted; not enacting it again"})
+				es.schedMgr.recordResult(&enactmentResult{id: id, tStamp: entry.StartTime})
+				es.forgetEntry(ctx, id)
+				es.finishDispatch()
+				continue
+			}
+			if err := es.checkClock(entry.Req.GetCreateEntry()); err != nil {
+				zerolog.Ctx(ctx).Error().Err(err).Object("req", loggable.Proto(entry.Req)).Msg("refusing to dispatch time-critical change")
+				es.schedMgr.recordResult(&enactmentResult{id: id, err: err, tStamp: entry.StartTime})
+				es.forgetEntry(ctx, id)
+				es.logResult(ctx, id, err)
+				es.finishDispatch()
+				if err := es.reportFailure(ctx, entry, err); err != nil {
+					return err
+				}
+				continue
+			}
+			es.markDispatched(ctx, entry.Req.GetCreateEntry())
+			dispatching++
 			go func() {
 				result := &enactmentResult{
 					id: id,
@@ -326,7 +619,9 @@ func (es *enactmentService) mainScheduleLoop(ctx context.Context) error {
 
 				select {
 				case <-ctx.Done():
-					return
+					// The stream broke, so there's no one to report the
+					// result to.
+					es.finishDispatch()
 				case es.enactmentResults <- result:
 				}
 			}()
@@ -337,9 +632,285 @@ func (es *enactmentService) mainScheduleLoop(ctx context.Context) error {
 				AnErr("result.err", result.err).
 				Time("result.tStamp", result.tStamp).
 				Msg("recv'd Dispatch result")
+			dispatching--
+			es.backend.Record(result.err)
+			entry, ok := es.schedMgr.Entries[result.id]
 			es.schedMgr.recordResult(result)
+			es.forgetEntry(ctx, result.id)
+			es.logResult(ctx, result.id, result.err)
+			var reportErr error
+			if ok && result.err != nil {
+				reportErr = es.reportFailure(ctx, entry, result.err)
+			}
+			es.finishDispatch()
+			if reportErr != nil {
+				return reportErr
+			}
+		// [4] Drop restored entries the controller didn't re-send.
+		case <-es.reconcileTimer:
+			es.dropProvisionalEntries(ctx)
+		// [5] Give up on a gap in the seqnos that the controller hasn't
+		// filled.
+		case <-es.seqnoGapTimer:
+			if len(pendingRequests) == 0 || es.clock.Since(gapSince) < seqnoGapTimeout {
+				// The gap was filled, or this is a stale timer for a gap
+				// that was filled before another one opened.
+				continue
+			}
+			gapTimer = nil
+			zerolog.Ctx(ctx).Warn().
+				Uint64("seqno.nextExpected", es.nextSeqno).
+				Int("requests", len(pendingRequests)).
+				Msg("seqno gap wasn't filled in time; rejecting held back requests")
+			for _, req := range pendingRequests {
+				st := es.seqnoGapStatus(mustSeqno(req))
+				es.logEvent(ctx, requestEvent(eventlog.Rejected, req, st))
+				if err := es.sendResponse(ctx, req.RequestId, st); err != nil {
+					return err
+				}
+			}
+			pendingRequests = pendingRequests[:0]
+		// [6] Carry out an operator's action.
+		case cmd := <-es.opsCommands:
+			switch cmd.action {
+			case opsRestartBackend:
+				cmd.result <- opsResult{err: es.restartBackend(ctx, dispatching)}
+			case opsFlushQueue:
+				cmd.result <- opsResult{flushed: es.flushQueue(ctx)}
+			case opsResync:
+				cmd.result <- opsResult{}
+				return errResyncRequested
+			}
+		}
+	}
+}
+
+// startDispatch records that an entry is being dispatched, unless the node
+// is draining, in which case it returns false.
+func (es *enactmentService) startDispatch() bool {
+	es.dispatchMu.Lock()
+	defer es.dispatchMu.Unlock()
+	if es.draining {
+		return false
+	}
+	es.inFlight++
+	return true
+}
+
+// finishDispatch records that the result of a dispatch has been handled.
+func (es *enactmentService) finishDispatch() {
+	es.dispatchMu.Lock()
+	defer es.dispatchMu.Unlock()
+	es.inFlight--
+	if es.draining && es.inFlight == 0 {
+		es.closeDrained()
+	}
+}
+
+// drain stops dispatching entries and waits until the results of those
+// already dispatched have been handled: recorded, and reported to the
+// controller if they failed. Entries that come due in the meantime aren't
+// dispatched.
+func (es *enactmentService) drain(ctx context.Context) error {
+	es.dispatchMu.Lock()
+	es.draining = true
+	inFlight := es.inFlight
+	if inFlight == 0 {
+		es.closeDrained()
+	}
+	es.dispatchMu.Unlock()
+
+	zerolog.Ctx(ctx).Info().Int("inFlight", inFlight).Msg("draining enactments")
+	select {
+	case <-es.drained:
+		return nil
+	case <-ctx.Done():
+		es.dispatchMu.Lock()
+		inFlight = es.inFlight
+		es.dispatchMu.Unlock()
+		return fmt.Errorf("%d enactments still in flight: %w", inFlight, context.Cause(ctx))
+	}
+}
+
+// restartBackend closes and re-initializes the driver, unless any of its
+// `dispatching` enactments are still running.
+func (es *enactmentService) restartBackend(ctx context.Context, dispatching int) error {
+	if dispatching > 0 {
+		return status.Errorf(codes.FailedPrecondition, "%d change(s) are being enacted; try again once they've completed", dispatching)
+	}
+	if err := es.ed.Close(); err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to close the enactment backend; re-initializing it anyway")
+	}
+	if err := es.ed.Init(ctx); err != nil {
+		es.backend.Record(err)
+		return status.Errorf(codes.Unavailable, "%T.Init() failed: %v", es.ed, err)
+	}
+	return nil
+}
+
+// flushQueue discards the entries that haven't been dispatched yet and
+// returns their IDs.
+func (es *enactmentService) flushQueue(ctx context.Context) []string {
+	flushed := []string{}
+	for id, entry := range es.schedMgr.Entries {
+		if !entry.StartTime.IsZero() {
+			continue
 		}
+		entry.timer.Stop()
+		delete(es.schedMgr.Entries, id)
+		es.forgetEntry(ctx, id)
+		es.logEvent(ctx, eventlog.Event{Kind: eventlog.Reconciled, EntryID: id, Message: "flushed by an operator"})
+		flushed = append(flushed, id)
+	}
+	slices.Sort(flushed)
+	return flushed
+}
+
+// logEvent appends `e` to the event log, if one is configured, filling in
+// the time and node.
+func (es *enactmentService) logEvent(ctx context.Context, e eventlog.Event) {
+	if es.events == nil {
+		return
 	}
+	e.Time = es.clock.Now().UTC()
+	e.Node = es.nodeID
+	if err := es.events.Append(e); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Str("kind", string(e.Kind)).Msg("failed to write to the event log")
+	}
+}
+
+// requestEvent describes `req`, along with its rejection status if any.
+func requestEvent(kind eventlog.Kind, req *schedpb.ReceiveRequestsMessageFromController, st *status.Status) eventlog.Event {
+	e := eventlog.Event{Kind: kind, RequestID: req.GetRequestId()}
+	e.EntryID, _ = getId(req)
+	e.Seqno, _ = getSeqno(req)
+	if oneof := req.ProtoReflect().WhichOneof(req.ProtoReflect().Descriptor().Oneofs().ByName("request")); oneof != nil {
+		e.Request = string(oneof.Name())
+	}
+	if st != nil {
+		e.Code, e.Message = st.Code().String(), st.Message()
+	}
+	return e
+}
+
+// logResult records the outcome of dispatching the entry with the provided
+// ID, including any rollback performed by an [enactment.TransactionalDriver].
+func (es *enactmentService) logResult(ctx context.Context, id string, err error) {
+	if err == nil {
+		es.logEvent(ctx, eventlog.Event{Kind: eventlog.Enacted, EntryID: id})
+		return
+	}
+
+	st := status.Convert(err)
+	failed := eventlog.Event{Kind: eventlog.Failed, EntryID: id, Code: st.Code().String(), Message: st.Message()}
+	f := &enactment.Failure{}
+	if !errors.As(err, &f) {
+		es.logEvent(ctx, failed)
+		return
+	}
+	if f.FailedRule != "" {
+		failed.Rules = []string{f.FailedRule}
+	}
+	es.logEvent(ctx, failed)
+	if len(f.AffectedRules) == 0 {
+		return
+	}
+
+	rolledBack := eventlog.Event{Kind: eventlog.RolledBack, EntryID: id}
+	for _, rule := range f.AffectedRules {
+		if !slices.Contains(f.Inconsistent, rule) {
+			rolledBack.Rules = append(rolledBack.Rules, rule)
+		}
+	}
+	if len(f.Inconsistent) > 0 {
+		rolledBack.Code = codes.Internal.String()
+		rolledBack.Message = fmt.Sprintf("failed to roll back %s: %v", strings.Join(f.Inconsistent, ", "), f.RollbackErr)
+	}
+	es.logEvent(ctx, rolledBack)
+}
+
+// seqnoGapStatus is the status of a request with the provided seqno that
+// was rejected because the requests before it never arrived. The controller
+// has to re-send every request from the next expected seqno onwards.
+func (es *enactmentService) seqnoGapStatus(seqno uint64) *status.Status {
+	st := status.Newf(codes.FailedPrecondition,
+		"seqno %d was held back for %s waiting for seqno %d, which never arrived", seqno, seqnoGapTimeout, es.nextSeqno)
+	detailed, err := st.WithDetails(&errdetails.ErrorInfo{
+		Reason: "SEQNO_GAP",
+		Domain: enactment.FailureDomain,
+		Metadata: map[string]string{
+			"expected_seqno": strconv.FormatUint(es.nextSeqno, 10),
+			"received_seqno": strconv.FormatUint(seqno, 10),
+		},
+	})
+	if err != nil {
+		return st
+	}
+	return detailed
+}
+
+// alreadyDispatched reports whether the dispatch ledger shows that the
+// entry with the provided ID was dispatched by a previous run of the agent.
+func (es *enactmentService) alreadyDispatched(ctx context.Context, id string) bool {
+	if es.store == nil {
+		return false
+	}
+	dispatched, err := es.store.Dispatched(id)
+	if err != nil {
+		// Better to risk enacting the entry twice than to never enact it.
+		zerolog.Ctx(ctx).Error().Err(err).Str("entry ID", id).Msg("failed to read the dispatch ledger")
+	}
+	return dispatched
+}
+
+func (es *enactmentService) markDispatched(ctx context.Context, entry *schedpb.CreateEntryRequest) {
+	if es.store == nil {
+		return
+	}
+	if err := es.store.MarkDispatched(entry); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Str("entry ID", entry.GetId()).Msg("failed to record the dispatch of a schedule entry")
+	}
+}
+
+// checkClock returns an error if `entry` is time-critical and the clock
+// guard reports that the local clock can't be trusted to enact it.
+func (es *enactmentService) checkClock(entry *schedpb.CreateEntryRequest) error {
+	if es.clockGuard == nil || !isTimeCritical(entry) {
+		return nil
+	}
+	if err := es.clockGuard.Check(); err != nil {
+		return status.Errorf(codes.FailedPrecondition, "not enacting time-critical change: %v", err)
+	}
+	return nil
+}
+
+// isTimeCritical reports whether `entry` has to be enacted at precisely its
+// scheduled time to be correct. Beams that are steered at the wrong time
+// point away from their targets, whereas routing changes only need to be
+// roughly coordinated.
+func isTimeCritical(entry *schedpb.CreateEntryRequest) bool {
+	switch entry.GetConfigurationChange().(type) {
+	case *schedpb.CreateEntryRequest_SetBeam,
+		*schedpb.CreateEntryRequest_DeleteBeam,
+		*schedpb.CreateEntryRequest_SetBeamHoppingPlan,
+		*schedpb.CreateEntryRequest_DeleteBeamHoppingPlan:
+		return true
+	default:
+		return false
+	}
+}
+
+// reportFailure relays the failure to enact `entry` to the controller, as a
+// second response to the request that created it. The SBI has no dedicated
+// message for this, but the status carries the details provided by the
+// driver, such as those of an [enactment.Failure]. Entries restored from the
+// schedule store weren't created by a request in this session, so their
+// failures are only logged.
+func (es *enactmentService) reportFailure(ctx context.Context, entry *scheduleEvent, err error) error {
+	if entry.Provisional {
+		return nil
+	}
+	return es.sendResponse(ctx, entry.Req.GetRequestId(), status.Convert(err))
 }
 
 func (es *enactmentService) sendResponse(ctx context.Context, id int64, status *status.Status) error {
@@ -363,24 +934,31 @@ func (es *enactmentService) handleSchedulingRequest(ctx context.Context, req *sc
 	switch req.Request.(type) {
 	case *schedpb.ReceiveRequestsMessageFromController_CreateEntry:
 		id := req.GetCreateEntry().Id
-		if _, ok := es.schedMgr.Entries[id]; ok {
+		if entry, ok := es.schedMgr.Entries[id]; ok {
+			if entry.Provisional {
+				// The controller re-sent a restored entry, so adopt its
+				// current version unless it's already been dispatched.
+				entry.Provisional = false
+				es.logEvent(ctx, eventlog.Event{Kind: eventlog.Reconciled, EntryID: id, Message: "controller re-sent restored entry"})
+				if entry.StartTime.IsZero() {
+					entry.timer.Stop()
+					entry.timer = es.newDispatchTimer(ctx, req.GetCreateEntry())
+					entry.Req = req
+					es.persistEntry(ctx, req.GetCreateEntry())
+				}
+				return OK()
+			}
 			// Ignore repeated scheduling of the same entry ID.
 			zerolog.Ctx(ctx).Debug().Str("entry ID", id).Msg("ignoring scheduling attempt of duplicate entry")
 			return OK()
 		}
-		delay := req.GetCreateEntry().Time.AsTime().Sub(es.clock.Now())
-		timer := es.clock.AfterFunc(delay, func() {
-			select {
-			case <-ctx.Done():
-				return
-			case es.dispatchTimer <- id:
-			}
-		})
-		es.schedMgr.createEntry(timer, req)
+		es.schedMgr.createEntry(es.newDispatchTimer(ctx, req.GetCreateEntry()), req)
+		es.persistEntry(ctx, req.GetCreateEntry())
 		return OK()
 
 	case *schedpb.ReceiveRequestsMessageFromController_DeleteEntry:
 		es.schedMgr.deleteEntry(req.GetDeleteEntry().Id)
+		es.forgetEntry(ctx, req.GetDeleteEntry().Id)
 		return OK()
 
 	case *schedpb.ReceiveRequestsMessageFromController_Finalize:
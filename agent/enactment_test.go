@@ -19,6 +19,7 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"path/filepath"
 	"sync"
 	"testing"
 	"time"
@@ -26,15 +27,23 @@ import (
 	afpb "aalyria.com/spacetime/api/cdpi/v1alpha"
 	apipb "aalyria.com/spacetime/api/common"
 	schedpb "aalyria.com/spacetime/api/scheduling/v1alpha"
+	"aalyria.com/spacetime/agent/clocksync"
+	"aalyria.com/spacetime/agent/enactment"
+	"aalyria.com/spacetime/agent/eventlog"
 	"aalyria.com/spacetime/agent/internal/channels"
+	"aalyria.com/spacetime/agent/internal/schedstore"
 	"aalyria.com/spacetime/agent/internal/task"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/jonboulle/clockwork"
 	"github.com/rs/zerolog"
 	"golang.org/x/sync/errgroup"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/testing/protocmp"
 	"google.golang.org/protobuf/types/known/emptypb"
@@ -146,6 +155,505 @@ func (tc *testCase) runTest(t *testing.T) {
 	tc.test(ctx, &testFixture{t: t, srv: srv, eb: enact, clock: clock})
 }
 
+func TestEnactments_restoresPersistedSchedule(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(baseContext(t))
+	g, ctx := errgroup.WithContext(ctx)
+	defer func() {
+		cancel()
+		checkErrIsDueToCanceledContext(t, g.Wait())
+	}()
+
+	// Simulate the schedule left behind by a previous run of the agent.
+	dir := t.TempDir()
+	store, err := schedstore.New(dir)
+	check(t, err)
+	soon := &schedpb.CreateEntryRequest{
+		Id:   "soon",
+		Time: timestamppb.New(startTime.Add(10 * time.Second)),
+		ConfigurationChange: &schedpb.CreateEntryRequest_DeleteRoute{
+			DeleteRoute: &schedpb.DeleteRoute{To: "2001:db8:1::/48"},
+		},
+	}
+	stale := &schedpb.CreateEntryRequest{
+		Id:   "stale",
+		Time: timestamppb.New(startTime.Add(scheduleReconcileTimeout + time.Minute)),
+		ConfigurationChange: &schedpb.CreateEntryRequest_DeleteRoute{
+			DeleteRoute: &schedpb.DeleteRoute{To: "2001:db8:2::/48"},
+		},
+	}
+	check(t, store.Put(soon))
+	check(t, store.Put(stale))
+
+	enact := newDelegatingBackend()
+	defer enact.checkNoUnhandledUpdates(t)
+
+	clock := clockwork.NewFakeClockAt(startTime)
+	srv := newServer(
+		zerolog.Ctx(ctx).With().Str("role", "test server").Logger().WithContext(ctx), []testNode{{id: "node-a"}})
+	srvAddr := srv.start(ctx, t, g)
+
+	agent := newAgent(t, WithClock(clock), WithNode("node-a",
+		WithEnactmentDriver(srvAddr, enact, grpc.WithTransportCredentials(insecure.NewCredentials())),
+		WithScheduleStateDir(dir)))
+	g.Go(func() error { return agent.Run(ctx) })
+	f := &testFixture{t: t, srv: srv, eb: enact, clock: clock}
+
+	f.expectSchedulingReset(ctx, "node-a")
+	f.expectSchedulingHello(ctx, "node-a")
+
+	// Restored entries are enacted without the controller re-sending them.
+	f.advanceClock(ctx, 10*time.Second)
+	assertProtosEqual(t, soon, f.waitForDispatchRequestFromController(ctx))
+
+	// Once the controller has had a chance to re-send the schedule, the
+	// entries it didn't re-send are dropped, and enacted entries are
+	// forgotten.
+	f.advanceClock(ctx, scheduleReconcileTimeout)
+	deadline := time.After(10 * time.Second)
+	for {
+		entries, err := store.List()
+		check(t, err)
+		if len(entries) == 0 {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatalf("persisted schedule still contains %v", entries)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestEnactments_sharedConnection(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(baseContext(t))
+	g, ctx := errgroup.WithContext(ctx)
+	defer func() {
+		cancel()
+		checkErrIsDueToCanceledContext(t, g.Wait())
+	}()
+
+	enact := newDelegatingBackend()
+	defer enact.checkNoUnhandledUpdates(t)
+
+	clock := clockwork.NewFakeClockAt(startTime)
+	nodes := []testNode{{id: "node-a"}, {id: "node-b"}, {id: "node-c"}}
+	srv := newServer(
+		zerolog.Ctx(ctx).With().Str("role", "test server").Logger().WithContext(ctx), nodes)
+	srvAddr := srv.start(ctx, t, g)
+
+	opts := []AgentOption{WithClock(clock), WithSharedConnection("sbi", srvAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))}
+	for _, n := range nodes {
+		opts = append(opts, WithNode(n.id, WithSharedEnactmentDriver("sbi", enact)))
+	}
+	agent := newAgent(t, opts...)
+	g.Go(func() error { return agent.Run(ctx) })
+	f := &testFixture{t: t, srv: srv, eb: enact, clock: clock}
+
+	for _, n := range nodes {
+		f.expectSchedulingReset(ctx, n.id)
+		f.expectSchedulingHello(ctx, n.id)
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	peers := map[string]bool{}
+	for _, p := range srv.peers {
+		peers[p] = true
+	}
+	if len(srv.peers) != len(nodes) || len(peers) != 1 {
+		t.Errorf("nodes connected from %v, want a single shared connection", srv.peers)
+	}
+}
+
+func TestEnactments_neverReenactsDispatchedEntries(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(baseContext(t))
+	g, ctx := errgroup.WithContext(ctx)
+	defer func() {
+		cancel()
+		checkErrIsDueToCanceledContext(t, g.Wait())
+	}()
+
+	// Simulate a previous run of the agent that crashed while dispatching an
+	// entry.
+	dir := t.TempDir()
+	store, err := schedstore.New(dir)
+	check(t, err)
+	dispatched := &schedpb.CreateEntryRequest{
+		Id:   "dispatched",
+		Time: timestamppb.New(startTime.Add(10 * time.Second)),
+		ConfigurationChange: &schedpb.CreateEntryRequest_DeleteRoute{
+			DeleteRoute: &schedpb.DeleteRoute{To: "2001:db8:1::/48"},
+		},
+	}
+	check(t, store.Put(dispatched))
+	check(t, store.MarkDispatched(dispatched))
+
+	enact := newDelegatingBackend()
+	defer enact.checkNoUnhandledUpdates(t)
+
+	clock := clockwork.NewFakeClockAt(startTime)
+	srv := newServer(
+		zerolog.Ctx(ctx).With().Str("role", "test server").Logger().WithContext(ctx), []testNode{{id: "node-a"}})
+	srvAddr := srv.start(ctx, t, g)
+
+	agent := newAgent(t, WithClock(clock), WithNode("node-a",
+		WithEnactmentDriver(srvAddr, enact, grpc.WithTransportCredentials(insecure.NewCredentials())),
+		WithScheduleStateDir(dir)))
+	g.Go(func() error { return agent.Run(ctx) })
+	f := &testFixture{t: t, srv: srv, eb: enact, clock: clock}
+
+	token := f.expectSchedulingReset(ctx, "node-a")
+	f.expectSchedulingHello(ctx, "node-a")
+
+	// The controller re-sends the entry after the reset.
+	resent := proto.Clone(dispatched).(*schedpb.CreateEntryRequest)
+	resent.ScheduleManipulationToken = token
+	resent.Seqno = 1
+	f.sendSchedulingRequest(ctx, "node-a", &schedpb.ReceiveRequestsMessageFromController{
+		RequestId: 1,
+		Request:   &schedpb.ReceiveRequestsMessageFromController_CreateEntry{CreateEntry: resent},
+	})
+	if _, err := srv.RecvFromNode(ctx, "node-a"); err != nil {
+		t.Fatalf("RecvFromNode: %v", err)
+	}
+
+	f.advanceClock(ctx, 10*time.Second)
+	select {
+	case req := <-enact.reqs:
+		t.Errorf("entry %v was enacted again", req)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestEnactments_reportsSeqnoGaps(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(baseContext(t))
+	g, ctx := errgroup.WithContext(ctx)
+	defer func() {
+		cancel()
+		checkErrIsDueToCanceledContext(t, g.Wait())
+	}()
+
+	enact := newDelegatingBackend()
+	defer enact.checkNoUnhandledUpdates(t)
+
+	clock := clockwork.NewFakeClockAt(startTime)
+	srv := newServer(
+		zerolog.Ctx(ctx).With().Str("role", "test server").Logger().WithContext(ctx), []testNode{{id: "node-a"}})
+	srvAddr := srv.start(ctx, t, g)
+
+	agent := newAgent(t, WithClock(clock), WithNode("node-a",
+		WithEnactmentDriver(srvAddr, enact, grpc.WithTransportCredentials(insecure.NewCredentials()))))
+	g.Go(func() error { return agent.Run(ctx) })
+	f := &testFixture{t: t, srv: srv, eb: enact, clock: clock}
+
+	token := f.expectSchedulingReset(ctx, "node-a")
+	f.expectSchedulingHello(ctx, "node-a")
+
+	deleteEntry := func(requestID int64, seqno uint64) *schedpb.ReceiveRequestsMessageFromController {
+		return &schedpb.ReceiveRequestsMessageFromController{
+			RequestId: requestID,
+			Request: &schedpb.ReceiveRequestsMessageFromController_DeleteEntry{DeleteEntry: &schedpb.DeleteEntryRequest{
+				ScheduleManipulationToken: token,
+				Seqno:                     seqno,
+				Id:                        "no-such-entry",
+			}},
+		}
+	}
+	recv := func() *schedpb.ReceiveRequestsMessageToController_Response {
+		msg, err := srv.RecvFromNode(ctx, "node-a")
+		if err != nil {
+			t.Fatalf("RecvFromNode: %v", err)
+		}
+		return msg.GetResponse()
+	}
+
+	f.sendSchedulingRequest(ctx, "node-a", deleteEntry(1, 1))
+	if rsp := recv(); rsp.GetStatus().GetCode() != int32(codes.OK) {
+		t.Fatalf("unexpected response to seqno 1: %v", rsp)
+	}
+
+	// Replays of processed requests are acknowledged.
+	f.sendSchedulingRequest(ctx, "node-a", deleteEntry(2, 1))
+	if rsp := recv(); rsp.GetRequestId() != 2 || rsp.GetStatus().GetCode() != int32(codes.OK) {
+		t.Fatalf("unexpected response to replayed seqno 1: %v", rsp)
+	}
+
+	// Seqno 2 never arrives, so seqno 3 is rejected once the agent gives up
+	// waiting for it.
+	f.sendSchedulingRequest(ctx, "node-a", deleteEntry(3, 3))
+	// Requests are handled in order, so once another replay is acknowledged
+	// seqno 3 is being held back.
+	f.sendSchedulingRequest(ctx, "node-a", deleteEntry(4, 1))
+	if rsp := recv(); rsp.GetRequestId() != 4 || rsp.GetStatus().GetCode() != int32(codes.OK) {
+		t.Fatalf("unexpected response to replayed seqno 1: %v", rsp)
+	}
+	f.advanceClock(ctx, seqnoGapTimeout)
+
+	rsp := recv()
+	if rsp.GetRequestId() != 3 || rsp.GetStatus().GetCode() != int32(codes.FailedPrecondition) {
+		t.Fatalf("unexpected response to seqno 3: %v", rsp)
+	}
+	st := status.FromProto(rsp.GetStatus())
+	var info *errdetails.ErrorInfo
+	for _, d := range st.Details() {
+		if i, ok := d.(*errdetails.ErrorInfo); ok {
+			info = i
+		}
+	}
+	if info.GetReason() != "SEQNO_GAP" || info.GetMetadata()["expected_seqno"] != "2" {
+		t.Errorf("unexpected gap details: %v", info)
+	}
+}
+
+func TestEnactments_clockGuardRefusesTimeCriticalChanges(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(baseContext(t))
+	g, ctx := errgroup.WithContext(ctx)
+	defer func() {
+		cancel()
+		checkErrIsDueToCanceledContext(t, g.Wait())
+	}()
+
+	enact := newDelegatingBackend()
+	defer enact.checkNoUnhandledUpdates(t)
+
+	clock := clockwork.NewFakeClockAt(startTime)
+	guard := clocksync.NewGuard(clocksync.GuardConfig{
+		Source: clocksync.OffsetSourceFunc(func(context.Context) (time.Duration, error) {
+			return time.Second, nil
+		}),
+		MaxSkew: 100 * time.Millisecond,
+		Clock:   clock,
+	})
+	srv := newServer(
+		zerolog.Ctx(ctx).With().Str("role", "test server").Logger().WithContext(ctx), []testNode{{id: "node-a"}})
+	srvAddr := srv.start(ctx, t, g)
+
+	agent := newAgent(t, WithClock(clock), WithClockGuard(guard), WithNode("node-a",
+		WithEnactmentDriver(srvAddr, enact, grpc.WithTransportCredentials(insecure.NewCredentials()))))
+	g.Go(func() error { return agent.Run(ctx) })
+	f := &testFixture{t: t, srv: srv, eb: enact, clock: clock}
+
+	token := f.expectSchedulingReset(ctx, "node-a")
+	f.expectSchedulingHello(ctx, "node-a")
+	for guard.Check() == nil {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	setBeam := &schedpb.CreateEntryRequest{
+		ScheduleManipulationToken: token,
+		Seqno:                     1,
+		Id:                        "set-beam",
+		Time:                      timestamppb.New(startTime),
+		ConfigurationChange:       &schedpb.CreateEntryRequest_SetBeam{SetBeam: &schedpb.SetBeam{Id: "beam-1"}},
+	}
+	setRoute := &schedpb.CreateEntryRequest{
+		ScheduleManipulationToken: token,
+		Seqno:                     2,
+		Id:                        "set-route",
+		Time:                      timestamppb.New(startTime),
+		ConfigurationChange: &schedpb.CreateEntryRequest_SetRoute{
+			SetRoute: &schedpb.SetRoute{To: "2001:db8:1::/48", Dev: "eth0"},
+		},
+	}
+	for i, entry := range []*schedpb.CreateEntryRequest{setBeam, setRoute} {
+		f.sendSchedulingRequest(ctx, "node-a", &schedpb.ReceiveRequestsMessageFromController{
+			RequestId: int64(i),
+			Request:   &schedpb.ReceiveRequestsMessageFromController_CreateEntry{CreateEntry: entry},
+		})
+	}
+
+	// The skew is compensated for, so nothing is due until the local clock
+	// reaches the entries' time plus the offset.
+	f.advanceClock(ctx, time.Second)
+
+	// Only the route, which isn't time-critical, is enacted.
+	assertProtosEqual(t, setRoute, f.waitForDispatchRequestFromController(ctx))
+	select {
+	case req := <-enact.reqs:
+		t.Errorf("unexpected dispatch of time-critical change %v", req)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// failingDriver fails every dispatch with `err`.
+type failingDriver struct{ err error }
+
+func (d *failingDriver) Init(context.Context) error { return nil }
+func (d *failingDriver) Close() error               { return nil }
+func (d *failingDriver) Stats() any                 { return nil }
+
+func (d *failingDriver) Dispatch(context.Context, *schedpb.CreateEntryRequest) error { return d.err }
+
+func TestEnactments_reportsDispatchFailures(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(baseContext(t))
+	g, ctx := errgroup.WithContext(ctx)
+	defer func() {
+		cancel()
+		checkErrIsDueToCanceledContext(t, g.Wait())
+	}()
+
+	failure := &enactment.Failure{
+		Class:         enactment.FailureCommit,
+		EntryID:       "set-routes",
+		FailedRule:    "route-2",
+		AffectedRules: []string{"route-1"},
+		Err:           errors.New("RTNETLINK answers: No such device"),
+	}
+	clock := clockwork.NewFakeClockAt(startTime)
+	srv := newServer(
+		zerolog.Ctx(ctx).With().Str("role", "test server").Logger().WithContext(ctx), []testNode{{id: "node-a"}})
+	srvAddr := srv.start(ctx, t, g)
+
+	agent := newAgent(t, WithClock(clock), WithNode("node-a",
+		WithEnactmentDriver(srvAddr, &failingDriver{err: failure}, grpc.WithTransportCredentials(insecure.NewCredentials()))))
+	g.Go(func() error { return agent.Run(ctx) })
+	f := &testFixture{t: t, srv: srv, clock: clock}
+
+	token := f.expectSchedulingReset(ctx, "node-a")
+	f.expectSchedulingHello(ctx, "node-a")
+
+	f.sendSchedulingRequest(ctx, "node-a", &schedpb.ReceiveRequestsMessageFromController{
+		RequestId: 7,
+		Request: &schedpb.ReceiveRequestsMessageFromController_CreateEntry{CreateEntry: &schedpb.CreateEntryRequest{
+			ScheduleManipulationToken: token,
+			Seqno:                     1,
+			Id:                        "set-routes",
+			Time:                      timestamppb.New(startTime.Add(time.Second)),
+			ConfigurationChange: &schedpb.CreateEntryRequest_SetRoute{
+				SetRoute: &schedpb.SetRoute{To: "2001:db8:1::/48", Dev: "eth0"},
+			},
+		}},
+	})
+
+	recv := func() *schedpb.ReceiveRequestsMessageToController_Response {
+		msg, err := srv.RecvFromNode(ctx, "node-a")
+		if err != nil {
+			t.Fatalf("RecvFromNode: %v", err)
+		}
+		return msg.GetResponse()
+	}
+	if rsp := recv(); rsp.GetRequestId() != 7 || rsp.GetStatus().GetCode() != int32(codes.OK) {
+		t.Fatalf("unexpected response to CreateEntry: %v", rsp)
+	}
+
+	f.advanceClock(ctx, time.Second)
+
+	rsp := recv()
+	if rsp.GetRequestId() != 7 {
+		t.Errorf("failure reported for request %d, want 7", rsp.GetRequestId())
+	}
+	assertProtosEqual(t, failure.GRPCStatus().Proto(), rsp.GetStatus())
+}
+
+func TestEnactments_recordsEvents(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(baseContext(t))
+	g, ctx := errgroup.WithContext(ctx)
+	defer func() {
+		cancel()
+		checkErrIsDueToCanceledContext(t, g.Wait())
+	}()
+
+	logPath := filepath.Join(t.TempDir(), "events.jsonl")
+	events, err := eventlog.NewWriter(eventlog.Config{Path: logPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer events.Close()
+
+	failure := &enactment.Failure{
+		Class:         enactment.FailureCommit,
+		EntryID:       "set-routes",
+		FailedRule:    "route-2",
+		AffectedRules: []string{"route-1"},
+		Err:           status.Error(codes.Unavailable, "RTNETLINK answers: No such device"),
+	}
+	clock := clockwork.NewFakeClockAt(startTime)
+	srv := newServer(
+		zerolog.Ctx(ctx).With().Str("role", "test server").Logger().WithContext(ctx), []testNode{{id: "node-a"}})
+	srvAddr := srv.start(ctx, t, g)
+
+	agent := newAgent(t, WithClock(clock), WithEventLog(events), WithNode("node-a",
+		WithEnactmentDriver(srvAddr, &failingDriver{err: failure}, grpc.WithTransportCredentials(insecure.NewCredentials()))))
+	g.Go(func() error { return agent.Run(ctx) })
+	f := &testFixture{t: t, srv: srv, clock: clock}
+
+	token := f.expectSchedulingReset(ctx, "node-a")
+	f.expectSchedulingHello(ctx, "node-a")
+
+	createEntry := func(reqID int64, token string) *schedpb.ReceiveRequestsMessageFromController {
+		return &schedpb.ReceiveRequestsMessageFromController{
+			RequestId: reqID,
+			Request: &schedpb.ReceiveRequestsMessageFromController_CreateEntry{CreateEntry: &schedpb.CreateEntryRequest{
+				ScheduleManipulationToken: token,
+				Seqno:                     1,
+				Id:                        "set-routes",
+				Time:                      timestamppb.New(startTime.Add(time.Second)),
+				ConfigurationChange: &schedpb.CreateEntryRequest_SetRoute{
+					SetRoute: &schedpb.SetRoute{To: "2001:db8:1::/48", Dev: "eth0"},
+				},
+			}},
+		}
+	}
+	recv := func() *schedpb.ReceiveRequestsMessageToController_Response {
+		msg, err := srv.RecvFromNode(ctx, "node-a")
+		if err != nil {
+			t.Fatalf("RecvFromNode: %v", err)
+		}
+		return msg.GetResponse()
+	}
+
+	f.sendSchedulingRequest(ctx, "node-a", createEntry(1, "stale-token"))
+	recv()
+	f.sendSchedulingRequest(ctx, "node-a", createEntry(2, token))
+	recv()
+	f.advanceClock(ctx, time.Second)
+	recv()
+
+	got := []eventlog.Event{}
+	if err := eventlog.Read(logPath, func(e eventlog.Event, err error) error {
+		if err != nil {
+			return err
+		}
+		e.Time = time.Time{}
+		got = append(got, e)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []eventlog.Event{
+		{Node: "node-a", Kind: eventlog.Received, EntryID: "set-routes", RequestID: 1, Seqno: 1, Request: "create_entry"},
+		{
+			Node: "node-a", Kind: eventlog.Rejected, EntryID: "set-routes", RequestID: 1, Seqno: 1, Request: "create_entry",
+			Code: "FailedPrecondition", Message: fmt.Sprintf("received schedule manipulation token %q is not current (%s)", "stale-token", token),
+		},
+		{Node: "node-a", Kind: eventlog.Received, EntryID: "set-routes", RequestID: 2, Seqno: 1, Request: "create_entry"},
+		{Node: "node-a", Kind: eventlog.Validated, EntryID: "set-routes", RequestID: 2, Seqno: 1, Request: "create_entry"},
+		{
+			Node: "node-a", Kind: eventlog.Failed, EntryID: "set-routes", Rules: []string{"route-2"},
+			Code: "Unavailable", Message: failure.Error(),
+		},
+		{Node: "node-a", Kind: eventlog.RolledBack, EntryID: "set-routes", Rules: []string{"route-1"}},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected events (-want +got):\n%s", diff)
+	}
+}
+
 type delegatingBackend struct {
 	m    map[string]backendFn
 	errs []error
@@ -205,6 +713,8 @@ type server struct {
 	schedpb.UnimplementedSchedulingServer
 	agents map[string]*agentSchedulingStream
 	tokens map[string]chan string
+	// peers records the address each node's stream came from.
+	peers map[string]string
 }
 
 type agentSchedulingStream struct {
@@ -231,6 +741,7 @@ func newServer(ctx context.Context, nodes []testNode) *server {
 		ctx:        ctx,
 		agents:     agents,
 		tokens:     tokens,
+		peers:      map[string]string{},
 	}
 }
 
@@ -266,13 +777,14 @@ func (s *server) ReceiveRequests(stream schedpb.Scheduling_ReceiveRequestsServer
 
 	s.mu.Lock()
 	ss, ok := s.agents[nid]
+	if p, hasPeer := peer.FromContext(stream.Context()); hasPeer {
+		s.peers[nid] = p.Addr.String()
+	}
 	s.mu.Unlock()
 	if !ok {
 		return fmt.Errorf("unknown node connecting: %q", nid)
 	}
 
-	defer close(ss.fromController)
-
 	select {
 	case <-s.ctx.Done():
 		return context.Canceled
@@ -0,0 +1,320 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventlog
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// indexFile is the name of the index in an archive directory.
+const indexFile = "index.json"
+
+// Archive describes a compressed archive of the events of a rotated log
+// file, as recorded in the index of the archive directory.
+type Archive struct {
+	// File is the name of the archive within the archive directory.
+	File string `json:"file"`
+	// First and Last are the times of the oldest and newest events in the
+	// archive.
+	First time.Time `json:"first"`
+	Last  time.Time `json:"last"`
+	// Events is the number of events in the archive.
+	Events int `json:"events"`
+	// Bytes is the compressed size of the archive.
+	Bytes int64 `json:"bytes"`
+	// SHA256 is the hex-encoded SHA-256 digest of the compressed archive.
+	SHA256 string `json:"sha256"`
+}
+
+// CompactOptions configures Compact.
+type CompactOptions struct {
+	// MaxAge is how long archives are kept after their newest event. 0
+	// keeps them regardless of their age.
+	MaxAge time.Duration
+	// MaxBytes is the total compressed size past which the oldest archives
+	// are deleted. 0 keeps them regardless of their size.
+	MaxBytes int64
+	// Now is the time that MaxAge is measured from. Defaults to the current
+	// time.
+	Now time.Time
+}
+
+// CompactResult reports what Compact did.
+type CompactResult struct {
+	// Archived are the archives that were created.
+	Archived []Archive
+	// Deleted are the archives that were deleted by the retention policy.
+	Deleted []Archive
+}
+
+// ArchiveDir returns the directory that Compact archives the log at `path`
+// into.
+func ArchiveDir(path string) string { return path + ".archive" }
+
+// Compact moves the rotated files of the log at `path` into gzip-compressed
+// archives in [ArchiveDir], records each in the directory's index, and then
+// deletes the archives that `opts` no longer retains. The current file is
+// left for the Writer, so it's safe to compact the log of a running agent,
+// but Compact mustn't run concurrently with itself on the same log.
+//
+// Compaction is incremental: only the files rotated since the last
+// compaction are read, and a compaction that's interrupted is finished by
+// the next one.
+func Compact(path string, opts CompactOptions) (CompactResult, error) {
+	res := CompactResult{}
+	dir := ArchiveDir(path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return res, fmt.Errorf("creating archive directory: %w", err)
+	}
+	index, err := Archives(path)
+	if err != nil {
+		return res, err
+	}
+
+	// Files are moved out of the rotation before they're read, so the
+	// Writer can keep rotating in the meantime. Staged files left behind by
+	// an interrupted compaction are archived first.
+	staged, err := filepath.Glob(filepath.Join(dir, "*.staged"))
+	if err != nil {
+		return res, err
+	}
+	slices.Sort(staged)
+	for {
+		oldest := 0
+		for {
+			if _, err := os.Stat(rotatedPath(path, oldest+1)); err != nil {
+				break
+			}
+			oldest++
+		}
+		if oldest == 0 {
+			break
+		}
+		s := filepath.Join(dir, fmt.Sprintf("%s.%d.staged", filepath.Base(path), time.Now().UnixNano()))
+		if err := os.Rename(rotatedPath(path, oldest), s); errors.Is(err, fs.ErrNotExist) {
+			// Rotated away while staging.
+			continue
+		} else if err != nil {
+			return res, fmt.Errorf("staging %s: %w", rotatedPath(path, oldest), err)
+		}
+		staged = append(staged, s)
+	}
+
+	for _, s := range staged {
+		a, err := archiveFile(dir, filepath.Base(path), len(index)+1, s)
+		if err != nil {
+			return res, err
+		}
+		if a != nil {
+			index = append(index, *a)
+			if err := writeIndex(dir, index); err != nil {
+				return res, err
+			}
+			res.Archived = append(res.Archived, *a)
+		}
+		if err := os.Remove(s); err != nil {
+			return res, err
+		}
+	}
+
+	keep, deleted := applyRetention(index, opts)
+	if len(deleted) == 0 {
+		return res, nil
+	}
+	if err := writeIndex(dir, keep); err != nil {
+		return res, err
+	}
+	for _, a := range deleted {
+		if err := os.Remove(filepath.Join(dir, a.File)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return res, fmt.Errorf("deleting archive: %w", err)
+		}
+	}
+	res.Deleted = deleted
+	return res, nil
+}
+
+// archiveFile compresses the log file at `src` into the next archive of
+// `dir`, or returns nil if it doesn't have any events.
+func archiveFile(dir, base string, seq int, src string) (*Archive, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	a := &Archive{}
+	for {
+		a.File = fmt.Sprintf("%s.%06d.gz", base, seq)
+		if _, err := os.Stat(filepath.Join(dir, a.File)); errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		seq++
+	}
+	tmp, err := os.CreateTemp(dir, "."+a.File+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+
+	// Lines are archived verbatim, including any that can't be decoded, so
+	// nothing is lost; only the events count towards the index.
+	digest := sha256.New()
+	zw := gzip.NewWriter(io.MultiWriter(tmp, digest))
+	if _, err := readEvents(io.TeeReader(in, zw), func(e Event, err error) error {
+		if err != nil {
+			return nil
+		}
+		if a.Events == 0 || e.Time.Before(a.First) {
+			a.First = e.Time
+		}
+		if a.Events == 0 || e.Time.After(a.Last) {
+			a.Last = e.Time
+		}
+		a.Events++
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", src, err)
+	}
+	if a.Events == 0 {
+		return nil, nil
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	if err := tmp.Sync(); err != nil {
+		return nil, err
+	}
+	fi, err := tmp.Stat()
+	if err != nil {
+		return nil, err
+	}
+	a.Bytes, a.SHA256 = fi.Size(), hex.EncodeToString(digest.Sum(nil))
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, a.File)); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// applyRetention splits `index` into the archives that `opts` keeps and
+// those it deletes, oldest first.
+func applyRetention(index []Archive, opts CompactOptions) (keep, deleted []Archive) {
+	keep = slices.Clone(index)
+	slices.SortStableFunc(keep, func(a, b Archive) int { return a.Last.Compare(b.Last) })
+	now := opts.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	total := int64(0)
+	for _, a := range keep {
+		total += a.Bytes
+	}
+	for len(keep) > 0 {
+		a := keep[0]
+		expired := opts.MaxAge > 0 && now.Sub(a.Last) > opts.MaxAge
+		if !expired && (opts.MaxBytes <= 0 || total <= opts.MaxBytes) {
+			break
+		}
+		total -= a.Bytes
+		keep, deleted = keep[1:], append(deleted, a)
+	}
+	return keep, deleted
+}
+
+// Archives returns the archives of the log at `path`, from oldest to newest.
+func Archives(path string) ([]Archive, error) {
+	data, err := os.ReadFile(filepath.Join(ArchiveDir(path), indexFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	index := []Archive{}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("decoding archive index: %w", err)
+	}
+	slices.SortStableFunc(index, func(a, b Archive) int { return a.First.Compare(b.First) })
+	return index, nil
+}
+
+// writeIndex atomically replaces the index of `dir`.
+func writeIndex(dir string, index []Archive) error {
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "."+indexFile+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, indexFile))
+}
+
+// readArchives calls `fn` with each event in the archives of the log at
+// `path` that has events at or after `since`, from oldest to newest.
+func readArchives(path string, since time.Time, fn func(Event, error) error) error {
+	index, err := Archives(path)
+	if err != nil {
+		return err
+	}
+	for _, a := range index {
+		if a.Last.Before(since) {
+			continue
+		}
+		if err := readArchive(path, a, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readArchive calls `fn` with each event in the archive `a` of the log at
+// `path`.
+func readArchive(path string, a Archive, fn func(Event, error) error) error {
+	f, err := os.Open(filepath.Join(ArchiveDir(path), a.File))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(bufio.NewReader(f))
+	if err != nil {
+		return fmt.Errorf("reading archive %s: %w", a.File, err)
+	}
+	defer zr.Close()
+	_, err = readEvents(zr, fn)
+	return err
+}
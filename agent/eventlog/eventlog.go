@@ -0,0 +1,482 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package eventlog records the decisions an agent makes about each update it
+// receives in an append-only, structured log, so that the timeline of an
+// incident can be reconstructed after the fact.
+//
+// The log is a file of JSON objects, one [Event] per line. Once the file
+// grows past a configured size it's rotated: "events.jsonl" becomes
+// "events.jsonl.1", the previous "events.jsonl.1" becomes "events.jsonl.2",
+// and so on, up to a configured number of files. [Compact] moves rotated
+// files into compressed archives, which are kept according to a retention
+// policy rather than the number of files.
+package eventlog
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxBytes is the size past which a log file is rotated, unless
+	// configured otherwise.
+	DefaultMaxBytes = 64 << 20
+	// DefaultMaxFiles is how many files, including the current one, are kept
+	// unless configured otherwise.
+	DefaultMaxFiles = 5
+)
+
+// Kind is the kind of decision an Event records.
+type Kind string
+
+const (
+	// Received means a request was received from the controller.
+	Received Kind = "received"
+	// Validated means a request was accepted into the schedule.
+	Validated Kind = "validated"
+	// Rejected means a request wasn't accepted into the schedule.
+	Rejected Kind = "rejected"
+	// Enacted means an entry was successfully enacted.
+	Enacted Kind = "enacted"
+	// Failed means an entry couldn't be enacted.
+	Failed Kind = "failed"
+	// RolledBack means the steps of a failed enactment that had already been
+	// applied were undone.
+	RolledBack Kind = "rolled_back"
+	// Reconciled means the schedule was changed to match the controller's,
+	// such as when entries persisted by a previous run are restored, adopted
+	// or dropped.
+	Reconciled Kind = "reconciled"
+	// OperatorAction means an operator acted on the agent remotely, such as
+	// restarting a backend.
+	OperatorAction Kind = "operator_action"
+)
+
+// Event is a single entry of the log.
+type Event struct {
+	Time time.Time `json:"time"`
+	Node string    `json:"node"`
+	Kind Kind      `json:"kind"`
+	// EntryID is the ID of the schedule entry the event concerns, if any.
+	EntryID string `json:"entry_id,omitempty"`
+	// RequestID and Seqno identify the controller's request, if any.
+	RequestID int64  `json:"request_id,omitempty"`
+	Seqno     uint64 `json:"seqno,omitempty"`
+	// Request is the type of the controller's request, such as
+	// "create_entry", or the operator's action, such as "restart_backend".
+	Request string `json:"request,omitempty"`
+	// Actor identifies the operator who took an action.
+	Actor string `json:"actor,omitempty"`
+	// Operator, TicketID, JobID and Labels are the call context the
+	// client attached to an operator action, if any (see package callctx).
+	Operator string            `json:"operator,omitempty"`
+	TicketID string            `json:"ticket_id,omitempty"`
+	JobID    string            `json:"job_id,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	// Code is the gRPC status code of a rejection or failure.
+	Code string `json:"code,omitempty"`
+	// Message describes the event in more detail.
+	Message string `json:"message,omitempty"`
+	// Rules are the rules affected by the event, such as those rolled back
+	// after a failure.
+	Rules []string `json:"rules,omitempty"`
+}
+
+// Config configures a Writer.
+type Config struct {
+	// Path is the path of the current log file.
+	Path string
+	// MaxBytes is the size past which the log file is rotated. Defaults to
+	// [DefaultMaxBytes].
+	MaxBytes int64
+	// MaxFiles is how many files, including the current one, are kept.
+	// Defaults to [DefaultMaxFiles].
+	MaxFiles int
+}
+
+// Writer appends events to a log. It's safe for concurrent use.
+type Writer struct {
+	path     string
+	maxBytes int64
+	maxFiles int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// NewWriter opens the log described by `conf` for appending, creating it if
+// needed.
+func NewWriter(conf Config) (*Writer, error) {
+	if conf.Path == "" {
+		return nil, errors.New("no event log path provided")
+	}
+	w := &Writer{path: conf.Path, maxBytes: conf.MaxBytes, maxFiles: conf.MaxFiles}
+	if w.maxBytes <= 0 {
+		w.maxBytes = DefaultMaxBytes
+	}
+	if w.maxFiles <= 0 {
+		w.maxFiles = DefaultMaxFiles
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *Writer) open() error {
+	f, err := os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("opening event log: %w", err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("opening event log: %w", err)
+	}
+	w.f, w.size = f, fi.Size()
+	return nil
+}
+
+// Append writes `e` to the log, rotating it first if it's full.
+func (w *Writer) Append(e Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.f == nil {
+		return fs.ErrClosed
+	}
+	if w.size > 0 && w.size+int64(len(data)) > w.maxBytes {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := w.f.Write(data)
+	w.size += int64(n)
+	return err
+}
+
+// rotate shifts the existing files along and starts a new current file.
+// Callers must hold w.mu.
+func (w *Writer) rotate() error {
+	if err := w.f.Close(); err != nil {
+		return err
+	}
+	w.f = nil
+
+	for i := w.maxFiles - 1; i >= 1; i-- {
+		err := os.Rename(rotatedPath(w.path, i-1), rotatedPath(w.path, i))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("rotating event log: %w", err)
+		}
+	}
+	return w.open()
+}
+
+// Close closes the log.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.f == nil {
+		return nil
+	}
+	err := w.f.Close()
+	w.f = nil
+	return err
+}
+
+// Path returns the path of the log the Writer appends to.
+func (w *Writer) Path() string { return w.path }
+
+// rotatedPath returns the path of the file that's been rotated `n` times.
+func rotatedPath(path string, n int) string {
+	if n == 0 {
+		return path
+	}
+	return path + "." + strconv.Itoa(n)
+}
+
+// Read calls `fn` with each event in the log at `path`, including the
+// rotated files and the archives made by [Compact], from oldest to newest.
+// Lines that can't be decoded are reported to `fn` as errors, so a damaged
+// file doesn't hide the events around it; returning an error from `fn`
+// stops the read.
+func Read(path string, fn func(Event, error) error) error {
+	return ReadSince(path, time.Time{}, fn)
+}
+
+// ReadSince is like [Read], but uses the index of the archives to skip
+// those that only have events before `since`. Events before `since` in the
+// archives it does read, and in the rotated files, are still passed to
+// `fn`.
+func ReadSince(path string, since time.Time, fn func(Event, error) error) error {
+	if err := readArchives(path, since, fn); err != nil {
+		return err
+	}
+	return readLive(path, fn)
+}
+
+// readLive calls `fn` with each event in the current and rotated files of
+// the log at `path`, from oldest to newest.
+func readLive(path string, fn func(Event, error) error) error {
+	rotated := 0
+	for {
+		if _, err := os.Stat(rotatedPath(path, rotated+1)); err != nil {
+			break
+		}
+		rotated++
+	}
+
+	for i := rotated; i >= 0; i-- {
+		f, err := os.Open(rotatedPath(path, i))
+		if errors.Is(err, fs.ErrNotExist) && i > 0 {
+			// Rotated away while reading.
+			continue
+		} else if err != nil {
+			return err
+		}
+		_, err = readEvents(f, fn)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Last returns the `n` most recent events in the log at `path` that `match`
+// accepts, from oldest to newest. A nil `match` accepts every event. Lines
+// that can't be decoded are skipped. Archives are only read, newest first,
+// if the current and rotated files don't have enough events.
+func Last(path string, n int, match func(Event) bool) ([]Event, error) {
+	last := func(n int, read func(func(Event, error) error) error) ([]Event, error) {
+		events := []Event{}
+		err := read(func(e Event, err error) error {
+			if err != nil || (match != nil && !match(e)) {
+				return nil
+			}
+			if events = append(events, e); len(events) > n {
+				events = events[1:]
+			}
+			return nil
+		})
+		return events, err
+	}
+
+	events, err := last(n, func(fn func(Event, error) error) error { return readLive(path, fn) })
+	if err != nil {
+		return nil, err
+	}
+	if len(events) >= n {
+		return events, nil
+	}
+	archives, err := Archives(path)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(archives) - 1; i >= 0 && len(events) < n; i-- {
+		older, err := last(n-len(events), func(fn func(Event, error) error) error { return readArchive(path, archives[i], fn) })
+		if err != nil {
+			return nil, err
+		}
+		events = append(older, events...)
+	}
+	return events, nil
+}
+
+// readEvents calls `fn` with each complete line of `r` and returns the
+// number of bytes consumed. A trailing partial line, which a Writer may
+// still be writing, isn't consumed.
+func readEvents(r io.Reader, fn func(Event, error) error) (int64, error) {
+	br := bufio.NewReader(r)
+	var consumed int64
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			return consumed, nil
+		} else if err != nil {
+			return consumed, err
+		}
+		consumed += int64(len(line))
+
+		e := Event{}
+		if err := json.Unmarshal(line, &e); err != nil {
+			err = fn(Event{}, fmt.Errorf("decoding event: %w", err))
+			if err != nil {
+				return consumed, err
+			}
+			continue
+		}
+		if err := fn(e, nil); err != nil {
+			return consumed, err
+		}
+	}
+}
+
+// Tailer reads the events of a log as they're appended, like tail -f.
+type Tailer struct {
+	path   string
+	f      *os.File
+	offset int64
+	// start is the size of the current file when the Tailer was created;
+	// events before it are the backlog.
+	start int64
+}
+
+// NewTailer returns a Tailer positioned at the end of the log at `path`.
+func NewTailer(path string) (*Tailer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &Tailer{path: path, f: f, offset: fi.Size(), start: fi.Size()}, nil
+}
+
+// Backlog calls `fn` with each event that was in the log when the Tailer
+// was created, from oldest to newest, like [Read].
+func (t *Tailer) Backlog(fn func(Event, error) error) error {
+	cur, err := t.f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := readArchives(t.path, time.Time{}, fn); err != nil {
+		return err
+	}
+
+	rotated := 0
+	for {
+		if _, err := os.Stat(rotatedPath(t.path, rotated+1)); err != nil {
+			break
+		}
+		rotated++
+	}
+	for i := rotated; i >= 0; i-- {
+		f, err := os.Open(rotatedPath(t.path, i))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		fi, err := f.Stat()
+		if err != nil || os.SameFile(fi, cur) {
+			// The Tailer's own file, which may have been rotated since, is
+			// read last and only up to where the Tailer started.
+			f.Close()
+			if err != nil {
+				return err
+			}
+			break
+		}
+		_, err = readEvents(f, fn)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = readEvents(io.NewSectionReader(t.f, 0, t.start), fn)
+	return err
+}
+
+// Follow calls `fn` with each event appended to the log after the Tailer
+// was created, until `ctx` is done or `fn` returns an error. It polls the
+// log every `interval`, and carries on with the new file when the log is
+// rotated.
+func (t *Tailer) Follow(ctx context.Context, interval time.Duration, fn func(Event, error) error) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		n, err := readEvents(io.NewSectionReader(t.f, t.offset, math.MaxInt64-t.offset), fn)
+		if err != nil {
+			return err
+		}
+		t.offset += n
+
+		// Once the file we have open has been rotated away and fully read,
+		// switch to the new one.
+		if n == 0 {
+			if err := t.switchIfRotated(); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// switchIfRotated moves on to the file after the one the Tailer has open,
+// if it's been rotated.
+func (t *Tailer) switchIfRotated() error {
+	old, err := t.f.Stat()
+	if err != nil {
+		return err
+	}
+	if cur, err := os.Stat(t.path); err != nil || os.SameFile(cur, old) {
+		// Either still current, or mid-rotation.
+		return nil
+	}
+
+	// The file that was rotated after ours is the one just before it in the
+	// rotation order. If ours has been rotated out of existence, so has
+	// everything before it, and the oldest remaining file is next.
+	next := t.path
+	for i := 1; ; i++ {
+		fi, err := os.Stat(rotatedPath(t.path, i))
+		if err != nil {
+			break
+		}
+		if os.SameFile(fi, old) {
+			break
+		}
+		next = rotatedPath(t.path, i)
+	}
+
+	f, err := os.Open(next)
+	if err != nil {
+		return err
+	}
+	t.f.Close()
+	t.f, t.offset = f, 0
+	return nil
+}
+
+// Close closes the Tailer.
+func (t *Tailer) Close() error { return t.f.Close() }
@@ -0,0 +1,320 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventlog
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func readAll(t *testing.T, path string) []string {
+	t.Helper()
+
+	ids := []string{}
+	if err := Read(path, func(e Event, err error) error {
+		if err != nil {
+			ids = append(ids, "<"+err.Error()+">")
+		} else {
+			ids = append(ids, e.EntryID)
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("Read(%q) failed: %v", path, err)
+	}
+	return ids
+}
+
+func TestWriter_rotates(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	// Each event is a little over 60 bytes, so every file holds two.
+	w, err := NewWriter(Config{Path: path, MaxBytes: 160, MaxFiles: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"e1", "e2", "e3", "e4", "e5", "e6", "e7"} {
+		if err := w.Append(Event{Time: time.Unix(0, 0).UTC(), Node: "n", Kind: Enacted, EntryID: id}); err != nil {
+			t.Fatalf("Append(%q) failed: %v", id, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The oldest file was rotated out of existence.
+	if _, err := os.Stat(path + ".3"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Stat(%s.3) = %v, want ErrNotExist", path, err)
+	}
+	if diff := cmp.Diff([]string{"e3", "e4", "e5", "e6", "e7"}, readAll(t, path)); diff != "" {
+		t.Errorf("unexpected events (-want +got):\n%s", diff)
+	}
+
+	// Reopening appends to the existing file.
+	w, err = NewWriter(Config{Path: path, MaxBytes: 160, MaxFiles: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if err := w.Append(Event{Kind: Enacted, EntryID: "e8"}); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"e3", "e4", "e5", "e6", "e7", "e8"}, readAll(t, path)); diff != "" {
+		t.Errorf("unexpected events after reopening (-want +got):\n%s", diff)
+	}
+}
+
+func TestRead_reportsCorruptLines(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	data := `{"kind":"received","entry_id":"a"}` + "\n" + "garbage\n" + `{"kind":"enacted","entry_id":"b"}` + "\n" + `{"kind":"enac`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	got := readAll(t, path)
+	if len(got) != 3 || got[0] != "a" || got[2] != "b" || got[1][0] != '<' {
+		t.Errorf("got events %q, want a, an error, and b", got)
+	}
+}
+
+func TestLast(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	data := `{"node":"n1","entry_id":"a"}` + "\n" + `{"node":"n2","entry_id":"b"}` + "\n" + "garbage\n" +
+		`{"node":"n1","entry_id":"c"}` + "\n" + `{"node":"n1","entry_id":"d"}` + "\n"
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Last(path, 2, func(e Event) bool { return e.Node == "n1" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].EntryID != "c" || got[1].EntryID != "d" {
+		t.Errorf("got events %+v, want c and d", got)
+	}
+}
+
+func TestTailer(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	// Each file holds two events.
+	w, err := NewWriter(Config{Path: path, MaxBytes: 200, MaxFiles: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	for _, id := range []string{"b1", "b2"} {
+		if err := w.Append(Event{EntryID: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tailer, err := NewTailer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tailer.Close()
+
+	// Events appended after the Tailer is created, including across
+	// rotations, are followed rather than part of the backlog.
+	want := []string{"f1", "f2", "f3"}
+	for _, id := range want {
+		if err := w.Append(Event{EntryID: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	backlog := []string{}
+	if err := tailer.Backlog(func(e Event, err error) error {
+		backlog = append(backlog, e.EntryID)
+		return err
+	}); err != nil {
+		t.Fatalf("Backlog() failed: %v", err)
+	}
+	if diff := cmp.Diff([]string{"b1", "b2"}, backlog); diff != "" {
+		t.Errorf("unexpected backlog (-want +got):\n%s", diff)
+	}
+
+	got := make(chan string)
+	go tailer.Follow(ctx, time.Millisecond, func(e Event, err error) error {
+		if err != nil {
+			return err
+		}
+		select {
+		case got <- e.EntryID:
+		case <-ctx.Done():
+		}
+		return nil
+	})
+	for _, id := range want {
+		select {
+		case g := <-got:
+			if g != id {
+				t.Fatalf("followed event %q, want %q", g, id)
+			}
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for event %q", id)
+		}
+	}
+}
+
+// writeRotated writes an event for each of `ids`, a minute apart from
+// `start` minutes after the epoch, to a log at `path` that holds two events
+// per file.
+func writeRotated(t *testing.T, path string, start int, ids ...string) {
+	t.Helper()
+
+	w, err := NewWriter(Config{Path: path, MaxBytes: 160, MaxFiles: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	for i, id := range ids {
+		e := Event{Time: time.Unix(int64(60*(start+i)), 0).UTC(), Node: "n", Kind: Enacted, EntryID: id}
+		if err := w.Append(e); err != nil {
+			t.Fatalf("Append(%q) failed: %v", id, err)
+		}
+	}
+}
+
+func TestCompact(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	writeRotated(t, path, 0, "e1", "e2", "e3", "e4", "e5")
+
+	res, err := Compact(path, CompactOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Archived) != 2 || len(res.Deleted) != 0 {
+		t.Fatalf("Compact() = %+v, want 2 archives and none deleted", res)
+	}
+	if _, err := os.Stat(path + ".1"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Stat(%s.1) = %v, want ErrNotExist", path, err)
+	}
+	archives, err := Archives(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Archive{
+		{First: time.Unix(0, 0).UTC(), Last: time.Unix(60, 0).UTC(), Events: 2},
+		{First: time.Unix(120, 0).UTC(), Last: time.Unix(180, 0).UTC(), Events: 2},
+	}
+	if diff := cmp.Diff(want, archives, cmpopts.IgnoreFields(Archive{}, "File", "Bytes", "SHA256")); diff != "" {
+		t.Errorf("unexpected archives (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"e1", "e2", "e3", "e4", "e5"}, readAll(t, path)); diff != "" {
+		t.Errorf("unexpected events (-want +got):\n%s", diff)
+	}
+
+	// Compaction is incremental: only the files rotated since are archived.
+	writeRotated(t, path, 5, "e6", "e7")
+	if res, err = Compact(path, CompactOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Archived) != 1 {
+		t.Errorf("second Compact() archived %d files, want 1", len(res.Archived))
+	}
+	if diff := cmp.Diff([]string{"e1", "e2", "e3", "e4", "e5", "e6", "e7"}, readAll(t, path)); diff != "" {
+		t.Errorf("unexpected events after the second compaction (-want +got):\n%s", diff)
+	}
+
+	got, err := Last(path, 4, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []string{}
+	for _, e := range got {
+		ids = append(ids, e.EntryID)
+	}
+	if diff := cmp.Diff([]string{"e4", "e5", "e6", "e7"}, ids); diff != "" {
+		t.Errorf("unexpected Last() events (-want +got):\n%s", diff)
+	}
+}
+
+func TestCompact_retention(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	writeRotated(t, path, 0, "e1", "e2", "e3", "e4", "e5", "e6", "e7")
+
+	// Only the archive of e1 and e2 ends more than 3 minutes before e6.
+	res, err := Compact(path, CompactOptions{MaxAge: 3 * time.Minute, Now: time.Unix(300, 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Archived) != 3 || len(res.Deleted) != 1 || res.Deleted[0].Last != time.Unix(60, 0).UTC() {
+		t.Fatalf("Compact() = %+v, want 3 archives with the oldest deleted", res)
+	}
+	if diff := cmp.Diff([]string{"e3", "e4", "e5", "e6", "e7"}, readAll(t, path)); diff != "" {
+		t.Errorf("unexpected events (-want +got):\n%s", diff)
+	}
+
+	// Bounding the size keeps only the newest archive.
+	if res, err = Compact(path, CompactOptions{MaxBytes: res.Archived[2].Bytes}); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Deleted) != 1 {
+		t.Errorf("Compact() deleted %d archives, want 1", len(res.Deleted))
+	}
+	if diff := cmp.Diff([]string{"e5", "e6", "e7"}, readAll(t, path)); diff != "" {
+		t.Errorf("unexpected events (-want +got):\n%s", diff)
+	}
+}
+
+func TestCompact_finishesInterruptedCompaction(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	if err := os.MkdirAll(ArchiveDir(path), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	data := `{"kind":"enacted","entry_id":"a"}` + "\n" + "garbage\n"
+	if err := os.WriteFile(filepath.Join(ArchiveDir(path), "events.jsonl.1.staged"), []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := Compact(path, CompactOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Archived) != 1 || res.Archived[0].Events != 1 {
+		t.Fatalf("Compact() = %+v, want 1 archive of 1 event", res)
+	}
+	// Lines that can't be decoded are archived too.
+	got := readAll(t, path)
+	if len(got) != 2 || got[0] != "a" || got[1][0] != '<' {
+		t.Errorf("got events %q, want a and an error", got)
+	}
+}
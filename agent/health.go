@@ -0,0 +1,69 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"time"
+
+	"aalyria.com/spacetime/agent/internal/health"
+	telemetrypb "aalyria.com/spacetime/telemetry/v1alpha"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// healthReportInterval is how often each node's condition is reported to
+	// the controller over its telemetry stream.
+	healthReportInterval = 30 * time.Second
+	// healthStreamGrace is how long a stream to the controller can be down
+	// before the node is considered unhealthy rather than degraded.
+	healthStreamGrace = 1 * time.Minute
+	// healthResultWindow is how many of the latest enactments and telemetry
+	// reports their health is assessed from.
+	healthResultWindow = 20
+	// healthQueueDepthLimit is how many requests and enactments a node can
+	// have outstanding before it's considered degraded.
+	healthQueueDepthLimit = 100
+)
+
+func nodeConditionProto(r health.Report) *telemetrypb.NodeConditionDataPoint {
+	dp := &telemetrypb.NodeConditionDataPoint{
+		Time:        timestamppb.New(r.Time),
+		HealthScore: r.Score,
+		State:       nodeHealthStateProto(r.State),
+	}
+	for _, c := range r.Checks {
+		dp.Checks = append(dp.Checks, &telemetrypb.NodeHealthCheck{
+			Name:   c.Name,
+			Score:  c.Score,
+			State:  nodeHealthStateProto(c.State),
+			Detail: c.Detail,
+		})
+	}
+	return dp
+}
+
+func nodeHealthStateProto(s health.State) telemetrypb.NodeHealthState {
+	switch s {
+	case health.Healthy:
+		return telemetrypb.NodeHealthState_NODE_HEALTH_STATE_HEALTHY
+	case health.Degraded:
+		return telemetrypb.NodeHealthState_NODE_HEALTH_STATE_DEGRADED
+	case health.Unhealthy:
+		return telemetrypb.NodeHealthState_NODE_HEALTH_STATE_UNHEALTHY
+	default:
+		return telemetrypb.NodeHealthState_NODE_HEALTH_STATE_UNSPECIFIED
+	}
+}
@@ -0,0 +1,269 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"aalyria.com/spacetime/agent/internal/health"
+
+	"github.com/rs/zerolog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// probeInterval is how often the health server reassesses the nodes.
+	probeInterval = 5 * time.Second
+	// probeStaleAfter is how old the latest assessment can get before the
+	// agent is considered wedged.
+	probeStaleAfter = 3 * probeInterval
+
+	// The services of the gRPC health server. The empty service, which
+	// probes check by default, reports readiness.
+	readinessService = "readiness"
+	livenessService  = "liveness"
+)
+
+type healthServerConfig struct {
+	grpcLis, httpLis net.Listener
+	maxUnhealthy     time.Duration
+}
+
+// WithHealthServer configures the Agent to report whether it's live and
+// ready, so that supervisors such as Kubernetes or systemd can restart it
+// when it's wedged.
+//
+// If `grpcLis` isn't nil, the grpc.health.v1.Health service is served on
+// it: the "readiness" service and the default, empty service report
+// readiness, and the "liveness" service reports liveness. If `httpLis`
+// isn't nil, /readyz and /livez are served on it, responding 200 OK or 503
+// Service Unavailable with a summary of each node's health.
+//
+// The Agent is ready while each node's scheduling stream is connected and
+// none of its nodes is unhealthy or draining (see [WithDrainTimeout]). It's
+// live as long as its nodes are running and their health keeps being
+// assessed. If `maxUnhealthy` is positive, a node that's been unhealthy for
+// longer than it also makes the Agent not live. The Agent closes the
+// listeners when it stops.
+func WithHealthServer(grpcLis, httpLis net.Listener, maxUnhealthy time.Duration) AgentOption {
+	return agentOptFunc(func(a *Agent) {
+		a.healthServer = &healthServerConfig{grpcLis: grpcLis, httpLis: httpLis, maxUnhealthy: maxUnhealthy}
+	})
+}
+
+// probeResult is an assessment of whether the Agent is live and ready.
+type probeResult struct {
+	time        time.Time
+	live, ready bool
+	// details explains the result, one line per node.
+	details []string
+}
+
+type healthServer struct {
+	healthpb.UnimplementedHealthServer
+
+	a            *Agent
+	nodes        map[string]runningNode
+	maxUnhealthy time.Duration
+
+	mu     sync.Mutex
+	latest probeResult
+	// unhealthySince is when each node that's unhealthy became so.
+	unhealthySince map[string]time.Time
+}
+
+// newHealthServer returns a healthServer that assesses `nodes`, every
+// probeInterval once it's run. It backs the configured health endpoints, if
+// any, and the watchdog notifications (see WithServiceNotifier).
+func (a *Agent) newHealthServer(nodes map[string]runningNode) *healthServer {
+	s := &healthServer{
+		a:              a,
+		nodes:          nodes,
+		unhealthySince: map[string]time.Time{},
+	}
+	if a.healthServer != nil {
+		s.maxUnhealthy = a.healthServer.maxUnhealthy
+	}
+	s.probe()
+	return s
+}
+
+// run assesses the nodes every probeInterval until `ctx` is done.
+func (s *healthServer) run(ctx context.Context) {
+	ticker := s.a.clock.NewTicker(probeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.Chan():
+			s.probe()
+		}
+	}
+}
+
+// serveHealth serves the configured health endpoints from `s` until `ctx`
+// is done.
+func (a *Agent) serveHealth(ctx context.Context, s *healthServer) {
+	cfg := a.healthServer
+	wg := &sync.WaitGroup{}
+	stops := []func(){}
+	if cfg.grpcLis != nil {
+		srv := grpc.NewServer()
+		healthpb.RegisterHealthServer(srv, s)
+		stops = append(stops, srv.Stop)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			zerolog.Ctx(ctx).Info().Str("address", cfg.grpcLis.Addr().String()).Msg("serving gRPC health checks")
+			if err := srv.Serve(cfg.grpcLis); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+				zerolog.Ctx(ctx).Error().Err(err).Msg("gRPC health server failed")
+			}
+		}()
+	}
+	if cfg.httpLis != nil {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/livez", s.handle(func(r probeResult) bool { return r.live }))
+		mux.HandleFunc("/readyz", s.handle(func(r probeResult) bool { return r.ready }))
+		srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		stops = append(stops, func() { srv.Close() })
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			zerolog.Ctx(ctx).Info().Str("address", cfg.httpLis.Addr().String()).Msg("serving HTTP health checks")
+			if err := srv.Serve(cfg.httpLis); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				zerolog.Ctx(ctx).Error().Err(err).Msg("HTTP health server failed")
+			}
+		}()
+	}
+
+	<-ctx.Done()
+	for _, stop := range stops {
+		stop()
+	}
+	wg.Wait()
+}
+
+// probe assesses the nodes and records the result.
+func (s *healthServer) probe() {
+	now := s.a.clock.Now()
+	r := probeResult{time: now, live: true, ready: true}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range slices.Sorted(maps.Keys(s.nodes)) {
+		n := s.nodes[id]
+		if n.ctx.Err() != nil {
+			r.live, r.ready = false, false
+			r.details = append(r.details, fmt.Sprintf("node %s: stopped", id))
+			delete(s.unhealthySince, id)
+			continue
+		}
+
+		report := n.nc.health.Report()
+		line := fmt.Sprintf("node %s: %s (score %.2f)", id, report.State, report.Score)
+		if es := n.nc.enactment; es != nil && !es.stream.IsConnected() {
+			r.ready = false
+			line += "; scheduling stream not connected"
+		}
+		if n.nc.draining.Load() {
+			r.ready = false
+			line += "; draining"
+		}
+		if report.State != health.Unhealthy {
+			delete(s.unhealthySince, id)
+			r.details = append(r.details, line)
+			continue
+		}
+
+		r.ready = false
+		since, ok := s.unhealthySince[id]
+		if !ok {
+			since = now
+			s.unhealthySince[id] = now
+		}
+		if s.maxUnhealthy > 0 && now.Sub(since) > s.maxUnhealthy {
+			r.live = false
+			line += fmt.Sprintf("; unhealthy for %v", now.Sub(since).Truncate(time.Second))
+		}
+		for _, c := range report.Checks {
+			if c.State == health.Unhealthy {
+				line += fmt.Sprintf("; %s: %s", c.Name, c.Detail)
+			}
+		}
+		r.details = append(r.details, line)
+	}
+	s.latest = r
+}
+
+// result returns the latest assessment. If it's stale, whatever assesses
+// the nodes is stuck, so the Agent is neither live nor ready.
+func (s *healthServer) result() probeResult {
+	s.mu.Lock()
+	r := s.latest
+	s.mu.Unlock()
+
+	if age := s.a.clock.Since(r.time); age > probeStaleAfter {
+		r.live, r.ready = false, false
+		r.details = append(slices.Clip(r.details), fmt.Sprintf("health last assessed %v ago", age.Truncate(time.Second)))
+	}
+	return r
+}
+
+func (s *healthServer) Check(_ context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	r := s.result()
+	var ok bool
+	switch req.GetService() {
+	case "", readinessService:
+		ok = r.ready
+	case livenessService:
+		ok = r.live
+	default:
+		return nil, status.Errorf(codes.NotFound, "unknown service %q", req.GetService())
+	}
+
+	st := healthpb.HealthCheckResponse_NOT_SERVING
+	if ok {
+		st = healthpb.HealthCheckResponse_SERVING
+	}
+	return &healthpb.HealthCheckResponse{Status: st}, nil
+}
+
+func (s *healthServer) handle(ok func(probeResult) bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		r := s.result()
+		code, summary := http.StatusOK, "ok"
+		if !ok(r) {
+			code, summary = http.StatusServiceUnavailable, "failed"
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(code)
+		fmt.Fprintln(w, summary)
+		for _, d := range r.details {
+			fmt.Fprintln(w, d)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"aalyria.com/spacetime/agent/internal/health"
+
+	"github.com/jonboulle/clockwork"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestHealthServer(t *testing.T) {
+	t.Parallel()
+
+	clock := clockwork.NewFakeClockAt(startTime)
+	backendScore := 1.0
+	newNode := func() (runningNode, context.CancelFunc, *health.Stream) {
+		nc := &nodeController{health: health.NewMonitor(clock)}
+		stream := health.NewStream(clock, healthStreamGrace)
+		nc.enactment = &enactmentService{stream: stream}
+		nc.health.Add("scheduling_stream", stream.Check)
+		nc.health.Add("enactment_backend", func() (float64, string) { return backendScore, "" })
+		ctx, cancel := context.WithCancel(context.Background())
+		return runningNode{nc: nc, ctx: ctx}, cancel, stream
+	}
+	nodeA, stopA, streamA := newNode()
+	defer stopA()
+	nodeB, stopB, streamB := newNode()
+	defer stopB()
+
+	s := &healthServer{
+		a:              &Agent{clock: clock},
+		nodes:          map[string]runningNode{"node-a": nodeA, "node-b": nodeB},
+		maxUnhealthy:   time.Minute,
+		unhealthySince: map[string]time.Time{},
+	}
+	expect := func(desc string, wantLive, wantReady bool) {
+		t.Helper()
+
+		for _, tc := range []struct {
+			service, path string
+			want          bool
+		}{
+			{"", "/readyz", wantReady},
+			{readinessService, "/readyz", wantReady},
+			{livenessService, "/livez", wantLive},
+		} {
+			resp, err := s.Check(context.Background(), &healthpb.HealthCheckRequest{Service: tc.service})
+			check(t, err)
+			if got := resp.GetStatus() == healthpb.HealthCheckResponse_SERVING; got != tc.want {
+				t.Errorf("%s: Check(%q) = %v, want serving: %v", desc, tc.service, resp.GetStatus(), tc.want)
+			}
+
+			rec := httptest.NewRecorder()
+			s.handle(func(r probeResult) bool {
+				if tc.path == "/livez" {
+					return r.live
+				}
+				return r.ready
+			})(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+			if got := rec.Code == http.StatusOK; got != tc.want {
+				t.Errorf("%s: GET %s = %d:\n%s\nwant OK: %v", desc, tc.path, rec.Code, rec.Body, tc.want)
+			}
+		}
+	}
+
+	s.probe()
+	expect("streams not connected yet", true, false)
+
+	streamA.Connected()
+	streamB.Connected()
+	s.probe()
+	expect("streams connected", true, true)
+
+	backendScore = 0
+	s.probe()
+	expect("backends just failed", true, false)
+	clock.Advance(2 * time.Minute)
+	s.probe()
+	expect("backends failed for longer than max_unhealthy", false, false)
+
+	backendScore = 1
+	s.probe()
+	expect("backends recovered", true, true)
+
+	clock.Advance(probeStaleAfter + time.Second)
+	expect("assessment is stale", false, false)
+	s.probe()
+	expect("assessed again", true, true)
+
+	stopB()
+	s.probe()
+	expect("node stopped", false, false)
+	rec := httptest.NewRecorder()
+	s.handle(func(r probeResult) bool { return r.live })(rec, httptest.NewRequest(http.MethodGet, "/livez", nil))
+	if !strings.Contains(rec.Body.String(), "node node-b: stopped") {
+		t.Errorf("GET /livez doesn't explain that node-b stopped:\n%s", rec.Body)
+	}
+
+	if _, err := s.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "unknown"}); err == nil {
+		t.Errorf("Check() of an unknown service succeeded, want an error")
+	}
+}
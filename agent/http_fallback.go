@@ -0,0 +1,272 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+// Some remote sites reach the controller through middleboxes that only pass
+// HTTP/1.1, which rules out gRPC. For those sites the Scheduling service can
+// be reached through an HTTP fallback that emulates the ReceiveRequests
+// stream with long polling:
+//
+//	POST {url}/reset                  ResetRequest
+//	POST {url}/sessions/{id}/send     ReceiveRequestsMessageToController
+//	POST {url}/sessions/{id}/poll     {} -> {"messages": [ReceiveRequestsMessageFromController, ...]}
+//
+// All bodies use the JSON mapping of the protobuf messages. The session ID is
+// chosen by the agent when the stream is opened. The server holds each poll
+// open until it has at least one message for the agent or until its own
+// timeout elapses, in which case it responds with an empty list. A 410 (Gone)
+// response means the session has ended and the agent needs to reconnect.
+// Errors are described by a JSON-encoded google.rpc.Status where possible.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	schedpb "aalyria.com/spacetime/api/scheduling/v1alpha"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// maxErrorBodyLen limits how much of an unexpected error response is
+// included in the resulting error.
+const maxErrorBodyLen = 256
+
+var protojsonUnmarshalOpts = protojson.UnmarshalOptions{DiscardUnknown: true}
+
+// fallbackSchedulingClient is a [schedpb.SchedulingClient] that uses
+// `primary` whenever it can and only switches to `fallback` when `primary`
+// is unavailable. The choice is made each time a ReceiveRequests stream is
+// opened, so the agent returns to `primary` as soon as it's reachable again,
+// and Reset calls follow whichever client opened the latest stream.
+type fallbackSchedulingClient struct {
+	primary, fallback schedpb.SchedulingClient
+
+	mu      sync.Mutex
+	current schedpb.SchedulingClient
+}
+
+func newFallbackSchedulingClient(primary, fallback schedpb.SchedulingClient) *fallbackSchedulingClient {
+	return &fallbackSchedulingClient{primary: primary, fallback: fallback, current: primary}
+}
+
+func (c *fallbackSchedulingClient) setCurrent(sc schedpb.SchedulingClient) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.current = sc
+}
+
+func (c *fallbackSchedulingClient) getCurrent() schedpb.SchedulingClient {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.current
+}
+
+func (c *fallbackSchedulingClient) ReceiveRequests(ctx context.Context, opts ...grpc.CallOption) (schedpb.Scheduling_ReceiveRequestsClient, error) {
+	stream, err := c.primary.ReceiveRequests(ctx, opts...)
+	if status.Code(err) != codes.Unavailable {
+		c.setCurrent(c.primary)
+		return stream, err
+	}
+
+	zerolog.Ctx(ctx).Warn().Err(err).Msg("scheduling endpoint unavailable, falling back to HTTP long polling")
+	c.setCurrent(c.fallback)
+	return c.fallback.ReceiveRequests(ctx, opts...)
+}
+
+func (c *fallbackSchedulingClient) Reset(ctx context.Context, req *schedpb.ResetRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	return c.getCurrent().Reset(ctx, req, opts...)
+}
+
+// httpSchedulingClient is a [schedpb.SchedulingClient] that implements the
+// long-polling protocol described above. gRPC call options are ignored.
+type httpSchedulingClient struct {
+	url          string
+	client       *http.Client
+	newSessionID func() string
+}
+
+func newHTTPSchedulingClient(baseURL string, client *http.Client) *httpSchedulingClient {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &httpSchedulingClient{
+		url:          strings.TrimSuffix(baseURL, "/"),
+		client:       client,
+		newSessionID: uuid.NewString,
+	}
+}
+
+func (c *httpSchedulingClient) Reset(ctx context.Context, req *schedpb.ResetRequest, _ ...grpc.CallOption) (*emptypb.Empty, error) {
+	if _, err := c.post(ctx, "/reset", req); err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (c *httpSchedulingClient) ReceiveRequests(ctx context.Context, _ ...grpc.CallOption) (schedpb.Scheduling_ReceiveRequestsClient, error) {
+	return &httpReceiveRequestsStream{
+		ctx:  ctx,
+		c:    c,
+		path: "/sessions/" + url.PathEscape(c.newSessionID()),
+	}, nil
+}
+
+// post sends `req` to `path` and returns the body of the response. Errors are
+// returned as gRPC status errors so that callers can treat them the same way
+// as errors from the gRPC transport.
+func (c *httpSchedulingClient) post(ctx context.Context, path string, req proto.Message) ([]byte, error) {
+	body, err := protojson.Marshal(req)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "encoding request: %v", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "creating request: %v", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	rsp, err := c.client.Do(httpReq)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, context.Cause(ctx)
+		}
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	defer rsp.Body.Close()
+
+	data, err := io.ReadAll(rsp.Body)
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "reading response: %v", err)
+	}
+	if rsp.StatusCode != http.StatusOK {
+		return nil, httpStatusError(rsp.StatusCode, data)
+	}
+	return data, nil
+}
+
+// httpStatusError converts an unsuccessful HTTP response into a gRPC status
+// error. If the body isn't a google.rpc.Status, the code is derived from the
+// HTTP status as described in
+// https://github.com/grpc/grpc/blob/master/doc/http-grpc-status-mapping.md.
+func httpStatusError(httpCode int, body []byte) error {
+	st := &spb.Status{}
+	if err := protojsonUnmarshalOpts.Unmarshal(body, st); err == nil && st.GetCode() != int32(codes.OK) {
+		return status.ErrorProto(st)
+	}
+
+	code := codes.Unknown
+	switch httpCode {
+	case http.StatusBadRequest:
+		code = codes.Internal
+	case http.StatusUnauthorized:
+		code = codes.Unauthenticated
+	case http.StatusForbidden:
+		code = codes.PermissionDenied
+	case http.StatusNotFound:
+		code = codes.Unimplemented
+	case http.StatusGone, http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		code = codes.Unavailable
+	}
+
+	if len(body) > maxErrorBodyLen {
+		body = body[:maxErrorBodyLen]
+	}
+	return status.Errorf(code, "HTTP %d: %s", httpCode, bytes.TrimSpace(body))
+}
+
+// httpReceiveRequestsStream emulates a ReceiveRequests stream using the
+// send and poll endpoints of a single session. As with a gRPC stream, Send
+// and Recv can be called concurrently with each other, but not with
+// themselves.
+type httpReceiveRequestsStream struct {
+	ctx     context.Context
+	c       *httpSchedulingClient
+	path    string
+	pending []*schedpb.ReceiveRequestsMessageFromController
+}
+
+func (s *httpReceiveRequestsStream) Send(msg *schedpb.ReceiveRequestsMessageToController) error {
+	_, err := s.c.post(s.ctx, s.path+"/send", msg)
+	return err
+}
+
+func (s *httpReceiveRequestsStream) Recv() (*schedpb.ReceiveRequestsMessageFromController, error) {
+	for len(s.pending) == 0 {
+		data, err := s.c.post(s.ctx, s.path+"/poll", &emptypb.Empty{})
+		if err != nil {
+			return nil, err
+		}
+
+		var rsp struct {
+			Messages []json.RawMessage `json:"messages"`
+		}
+		if err := json.Unmarshal(data, &rsp); err != nil {
+			return nil, status.Errorf(codes.Internal, "decoding poll response: %v", err)
+		}
+		for _, raw := range rsp.Messages {
+			msg := &schedpb.ReceiveRequestsMessageFromController{}
+			if err := protojsonUnmarshalOpts.Unmarshal(raw, msg); err != nil {
+				return nil, status.Errorf(codes.Internal, "decoding poll response: %v", err)
+			}
+			s.pending = append(s.pending, msg)
+		}
+	}
+
+	msg := s.pending[0]
+	s.pending = s.pending[1:]
+	return msg, nil
+}
+
+func (s *httpReceiveRequestsStream) Header() (metadata.MD, error) { return metadata.MD{}, nil }
+func (s *httpReceiveRequestsStream) Trailer() metadata.MD         { return nil }
+func (s *httpReceiveRequestsStream) CloseSend() error             { return nil }
+func (s *httpReceiveRequestsStream) Context() context.Context     { return s.ctx }
+
+func (s *httpReceiveRequestsStream) SendMsg(m any) error {
+	msg, ok := m.(*schedpb.ReceiveRequestsMessageToController)
+	if !ok {
+		return fmt.Errorf("unexpected message type %T", m)
+	}
+	return s.Send(msg)
+}
+
+func (s *httpReceiveRequestsStream) RecvMsg(m any) error {
+	dst, ok := m.(*schedpb.ReceiveRequestsMessageFromController)
+	if !ok {
+		return fmt.Errorf("unexpected message type %T", m)
+	}
+	msg, err := s.Recv()
+	if err != nil {
+		return err
+	}
+	proto.Merge(dst, msg)
+	return nil
+}
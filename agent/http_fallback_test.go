@@ -0,0 +1,223 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	schedpb "aalyria.com/spacetime/api/scheduling/v1alpha"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// longPollServer is a minimal implementation of the server side of the HTTP
+// fallback protocol for a single session.
+type longPollServer struct {
+	t       *testing.T
+	session string
+
+	mu     sync.Mutex
+	resets []*schedpb.ResetRequest
+	sent   []*schedpb.ReceiveRequestsMessageToController
+	// polls holds the messages returned by each successive poll.
+	polls [][]*schedpb.ReceiveRequestsMessageFromController
+}
+
+func (s *longPollServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	check(s.t, err)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.URL.Path {
+	case "/scheduling/reset":
+		req := &schedpb.ResetRequest{}
+		check(s.t, protojson.Unmarshal(body, req))
+		s.resets = append(s.resets, req)
+		w.Write([]byte("{}"))
+
+	case "/scheduling/sessions/" + s.session + "/send":
+		msg := &schedpb.ReceiveRequestsMessageToController{}
+		check(s.t, protojson.Unmarshal(body, msg))
+		s.sent = append(s.sent, msg)
+		w.Write([]byte("{}"))
+
+	case "/scheduling/sessions/" + s.session + "/poll":
+		if len(s.polls) == 0 {
+			w.WriteHeader(http.StatusGone)
+			w.Write([]byte(`{"code": 14, "message": "session ended"}`))
+			return
+		}
+		rsp := struct {
+			Messages []json.RawMessage `json:"messages"`
+		}{Messages: []json.RawMessage{}}
+		for _, msg := range s.polls[0] {
+			data, err := protojson.Marshal(msg)
+			check(s.t, err)
+			rsp.Messages = append(rsp.Messages, data)
+		}
+		s.polls = s.polls[1:]
+		check(s.t, json.NewEncoder(w).Encode(rsp))
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestHTTPSchedulingClient(t *testing.T) {
+	t.Parallel()
+
+	ctx := baseContext(t)
+	first := &schedpb.ReceiveRequestsMessageFromController{RequestId: 1}
+	second := &schedpb.ReceiveRequestsMessageFromController{RequestId: 2}
+	srv := &longPollServer{
+		t:       t,
+		session: "session-a",
+		polls: [][]*schedpb.ReceiveRequestsMessageFromController{
+			// The first poll times out without any messages.
+			{},
+			{first, second},
+		},
+	}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	c := newHTTPSchedulingClient(ts.URL+"/scheduling/", ts.Client())
+	c.newSessionID = func() string { return "session-a" }
+
+	reset := &schedpb.ResetRequest{AgentId: "agent-a", ScheduleManipulationToken: "token"}
+	if _, err := c.Reset(ctx, reset); err != nil {
+		t.Fatalf("Reset() failed: %v", err)
+	}
+
+	stream, err := c.ReceiveRequests(ctx)
+	check(t, err)
+
+	hello := &schedpb.ReceiveRequestsMessageToController{
+		Hello: &schedpb.ReceiveRequestsMessageToController_Hello{AgentId: "agent-a"},
+	}
+	check(t, stream.Send(hello))
+
+	for _, want := range []*schedpb.ReceiveRequestsMessageFromController{first, second} {
+		got, err := stream.Recv()
+		check(t, err)
+		assertProtosEqual(t, want, got)
+	}
+
+	if _, err := stream.Recv(); status.Code(err) != codes.Unavailable {
+		t.Errorf("Recv() after the session ended returned %v, want an Unavailable error", err)
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	assertProtosEqual(t, []*schedpb.ResetRequest{reset}, srv.resets)
+	assertProtosEqual(t, []*schedpb.ReceiveRequestsMessageToController{hello}, srv.sent)
+}
+
+func TestHTTPStatusError(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		desc     string
+		httpCode int
+		body     string
+		want     codes.Code
+	}{
+		{desc: "status body", httpCode: http.StatusBadRequest, body: `{"code": 3, "message": "bad"}`, want: codes.InvalidArgument},
+		{desc: "unauthorized", httpCode: http.StatusUnauthorized, body: "nope", want: codes.Unauthenticated},
+		{desc: "not found", httpCode: http.StatusNotFound, body: "<html></html>", want: codes.Unimplemented},
+		{desc: "session ended", httpCode: http.StatusGone, want: codes.Unavailable},
+		{desc: "bad gateway", httpCode: http.StatusBadGateway, want: codes.Unavailable},
+		{desc: "teapot", httpCode: http.StatusTeapot, want: codes.Unknown},
+	} {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			if got := status.Code(httpStatusError(tc.httpCode, []byte(tc.body))); got != tc.want {
+				t.Errorf("httpStatusError(%d, %q) has code %v, want %v", tc.httpCode, tc.body, got, tc.want)
+			}
+		})
+	}
+}
+
+// stubSchedulingClient records the calls made to it and fails to open
+// streams with `streamErr`.
+type stubSchedulingClient struct {
+	streamErr error
+	resets    []*schedpb.ResetRequest
+}
+
+func (s *stubSchedulingClient) ReceiveRequests(context.Context, ...grpc.CallOption) (schedpb.Scheduling_ReceiveRequestsClient, error) {
+	if s.streamErr != nil {
+		return nil, s.streamErr
+	}
+	return &httpReceiveRequestsStream{}, nil
+}
+
+func (s *stubSchedulingClient) Reset(_ context.Context, req *schedpb.ResetRequest, _ ...grpc.CallOption) (*emptypb.Empty, error) {
+	s.resets = append(s.resets, req)
+	return &emptypb.Empty{}, nil
+}
+
+func TestFallbackSchedulingClient(t *testing.T) {
+	t.Parallel()
+
+	ctx := baseContext(t)
+	primary, fallback := &stubSchedulingClient{}, &stubSchedulingClient{}
+	c := newFallbackSchedulingClient(primary, fallback)
+
+	openAndReset := func(token string) {
+		t.Helper()
+		if _, err := c.ReceiveRequests(ctx); err != nil {
+			t.Fatalf("ReceiveRequests() failed: %v", err)
+		}
+		if _, err := c.Reset(ctx, &schedpb.ResetRequest{ScheduleManipulationToken: token}); err != nil {
+			t.Fatalf("Reset() failed: %v", err)
+		}
+	}
+
+	openAndReset("primary-1")
+	primary.streamErr = status.Error(codes.Unavailable, "connection refused")
+	openAndReset("fallback-1")
+	primary.streamErr = nil
+	openAndReset("primary-2")
+
+	tokens := func(reqs []*schedpb.ResetRequest) []string {
+		toks := []string{}
+		for _, r := range reqs {
+			toks = append(toks, r.GetScheduleManipulationToken())
+		}
+		return toks
+	}
+	assertProtosEqual(t, []string{"primary-1", "primary-2"}, tokens(primary.resets))
+	assertProtosEqual(t, []string{"fallback-1"}, tokens(fallback.resets))
+
+	primary.streamErr = status.Error(codes.Unauthenticated, "bad token")
+	if _, err := c.ReceiveRequests(ctx); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("ReceiveRequests() returned %v, want the primary client's Unauthenticated error", err)
+	}
+}
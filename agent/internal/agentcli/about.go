@@ -0,0 +1,58 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agentcli
+
+import (
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"aalyria.com/spacetime/about"
+)
+
+// runAbout implements the `about` subcommand, which describes the
+// third-party modules built into the agent and their licenses: `about
+// licenses` lists them, and `about sbom` prints them as an SPDX document.
+func (ac AgentConf) runAbout(appName string, args []string) error {
+	usage := fmt.Errorf("usage: %s about <licenses|sbom>", appName)
+	if len(args) != 1 {
+		return usage
+	}
+
+	switch args[0] {
+	case "licenses":
+		mods, err := about.Modules()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(ac.Handles.Stdout(), 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "MODULE\tVERSION\tLICENSE")
+		for _, m := range mods {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", m.Path, m.Version, m.License)
+		}
+		return w.Flush()
+
+	case "sbom":
+		doc, err := about.SBOM(appName, time.Now())
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(ac.Handles.Stdout(), "%s\n", doc)
+		return err
+
+	default:
+		return usage
+	}
+}
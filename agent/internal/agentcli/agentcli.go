@@ -20,6 +20,8 @@ import (
 	"bytes"
 	"cmp"
 	"context"
+	"crypto"
+	"crypto/tls"
 	"crypto/x509"
 	"errors"
 	"flag"
@@ -32,6 +34,7 @@ import (
 	"os"
 	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/jonboulle/clockwork"
@@ -50,18 +53,29 @@ import (
 	channelz "google.golang.org/grpc/channelz/service"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/credentials/local"
 	"google.golang.org/grpc/encoding/gzip"
 	"google.golang.org/protobuf/types/known/anypb"
 
 	agent "aalyria.com/spacetime/agent"
+	"aalyria.com/spacetime/agent/clocksync"
 	"aalyria.com/spacetime/agent/enactment"
 	enact_extproc "aalyria.com/spacetime/agent/enactment/extproc"
+	"aalyria.com/spacetime/agent/eventlog"
 	"aalyria.com/spacetime/agent/internal/configpb"
+	"aalyria.com/spacetime/agent/internal/logging"
 	"aalyria.com/spacetime/agent/internal/protofmt"
+	"aalyria.com/spacetime/agent/internal/sdnotify"
 	"aalyria.com/spacetime/agent/internal/task"
 	"aalyria.com/spacetime/agent/telemetry"
 	telemetry_extproc "aalyria.com/spacetime/agent/telemetry/extproc"
 	"aalyria.com/spacetime/auth"
+	"aalyria.com/spacetime/auth/awskms"
+	"aalyria.com/spacetime/auth/gcpkms"
+	"aalyria.com/spacetime/auth/pkcs11"
+	"aalyria.com/spacetime/auth/vault"
+	"aalyria.com/spacetime/dialproxy"
+	"aalyria.com/spacetime/inproc"
 )
 
 // Handles are abstractions over impure, external resources like time and stdio
@@ -112,31 +126,55 @@ type AgentConf struct {
 	AppName   string
 	Handles   Handles
 	Providers []Provider
+	// QUICDialer is used for connections that select the experimental QUIC
+	// transport. If nil, those connections fail with [ErrQUICUnsupported].
+	QUICDialer QUICDialer
 }
 
 func (ac AgentConf) Run(ctx context.Context, appName string, args []string) (err error) {
-	var log zerolog.Logger
-	if os.Getenv("TERM") != "" {
-		log = zerolog.New(zerolog.ConsoleWriter{Out: ac.Handles.Stderr(), TimeFormat: "2006-01-02 03:04:05PM"})
-	} else {
-		log = zerolog.New(ac.Handles.Stderr())
+	interactive := os.Getenv("TERM") != ""
+	log, err := logging.New(ac.Handles.Stderr(), logging.FormatAuto, interactive)
+	if err != nil {
+		return err
+	}
+	ctx = log.WithContext(ctx)
+
+	if len(args) > 0 && args[0] == "events" {
+		ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+		defer stop()
+		return ac.runEvents(ctx, appName, args[1:])
+	}
+	if len(args) > 0 && args[0] == "collect" {
+		ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+		defer stop()
+		return ac.runCollect(ctx, appName+" collect", args[1:])
+	}
+	if len(args) > 0 && args[0] == "about" {
+		return ac.runAbout(appName, args[1:])
 	}
-	ctx = log.With().Timestamp().Logger().WithContext(ctx)
 
 	fs := flag.NewFlagSet(appName, flag.ContinueOnError)
 	fs.SetOutput(ac.Handles.Stderr())
 	fs.Usage = func() {
 		w := fs.Output()
 		fmt.Fprintf(w, "Usage: %s [options]\n", appName)
+		fmt.Fprintf(w, "       %s events <tail|export|compact> [options]\n", appName)
+		fmt.Fprintf(w, "       %s collect [options]\n", appName)
+		fmt.Fprintf(w, "       %s about <licenses|sbom>\n", appName)
 		fmt.Fprint(w, "\nOptions:\n")
 		fs.PrintDefaults()
 	}
 
 	confPath := fs.String("config", "", "The path to a protobuf representation of the agent's configuration (an AgentParams message).")
-	protoFormat := fs.String("format", "text", "The format (one of text, wire, or json) to read the configuration as.")
+	protoFormat := fs.String("format", "text", "The format (one of text, wire, json, or yaml) to read the configuration as.")
 	dryRunOnly := fs.Bool("dry-run", false, "Just validate the config, don't start the agent. Exits with a non-zero return code if the config is invalid.")
+	pprofAddr := fs.String("pprof-addr", "", "The address (host:port) to serve net/http/pprof on. Overrides observability_params.pprof_address from the config.")
 	logLevel := logLevelFlag(zerolog.InfoLevel)
 	fs.Var(&logLevel, "log-level", "The log level (one of disabled, warn, panic, info, fatal, error, debug, or trace) to use.")
+	logFormat := fs.String("log-format", logging.FormatAuto, "The format (one of auto, json, or console) to write logs in. auto writes console logs if TERM is set, and JSON logs otherwise.")
+	logModules := logging.Levels{}
+	fs.Var(logModules, "log-modules", "A comma-separated list of MODULE=LEVEL pairs (e.g. enactment=debug,telemetry=warn) that override --log-level for the logs of individual modules: enactment, telemetry, clocksync, remoteops, health, or config.")
+	reload := fs.Bool("reload", true, "Watch the config for changes. Changes to the logging params and to the clock_sync max_skew and check_interval are applied without restarting; other changes require a restart.")
 	if err := fs.Parse(args); err == flag.ErrHelp {
 		fs.Usage()
 		return nil
@@ -148,10 +186,33 @@ func (ac AgentConf) Run(ctx context.Context, appName string, args []string) (err
 	if err != nil {
 		return err
 	}
-	log = (*zerolog.Ctx(ctx)).Level(zerolog.Level(logLevel))
-	ctx = log.WithContext(ctx)
+	override := func(params *configpb.AgentParams) {
+		if *pprofAddr != "" {
+			if params.ObservabilityParams == nil {
+				params.ObservabilityParams = &configpb.ObservabilityParams{}
+			}
+			params.ObservabilityParams.PprofAddress = *pprofAddr
+		}
+	}
+	override(params)
+
+	logFlags := logOverrides{modules: logModules}
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "log-level" {
+			logFlags.level = (*zerolog.Level)(&logLevel)
+		}
+	})
+	level, modules, err := logFlags.levels(params)
+	if err != nil {
+		return err
+	}
+	logs := logging.NewConfig(level, modules)
+	if log, err = logging.New(ac.Handles.Stderr(), *logFormat, interactive); err != nil {
+		return fmt.Errorf("bad --log-format: %w", err)
+	}
+	ctx = logging.WithConfig(log.WithContext(ctx), logs)
 	if *dryRunOnly {
-		log.Info().Msg("config is valid")
+		zerolog.Ctx(ctx).Info().Msg("config is valid")
 		return nil
 	}
 
@@ -161,7 +222,8 @@ func (ac AgentConf) Run(ctx context.Context, appName string, args []string) (err
 	}
 	defer shutdownTracer()
 
-	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	// systemd stops services with SIGTERM.
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
 	g, ctx := errgroup.WithContext(ctx)
@@ -177,8 +239,28 @@ func (ac AgentConf) Run(ctx context.Context, appName string, args []string) (err
 		}
 		return nil
 	})
+	var reloader *configReloader
+	if *reload {
+		pf, _ := protofmt.FromString(*protoFormat)
+		reloader = &configReloader{
+			path:     *confPath,
+			format:   pf,
+			override: override,
+			logs:     logs,
+			logFlags: logFlags,
+			running:  params,
+			applied:  params,
+		}
+		g.Go(func() error {
+			w, err := reloader.watch()
+			if err != nil {
+				return err
+			}
+			return reloader.run(ctx, w)
+		})
+	}
 	g.Go(func() error {
-		if err := ac.runAgent(ctx, params); err != nil {
+		if err := ac.runAgent(ctx, params, reloader); err != nil {
 			return fmt.Errorf("running agent: %w", err)
 		}
 		return nil
@@ -221,12 +303,15 @@ func readParams(confPath, protoFormat string) (*configpb.AgentParams, error) {
 		return nil, errors.New("empty config (--config) provided")
 	}
 
+	return parseParams(confData, pf)
+}
+
+func parseParams(confData []byte, pf protofmt.Format) (*configpb.AgentParams, error) {
 	conf := &configpb.AgentParams{}
-	if err = pf.Unmarshal(confData, conf); err != nil {
+	if err := pf.Unmarshal(confData, conf); err != nil {
 		return nil, fmt.Errorf("unmarshalling config proto: %w", err)
 	}
-
-	return conf, err
+	return conf, nil
 }
 
 func injectTracer(ctx context.Context, params *configpb.AgentParams) (newCtx context.Context, shutdown func(), err error) {
@@ -270,7 +355,7 @@ func injectTracer(ctx context.Context, params *configpb.AgentParams) (newCtx con
 	return ctx, shutdown, nil
 }
 
-func getPrivateKey(ss *configpb.SigningStrategy) (io.Reader, error) {
+func getPrivateKey(ctx context.Context, ss *configpb.SigningStrategy) (io.Reader, error) {
 	switch ss.Type.(type) {
 	case *configpb.SigningStrategy_PrivateKeyBytes:
 		return bytes.NewBuffer(ss.GetPrivateKeyBytes()), nil
@@ -282,11 +367,82 @@ func getPrivateKey(ss *configpb.SigningStrategy) (io.Reader, error) {
 		}
 		return bytes.NewBuffer(b), nil
 
+	case *configpb.SigningStrategy_VaultKv_:
+		b, err := vault.FetchPrivateKey(ctx, vault.KVConfig{
+			Mount: ss.GetVaultKv().GetMount(),
+			Path:  ss.GetVaultKv().GetPath(),
+			Field: ss.GetVaultKv().GetField(),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("reading private key from Vault: %w", err)
+		}
+		return bytes.NewBuffer(b), nil
+
 	default:
 		return nil, errors.New("no signing strategy provided")
 	}
 }
 
+// getSigner returns the signer described by the signing strategy, or nil if
+// it provides the private key itself. Signers are closed once `ctx` is done,
+// since they're needed to refresh tokens for as long as the agent runs.
+func getSigner(ctx context.Context, ss *configpb.SigningStrategy) (crypto.Signer, error) {
+	switch ss.Type.(type) {
+	case *configpb.SigningStrategy_Pkcs11_:
+		p11 := ss.GetPkcs11()
+		c := pkcs11.Config{
+			ModulePath: p11.GetModulePath(),
+			TokenLabel: p11.GetTokenLabel(),
+			KeyLabel:   p11.GetKeyLabel(),
+			KeyID:      p11.GetKeyId(),
+			PINEnvVar:  p11.GetPinEnvVar(),
+		}
+		if p11.Slot != nil {
+			slot := int(p11.GetSlot())
+			c.Slot = &slot
+		}
+		signer, err := pkcs11.NewSigner(c)
+		if err != nil {
+			return nil, fmt.Errorf("opening PKCS #11 signing key: %w", err)
+		}
+		context.AfterFunc(ctx, func() { signer.Close() })
+		return signer, nil
+
+	case *configpb.SigningStrategy_AwsKms_:
+		signer, err := awskms.NewSigner(ctx, awskms.Config{
+			KeyID:  ss.GetAwsKms().GetKeyId(),
+			Region: ss.GetAwsKms().GetRegion(),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("opening AWS KMS signing key: %w", err)
+		}
+		return signer, nil
+
+	case *configpb.SigningStrategy_GcpKms_:
+		signer, err := gcpkms.NewSigner(ctx, gcpkms.Config{
+			KeyVersionName: ss.GetGcpKms().GetKeyVersionName(),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("opening Cloud KMS signing key: %w", err)
+		}
+		context.AfterFunc(ctx, func() { signer.Close() })
+		return signer, nil
+
+	case *configpb.SigningStrategy_VaultTransit_:
+		signer, err := vault.NewTransitSigner(ctx, vault.TransitConfig{
+			Mount:   ss.GetVaultTransit().GetMount(),
+			KeyName: ss.GetVaultTransit().GetKeyName(),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("opening Vault Transit signing key: %w", err)
+		}
+		return signer, nil
+
+	default:
+		return nil, nil
+	}
+}
+
 func getProtoFmt(pfpb configpb.NetworkNode_ExternalCommand_ProtoFormat) protofmt.Format {
 	switch pfpb.Enum() {
 	case configpb.NetworkNode_ExternalCommand_JSON.Enum():
@@ -303,7 +459,154 @@ func getProtoFmt(pfpb configpb.NetworkNode_ExternalCommand_ProtoFormat) protofmt
 	}
 }
 
-func getDialOpts(ctx context.Context, connParams *configpb.ConnectionParams, clock clockwork.Clock) ([]grpc.DialOption, error) {
+// remoteOpsOption listens on the configured address and returns the
+// AgentOption that serves the AgentOps service on it, requiring callers to
+// present a client certificate signed by one of the configured CAs.
+func remoteOpsOption(ctx context.Context, ro *configpb.RemoteOpsParams) (agent.AgentOption, error) {
+	switch {
+	case ro.GetListenAddress() == "":
+		return nil, errors.New("a listen_address is required")
+	case ro.GetCertFile() == "" || ro.GetKeyFile() == "":
+		return nil, errors.New("a cert_file and key_file are required")
+	case ro.GetClientCaFile() == "":
+		return nil, errors.New("a client_ca_file is required")
+	case len(ro.GetAllowedIdentities()) == 0:
+		return nil, errors.New("at least one of allowed_identities is required")
+	}
+
+	cert, err := tls.LoadX509KeyPair(ro.GetCertFile(), ro.GetKeyFile())
+	if err != nil {
+		return nil, fmt.Errorf("loading server certificate: %w", err)
+	}
+	pemBytes, err := os.ReadFile(ro.GetClientCaFile())
+	if err != nil {
+		return nil, fmt.Errorf("reading client CA bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemBytes) {
+		return nil, fmt.Errorf("no PEM-encoded certificates found in client CA bundle %s", ro.GetClientCaFile())
+	}
+
+	lis, err := (&net.ListenConfig{}).Listen(ctx, "tcp", ro.GetListenAddress())
+	if err != nil {
+		return nil, err
+	}
+	creds := credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	})
+	return agent.WithRemoteOps(lis, ro.GetAllowedIdentities(), grpc.Creds(creds)), nil
+}
+
+func telemetryQueueConfig(tq *configpb.TelemetryQueueParams) agent.TelemetryQueueConfig {
+	cfg := agent.TelemetryQueueConfig{
+		Size:          int(tq.GetSize()),
+		MaxBatch:      int(tq.GetMaxBatch()),
+		DeltaEncoding: tq.GetDeltaEncoding(),
+	}
+	switch tq.GetDropPolicy() {
+	case configpb.TelemetryQueueParams_DROP_NEWEST:
+		cfg.DropPolicy = agent.DropNewest
+	case configpb.TelemetryQueueParams_DROP_LOWEST_PRIORITY:
+		cfg.DropPolicy = agent.DropLowestPriority
+	default:
+		cfg.DropPolicy = agent.DropOldest
+	}
+	return cfg
+}
+
+// healthCheckOption listens on the configured addresses and returns the
+// AgentOption that serves the health endpoints on them.
+func healthCheckOption(ctx context.Context, hc *configpb.HealthCheckParams) (agent.AgentOption, error) {
+	if hc.GetGrpcAddress() == "" && hc.GetHttpAddress() == "" {
+		return nil, errors.New("at least one of grpc_address and http_address is required")
+	}
+	if hc.GetMaxUnhealthy().AsDuration() < 0 {
+		return nil, fmt.Errorf("max_unhealthy can't be negative, got %v", hc.GetMaxUnhealthy().AsDuration())
+	}
+
+	var grpcLis, httpLis net.Listener
+	lc := &net.ListenConfig{}
+	if addr := hc.GetGrpcAddress(); addr != "" {
+		lis, err := lc.Listen(ctx, "tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		grpcLis = lis
+	}
+	if addr := hc.GetHttpAddress(); addr != "" {
+		lis, err := lc.Listen(ctx, "tcp", addr)
+		if err != nil {
+			if grpcLis != nil {
+				grpcLis.Close()
+			}
+			return nil, err
+		}
+		httpLis = lis
+	}
+	return agent.WithHealthServer(grpcLis, httpLis, hc.GetMaxUnhealthy().AsDuration()), nil
+}
+
+// getTLSConfig returns the TLS configuration described by the connection's
+// transport_security, or nil if the connection is insecure.
+func getTLSConfig(ctx context.Context, connParams *configpb.ConnectionParams) (*tls.Config, error) {
+	switch connParams.GetTransportSecurity().GetType().(type) {
+	case *configpb.ConnectionParams_TransportSecurity_Insecure:
+		return nil, nil
+
+	case *configpb.ConnectionParams_TransportSecurity_SystemCertPool:
+		cp, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, fmt.Errorf("reading system tls cert pool: %w", err)
+		}
+		return &tls.Config{RootCAs: cp}, nil
+
+	case *configpb.ConnectionParams_TransportSecurity_MutualTls_:
+		mtls := connParams.GetTransportSecurity().GetMutualTls()
+		tlsConf, err := auth.NewMutualTLSConfig(auth.MutualTLSConfig{
+			ClientCertFile: mtls.GetClientCertFile(),
+			ClientKeyFile:  mtls.GetClientKeyFile(),
+			CABundleFile:   mtls.GetCaBundleFile(),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("creating mutual TLS credentials: %w", err)
+		}
+		return tlsConf, nil
+
+	case *configpb.ConnectionParams_TransportSecurity_Spiffe_:
+		spiffe := connParams.GetTransportSecurity().GetSpiffe()
+		tlsConf, closer, err := auth.NewSPIFFETLSConfig(ctx, auth.SPIFFEConfig{
+			WorkloadAPIAddr: spiffe.GetWorkloadApiAddr(),
+			ServerID:        spiffe.GetServerId(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		// The X.509 source keeps a stream open to the Workload API for
+		// rotation, so it lives as long as the agent does.
+		context.AfterFunc(ctx, func() { closer.Close() })
+		return tlsConf, nil
+
+	default:
+		return nil, errors.New("no transport security selection provided")
+	}
+}
+
+// endpointHost returns the host named by a gRPC endpoint URI, without the
+// port.
+func endpointHost(endpointURI string) string {
+	host := endpointURI
+	if uri, err := url.Parse(endpointURI); err == nil {
+		host = strings.TrimPrefix(cmp.Or(uri.Host, uri.Path), "/")
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
+
+func getDialOpts(ctx context.Context, connParams *configpb.ConnectionParams, clock clockwork.Clock, quicDialer QUICDialer) ([]grpc.DialOption, error) {
 	tracerProvider, _ := task.ExtractTracerProvider(ctx)
 
 	dialOpts := []grpc.DialOption{
@@ -330,89 +633,165 @@ func getDialOpts(ctx context.Context, connParams *configpb.ConnectionParams, clo
 		grpcConnParams.MinConnectTimeout = minConnectTimeout
 	}
 
-	switch connParams.GetTransportSecurity().GetType().(type) {
-	case *configpb.ConnectionParams_TransportSecurity_Insecure:
-		dialOpts = append(dialOpts, grpc.WithTransportCredentials(insecure.NewCredentials()))
-
-	case *configpb.ConnectionParams_TransportSecurity_SystemCertPool:
-		cp, err := x509.SystemCertPool()
+	endpoint := connParams.GetEndpointUri()
+	switch {
+	case connParams.GetQuic() != nil:
+		quicOpts, err := getQUICDialOpts(ctx, connParams, quicDialer)
 		if err != nil {
-			return nil, fmt.Errorf("reading system tls cert pool: %w", err)
+			return nil, err
 		}
-		dialOpts = append(dialOpts, grpc.WithTransportCredentials(credentials.NewClientTLSFromCert(cp, "")))
+		dialOpts = append(dialOpts, quicOpts...)
+
+	case strings.HasPrefix(endpoint, inproc.Scheme+":"):
+		// A controller embedded in the same process, which is connected to
+		// without the network, so the proxy and transport security don't
+		// apply.
+		dialOpts = append(dialOpts, inproc.DialOptions()...)
 
 	default:
-		return nil, errors.New("no transport security selection provided")
+		proxyOpts, err := dialproxy.DialOptions(dialproxy.Config{URL: connParams.GetProxyUrl()})
+		if err != nil {
+			return nil, fmt.Errorf("configuring the proxy: %w", err)
+		}
+		dialOpts = append(dialOpts, proxyOpts...)
+
+		tlsConf, err := getTLSConfig(ctx, connParams)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case tlsConf != nil:
+			dialOpts = append(dialOpts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConf)))
+		case strings.HasPrefix(endpoint, "unix:"):
+			// Unlike insecure credentials, local credentials allow JWTs to
+			// be sent over the socket, which doesn't leave the host.
+			dialOpts = append(dialOpts, grpc.WithTransportCredentials(local.NewCredentials()))
+		default:
+			dialOpts = append(dialOpts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		}
 	}
 
 	dialOpts = append(dialOpts, grpc.WithConnectParams(grpcConnParams))
 
-	switch authStrat := connParams.GetAuthStrategy(); authStrat.Type.(type) {
+	creds, err := getPerRPCCredentials(ctx, connParams, clock)
+	if err != nil {
+		return nil, err
+	}
+	if creds != nil {
+		dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(creds))
+	}
+
+	return dialOpts, nil
+}
+
+// getPerRPCCredentials returns the credentials described by the
+// connection's auth_strategy, or nil if requests shouldn't be authenticated.
+func getPerRPCCredentials(ctx context.Context, connParams *configpb.ConnectionParams, clock clockwork.Clock) (credentials.PerRPCCredentials, error) {
+	switch authStrat := connParams.GetAuthStrategy(); authStrat.GetType().(type) {
 	case *configpb.AuthStrategy_None:
 		// ¯\_(ツ)_/¯
+		return nil, nil
 
 	case *configpb.AuthStrategy_Jwt_:
 		jwtSpec := authStrat.GetJwt()
-		pkeySrc, err := getPrivateKey(jwtSpec.GetSigningStrategy())
-		if err != nil {
-			return nil, err
-		}
 		uri, err := url.Parse(connParams.GetEndpointUri())
 		if err != nil {
 			return nil, fmt.Errorf("parsing %q: %w", connParams.GetEndpointUri(), err)
 		}
-
-		creds, err := auth.NewCredentials(ctx, auth.Config{
+		authConf := auth.Config{
 			Clock:        clock,
 			Email:        jwtSpec.GetEmail(),
 			PrivateKeyID: jwtSpec.GetPrivateKeyId(),
-			PrivateKey:   pkeySrc,
 			Host:         strings.TrimPrefix(cmp.Or(uri.Host, uri.Path), "/"),
-		})
+		}
+
+		signer, err := getSigner(ctx, jwtSpec.GetSigningStrategy())
+		if err != nil {
+			return nil, err
+		}
+		if signer != nil {
+			authConf.Signer = signer
+		} else {
+			pkeySrc, err := getPrivateKey(ctx, jwtSpec.GetSigningStrategy())
+			if err != nil {
+				return nil, err
+			}
+			authConf.PrivateKey = pkeySrc
+		}
+
+		creds, err := auth.NewCredentials(ctx, authConf)
 		if err != nil {
 			return nil, fmt.Errorf("generating authorization JWT: %w", err)
 		}
-		dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(creds))
+		return creds, nil
 
 	default:
 		return nil, errors.New("no auth_strategy provided")
 	}
+}
 
-	return dialOpts, nil
+// getHTTPFallbackClient returns an [http.Client] for the connection's
+// http_fallback that uses the same transport security and auth strategy as
+// the gRPC connection. HTTP/2 is disabled, since the fallback exists for
+// networks that don't support it.
+func getHTTPFallbackClient(ctx context.Context, connParams *configpb.ConnectionParams, clock clockwork.Clock) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ForceAttemptHTTP2 = false
+	transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+
+	proxy, err := dialproxy.HTTPProxy(dialproxy.Config{URL: connParams.GetProxyUrl()})
+	if err != nil {
+		return nil, fmt.Errorf("configuring the proxy: %w", err)
+	}
+	transport.Proxy = proxy
+
+	tlsConf, err := getTLSConfig(ctx, connParams)
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSClientConfig = tlsConf
+
+	creds, err := getPerRPCCredentials(ctx, connParams, clock)
+	if err != nil {
+		return nil, err
+	}
+	if creds == nil {
+		return &http.Client{Transport: transport}, nil
+	}
+	rt, err := auth.NewRoundTripper(creds, transport)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: rt}, nil
 }
 
-func (ac *AgentConf) getNodeOpts(ctx context.Context, node *configpb.NetworkNode, clock clockwork.Clock) (nodeOpts []agent.NodeOption, err error) {
+func (ac *AgentConf) getNodeOpts(ctx context.Context, node *configpb.NetworkNode, conns *connections) (nodeOpts []agent.NodeOption, err error) {
+	clock := conns.clock
+
 enactmentSwitch:
 	switch conf := node.GetEnactmentDriver().GetType().(type) {
 	case *configpb.NetworkNode_EnactmentDriver_ExternalCommand:
-		dialOpts, err := getDialOpts(ctx, node.EnactmentDriver.GetConnectionParams(), clock)
-		if err != nil {
-			return nil, err
-		}
-
 		enactCmd := node.GetEnactmentDriver().GetExternalCommand()
 		ed := enact_extproc.New(enactCmd.GetArgs(), getProtoFmt(enactCmd.GetProtoFormat()))
 
-		nodeOpts = append(nodeOpts, agent.WithEnactmentDriver(node.GetEnactmentDriver().GetConnectionParams().EndpointUri, ed, dialOpts...))
-
-	case *configpb.NetworkNode_EnactmentDriver_Netlink:
-		dialOpts, err := getDialOpts(ctx, node.EnactmentDriver.GetConnectionParams(), clock)
+		opt, err := conns.enactmentDriver(ctx, node.GetEnactmentDriver().GetConnectionParams(), ed)
 		if err != nil {
 			return nil, err
 		}
+		nodeOpts = append(nodeOpts, opt)
 
+	case *configpb.NetworkNode_EnactmentDriver_Netlink:
 		ed, err := newNetlinkEnactmentDriver(ctx, clock, node.GetId(), conf.Netlink)
 		if err != nil {
 			return nil, err
 		}
-		nodeOpts = append(nodeOpts, agent.WithEnactmentDriver(node.GetEnactmentDriver().GetConnectionParams().EndpointUri, ed, dialOpts...))
-
-	case *configpb.NetworkNode_EnactmentDriver_Dynamic:
-		dialOpts, err := getDialOpts(ctx, node.EnactmentDriver.GetConnectionParams(), clock)
+		opt, err := conns.enactmentDriver(ctx, node.GetEnactmentDriver().GetConnectionParams(), ed)
 		if err != nil {
 			return nil, err
 		}
+		nodeOpts = append(nodeOpts, opt)
 
+	case *configpb.NetworkNode_EnactmentDriver_Dynamic:
 		for _, p := range ac.Providers {
 			ed, err := p.EnactmentDriver(ctx, ac.Handles, node.GetId(), conf.Dynamic)
 			if errors.Is(err, ErrUnknownConfigProto) {
@@ -421,43 +800,52 @@ enactmentSwitch:
 				return nil, err
 			}
 
-			nodeOpts = append(nodeOpts, agent.WithEnactmentDriver(node.GetEnactmentDriver().GetConnectionParams().EndpointUri, ed, dialOpts...))
+			opt, err := conns.enactmentDriver(ctx, node.GetEnactmentDriver().GetConnectionParams(), ed)
+			if err != nil {
+				return nil, err
+			}
+			nodeOpts = append(nodeOpts, opt)
 			break enactmentSwitch
 		}
 
 		return nil, fmt.Errorf("no provider recognized proto of type %s for node %s", conf.Dynamic.GetTypeUrl(), node.GetId())
 	}
 
-telemetrySwitch:
-	switch conf := node.GetTelemetryDriver().GetType().(type) {
-	case *configpb.NetworkNode_TelemetryDriver_ExternalCommand:
-		dialOpts, err := getDialOpts(ctx, node.TelemetryDriver.GetConnectionParams(), clock)
+	if connParams := node.GetEnactmentDriver().GetConnectionParams(); connParams.GetHttpFallback().GetUrl() != "" {
+		client, err := getHTTPFallbackClient(ctx, connParams, clock)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("configuring the HTTP fallback: %w", err)
 		}
+		nodeOpts = append(nodeOpts, agent.WithEnactmentHTTPFallback(connParams.GetHttpFallback().GetUrl(), client))
+	}
+	if dir := node.GetEnactmentDriver().GetScheduleStateDir(); dir != "" {
+		nodeOpts = append(nodeOpts, agent.WithScheduleStateDir(dir))
+	}
 
+telemetrySwitch:
+	switch conf := node.GetTelemetryDriver().GetType().(type) {
+	case *configpb.NetworkNode_TelemetryDriver_ExternalCommand:
 		telCmd := node.GetTelemetryDriver().GetExternalCommand()
 		td := telemetry_extproc.NewDriver(telCmd.GetCommand().GetArgs(), getProtoFmt(telCmd.GetCommand().GetProtoFormat()), telCmd.GetCollectionPeriod().AsDuration())
-		nodeOpts = append(nodeOpts, agent.WithTelemetryDriver(node.GetTelemetryDriver().GetConnectionParams().EndpointUri, td, dialOpts...))
 
-	case *configpb.NetworkNode_TelemetryDriver_Netlink:
-		dialOpts, err := getDialOpts(ctx, node.TelemetryDriver.GetConnectionParams(), clock)
+		opt, err := conns.telemetryDriver(ctx, node.GetTelemetryDriver().GetConnectionParams(), td)
 		if err != nil {
 			return nil, err
 		}
+		nodeOpts = append(nodeOpts, opt)
 
+	case *configpb.NetworkNode_TelemetryDriver_Netlink:
 		td, err := newNetlinkTelemetryDriver(ctx, clock, node.GetId(), conf.Netlink)
 		if err != nil {
 			return nil, err
 		}
-		nodeOpts = append(nodeOpts, agent.WithTelemetryDriver(node.GetTelemetryDriver().GetConnectionParams().EndpointUri, td, dialOpts...))
-
-	case *configpb.NetworkNode_TelemetryDriver_Dynamic:
-		dialOpts, err := getDialOpts(ctx, node.TelemetryDriver.GetConnectionParams(), clock)
+		opt, err := conns.telemetryDriver(ctx, node.GetTelemetryDriver().GetConnectionParams(), td)
 		if err != nil {
 			return nil, err
 		}
+		nodeOpts = append(nodeOpts, opt)
 
+	case *configpb.NetworkNode_TelemetryDriver_Dynamic:
 		for _, p := range ac.Providers {
 			td, err := p.TelemetryDriver(ctx, ac.Handles, node.GetId(), conf.Dynamic)
 			if errors.Is(err, ErrUnknownConfigProto) {
@@ -466,7 +854,11 @@ telemetrySwitch:
 				return nil, err
 			}
 
-			nodeOpts = append(nodeOpts, agent.WithTelemetryDriver(node.GetTelemetryDriver().GetConnectionParams().EndpointUri, td, dialOpts...))
+			opt, err := conns.telemetryDriver(ctx, node.GetTelemetryDriver().GetConnectionParams(), td)
+			if err != nil {
+				return nil, err
+			}
+			nodeOpts = append(nodeOpts, opt)
 			break telemetrySwitch
 		}
 		return nil, fmt.Errorf("no provider recognized proto of type %s for node %s", conf.Dynamic.GetTypeUrl(), node.GetId())
@@ -540,18 +932,88 @@ func runChannelzServer(ctx context.Context, params *configpb.AgentParams) error
 	return g.Wait()
 }
 
-func (ac *AgentConf) runAgent(ctx context.Context, params *configpb.AgentParams) error {
+func (ac *AgentConf) runAgent(ctx context.Context, params *configpb.AgentParams, reloader *configReloader) error {
 	clock := clockwork.NewRealClock()
 
 	agentOpts := []agent.AgentOption{agent.WithClock(clock)}
 
+	if bw := params.GetBandwidth(); bw != nil {
+		agentOpts = append(agentOpts, agent.WithDailyBandwidthCap(bw.GetDailyCapBytes(), bw.GetTelemetryReductionThreshold()))
+		if tq := bw.GetTelemetryQueue(); tq != nil {
+			agentOpts = append(agentOpts, agent.WithTelemetryQueue(telemetryQueueConfig(tq)))
+		}
+	}
+
+	if el := params.GetEventLog(); el != nil {
+		if el.GetPath() == "" {
+			return errors.New("event_log requires a path")
+		}
+		events, err := eventlog.NewWriter(eventlog.Config{
+			Path:     el.GetPath(),
+			MaxBytes: int64(el.GetMaxSizeBytes()),
+			MaxFiles: int(el.GetMaxFiles()),
+		})
+		if err != nil {
+			return fmt.Errorf("opening event log: %w", err)
+		}
+		defer events.Close()
+		agentOpts = append(agentOpts, agent.WithEventLog(events))
+	}
+
+	if ro := params.GetRemoteOps(); ro != nil {
+		opt, err := remoteOpsOption(ctx, ro)
+		if err != nil {
+			return fmt.Errorf("remote_ops: %w", err)
+		}
+		agentOpts = append(agentOpts, opt)
+	}
+
+	if hc := params.GetHealthCheck(); hc != nil {
+		opt, err := healthCheckOption(ctx, hc)
+		if err != nil {
+			return fmt.Errorf("health_check: %w", err)
+		}
+		agentOpts = append(agentOpts, opt)
+	}
+
+	if d := params.GetDrainTimeout(); d != nil {
+		agentOpts = append(agentOpts, agent.WithDrainTimeout(d.AsDuration()))
+	}
+
+	if n := sdnotify.FromEnv(); n != nil {
+		watchdog, err := sdnotify.WatchdogInterval()
+		if err != nil {
+			return err
+		}
+		zerolog.Ctx(ctx).Debug().Dur("watchdogInterval", watchdog).Msg("notifying systemd of the agent's state")
+		agentOpts = append(agentOpts, agent.WithServiceNotifier(n, watchdog))
+	}
+
+	if cs := params.GetClockSync(); cs != nil {
+		if cs.GetNtpServer() == "" {
+			return errors.New("clock_sync requires an ntp_server")
+		}
+		guard := clocksync.NewGuard(clocksync.GuardConfig{
+			Source:   clocksync.NewNTPSource(cs.GetNtpServer(), clock),
+			MaxSkew:  cs.GetMaxSkew().AsDuration(),
+			Interval: cs.GetCheckInterval().AsDuration(),
+			Clock:    clock,
+		})
+		if reloader != nil {
+			reloader.setGuard(guard)
+		}
+		agentOpts = append(agentOpts, agent.WithClockGuard(guard))
+	}
+
+	conns := newConnections(params, clock, ac.QUICDialer)
 	for _, node := range params.GetNetworkNodes() {
-		nodeOpts, err := ac.getNodeOpts(ctx, node, clock)
+		nodeOpts, err := ac.getNodeOpts(ctx, node, conns)
 		if err != nil {
 			return fmt.Errorf("node %s: %w", node.Id, err)
 		}
 		agentOpts = append(agentOpts, agent.WithNode(node.GetId(), nodeOpts...))
 	}
+	agentOpts = append(agentOpts, conns.agentOpts...)
 
 	a, err := agent.NewAgent(agentOpts...)
 	if err != nil {
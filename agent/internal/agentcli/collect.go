@@ -0,0 +1,195 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agentcli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"aalyria.com/spacetime/agent/eventlog"
+	"aalyria.com/spacetime/agent/internal/configpb"
+	"aalyria.com/spacetime/agent/internal/tarball"
+	"aalyria.com/spacetime/rpclog"
+
+	"google.golang.org/protobuf/encoding/prototext"
+)
+
+// collectFetchLimit bounds how much is read from each of the running
+// agent's debug endpoints.
+const collectFetchLimit = 64 << 20
+
+// runCollect implements the `collect` subcommand, which gathers what's
+// needed to diagnose an agent into a single archive that can be attached to
+// a support ticket: its configuration with any secrets redacted, version
+// information, its most recent events, and, if the agent is running with a
+// pprof server, a snapshot of its stats and goroutines.
+//
+// Anything that can't be collected is listed in the archive's
+// collection_errors.txt rather than failing the command, since a bundle
+// from a broken agent is when it's needed the most.
+func (ac AgentConf) runCollect(ctx context.Context, name string, args []string) error {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(ac.Handles.Stderr())
+	fs.Usage = func() {
+		w := fs.Output()
+		fmt.Fprintf(w, "Usage: %s [options]\n", name)
+		fmt.Fprint(w, "\nOptions:\n")
+		fs.PrintDefaults()
+	}
+	confPath := fs.String("config", "", "The path to the agent's configuration (an AgentParams message).")
+	protoFormat := fs.String("format", "text", "The format (one of text, wire, or json) to read the configuration as.")
+	output := fs.String("output", "", "The path, or s3:// or gs:// URL, to write the archive to. Defaults to agent-diagnostics-<timestamp>.tar.gz in the current directory.")
+	numEvents := fs.Int("n", 1000, "The number of the most recent events to include.")
+	pprofAddr := fs.String("pprof-addr", "", "The address (host:port) of the running agent's pprof server. Defaults to observability_params.pprof_address from the config.")
+	timeout := fs.Duration("timeout", 5*time.Second, "How long to wait for the running agent to respond.")
+	if err := fs.Parse(args); err == flag.ErrHelp {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	params, err := readParams(*confPath, *protoFormat)
+	if err != nil {
+		return err
+	}
+	addr := params.GetObservabilityParams().GetPprofAddress()
+	if *pprofAddr != "" {
+		addr = *pprofAddr
+	}
+
+	now := ac.Handles.Clock().Now()
+	path := *output
+	if path == "" {
+		path = "agent-diagnostics-" + now.UTC().Format("20060102-150405") + ".tar.gz"
+	}
+
+	files := []struct {
+		name string
+		data func() ([]byte, error)
+	}{
+		{"config.textproto", func() ([]byte, error) { return redactedConfig(params) }},
+		{"version.txt", func() ([]byte, error) { return versionInfo(), nil }},
+		{"events.jsonl", func() ([]byte, error) { return lastEvents(params, *numEvents) }},
+		{"vars.json", func() ([]byte, error) { return fetchDebug(ctx, addr, "/debug/vars", *timeout) }},
+		{"goroutines.txt", func() ([]byte, error) {
+			return fetchDebug(ctx, addr, "/debug/pprof/goroutine?debug=2", *timeout)
+		}},
+	}
+
+	buf := &bytes.Buffer{}
+	tw := tarball.NewWriter(buf, now)
+	failures := []string{}
+	for _, f := range files {
+		data, err := f.data()
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v\n", f.name, err))
+			continue
+		}
+		if err := tw.Add(f.name, data); err != nil {
+			return err
+		}
+	}
+	if len(failures) > 0 {
+		if err := tw.Add("collection_errors.txt", []byte(strings.Join(failures, ""))); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := writeOutput(ctx, path, func(w io.Writer) error {
+		_, err := w.Write(buf.Bytes())
+		return err
+	}); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(ac.Handles.Stdout(), "wrote %s\n", path)
+	if len(failures) > 0 {
+		fmt.Fprintf(ac.Handles.Stderr(), "%d item(s) couldn't be collected; see collection_errors.txt in the archive\n", len(failures))
+	}
+	return nil
+}
+
+// redactedConfig returns the textproto representation of `params` with the
+// values of fields that look like they hold credentials redacted.
+func redactedConfig(params *configpb.AgentParams) ([]byte, error) {
+	return prototext.MarshalOptions{Multiline: true}.Marshal(rpclog.Options{}.Redact(params))
+}
+
+func versionInfo() []byte {
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "go: %s\nplatform: %s/%s\n\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		sb.WriteString(bi.String())
+	} else {
+		sb.WriteString("no build information available\n")
+	}
+	return []byte(sb.String())
+}
+
+// lastEvents returns the `n` most recent events in the event log configured
+// by `params`, one JSON object per line.
+func lastEvents(params *configpb.AgentParams, n int) ([]byte, error) {
+	if params.GetEventLog().GetPath() == "" {
+		return nil, errors.New("the config doesn't configure an event_log")
+	}
+	events, err := eventlog.Last(params.GetEventLog().GetPath(), n, nil)
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// fetchDebug returns the response to a GET of `path` from the pprof server
+// of the running agent, at `addr`.
+func fetchDebug(ctx context.Context, addr, path string, timeout time.Duration) ([]byte, error) {
+	if addr == "" {
+		return nil, errors.New("no pprof address is configured, so the running agent can't be queried")
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+addr+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", path, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, collectFetchLimit))
+}
@@ -0,0 +1,122 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agentcli
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jonboulle/clockwork"
+	"google.golang.org/protobuf/proto"
+
+	agent "aalyria.com/spacetime/agent"
+	"aalyria.com/spacetime/agent/enactment"
+	"aalyria.com/spacetime/agent/internal/configpb"
+	"aalyria.com/spacetime/agent/telemetry"
+)
+
+// connections decides how the services of each node connect to the
+// controller. If multiplex_connections is set, services whose
+// connection_params are identical share a single connection, configured
+// with [agent.WithSharedConnection]; otherwise each service dials its own.
+type connections struct {
+	multiplex  bool
+	clock      clockwork.Clock
+	quicDialer QUICDialer
+
+	// names maps the deterministic encoding of each shared connection's
+	// params to the connection's name.
+	names map[string]string
+	// agentOpts configure the shared connections.
+	agentOpts []agent.AgentOption
+}
+
+func newConnections(params *configpb.AgentParams, clock clockwork.Clock, quicDialer QUICDialer) *connections {
+	return &connections{
+		multiplex:  params.GetMultiplexConnections(),
+		clock:      clock,
+		quicDialer: quicDialer,
+		names:      map[string]string{},
+	}
+}
+
+// enactmentDriver returns the option that configures a node's enactment
+// driver to connect as described by `connParams`.
+func (c *connections) enactmentDriver(ctx context.Context, connParams *configpb.ConnectionParams, ed enactment.Driver) (agent.NodeOption, error) {
+	if !c.multiplex {
+		dialOpts, err := getDialOpts(ctx, connParams, c.clock, c.quicDialer)
+		if err != nil {
+			return nil, err
+		}
+		return agent.WithEnactmentDriver(connParams.GetEndpointUri(), ed, dialOpts...), nil
+	}
+	name, err := c.shared(ctx, connParams)
+	if err != nil {
+		return nil, err
+	}
+	return agent.WithSharedEnactmentDriver(name, ed), nil
+}
+
+// telemetryDriver returns the option that configures a node's telemetry
+// driver to connect as described by `connParams`.
+func (c *connections) telemetryDriver(ctx context.Context, connParams *configpb.ConnectionParams, td telemetry.Driver) (agent.NodeOption, error) {
+	if !c.multiplex {
+		dialOpts, err := getDialOpts(ctx, connParams, c.clock, c.quicDialer)
+		if err != nil {
+			return nil, err
+		}
+		return agent.WithTelemetryDriver(connParams.GetEndpointUri(), td, dialOpts...), nil
+	}
+	name, err := c.shared(ctx, connParams)
+	if err != nil {
+		return nil, err
+	}
+	return agent.WithSharedTelemetryDriver(name, td), nil
+}
+
+// shared returns the name of the shared connection described by
+// `connParams`, configuring it if it's the first to be requested. Shared
+// connections are named after their endpoint, with a suffix if several
+// connect to the same endpoint differently (e.g. with other credentials).
+func (c *connections) shared(ctx context.Context, connParams *configpb.ConnectionParams) (string, error) {
+	key, err := proto.MarshalOptions{Deterministic: true}.Marshal(connParams)
+	if err != nil {
+		return "", fmt.Errorf("encoding connection_params: %w", err)
+	}
+	if name, ok := c.names[string(key)]; ok {
+		return name, nil
+	}
+
+	dialOpts, err := getDialOpts(ctx, connParams, c.clock, c.quicDialer)
+	if err != nil {
+		return "", err
+	}
+	name := connParams.GetEndpointUri()
+	for n := 2; c.hasName(name); n++ {
+		name = fmt.Sprintf("%s#%d", connParams.GetEndpointUri(), n)
+	}
+	c.names[string(key)] = name
+	c.agentOpts = append(c.agentOpts, agent.WithSharedConnection(name, connParams.GetEndpointUri(), dialOpts...))
+	return name, nil
+}
+
+func (c *connections) hasName(name string) bool {
+	for _, n := range c.names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agentcli
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jonboulle/clockwork"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"aalyria.com/spacetime/agent/internal/configpb"
+)
+
+func TestConnections_shared(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	connParams := func(endpoint string, auth *configpb.AuthStrategy) *configpb.ConnectionParams {
+		return &configpb.ConnectionParams{
+			EndpointUri:  endpoint,
+			AuthStrategy: auth,
+			TransportSecurity: &configpb.ConnectionParams_TransportSecurity{
+				Type: &configpb.ConnectionParams_TransportSecurity_Insecure{Insecure: &emptypb.Empty{}},
+			},
+		}
+	}
+	noAuth := &configpb.AuthStrategy{Type: &configpb.AuthStrategy_None{None: &emptypb.Empty{}}}
+	params := &configpb.AgentParams{MultiplexConnections: true}
+	conns := newConnections(params, clockwork.NewFakeClock(), nil)
+
+	for _, tc := range []struct {
+		params *configpb.ConnectionParams
+		want   string
+	}{
+		{connParams("dns:///sbi.example.com:443", noAuth), "dns:///sbi.example.com:443"},
+		{connParams("dns:///telemetry.example.com:443", noAuth), "dns:///telemetry.example.com:443"},
+		{connParams("dns:///sbi.example.com:443", noAuth), "dns:///sbi.example.com:443"},
+		{connParams("dns:///sbi.example.com:443", nil), "dns:///sbi.example.com:443#2"},
+	} {
+		got, err := conns.shared(ctx, tc.params)
+		if tc.params.GetAuthStrategy() == nil {
+			// Connections without an auth_strategy can't be dialed, but
+			// must fail without being shared.
+			if err == nil {
+				t.Errorf("shared(%v) = %q, want an error", tc.params, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("shared(%v) returned unexpected error: %v", tc.params, err)
+		}
+		if got != tc.want {
+			t.Errorf("shared(%v) = %q, want %q", tc.params, got, tc.want)
+		}
+	}
+	if got, want := len(conns.agentOpts), 2; got != want {
+		t.Errorf("configured %d shared connections, want %d", got, want)
+	}
+}
@@ -0,0 +1,275 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agentcli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"aalyria.com/spacetime/agent/eventlog"
+)
+
+const eventsFollowInterval = 500 * time.Millisecond
+
+// runEvents implements the `events` subcommand, which reads the event log
+// written by an agent configured with `event_log`.
+func (ac AgentConf) runEvents(ctx context.Context, appName string, args []string) error {
+	usage := func(w io.Writer) {
+		fmt.Fprintf(w, "Usage: %s events <tail|export|compact> [options]\n", appName)
+		fmt.Fprint(w, "\nCommands:\n")
+		fmt.Fprint(w, "  tail     Print the most recent events, optionally following the log as it's written.\n")
+		fmt.Fprint(w, "  export   Write the events matching the provided filters as JSON lines.\n")
+		fmt.Fprint(w, "  compact  Move rotated files into compressed archives and delete the archives past their retention.\n")
+	}
+	if len(args) == 0 {
+		usage(ac.Handles.Stderr())
+		return errors.New("no events command provided")
+	}
+
+	switch cmd, args := args[0], args[1:]; cmd {
+	case "tail":
+		return ac.tailEvents(ctx, appName+" events tail", args)
+	case "export":
+		return ac.exportEvents(ctx, appName+" events export", args)
+	case "compact":
+		return ac.compactEvents(appName+" events compact", args)
+	case "help", "-h", "-help", "--help":
+		usage(ac.Handles.Stdout())
+		return nil
+	default:
+		usage(ac.Handles.Stderr())
+		return fmt.Errorf("unknown events command %q", cmd)
+	}
+}
+
+// eventLogFlags are the flags shared by the `events` commands to locate the
+// log.
+type eventLogFlags struct {
+	path, confPath, protoFormat *string
+}
+
+func newEventsFlagSet(ac AgentConf, name string) (*flag.FlagSet, eventLogFlags) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(ac.Handles.Stderr())
+	fs.Usage = func() {
+		w := fs.Output()
+		fmt.Fprintf(w, "Usage: %s [options]\n", name)
+		fmt.Fprint(w, "\nOptions:\n")
+		fs.PrintDefaults()
+	}
+	return fs, eventLogFlags{
+		path:        fs.String("path", "", "The path of the event log. Defaults to event_log.path from --config."),
+		confPath:    fs.String("config", "", "The path to the agent's configuration (an AgentParams message) to find the event log in."),
+		protoFormat: fs.String("format", "text", "The format (one of text, wire, or json) to read the configuration as."),
+	}
+}
+
+func (f eventLogFlags) logPath() (string, error) {
+	if *f.path != "" {
+		return *f.path, nil
+	}
+	if *f.confPath == "" {
+		return "", errors.New("one of --path or --config is required")
+	}
+	params, err := readParams(*f.confPath, *f.protoFormat)
+	if err != nil {
+		return "", err
+	}
+	if params.GetEventLog().GetPath() == "" {
+		return "", fmt.Errorf("%s doesn't configure an event_log", *f.confPath)
+	}
+	return params.GetEventLog().GetPath(), nil
+}
+
+func (ac AgentConf) tailEvents(ctx context.Context, name string, args []string) error {
+	fs, logFlags := newEventsFlagSet(ac, name)
+	n := fs.Int("n", 20, "The number of past events to print, or 0 for all of them.")
+	follow := fs.Bool("f", false, "Keep printing events as they're written, until interrupted.")
+	if err := fs.Parse(args); err == flag.ErrHelp {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	path, err := logFlags.logPath()
+	if err != nil {
+		return err
+	}
+
+	t, err := eventlog.NewTailer(path)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+
+	out, warn := ac.Handles.Stdout(), ac.skipCorruptEvent
+	backlog := []eventlog.Event{}
+	if err := t.Backlog(warn(func(e eventlog.Event) error {
+		backlog = append(backlog, e)
+		if *n > 0 && len(backlog) > *n {
+			backlog = backlog[1:]
+		}
+		return nil
+	})); err != nil {
+		return err
+	}
+	for _, e := range backlog {
+		if err := writeEventLine(out, e); err != nil {
+			return err
+		}
+	}
+	if !*follow {
+		return nil
+	}
+
+	return t.Follow(ctx, eventsFollowInterval, warn(func(e eventlog.Event) error {
+		return writeEventLine(out, e)
+	}))
+}
+
+func (ac AgentConf) exportEvents(ctx context.Context, name string, args []string) error {
+	fs, logFlags := newEventsFlagSet(ac, name)
+	since := fs.String("since", "", "Only export events at or after this time (RFC 3339).")
+	until := fs.String("until", "", "Only export events before this time (RFC 3339).")
+	node := fs.String("node", "", "Only export events for this node.")
+	kinds := fs.String("kind", "", "Only export events of these comma-separated kinds, such as failed,rolled_back.")
+	output := fs.String("output", "", "The path, or s3:// or gs:// URL, to write the events to. Defaults to stdout.")
+	if err := fs.Parse(args); err == flag.ErrHelp {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	path, err := logFlags.logPath()
+	if err != nil {
+		return err
+	}
+
+	var start, end time.Time
+	if *since != "" {
+		if start, err = time.Parse(time.RFC3339Nano, *since); err != nil {
+			return fmt.Errorf("bad --since: %w", err)
+		}
+	}
+	if *until != "" {
+		if end, err = time.Parse(time.RFC3339Nano, *until); err != nil {
+			return fmt.Errorf("bad --until: %w", err)
+		}
+	}
+	wantKinds := map[eventlog.Kind]bool{}
+	if *kinds != "" {
+		for _, k := range strings.Split(*kinds, ",") {
+			wantKinds[eventlog.Kind(strings.TrimSpace(k))] = true
+		}
+	}
+
+	export := func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		return eventlog.ReadSince(path, start, ac.skipCorruptEvent(func(e eventlog.Event) error {
+			switch {
+			case !start.IsZero() && e.Time.Before(start),
+				!end.IsZero() && !e.Time.Before(end),
+				*node != "" && e.Node != *node,
+				len(wantKinds) > 0 && !wantKinds[e.Kind]:
+				return nil
+			}
+			return enc.Encode(e)
+		}))
+	}
+	if *output == "" {
+		return export(ac.Handles.Stdout())
+	}
+	return writeOutput(ctx, *output, export)
+}
+
+func (ac AgentConf) compactEvents(name string, args []string) error {
+	fs, logFlags := newEventsFlagSet(ac, name)
+	maxAge := fs.Duration("max-age", 0, "Delete archives whose newest event is older than this, such as 720h. 0 keeps archives regardless of their age.")
+	maxBytes := fs.Int64("max-bytes", 0, "Delete the oldest archives once they take up more than this many bytes in total. 0 keeps archives regardless of their size.")
+	if err := fs.Parse(args); err == flag.ErrHelp {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if *maxAge < 0 || *maxBytes < 0 {
+		return errors.New("--max-age and --max-bytes can't be negative")
+	}
+	path, err := logFlags.logPath()
+	if err != nil {
+		return err
+	}
+
+	res, err := eventlog.Compact(path, eventlog.CompactOptions{MaxAge: *maxAge, MaxBytes: *maxBytes})
+	out := ac.Handles.Stdout()
+	for _, a := range res.Archived {
+		fmt.Fprintf(out, "archived %d events from %s to %s as %s (%d bytes)\n", a.Events,
+			a.First.UTC().Format(time.RFC3339), a.Last.UTC().Format(time.RFC3339), a.File, a.Bytes)
+	}
+	for _, a := range res.Deleted {
+		fmt.Fprintf(out, "deleted %s, with events from %s to %s\n", a.File,
+			a.First.UTC().Format(time.RFC3339), a.Last.UTC().Format(time.RFC3339))
+	}
+	if err != nil {
+		return fmt.Errorf("compacting %s: %w", path, err)
+	}
+	return nil
+}
+
+// skipCorruptEvent adapts `fn` to the callbacks of the eventlog package,
+// warning about lines that can't be decoded rather than giving up on the
+// rest of the log.
+func (ac AgentConf) skipCorruptEvent(fn func(eventlog.Event) error) func(eventlog.Event, error) error {
+	return func(e eventlog.Event, err error) error {
+		if err != nil {
+			fmt.Fprintf(ac.Handles.Stderr(), "skipping unreadable event: %v\n", err)
+			return nil
+		}
+		return fn(e)
+	}
+}
+
+// writeEventLine writes a single line summarizing `e` to `w`.
+func writeEventLine(w io.Writer, e eventlog.Event) error {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "%s %s %s", e.Time.UTC().Format(time.RFC3339Nano), e.Node, e.Kind)
+	if e.Request != "" {
+		fmt.Fprintf(b, " %s", e.Request)
+	}
+	if e.EntryID != "" {
+		fmt.Fprintf(b, " entry=%s", e.EntryID)
+	}
+	if e.RequestID != 0 {
+		fmt.Fprintf(b, " request=%d", e.RequestID)
+	}
+	if e.Seqno != 0 {
+		fmt.Fprintf(b, " seqno=%d", e.Seqno)
+	}
+	if e.Code != "" {
+		fmt.Fprintf(b, " code=%s", e.Code)
+	}
+	if len(e.Rules) > 0 {
+		fmt.Fprintf(b, " rules=%s", strings.Join(e.Rules, ","))
+	}
+	if e.Message != "" {
+		fmt.Fprintf(b, " %q", e.Message)
+	}
+	b.WriteByte('\n')
+	_, err := io.WriteString(w, b.String())
+	return err
+}
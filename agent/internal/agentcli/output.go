@@ -0,0 +1,37 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agentcli
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"aalyria.com/spacetime/objstore"
+)
+
+// writeOutput calls `write` with a writer to `dest`, which is either a local
+// path or an object storage URL (see [objstore.Create]). Nothing is left at
+// `dest` if `write` fails.
+func writeOutput(ctx context.Context, dest string, write func(io.Writer) error) error {
+	w, err := objstore.Create(ctx, dest, objstore.Config{})
+	if err != nil {
+		return err
+	}
+	if err := write(w); err != nil {
+		return errors.Join(err, w.Abort())
+	}
+	return w.Close()
+}
@@ -0,0 +1,123 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agentcli
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"aalyria.com/spacetime/agent/internal/configpb"
+)
+
+// defaultQUICALPN is the ALPN protocol used for QUIC connections unless the
+// configuration overrides it. The connection carries HTTP/2 framed gRPC on a
+// single stream, which isn't HTTP/3, so it deliberately doesn't use "h3".
+const defaultQUICALPN = "spacetime-grpc"
+
+// QUICDialer opens QUIC connections for nodes whose ConnectionParams select
+// the experimental QUIC transport. This module doesn't depend on a QUIC
+// implementation, so agent binaries that want QUIC support provide one, for
+// example backed by quic-go.
+type QUICDialer interface {
+	// DialQUIC performs a QUIC handshake with `addr` using `tlsConf` and
+	// returns a single bidirectional stream on the connection. The returned
+	// connection must also implement ConnectionState() tls.ConnectionState,
+	// reporting the TLS state of the QUIC connection.
+	DialQUIC(ctx context.Context, addr string, tlsConf *tls.Config) (net.Conn, error)
+}
+
+// ErrQUICUnsupported is returned when a node selects the QUIC transport but
+// the [AgentConf] has no [QUICDialer].
+var ErrQUICUnsupported = errors.New("this agent was built without QUIC support")
+
+type tlsStateConn interface {
+	ConnectionState() tls.ConnectionState
+}
+
+// quicCredentials are the gRPC transport credentials for connections carried
+// over QUIC. QUIC always encrypts with TLS 1.3, so rather than performing
+// another TLS handshake on top, they report the security of the QUIC
+// connection itself. This keeps per-RPC credentials that require transport
+// security working.
+type quicCredentials struct {
+	serverName string
+}
+
+func (qc *quicCredentials) ClientHandshake(_ context.Context, _ string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	tc, ok := conn.(tlsStateConn)
+	if !ok {
+		return nil, nil, fmt.Errorf("connection of type %T doesn't report its TLS state", conn)
+	}
+	return conn, credentials.TLSInfo{
+		State:          tc.ConnectionState(),
+		CommonAuthInfo: credentials.CommonAuthInfo{SecurityLevel: credentials.PrivacyAndIntegrity},
+	}, nil
+}
+
+func (qc *quicCredentials) ServerHandshake(net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return nil, nil, errors.New("QUIC credentials are client-only")
+}
+
+func (qc *quicCredentials) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{SecurityProtocol: "tls", ServerName: qc.serverName}
+}
+
+func (qc *quicCredentials) Clone() credentials.TransportCredentials {
+	return &quicCredentials{serverName: qc.serverName}
+}
+
+func (qc *quicCredentials) OverrideServerName(serverName string) error {
+	qc.serverName = serverName
+	return nil
+}
+
+// getQUICDialOpts returns the dial options that carry gRPC connections over
+// QUIC streams opened by `dialer`, in place of the usual TCP dialer and
+// transport credentials.
+func getQUICDialOpts(ctx context.Context, connParams *configpb.ConnectionParams, dialer QUICDialer) ([]grpc.DialOption, error) {
+	if dialer == nil {
+		return nil, ErrQUICUnsupported
+	}
+
+	tlsConf, err := getTLSConfig(ctx, connParams)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConf == nil {
+		return nil, errors.New("the QUIC transport requires TLS, but transport_security is insecure")
+	}
+	tlsConf = tlsConf.Clone()
+	if tlsConf.ServerName == "" {
+		tlsConf.ServerName = endpointHost(connParams.GetEndpointUri())
+	}
+	alpn := connParams.GetQuic().GetAlpn()
+	if alpn == "" {
+		alpn = defaultQUICALPN
+	}
+	tlsConf.NextProtos = []string{alpn}
+
+	return []grpc.DialOption{
+		grpc.WithTransportCredentials(&quicCredentials{serverName: tlsConf.ServerName}),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return dialer.DialQUIC(ctx, addr, tlsConf)
+		}),
+	}, nil
+}
@@ -0,0 +1,214 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agentcli
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog"
+	"google.golang.org/protobuf/proto"
+
+	"aalyria.com/spacetime/agent/clocksync"
+	"aalyria.com/spacetime/agent/internal/configpb"
+	"aalyria.com/spacetime/agent/internal/logging"
+	"aalyria.com/spacetime/agent/internal/protofmt"
+)
+
+// reloadDelay is how long the config directory has to be left alone after
+// a change before the file is reread, so that a file is read once it's fully
+// written rather than on each of the writes that make it up.
+const reloadDelay = 100 * time.Millisecond
+
+// logOverrides are the log levels set by flags, which take precedence over
+// the logging params of the configuration.
+type logOverrides struct {
+	// level is nil unless --log-level was set.
+	level   *zerolog.Level
+	modules logging.Levels
+}
+
+// levels returns the log levels configured by `params`, with the overrides
+// applied.
+func (o logOverrides) levels(params *configpb.AgentParams) (zerolog.Level, logging.Levels, error) {
+	level := zerolog.InfoLevel
+	if name := params.GetLogging().GetLevel(); name != "" {
+		var err error
+		if level, err = zerolog.ParseLevel(name); err != nil {
+			return 0, nil, fmt.Errorf("logging.level: %w", err)
+		}
+	}
+	if o.level != nil {
+		level = *o.level
+	}
+
+	modules := logging.Levels{}
+	for module, name := range params.GetLogging().GetModuleLevels() {
+		l, err := zerolog.ParseLevel(name)
+		if err != nil {
+			return 0, nil, fmt.Errorf("logging.module_levels[%q]: %w", module, err)
+		}
+		modules[module] = l
+	}
+	for module, l := range o.modules {
+		modules[module] = l
+	}
+	return level, modules, nil
+}
+
+// configReloader rereads the agent's configuration file when it changes and
+// applies the changes that don't require restarting the agent: the log
+// levels and the clock_sync max_skew and check_interval.
+type configReloader struct {
+	path   string
+	format protofmt.Format
+	// override applies the flags that take precedence over the file.
+	override func(*configpb.AgentParams)
+	logs     *logging.Config
+	logFlags logOverrides
+
+	// running is the configuration the agent was started with, applied the
+	// one it was last reloaded from, and data the contents of the file when
+	// it was last read.
+	running, applied *configpb.AgentParams
+	data             []byte
+
+	mu    sync.Mutex
+	guard *clocksync.Guard
+}
+
+// setGuard sets the clock guard the clock_sync changes apply to.
+func (r *configReloader) setGuard(g *clocksync.Guard) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.guard = g
+}
+
+// watch starts watching the file for changes. It's the directory holding
+// the file that's watched, rather than the file itself, so that changes are
+// seen however the file is replaced: editors often write a new file and
+// rename it over the old one, and Kubernetes updates mounted ConfigMaps by
+// swapping a symlink the file's path resolves through, neither of which a
+// watch on the file would survive.
+func (r *configReloader) watch() (*fsnotify.Watcher, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("unable to watch the config: %w", err)
+	}
+	if err := w.Add(filepath.Dir(r.path)); err != nil {
+		w.Close()
+		return nil, fmt.Errorf("unable to watch the config: %w", err)
+	}
+	return w, nil
+}
+
+// run reloads the file whenever `w`, as returned by watch, reports changes
+// to its directory, until `ctx` is done. It closes `w` before returning.
+func (r *configReloader) run(ctx context.Context, w *fsnotify.Watcher) error {
+	defer w.Close()
+	ctx = logging.Module(ctx, "config")
+	log := zerolog.Ctx(ctx)
+
+	// Other files in the directory, such as the ones Kubernetes swaps in,
+	// can change the file, so any change is a reason to reread it; reload
+	// ignores the ones that left it unchanged.
+	timer := time.NewTimer(reloadDelay)
+	timer.Stop()
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev, ok := <-w.Events:
+			if !ok {
+				return nil
+			}
+			if ev.Op != fsnotify.Chmod {
+				timer.Reset(reloadDelay)
+			}
+		case err, ok := <-w.Errors:
+			if !ok {
+				return nil
+			}
+			log.Warn().Err(err).Str("path", r.path).Msg("error watching config")
+		case <-timer.C:
+			r.reload(ctx)
+		}
+	}
+}
+
+// reload applies the changes made to the file since it was last read, if
+// any. Invalid configurations are logged and ignored, so that a typo can't
+// take down a running agent.
+func (r *configReloader) reload(ctx context.Context) {
+	log := zerolog.Ctx(ctx)
+
+	data, err := os.ReadFile(r.path)
+	switch {
+	case err != nil:
+		log.Warn().Err(err).Str("path", r.path).Msg("unable to reread config")
+		return
+	case bytes.Equal(data, r.data):
+		return
+	}
+	r.data = data
+
+	next, err := parseParams(data, r.format)
+	if err != nil {
+		log.Warn().Err(err).Str("path", r.path).Msg("ignoring invalid config")
+		return
+	}
+	r.override(next)
+	if proto.Equal(next, r.applied) {
+		return
+	}
+	level, modules, err := r.logFlags.levels(next)
+	if err != nil {
+		log.Warn().Err(err).Str("path", r.path).Msg("ignoring invalid config")
+		return
+	}
+
+	r.logs.Set(level, modules)
+	r.mu.Lock()
+	if r.guard != nil {
+		r.guard.SetMaxSkew(next.GetClockSync().GetMaxSkew().AsDuration())
+		r.guard.SetInterval(next.GetClockSync().GetCheckInterval().AsDuration())
+	}
+	r.mu.Unlock()
+	r.applied = next
+
+	if !proto.Equal(withoutReloadableParams(r.running), withoutReloadableParams(next)) {
+		log.Warn().Str("path", r.path).Msg("reloaded the logging and clock_sync intervals from the changed config; its other changes only take effect once the agent is restarted")
+		return
+	}
+	log.Info().Str("path", r.path).Msg("reloaded config")
+}
+
+// withoutReloadableParams returns a copy of `params` without the params
+// configReloader applies.
+func withoutReloadableParams(params *configpb.AgentParams) *configpb.AgentParams {
+	params = proto.Clone(params).(*configpb.AgentParams)
+	params.Logging = nil
+	if cs := params.GetClockSync(); cs != nil {
+		cs.MaxSkew, cs.CheckInterval = nil, nil
+	}
+	return params
+}
@@ -0,0 +1,226 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agentcli
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rs/zerolog"
+
+	"aalyria.com/spacetime/agent/clocksync"
+	"aalyria.com/spacetime/agent/internal/configpb"
+	"aalyria.com/spacetime/agent/internal/logging"
+	"aalyria.com/spacetime/agent/internal/protofmt"
+)
+
+func TestLogOverrides(t *testing.T) {
+	t.Parallel()
+
+	params := &configpb.AgentParams{Logging: &configpb.LoggingParams{
+		Level:        "warn",
+		ModuleLevels: map[string]string{"enactment": "debug", "telemetry": "error"},
+	}}
+
+	level, modules, err := logOverrides{}.levels(params)
+	if err != nil {
+		t.Fatalf("levels() returned unexpected error: %v", err)
+	}
+	if level != zerolog.WarnLevel {
+		t.Errorf("levels() level = %v, want %v", level, zerolog.WarnLevel)
+	}
+	if diff := cmp.Diff(logging.Levels{"enactment": zerolog.DebugLevel, "telemetry": zerolog.ErrorLevel}, modules); diff != "" {
+		t.Errorf("levels() modules mismatch (-want +got):\n%s", diff)
+	}
+
+	trace := zerolog.TraceLevel
+	flags := logOverrides{level: &trace, modules: logging.Levels{"telemetry": zerolog.InfoLevel}}
+	level, modules, err = flags.levels(params)
+	if err != nil {
+		t.Fatalf("levels() with flags returned unexpected error: %v", err)
+	}
+	if level != zerolog.TraceLevel {
+		t.Errorf("levels() with --log-level=trace level = %v, want %v", level, zerolog.TraceLevel)
+	}
+	if diff := cmp.Diff(logging.Levels{"enactment": zerolog.DebugLevel, "telemetry": zerolog.InfoLevel}, modules); diff != "" {
+		t.Errorf("levels() with --log-modules modules mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, _, err := (logOverrides{}).levels(&configpb.AgentParams{Logging: &configpb.LoggingParams{Level: "loud"}}); err == nil {
+		t.Error("levels() with an invalid level succeeded, want an error")
+	}
+}
+
+func TestConfigReloader(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(data string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`
+clock_sync:
+  ntp_server: time.example.com
+logging:
+  level: info
+`)
+	running, err := readParams(path, "yaml")
+	if err != nil {
+		t.Fatalf("readParams() returned unexpected error: %v", err)
+	}
+
+	logBuf := &bytes.Buffer{}
+	ctx := zerolog.New(logBuf).WithContext(context.Background())
+	logs := logging.NewConfig(zerolog.InfoLevel, nil)
+	guard := clocksync.NewGuard(clocksync.GuardConfig{
+		Source: clocksync.OffsetSourceFunc(func(context.Context) (time.Duration, error) { return 0, nil }),
+	})
+	r := &configReloader{
+		path:     path,
+		format:   protofmt.YAML,
+		override: func(*configpb.AgentParams) {},
+		logs:     logs,
+		running:  running,
+		applied:  running,
+	}
+	r.setGuard(guard)
+
+	// Reading the file the agent started with changes nothing.
+	r.reload(ctx)
+	if logBuf.Len() != 0 {
+		t.Errorf("reloading the unchanged config logged %q, want nothing", logBuf.String())
+	}
+
+	write(`
+clock_sync:
+  ntp_server: time.example.com
+  max_skew: 0.020s
+logging:
+  level: debug
+  module_levels: {enactment: trace}
+`)
+	r.reload(ctx)
+	if level, modules := logs.Levels(); level != zerolog.DebugLevel || modules["enactment"] != zerolog.TraceLevel {
+		t.Errorf("after reloading, levels = %v, %v, want debug and enactment=trace", level, modules)
+	}
+	if got := guard.MaxSkew(); got != 20*time.Millisecond {
+		t.Errorf("after reloading, MaxSkew() = %v, want 20ms", got)
+	}
+	if want := "reloaded config"; !strings.Contains(logBuf.String(), want) {
+		t.Errorf("reloading logged %q, want it to contain %q", logBuf.String(), want)
+	}
+
+	// Invalid configs are ignored.
+	logBuf.Reset()
+	write(`logging: {level: loud}`)
+	r.reload(ctx)
+	if level, _ := logs.Levels(); level != zerolog.DebugLevel {
+		t.Errorf("after reloading an invalid config, level = %v, want it unchanged", level)
+	}
+	if want := "ignoring invalid config"; !strings.Contains(logBuf.String(), want) {
+		t.Errorf("reloading an invalid config logged %q, want it to contain %q", logBuf.String(), want)
+	}
+
+	// Changes that need a restart are reported, but the rest still apply.
+	logBuf.Reset()
+	write(`
+clock_sync:
+  ntp_server: other.example.com
+logging:
+  level: warn
+`)
+	r.reload(ctx)
+	if level, _ := logs.Levels(); level != zerolog.WarnLevel {
+		t.Errorf("after reloading a config that needs a restart, level = %v, want %v", level, zerolog.WarnLevel)
+	}
+	if want := "only take effect once the agent is restarted"; !strings.Contains(logBuf.String(), want) {
+		t.Errorf("reloading a config that needs a restart logged %q, want it to contain %q", logBuf.String(), want)
+	}
+}
+
+func TestConfigReloaderWatch(t *testing.T) {
+	t.Parallel()
+
+	// Lay the config out the way Kubernetes mounts ConfigMaps: config.yaml
+	// is a symlink into ..data, itself a symlink to the directory holding
+	// the current version, which is swapped atomically on updates.
+	dir := t.TempDir()
+	writeVersion := func(version, data string) {
+		t.Helper()
+		if err := os.Mkdir(filepath.Join(dir, version), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, version, "config.yaml"), []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(version, filepath.Join(dir, "..data_tmp")); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeVersion("v1", `logging: {level: info}`)
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.Symlink(filepath.Join("..data", "config.yaml"), path); err != nil {
+		t.Fatal(err)
+	}
+	running, err := readParams(path, "yaml")
+	if err != nil {
+		t.Fatalf("readParams() returned unexpected error: %v", err)
+	}
+
+	logs := logging.NewConfig(zerolog.InfoLevel, nil)
+	r := &configReloader{
+		path:     path,
+		format:   protofmt.YAML,
+		override: func(*configpb.AgentParams) {},
+		logs:     logs,
+		running:  running,
+		applied:  running,
+	}
+	w, err := r.watch()
+	if err != nil {
+		t.Fatalf("watch() returned unexpected error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(zerolog.Nop().WithContext(context.Background()))
+	done := make(chan error)
+	go func() { done <- r.run(ctx, w) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("run() returned unexpected error: %v", err)
+		}
+	})
+
+	writeVersion("v2", `logging: {level: debug}`)
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if level, _ := logs.Levels(); level == zerolog.DebugLevel {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the config wasn't reloaded after the ..data symlink was swapped")
+		}
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bandwidth accounts for the bytes an agent exchanges with the
+// controller and enforces a daily cap on them, for sites whose backhaul is
+// billed by volume.
+package bandwidth
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	"google.golang.org/grpc/stats"
+)
+
+// DefaultReduceAt is the fraction of the daily cap past which telemetry is
+// reduced, unless configured otherwise.
+const DefaultReduceAt = 0.8
+
+// maxTelemetryStride is the most telemetry reports a [Meter] skips for each
+// one it allows just before the cap is reached.
+const maxTelemetryStride = 10
+
+// Meter counts the bytes sent and received on each of an agent's streams
+// and tracks their total against a daily cap. Days start at midnight UTC.
+//
+// Only gRPC payloads, headers and trailers are counted, so the total is an
+// approximation that excludes TLS and HTTP/2 framing overhead. A Meter is
+// safe for concurrent use.
+type Meter struct {
+	clock    clockwork.Clock
+	dailyCap uint64
+	reduceAt float64
+
+	mu               sync.Mutex
+	day              time.Time
+	used             uint64
+	streams          map[string]*streamCounts
+	telemetrySeen    uint64
+	telemetryDropped uint64
+}
+
+type streamCounts struct {
+	sent, received uint64
+}
+
+// NewMeter returns a Meter that enforces a cap of `dailyCap` bytes per day.
+// A `dailyCap` of 0 disables the cap, leaving only the accounting. Telemetry
+// is reduced progressively once usage exceeds `reduceAt`, a fraction of the
+// cap; values outside (0, 1] select [DefaultReduceAt].
+func NewMeter(clock clockwork.Clock, dailyCap uint64, reduceAt float64) *Meter {
+	if reduceAt <= 0 || reduceAt > 1 {
+		reduceAt = DefaultReduceAt
+	}
+	return &Meter{
+		clock:    clock,
+		dailyCap: dailyCap,
+		reduceAt: reduceAt,
+		day:      startOfDay(clock.Now()),
+		streams:  map[string]*streamCounts{},
+	}
+}
+
+func startOfDay(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
+
+// rollover resets the daily usage if a new day has started. Callers must
+// hold m.mu.
+func (m *Meter) rollover() {
+	if today := startOfDay(m.clock.Now()); today.After(m.day) {
+		m.day = today
+		m.used = 0
+	}
+}
+
+// Record adds `sent` and `received` bytes to the usage of `stream`.
+func (m *Meter) Record(stream string, sent, received int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.rollover()
+	sc, ok := m.streams[stream]
+	if !ok {
+		sc = &streamCounts{}
+		m.streams[stream] = sc
+	}
+	sc.sent += uint64(sent)
+	sc.received += uint64(received)
+	m.used += uint64(sent + received)
+}
+
+// telemetryStride returns how many telemetry reports are seen for each one
+// that's sent, or 0 if telemetry should be suspended. Callers must hold
+// m.mu.
+func (m *Meter) telemetryStride() uint64 {
+	if m.dailyCap == 0 {
+		return 1
+	}
+	reduceFrom := m.reduceAt * float64(m.dailyCap)
+	switch used := float64(m.used); {
+	case used >= float64(m.dailyCap):
+		return 0
+	case used < reduceFrom:
+		return 1
+	default:
+		// Scale linearly from every report at the threshold to one in
+		// maxTelemetryStride just before the cap.
+		frac := (used - reduceFrom) / (float64(m.dailyCap) - reduceFrom)
+		return 1 + uint64(frac*(maxTelemetryStride-1))
+	}
+}
+
+// AllowTelemetry reports whether a telemetry report should be sent. Reports
+// are thinned out as usage approaches the daily cap and are suspended once
+// it's reached, until the next day starts. Enactment traffic is never
+// restricted, since dropping it would leave the node out of sync with the
+// controller.
+func (m *Meter) AllowTelemetry() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.rollover()
+	stride := m.telemetryStride()
+	m.telemetrySeen++
+	if stride == 0 || m.telemetrySeen%stride != 0 {
+		m.telemetryDropped++
+		return false
+	}
+	return true
+}
+
+// StreamStats is the usage of a single stream.
+type StreamStats struct {
+	Stream        string
+	BytesSent     uint64
+	BytesReceived uint64
+}
+
+// Stats is a snapshot of a Meter's usage.
+type Stats struct {
+	// DailyCapBytes is 0 if no cap is enforced.
+	DailyCapBytes           uint64
+	BytesUsedToday          uint64
+	TelemetryStride         uint64
+	TelemetryReportsDropped uint64
+	// Streams holds the usage of each stream since the Meter was created.
+	Streams []StreamStats
+}
+
+// Stats returns a snapshot of the Meter's usage, suitable for exporting via
+// expvar.
+func (m *Meter) Stats() interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.rollover()
+	s := Stats{
+		DailyCapBytes:           m.dailyCap,
+		BytesUsedToday:          m.used,
+		TelemetryStride:         m.telemetryStride(),
+		TelemetryReportsDropped: m.telemetryDropped,
+		Streams:                 []StreamStats{},
+	}
+	for name, sc := range m.streams {
+		s.Streams = append(s.Streams, StreamStats{Stream: name, BytesSent: sc.sent, BytesReceived: sc.received})
+	}
+	sort.Slice(s.Streams, func(i, j int) bool { return s.Streams[i].Stream < s.Streams[j].Stream })
+	return s
+}
+
+// StatsHandler returns a gRPC [stats.Handler] that records the traffic of
+// the connection it's installed on as `stream`, for use with
+// [grpc.WithStatsHandler].
+func (m *Meter) StatsHandler(stream string) stats.Handler {
+	return &statsHandler{m: m, stream: stream}
+}
+
+type statsHandler struct {
+	m      *Meter
+	stream string
+}
+
+func (h *statsHandler) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context { return ctx }
+
+func (h *statsHandler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context { return ctx }
+
+func (h *statsHandler) HandleConn(context.Context, stats.ConnStats) {}
+
+func (h *statsHandler) HandleRPC(_ context.Context, rs stats.RPCStats) {
+	switch s := rs.(type) {
+	case *stats.OutPayload:
+		h.m.Record(h.stream, s.WireLength, 0)
+	case *stats.InPayload:
+		h.m.Record(h.stream, 0, s.WireLength)
+	case *stats.InHeader:
+		h.m.Record(h.stream, 0, s.WireLength)
+	case *stats.InTrailer:
+		h.m.Record(h.stream, 0, s.WireLength)
+	}
+}
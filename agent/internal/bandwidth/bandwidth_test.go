@@ -0,0 +1,87 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bandwidth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jonboulle/clockwork"
+	"google.golang.org/grpc/stats"
+)
+
+func TestMeter_accountsPerStream(t *testing.T) {
+	t.Parallel()
+
+	m := NewMeter(clockwork.NewFakeClock(), 0, 0)
+	h := m.StatsHandler("node-a/enactment")
+	h.HandleRPC(context.Background(), &stats.OutPayload{WireLength: 100})
+	h.HandleRPC(context.Background(), &stats.InHeader{WireLength: 20})
+	h.HandleRPC(context.Background(), &stats.InPayload{WireLength: 300})
+	m.Record("node-a/telemetry", 50, 5)
+
+	want := Stats{
+		BytesUsedToday:  475,
+		TelemetryStride: 1,
+		Streams: []StreamStats{
+			{Stream: "node-a/enactment", BytesSent: 100, BytesReceived: 320},
+			{Stream: "node-a/telemetry", BytesSent: 50, BytesReceived: 5},
+		},
+	}
+	if diff := cmp.Diff(want, m.Stats()); diff != "" {
+		t.Errorf("unexpected stats (-want +got):\n%s", diff)
+	}
+}
+
+func TestMeter_reducesTelemetryNearCap(t *testing.T) {
+	t.Parallel()
+
+	clock := clockwork.NewFakeClockAt(time.Date(2024, time.March, 1, 23, 0, 0, 0, time.UTC))
+	m := NewMeter(clock, 1000, 0.5)
+
+	allowed := func(n int) int {
+		count := 0
+		for i := 0; i < n; i++ {
+			if m.AllowTelemetry() {
+				count++
+			}
+		}
+		return count
+	}
+
+	if got := allowed(10); got != 10 {
+		t.Errorf("below the threshold: got %d of 10 reports allowed, want 10", got)
+	}
+
+	m.Record("telemetry", 900, 0)
+	if got := allowed(100); got == 0 || got >= 50 {
+		t.Errorf("near the cap: got %d of 100 reports allowed, want some but fewer than half", got)
+	}
+
+	m.Record("telemetry", 100, 0)
+	if got := allowed(10); got != 0 {
+		t.Errorf("at the cap: got %d of 10 reports allowed, want 0", got)
+	}
+
+	clock.Advance(time.Hour)
+	if got := allowed(10); got != 10 {
+		t.Errorf("after the day rolled over: got %d of 10 reports allowed, want 10", got)
+	}
+	if got := m.Stats().(Stats).BytesUsedToday; got != 0 {
+		t.Errorf("after the day rolled over: got %d bytes used, want 0", got)
+	}
+}
@@ -0,0 +1,287 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package health assesses the condition of a node from the health of the
+// agent's components, such as its streams to the controller and its
+// enactment backend, so sites that need attention can be found and ranked.
+package health
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"aalyria.com/spacetime/agent/clocksync"
+
+	"github.com/jonboulle/clockwork"
+)
+
+// The scores at or above which a component is considered healthy or
+// degraded, respectively.
+const (
+	healthyScore  = 0.9
+	degradedScore = 0.5
+)
+
+// State summarizes a score.
+type State string
+
+const (
+	// Healthy means the component is operating normally.
+	Healthy State = "healthy"
+	// Degraded means the component is operating, but is at risk of failing
+	// to carry out the controller's instructions.
+	Degraded State = "degraded"
+	// Unhealthy means the component can't carry out the controller's
+	// instructions.
+	Unhealthy State = "unhealthy"
+)
+
+// StateOf returns the State a score falls in.
+func StateOf(score float64) State {
+	switch {
+	case score >= healthyScore:
+		return Healthy
+	case score >= degradedScore:
+		return Degraded
+	default:
+		return Unhealthy
+	}
+}
+
+// A CheckFunc assesses a component, returning a score from 0 (failed) to 1
+// (healthy) and an explanation of it.
+type CheckFunc func() (score float64, detail string)
+
+// Check is the assessment of a single component.
+type Check struct {
+	Name   string
+	Score  float64
+	State  State
+	Detail string
+}
+
+// Report is the assessment of a node.
+type Report struct {
+	Time time.Time
+	// Score is the score of the least healthy component, or 1 if there are
+	// none.
+	Score  float64
+	State  State
+	Checks []Check
+}
+
+// Monitor assesses a node from the checks added to it. A Monitor is safe for
+// concurrent use.
+type Monitor struct {
+	clock clockwork.Clock
+
+	mu     sync.Mutex
+	names  []string
+	checks []CheckFunc
+}
+
+// NewMonitor returns a Monitor with no checks.
+func NewMonitor(clock clockwork.Clock) *Monitor {
+	return &Monitor{clock: clock}
+}
+
+// Add adds a check of the component called `name`. Checks are reported in
+// the order they're added.
+func (m *Monitor) Add(name string, fn CheckFunc) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.names = append(m.names, name)
+	m.checks = append(m.checks, fn)
+}
+
+// Report runs the checks and combines them.
+func (m *Monitor) Report() Report {
+	m.mu.Lock()
+	names, checks := m.names, m.checks
+	m.mu.Unlock()
+
+	r := Report{Time: m.clock.Now(), Score: 1, Checks: make([]Check, 0, len(checks))}
+	for i, fn := range checks {
+		score, detail := fn()
+		score = math.Max(0, math.Min(1, score))
+		r.Checks = append(r.Checks, Check{Name: names[i], Score: score, State: StateOf(score), Detail: detail})
+		r.Score = math.Min(r.Score, score)
+	}
+	r.State = StateOf(r.Score)
+	return r
+}
+
+// Stats returns the Monitor's report in a form suitable for exporting with
+// expvar.
+func (m *Monitor) Stats() any { return m.Report() }
+
+// Stream tracks whether a stream to the controller is connected. A stream
+// that's been down for less than its grace period is considered degraded
+// rather than unhealthy, since it's usually re-established by a retry. A
+// Stream is safe for concurrent use.
+type Stream struct {
+	clock clockwork.Clock
+	grace time.Duration
+
+	mu        sync.Mutex
+	connected bool
+	since     time.Time
+	lastErr   error
+}
+
+// NewStream returns a Stream that hasn't connected yet.
+func NewStream(clock clockwork.Clock, grace time.Duration) *Stream {
+	return &Stream{clock: clock, grace: grace, since: clock.Now()}
+}
+
+// Connected records that the stream was established.
+func (s *Stream) Connected() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.connected, s.since = true, s.clock.Now()
+}
+
+// Disconnected records that the stream ended with `err`.
+func (s *Stream) Disconnected(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.connected {
+		s.since = s.clock.Now()
+	}
+	s.connected, s.lastErr = false, err
+}
+
+// IsConnected reports whether the stream is currently established.
+func (s *Stream) IsConnected() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.connected
+}
+
+// Check is a [CheckFunc] for the stream.
+func (s *Stream) Check() (float64, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.connected {
+		return 1, fmt.Sprintf("connected since %s", s.since.UTC().Format(time.RFC3339))
+	}
+	down := s.clock.Since(s.since).Round(time.Second)
+	detail := fmt.Sprintf("not connected for %s", down)
+	if s.lastErr != nil {
+		detail += fmt.Sprintf(": %v", s.lastErr)
+	}
+	if down < s.grace {
+		return degradedScore, detail
+	}
+	return 0, detail
+}
+
+// Results tracks the outcome of a component's most recent operations, such
+// as the changes dispatched to an enactment backend. A Results is safe for
+// concurrent use.
+type Results struct {
+	mu      sync.Mutex
+	window  []bool
+	next    int
+	full    bool
+	lastErr error
+}
+
+// NewResults returns a Results that remembers the last `size` outcomes.
+func NewResults(size int) *Results {
+	return &Results{window: make([]bool, max(size, 1))}
+}
+
+// Record records the outcome of an operation.
+func (r *Results) Record(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.window[r.next] = err == nil
+	r.next = (r.next + 1) % len(r.window)
+	r.full = r.full || r.next == 0
+	if err != nil {
+		r.lastErr = err
+	}
+}
+
+// Check is a [CheckFunc] scoring the fraction of the recorded operations
+// that succeeded.
+func (r *Results) Check() (float64, string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := r.next
+	if r.full {
+		n = len(r.window)
+	}
+	if n == 0 {
+		return 1, "no operations yet"
+	}
+	failed := 0
+	for _, ok := range r.window[:n] {
+		if !ok {
+			failed++
+		}
+	}
+	if failed == 0 {
+		return 1, fmt.Sprintf("last %d operation(s) succeeded", n)
+	}
+	return float64(n-failed) / float64(n), fmt.Sprintf("%d of the last %d operation(s) failed, most recently with: %v", failed, n, r.lastErr)
+}
+
+// ClockSkewCheck returns a [CheckFunc] for the clock offset measured by `g`.
+// The score falls from 1 to [degradedScore] as the offset grows from half
+// the tolerated skew to all of it, and is 0 once time-critical changes are
+// refused.
+func ClockSkewCheck(g *clocksync.Guard) CheckFunc {
+	return func() (float64, string) {
+		offset, maxSkew := g.Offset(), g.MaxSkew()
+		detail := fmt.Sprintf("offset %s, tolerance %s", offset, maxSkew)
+		if err := g.Check(); err != nil {
+			return 0, err.Error()
+		}
+		ratio := math.Abs(offset.Seconds()) / maxSkew.Seconds()
+		if ratio <= 0.5 {
+			return 1, detail
+		}
+		return 1 - (ratio-0.5)*2*(1-degradedScore), detail
+	}
+}
+
+// QueueDepthCheck returns a [CheckFunc] for a queue of work whose length is
+// reported by `depth`. The score falls from 1 to [degradedScore] as the
+// queue grows from half of `limit` to all of it, and keeps falling past it.
+func QueueDepthCheck(depth func() int, limit int) CheckFunc {
+	return func() (float64, string) {
+		d := depth()
+		detail := fmt.Sprintf("%d queued, limit %d", d, limit)
+		ratio := float64(d) / float64(limit)
+		switch {
+		case ratio <= 0.5:
+			return 1, detail
+		case ratio <= 1:
+			return 1 - (ratio-0.5)*2*(1-degradedScore), detail
+		default:
+			return degradedScore / ratio, detail
+		}
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package health
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jonboulle/clockwork"
+)
+
+func TestMonitor_scoresLeastHealthyComponent(t *testing.T) {
+	t.Parallel()
+
+	clock := clockwork.NewFakeClockAt(time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC))
+	m := NewMonitor(clock)
+	if got := m.Report(); got.Score != 1 || got.State != Healthy {
+		t.Errorf("with no checks: got score %v (%s), want 1 (healthy)", got.Score, got.State)
+	}
+
+	m.Add("a", func() (float64, string) { return 1, "fine" })
+	m.Add("b", func() (float64, string) { return 0.6, "slow" })
+	m.Add("c", func() (float64, string) { return 1.5, "out of range" })
+
+	want := Report{
+		Time:  clock.Now(),
+		Score: 0.6,
+		State: Degraded,
+		Checks: []Check{
+			{Name: "a", Score: 1, State: Healthy, Detail: "fine"},
+			{Name: "b", Score: 0.6, State: Degraded, Detail: "slow"},
+			{Name: "c", Score: 1, State: Healthy, Detail: "out of range"},
+		},
+	}
+	if diff := cmp.Diff(want, m.Report()); diff != "" {
+		t.Errorf("unexpected report (-want +got):\n%s", diff)
+	}
+}
+
+func TestStream(t *testing.T) {
+	t.Parallel()
+
+	clock := clockwork.NewFakeClock()
+	s := NewStream(clock, time.Minute)
+	check := func(desc string, want State) {
+		t.Helper()
+		if score, detail := s.Check(); StateOf(score) != want {
+			t.Errorf("%s: got score %v (%s), want %s", desc, score, detail, want)
+		}
+		if got, want := s.IsConnected(), want == Healthy; got != want {
+			t.Errorf("%s: IsConnected() = %v, want %v", desc, got, want)
+		}
+	}
+
+	check("before connecting", Degraded)
+	clock.Advance(2 * time.Minute)
+	check("never connected", Unhealthy)
+	s.Connected()
+	check("connected", Healthy)
+	clock.Advance(time.Hour)
+	s.Disconnected(errors.New("connection reset"))
+	check("just disconnected", Degraded)
+	clock.Advance(2 * time.Minute)
+	check("disconnected past grace period", Unhealthy)
+}
+
+func TestResults_scoresRecentOutcomes(t *testing.T) {
+	t.Parallel()
+
+	r := NewResults(4)
+	if score, _ := r.Check(); score != 1 {
+		t.Errorf("with no results: got score %v, want 1", score)
+	}
+
+	r.Record(errors.New("no such device"))
+	r.Record(nil)
+	if score, _ := r.Check(); score != 0.5 {
+		t.Errorf("got score %v, want 0.5", score)
+	}
+
+	// The failure falls out of the window.
+	for range 4 {
+		r.Record(nil)
+	}
+	if score, detail := r.Check(); score != 1 {
+		t.Errorf("got score %v (%s), want 1", score, detail)
+	}
+}
+
+func TestQueueDepthCheck(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		depth int
+		want  float64
+	}{
+		{depth: 0, want: 1},
+		{depth: 50, want: 1},
+		{depth: 75, want: 0.75},
+		{depth: 100, want: 0.5},
+		{depth: 200, want: 0.25},
+	} {
+		if got, _ := QueueDepthCheck(func() int { return tc.depth }, 100)(); got != tc.want {
+			t.Errorf("depth %d: got score %v, want %v", tc.depth, got, tc.want)
+		}
+	}
+}
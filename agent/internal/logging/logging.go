@@ -0,0 +1,167 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package logging configures the agent's zerolog logger: the format it writes
+// and the level of each of the agent's modules, so that the logs of a single
+// noisy module can be turned up or down without affecting the others.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog"
+)
+
+// ModuleKey is the field that identifies the module a log line came from.
+const ModuleKey = "module"
+
+// Formats that New supports.
+const (
+	// FormatAuto writes human-readable logs if the process is attached to a
+	// terminal, and JSON otherwise.
+	FormatAuto    = "auto"
+	FormatJSON    = "json"
+	FormatConsole = "console"
+)
+
+const consoleTimeFormat = "2006-01-02 03:04:05PM"
+
+// New returns a logger that writes timestamped logs to `w` in `format`.
+// `interactive` decides what FormatAuto means.
+func New(w io.Writer, format string, interactive bool) (zerolog.Logger, error) {
+	switch format {
+	case FormatAuto:
+		if interactive {
+			return New(w, FormatConsole, interactive)
+		}
+		return New(w, FormatJSON, interactive)
+	case FormatConsole:
+		w = zerolog.ConsoleWriter{Out: w, TimeFormat: consoleTimeFormat}
+	case FormatJSON:
+	default:
+		return zerolog.Nop(), fmt.Errorf("unknown log format %q (must be one of %s, %s, or %s)", format, FormatAuto, FormatJSON, FormatConsole)
+	}
+	return zerolog.New(w).With().Timestamp().Logger(), nil
+}
+
+// Levels maps module names to the level their logs are written at. It
+// implements flag.Value, parsing a comma-separated list of MODULE=LEVEL
+// pairs such as "enactment=debug,telemetry=warn".
+type Levels map[string]zerolog.Level
+
+func (l Levels) String() string {
+	pairs := []string{}
+	for _, m := range slices.Sorted(maps.Keys(l)) {
+		pairs = append(pairs, m+"="+l[m].String())
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (l Levels) Set(value string) error {
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		module, levelName, ok := strings.Cut(pair, "=")
+		if !ok || module == "" {
+			return fmt.Errorf("invalid module level %q (must be MODULE=LEVEL)", pair)
+		}
+		level, err := zerolog.ParseLevel(levelName)
+		if err != nil {
+			return fmt.Errorf("invalid level for module %q: %w", module, err)
+		}
+		l[module] = level
+	}
+	return nil
+}
+
+// Config holds the level of the agent's logs and of each of its modules.
+// The levels can be changed while the agent runs, e.g. when its
+// configuration is reloaded, and the change applies to every logger
+// returned by WithConfig and Module, including those already in use.
+type Config struct {
+	mu      sync.RWMutex
+	level   zerolog.Level
+	modules Levels
+}
+
+// NewConfig returns a Config that logs at `level`, except for the modules in
+// `modules`.
+func NewConfig(level zerolog.Level, modules Levels) *Config {
+	c := &Config{}
+	c.Set(level, modules)
+	return c
+}
+
+// Set changes the levels of `c`.
+func (c *Config) Set(level zerolog.Level, modules Levels) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.level, c.modules = level, maps.Clone(modules)
+}
+
+// Levels returns the levels of `c`.
+func (c *Config) Levels() (zerolog.Level, Levels) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.level, maps.Clone(c.modules)
+}
+
+// levelOf returns the level of `module`, or of the logs of no module in
+// particular if it's empty.
+func (c *Config) levelOf(module string) zerolog.Level {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if level, ok := c.modules[module]; ok && module != "" {
+		return level
+	}
+	return c.level
+}
+
+// sampler drops the events below the current level of a module. Unlike
+// zerolog.Logger.Level, it's consulted for every event, and before the event
+// is built, so disabled events stay cheap.
+type sampler struct {
+	c      *Config
+	module string
+}
+
+func (s sampler) Sample(lvl zerolog.Level) bool { return lvl >= s.c.levelOf(s.module) }
+
+type configKey struct{}
+
+// WithConfig returns a context whose logger, and the loggers of the modules
+// derived from it with Module, log at the levels of `c`.
+func WithConfig(ctx context.Context, c *Config) context.Context {
+	log := zerolog.Ctx(ctx).Level(zerolog.TraceLevel).Sample(sampler{c: c})
+	return context.WithValue(log.WithContext(ctx), configKey{}, c)
+}
+
+// Module returns a context whose logger tags every line with `name` and logs
+// at the level configured for `name` by the context's Config, if any.
+// Modules without a configured level use the level of the rest of the
+// agent.
+func Module(ctx context.Context, name string) context.Context {
+	log := zerolog.Ctx(ctx).With().Str(ModuleKey, name).Logger()
+	if c, ok := ctx.Value(configKey{}).(*Config); ok {
+		log = log.Sample(sampler{c: c, module: name})
+	}
+	return log.WithContext(ctx)
+}
@@ -0,0 +1,177 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rs/zerolog"
+)
+
+func TestLevelsSet(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name    string
+		value   string
+		want    Levels
+		wantErr string
+	}{
+		{
+			name:  "single",
+			value: "enactment=debug",
+			want:  Levels{"enactment": zerolog.DebugLevel},
+		},
+		{
+			name:  "several",
+			value: "enactment=trace, telemetry=warn,,clocksync=disabled",
+			want: Levels{
+				"enactment": zerolog.TraceLevel,
+				"telemetry": zerolog.WarnLevel,
+				"clocksync": zerolog.Disabled,
+			},
+		},
+		{
+			name:    "missing level",
+			value:   "enactment",
+			wantErr: "must be MODULE=LEVEL",
+		},
+		{
+			name:    "bad level",
+			value:   "enactment=loud",
+			wantErr: `invalid level for module "enactment"`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := Levels{}
+			err := got.Set(tc.value)
+			switch {
+			case tc.wantErr != "":
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("Set(%q) returned error %v, want one containing %q", tc.value, err, tc.wantErr)
+				}
+				return
+			case err != nil:
+				t.Fatalf("Set(%q) returned unexpected error: %v", tc.value, err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("Set(%q) levels mismatch (-want +got):\n%s", tc.value, diff)
+			}
+		})
+	}
+}
+
+func TestLevelsString(t *testing.T) {
+	t.Parallel()
+
+	l := Levels{"telemetry": zerolog.WarnLevel, "enactment": zerolog.DebugLevel}
+	if got, want := l.String(), "enactment=debug,telemetry=warn"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+// loggedLines returns the level, module, and message of each JSON log line
+// in `buf`.
+func loggedLines(t *testing.T, buf *bytes.Buffer) []map[string]string {
+	t.Helper()
+
+	lines := []map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		entry := map[string]string{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("unmarshalling log line %q: %v", line, err)
+		}
+		lines = append(lines, entry)
+	}
+	buf.Reset()
+	return lines
+}
+
+func TestModule(t *testing.T) {
+	t.Parallel()
+
+	buf := &bytes.Buffer{}
+	c := NewConfig(zerolog.InfoLevel, Levels{"enactment": zerolog.DebugLevel, "telemetry": zerolog.Disabled})
+	ctx := WithConfig(zerolog.New(buf).WithContext(context.Background()), c)
+	enactmentCtx, telemetryCtx := Module(ctx, "enactment"), Module(ctx, "telemetry")
+
+	logAll := func() {
+		zerolog.Ctx(ctx).Debug().Msg("agent debug")
+		zerolog.Ctx(ctx).Info().Msg("agent info")
+		zerolog.Ctx(enactmentCtx).Debug().Msg("enactment debug")
+		zerolog.Ctx(telemetryCtx).Error().Msg("telemetry error")
+		zerolog.Ctx(Module(ctx, "clocksync")).Debug().Msg("clocksync debug")
+		zerolog.Ctx(Module(ctx, "clocksync")).Info().Msg("clocksync info")
+	}
+
+	logAll()
+	want := []map[string]string{
+		{"level": "info", "message": "agent info"},
+		{"level": "debug", "module": "enactment", "message": "enactment debug"},
+		{"level": "info", "module": "clocksync", "message": "clocksync info"},
+	}
+	if diff := cmp.Diff(want, loggedLines(t, buf)); diff != "" {
+		t.Errorf("logged lines mismatch (-want +got):\n%s", diff)
+	}
+
+	// Changing the levels applies to the loggers already handed out.
+	c.Set(zerolog.WarnLevel, Levels{"telemetry": zerolog.InfoLevel})
+	logAll()
+	want = []map[string]string{
+		{"level": "error", "module": "telemetry", "message": "telemetry error"},
+	}
+	if diff := cmp.Diff(want, loggedLines(t, buf)); diff != "" {
+		t.Errorf("logged lines after Set mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		format      string
+		interactive bool
+		wantJSON    bool
+	}{
+		{format: FormatAuto, interactive: false, wantJSON: true},
+		{format: FormatAuto, interactive: true, wantJSON: false},
+		{format: FormatJSON, interactive: true, wantJSON: true},
+		{format: FormatConsole, interactive: false, wantJSON: false},
+	} {
+		buf := &bytes.Buffer{}
+		log, err := New(buf, tc.format, tc.interactive)
+		if err != nil {
+			t.Fatalf("New(%q, %v) returned unexpected error: %v", tc.format, tc.interactive, err)
+		}
+		log.Info().Msg("hello")
+		if got := json.Valid(buf.Bytes()); got != tc.wantJSON {
+			t.Errorf("New(%q, %v) wrote %q, which is valid JSON: %v, want %v", tc.format, tc.interactive, buf.String(), got, tc.wantJSON)
+		}
+	}
+
+	if _, err := New(&bytes.Buffer{}, "xml", false); err == nil {
+		t.Errorf(`New("xml") succeeded, want an error`)
+	}
+}
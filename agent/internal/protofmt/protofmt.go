@@ -15,12 +15,14 @@
 package protofmt
 
 import (
+	"encoding/json"
 	"fmt"
 	"strings"
 
 	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/encoding/prototext"
 	"google.golang.org/protobuf/proto"
+	"gopkg.in/yaml.v3"
 )
 
 type Format int
@@ -29,6 +31,9 @@ const (
 	JSON Format = iota
 	Wire
 	Text
+	// YAML is the YAML equivalent of the JSON format, which is often more
+	// convenient to write by hand.
+	YAML
 )
 
 func FromString(s string) (Format, error) {
@@ -39,6 +44,8 @@ func FromString(s string) (Format, error) {
 		return JSON, nil
 	case "wire":
 		return Wire, nil
+	case "yaml", "yml":
+		return YAML, nil
 	default:
 		return 0, fmt.Errorf("unknown proto format: %q", s)
 	}
@@ -52,6 +59,8 @@ func (pf Format) String() string {
 		return "text"
 	case Wire:
 		return "wire"
+	case YAML:
+		return "YAML"
 	default:
 		return "unknown"
 	}
@@ -65,6 +74,17 @@ func (pf Format) Marshal(m proto.Message) ([]byte, error) {
 		return prototext.Marshal(m)
 	case Wire:
 		return proto.Marshal(m)
+	case YAML:
+		data, err := protojson.Marshal(m)
+		if err != nil {
+			return nil, err
+		}
+		// JSON is valid YAML, so it can be decoded and reencoded as YAML.
+		var v any
+		if err := yaml.Unmarshal(data, &v); err != nil {
+			return nil, err
+		}
+		return yaml.Marshal(v)
 	default:
 		return nil, fmt.Errorf("marshal: unknown format: %v", pf)
 	}
@@ -78,7 +98,42 @@ func (pf Format) Unmarshal(data []byte, m proto.Message) error {
 		return prototext.Unmarshal(data, m)
 	case Wire:
 		return proto.Unmarshal(data, m)
+	case YAML:
+		var v any
+		if err := yaml.Unmarshal(data, &v); err != nil {
+			return err
+		}
+		data, err := json.Marshal(jsonValue(v))
+		if err != nil {
+			return err
+		}
+		return protojson.Unmarshal(data, m)
 	default:
 		return fmt.Errorf("unmarshal: unknown format: %v", pf)
 	}
 }
+
+// jsonValue converts the maps decoded from YAML, whose keys needn't be
+// strings, into maps that can be encoded as JSON.
+func jsonValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			v[k] = jsonValue(e)
+		}
+		return v
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = jsonValue(e)
+		}
+		return m
+	case []any:
+		for i, e := range v {
+			v[i] = jsonValue(e)
+		}
+		return v
+	default:
+		return v
+	}
+}
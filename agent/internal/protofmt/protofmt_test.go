@@ -17,10 +17,14 @@ package protofmt
 import (
 	"fmt"
 	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
 func TestString_roundTripping(t *testing.T) {
-	for _, n := range []Format{JSON, Wire, Text} {
+	for _, n := range []Format{JSON, Wire, Text, YAML} {
 		t.Run(n.String(), func(t *testing.T) {
 			pf, err := FromString(n.String())
 			if err != nil {
@@ -36,7 +40,7 @@ func TestString_roundTripping(t *testing.T) {
 }
 
 func TestFromString(t *testing.T) {
-	for s, f := range map[string]Format{"json": JSON, "JSON": JSON, "text": Text, "wire": Wire} {
+	for s, f := range map[string]Format{"json": JSON, "JSON": JSON, "text": Text, "wire": Wire, "yaml": YAML, "yml": YAML} {
 		t.Run(fmt.Sprintf("%q -> %v", s, f), func(t *testing.T) {
 			pf, err := FromString(s)
 			if err != nil {
@@ -50,3 +54,40 @@ func TestFromString(t *testing.T) {
 		})
 	}
 }
+
+func TestYAML(t *testing.T) {
+	data := []byte(`
+name: agent
+nodes:
+  - id: node-a
+    ports: {1: eth0, 2: eth1}
+`)
+	got := &structpb.Struct{}
+	if err := YAML.Unmarshal(data, got); err != nil {
+		t.Fatalf("got unexpected error from Unmarshal: %v", err)
+	}
+	want, err := structpb.NewStruct(map[string]any{
+		"name": "agent",
+		"nodes": []any{
+			map[string]any{"id": "node-a", "ports": map[string]any{"1": "eth0", "2": "eth1"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got, protocmp.Transform()); diff != "" {
+		t.Errorf("Unmarshal mismatch (-want +got):\n%s", diff)
+	}
+
+	marshalled, err := YAML.Marshal(want)
+	if err != nil {
+		t.Fatalf("got unexpected error from Marshal: %v", err)
+	}
+	roundTripped := &structpb.Struct{}
+	if err := YAML.Unmarshal(marshalled, roundTripped); err != nil {
+		t.Fatalf("got unexpected error from Unmarshal(Marshal()): %v", err)
+	}
+	if diff := cmp.Diff(want, roundTripped, protocmp.Transform()); diff != "" {
+		t.Errorf("round-tripping mismatch (-want +got):\n%s", diff)
+	}
+}
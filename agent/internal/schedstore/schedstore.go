@@ -0,0 +1,196 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package schedstore persists the schedule entries an agent has accepted, so
+// that they can be enacted even if the agent restarts before it's able to
+// reconnect to the controller, along with a ledger of the entries it has
+// already dispatched, so that they aren't enacted again when the controller
+// re-sends them.
+package schedstore
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	schedpb "aalyria.com/spacetime/api/scheduling/v1alpha"
+
+	"google.golang.org/protobuf/proto"
+)
+
+const entryExt = ".binpb"
+
+// dispatchedDir is the subdirectory holding the dispatch ledger.
+const dispatchedDir = "dispatched"
+
+// Store keeps each entry in its own file within a directory, named after the
+// entry's ID. Entries are written atomically, so a crash never leaves a
+// partially written entry behind. A Store isn't safe for concurrent use by
+// multiple agents.
+type Store struct {
+	dir string
+}
+
+// New returns a Store that keeps entries in `dir`, creating it if needed.
+func New(dir string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Join(dir, dispatchedDir), 0o700); err != nil {
+		return nil, fmt.Errorf("creating schedule state directory: %w", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+func (s *Store) path(id string) string {
+	return filepath.Join(s.dir, url.PathEscape(id)+entryExt)
+}
+
+// Put stores `entry`, replacing any existing entry with the same ID.
+func (s *Store) Put(entry *schedpb.CreateEntryRequest) error {
+	data, err := proto.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("encoding entry %q: %w", entry.GetId(), err)
+	}
+	if err := writeFileAtomic(s.path(entry.GetId()), data); err != nil {
+		return fmt.Errorf("writing entry %q: %w", entry.GetId(), err)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces the file at `path` with `data`, so that readers
+// see either the old or the new contents, never a mix.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".entry-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Delete removes the entry with the provided ID. Deleting an entry that
+// doesn't exist isn't an error.
+func (s *Store) Delete(id string) error {
+	if err := os.Remove(s.path(id)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// List returns all stored entries, ordered by their scheduled time.
+func (s *Store) List() ([]*schedpb.CreateEntryRequest, error) {
+	dirents, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []*schedpb.CreateEntryRequest{}
+	errs := []error{}
+	for _, de := range dirents {
+		name := de.Name()
+		if de.IsDir() || strings.HasPrefix(name, ".") || filepath.Ext(name) != entryExt {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(s.dir, name))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		entry := &schedpb.CreateEntryRequest{}
+		if err := proto.Unmarshal(data, entry); err != nil {
+			errs = append(errs, fmt.Errorf("decoding %s: %w", name, err))
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	slices.SortFunc(entries, func(a, b *schedpb.CreateEntryRequest) int {
+		return cmp.Or(a.GetTime().AsTime().Compare(b.GetTime().AsTime()), cmp.Compare(a.GetId(), b.GetId()))
+	})
+	return entries, errors.Join(errs...)
+}
+
+func (s *Store) dispatchedPath(id string) string {
+	return filepath.Join(s.dir, dispatchedDir, url.PathEscape(id))
+}
+
+// MarkDispatched records in the dispatch ledger that `entry` is being
+// enacted. Entries are marked before they're handed to the enactment driver,
+// so an agent that crashes mid-dispatch errs on the side of not enacting the
+// entry again.
+func (s *Store) MarkDispatched(entry *schedpb.CreateEntryRequest) error {
+	data := []byte(entry.GetTime().AsTime().UTC().Format(time.RFC3339Nano))
+	if err := writeFileAtomic(s.dispatchedPath(entry.GetId()), data); err != nil {
+		return fmt.Errorf("recording dispatch of entry %q: %w", entry.GetId(), err)
+	}
+	return nil
+}
+
+// Dispatched reports whether the entry with the provided ID is in the
+// dispatch ledger.
+func (s *Store) Dispatched(id string) (bool, error) {
+	switch _, err := os.Stat(s.dispatchedPath(id)); {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, fs.ErrNotExist):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// PruneDispatched removes the ledger records of the entries scheduled before
+// `before`. Records that can't be read are removed too, since they can't be
+// trusted anyway.
+func (s *Store) PruneDispatched(before time.Time) error {
+	dir := filepath.Join(s.dir, dispatchedDir)
+	dirents, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	errs := []error{}
+	for _, de := range dirents {
+		if de.IsDir() || strings.HasPrefix(de.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, de.Name())
+		if data, err := os.ReadFile(path); err == nil {
+			if t, err := time.Parse(time.RFC3339Nano, string(data)); err == nil && !t.Before(before) {
+				continue
+			}
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,133 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schedstore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	schedpb "aalyria.com/spacetime/api/scheduling/v1alpha"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func entry(id string, t time.Time) *schedpb.CreateEntryRequest {
+	return &schedpb.CreateEntryRequest{Id: id, Time: timestamppb.New(t)}
+}
+
+func TestStore(t *testing.T) {
+	t.Parallel()
+
+	dir := filepath.Join(t.TempDir(), "schedule")
+	s, err := New(dir)
+	if err != nil {
+		t.Fatalf("New(%q) failed: %v", dir, err)
+	}
+
+	now := time.Now()
+	for _, e := range []*schedpb.CreateEntryRequest{
+		entry("late", now.Add(time.Hour)),
+		entry("a/b", now),
+		entry("early", now.Add(-time.Hour)),
+		entry("late", now.Add(2*time.Hour)),
+	} {
+		if err := s.Put(e); err != nil {
+			t.Fatalf("Put(%v) failed: %v", e, err)
+		}
+	}
+	if err := s.Delete("early"); err != nil {
+		t.Fatalf("Delete() failed: %v", err)
+	}
+	if err := s.Delete("never-stored"); err != nil {
+		t.Fatalf("Delete() of a missing entry failed: %v", err)
+	}
+
+	// Entries survive being reopened.
+	s, err = New(dir)
+	if err != nil {
+		t.Fatalf("New(%q) failed: %v", dir, err)
+	}
+	got, err := s.List()
+	if err != nil {
+		t.Fatalf("List() failed: %v", err)
+	}
+	want := []*schedpb.CreateEntryRequest{entry("a/b", now), entry("late", now.Add(2*time.Hour))}
+	if diff := cmp.Diff(want, got, protocmp.Transform()); diff != "" {
+		t.Errorf("List() returned unexpected entries (-want +got):\n%s", diff)
+	}
+}
+
+func TestStore_List_skipsCorruptEntries(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	s, err := New(dir)
+	if err != nil {
+		t.Fatalf("New(%q) failed: %v", dir, err)
+	}
+	now := time.Now()
+	if err := s.Put(entry("ok", now)); err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "corrupt"+entryExt), []byte("\xff\xff"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.List()
+	if err == nil {
+		t.Errorf("List() succeeded, want an error for the corrupt entry")
+	}
+	if diff := cmp.Diff([]*schedpb.CreateEntryRequest{entry("ok", now)}, got, protocmp.Transform()); diff != "" {
+		t.Errorf("List() returned unexpected entries (-want +got):\n%s", diff)
+	}
+}
+
+func TestStore_dispatchLedger(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	s, err := New(dir)
+	if err != nil {
+		t.Fatalf("New(%q) failed: %v", dir, err)
+	}
+
+	now := time.Now()
+	for _, e := range []*schedpb.CreateEntryRequest{entry("old", now.Add(-2*time.Hour)), entry("a/b", now)} {
+		if err := s.MarkDispatched(e); err != nil {
+			t.Fatalf("MarkDispatched(%v) failed: %v", e, err)
+		}
+	}
+	// The ledger isn't mistaken for stored entries.
+	if got, err := s.List(); err != nil || len(got) != 0 {
+		t.Errorf("List() = %v, %v; want no entries", got, err)
+	}
+
+	if err := s.PruneDispatched(now.Add(-time.Hour)); err != nil {
+		t.Fatalf("PruneDispatched() failed: %v", err)
+	}
+	s, err = New(dir)
+	if err != nil {
+		t.Fatalf("New(%q) failed: %v", dir, err)
+	}
+	for id, want := range map[string]bool{"a/b": true, "old": false, "never-dispatched": false} {
+		if got, err := s.Dispatched(id); err != nil || got != want {
+			t.Errorf("Dispatched(%q) = %v, %v; want %v, nil", id, got, err, want)
+		}
+	}
+}
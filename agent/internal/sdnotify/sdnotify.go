@@ -0,0 +1,104 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sdnotify implements the client side of systemd's service
+// notification protocol (see sd_notify(3)), so the agent can run as a
+// Type=notify service and be supervised by systemd's watchdog.
+package sdnotify
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The states a Notifier reports most often.
+const (
+	// Ready tells the service manager that the service finished starting
+	// up.
+	Ready = "READY=1"
+	// Stopping tells the service manager that the service is shutting down.
+	Stopping = "STOPPING=1"
+	// Watchdog keeps the service manager's watchdog from restarting the
+	// service.
+	Watchdog = "WATCHDOG=1"
+)
+
+// Status returns the state that sets the service's status line, as shown by
+// `systemctl status`.
+func Status(s string) string {
+	return "STATUS=" + strings.ReplaceAll(s, "\n", " ")
+}
+
+// Notifier sends notifications to the service manager's socket.
+type Notifier struct {
+	addr *net.UnixAddr
+}
+
+// New returns a Notifier that sends notifications to the datagram socket
+// at `socket`. A leading "@" selects Linux's abstract namespace.
+func New(socket string) *Notifier {
+	return &Notifier{addr: &net.UnixAddr{Name: socket, Net: "unixgram"}}
+}
+
+// FromEnv returns a Notifier for the socket in $NOTIFY_SOCKET, or nil if the
+// process wasn't started by a service manager that expects notifications.
+func FromEnv() *Notifier {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	return New(socket)
+}
+
+// Notify sends `states`, such as [Ready] or [Status], to the service manager
+// in a single notification.
+func (n *Notifier) Notify(states ...string) error {
+	conn, err := net.DialUnix(n.addr.Net, nil, n.addr)
+	if err != nil {
+		return fmt.Errorf("connecting to the notification socket: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(strings.Join(states, "\n"))); err != nil {
+		return fmt.Errorf("sending notification: %w", err)
+	}
+	return nil
+}
+
+// WatchdogInterval returns how often the service manager expects
+// [Watchdog] notifications from this process, per $WATCHDOG_USEC and
+// $WATCHDOG_PID, or 0 if it doesn't.
+func WatchdogInterval() (time.Duration, error) {
+	usec := os.Getenv("WATCHDOG_USEC")
+	if usec == "" {
+		return 0, nil
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		// The watchdog is meant for another process, such as our parent.
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parsing $WATCHDOG_USEC: %w", err)
+	}
+	if n <= 0 {
+		return 0, errors.New("$WATCHDOG_USEC must be positive")
+	}
+	return time.Duration(n) * time.Microsecond, nil
+}
@@ -0,0 +1,95 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sdnotify
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNotifier(t *testing.T) {
+	t.Parallel()
+
+	socket := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := New(socket).Notify(Ready, Status("running 2\nnodes")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(buf[:n]), "READY=1\nSTATUS=running 2 nodes"; got != want {
+		t.Errorf("got notification %q, want %q", got, want)
+	}
+}
+
+func TestNotifier_noListener(t *testing.T) {
+	t.Parallel()
+
+	if err := New(filepath.Join(t.TempDir(), "missing.sock")).Notify(Ready); err == nil {
+		t.Error("Notify() succeeded without a listener, want an error")
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if n := FromEnv(); n != nil {
+		t.Errorf("FromEnv() = %v without $NOTIFY_SOCKET, want nil", n)
+	}
+	t.Setenv("NOTIFY_SOCKET", "@agent")
+	if n := FromEnv(); n == nil || n.addr.Name != "@agent" {
+		t.Errorf("FromEnv() = %v, want a notifier for @agent", n)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	for _, tc := range []struct {
+		desc, usec, pid string
+		want            time.Duration
+		wantErr         bool
+	}{
+		{desc: "unset"},
+		{desc: "this process", usec: "30000000", pid: strconv.Itoa(os.Getpid()), want: 30 * time.Second},
+		{desc: "any process", usec: "500000", want: 500 * time.Millisecond},
+		{desc: "another process", usec: "30000000", pid: strconv.Itoa(os.Getpid() + 1)},
+		{desc: "malformed", usec: "30s", wantErr: true},
+		{desc: "zero", usec: "0", wantErr: true},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Setenv("WATCHDOG_USEC", tc.usec)
+			t.Setenv("WATCHDOG_PID", tc.pid)
+
+			got, err := WatchdogInterval()
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("WatchdogInterval() = %v, %v, want error: %v", got, err, tc.wantErr)
+			}
+			if got != tc.want {
+				t.Errorf("WatchdogInterval() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
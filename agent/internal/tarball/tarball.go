@@ -0,0 +1,61 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tarball writes gzip-compressed tar archives of in-memory files,
+// such as the diagnostics bundles attached to support tickets.
+package tarball
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"time"
+)
+
+// Writer adds files to a gzip-compressed tar archive. Close must be called
+// to flush the archive to the underlying writer.
+type Writer struct {
+	gw      *gzip.Writer
+	tw      *tar.Writer
+	modTime time.Time
+}
+
+// NewWriter returns a Writer that writes an archive to `w`, with every file
+// modified at `modTime`.
+func NewWriter(w io.Writer, modTime time.Time) *Writer {
+	gw := gzip.NewWriter(w)
+	return &Writer{gw: gw, tw: tar.NewWriter(gw), modTime: modTime}
+}
+
+// Add adds a regular file with the provided name and contents.
+func (w *Writer) Add(name string, data []byte) error {
+	if err := w.tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0o644,
+		Size:    int64(len(data)),
+		ModTime: w.modTime,
+	}); err != nil {
+		return err
+	}
+	_, err := w.tw.Write(data)
+	return err
+}
+
+// Close finishes the archive. It doesn't close the underlying writer.
+func (w *Writer) Close() error {
+	if err := w.tw.Close(); err != nil {
+		return err
+	}
+	return w.gw.Close()
+}
@@ -19,12 +19,15 @@ package task
 import (
 	"context"
 	"crypto/rand"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"math"
 	"math/big"
+	"sync"
 	"time"
 
+	"aalyria.com/spacetime/agent/internal/logging"
 	"github.com/jonboulle/clockwork"
 	"github.com/rs/zerolog"
 	"go.opentelemetry.io/otel/attribute"
@@ -43,6 +46,82 @@ type RetryConfig struct {
 	MaxRetries      int
 	BackoffDuration time.Duration
 	ErrIsFatal      func(error) bool
+
+	// MaxBackoff, if set, makes the backoff exponential: it doubles with
+	// each consecutive failure, starting from BackoffDuration, up to
+	// MaxBackoff. An attempt that runs for at least MaxBackoff before
+	// failing resets it, since the failure is unlikely to be related to the
+	// previous ones.
+	MaxBackoff time.Duration
+	// Stats, if set, counts the attempts and retries.
+	Stats *RetryStats
+}
+
+// RetryStats counts the attempts of a task run with WithRetries. It's safe
+// for concurrent use, and its JSON encoding is a snapshot of the counters,
+// suitable for exporting with expvar.
+type RetryStats struct {
+	mu                  sync.Mutex
+	attempts            int64
+	retries             int64
+	consecutiveFailures int64
+	lastError           string
+	lastBackoff         time.Duration
+}
+
+// RetryStatsSnapshot is a point-in-time copy of a RetryStats.
+type RetryStatsSnapshot struct {
+	// Attempts is the number of times the task was started.
+	Attempts int64
+	// Retries is the number of times the task was restarted after failing.
+	Retries int64
+	// ConsecutiveFailures is the number of times the task has failed since
+	// the backoff was last reset.
+	ConsecutiveFailures int64
+	LastError           string
+	LastBackoff         time.Duration
+}
+
+// Snapshot returns the current value of the counters.
+func (s *RetryStats) Snapshot() RetryStatsSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return RetryStatsSnapshot{
+		Attempts:            s.attempts,
+		Retries:             s.retries,
+		ConsecutiveFailures: s.consecutiveFailures,
+		LastError:           s.lastError,
+		LastBackoff:         s.lastBackoff,
+	}
+}
+
+func (s *RetryStats) MarshalJSON() ([]byte, error) { return json.Marshal(s.Snapshot()) }
+
+func (s *RetryStats) update(fn func(*RetryStats)) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(s)
+}
+
+// backoff returns the delay before the next attempt after `failures`
+// consecutive failures, jittered to within [0.5, 1.5] times its nominal
+// value so that many agents don't retry in lockstep.
+func (rc RetryConfig) backoff(failures int) time.Duration {
+	delay := rc.BackoffDuration
+	if rc.MaxBackoff > 0 {
+		for i := 1; i < failures && delay < rc.MaxBackoff; i++ {
+			delay *= 2
+		}
+		delay = min(delay, rc.MaxBackoff)
+	}
+
+	randFact := Float64() - 0.5
+	jitterMs := time.Millisecond * time.Duration(
+		math.Round(randFact*float64(delay.Milliseconds())))
+	return delay + jitterMs
 }
 
 // Float64 generates a float64 between [0, 1). It uses the technique described
@@ -54,7 +133,7 @@ func Float64() float64 {
 	if err != nil {
 		panic(err)
 	}
-	return float64(nBig.Int64() / maxMantissa)
+	return float64(nBig.Int64()) / maxMantissa
 }
 
 // WithRetries returns a new task that will retry the inner task
@@ -63,6 +142,7 @@ func (t Task) WithRetries(rc RetryConfig) Task {
 	return func(ctx context.Context) error {
 		log := zerolog.Ctx(ctx)
 		var err error
+		failures := 0
 
 		for retryCount := 0; rc.MaxRetries == 0 || retryCount <= rc.MaxRetries; retryCount++ {
 			select {
@@ -71,19 +151,32 @@ func (t Task) WithRetries(rc RetryConfig) Task {
 			default:
 			}
 
+			rc.Stats.update(func(s *RetryStats) {
+				s.attempts++
+				if retryCount > 0 {
+					s.retries++
+				}
+			})
+			start := rc.Clock.Now()
 			if err = t(ctx); err != nil {
 				if rc.ErrIsFatal != nil && rc.ErrIsFatal(err) {
 					return err
 				}
 
-				// delayDur is within [0.5 * backoff, 1.5 * backoff]
-				randFact := Float64() - 0.5
-				jitterMs := time.Millisecond * time.Duration(
-					math.Round(randFact*float64(rc.BackoffDuration.Milliseconds())))
-				delayDur := rc.BackoffDuration + jitterMs
+				if rc.MaxBackoff > 0 && rc.Clock.Since(start) >= rc.MaxBackoff {
+					failures = 0
+				}
+				failures++
+				delayDur := rc.backoff(failures)
+				rc.Stats.update(func(s *RetryStats) {
+					s.consecutiveFailures = int64(failures)
+					s.lastError = err.Error()
+					s.lastBackoff = delayDur
+				})
 
 				log.Error().
 					Err(err).
+					Int("consecutiveFailures", failures).
 					Dur("backoffDelay", delayDur).
 					Msg("error, retrying shortly")
 
@@ -120,6 +213,14 @@ func (t Task) WithLogField(k, v string) Task {
 	})
 }
 
+// WithLogModule returns a new task whose logs are tagged with the module
+// `name` and written at the level configured for it (see logging.Module).
+func (t Task) WithLogModule(name string) Task {
+	return func(ctx context.Context) error {
+		return t(logging.Module(ctx, name))
+	}
+}
+
 // WithStartingStoppingLogs returns a new task that will log a "starting"
 // message before and a "stopping" message after invoking the inner task.
 func (t Task) WithStartingStoppingLogs(name string, lvl zerolog.Level) Task {
@@ -19,7 +19,9 @@ import (
 	"errors"
 	"reflect"
 	"testing"
+	"time"
 
+	"github.com/jonboulle/clockwork"
 	otelsdktrace "go.opentelemetry.io/otel/sdk/trace"
 	oteltracenoop "go.opentelemetry.io/otel/trace/noop"
 )
@@ -94,3 +96,86 @@ func TestWithPanicCatcher(t *testing.T) {
 		t.Errorf("expected result from WithPanicCatcher to be %v, but got %v", want, got)
 	}
 }
+
+func TestFloat64(t *testing.T) {
+	nonZero := false
+	for range 100 {
+		f := Float64()
+		if f < 0 || f >= 1 {
+			t.Fatalf("Float64() = %v, want a value in [0, 1)", f)
+		}
+		nonZero = nonZero || f != 0
+	}
+	if !nonZero {
+		t.Errorf("Float64() returned 0 every time")
+	}
+}
+
+func TestWithRetries_exponentialBackoff(t *testing.T) {
+	clock := clockwork.NewFakeClock()
+	stats := &RetryStats{}
+	errFailed := errors.New("failed")
+	rc := RetryConfig{
+		Clock:           clock,
+		MaxRetries:      4,
+		BackoffDuration: time.Second,
+		MaxBackoff:      4 * time.Second,
+		Stats:           stats,
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- Task(func(context.Context) error { return errFailed }).WithRetries(rc)(context.Background())
+	}()
+
+	for i, nominal := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		clock.BlockUntil(1)
+		got := stats.Snapshot()
+		if got.ConsecutiveFailures != int64(i+1) || got.Attempts != int64(i+1) || got.Retries != int64(i) {
+			t.Fatalf("after failure %d, got stats %+v", i+1, got)
+		}
+		if got.LastBackoff < nominal/2 || got.LastBackoff > nominal*3/2 {
+			t.Errorf("after failure %d, backoff was %s, want within 50%% of %s", i+1, got.LastBackoff, nominal)
+		}
+		clock.Advance(got.LastBackoff)
+	}
+	clock.BlockUntil(1)
+	clock.Advance(rc.MaxBackoff * 2)
+
+	if err := <-done; err != errFailed {
+		t.Errorf("WithRetries returned %v, want %v", err, errFailed)
+	}
+	if got := stats.Snapshot(); got.Attempts != 5 || got.Retries != 4 || got.LastError != errFailed.Error() {
+		t.Errorf("got final stats %+v", got)
+	}
+}
+
+func TestWithRetries_longAttemptResetsBackoff(t *testing.T) {
+	clock := clockwork.NewFakeClock()
+	stats := &RetryStats{}
+	rc := RetryConfig{
+		Clock:           clock,
+		MaxRetries:      2,
+		BackoffDuration: time.Second,
+		MaxBackoff:      time.Minute,
+		Stats:           stats,
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- Task(func(context.Context) error {
+			// The attempt runs for long enough to count as a fresh start.
+			clock.Advance(rc.MaxBackoff)
+			return errors.New("failed")
+		}).WithRetries(rc)(context.Background())
+	}()
+
+	for range rc.MaxRetries + 1 {
+		clock.BlockUntil(1)
+		if got := stats.Snapshot(); got.ConsecutiveFailures != 1 {
+			t.Fatalf("got stats %+v, want the backoff to be reset", got)
+		}
+		clock.Advance(2 * time.Second)
+	}
+	<-done
+}
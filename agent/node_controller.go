@@ -18,8 +18,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
+	"sync/atomic"
 	"time"
 
+	"aalyria.com/spacetime/agent/clocksync"
+	"aalyria.com/spacetime/agent/eventlog"
+	"aalyria.com/spacetime/agent/internal/health"
+	"aalyria.com/spacetime/agent/internal/schedstore"
 	"aalyria.com/spacetime/agent/internal/task"
 	apipb "aalyria.com/spacetime/api/common"
 	schedpb "aalyria.com/spacetime/api/scheduling/v1alpha"
@@ -32,19 +38,42 @@ import (
 	"google.golang.org/grpc/status"
 )
 
+const (
+	// streamBackoff is how long a node waits before re-establishing a
+	// stream to the controller that broke. It doubles with each consecutive
+	// failure, up to streamMaxBackoff.
+	streamBackoff    = 5 * time.Second
+	streamMaxBackoff = 2 * time.Minute
+)
+
 // nodeController is the logical owner of a node and its various services
 // (telemetry, enactments, etc.).
 type nodeController struct {
 	// The node ID this controller is responsible for.
 	id string
 	// done is called when the controller should stop.
-	done      func()
-	clock     clockwork.Clock
-	initState *apipb.ControlPlaneState
-	services  []task.Task
-
-	enactmentStats func() interface{}
-	telemetryStats func() interface{}
+	done       func()
+	clock      clockwork.Clock
+	clockGuard *clocksync.Guard
+	events     *eventlog.Writer
+	health     *health.Monitor
+	initState  *apipb.ControlPlaneState
+	services   []task.Task
+	// enactment is the node's enactment service, if it has one.
+	enactment *enactmentService
+	// telemetry is the node's telemetry service, if it has one.
+	telemetry *telemetryService
+	// draining is set once the node has started draining (see drain).
+	draining atomic.Bool
+
+	enactmentStats      func() interface{}
+	telemetryStats      func() interface{}
+	telemetryQueueStats func() interface{}
+
+	// enactmentRetries and telemetryRetries count the attempts to
+	// (re-)establish each service's stream, if it's enabled.
+	enactmentRetries *task.RetryStats
+	telemetryRetries *task.RetryStats
 
 	closers []func() error
 
@@ -53,16 +82,21 @@ type nodeController struct {
 
 func (a *Agent) newNodeController(node *node, done func()) (*nodeController, error) {
 	nc := &nodeController{
-		id:             node.id,
-		done:           done,
-		clock:          a.clock,
-		enactmentStats: func() interface{} { return nil },
-		telemetryStats: func() interface{} { return nil },
-		newToken:       uuid.NewString,
+		id:                  node.id,
+		done:                done,
+		clock:               a.clock,
+		clockGuard:          a.clockGuard,
+		events:              a.events,
+		health:              health.NewMonitor(a.clock),
+		enactmentStats:      func() interface{} { return nil },
+		telemetryStats:      func() interface{} { return nil },
+		telemetryQueueStats: func() interface{} { return nil },
+		newToken:            uuid.NewString,
 	}
 
 	rc := task.RetryConfig{
-		BackoffDuration: 5 * time.Second,
+		BackoffDuration: streamBackoff,
+		MaxBackoff:      streamMaxBackoff,
 		ErrIsFatal: func(err error) bool {
 			switch c := status.Code(err); {
 			case c == codes.Unauthenticated, c == codes.Canceled, c == codes.Unimplemented, errors.Is(err, context.Canceled):
@@ -76,47 +110,87 @@ func (a *Agent) newNodeController(node *node, done func()) (*nodeController, err
 
 	nc.services = []task.Task{}
 	if node.telemetryEnabled {
-		telemetryConn, err := grpc.NewClient(node.telemetryEndpoint, node.telemetryDialOpts...)
+		telemetryConn, err := nc.clientConn(a, node.telemetryConn, node.telemetryEndpoint, node.telemetryDialOpts, node.id+"/telemetry")
 		if err != nil {
 			return nil, fmt.Errorf("failed connecting to telemetry endpoint: %w", err)
 		}
-		nc.closers = append(nc.closers, telemetryConn.Close)
 
 		telemetryClient := telemetrypb.NewTelemetryClient(telemetryConn)
 
 		ts := nc.newTelemetryService(telemetryClient, node.td)
+		ts.allowReport = a.bandwidth.AllowTelemetry
+		if a.telemetryQueue != nil {
+			ts.queue = newTelemetryQueue(*a.telemetryQueue, nc.clock.Now)
+			nc.telemetryQueueStats = ts.queue.Stats
+		}
+		nc.health.Add("telemetry_export", ts.exports.Check)
 
 		nc.services = append(nc.services, task.Task(ts.run).
 			WithNewSpan("telemetry_service").
 			WithLogField("service", "telemetry").
-			WithRetries(rc).
+			WithLogModule("telemetry").
+			WithRetries(nc.retryConfig(rc, &nc.telemetryRetries)).
 			WithPanicCatcher())
 
 		nc.telemetryStats = ts.Stats
+		nc.telemetry = ts
 	}
 
 	if node.enactmentsEnabled {
-		enactmentConn, err := grpc.NewClient(node.enactmentEndpoint, node.enactmentDialOpts...)
+		enactmentConn, err := nc.clientConn(a, node.enactmentConn, node.enactmentEndpoint, node.enactmentDialOpts, node.id+"/enactment")
 		if err != nil {
 			return nil, fmt.Errorf("failed connecting to enactment endpoint: %w", err)
 		}
-		nc.closers = append(nc.closers, enactmentConn.Close)
 
-		schedClient := schedpb.NewSchedulingClient(enactmentConn)
+		var schedClient schedpb.SchedulingClient = schedpb.NewSchedulingClient(enactmentConn)
+		if node.enactmentHTTPFallbackURL != "" {
+			schedClient = newFallbackSchedulingClient(schedClient, newHTTPSchedulingClient(node.enactmentHTTPFallbackURL, node.enactmentHTTPClient))
+		}
 		es := nc.newEnactmentService(schedClient, node.ed, nc.newToken())
+		if node.scheduleStateDir != "" {
+			if es.store, err = schedstore.New(node.scheduleStateDir); err != nil {
+				return nil, err
+			}
+		}
 
 		nc.services = append(nc.services, task.Task(es.run).
 			WithNewSpan("enactment_service").
 			WithLogField("service", "enactment").
-			WithRetries(rc).
+			WithLogModule("enactment").
+			WithRetries(nc.retryConfig(rc, &nc.enactmentRetries)).
 			WithPanicCatcher())
 
+		nc.enactment = es
 		nc.enactmentStats = es.Stats
+		nc.health.Add("scheduling_stream", es.stream.Check)
+		nc.health.Add("enactment_backend", es.backend.Check)
+		nc.health.Add("queue_depth", health.QueueDepthCheck(func() int { return int(es.queueDepth.Load()) }, healthQueueDepthLimit))
+	}
+
+	if nc.clockGuard != nil {
+		nc.health.Add("clock_skew", health.ClockSkewCheck(nc.clockGuard))
 	}
 
 	return nc, nil
 }
 
+// clientConn returns the shared connection named `shared`, if set, and
+// otherwise dials a connection to `endpoint` that's closed when the
+// controller stops. The traffic of a dialed connection is accounted for as
+// `stream`.
+func (nc *nodeController) clientConn(a *Agent, shared, endpoint string, dialOpts []grpc.DialOption, stream string) (grpc.ClientConnInterface, error) {
+	if shared != "" {
+		return a.conns[shared].cc, nil
+	}
+	dialOpts = append(slices.Clip(dialOpts), grpc.WithStatsHandler(a.bandwidth.StatsHandler(stream)))
+	cc, err := grpc.NewClient(endpoint, dialOpts...)
+	if err != nil {
+		return nil, err
+	}
+	nc.closers = append(nc.closers, cc.Close)
+	return cc, nil
+}
+
 func (nc *nodeController) run(ctx context.Context) (resErr error) {
 	defer func() {
 		nc.done()
@@ -131,14 +205,47 @@ func (nc *nodeController) run(ctx context.Context) (resErr error) {
 	return task.Group(nc.services...).WithPanicCatcher()(ctx)
 }
 
+// drain finishes the node's work in flight, so that it can be stopped
+// without losing any, until `ctx` is done: no more entries are dispatched,
+// and those already dispatched are waited for, then the telemetry the
+// driver has gathered is flushed along with a final assessment of the node.
+func (nc *nodeController) drain(ctx context.Context) error {
+	nc.draining.Store(true)
+
+	errs := []error{}
+	if nc.enactment != nil {
+		errs = append(errs, nc.enactment.drain(ctx))
+	}
+	if nc.telemetry != nil {
+		errs = append(errs, nc.telemetry.drain(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+// retryConfig returns a copy of `rc` whose attempts are counted by a new
+// RetryStats, stored in `stats`.
+func (nc *nodeController) retryConfig(rc task.RetryConfig, stats **task.RetryStats) task.RetryConfig {
+	*stats = &task.RetryStats{}
+	rc.Stats = *stats
+	return rc
+}
+
 type nodeControllerStats struct {
-	Enactment interface{}
-	Telemetry interface{}
+	Enactment        interface{}
+	EnactmentRetries *task.RetryStats
+	Telemetry        interface{}
+	TelemetryRetries *task.RetryStats
+	TelemetryQueue   interface{}
+	Health           health.Report
 }
 
 func (nc *nodeController) Stats() interface{} {
 	return nodeControllerStats{
-		Enactment: nc.enactmentStats(),
-		Telemetry: nc.telemetryStats(),
+		Enactment:        nc.enactmentStats(),
+		EnactmentRetries: nc.enactmentRetries,
+		Telemetry:        nc.telemetryStats(),
+		TelemetryRetries: nc.telemetryRetries,
+		TelemetryQueue:   nc.telemetryQueueStats(),
+		Health:           nc.health.Report(),
 	}
 }
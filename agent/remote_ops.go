@@ -0,0 +1,338 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"net"
+	"runtime/debug"
+	"runtime/pprof"
+	"slices"
+	"strings"
+
+	"aalyria.com/spacetime/agent/eventlog"
+	"aalyria.com/spacetime/agent/internal/tarball"
+	agentopspb "aalyria.com/spacetime/api/agentops/v1alpha"
+	"aalyria.com/spacetime/callctx"
+
+	"github.com/rs/zerolog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// diagnosticsEventLimit is the number of the most recent events included in
+// a diagnostics bundle.
+const diagnosticsEventLimit = 1000
+
+// opsActions names the actions of the AgentOps methods in the event log.
+var opsActions = map[string]string{
+	agentopspb.AgentOps_RestartBackend_FullMethodName:     "restart_backend",
+	agentopspb.AgentOps_FlushQueue_FullMethodName:         "flush_queue",
+	agentopspb.AgentOps_ResyncState_FullMethodName:        "resync_state",
+	agentopspb.AgentOps_CaptureDiagnostics_FullMethodName: "capture_diagnostics",
+}
+
+type remoteOpsConfig struct {
+	lis        net.Listener
+	identities []string
+	opts       []grpc.ServerOption
+}
+
+// WithRemoteOps configures the Agent to serve the AgentOps service on `lis`,
+// letting operators restart a node's backend, flush its queue, resync its
+// state or capture diagnostics without shell access to the host.
+//
+// Callers must present a client certificate that the server verifies, so
+// `opts` must include TLS credentials that require one (see
+// [crypto/tls.RequireAndVerifyClientCert]). The caller's identity is the first URI
+// SAN of its certificate, such as a SPIFFE ID, or else the certificate's
+// common name, and it must be one of `allowedIdentities`. Every action is
+// logged along with the caller's identity and reason, including in the
+// event log if one is configured. The Agent closes `lis` when it stops.
+func WithRemoteOps(lis net.Listener, allowedIdentities []string, opts ...grpc.ServerOption) AgentOption {
+	return agentOptFunc(func(a *Agent) {
+		a.remoteOps = &remoteOpsConfig{lis: lis, identities: allowedIdentities, opts: opts}
+	})
+}
+
+// runningNode is a node controller along with the context it runs in.
+type runningNode struct {
+	nc  *nodeController
+	ctx context.Context
+}
+
+type opsServer struct {
+	agentopspb.UnimplementedAgentOpsServer
+
+	a          *Agent
+	agentMap   *expvar.Map
+	nodes      map[string]runningNode
+	identities []string
+}
+
+// serveRemoteOps serves the AgentOps service for `nodes` until `ctx` is
+// done.
+func (a *Agent) serveRemoteOps(ctx context.Context, agentMap *expvar.Map, nodes map[string]runningNode) {
+	s := &opsServer{a: a, agentMap: agentMap, nodes: nodes, identities: a.remoteOps.identities}
+	srv := grpc.NewServer(append(slices.Clip(a.remoteOps.opts), grpc.ChainUnaryInterceptor(s.audit))...)
+	agentopspb.RegisterAgentOpsServer(srv, s)
+
+	go func() {
+		<-ctx.Done()
+		srv.Stop()
+	}()
+
+	zerolog.Ctx(ctx).Info().Str("address", a.remoteOps.lis.Addr().String()).Msg("serving remote ops")
+	if err := srv.Serve(a.remoteOps.lis); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("remote ops server failed")
+	}
+}
+
+// opsRequest is implemented by all the AgentOps requests.
+type opsRequest interface {
+	GetNodeId() string
+	GetReason() string
+}
+
+// audit authorizes the caller and records the action it takes.
+func (s *opsServer) audit(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	actor, err := s.authorize(ctx)
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Str("method", info.FullMethod).Msg("refused remote ops call")
+		return nil, err
+	}
+	r, ok := req.(opsRequest)
+	if !ok {
+		return nil, status.Errorf(codes.Unimplemented, "unknown method %s", info.FullMethod)
+	}
+	if r.GetReason() == "" {
+		return nil, status.Error(codes.InvalidArgument, "a reason is required")
+	}
+
+	action := opsActions[info.FullMethod]
+	call := callctx.FromIncomingContext(ctx)
+	logCtx := zerolog.Ctx(ctx).With().
+		Str("action", action).
+		Str("actor", actor).
+		Str("nodeID", r.GetNodeId()).
+		Str("reason", r.GetReason())
+	if !call.IsZero() {
+		labels := zerolog.Dict()
+		for k, v := range call.Labels {
+			labels.Str(k, v)
+		}
+		logCtx = logCtx.Dict("call", zerolog.Dict().
+			Str("operator", call.Operator).
+			Str("ticketID", call.TicketID).
+			Str("jobID", call.JobID).
+			Dict("labels", labels))
+	}
+	log := logCtx.Logger()
+	log.Info().Msg("operator action requested")
+
+	resp, err := handler(ctx, req)
+
+	e := eventlog.Event{
+		Kind:     eventlog.OperatorAction,
+		Node:     r.GetNodeId(),
+		Request:  action,
+		Actor:    actor,
+		Operator: call.Operator,
+		TicketID: call.TicketID,
+		JobID:    call.JobID,
+		Labels:   call.Labels,
+		Message:  r.GetReason(),
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("operator action failed")
+		e.Code = status.Code(err).String()
+	} else {
+		log.Info().Msg("operator action completed")
+	}
+	if s.a.events != nil {
+		e.Time = s.a.clock.Now().UTC()
+		if err := s.a.events.Append(e); err != nil {
+			log.Error().Err(err).Msg("failed to write to the event log")
+		}
+	}
+	return resp, err
+}
+
+// authorize returns the identity of the caller, provided it's allowed to
+// take actions.
+func (s *opsServer) authorize(ctx context.Context) (string, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", status.Error(codes.Unauthenticated, "no peer information")
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return "", status.Error(codes.Unauthenticated, "a verified client certificate is required")
+	}
+	id := certIdentity(tlsInfo.State.VerifiedChains[0][0])
+	if !slices.Contains(s.identities, id) {
+		return "", status.Errorf(codes.PermissionDenied, "%q isn't allowed to act on this agent", id)
+	}
+	return id, nil
+}
+
+// certIdentity returns the first URI SAN of `cert`, or else its subject's
+// common name.
+func certIdentity(cert *x509.Certificate) string {
+	if len(cert.URIs) > 0 {
+		return cert.URIs[0].String()
+	}
+	return cert.Subject.CommonName
+}
+
+// enactment returns the enactment service of the node `id`, and the context
+// the node runs in.
+func (s *opsServer) enactment(id string) (*enactmentService, context.Context, error) {
+	n, ok := s.nodes[id]
+	switch {
+	case id == "":
+		return nil, nil, status.Error(codes.InvalidArgument, "a node_id is required")
+	case !ok:
+		return nil, nil, status.Errorf(codes.NotFound, "unknown node %q", id)
+	case n.nc.enactment == nil:
+		return nil, nil, status.Errorf(codes.FailedPrecondition, "node %q doesn't have enactments enabled", id)
+	}
+	return n.nc.enactment, n.ctx, nil
+}
+
+// do carries out `action` on the node `id`, giving up if the node stops.
+func (s *opsServer) do(ctx context.Context, id string, action opsAction) opsResult {
+	es, nodeCtx, err := s.enactment(id)
+	if err != nil {
+		return opsResult{err: err}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer context.AfterFunc(nodeCtx, cancel)()
+
+	r := es.do(ctx, action)
+	if r.err != nil && nodeCtx.Err() != nil {
+		r.err = status.Errorf(codes.Unavailable, "node %q has stopped", id)
+	}
+	return r
+}
+
+func (s *opsServer) RestartBackend(ctx context.Context, req *agentopspb.RestartBackendRequest) (*agentopspb.RestartBackendResponse, error) {
+	if r := s.do(ctx, req.GetNodeId(), opsRestartBackend); r.err != nil {
+		return nil, r.err
+	}
+	return &agentopspb.RestartBackendResponse{}, nil
+}
+
+func (s *opsServer) FlushQueue(ctx context.Context, req *agentopspb.FlushQueueRequest) (*agentopspb.FlushQueueResponse, error) {
+	r := s.do(ctx, req.GetNodeId(), opsFlushQueue)
+	if r.err != nil {
+		return nil, r.err
+	}
+	return &agentopspb.FlushQueueResponse{FlushedEntryIds: r.flushed}, nil
+}
+
+func (s *opsServer) ResyncState(ctx context.Context, req *agentopspb.ResyncStateRequest) (*agentopspb.ResyncStateResponse, error) {
+	if r := s.do(ctx, req.GetNodeId(), opsResync); r.err != nil {
+		return nil, r.err
+	}
+	return &agentopspb.ResyncStateResponse{}, nil
+}
+
+func (s *opsServer) CaptureDiagnostics(ctx context.Context, req *agentopspb.CaptureDiagnosticsRequest) (*agentopspb.CaptureDiagnosticsResponse, error) {
+	if id := req.GetNodeId(); id != "" {
+		if _, ok := s.nodes[id]; !ok {
+			return nil, status.Errorf(codes.NotFound, "unknown node %q", id)
+		}
+	}
+	bundle, err := s.diagnosticsBundle(req.GetNodeId())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "capturing diagnostics: %v", err)
+	}
+	return &agentopspb.CaptureDiagnosticsResponse{Bundle: bundle}, nil
+}
+
+// diagnosticsBundle returns a gzip-compressed tar archive of the agent's
+// stats, goroutine stacks, build information and the most recent events of
+// the node `nodeID`, or of every node if it's empty.
+func (s *opsServer) diagnosticsBundle(nodeID string) ([]byte, error) {
+	files := []struct {
+		name string
+		data func() ([]byte, error)
+	}{
+		{"stats.json", func() ([]byte, error) { return []byte(s.agentMap.String()), nil }},
+		{"goroutines.txt", func() ([]byte, error) {
+			buf := &bytes.Buffer{}
+			err := pprof.Lookup("goroutine").WriteTo(buf, 2)
+			return buf.Bytes(), err
+		}},
+		{"build_info.txt", func() ([]byte, error) {
+			bi, ok := debug.ReadBuildInfo()
+			if !ok {
+				return []byte("no build information available\n"), nil
+			}
+			return []byte(bi.String()), nil
+		}},
+		{"events.jsonl", func() ([]byte, error) { return s.recentEvents(nodeID) }},
+	}
+
+	buf := &bytes.Buffer{}
+	tw := tarball.NewWriter(buf, s.a.clock.Now())
+	for _, f := range files {
+		data, err := f.data()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.name, err)
+		}
+		if err := tw.Add(f.name, data); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// recentEvents returns the most recent events of the node `nodeID`, or of
+// every node if it's empty, one JSON object per line.
+func (s *opsServer) recentEvents(nodeID string) ([]byte, error) {
+	if s.a.events == nil {
+		return nil, nil
+	}
+	events, err := eventlog.Last(s.a.events.Path(), diagnosticsEventLimit, func(e eventlog.Event) bool {
+		return nodeID == "" || e.Node == nodeID
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sb := &strings.Builder{}
+	enc := json.NewEncoder(sb)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return nil, err
+		}
+	}
+	return []byte(sb.String()), nil
+}
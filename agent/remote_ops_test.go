@@ -0,0 +1,281 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"aalyria.com/spacetime/agent/eventlog"
+	agentopspb "aalyria.com/spacetime/api/agentops/v1alpha"
+	schedpb "aalyria.com/spacetime/api/scheduling/v1alpha"
+	"aalyria.com/spacetime/callctx"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jonboulle/clockwork"
+	"github.com/rs/zerolog"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const operatorID = "spiffe://example.com/operator"
+
+// testCA issues certificates for the tests of the remote ops channel.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	check(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	check(t, err)
+	cert, err := x509.ParseCertificate(der)
+	check(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue returns a certificate for `tmpl`, signed by the CA.
+func (ca *testCA) issue(t *testing.T, tmpl *x509.Certificate) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	check(t, err)
+	tmpl.SerialNumber = big.NewInt(time.Now().UnixNano())
+	tmpl.NotBefore, tmpl.NotAfter = time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	check(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// client returns an AgentOps client that connects to `addr` with a
+// certificate identifying it as `id`.
+func (ca *testCA) client(t *testing.T, addr, id string) agentopspb.AgentOpsClient {
+	t.Helper()
+
+	u, err := url.Parse(id)
+	check(t, err)
+	cert := ca.issue(t, &x509.Certificate{URIs: []*url.URL{u}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      ca.pool,
+		ServerName:   "localhost",
+	})))
+	check(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return agentopspb.NewAgentOpsClient(conn)
+}
+
+type restartableDriver struct {
+	failingDriver
+	inits atomic.Int32
+}
+
+func (d *restartableDriver) Init(context.Context) error {
+	d.inits.Add(1)
+	return nil
+}
+
+func TestRemoteOps(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(baseContext(t))
+	g, ctx := errgroup.WithContext(ctx)
+	defer func() {
+		cancel()
+		checkErrIsDueToCanceledContext(t, g.Wait())
+	}()
+
+	logPath := filepath.Join(t.TempDir(), "events.jsonl")
+	events, err := eventlog.NewWriter(eventlog.Config{Path: logPath})
+	check(t, err)
+	defer events.Close()
+
+	ca := newTestCA(t)
+	serverCert := ca.issue(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "agent"},
+		DNSNames:    []string{"localhost"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	lis, err := net.Listen("tcp", "localhost:0")
+	check(t, err)
+	opsCreds := credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    ca.pool,
+	})
+
+	clock := clockwork.NewFakeClockAt(startTime)
+	srv := newServer(
+		zerolog.Ctx(ctx).With().Str("role", "test server").Logger().WithContext(ctx), []testNode{{id: "node-a"}})
+	srvAddr := srv.start(ctx, t, g)
+
+	driver := &restartableDriver{}
+	agent := newAgent(t, WithClock(clock), WithEventLog(events),
+		WithRemoteOps(lis, []string{operatorID}, grpc.Creds(opsCreds)),
+		WithNode("node-a", WithEnactmentDriver(srvAddr, driver, grpc.WithTransportCredentials(insecure.NewCredentials()))))
+	g.Go(func() error { return agent.Run(ctx) })
+	f := &testFixture{t: t, srv: srv, clock: clock}
+
+	token := f.expectSchedulingReset(ctx, "node-a")
+	f.expectSchedulingHello(ctx, "node-a")
+
+	ops := ca.client(t, lis.Addr().String(), operatorID)
+
+	t.Run("refuses unauthorized callers", func(t *testing.T) {
+		intruder := ca.client(t, lis.Addr().String(), "spiffe://example.com/intruder")
+		_, err := intruder.RestartBackend(ctx, &agentopspb.RestartBackendRequest{NodeId: "node-a", Reason: "curiosity"})
+		if got, want := status.Code(err), codes.PermissionDenied; got != want {
+			t.Errorf("RestartBackend() by an unlisted identity: got code %v, want %v (err: %v)", got, want, err)
+		}
+	})
+
+	t.Run("requires a reason", func(t *testing.T) {
+		_, err := ops.RestartBackend(ctx, &agentopspb.RestartBackendRequest{NodeId: "node-a"})
+		if got, want := status.Code(err), codes.InvalidArgument; got != want {
+			t.Errorf("RestartBackend() without a reason: got code %v, want %v (err: %v)", got, want, err)
+		}
+	})
+
+	if _, err := ops.RestartBackend(ctx, &agentopspb.RestartBackendRequest{NodeId: "node-a", Reason: "modem wedged"}); err != nil {
+		t.Fatalf("RestartBackend(): %v", err)
+	}
+	if got, want := driver.inits.Load(), int32(2); got != want {
+		t.Errorf("driver was initialized %d times, want %d", got, want)
+	}
+
+	f.sendSchedulingRequest(ctx, "node-a", &schedpb.ReceiveRequestsMessageFromController{
+		RequestId: 1,
+		Request: &schedpb.ReceiveRequestsMessageFromController_CreateEntry{CreateEntry: &schedpb.CreateEntryRequest{
+			ScheduleManipulationToken: token,
+			Seqno:                     1,
+			Id:                        "set-routes",
+			Time:                      timestamppb.New(startTime.Add(time.Minute)),
+			ConfigurationChange: &schedpb.CreateEntryRequest_SetRoute{
+				SetRoute: &schedpb.SetRoute{To: "2001:db8:1::/48", Dev: "eth0"},
+			},
+		}},
+	})
+	if _, err := srv.RecvFromNode(ctx, "node-a"); err != nil {
+		t.Fatalf("RecvFromNode: %v", err)
+	}
+
+	flushResp, err := ops.FlushQueue(ctx, &agentopspb.FlushQueueRequest{NodeId: "node-a", Reason: "stale routes"})
+	if err != nil {
+		t.Fatalf("FlushQueue(): %v", err)
+	}
+	if diff := cmp.Diff([]string{"set-routes"}, flushResp.GetFlushedEntryIds()); diff != "" {
+		t.Errorf("unexpected flushed entries (-want +got):\n%s", diff)
+	}
+
+	call := callctx.Info{Operator: "alice", TicketID: "OPS-1234", Labels: map[string]string{"shift": "night"}}
+	callCtx := metadata.NewOutgoingContext(ctx, call.Metadata())
+	if _, err := ops.ResyncState(callCtx, &agentopspb.ResyncStateRequest{NodeId: "node-a", Reason: "controller failover"}); err != nil {
+		t.Fatalf("ResyncState(): %v", err)
+	}
+	// Wait for the stream to back off before reconnecting.
+	clock.BlockUntil(1)
+	f.advanceClock(ctx, 10*time.Second)
+	f.expectSchedulingReset(ctx, "node-a")
+	f.expectSchedulingHello(ctx, "node-a")
+
+	diagResp, err := ops.CaptureDiagnostics(ctx, &agentopspb.CaptureDiagnosticsRequest{NodeId: "node-a", Reason: "bug report"})
+	if err != nil {
+		t.Fatalf("CaptureDiagnostics(): %v", err)
+	}
+	files := map[string]string{}
+	gr, err := gzip.NewReader(bytes.NewReader(diagResp.GetBundle()))
+	check(t, err)
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		check(t, err)
+		data, err := io.ReadAll(tr)
+		check(t, err)
+		files[hdr.Name] = string(data)
+	}
+	for _, name := range []string{"stats.json", "goroutines.txt", "build_info.txt", "events.jsonl"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("diagnostics bundle is missing %s", name)
+		}
+	}
+	if !strings.Contains(files["events.jsonl"], `"message":"stale routes"`) {
+		t.Errorf("diagnostics bundle's events don't include the flush:\n%s", files["events.jsonl"])
+	}
+
+	got := []eventlog.Event{}
+	check(t, eventlog.Read(logPath, func(e eventlog.Event, err error) error {
+		if err != nil {
+			return err
+		}
+		if e.Kind == eventlog.OperatorAction {
+			e.Time = time.Time{}
+			got = append(got, e)
+		}
+		return nil
+	}))
+	want := []eventlog.Event{
+		{Node: "node-a", Kind: eventlog.OperatorAction, Request: "restart_backend", Actor: operatorID, Message: "modem wedged"},
+		{Node: "node-a", Kind: eventlog.OperatorAction, Request: "flush_queue", Actor: operatorID, Message: "stale routes"},
+		{Node: "node-a", Kind: eventlog.OperatorAction, Request: "resync_state", Actor: operatorID, Operator: "alice", TicketID: "OPS-1234", Labels: map[string]string{"shift": "night"}, Message: "controller failover"},
+		{Node: "node-a", Kind: eventlog.OperatorAction, Request: "capture_diagnostics", Actor: operatorID, Message: "bug report"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected operator events (-want +got):\n%s", diff)
+	}
+}
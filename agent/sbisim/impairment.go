@@ -0,0 +1,210 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sbisim
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ErrMessageLost is the cause of the streams broken by an [Impairment]'s
+// loss.
+var ErrMessageLost = errors.New("message lost by the simulated link")
+
+// Impairment describes the conditions of the link between a node and the
+// simulated controller, such as those of a congested or distant space link.
+// It applies to the messages of the node's scheduling stream in both
+// directions, and to its schedule resets. Telemetry reports don't identify
+// their node, so they aren't impaired.
+type Impairment struct {
+	// Delay is added to the delivery of every message.
+	Delay time.Duration `yaml:"delay"`
+	// Jitter adds a further random delay of up to Jitter to each message.
+	// Messages are still delivered in order, unless Reorder lets them
+	// overtake each other.
+	Jitter time.Duration `yaml:"jitter"`
+	// Loss is the probability, between 0 and 1, that a message is lost.
+	// gRPC streams are reliable, so rather than disappearing, a lost
+	// message breaks the stream, like a connection that times out after
+	// losing too many packets. Requests to the node that were lost or still
+	// in flight are delivered again once it reconnects, while lost
+	// responses are gone.
+	Loss float64 `yaml:"loss"`
+	// Reorder is the probability, between 0 and 1, that a message may
+	// overtake the messages sent before it, if its jitter is smaller.
+	Reorder float64 `yaml:"reorder"`
+	// Seed seeds the random choices of the impairment, so they're the same
+	// each time a node is impaired the same way.
+	Seed uint64 `yaml:"seed"`
+}
+
+func (imp Impairment) validate() error {
+	switch {
+	case imp.Delay < 0 || imp.Jitter < 0:
+		return errors.New("delay and jitter can't be negative")
+	case imp.Loss < 0 || imp.Loss > 1:
+		return fmt.Errorf("loss must be between 0 and 1, got %v", imp.Loss)
+	case imp.Reorder < 0 || imp.Reorder > 1:
+		return fmt.Errorf("reorder must be between 0 and 1, got %v", imp.Reorder)
+	}
+	return nil
+}
+
+// link decides when the messages in one direction of an impaired link are
+// delivered. Its methods are safe for concurrent use.
+type link struct {
+	mu   sync.Mutex
+	imp  Impairment
+	rng  *rand.Rand
+	last time.Time
+}
+
+func newLink(name string, imp Impairment) *link {
+	// Each direction of each node's link gets its own sequence, so that
+	// impairing one doesn't change what happens to another.
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return &link{imp: imp, rng: rand.New(rand.NewPCG(imp.Seed, h.Sum64()))}
+}
+
+// schedule returns when a message sent at `now` is delivered, or whether it
+// was lost. A nil link delivers every message right away.
+func (l *link) schedule(now time.Time) (at time.Time, lost bool) {
+	if l == nil {
+		return now, false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Draw the same numbers for every message so that changing one
+	// parameter doesn't change the choices made for the others.
+	loss, jitter, reorder := l.rng.Float64(), l.rng.Float64(), l.rng.Float64()
+	if loss < l.imp.Loss {
+		return time.Time{}, true
+	}
+	at = now.Add(l.imp.Delay + time.Duration(jitter*float64(l.imp.Jitter)))
+	if reorder >= l.imp.Reorder && at.Before(l.last) {
+		at = l.last
+	}
+	l.last = later(l.last, at)
+	return at, false
+}
+
+func later(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// delayLine holds values until their delivery time, and hands them out in
+// order of delivery time, then of when they were put.
+type delayLine[V any] struct {
+	mu      sync.Mutex
+	items   []delayed[V]
+	nextSeq uint64
+	// changed is closed and replaced whenever a value is put.
+	changed chan struct{}
+}
+
+type delayed[V any] struct {
+	v   V
+	at  time.Time
+	seq uint64
+}
+
+func newDelayLine[V any]() *delayLine[V] {
+	return &delayLine[V]{changed: make(chan struct{})}
+}
+
+func (d *delayLine[V]) put(v V, at time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	i, _ := slices.BinarySearchFunc(d.items, at, func(e delayed[V], at time.Time) int {
+		if e.at.After(at) {
+			return 1
+		}
+		return -1
+	})
+	d.items = slices.Insert(d.items, i, delayed[V]{v: v, at: at, seq: d.nextSeq})
+	d.nextSeq++
+	close(d.changed)
+	d.changed = make(chan struct{})
+}
+
+// take removes and returns the next value once it's due, waiting for one
+// to be put if there are none.
+func (d *delayLine[V]) take(ctx context.Context) (V, error) {
+	for {
+		d.mu.Lock()
+		changed := d.changed
+		untilDue := time.Duration(-1)
+		if len(d.items) > 0 {
+			next := d.items[0]
+			if untilDue = time.Until(next.at); untilDue <= 0 {
+				d.items = d.items[1:]
+				d.mu.Unlock()
+				return next.v, nil
+			}
+		}
+		d.mu.Unlock()
+
+		if err := d.wait(ctx, changed, untilDue); err != nil {
+			var zero V
+			return zero, err
+		}
+	}
+}
+
+// wait waits for `changed` to be closed or, unless it's negative, for
+// `timeout` to elapse.
+func (d *delayLine[V]) wait(ctx context.Context, changed <-chan struct{}, timeout time.Duration) error {
+	var expired <-chan time.Time
+	if timeout >= 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		expired = t.C
+	}
+	select {
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	case <-changed:
+	case <-expired:
+	}
+	return nil
+}
+
+// drain removes and returns the values that haven't been taken, in the
+// order they were put.
+func (d *delayLine[V]) drain() []V {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	slices.SortFunc(d.items, func(a, b delayed[V]) int { return cmp.Compare(a.seq, b.seq) })
+	vs := []V{}
+	for _, item := range d.items {
+		vs = append(vs, item.v)
+	}
+	d.items = nil
+	return vs
+}
@@ -0,0 +1,147 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sbisim
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// scheduleMessages returns the delivery times of `n` messages sent over `l`
+// a millisecond apart, relative to `start`, with -1 for lost messages.
+func scheduleMessages(l *link, start time.Time, n int) []time.Duration {
+	delays := []time.Duration{}
+	for i := range n {
+		at, lost := l.schedule(start.Add(time.Duration(i) * time.Millisecond))
+		if lost {
+			delays = append(delays, -1)
+			continue
+		}
+		delays = append(delays, at.Sub(start))
+	}
+	return delays
+}
+
+func TestLinkSchedule(t *testing.T) {
+	t.Parallel()
+
+	start := time.Now()
+	imp := Impairment{Delay: 100 * time.Millisecond, Jitter: 50 * time.Millisecond, Loss: 0.2, Seed: 3}
+	got := scheduleMessages(newLink("node-a", imp), start, 200)
+	if diff := cmp.Diff(got, scheduleMessages(newLink("node-a", imp), start, 200)); diff != "" {
+		t.Errorf("links with the same seed scheduled messages differently (-first +second):\n%s", diff)
+	}
+	if diff := cmp.Diff(got, scheduleMessages(newLink("node-b", imp), start, 200)); diff == "" {
+		t.Error("links of different nodes scheduled messages the same way")
+	}
+
+	lost, last := 0, time.Duration(0)
+	for i, d := range got {
+		if d < 0 {
+			lost++
+			continue
+		}
+		sent := time.Duration(i) * time.Millisecond
+		if d < sent+imp.Delay || d > sent+imp.Delay+imp.Jitter {
+			t.Errorf("message %d sent at %v is delivered at %v, want between %v and %v", i, sent, d, sent+imp.Delay, sent+imp.Delay+imp.Jitter)
+		}
+		if d < last {
+			t.Errorf("message %d is delivered at %v, before the message sent before it at %v", i, d, last)
+		}
+		last = d
+	}
+	if lost < 20 || lost > 60 {
+		t.Errorf("%d of 200 messages were lost, want about 40", lost)
+	}
+}
+
+func TestLinkSchedule_reorder(t *testing.T) {
+	t.Parallel()
+
+	l := newLink("node-a", Impairment{Jitter: 50 * time.Millisecond, Reorder: 1})
+	reordered := false
+	got := scheduleMessages(l, time.Now(), 100)
+	for i := 1; i < len(got); i++ {
+		if got[i] < got[i-1] {
+			reordered = true
+		}
+	}
+	if !reordered {
+		t.Errorf("no messages were reordered: %v", got)
+	}
+}
+
+func TestLinkSchedule_nil(t *testing.T) {
+	t.Parallel()
+
+	var l *link
+	now := time.Now()
+	if at, lost := l.schedule(now); !at.Equal(now) || lost {
+		t.Errorf("nil link scheduled a message sent at %v for %v (lost: %v), want it delivered right away", now, at, lost)
+	}
+}
+
+func TestDelayLine(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	d := newDelayLine[string]()
+	now := time.Now()
+	d.put("late", now.Add(40*time.Millisecond))
+	d.put("early", now.Add(20*time.Millisecond))
+	d.put("tied", now.Add(20*time.Millisecond))
+
+	got := []string{}
+	for range 3 {
+		v, err := d.take(ctx)
+		if err != nil {
+			t.Fatalf("take: %v", err)
+		}
+		got = append(got, v)
+	}
+	if time.Since(now) < 40*time.Millisecond {
+		t.Errorf("values were taken after %v, before they were due", time.Since(now))
+	}
+	if diff := cmp.Diff([]string{"early", "tied", "late"}, got); diff != "" {
+		t.Errorf("values were taken in the wrong order (-want +got):\n%s", diff)
+	}
+
+	// A value put while waiting is taken once it's due.
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		d.put("next", time.Now())
+	}()
+	if v, err := d.take(ctx); err != nil || v != "next" {
+		t.Errorf("take() = %q, %v; want \"next\"", v, err)
+	}
+
+	// Values that aren't due yet are drained in the order they were put.
+	d.put("b", now.Add(time.Hour))
+	d.put("a", now.Add(time.Minute))
+	if diff := cmp.Diff([]string{"b", "a"}, d.drain()); diff != "" {
+		t.Errorf("drain() returned the wrong values (-want +got):\n%s", diff)
+	}
+
+	cancelled, cancelNow := context.WithCancel(ctx)
+	cancelNow()
+	if _, err := d.take(cancelled); err == nil {
+		t.Error("take() on an empty delay line with a canceled context succeeded")
+	}
+}
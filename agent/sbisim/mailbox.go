@@ -0,0 +1,86 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sbisim
+
+import (
+	"context"
+	"sync"
+)
+
+// mailbox holds values sent to any number of keys until they're taken, in
+// the order they were put.
+type mailbox[K comparable, V any] struct {
+	mu    sync.Mutex
+	items map[K][]V
+	// changed is closed and replaced whenever a value is put.
+	changed chan struct{}
+}
+
+func newMailbox[K comparable, V any]() *mailbox[K, V] {
+	return &mailbox[K, V]{items: map[K][]V{}, changed: make(chan struct{})}
+}
+
+func (m *mailbox[K, V]) put(k K, v V) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.items[k] = append(m.items[k], v)
+	m.notifyLocked()
+}
+
+// putFront returns a value to the head of the key's queue.
+func (m *mailbox[K, V]) putFront(k K, v V) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.items[k] = append([]V{v}, m.items[k]...)
+	m.notifyLocked()
+}
+
+func (m *mailbox[K, V]) notifyLocked() {
+	close(m.changed)
+	m.changed = make(chan struct{})
+}
+
+// take removes and returns the oldest value put to `k`, waiting for one if
+// there are none.
+func (m *mailbox[K, V]) take(ctx context.Context, k K) (V, error) {
+	for {
+		m.mu.Lock()
+		if vs := m.items[k]; len(vs) > 0 {
+			m.items[k] = vs[1:]
+			m.mu.Unlock()
+			return vs[0], nil
+		}
+		changed := m.changed
+		m.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			var zero V
+			return zero, context.Cause(ctx)
+		case <-changed:
+		}
+	}
+}
+
+// queue is a mailbox with a single key.
+type queue[V any] struct{ m *mailbox[struct{}, V] }
+
+func newQueue[V any]() *queue[V] { return &queue[V]{m: newMailbox[struct{}, V]()} }
+
+func (q *queue[V]) put(v V)                             { q.m.put(struct{}{}, v) }
+func (q *queue[V]) putFront(v V)                        { q.m.putFront(struct{}{}, v) }
+func (q *queue[V]) take(ctx context.Context) (V, error) { return q.m.take(ctx, struct{}{}) }
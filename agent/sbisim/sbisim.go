@@ -0,0 +1,600 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sbisim provides a simulated southbound interface (SBI) server that
+// agents can connect to in place of a live Spacetime instance. Tests script
+// the changes the simulated controller schedules on each node, assert on the
+// acknowledgements and telemetry the agent sends back, and inject faults such
+// as broken streams, slow acknowledgements and lossy, high-latency links.
+//
+// A Server can be used in-process, or standalone through the sbisim binary.
+package sbisim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	schedpb "aalyria.com/spacetime/api/scheduling/v1alpha"
+	telemetrypb "aalyria.com/spacetime/telemetry/v1alpha"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var (
+	// ErrStreamReset is the cause of the streams broken by [Node.ResetStream].
+	ErrStreamReset = errors.New("stream reset by the SBI simulator")
+	// ErrLinkDown is the cause of the streams broken by [Node.SetLinkDown],
+	// and the error agents get while their node's link is down.
+	ErrLinkDown = errors.New("link to the node is down")
+)
+
+// Server simulates the SBI of a Spacetime instance: the Scheduling service,
+// through which agents receive their schedules, and the Telemetry service, to
+// which they report metrics. Nodes are tracked by the IDs agents identify
+// themselves with, and don't need to be declared ahead of time.
+type Server struct {
+	schedpb.UnimplementedSchedulingServer
+	telemetrypb.UnimplementedTelemetryServer
+
+	metrics *queue[*telemetrypb.ExportMetricsRequest]
+
+	mu       sync.Mutex
+	nodes    map[string]*Node
+	ackDelay time.Duration
+	observer func(nodeID string, msg proto.Message)
+}
+
+// New returns a Server with no nodes.
+func New() *Server {
+	return &Server{
+		metrics: newQueue[*telemetrypb.ExportMetricsRequest](),
+		nodes:   map[string]*Node{},
+	}
+}
+
+// Register registers the Server's services with `r`, for use alongside
+// other services or with custom server options.
+func (s *Server) Register(r grpc.ServiceRegistrar) {
+	schedpb.RegisterSchedulingServer(r, s)
+	telemetrypb.RegisterTelemetryServer(r, s)
+}
+
+// Serve accepts agent connections on `lis` until `ctx` is done. Connections
+// are plaintext unless `opts` configure transport credentials.
+func (s *Server) Serve(ctx context.Context, lis net.Listener, opts ...grpc.ServerOption) error {
+	srv := grpc.NewServer(opts...)
+	s.Register(srv)
+
+	stop := context.AfterFunc(ctx, srv.Stop)
+	defer stop()
+
+	if err := srv.Serve(lis); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+		return err
+	}
+	return nil
+}
+
+// Node returns the simulated state of the node with the provided ID,
+// creating it if the node hasn't connected yet.
+func (s *Server) Node(id string) *Node {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, ok := s.nodes[id]
+	if !ok {
+		n = newNode(id)
+		s.nodes[id] = n
+	}
+	return n
+}
+
+// Reset implements the Scheduling service.
+func (s *Server) Reset(ctx context.Context, req *schedpb.ResetRequest) (*emptypb.Empty, error) {
+	s.observe(req.GetAgentId(), req)
+	n := s.Node(req.GetAgentId())
+	if n.LinkDown() {
+		return nil, status.Error(codes.Unavailable, ErrLinkDown.Error())
+	}
+	fromNode, toNode := n.links()
+	if err := traverse(ctx, fromNode); err != nil {
+		return nil, err
+	}
+	if err := s.delayAck(ctx); err != nil {
+		return nil, err
+	}
+
+	n.mu.Lock()
+	n.token = req.GetScheduleManipulationToken()
+	n.nextSeqno = 1
+	n.mu.Unlock()
+
+	n.resets.put(req.GetScheduleManipulationToken())
+	if err := traverse(ctx, toNode); err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// ReceiveRequests implements the Scheduling service.
+func (s *Server) ReceiveRequests(stream schedpb.Scheduling_ReceiveRequestsServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	if first.GetHello() == nil {
+		return status.Error(codes.InvalidArgument, "the first message of the stream must be a Hello")
+	}
+	n := s.Node(first.GetHello().GetAgentId())
+	s.observe(n.id, first)
+	if n.LinkDown() {
+		return status.Error(codes.Unavailable, ErrLinkDown.Error())
+	}
+
+	ctx, cancel := context.WithCancelCause(stream.Context())
+	defer cancel(nil)
+	defer n.detach(n.attach(cancel))
+	n.hellos.put(first.GetHello())
+
+	// Messages go through delay lines, where impaired links hold them until
+	// they're due.
+	inbound := newDelayLine[*schedpb.ReceiveRequestsMessageToController]()
+	outbound := newDelayLine[*schedpb.ReceiveRequestsMessageFromController]()
+	deliver := func(msg *schedpb.ReceiveRequestsMessageToController) {
+		s.observe(n.id, msg)
+		if rsp := msg.GetResponse(); rsp != nil {
+			n.responses.put(rsp.GetRequestId(), status.FromProto(rsp.GetStatus()))
+		}
+	}
+
+	errCh := make(chan error, 2)
+	wg := &sync.WaitGroup{}
+	var unsent *schedpb.ReceiveRequestsMessageFromController
+	defer func() {
+		// Streams can't be used once the handler returns.
+		cancel(nil)
+		wg.Wait()
+		// Let the agent see the requests that were in flight again once it
+		// reconnects.
+		undelivered := outbound.drain()
+		if unsent != nil {
+			undelivered = append([]*schedpb.ReceiveRequestsMessageFromController{unsent}, undelivered...)
+		}
+		for i := len(undelivered) - 1; i >= 0; i-- {
+			n.outbox.putFront(undelivered[i])
+		}
+	}()
+	go func() {
+		for {
+			msg, err := stream.Recv()
+			if err != nil {
+				errCh <- err
+				return
+			}
+			fromNode, _ := n.links()
+			if fromNode == nil {
+				deliver(msg)
+				continue
+			}
+			at, lost := fromNode.schedule(time.Now())
+			if lost {
+				cancel(ErrMessageLost)
+				return
+			}
+			inbound.put(msg, at)
+		}
+	}()
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		for {
+			msg, err := inbound.take(ctx)
+			if err != nil {
+				return
+			}
+			deliver(msg)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for {
+			req, err := n.outbox.take(ctx)
+			if err != nil {
+				return
+			}
+			_, toNode := n.links()
+			at, lost := toNode.schedule(time.Now())
+			if lost {
+				n.outbox.putFront(req)
+				cancel(ErrMessageLost)
+				return
+			}
+			outbound.put(req, at)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for {
+			req, err := outbound.take(ctx)
+			if err != nil {
+				errCh <- err
+				return
+			}
+			if err := stream.Send(req); err != nil {
+				unsent = req
+				errCh <- err
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		if cause := context.Cause(ctx); errors.Is(cause, ErrStreamReset) || errors.Is(cause, ErrLinkDown) || errors.Is(cause, ErrMessageLost) {
+			return status.Error(codes.Unavailable, cause.Error())
+		}
+		return context.Cause(ctx)
+	case err := <-errCh:
+		return err
+	}
+}
+
+// ExportMetrics implements the Telemetry service.
+func (s *Server) ExportMetrics(ctx context.Context, req *telemetrypb.ExportMetricsRequest) (*emptypb.Empty, error) {
+	s.observe("", req)
+	if err := s.delayAck(ctx); err != nil {
+		return nil, err
+	}
+	s.metrics.put(req)
+	return &emptypb.Empty{}, nil
+}
+
+// WaitForMetrics waits for the next telemetry report from any node. Reports
+// don't identify the node that sent them, only the interfaces they cover.
+func (s *Server) WaitForMetrics(ctx context.Context) (*telemetrypb.ExportMetricsRequest, error) {
+	return s.metrics.take(ctx)
+}
+
+// SetAckDelay delays the simulated controller's acknowledgement of schedule
+// resets and telemetry reports by `d`, to simulate a slow or congested
+// controller. A delay of 0 restores immediate acknowledgements.
+func (s *Server) SetAckDelay(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ackDelay = d
+}
+
+// SetObserver registers `fn` to be called with each message the Server
+// receives from agents: reset requests, the messages of scheduling streams,
+// and telemetry reports. Telemetry reports don't identify their node, so
+// they're observed with an empty node ID. `fn` is called synchronously, so
+// it should return quickly.
+func (s *Server) SetObserver(fn func(nodeID string, msg proto.Message)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.observer = fn
+}
+
+func (s *Server) observe(nodeID string, msg proto.Message) {
+	s.mu.Lock()
+	fn := s.observer
+	s.mu.Unlock()
+	if fn != nil {
+		fn(nodeID, msg)
+	}
+}
+
+func (s *Server) delayAck(ctx context.Context) error {
+	s.mu.Lock()
+	d := s.ackDelay
+	s.mu.Unlock()
+	if d <= 0 {
+		return nil
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// traverse waits for a message of a unary call to make its way across `l`,
+// failing with an Unavailable error if it's lost.
+func traverse(ctx context.Context, l *link) error {
+	if l == nil {
+		return nil
+	}
+	now := time.Now()
+	at, lost := l.schedule(now)
+	if lost {
+		return status.Error(codes.Unavailable, ErrMessageLost.Error())
+	}
+
+	t := time.NewTimer(at.Sub(now))
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// Node is the simulated controller's view of a single node. Its methods are
+// safe for concurrent use.
+type Node struct {
+	id string
+
+	mu            sync.Mutex
+	token         string
+	nextSeqno     uint64
+	nextRequestID int64
+	cancelStream  context.CancelCauseFunc
+	streamGen     uint64
+	linkDown      bool
+	impairment    Impairment
+	// toNode and fromNode decide the fate of the messages sent over each
+	// direction of the node's link. They're nil while it isn't impaired.
+	toNode, fromNode *link
+
+	resets    *queue[string]
+	hellos    *queue[*schedpb.ReceiveRequestsMessageToController_Hello]
+	outbox    *queue[*schedpb.ReceiveRequestsMessageFromController]
+	responses *mailbox[int64, *status.Status]
+}
+
+func newNode(id string) *Node {
+	return &Node{
+		id:            id,
+		nextSeqno:     1,
+		nextRequestID: 1,
+		resets:        newQueue[string](),
+		hellos:        newQueue[*schedpb.ReceiveRequestsMessageToController_Hello](),
+		outbox:        newQueue[*schedpb.ReceiveRequestsMessageFromController](),
+		responses:     newMailbox[int64, *status.Status](),
+	}
+}
+
+// ID returns the node's ID.
+func (n *Node) ID() string { return n.id }
+
+// Token returns the schedule manipulation token of the node's last reset,
+// or "" if it hasn't reset its schedule yet.
+func (n *Node) Token() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.token
+}
+
+// WaitForReset waits for the node to reset its schedule and returns the new
+// schedule manipulation token. Each reset is returned once, in order.
+func (n *Node) WaitForReset(ctx context.Context) (string, error) {
+	return n.resets.take(ctx)
+}
+
+// WaitForHello waits for the node to open a scheduling stream. Each stream
+// is returned once, in order, so this can be used to wait for the node to
+// reconnect after [Node.ResetStream].
+func (n *Node) WaitForHello(ctx context.Context) (*schedpb.ReceiveRequestsMessageToController_Hello, error) {
+	return n.hellos.take(ctx)
+}
+
+// Send sends `req` to the node and returns its request ID. Requests sent
+// while the node isn't connected are delivered once it connects.
+//
+// The request is assigned the next request ID if it doesn't have one. The
+// schedule manipulation token and seqno of CreateEntry, DeleteEntry and
+// Finalize requests are filled in if unset, which requires waiting for the
+// node to reset its schedule.
+func (n *Node) Send(ctx context.Context, req *schedpb.ReceiveRequestsMessageFromController) (int64, error) {
+	var token *string
+	var seqno *uint64
+	switch r := req.GetRequest().(type) {
+	case *schedpb.ReceiveRequestsMessageFromController_CreateEntry:
+		token, seqno = &r.CreateEntry.ScheduleManipulationToken, &r.CreateEntry.Seqno
+	case *schedpb.ReceiveRequestsMessageFromController_DeleteEntry:
+		token, seqno = &r.DeleteEntry.ScheduleManipulationToken, &r.DeleteEntry.Seqno
+	case *schedpb.ReceiveRequestsMessageFromController_Finalize:
+		token, seqno = &r.Finalize.ScheduleManipulationToken, &r.Finalize.Seqno
+	}
+	if token != nil && (*token == "" || *seqno == 0) {
+		t, s, err := n.sequence(ctx)
+		if err != nil {
+			return 0, err
+		}
+		if *token == "" {
+			*token = t
+		}
+		if *seqno == 0 {
+			*seqno = s
+		}
+	}
+
+	n.mu.Lock()
+	if req.GetRequestId() == 0 {
+		req.RequestId = n.nextRequestID
+	}
+	n.nextRequestID = max(n.nextRequestID, req.GetRequestId()+1)
+	n.mu.Unlock()
+
+	n.outbox.put(req)
+	return req.GetRequestId(), nil
+}
+
+// sequence returns the token and next seqno for a new request, waiting for
+// the node's first reset if needed.
+func (n *Node) sequence(ctx context.Context) (string, uint64, error) {
+	for {
+		n.mu.Lock()
+		token, seqno := n.token, n.nextSeqno
+		if token != "" {
+			n.nextSeqno++
+		}
+		n.mu.Unlock()
+		if token != "" {
+			return token, seqno, nil
+		}
+
+		// The reset is handed back so WaitForReset still sees it.
+		token, err := n.resets.take(ctx)
+		if err != nil {
+			return "", 0, fmt.Errorf("waiting for node %q to reset its schedule: %w", n.id, err)
+		}
+		n.resets.putFront(token)
+	}
+}
+
+// CreateEntry schedules `entry` on the node and returns the ID of the
+// request. See [Node.Send].
+func (n *Node) CreateEntry(ctx context.Context, entry *schedpb.CreateEntryRequest) (int64, error) {
+	return n.Send(ctx, &schedpb.ReceiveRequestsMessageFromController{
+		Request: &schedpb.ReceiveRequestsMessageFromController_CreateEntry{CreateEntry: entry},
+	})
+}
+
+// DeleteEntry deletes the entry with the provided ID from the node's
+// schedule and returns the ID of the request.
+func (n *Node) DeleteEntry(ctx context.Context, id string) (int64, error) {
+	return n.Send(ctx, &schedpb.ReceiveRequestsMessageFromController{
+		Request: &schedpb.ReceiveRequestsMessageFromController_DeleteEntry{DeleteEntry: &schedpb.DeleteEntryRequest{Id: id}},
+	})
+}
+
+// Finalize finalizes the node's schedule up to `upTo` and returns the ID of
+// the request.
+func (n *Node) Finalize(ctx context.Context, upTo time.Time) (int64, error) {
+	return n.Send(ctx, &schedpb.ReceiveRequestsMessageFromController{
+		Request: &schedpb.ReceiveRequestsMessageFromController_Finalize{Finalize: &schedpb.FinalizeRequest{UpTo: timestamppb.New(upTo)}},
+	})
+}
+
+// WaitForResponse waits for the node's next response to the request with
+// the provided ID. Agents may respond more than once to a request, such as
+// when they fail to enact an entry they'd accepted.
+func (n *Node) WaitForResponse(ctx context.Context, requestID int64) (*status.Status, error) {
+	return n.responses.take(ctx, requestID)
+}
+
+// ResetStream breaks the node's scheduling stream, if it's connected, with
+// an Unavailable error, as though the connection to the controller had been
+// lost. It reports whether there was a stream to break.
+func (n *Node) ResetStream() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.cancelStream == nil {
+		return false
+	}
+	n.cancelStream(ErrStreamReset)
+	n.cancelStream = nil
+	return true
+}
+
+// SetLinkDown simulates the loss (`down` is true) or recovery of the link
+// between the node and the controller. While the link is down, the node's
+// scheduling stream is broken and its attempts to reconnect or reset its
+// schedule fail with an Unavailable error. Requests sent in the meantime
+// are delivered once the link recovers and the node reconnects.
+func (n *Node) SetLinkDown(down bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.linkDown = down
+	if down && n.cancelStream != nil {
+		n.cancelStream(ErrLinkDown)
+		n.cancelStream = nil
+	}
+}
+
+// LinkDown reports whether the node's link is down. See [Node.SetLinkDown].
+func (n *Node) LinkDown() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.linkDown
+}
+
+// SetImpairment impairs the link between the node and the controller,
+// delaying, reordering and losing the messages sent over it, or restores it
+// if `imp` is the zero Impairment. It applies to the messages sent from then
+// on.
+func (n *Node) SetImpairment(imp Impairment) error {
+	if err := imp.validate(); err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.impairment = imp
+	if imp == (Impairment{}) {
+		n.toNode, n.fromNode = nil, nil
+		return nil
+	}
+	n.toNode = newLink("controller to "+n.id, imp)
+	n.fromNode = newLink(n.id+" to controller", imp)
+	return nil
+}
+
+// Impairment returns the impairment of the node's link. See
+// [Node.SetImpairment].
+func (n *Node) Impairment() Impairment {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.impairment
+}
+
+func (n *Node) links() (fromNode, toNode *link) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.fromNode, n.toNode
+}
+
+func (n *Node) attach(cancel context.CancelCauseFunc) uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.cancelStream != nil {
+		// The node reconnected before its old stream noticed it was gone.
+		n.cancelStream(errors.New("superseded by a new stream"))
+	}
+	n.cancelStream = cancel
+	n.streamGen++
+	if n.linkDown {
+		// The link went down after the stream was accepted.
+		n.cancelStream(ErrLinkDown)
+		n.cancelStream = nil
+	}
+	return n.streamGen
+}
+
+func (n *Node) detach(gen uint64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.streamGen == gen {
+		n.cancelStream = nil
+	}
+}
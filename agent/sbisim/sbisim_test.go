@@ -0,0 +1,160 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sbisim
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"aalyria.com/spacetime/agent"
+	schedpb "aalyria.com/spacetime/api/scheduling/v1alpha"
+	telemetrypb "aalyria.com/spacetime/telemetry/v1alpha"
+
+	"github.com/jonboulle/clockwork"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// recordingDriver is an enactment and telemetry driver that records the
+// entries it's asked to enact and sends the reports it's given.
+type recordingDriver struct {
+	dispatched chan *schedpb.CreateEntryRequest
+	reports    chan *telemetrypb.ExportMetricsRequest
+}
+
+func (d *recordingDriver) Init(context.Context) error { return nil }
+func (d *recordingDriver) Close() error               { return nil }
+func (d *recordingDriver) Stats() any                 { return nil }
+
+func (d *recordingDriver) Dispatch(ctx context.Context, req *schedpb.CreateEntryRequest) error {
+	select {
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	case d.dispatched <- req:
+		return nil
+	}
+}
+
+func (d *recordingDriver) Run(ctx context.Context, _ string, report func(*telemetrypb.ExportMetricsRequest) error) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case r := <-d.reports:
+			if err := report(r); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func TestServer(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	g, ctx := errgroup.WithContext(ctx)
+	defer func() {
+		cancel()
+		if err := g.Wait(); err != nil && !errors.Is(err, context.Canceled) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := New()
+	g.Go(func() error { return srv.Serve(ctx, lis) })
+
+	clock := clockwork.NewFakeClock()
+	d := &recordingDriver{
+		dispatched: make(chan *schedpb.CreateEntryRequest),
+		reports:    make(chan *telemetrypb.ExportMetricsRequest),
+	}
+	creds := grpc.WithTransportCredentials(insecure.NewCredentials())
+	a, err := agent.NewAgent(agent.WithClock(clock), agent.WithNode("node-a",
+		agent.WithEnactmentDriver(lis.Addr().String(), d, creds),
+		agent.WithTelemetryDriver(lis.Addr().String(), d, creds)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.Go(func() error { return a.Run(ctx) })
+
+	node := srv.Node("node-a")
+	if _, err := node.WaitForHello(ctx); err != nil {
+		t.Fatalf("WaitForHello: %v", err)
+	}
+
+	// Entries are acknowledged and then enacted on schedule.
+	entry := &schedpb.CreateEntryRequest{
+		Id:   "entry-1",
+		Time: timestamppb.New(clock.Now().Add(time.Minute)),
+		ConfigurationChange: &schedpb.CreateEntryRequest_DeleteRoute{
+			DeleteRoute: &schedpb.DeleteRoute{To: "2001:db8::/32"},
+		},
+	}
+	reqID, err := node.CreateEntry(ctx, entry)
+	if err != nil {
+		t.Fatalf("CreateEntry: %v", err)
+	}
+	if st, err := node.WaitForResponse(ctx, reqID); err != nil || st.Code() != codes.OK {
+		t.Fatalf("WaitForResponse(%d) = %v, %v; want OK", reqID, st, err)
+	}
+	if entry.GetScheduleManipulationToken() != node.Token() || entry.GetSeqno() != 1 {
+		t.Errorf("entry was sent with token %q and seqno %d; want %q and 1", entry.GetScheduleManipulationToken(), entry.GetSeqno(), node.Token())
+	}
+	clock.Advance(time.Minute)
+	if got := <-d.dispatched; got.GetId() != entry.GetId() {
+		t.Errorf("dispatched entry %q, want %q", got.GetId(), entry.GetId())
+	}
+
+	// Acknowledgements can be delayed without losing reports.
+	srv.SetAckDelay(50 * time.Millisecond)
+	d.reports <- &telemetrypb.ExportMetricsRequest{}
+	if _, err := srv.WaitForMetrics(ctx); err != nil {
+		t.Fatalf("WaitForMetrics: %v", err)
+	}
+	srv.SetAckDelay(0)
+
+	// The agent reconnects after its stream is reset.
+	if !node.ResetStream() {
+		t.Fatal("ResetStream() found no stream to reset")
+	}
+	helloCh := make(chan error, 1)
+	go func() {
+		_, err := node.WaitForHello(ctx)
+		helloCh <- err
+	}()
+	for reconnected := false; !reconnected; {
+		// Skip past the agent's retry backoff.
+		clock.Advance(time.Second)
+		select {
+		case err := <-helloCh:
+			if err != nil {
+				t.Fatalf("WaitForHello: %v", err)
+			}
+			reconnected = true
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,345 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sbisim
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"slices"
+	"time"
+
+	schedpb "aalyria.com/spacetime/api/scheduling/v1alpha"
+	telemetrypb "aalyria.com/spacetime/telemetry/v1alpha"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"gopkg.in/yaml.v3"
+)
+
+// The actions of scenario events.
+const (
+	// ActionWaitHello waits for the event's node to open a scheduling stream.
+	ActionWaitHello = "wait-hello"
+	// ActionSend sends the event's message, a
+	// ReceiveRequestsMessageFromController, to its node. See [Node.Send].
+	ActionSend = "send"
+	// ActionCreateEntry schedules the event's message, a CreateEntryRequest,
+	// on its node.
+	ActionCreateEntry = "create-entry"
+	// ActionDeleteEntry deletes the entry with the event's entry ID from its
+	// node's schedule.
+	ActionDeleteEntry = "delete-entry"
+	// ActionResetStream breaks the event's node's scheduling stream.
+	ActionResetStream = "reset-stream"
+	// ActionLinkDown takes the event's node's link down. If the event has a
+	// duration, the link comes back up once it has elapsed.
+	ActionLinkDown = "link-down"
+	// ActionLinkUp brings the event's node's link back up.
+	ActionLinkUp = "link-up"
+	// ActionImpair impairs the event's node's link as the event's impairment
+	// describes, or restores it if the event has none. If the event has a
+	// duration, the link is restored once it has elapsed. See
+	// [Node.SetImpairment].
+	ActionImpair = "impair"
+	// ActionAckDelay delays the controller's acknowledgements by the event's
+	// duration. See [Server.SetAckDelay].
+	ActionAckDelay = "ack-delay"
+	// ActionTelemetry reports the event's message, an ExportMetricsRequest,
+	// through the scenario's Telemetry function.
+	ActionTelemetry = "telemetry"
+	// ActionWaitMetrics waits for the next telemetry report from any node.
+	ActionWaitMetrics = "wait-metrics"
+)
+
+// TelemetryFunc makes the node with the provided ID report `report`, such as
+// by handing it to the node's telemetry driver.
+type TelemetryFunc func(ctx context.Context, nodeID string, report *telemetrypb.ExportMetricsRequest) error
+
+// Scenario is a timeline of events, such as link failures, telemetry
+// changes and schedule edits, to play out against a [Server]. Scenarios are
+// usually written in YAML and read with [ParseScenario]:
+//
+//	seed: 42
+//	events:
+//	- action: wait-hello
+//	  node: node-a
+//	- at: 1s
+//	  action: create-entry
+//	  node: node-a
+//	  message:
+//	    id: route-1
+//	    time: 2024-01-01T00:00:00Z
+//	    delete_route: {to: "2001:db8::/32"}
+//	- at: 5s
+//	  jitter: 2s
+//	  action: link-down
+//	  node: node-a
+//	  duration: 10s
+//	- at: 20s
+//	  probability: 0.5
+//	  action: reset-stream
+//	  node: node-a
+//	- at: 30s
+//	  action: impair
+//	  node: node-a
+//	  duration: 1m
+//	  impairment: {delay: 600ms, jitter: 100ms, loss: 0.01}
+//
+// Every random choice, such as how much an event is delayed by its jitter
+// and whether it happens at all, is drawn from a generator seeded with
+// Seed, so a scenario plays out the same way each time it's run with the
+// same seed.
+type Scenario struct {
+	Seed   uint64  `yaml:"seed"`
+	Events []Event `yaml:"events"`
+
+	// Telemetry is used to make nodes report telemetry for telemetry events.
+	// Scenarios with telemetry events can't be run without it.
+	Telemetry TelemetryFunc `yaml:"-"`
+}
+
+// Event is something that happens to the simulated network during a
+// Scenario.
+type Event struct {
+	// At is when the event happens, relative to the start of the scenario.
+	At time.Duration `yaml:"at"`
+	// Jitter, if set, delays the event by a random amount up to Jitter.
+	Jitter time.Duration `yaml:"jitter"`
+	// Probability, if set, is the chance that the event happens at all,
+	// between 0 and 1.
+	Probability *float64 `yaml:"probability"`
+	// Timeout, if set, limits how long the event can take, such as when
+	// waiting for a node to connect.
+	Timeout time.Duration `yaml:"timeout"`
+
+	// Action is what happens; one of the Action constants.
+	Action string `yaml:"action"`
+	// Node is the ID of the node the event happens to.
+	Node string `yaml:"node"`
+	// Duration is the duration of link-down, impair and ack-delay events.
+	Duration time.Duration `yaml:"duration"`
+	// Impairment is the impairment of impair events.
+	Impairment *Impairment `yaml:"impairment"`
+	// EntryID is the ID of the entry of delete-entry events.
+	EntryID string `yaml:"entry_id"`
+	// Message is the message of send, create-entry and telemetry events, in
+	// its JSON form.
+	Message map[string]any `yaml:"message"`
+
+	msg proto.Message
+}
+
+// Step is an event of a scenario and the time it happens, relative to the
+// start of the scenario.
+type Step struct {
+	At    time.Duration
+	Event *Event
+}
+
+// ParseScenario parses a scenario in its YAML form.
+func ParseScenario(data []byte) (*Scenario, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+
+	sc := &Scenario{}
+	if err := dec.Decode(sc); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("parsing scenario: %w", err)
+	}
+	if err := sc.prepare(); err != nil {
+		return nil, err
+	}
+	return sc, nil
+}
+
+// prepare checks the scenario's events and parses their messages.
+func (sc *Scenario) prepare() error {
+	for i := range sc.Events {
+		if err := sc.Events[i].prepare(); err != nil {
+			return fmt.Errorf("event %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+func (e *Event) prepare() error {
+	switch {
+	case e.At < 0 || e.Jitter < 0 || e.Timeout < 0 || e.Duration < 0:
+		return errors.New("times and durations can't be negative")
+	case e.Probability != nil && (*e.Probability < 0 || *e.Probability > 1):
+		return fmt.Errorf("probability must be between 0 and 1, got %v", *e.Probability)
+	}
+
+	switch e.Action {
+	case ActionAckDelay, ActionWaitMetrics:
+	case ActionWaitHello, ActionResetStream, ActionLinkDown, ActionLinkUp, ActionImpair, ActionSend, ActionCreateEntry, ActionTelemetry, ActionDeleteEntry:
+		if e.Node == "" {
+			return fmt.Errorf("%s events need a node", e.Action)
+		}
+	case "":
+		return errors.New("missing action")
+	default:
+		return fmt.Errorf("unknown action %q", e.Action)
+	}
+
+	switch e.Action {
+	case ActionSend:
+		e.msg = &schedpb.ReceiveRequestsMessageFromController{}
+	case ActionCreateEntry:
+		e.msg = &schedpb.CreateEntryRequest{}
+	case ActionTelemetry:
+		e.msg = &telemetrypb.ExportMetricsRequest{}
+	case ActionDeleteEntry:
+		if e.EntryID == "" {
+			return errors.New("delete-entry events need an entry_id")
+		}
+	case ActionImpair:
+		if e.Impairment != nil {
+			if err := e.Impairment.validate(); err != nil {
+				return fmt.Errorf("impairment: %w", err)
+			}
+		}
+	}
+	if e.msg == nil {
+		return nil
+	}
+	if e.Message == nil {
+		return fmt.Errorf("%s events need a message", e.Action)
+	}
+	data, err := json.Marshal(e.Message)
+	if err != nil {
+		return fmt.Errorf("encoding message: %w", err)
+	}
+	if err := protojson.Unmarshal(data, e.msg); err != nil {
+		return fmt.Errorf("parsing message as a %s: %w", e.msg.ProtoReflect().Descriptor().FullName(), err)
+	}
+	return nil
+}
+
+// Timeline returns the steps the scenario will take when it's run, in the
+// order they'll be taken. Events that don't happen because of their
+// probability are left out, and the end of each link-down or impair event
+// with a duration is a step of its own, which brings the link back up or
+// restores it.
+//
+// Each event draws the same amount of randomness whatever its settings, so
+// changing one event doesn't change the random choices made for the others.
+func (sc *Scenario) Timeline() []Step {
+	rng := rand.New(rand.NewPCG(sc.Seed, 0))
+	steps := []Step{}
+	for i := range sc.Events {
+		e := &sc.Events[i]
+		delay, roll := rng.Float64(), rng.Float64()
+		if e.Probability != nil && roll >= *e.Probability {
+			continue
+		}
+		at := e.At + time.Duration(delay*float64(e.Jitter))
+		steps = append(steps, Step{At: at, Event: e})
+		if e.Duration > 0 {
+			switch e.Action {
+			case ActionLinkDown:
+				steps = append(steps, Step{At: at + e.Duration, Event: &Event{Action: ActionLinkUp, Node: e.Node}})
+			case ActionImpair:
+				steps = append(steps, Step{At: at + e.Duration, Event: &Event{Action: ActionImpair, Node: e.Node}})
+			}
+		}
+	}
+	slices.SortStableFunc(steps, func(a, b Step) int { return cmp.Compare(a.At, b.At) })
+	return steps
+}
+
+// Run plays out the scenario against `srv`, returning once every step has
+// been taken. Steps are taken one at a time: a step that's due while an
+// earlier one is still in progress, such as waiting for a node to connect,
+// is taken as soon as the earlier one finishes.
+func (sc *Scenario) Run(ctx context.Context, srv *Server) error {
+	if err := sc.prepare(); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	for _, step := range sc.Timeline() {
+		if d := time.Until(start.Add(step.At)); d > 0 {
+			t := time.NewTimer(d)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return context.Cause(ctx)
+			case <-t.C:
+			}
+		}
+		if err := sc.runEvent(ctx, srv, step.Event); err != nil {
+			return fmt.Errorf("%s event at %v: %w", step.Event.Action, step.At, err)
+		}
+	}
+	return nil
+}
+
+func (sc *Scenario) runEvent(ctx context.Context, srv *Server, e *Event) error {
+	if e.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.Timeout)
+		defer cancel()
+	}
+
+	// Messages are cloned since sending them fills in their IDs.
+	switch e.Action {
+	case ActionWaitHello:
+		_, err := srv.Node(e.Node).WaitForHello(ctx)
+		return err
+	case ActionSend:
+		_, err := srv.Node(e.Node).Send(ctx, proto.Clone(e.msg).(*schedpb.ReceiveRequestsMessageFromController))
+		return err
+	case ActionCreateEntry:
+		_, err := srv.Node(e.Node).CreateEntry(ctx, proto.Clone(e.msg).(*schedpb.CreateEntryRequest))
+		return err
+	case ActionDeleteEntry:
+		_, err := srv.Node(e.Node).DeleteEntry(ctx, e.EntryID)
+		return err
+	case ActionResetStream:
+		srv.Node(e.Node).ResetStream()
+		return nil
+	case ActionLinkDown:
+		srv.Node(e.Node).SetLinkDown(true)
+		return nil
+	case ActionLinkUp:
+		srv.Node(e.Node).SetLinkDown(false)
+		return nil
+	case ActionImpair:
+		imp := Impairment{}
+		if e.Impairment != nil {
+			imp = *e.Impairment
+		}
+		return srv.Node(e.Node).SetImpairment(imp)
+	case ActionAckDelay:
+		srv.SetAckDelay(e.Duration)
+		return nil
+	case ActionTelemetry:
+		if sc.Telemetry == nil {
+			return errors.New("the scenario has no Telemetry function to report telemetry with")
+		}
+		return sc.Telemetry(ctx, e.Node, proto.Clone(e.msg).(*telemetrypb.ExportMetricsRequest))
+	case ActionWaitMetrics:
+		_, err := srv.WaitForMetrics(ctx)
+		return err
+	default:
+		return fmt.Errorf("unknown action %q", e.Action)
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sbisim
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	schedpb "aalyria.com/spacetime/api/scheduling/v1alpha"
+	telemetrypb "aalyria.com/spacetime/telemetry/v1alpha"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseScenario(t *testing.T) {
+	t.Parallel()
+
+	sc, err := ParseScenario([]byte(`
+seed: 7
+events:
+- action: wait-hello
+  node: node-a
+  timeout: 30s
+- at: 1s
+  action: create-entry
+  node: node-a
+  message:
+    id: entry-1
+    time: 2024-01-01T00:00:00Z
+    delete_route: {to: "2001:db8::/32"}
+- at: 2s
+  jitter: 500ms
+  probability: 0.25
+  action: link-down
+  node: node-a
+  duration: 10s
+`))
+	if err != nil {
+		t.Fatalf("ParseScenario: %v", err)
+	}
+	if sc.Seed != 7 || len(sc.Events) != 3 {
+		t.Fatalf("ParseScenario returned seed %d and %d events, want 7 and 3", sc.Seed, len(sc.Events))
+	}
+	if got := sc.Events[0].Timeout; got != 30*time.Second {
+		t.Errorf("first event has timeout %v, want 30s", got)
+	}
+	entry, ok := sc.Events[1].msg.(*schedpb.CreateEntryRequest)
+	if !ok || entry.GetId() != "entry-1" || entry.GetDeleteRoute().GetTo() != "2001:db8::/32" || entry.GetTime().GetSeconds() != 1704067200 {
+		t.Errorf("create-entry event has message %v, want entry-1 deleting the route to 2001:db8::/32", sc.Events[1].msg)
+	}
+	if e := sc.Events[2]; e.Jitter != 500*time.Millisecond || *e.Probability != 0.25 || e.Duration != 10*time.Second {
+		t.Errorf("link-down event = %+v, want 500ms of jitter, a probability of 0.25 and a duration of 10s", e)
+	}
+}
+
+func TestParseScenario_errors(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name, yaml, wantErr string
+	}{
+		{"unknown field", "events: [{action: wait-hello, node: a, colour: red}]", "colour"},
+		{"unknown action", "events: [{action: explode, node: a}]", `unknown action "explode"`},
+		{"missing action", "events: [{node: a}]", "missing action"},
+		{"missing node", "events: [{action: link-down}]", "link-down events need a node"},
+		{"missing message", "events: [{action: send, node: a}]", "send events need a message"},
+		{"missing entry ID", "events: [{action: delete-entry, node: a}]", "need an entry_id"},
+		{"bad message", "events: [{action: create-entry, node: a, message: {colour: red}}]", "parsing message as a aalyria.spacetime.scheduling.v1alpha.CreateEntryRequest"},
+		{"bad probability", "events: [{action: reset-stream, node: a, probability: 2}]", "probability must be between 0 and 1"},
+		{"negative time", "events: [{at: -1s, action: reset-stream, node: a}]", "can't be negative"},
+		{"bad impairment", "events: [{action: impair, node: a, impairment: {loss: 1.5}}]", "impairment: loss must be between 0 and 1"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := ParseScenario([]byte(tc.yaml))
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("ParseScenario(%q) returned error %v, want one containing %q", tc.yaml, err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func timelineActions(steps []Step) []string {
+	actions := []string{}
+	for _, s := range steps {
+		actions = append(actions, s.Event.Action+"@"+s.At.String())
+	}
+	return actions
+}
+
+func TestScenarioTimeline(t *testing.T) {
+	t.Parallel()
+
+	half := 0.5
+	newScenario := func(seed uint64) *Scenario {
+		sc := &Scenario{Seed: seed}
+		for i := range 20 {
+			sc.Events = append(sc.Events, Event{
+				At:          time.Duration(i) * time.Second,
+				Jitter:      time.Second,
+				Probability: &half,
+				Action:      ActionResetStream,
+				Node:        "node-a",
+			})
+		}
+		return sc
+	}
+
+	// The same seed always produces the same timeline.
+	first := timelineActions(newScenario(1).Timeline())
+	if diff := cmp.Diff(first, timelineActions(newScenario(1).Timeline())); diff != "" {
+		t.Errorf("timelines with the same seed differ (-first +second):\n%s", diff)
+	}
+	if len(first) == 0 || len(first) == 20 {
+		t.Errorf("timeline has %d of 20 events with a probability of 0.5", len(first))
+	}
+	if slices.Equal(first, timelineActions(newScenario(2).Timeline())) {
+		t.Errorf("timelines with seeds 1 and 2 are the same: %v", first)
+	}
+
+	// Changing one event doesn't affect the others.
+	others := func(sc *Scenario) []string {
+		return timelineActions(slices.DeleteFunc(sc.Timeline(), func(s Step) bool { return s.Event == &sc.Events[0] }))
+	}
+	sc := newScenario(1)
+	want := others(sc)
+	sc.Events[0].Probability = nil
+	sc.Events[0].Jitter = 0
+	if diff := cmp.Diff(want, others(sc)); diff != "" {
+		t.Errorf("changing the first event changed the others (-want +got):\n%s", diff)
+	}
+}
+
+func TestScenarioTimeline_linkDownDuration(t *testing.T) {
+	t.Parallel()
+
+	sc := &Scenario{Events: []Event{
+		{At: time.Second, Action: ActionLinkDown, Node: "node-a", Duration: 5 * time.Second},
+		{At: 3 * time.Second, Action: ActionResetStream, Node: "node-b"},
+	}}
+	want := []string{"link-down@1s", "reset-stream@3s", "link-up@6s"}
+	if diff := cmp.Diff(want, timelineActions(sc.Timeline())); diff != "" {
+		t.Errorf("unexpected timeline (-want +got):\n%s", diff)
+	}
+}
+
+func TestScenarioTimeline_impairDuration(t *testing.T) {
+	t.Parallel()
+
+	sc := &Scenario{Events: []Event{
+		{At: time.Second, Action: ActionImpair, Node: "node-a", Duration: 5 * time.Second, Impairment: &Impairment{Loss: 0.5}},
+	}}
+	steps := sc.Timeline()
+	want := []string{"impair@1s", "impair@6s"}
+	if diff := cmp.Diff(want, timelineActions(steps)); diff != "" {
+		t.Fatalf("unexpected timeline (-want +got):\n%s", diff)
+	}
+	if steps[1].Event.Impairment != nil {
+		t.Errorf("the impairment ends with impairment %+v, want none", *steps[1].Event.Impairment)
+	}
+}
+
+func TestScenarioRun(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	srv := New()
+	reports := []string{}
+	sc, err := ParseScenario([]byte(`
+events:
+- action: ack-delay
+  duration: 20ms
+- action: link-down
+  node: node-a
+  duration: 50ms
+- at: 10ms
+  action: telemetry
+  node: node-a
+  message:
+    interface_metrics: [{interface_id: eth0}]
+- at: 20ms
+  action: send
+  node: node-a
+  message:
+    request_id: 7
+    finalize: {schedule_manipulation_token: token, seqno: 1, up_to: 2024-01-01T00:00:00Z}
+`))
+	if err != nil {
+		t.Fatalf("ParseScenario: %v", err)
+	}
+	sc.Telemetry = func(_ context.Context, nodeID string, report *telemetrypb.ExportMetricsRequest) error {
+		if !srv.Node(nodeID).LinkDown() {
+			t.Errorf("telemetry was reported after the link came back up")
+		}
+		reports = append(reports, nodeID+":"+report.GetInterfaceMetrics()[0].GetInterfaceId())
+		return nil
+	}
+
+	start := time.Now()
+	if err := sc.Run(ctx, srv); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Run returned after %v, before the link came back up", elapsed)
+	}
+	if srv.Node("node-a").LinkDown() {
+		t.Error("node-a's link is still down")
+	}
+	if diff := cmp.Diff([]string{"node-a:eth0"}, reports); diff != "" {
+		t.Errorf("unexpected telemetry reports (-want +got):\n%s", diff)
+	}
+	if req, err := srv.Node("node-a").outbox.take(ctx); err != nil || req.GetRequestId() != 7 {
+		t.Errorf("node-a was sent request %v (%v), want request 7", req, err)
+	}
+	if d := srv.ackDelay; d != 20*time.Millisecond {
+		t.Errorf("ack delay is %v, want 20ms", d)
+	}
+
+	// Telemetry events need a Telemetry function.
+	sc.Telemetry = nil
+	if err := sc.Run(ctx, New()); err == nil {
+		t.Error("Run with a telemetry event and no Telemetry function succeeded")
+	}
+}
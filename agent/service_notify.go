@@ -0,0 +1,124 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// The states the Agent notifies its service manager of, in the format of
+// sd_notify(3).
+const (
+	notifyReady    = "READY=1"
+	notifyStopping = "STOPPING=1"
+	notifyWatchdog = "WATCHDOG=1"
+)
+
+// ServiceNotifier notifies a service manager, such as systemd, of the
+// Agent's state. Each state is a "KEY=value" assignment in the format of
+// sd_notify(3), such as "READY=1" or "STATUS=draining".
+type ServiceNotifier interface {
+	Notify(states ...string) error
+}
+
+// WithServiceNotifier configures the Agent to notify `n` once its nodes have
+// started, and again once it starts shutting down, so that it can run as a
+// systemd service of Type=notify. If `watchdogInterval` is positive, the
+// Agent also keeps the service manager's watchdog from restarting it for as
+// long as it's live (see [WithHealthServer]), by notifying it twice per
+// interval.
+func WithServiceNotifier(n ServiceNotifier, watchdogInterval time.Duration) AgentOption {
+	return agentOptFunc(func(a *Agent) {
+		a.notifier = n
+		a.watchdogInterval = watchdogInterval
+	})
+}
+
+// WithDrainTimeout configures the Agent to drain its nodes, for up to `d`,
+// once the context provided to Run is canceled, rather than stopping them
+// right away. While a node drains, entries that come due aren't dispatched,
+// those already dispatched are waited for, and its telemetry is flushed.
+// Meanwhile, the Agent is reported as not ready (see [WithHealthServer]).
+// Entries that weren't dispatched are still enacted after a restart if the
+// node persists its schedule (see [WithScheduleStateDir]).
+func WithDrainTimeout(d time.Duration) AgentOption {
+	return agentOptFunc(func(a *Agent) {
+		a.drainTimeout = d
+	})
+}
+
+// notify sends `states` to the configured ServiceNotifier, if any.
+func (a *Agent) notify(ctx context.Context, states ...string) {
+	if a.notifier == nil {
+		return
+	}
+	if err := a.notifier.Notify(states...); err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Strs("states", states).Msg("failed notifying the service manager")
+	}
+}
+
+// runWatchdog notifies the service manager's watchdog twice per
+// watchdogInterval, as long as `s` assesses the Agent as live, until `ctx`
+// is done.
+func (a *Agent) runWatchdog(ctx context.Context, s *healthServer) {
+	ticker := a.clock.NewTicker(a.watchdogInterval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.Chan():
+		}
+		if r := s.result(); !r.live {
+			zerolog.Ctx(ctx).Warn().Strs("details", r.details).Msg("agent isn't live; not notifying the watchdog")
+			continue
+		}
+		a.notify(ctx, notifyWatchdog)
+	}
+}
+
+// drain drains each of `nodes` that's still running, for up to
+// drainTimeout, and returns once they're done.
+func (a *Agent) drain(nodes map[string]runningNode) {
+	wg := &sync.WaitGroup{}
+	for id, n := range nodes {
+		if n.ctx.Err() != nil {
+			continue
+		}
+
+		ctx, cancel := context.WithCancel(n.ctx)
+		timer := a.clock.AfterFunc(a.drainTimeout, cancel)
+		log := zerolog.Ctx(ctx).With().Str("nodeID", id).Logger()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer cancel()
+			defer timer.Stop()
+
+			log.Info().Dur("timeout", a.drainTimeout).Msg("draining node")
+			if err := n.nc.drain(ctx); err != nil {
+				log.Warn().Err(err).Msg("node didn't drain cleanly")
+				return
+			}
+			log.Info().Msg("node drained")
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,197 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	schedpb "aalyria.com/spacetime/api/scheduling/v1alpha"
+
+	"github.com/jonboulle/clockwork"
+	"github.com/rs/zerolog"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// fakeNotifier sends each notification it's given on a channel.
+type fakeNotifier chan []string
+
+func (n fakeNotifier) Notify(states ...string) error {
+	n <- states
+	return nil
+}
+
+func (n fakeNotifier) expect(ctx context.Context, t *testing.T, want string) {
+	t.Helper()
+
+	for {
+		select {
+		case <-ctx.Done():
+			t.Fatalf("no %q notification: %v", want, context.Cause(ctx))
+		case states := <-n:
+			if slices.Contains(states, want) {
+				return
+			}
+		}
+	}
+}
+
+// blockingDriver dispatches entries once they're released, recording
+// whether the context of each dispatch was canceled in the meantime.
+type blockingDriver struct {
+	started  chan string
+	release  chan struct{}
+	canceled chan bool
+}
+
+func (d *blockingDriver) Init(context.Context) error { return nil }
+func (d *blockingDriver) Close() error               { return nil }
+func (d *blockingDriver) Stats() any                 { return nil }
+
+func (d *blockingDriver) Dispatch(ctx context.Context, req *schedpb.CreateEntryRequest) error {
+	d.started <- req.GetId()
+	select {
+	case <-d.release:
+		d.canceled <- false
+		return nil
+	case <-ctx.Done():
+		d.canceled <- true
+		return context.Cause(ctx)
+	}
+}
+
+func TestEnactmentService_drain(t *testing.T) {
+	t.Parallel()
+
+	nc := &nodeController{clock: clockwork.NewFakeClockAt(startTime)}
+	es := nc.newEnactmentService(nil, nil, "token")
+
+	if !es.startDispatch() {
+		t.Fatal("startDispatch() = false before draining, want true")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := es.drain(ctx); err == nil || !strings.Contains(err.Error(), "1 enactments still in flight") {
+		t.Errorf("drain() with a dispatch in flight = %v, want an error about it", err)
+	}
+	if es.startDispatch() {
+		t.Error("startDispatch() = true while draining, want false")
+	}
+
+	drained := make(chan error)
+	go func() { drained <- es.drain(context.Background()) }()
+	es.finishDispatch()
+	if err := <-drained; err != nil {
+		t.Errorf("drain() once the dispatch finished = %v, want nil", err)
+	}
+}
+
+func TestAgent_drainsOnShutdown(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(baseContext(t))
+	g, ctx := errgroup.WithContext(ctx)
+	defer func() {
+		cancel()
+		// The agent runs outside of g, so only the test server's goroutines,
+		// which stop gracefully, are left to wait for.
+		if err := g.Wait(); err != nil {
+			t.Errorf("unexpected error from the test server: %v", err)
+		}
+	}()
+
+	clock := clockwork.NewFakeClockAt(startTime)
+	srv := newServer(
+		zerolog.Ctx(ctx).With().Str("role", "test server").Logger().WithContext(ctx), []testNode{{id: "node-a"}})
+	srvAddr := srv.start(ctx, t, g)
+
+	driver := &blockingDriver{started: make(chan string), release: make(chan struct{}), canceled: make(chan bool, 1)}
+	notifier := make(fakeNotifier, 10)
+	agent := newAgent(t, WithClock(clock), WithDrainTimeout(time.Minute), WithServiceNotifier(notifier, 0), WithNode("node-a",
+		WithEnactmentDriver(srvAddr, driver, grpc.WithTransportCredentials(insecure.NewCredentials()))))
+	agentCtx, stopAgent := context.WithCancel(ctx)
+	defer stopAgent()
+	runErr := make(chan error, 1)
+	go func() { runErr <- agent.Run(agentCtx) }()
+	f := &testFixture{t: t, srv: srv, clock: clock}
+
+	notifier.expect(ctx, t, notifyReady)
+	token := f.expectSchedulingReset(ctx, "node-a")
+	f.expectSchedulingHello(ctx, "node-a")
+
+	f.sendSchedulingRequest(ctx, "node-a", &schedpb.ReceiveRequestsMessageFromController{
+		RequestId: 1,
+		Request: &schedpb.ReceiveRequestsMessageFromController_CreateEntry{CreateEntry: &schedpb.CreateEntryRequest{
+			ScheduleManipulationToken: token,
+			Seqno:                     1,
+			Id:                        "set-route",
+			Time:                      timestamppb.New(startTime.Add(time.Second)),
+			ConfigurationChange: &schedpb.CreateEntryRequest_SetRoute{
+				SetRoute: &schedpb.SetRoute{To: "2001:db8:1::/48", Dev: "eth0"},
+			},
+		}},
+	})
+	msg, err := srv.RecvFromNode(ctx, "node-a")
+	check(t, err)
+	if rsp := msg.GetResponse(); rsp.GetRequestId() != 1 || rsp.GetStatus().GetCode() != int32(codes.OK) {
+		t.Fatalf("unexpected response to CreateEntry: %v", rsp)
+	}
+
+	f.advanceClock(ctx, time.Second)
+	select {
+	case <-ctx.Done():
+		t.Fatal("entry wasn't dispatched")
+	case <-driver.started:
+	}
+
+	// The dispatch in flight isn't canceled along with the agent, and the
+	// agent doesn't stop until it's done.
+	stopAgent()
+	notifier.expect(ctx, t, notifyStopping)
+	select {
+	case err := <-runErr:
+		t.Fatalf("Run() returned before the dispatch in flight finished: %v", err)
+	default:
+	}
+	close(driver.release)
+	if <-driver.canceled {
+		t.Error("dispatch in flight was canceled while the agent drained")
+	}
+	checkErrIsDueToCanceledContext(t, <-runErr)
+}
+
+func TestAgent_notifiesWatchdogWhileLive(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(baseContext(t))
+	defer cancel()
+
+	clock := clockwork.NewFakeClockAt(startTime)
+	notifier := make(fakeNotifier, 10)
+	a := &Agent{clock: clock, notifier: notifier, watchdogInterval: 10 * time.Second}
+	s := a.newHealthServer(map[string]runningNode{})
+
+	go a.runWatchdog(ctx, s)
+	clock.BlockUntil(1)
+	clock.Advance(5 * time.Second)
+	notifier.expect(ctx, t, notifyWatchdog)
+}
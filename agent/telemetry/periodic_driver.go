@@ -79,3 +79,17 @@ func (pd *PeriodicDriver) Run(
 		}
 	}
 }
+
+// Flush reports a final report from the generator, so that the metrics
+// gathered since the last period aren't lost.
+func (pd *PeriodicDriver) Flush(
+	ctx context.Context,
+	nodeID string,
+	reportMetrics func(*telemetrypb.ExportMetricsRequest) error,
+) error {
+	report, err := pd.GenerateReport(ctx, nodeID)
+	if err != nil || report == nil {
+		return err
+	}
+	return reportMetrics(report)
+}
@@ -107,3 +107,21 @@ func TestPeriodicDriver(t *testing.T) {
 	cancel()
 	g.Wait()
 }
+
+func TestPeriodicDriver_flush(t *testing.T) {
+	clock := clockwork.NewFakeClock()
+	driver := NewPeriodicDriver(&constantGenerator{clock: clock}, clock, time.Hour)
+
+	var reports []*telemetrypb.ExportMetricsRequest
+	err := driver.Flush(context.Background(), "node ID doesn't matter", func(r *telemetrypb.ExportMetricsRequest) error {
+		reports = append(reports, r)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 1 {
+		t.Fatalf("Flush() reported %d reports, want 1", len(reports))
+	}
+	var _ Flusher = driver
+}
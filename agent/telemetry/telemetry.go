@@ -34,3 +34,14 @@ type Driver interface {
 	// if it's configured.
 	Stats() any
 }
+
+// Flusher is implemented by drivers that can report the metrics they've
+// gathered but not reported yet, which the agent does before it stops when
+// it's drained.
+type Flusher interface {
+	Flush(
+		ctx context.Context,
+		nodeID string,
+		reportMetrics func(*telemetrypb.ExportMetricsRequest) error,
+	) error
+}
@@ -0,0 +1,287 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	telemetrypb "aalyria.com/spacetime/telemetry/v1alpha"
+)
+
+// deltaRefreshInterval is how often an interface's operational state is
+// exported even though it hasn't changed, when delta encoding is enabled, so
+// that the controller doesn't consider it stale.
+const deltaRefreshInterval = 5 * time.Minute
+
+// DropPolicy selects which telemetry report is dropped when a node's
+// telemetry queue is full (see [WithTelemetryQueue]).
+type DropPolicy int
+
+const (
+	// DropOldest drops the report that's been queued the longest.
+	DropOldest DropPolicy = iota
+	// DropNewest drops the report that's being queued.
+	DropNewest
+	// DropLowestPriority drops the oldest of the reports with the lowest
+	// priority, including the one that's being queued. Reports of the
+	// node's condition take priority over the driver's metrics.
+	DropLowestPriority
+)
+
+// TelemetryQueueConfig configures a node's telemetry queue.
+type TelemetryQueueConfig struct {
+	// Size is the most reports that are queued before some are dropped, per
+	// DropPolicy. Defaults to 64.
+	Size       int
+	DropPolicy DropPolicy
+	// MaxBatch is the most queued reports merged into a single export.
+	// Defaults to 16.
+	MaxBatch int
+	// DeltaEncoding omits the interface operational states that haven't
+	// changed since they were last exported, except every
+	// deltaRefreshInterval.
+	DeltaEncoding bool
+}
+
+// WithTelemetryQueue configures the Agent to queue each node's telemetry
+// reports rather than export them as they're generated, for sites whose
+// uplink is constrained. Reports that are queued while an export is in
+// flight, or while the controller is unreachable, are merged into batches,
+// which compress better, and the driver never waits for the network. Once
+// the queue is full, reports are dropped per the configured policy; the
+// number dropped is exported in each node's stats.
+func WithTelemetryQueue(cfg TelemetryQueueConfig) AgentOption {
+	return agentOptFunc(func(a *Agent) {
+		a.telemetryQueue = &cfg
+	})
+}
+
+// queuedReport is a report waiting in a telemetryQueue.
+type queuedReport struct {
+	req *telemetrypb.ExportMetricsRequest
+	// count is the number of reports merged into req.
+	count uint64
+	// priority is higher for reports that should be dropped last.
+	priority int
+}
+
+func reportPriority(req *telemetrypb.ExportMetricsRequest) int {
+	if len(req.GetNodeConditionDataPoints()) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// telemetryQueue is a bounded queue of telemetry reports, which are merged
+// into batches as they're taken off it. A telemetryQueue is safe for
+// concurrent use.
+type telemetryQueue struct {
+	cfg TelemetryQueueConfig
+	now func() time.Time
+	// ready receives a value when reports are queued.
+	ready chan struct{}
+
+	mu      sync.Mutex
+	reports []queuedReport
+	// exportedStates is the latest operational state exported for each
+	// interface, and when, for delta encoding.
+	exportedStates map[string]exportedState
+
+	queued, exported, dropped, batches uint64
+}
+
+type exportedState struct {
+	value telemetrypb.IfOperStatus
+	at    time.Time
+}
+
+func newTelemetryQueue(cfg TelemetryQueueConfig, now func() time.Time) *telemetryQueue {
+	if cfg.Size < 1 {
+		cfg.Size = 64
+	}
+	if cfg.MaxBatch < 1 {
+		cfg.MaxBatch = 16
+	}
+	return &telemetryQueue{
+		cfg:            cfg,
+		now:            now,
+		ready:          make(chan struct{}, 1),
+		exportedStates: map[string]exportedState{},
+	}
+}
+
+// push queues `req`, dropping a report if the queue is full.
+func (q *telemetryQueue) push(req *telemetrypb.ExportMetricsRequest) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.queued++
+	q.reports = append(q.reports, queuedReport{req: req, count: 1, priority: reportPriority(req)})
+	q.trim()
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// requeue puts a batch that failed to export back at the front of the
+// queue, dropping reports if that overfills it.
+func (q *telemetryQueue) requeue(b *telemetryQueueBatch) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.reports = slices.Insert(q.reports, 0, queuedReport{req: b.req, count: b.reports, priority: reportPriority(b.req)})
+	q.trim()
+}
+
+// trim drops reports until the queue is no longer overfull. Callers must
+// hold q.mu.
+func (q *telemetryQueue) trim() {
+	for len(q.reports) > q.cfg.Size {
+		i := 0
+		switch q.cfg.DropPolicy {
+		case DropNewest:
+			i = len(q.reports) - 1
+		case DropLowestPriority:
+			for j, r := range q.reports {
+				if r.priority < q.reports[i].priority {
+					i = j
+				}
+			}
+		}
+		q.dropped += q.reports[i].count
+		q.reports = slices.Delete(q.reports, i, i+1)
+	}
+}
+
+// pop takes up to MaxBatch reports off the queue and merges them into one,
+// or returns nil if the queue is empty.
+func (q *telemetryQueue) pop() *telemetryQueueBatch {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.reports) == 0 {
+		return nil
+	}
+	n := min(len(q.reports), q.cfg.MaxBatch)
+	b := &telemetryQueueBatch{req: &telemetrypb.ExportMetricsRequest{}}
+	for _, r := range q.reports[:n] {
+		b.req.InterfaceMetrics = append(b.req.InterfaceMetrics, r.req.GetInterfaceMetrics()...)
+		b.req.ModemMetrics = append(b.req.ModemMetrics, r.req.GetModemMetrics()...)
+		b.req.NodeConditionDataPoints = append(b.req.NodeConditionDataPoints, r.req.GetNodeConditionDataPoints()...)
+		b.reports += r.count
+	}
+	q.reports = slices.Delete(q.reports, 0, n)
+	b.req.InterfaceMetrics = mergeInterfaceMetrics(b.req.InterfaceMetrics)
+	if q.cfg.DeltaEncoding {
+		q.omitUnchangedStates(b.req)
+	}
+	return b
+}
+
+// telemetryQueueBatch is a report merged from a number of queued reports.
+type telemetryQueueBatch struct {
+	req     *telemetrypb.ExportMetricsRequest
+	reports uint64
+}
+
+// done records that `b` was exported, or requeues it if it wasn't.
+func (q *telemetryQueue) done(b *telemetryQueueBatch, err error) {
+	if err != nil {
+		q.requeue(b)
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.batches++
+	q.exported += b.reports
+	now := q.now()
+	for _, im := range b.req.GetInterfaceMetrics() {
+		if dps := im.GetOperationalStateDataPoints(); len(dps) > 0 {
+			q.exportedStates[im.GetInterfaceId()] = exportedState{value: dps[len(dps)-1].GetValue(), at: now}
+		}
+	}
+}
+
+// discard records that `b` was dropped instead of exported.
+func (q *telemetryQueue) discard(b *telemetryQueueBatch) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.dropped += b.reports
+}
+
+// mergeInterfaceMetrics combines the metrics of each interface into a
+// single InterfaceMetrics, in the order the interfaces first appear. The
+// metrics it's given aren't modified.
+func mergeInterfaceMetrics(ims []*telemetrypb.InterfaceMetrics) []*telemetrypb.InterfaceMetrics {
+	merged := []*telemetrypb.InterfaceMetrics{}
+	byID := map[string]*telemetrypb.InterfaceMetrics{}
+	for _, im := range ims {
+		m, ok := byID[im.GetInterfaceId()]
+		if !ok {
+			m = &telemetrypb.InterfaceMetrics{InterfaceId: im.GetInterfaceId()}
+			byID[im.GetInterfaceId()] = m
+			merged = append(merged, m)
+		}
+		m.OperationalStateDataPoints = append(m.OperationalStateDataPoints, im.GetOperationalStateDataPoints()...)
+		m.StandardInterfaceStatisticsDataPoints = append(m.StandardInterfaceStatisticsDataPoints, im.GetStandardInterfaceStatisticsDataPoints()...)
+	}
+	return merged
+}
+
+// omitUnchangedStates drops the operational states from `req` that repeat
+// the state before them, or the state last exported unless that's due to
+// be refreshed. Callers must hold q.mu.
+func (q *telemetryQueue) omitUnchangedStates(req *telemetrypb.ExportMetricsRequest) {
+	now := q.now()
+	for _, im := range req.GetInterfaceMetrics() {
+		prev, ok := q.exportedStates[im.GetInterfaceId()]
+		stale := !ok || now.Sub(prev.at) >= deltaRefreshInterval
+		dps := im.GetOperationalStateDataPoints()[:0]
+		for _, dp := range im.GetOperationalStateDataPoints() {
+			if !stale && dp.GetValue() == prev.value {
+				continue
+			}
+			dps = append(dps, dp)
+			prev.value, stale = dp.GetValue(), false
+		}
+		im.OperationalStateDataPoints = dps
+	}
+}
+
+type telemetryQueueStats struct {
+	Length, Size    int
+	ReportsQueued   uint64
+	ReportsExported uint64
+	ReportsDropped  uint64
+	BatchesExported uint64
+}
+
+func (q *telemetryQueue) Stats() interface{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return telemetryQueueStats{
+		Length:          len(q.reports),
+		Size:            q.cfg.Size,
+		ReportsQueued:   q.queued,
+		ReportsExported: q.exported,
+		ReportsDropped:  q.dropped,
+		BatchesExported: q.batches,
+	}
+}
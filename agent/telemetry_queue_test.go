@@ -0,0 +1,176 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	telemetrypb "aalyria.com/spacetime/telemetry/v1alpha"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func ifaceReport(id string, states ...telemetrypb.IfOperStatus) *telemetrypb.ExportMetricsRequest {
+	im := &telemetrypb.InterfaceMetrics{InterfaceId: id}
+	for _, s := range states {
+		im.OperationalStateDataPoints = append(im.OperationalStateDataPoints, &telemetrypb.IfOperStatusDataPoint{Value: s})
+	}
+	return &telemetrypb.ExportMetricsRequest{InterfaceMetrics: []*telemetrypb.InterfaceMetrics{im}}
+}
+
+func conditionReport() *telemetrypb.ExportMetricsRequest {
+	return &telemetrypb.ExportMetricsRequest{
+		NodeConditionDataPoints: []*telemetrypb.NodeConditionDataPoint{{HealthScore: 1}},
+	}
+}
+
+// describeReport lists the interfaces in `req`, followed by "condition" if
+// it reports the node's condition.
+func describeReport(req *telemetrypb.ExportMetricsRequest) []string {
+	desc := []string{}
+	for _, im := range req.GetInterfaceMetrics() {
+		desc = append(desc, im.GetInterfaceId())
+	}
+	if len(req.GetNodeConditionDataPoints()) > 0 {
+		desc = append(desc, "condition")
+	}
+	return desc
+}
+
+// popAll describes each batch popped off `q` until it's empty.
+func popAll(q *telemetryQueue) [][]string {
+	batches := [][]string{}
+	for b := q.pop(); b != nil; b = q.pop() {
+		batches = append(batches, describeReport(b.req))
+		q.done(b, nil)
+	}
+	return batches
+}
+
+func TestTelemetryQueue_batches(t *testing.T) {
+	t.Parallel()
+
+	q := newTelemetryQueue(TelemetryQueueConfig{MaxBatch: 3}, time.Now)
+	q.push(ifaceReport("eth0", telemetrypb.IfOperStatus_IF_OPER_STATUS_UP))
+	q.push(ifaceReport("eth1"))
+	q.push(ifaceReport("eth0", telemetrypb.IfOperStatus_IF_OPER_STATUS_DOWN))
+	q.push(conditionReport())
+
+	b := q.pop()
+	if diff := cmp.Diff([]string{"eth0", "eth1"}, describeReport(b.req)); diff != "" {
+		t.Errorf("first batch mismatch (-want +got):\n%s", diff)
+	}
+	if got := len(b.req.GetInterfaceMetrics()[0].GetOperationalStateDataPoints()); got != 2 {
+		t.Errorf("first batch has %d eth0 states, want both of them", got)
+	}
+	q.done(b, nil)
+	if diff := cmp.Diff([][]string{{"condition"}}, popAll(q)); diff != "" {
+		t.Errorf("remaining batches mismatch (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff(telemetryQueueStats{Size: 64, ReportsQueued: 4, ReportsExported: 4, BatchesExported: 2}, q.Stats()); diff != "" {
+		t.Errorf("stats mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestTelemetryQueue_dropPolicies(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		policy DropPolicy
+		want   [][]string
+	}{
+		{DropOldest, [][]string{{"eth0"}, {"eth1"}}},
+		{DropNewest, [][]string{{"condition"}, {"eth0"}}},
+		{DropLowestPriority, [][]string{{"condition"}, {"eth1"}}},
+	} {
+		q := newTelemetryQueue(TelemetryQueueConfig{Size: 2, MaxBatch: 1, DropPolicy: tc.policy}, time.Now)
+		q.push(conditionReport())
+		q.push(ifaceReport("eth0"))
+		q.push(ifaceReport("eth1"))
+
+		if diff := cmp.Diff(tc.want, popAll(q)); diff != "" {
+			t.Errorf("policy %d: batches mismatch (-want +got):\n%s", tc.policy, diff)
+		}
+		if got := q.Stats().(telemetryQueueStats).ReportsDropped; got != 1 {
+			t.Errorf("policy %d: %d reports dropped, want 1", tc.policy, got)
+		}
+	}
+}
+
+func TestTelemetryQueue_requeuesFailedBatches(t *testing.T) {
+	t.Parallel()
+
+	q := newTelemetryQueue(TelemetryQueueConfig{Size: 2}, time.Now)
+	q.push(ifaceReport("eth0"))
+	q.push(ifaceReport("eth1"))
+	b := q.pop()
+	q.push(ifaceReport("eth2"))
+	q.done(b, errors.New("unavailable"))
+
+	// The failed batch is put back in front of the queue. It counts as the
+	// two reports it was merged from, which are dropped together once the
+	// queue overflows.
+	q.push(ifaceReport("eth3"))
+	if diff := cmp.Diff([][]string{{"eth2", "eth3"}}, popAll(q)); diff != "" {
+		t.Errorf("batches mismatch (-want +got):\n%s", diff)
+	}
+	if got := q.Stats().(telemetryQueueStats).ReportsDropped; got != 2 {
+		t.Errorf("%d reports dropped, want 2", got)
+	}
+}
+
+func TestTelemetryQueue_deltaEncoding(t *testing.T) {
+	t.Parallel()
+
+	const (
+		up   = telemetrypb.IfOperStatus_IF_OPER_STATUS_UP
+		down = telemetrypb.IfOperStatus_IF_OPER_STATUS_DOWN
+	)
+	now := startTime
+	q := newTelemetryQueue(TelemetryQueueConfig{DeltaEncoding: true}, func() time.Time { return now })
+	export := func(states ...telemetrypb.IfOperStatus) []telemetrypb.IfOperStatus {
+		t.Helper()
+
+		q.push(ifaceReport("eth0", states...))
+		b := q.pop()
+		q.done(b, nil)
+		got := []telemetrypb.IfOperStatus{}
+		for _, dp := range b.req.GetInterfaceMetrics()[0].GetOperationalStateDataPoints() {
+			got = append(got, dp.GetValue())
+		}
+		return got
+	}
+
+	for _, step := range []struct {
+		desc    string
+		advance time.Duration
+		states  []telemetrypb.IfOperStatus
+		want    []telemetrypb.IfOperStatus
+	}{
+		{"first export", 0, []telemetrypb.IfOperStatus{up, up, down}, []telemetrypb.IfOperStatus{up, down}},
+		{"unchanged", time.Minute, []telemetrypb.IfOperStatus{down}, []telemetrypb.IfOperStatus{}},
+		{"changed", time.Minute, []telemetrypb.IfOperStatus{down, up}, []telemetrypb.IfOperStatus{up}},
+		{"refreshed", deltaRefreshInterval, []telemetrypb.IfOperStatus{up}, []telemetrypb.IfOperStatus{up}},
+	} {
+		now = now.Add(step.advance)
+		if got := export(step.states...); !slices.Equal(got, step.want) {
+			t.Errorf("%s: exported states %v, want %v", step.desc, got, step.want)
+		}
+	}
+}
@@ -16,32 +16,166 @@ package agent
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"time"
 
+	"aalyria.com/spacetime/agent/internal/health"
 	"aalyria.com/spacetime/agent/telemetry"
 	telemetrypb "aalyria.com/spacetime/telemetry/v1alpha"
+
+	"github.com/jonboulle/clockwork"
+	"github.com/rs/zerolog"
+	"golang.org/x/sync/errgroup"
 )
 
 type telemetryService struct {
 	nodeID          string
 	telemetryClient telemetrypb.TelemetryClient
 	td              telemetry.Driver
+	// allowReport reports whether the next report should be sent, or
+	// dropped to stay within the agent's bandwidth cap.
+	allowReport func() bool
+
+	clock clockwork.Clock
+	// condition assesses the node, which is reported every
+	// healthReportInterval.
+	condition func() health.Report
+	// exports records the outcome of each report.
+	exports *health.Results
+	// queue, if set, holds the reports until they're exported (see
+	// WithTelemetryQueue).
+	queue *telemetryQueue
 }
 
+// telemetryRetryInterval is how long the telemetry service waits to export
+// its queued reports again after an export fails.
+const telemetryRetryInterval = 5 * time.Second
+
 func (nc *nodeController) newTelemetryService(tc telemetrypb.TelemetryClient, td telemetry.Driver) *telemetryService {
 	return &telemetryService{
 		nodeID:          nc.id,
 		telemetryClient: tc,
 		td:              td,
+		allowReport:     func() bool { return true },
+		clock:           nc.clock,
+		condition:       nc.health.Report,
+		exports:         health.NewResults(healthResultWindow),
 	}
 }
 
 func (ts *telemetryService) Stats() interface{} { return ts.td.Stats() }
 
+// reportMetrics returns a function that exports each report it's given,
+// unless it has to be dropped to stay within the bandwidth cap. If the
+// service has a queue, the function queues the reports instead.
+func (ts *telemetryService) reportMetrics(ctx context.Context) func(*telemetrypb.ExportMetricsRequest) error {
+	if ts.queue != nil {
+		return func(report *telemetrypb.ExportMetricsRequest) error {
+			ts.queue.push(report)
+			return nil
+		}
+	}
+	return func(report *telemetrypb.ExportMetricsRequest) error {
+		if !ts.allowReport() {
+			return nil
+		}
+		return ts.export(ctx, report)
+	}
+}
+
+func (ts *telemetryService) export(ctx context.Context, report *telemetrypb.ExportMetricsRequest) error {
+	_, err := ts.telemetryClient.ExportMetrics(ctx, report)
+	ts.exports.Record(err)
+	return err
+}
+
+// exportQueued exports the reports in the queue, in batches, until `ctx` is
+// done. A batch that fails to export is retried after
+// telemetryRetryInterval, along with whatever's been queued meanwhile.
+func (ts *telemetryService) exportQueued(ctx context.Context) error {
+	for {
+		ready, retry := ts.queue.ready, (<-chan time.Time)(nil)
+		if err := ts.exportBatches(ctx); err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Dur("retryIn", telemetryRetryInterval).Msg("failed exporting queued telemetry")
+			ready, retry = nil, ts.clock.After(telemetryRetryInterval)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ready:
+		case <-retry:
+		}
+	}
+}
+
+// exportBatches exports batches from the queue until it's empty or an export
+// fails, in which case the batch is put back.
+func (ts *telemetryService) exportBatches(ctx context.Context) error {
+	for b := ts.queue.pop(); b != nil; b = ts.queue.pop() {
+		if !ts.allowReport() {
+			ts.queue.discard(b)
+			continue
+		}
+		err := ts.export(ctx, b.req)
+		ts.queue.done(b, err)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ts *telemetryService) conditionReport() *telemetrypb.ExportMetricsRequest {
+	return &telemetrypb.ExportMetricsRequest{
+		NodeConditionDataPoints: []*telemetrypb.NodeConditionDataPoint{nodeConditionProto(ts.condition())},
+	}
+}
+
 func (ts *telemetryService) run(ctx context.Context) error {
-	reportMetrics := func(report *telemetrypb.ExportMetricsRequest) error {
-		_, err := ts.telemetryClient.ExportMetrics(ctx, report)
-		return err
+	reportMetrics := ts.reportMetrics(ctx)
+
+	g, ctx := errgroup.WithContext(ctx)
+	if ts.queue != nil {
+		g.Go(func() error { return ts.exportQueued(ctx) })
 	}
+	g.Go(func() error { return ts.td.Run(ctx, ts.nodeID, reportMetrics) })
+	g.Go(func() error {
+		ticker := ts.clock.NewTicker(healthReportInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.Chan():
+			}
+			// A failed report is retried at the next interval, with a fresh
+			// assessment.
+			reportMetrics(ts.conditionReport())
+		}
+	})
+	return g.Wait()
+}
 
-	return ts.td.Run(ctx, ts.nodeID, reportMetrics)
+// drain reports the metrics the driver has gathered but not reported yet,
+// if it can, and a final assessment of the node, along with whatever's
+// still queued.
+func (ts *telemetryService) drain(ctx context.Context) error {
+	reportMetrics := ts.reportMetrics(ctx)
+	errs := []error{}
+	if f, ok := ts.td.(telemetry.Flusher); ok {
+		if err := f.Flush(ctx, ts.nodeID, reportMetrics); err != nil {
+			errs = append(errs, fmt.Errorf("flushing telemetry: %w", err))
+		}
+	}
+	if err := reportMetrics(ts.conditionReport()); err != nil {
+		errs = append(errs, fmt.Errorf("reporting node condition: %w", err))
+	}
+	if ts.queue != nil {
+		if err := ts.exportBatches(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("exporting queued telemetry: %w", err))
+		}
+	}
+	return errors.Join(errs...)
 }
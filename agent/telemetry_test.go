@@ -21,6 +21,7 @@ import (
 	"testing"
 	"time"
 
+	"aalyria.com/spacetime/agent/internal/bandwidth"
 	apipb "aalyria.com/spacetime/api/common"
 	telemetrypb "aalyria.com/spacetime/telemetry/v1alpha"
 
@@ -30,6 +31,7 @@ import (
 	"google.golang.org/protobuf/encoding/prototext"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 func textPBIfaceID(t *testing.T, nodeID, ifaceID string) string {
@@ -96,6 +98,120 @@ func TestRelaysMetricsFromDriverToController(t *testing.T) {
 	checkErrIsDueToCanceledContext(t, <-errCh)
 }
 
+func TestExportsQueuedMetricsToController(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(baseContext(t), time.Second)
+	defer cancel()
+
+	ts := NewTelemetryServer()
+	td := newManualReportDriver()
+
+	srvAddr := ts.Start(ctx, t)
+	a := newAgent(t,
+		WithClock(clockwork.NewFakeClock()),
+		WithTelemetryQueue(TelemetryQueueConfig{}),
+		WithNode("mynode", WithTelemetryDriver(srvAddr, td, grpc.WithTransportCredentials(insecure.NewCredentials()))))
+	errCh := make(chan error)
+	go func() { errCh <- a.Run(ctx) }()
+
+	report := &telemetrypb.ExportMetricsRequest{
+		InterfaceMetrics: []*telemetrypb.InterfaceMetrics{{
+			InterfaceId: textPBIfaceID(t, "foobar", "lo0"),
+			StandardInterfaceStatisticsDataPoints: []*telemetrypb.StandardInterfaceStatisticsDataPoint{{
+				TxBytes: 1,
+				RxBytes: 12,
+			}},
+		}},
+	}
+	td.reports <- report
+	assertProtosEqual(t, report, <-ts.reportedMetrics)
+
+	cancel()
+	checkErrIsDueToCanceledContext(t, <-errCh)
+}
+
+func TestDropsMetricsOverBandwidthCap(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(baseContext(t), time.Second)
+	defer cancel()
+
+	ts := NewTelemetryServer()
+	td := newManualReportDriver()
+
+	srvAddr := ts.Start(ctx, t)
+	a := newAgent(t,
+		WithClock(clockwork.NewFakeClock()),
+		// The first report alone exceeds the cap.
+		WithDailyBandwidthCap(1, 0),
+		WithNode("mynode", WithTelemetryDriver(srvAddr, td, grpc.WithTransportCredentials(insecure.NewCredentials()))))
+	errCh := make(chan error)
+	go func() { errCh <- a.Run(ctx) }()
+
+	report := &telemetrypb.ExportMetricsRequest{
+		InterfaceMetrics: []*telemetrypb.InterfaceMetrics{{InterfaceId: textPBIfaceID(t, "foobar", "lo0")}},
+	}
+	td.reports <- report
+	assertProtosEqual(t, report, <-ts.reportedMetrics)
+
+	// The driver handles reports one at a time, so once the third report is
+	// accepted the second has been dropped.
+	td.reports <- report
+	td.reports <- report
+	select {
+	case <-ts.reportedMetrics:
+		t.Errorf("report was sent despite the bandwidth cap being exceeded")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel()
+	checkErrIsDueToCanceledContext(t, <-errCh)
+
+	if got := a.bandwidth.Stats().(bandwidth.Stats).TelemetryReportsDropped; got != 2 {
+		t.Errorf("got %d dropped reports, want 2", got)
+	}
+}
+
+func TestReportsNodeConditionToController(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(baseContext(t), time.Second)
+	defer cancel()
+
+	ts := NewTelemetryServer()
+	td := newManualReportDriver()
+	clock := clockwork.NewFakeClockAt(startTime)
+
+	srvAddr := ts.Start(ctx, t)
+	a := newAgent(t,
+		WithClock(clock),
+		WithNode("mynode", WithTelemetryDriver(srvAddr, td, grpc.WithTransportCredentials(insecure.NewCredentials()))))
+	errCh := make(chan error)
+	go func() { errCh <- a.Run(ctx) }()
+
+	// Wait for the condition report ticker.
+	clock.BlockUntil(1)
+	clock.Advance(healthReportInterval)
+
+	assertProtosEqual(t, &telemetrypb.ExportMetricsRequest{
+		NodeConditionDataPoints: []*telemetrypb.NodeConditionDataPoint{{
+			Time:        timestamppb.New(clock.Now()),
+			HealthScore: 1,
+			State:       telemetrypb.NodeHealthState_NODE_HEALTH_STATE_HEALTHY,
+			Checks: []*telemetrypb.NodeHealthCheck{{
+				Name:   "telemetry_export",
+				Score:  1,
+				State:  telemetrypb.NodeHealthState_NODE_HEALTH_STATE_HEALTHY,
+				Detail: "no operations yet",
+			}},
+		}},
+	}, <-ts.reportedMetrics)
+
+	cancel()
+	checkErrIsDueToCanceledContext(t, <-errCh)
+}
+
 type telemetryServer struct {
 	reportedMetrics chan *telemetrypb.ExportMetricsRequest
 
@@ -17,6 +17,7 @@ package auth // import "aalyria.com/spacetime/auth"
 import (
 	"cmp"
 	"context"
+	"crypto"
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
@@ -53,24 +54,27 @@ var (
 
 // authCredentials is an implementation of [credentials.PerRPCCredentials].
 type authCredentials struct {
-	spacetimeTokenSrc, proxyTokenSrc func(context.Context) (string, error)
+	spacetimeTokenSrc, proxyTokenSrc TokenSource
 }
 
 func (ac authCredentials) RequireTransportSecurity() bool { return true }
 
 func (ac authCredentials) fetch(ctx context.Context) (stToken, proxyToken string, _ error) {
 	wg := sync.WaitGroup{}
-	wg.Add(2)
+	wg.Add(1)
 
 	var stErr, proxyErr error
 	go func() {
 		defer wg.Done()
-		stToken, stErr = ac.spacetimeTokenSrc(ctx)
-	}()
-	go func() {
-		defer wg.Done()
-		proxyToken, proxyErr = ac.proxyTokenSrc(ctx)
+		stToken, stErr = ac.spacetimeTokenSrc.Token(ctx)
 	}()
+	if ac.proxyTokenSrc != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			proxyToken, proxyErr = ac.proxyTokenSrc.Token(ctx)
+		}()
+	}
 
 	wg.Wait()
 
@@ -91,24 +95,130 @@ func (ac authCredentials) GetRequestMetadata(ctx context.Context, uri ...string)
 		return nil, fmt.Errorf("unable to transfer TokenSource PerRPCCredentials: %w", err)
 	}
 
-	return map[string]string{
-		authHeader:      "Bearer " + stToken,
-		proxyAuthHeader: "Bearer " + proxyToken,
-	}, nil
+	md := map[string]string{authHeader: "Bearer " + stToken}
+	if ac.proxyTokenSrc != nil {
+		md[proxyAuthHeader] = "Bearer " + proxyToken
+	}
+	return md, nil
+}
+
+// NewRoundTripper returns an [http.RoundTripper] that adds the same headers
+// to each request that `creds` adds to gRPC calls, then sends the request
+// using `base`, or [http.DefaultTransport] if `base` is nil. `creds` must
+// have been created by this package. As with gRPC, the tokens are only sent
+// over a secure transport, so requests must use HTTPS.
+func NewRoundTripper(creds credentials.PerRPCCredentials, base http.RoundTripper) (http.RoundTripper, error) {
+	ac, ok := creds.(authCredentials)
+	if !ok {
+		return nil, fmt.Errorf("unsupported credentials type %T", creds)
+	}
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return authTransport{creds: ac, base: base}, nil
+}
+
+type authTransport struct {
+	creds authCredentials
+	base  http.RoundTripper
+}
+
+func (t authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	closeBody := func() {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+	}
+
+	if req.URL.Scheme != "https" {
+		closeBody()
+		return nil, fmt.Errorf("refusing to send credentials to %s over %s", req.URL.Host, req.URL.Scheme)
+	}
+	stToken, proxyToken, err := t.creds.fetch(req.Context())
+	if err != nil {
+		closeBody()
+		return nil, err
+	}
+
+	// RoundTrippers mustn't modify the request they're given.
+	req = req.Clone(req.Context())
+	req.Header.Set(authHeader, "Bearer "+stToken)
+	if t.creds.proxyTokenSrc != nil {
+		req.Header.Set(proxyAuthHeader, "Bearer "+proxyToken)
+	}
+	return t.base.RoundTrip(req)
 }
 
 type Config struct {
-	Client       *http.Client
-	Clock        clockwork.Clock
-	PrivateKey   io.Reader
+	// Client is used to exchange tokens with the identity provider of the
+	// proxy. Defaults to [http.DefaultClient].
+	Client     *http.Client
+	Clock      clockwork.Clock
+	PrivateKey io.Reader
+	// Signer signs JWTs in place of PrivateKey, which is then ignored. It
+	// allows the key to be held by an HSM or smartcard, such as one accessed
+	// using the [aalyria.com/spacetime/auth/pkcs11] package, so that it
+	// never has to be written to disk. The key must be an RSA key.
+	Signer       crypto.Signer
 	PrivateKeyID string
 	Email        string
-	Host         string
+	// Host is the audience of the JWTs, usually the host of the server
+	// they're sent to.
+	Host string
+	// Issuer is the "iss" claim of the JWTs. Defaults to Email.
+	Issuer string
+	// Scopes, if set, are sent space-separated in the "scope" claim of the
+	// JWTs.
+	Scopes []string
+	// Lifetime is how long each signed JWT is valid for. Defaults to an
+	// hour.
+	Lifetime time.Duration
 }
 
 // NewCredentials creates a [credentials.PerRPCCredentials] implementation that
 // can be used to authenticate outgoing gRPC requests with Spacetime services.
 func NewCredentials(ctx context.Context, c Config) (credentials.PerRPCCredentials, error) {
+	pkey, err := c.privateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		stSrc, proxySrc TokenSource
+		stErr, proxyErr error
+		wg              sync.WaitGroup
+	)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		stSrc, stErr = newSpacetimeTokenSource(ctx, c, pkey)
+	}()
+	go func() {
+		defer wg.Done()
+		proxySrc, proxyErr = newProxyTokenSource(ctx, c, pkey)
+	}()
+	wg.Wait()
+
+	return authCredentials{spacetimeTokenSrc: stSrc, proxyTokenSrc: proxySrc}, errors.Join(stErr, proxyErr)
+}
+
+// NewSpacetimeTokenSource creates a [TokenSource] of the signed JWTs that
+// authenticate requests with Spacetime services, without the
+// proxy-authorization token that [NewCredentials] also obtains. Minting these
+// tokens doesn't require any network access, so it can be used by clients
+// that don't use gRPC, or that send requests through their own transport,
+// such as browser-based tools built for js/wasm.
+func NewSpacetimeTokenSource(ctx context.Context, c Config) (TokenSource, error) {
+	pkey, err := c.privateKey()
+	if err != nil {
+		return nil, err
+	}
+	return newSpacetimeTokenSource(ctx, c, pkey)
+}
+
+// privateKey validates the config and parses its private key, or returns its
+// Signer if one is set.
+func (c Config) privateKey() (any, error) {
 	errs := []error{}
 	switch {
 	case c.Clock == nil:
@@ -117,7 +227,7 @@ func NewCredentials(ctx context.Context, c Config) (credentials.PerRPCCredential
 		errs = append(errs, errors.New("missing required field 'Email'"))
 	case c.PrivateKeyID == "":
 		errs = append(errs, errors.New("missing required field 'PrivateKeyID'"))
-	case c.PrivateKey == nil:
+	case c.PrivateKey == nil && c.Signer == nil:
 		errs = append(errs, errors.New("missing required field 'PrivateKey'"))
 	case c.Host == "":
 		errs = append(errs, errors.New("missing required field 'Host'"))
@@ -126,6 +236,13 @@ func NewCredentials(ctx context.Context, c Config) (credentials.PerRPCCredential
 		return nil, errors.Join(errs...)
 	}
 
+	if c.Signer != nil {
+		if err := validateSigner(c.Signer); err != nil {
+			return nil, err
+		}
+		return c.Signer, nil
+	}
+
 	pkeyBytes, err := io.ReadAll(c.PrivateKey)
 	if err != nil {
 		return nil, fmt.Errorf("getting private key bytes: %w", err)
@@ -137,38 +254,21 @@ func NewCredentials(ctx context.Context, c Config) (credentials.PerRPCCredential
 	if pkeyBlock == nil {
 		return nil, errors.New("PrivateKey not PEM-encoded")
 	}
-	pkey, err := parsePrivateKey(pkeyBlock.Bytes)
-	if err != nil {
-		return nil, err
-	}
-
-	var (
-		stSrc, proxySrc func(context.Context) (string, error)
-		stErr, proxyErr error
-		wg              sync.WaitGroup
-	)
-	wg.Add(2)
-	go func() {
-		defer wg.Done()
-		stSrc, stErr = newSpacetimeTokenSource(ctx, c, pkey)
-	}()
-	go func() {
-		defer wg.Done()
-		proxySrc, proxyErr = newProxyTokenSource(ctx, c, pkey)
-	}()
-	wg.Wait()
-
-	return authCredentials{spacetimeTokenSrc: stSrc, proxyTokenSrc: proxySrc}, errors.Join(stErr, proxyErr)
+	return parsePrivateKey(pkeyBlock.Bytes)
 }
 
-func newSpacetimeTokenSource(ctx context.Context, c Config, pkey any) (func(context.Context) (string, error), error) {
-	return reuseToken(ctx, c, pkey, func(context.Context) (*expiringToken, error) {
+func newSpacetimeTokenSource(ctx context.Context, c Config, pkey any) (TokenSource, error) {
+	src, err := reuseToken(ctx, c.Clock, func(context.Context) (*expiringToken, error) {
 		return generateNewJWT(c, pkey, spacetimeSigningMethod, nil)
 	})
+	if err != nil {
+		return nil, err
+	}
+	return TokenSourceFunc(src), nil
 }
 
-func newProxyTokenSource(ctx context.Context, c Config, pkey any) (func(context.Context) (string, error), error) {
-	return reuseToken(ctx, c, pkey, func(ctx context.Context) (*expiringToken, error) {
+func newProxyTokenSource(ctx context.Context, c Config, pkey any) (TokenSource, error) {
+	src, err := reuseToken(ctx, c.Clock, func(ctx context.Context) (*expiringToken, error) {
 		toExchange, err := generateNewJWT(c, pkey, proxySigningMethod, map[string]any{
 			"aud":             GoogleOIDCURL,
 			"target_audience": proxyAudience,
@@ -209,9 +309,13 @@ func newProxyTokenSource(ctx context.Context, c Config, pkey any) (func(context.
 		}
 		return &expiringToken{tok: r.IDToken, expiresAt: toExchange.expiresAt}, nil
 	})
+	if err != nil {
+		return nil, err
+	}
+	return TokenSourceFunc(src), nil
 }
 
-func reuseToken(ctx context.Context, c Config, pkey any, genToken func(context.Context) (*expiringToken, error)) (func(context.Context) (string, error), error) {
+func reuseToken(ctx context.Context, clock clockwork.Clock, genToken func(context.Context) (*expiringToken, error)) (func(context.Context) (string, error), error) {
 	freshToken, err := genToken(ctx)
 	if err != nil {
 		return nil, err
@@ -222,7 +326,7 @@ func reuseToken(ctx context.Context, c Config, pkey any, genToken func(context.C
 		mu.Lock()
 		defer mu.Unlock()
 
-		if freshToken.isStale(c.Clock) {
+		if freshToken.isStale(clock) {
 			ft, err := genToken(ctx)
 			if err != nil {
 				return "", err
@@ -267,7 +371,7 @@ type expiringToken struct {
 
 func generateNewJWT(c Config, pkey any, signingMethod jwt.SigningMethod, extraClaims map[string]any) (*expiringToken, error) {
 	now := c.Clock.Now()
-	expiresAt := now.Add(tokenLifetime)
+	expiresAt := now.Add(cmp.Or(c.Lifetime, tokenLifetime))
 
 	claims := jwt.MapClaims{
 		// AUDience
@@ -275,7 +379,7 @@ func generateNewJWT(c Config, pkey any, signingMethod jwt.SigningMethod, extraCl
 		// Key ID
 		"kid": c.PrivateKeyID,
 		// ISSuer
-		"iss": c.Email,
+		"iss": cmp.Or(c.Issuer, c.Email),
 		// SUBject
 		"sub": c.Email,
 		// EXPires at
@@ -283,8 +387,17 @@ func generateNewJWT(c Config, pkey any, signingMethod jwt.SigningMethod, extraCl
 		// Issued AT
 		"iat": jwt.NewNumericDate(now),
 	}
+	if len(c.Scopes) > 0 {
+		claims["scope"] = strings.Join(c.Scopes, " ")
+	}
 	maps.Insert(claims, maps.All(extraClaims))
 
+	if c.Signer != nil {
+		if rsaMethod, ok := signingMethod.(*jwt.SigningMethodRSA); ok {
+			signingMethod = signerMethod{rsaMethod: rsaMethod}
+		}
+	}
+
 	token, err := jwt.NewWithClaims(signingMethod, claims).SignedString(pkey)
 	if err != nil {
 		return nil, fmt.Errorf("signing auth jwt: %w", err)
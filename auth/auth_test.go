@@ -27,6 +27,7 @@ import (
 	"time"
 
 	"aalyria.com/spacetime/auth/authtest"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/jonboulle/clockwork"
 )
 
@@ -159,6 +160,108 @@ func TestNewCredentials(t *testing.T) {
 	// which can't be used outside the grpc packages.
 }
 
+func TestNewSpacetimeTokenSource(t *testing.T) {
+	t.Parallel()
+
+	conf := Config{
+		Email:        "some@example.com",
+		PrivateKey:   bytes.NewBuffer(testKey.privatePEM),
+		PrivateKeyID: "1",
+		Clock:        clockwork.NewFakeClockAt(time.Date(2011, time.February, 16, 0, 0, 0, 0, time.UTC)),
+		Host:         "example.com",
+	}
+
+	src, err := NewSpacetimeTokenSource(context.Background(), conf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tok, err := src.Token(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	claims := jwt.MapClaims{}
+	parsed, err := jwt.ParseWithClaims(tok, claims, func(*jwt.Token) (any, error) {
+		return &testKey.privateKey.PublicKey, nil
+	}, jwt.WithoutClaimsValidation(), jwt.WithValidMethods([]string{"RS384"}))
+	if err != nil {
+		t.Fatalf("unable to verify token: %v", err)
+	}
+	if !parsed.Valid {
+		t.Errorf("expected token to be valid")
+	}
+	for k, want := range map[string]string{"aud": "example.com", "kid": "1", "iss": "some@example.com", "sub": "some@example.com"} {
+		if got := claims[k]; got != want {
+			t.Errorf("unexpected %q claim: got %v, but expected %q", k, got, want)
+		}
+	}
+}
+
+func TestNewSpacetimeTokenSource_lifetime(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2011, time.February, 16, 0, 0, 0, 0, time.UTC)
+	src, err := NewSpacetimeTokenSource(context.Background(), Config{
+		Email:        "some@example.com",
+		PrivateKey:   bytes.NewBuffer(testKey.privatePEM),
+		PrivateKeyID: "1",
+		Clock:        clockwork.NewFakeClockAt(now),
+		Host:         "example.com",
+		Lifetime:     10 * time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tok, err := src.Token(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	claims := jwt.RegisteredClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(tok, &claims); err != nil {
+		t.Fatalf("unable to parse token: %v", err)
+	}
+	if want := now.Add(10 * time.Minute); !claims.ExpiresAt.Time.Equal(want) {
+		t.Errorf("token expires at %v, want %v", claims.ExpiresAt.Time, want)
+	}
+}
+
+func TestNewSpacetimeTokenSource_issuerAndScopes(t *testing.T) {
+	t.Parallel()
+
+	src, err := NewSpacetimeTokenSource(context.Background(), Config{
+		Email:        "some@example.com",
+		PrivateKey:   bytes.NewBuffer(testKey.privatePEM),
+		PrivateKeyID: "1",
+		Clock:        clockwork.NewRealClock(),
+		Host:         "staging.example.com",
+		Issuer:       "https://idp.example.com",
+		Scopes:       []string{"nbi.read", "nbi.write"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tok, err := src.Token(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(tok, claims); err != nil {
+		t.Fatalf("unable to parse token: %v", err)
+	}
+	for k, want := range map[string]string{
+		"aud":   "staging.example.com",
+		"iss":   "https://idp.example.com",
+		"sub":   "some@example.com",
+		"scope": "nbi.read nbi.write",
+	} {
+		if got := claims[k]; got != want {
+			t.Errorf("unexpected %q claim: got %v, but expected %q", k, got, want)
+		}
+	}
+}
+
 type rsaKeyForTesting struct {
 	privateKey *rsa.PrivateKey
 	privatePEM []byte
@@ -0,0 +1,135 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package awskms provides a [crypto.Signer] backed by an asymmetric RSA key
+// held in AWS Key Management Service. The signer can be used as the
+// [aalyria.com/spacetime/auth.Config.Signer] so that JWTs are signed without
+// the private key ever leaving KMS.
+package awskms // import "aalyria.com/spacetime/auth/awskms"
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+// Client is the subset of the [kms.Client] API used by the [Signer].
+type Client interface {
+	GetPublicKey(context.Context, *kms.GetPublicKeyInput, ...func(*kms.Options)) (*kms.GetPublicKeyOutput, error)
+	Sign(context.Context, *kms.SignInput, ...func(*kms.Options)) (*kms.SignOutput, error)
+}
+
+// Config identifies the KMS key to sign with.
+type Config struct {
+	// KeyID is the ID, ARN, alias name or alias ARN of the key.
+	KeyID string
+	// Region overrides the region discovered from the environment, shared
+	// config files or instance metadata. It isn't needed if KeyID is an ARN
+	// in the discovered region.
+	Region string
+	// Client is used to call KMS. Defaults to a client using credentials
+	// from the default AWS credential chain, such as the instance role of
+	// the VM the agent runs on.
+	Client Client
+}
+
+// Signer is a [crypto.Signer] that signs using a key held in AWS KMS.
+type Signer struct {
+	ctx    context.Context
+	client Client
+	keyID  string
+	pub    *rsa.PublicKey
+}
+
+// NewSigner fetches the public key of the configured KMS key. `ctx` is used
+// for that request and for every signing request made by the returned
+// signer, since [crypto.Signer.Sign] doesn't accept one.
+func NewSigner(ctx context.Context, c Config) (*Signer, error) {
+	if c.KeyID == "" {
+		return nil, errors.New("missing required field 'KeyID'")
+	}
+
+	client := c.Client
+	if client == nil {
+		opts := []func(*config.LoadOptions) error{}
+		if c.Region != "" {
+			opts = append(opts, config.WithRegion(c.Region))
+		}
+		awsConf, err := config.LoadDefaultConfig(ctx, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("loading AWS configuration: %w", err)
+		}
+		client = kms.NewFromConfig(awsConf)
+	}
+
+	resp, err := client.GetPublicKey(ctx, &kms.GetPublicKeyInput{KeyId: aws.String(c.KeyID)})
+	if err != nil {
+		return nil, fmt.Errorf("getting public key of %s: %w", c.KeyID, err)
+	}
+	if resp.KeyUsage != types.KeyUsageTypeSignVerify {
+		return nil, fmt.Errorf("key %s can't be used for signing (key usage is %s)", c.KeyID, resp.KeyUsage)
+	}
+	pub, err := x509.ParsePKIXPublicKey(resp.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("parsing public key of %s: %w", c.KeyID, err)
+	}
+	rsaPub, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("key %s isn't an RSA key (got %T)", c.KeyID, pub)
+	}
+
+	return &Signer{ctx: ctx, client: client, keyID: c.KeyID, pub: rsaPub}, nil
+}
+
+func (s *Signer) Public() crypto.PublicKey { return s.pub }
+
+// Sign signs `digest` using PKCS #1 v1.5 padding. PSS signatures aren't
+// supported.
+func (s *Signer) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if _, ok := opts.(*rsa.PSSOptions); ok {
+		return nil, errors.New("PSS signatures aren't supported")
+	}
+
+	var alg types.SigningAlgorithmSpec
+	switch opts.HashFunc() {
+	case crypto.SHA256:
+		alg = types.SigningAlgorithmSpecRsassaPkcs1V15Sha256
+	case crypto.SHA384:
+		alg = types.SigningAlgorithmSpecRsassaPkcs1V15Sha384
+	case crypto.SHA512:
+		alg = types.SigningAlgorithmSpecRsassaPkcs1V15Sha512
+	default:
+		return nil, fmt.Errorf("unsupported hash function %v", opts.HashFunc())
+	}
+
+	resp, err := s.client.Sign(s.ctx, &kms.SignInput{
+		KeyId:            aws.String(s.keyID),
+		Message:          digest,
+		MessageType:      types.MessageTypeDigest,
+		SigningAlgorithm: alg,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("signing with %s: %w", s.keyID, err)
+	}
+	return resp.Signature, nil
+}
@@ -0,0 +1,97 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package awskms
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha512"
+	"crypto/x509"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+type fakeClient struct {
+	key   *rsa.PrivateKey
+	usage types.KeyUsageType
+}
+
+func (f fakeClient) GetPublicKey(_ context.Context, in *kms.GetPublicKeyInput, _ ...func(*kms.Options)) (*kms.GetPublicKeyOutput, error) {
+	der, err := x509.MarshalPKIXPublicKey(&f.key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &kms.GetPublicKeyOutput{KeyId: in.KeyId, KeyUsage: f.usage, PublicKey: der}, nil
+}
+
+func (f fakeClient) Sign(_ context.Context, in *kms.SignInput, _ ...func(*kms.Options)) (*kms.SignOutput, error) {
+	if in.MessageType != types.MessageTypeDigest {
+		return nil, fmt.Errorf("unexpected message type %s", in.MessageType)
+	}
+	hash := map[types.SigningAlgorithmSpec]crypto.Hash{
+		types.SigningAlgorithmSpecRsassaPkcs1V15Sha256: crypto.SHA256,
+		types.SigningAlgorithmSpecRsassaPkcs1V15Sha384: crypto.SHA384,
+		types.SigningAlgorithmSpecRsassaPkcs1V15Sha512: crypto.SHA512,
+	}[in.SigningAlgorithm]
+	sig, err := rsa.SignPKCS1v15(rand.Reader, f.key, hash, in.Message)
+	if err != nil {
+		return nil, err
+	}
+	return &kms.SignOutput{Signature: sig}, nil
+}
+
+func TestSigner(t *testing.T) {
+	t.Parallel()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	signer, err := NewSigner(ctx, Config{KeyID: "alias/spacetime", Client: fakeClient{key: key, usage: types.KeyUsageTypeSignVerify}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	digest := sha512.Sum384([]byte("header.payload"))
+	sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA384)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := rsa.VerifyPKCS1v15(signer.Public().(*rsa.PublicKey), crypto.SHA384, digest[:], sig); err != nil {
+		t.Errorf("signature doesn't verify: %v", err)
+	}
+
+	if _, err := signer.Sign(rand.Reader, digest[:], &rsa.PSSOptions{Hash: crypto.SHA384}); err == nil {
+		t.Errorf("expected PSS signatures to be rejected")
+	}
+}
+
+func TestNewSigner_encryptionKey(t *testing.T) {
+	t.Parallel()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewSigner(context.Background(), Config{KeyID: "alias/spacetime", Client: fakeClient{key: key, usage: types.KeyUsageTypeEncryptDecrypt}}); err == nil {
+		t.Errorf("expected an error for a key that can't sign")
+	}
+}
@@ -19,5 +19,20 @@
 // used with the [google.golang.org/grpc.WithPerRPCCredentials] dial option to
 // authenticate RPCs. See the [auth documentation] for more information.
 //
+// Environments that can't use private-key JWT signing can instead use one of
+// the alternative [TokenSource] implementations, such as
+// [NewClientCredentialsTokenSource], [NewImpersonationTokenSource], or
+// [NewEnvTokenSource], and wrap it with [NewTokenSourceCredentials].
+//
+// Private keys that mustn't be written to disk can instead be held by an HSM,
+// smartcard, cloud KMS or HashiCorp Vault and provided as the
+// [Config.Signer], for example using the [aalyria.com/spacetime/auth/pkcs11]
+// or [aalyria.com/spacetime/auth/vault] packages.
+//
+// Clients that don't use gRPC, including those built for js/wasm, can use
+// [NewSpacetimeTokenSource] to mint tokens for their own transport. Plain
+// HTTP clients can instead wrap their transport with [NewRoundTripper] to
+// send the same headers as the gRPC credentials.
+//
 // [auth documentation]: https://docs.spacetime.aalyria.com/authentication
 package auth
@@ -0,0 +1,167 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package gcpkms provides a [crypto.Signer] backed by an asymmetric RSA key
+// held in Google Cloud KMS. The signer can be used as the
+// [aalyria.com/spacetime/auth.Config.Signer] so that JWTs are signed without
+// the private key ever leaving KMS.
+//
+// Spacetime tokens are signed using SHA-384, which Cloud KMS only supports
+// for keys with one of the RSA_SIGN_RAW_PKCS1_* algorithms.
+package gcpkms // import "aalyria.com/spacetime/auth/gcpkms"
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	kms "cloud.google.com/go/kms/apiv1"
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	"github.com/googleapis/gax-go/v2"
+)
+
+// Client is the subset of the [kms.KeyManagementClient] API used by the
+// [Signer].
+type Client interface {
+	GetPublicKey(context.Context, *kmspb.GetPublicKeyRequest, ...gax.CallOption) (*kmspb.PublicKey, error)
+	AsymmetricSign(context.Context, *kmspb.AsymmetricSignRequest, ...gax.CallOption) (*kmspb.AsymmetricSignResponse, error)
+}
+
+// Config identifies the KMS key version to sign with.
+type Config struct {
+	// KeyVersionName is the resource name of the key version, e.g.
+	// projects/P/locations/L/keyRings/R/cryptoKeys/K/cryptoKeyVersions/1.
+	KeyVersionName string
+	// Client is used to call KMS. Defaults to a client using Application
+	// Default Credentials, such as the service account of the VM the agent
+	// runs on.
+	Client Client
+}
+
+// Signer is a [crypto.Signer] that signs using a key held in Cloud KMS.
+type Signer struct {
+	ctx    context.Context
+	client Client
+	// closer closes the client if it was created by NewSigner.
+	closer io.Closer
+	name   string
+	alg    kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm
+	pub    *rsa.PublicKey
+}
+
+// DigestInfo prefixes of the hash functions Spacetime tokens are signed with,
+// from RFC 8017 section 9.2. Keys using the raw PKCS #1 algorithms expect the
+// caller to provide the encoded DigestInfo rather than the bare digest.
+var digestInfoPrefixes = map[crypto.Hash][]byte{
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA384: {0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+// NewSigner fetches the public key of the configured key version. `ctx` is
+// used to create the client and for every signing request made by the
+// returned signer, since [crypto.Signer.Sign] doesn't accept one.
+func NewSigner(ctx context.Context, c Config) (*Signer, error) {
+	if c.KeyVersionName == "" {
+		return nil, errors.New("missing required field 'KeyVersionName'")
+	}
+
+	s := &Signer{ctx: ctx, client: c.Client, name: c.KeyVersionName}
+	if s.client == nil {
+		client, err := kms.NewKeyManagementClient(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("creating Cloud KMS client: %w", err)
+		}
+		s.client, s.closer = client, client
+	}
+
+	pub, err := s.client.GetPublicKey(ctx, &kmspb.GetPublicKeyRequest{Name: c.KeyVersionName})
+	if err != nil {
+		s.Close()
+		return nil, fmt.Errorf("getting public key of %s: %w", c.KeyVersionName, err)
+	}
+	if err := s.setPublicKey(pub); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Signer) setPublicKey(pub *kmspb.PublicKey) error {
+	if !strings.HasPrefix(pub.GetAlgorithm().String(), "RSA_SIGN_PKCS1_") && !strings.HasPrefix(pub.GetAlgorithm().String(), "RSA_SIGN_RAW_PKCS1_") {
+		return fmt.Errorf("key %s uses unsupported algorithm %s", s.name, pub.GetAlgorithm())
+	}
+	block, _ := pem.Decode([]byte(pub.GetPem()))
+	if block == nil {
+		return fmt.Errorf("public key of %s isn't PEM-encoded", s.name)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("parsing public key of %s: %w", s.name, err)
+	}
+	rsaPub, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("key %s isn't an RSA key (got %T)", s.name, key)
+	}
+	s.alg, s.pub = pub.GetAlgorithm(), rsaPub
+	return nil
+}
+
+func (s *Signer) Public() crypto.PublicKey { return s.pub }
+
+// Sign signs `digest` using PKCS #1 v1.5 padding. Keys that aren't raw
+// PKCS #1 keys can only sign digests of the hash function of their
+// algorithm.
+func (s *Signer) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if _, ok := opts.(*rsa.PSSOptions); ok {
+		return nil, errors.New("PSS signatures aren't supported")
+	}
+
+	req := &kmspb.AsymmetricSignRequest{Name: s.name}
+	alg := s.alg.String()
+	switch hash := opts.HashFunc(); {
+	case strings.HasPrefix(alg, "RSA_SIGN_RAW_PKCS1_"):
+		prefix, ok := digestInfoPrefixes[hash]
+		if !ok {
+			return nil, fmt.Errorf("unsupported hash function %v", hash)
+		}
+		req.Data = append(append([]byte{}, prefix...), digest...)
+	case hash == crypto.SHA256 && strings.HasSuffix(alg, "_SHA256"):
+		req.Digest = &kmspb.Digest{Digest: &kmspb.Digest_Sha256{Sha256: digest}}
+	case hash == crypto.SHA512 && strings.HasSuffix(alg, "_SHA512"):
+		req.Digest = &kmspb.Digest{Digest: &kmspb.Digest_Sha512{Sha512: digest}}
+	default:
+		return nil, fmt.Errorf("key %s with algorithm %s can't sign %v digests; use an RSA_SIGN_RAW_PKCS1_* key", s.name, alg, hash)
+	}
+
+	resp, err := s.client.AsymmetricSign(s.ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("signing with %s: %w", s.name, err)
+	}
+	return resp.GetSignature(), nil
+}
+
+// Close closes the KMS client if it was created by [NewSigner].
+func (s *Signer) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
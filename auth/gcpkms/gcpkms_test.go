@@ -0,0 +1,145 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gcpkms
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	"github.com/googleapis/gax-go/v2"
+)
+
+type fakeClient struct {
+	key *rsa.PrivateKey
+	alg kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm
+}
+
+func (f fakeClient) GetPublicKey(_ context.Context, req *kmspb.GetPublicKeyRequest, _ ...gax.CallOption) (*kmspb.PublicKey, error) {
+	der, err := x509.MarshalPKIXPublicKey(&f.key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &kmspb.PublicKey{
+		Name:      req.GetName(),
+		Algorithm: f.alg,
+		Pem:       string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+	}, nil
+}
+
+func (f fakeClient) AsymmetricSign(_ context.Context, req *kmspb.AsymmetricSignRequest, _ ...gax.CallOption) (*kmspb.AsymmetricSignResponse, error) {
+	var (
+		sig []byte
+		err error
+	)
+	switch {
+	case req.GetData() != nil:
+		// Raw PKCS #1 keys pad the DigestInfo they're given as-is.
+		sig, err = rsa.SignPKCS1v15(rand.Reader, f.key, 0, req.GetData())
+	case req.GetDigest().GetSha256() != nil:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, f.key, crypto.SHA256, req.GetDigest().GetSha256())
+	default:
+		err = errors.New("unexpected request")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &kmspb.AsymmetricSignResponse{Name: req.GetName(), Signature: sig}, nil
+}
+
+const keyName = "projects/p/locations/global/keyRings/r/cryptoKeys/k/cryptoKeyVersions/1"
+
+func TestSigner(t *testing.T) {
+	t.Parallel()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sha256Digest := sha256.Sum256([]byte("header.payload"))
+	sha384Digest := sha512.Sum384([]byte("header.payload"))
+
+	for _, tc := range []struct {
+		name    string
+		alg     kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm
+		hash    crypto.Hash
+		digest  []byte
+		wantErr bool
+	}{
+		{
+			name:   "raw key with SHA-384",
+			alg:    kmspb.CryptoKeyVersion_RSA_SIGN_RAW_PKCS1_2048,
+			hash:   crypto.SHA384,
+			digest: sha384Digest[:],
+		},
+		{
+			name:   "SHA-256 key with SHA-256",
+			alg:    kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_2048_SHA256,
+			hash:   crypto.SHA256,
+			digest: sha256Digest[:],
+		},
+		{
+			name:    "SHA-256 key with SHA-384",
+			alg:     kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_2048_SHA256,
+			hash:    crypto.SHA384,
+			digest:  sha384Digest[:],
+			wantErr: true,
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			signer, err := NewSigner(context.Background(), Config{KeyVersionName: keyName, Client: fakeClient{key: key, alg: tc.alg}})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer signer.Close()
+
+			sig, err := signer.Sign(rand.Reader, tc.digest, tc.hash)
+			switch {
+			case tc.wantErr && err == nil:
+				t.Fatal("expected an error, got none")
+			case tc.wantErr:
+				return
+			case err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := rsa.VerifyPKCS1v15(signer.Public().(*rsa.PublicKey), tc.hash, tc.digest, sig); err != nil {
+				t.Errorf("signature doesn't verify: %v", err)
+			}
+		})
+	}
+}
+
+func TestNewSigner_unsupportedAlgorithm(t *testing.T) {
+	t.Parallel()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewSigner(context.Background(), Config{KeyVersionName: keyName, Client: fakeClient{key: key, alg: kmspb.CryptoKeyVersion_RSA_SIGN_PSS_2048_SHA256}}); err == nil {
+		t.Errorf("expected an error for a PSS key")
+	}
+}
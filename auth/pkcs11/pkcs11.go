@@ -0,0 +1,124 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pkcs11 provides a [crypto.Signer] backed by a private key held in a
+// PKCS #11 token, such as an HSM or smartcard. The signer can be used as the
+// [aalyria.com/spacetime/auth.Config.Signer] so that JWTs are signed without
+// the private key ever being written to disk.
+package pkcs11 // import "aalyria.com/spacetime/auth/pkcs11"
+
+import (
+	"crypto"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/ThalesIgnite/crypto11"
+)
+
+// DefaultPINEnvVar is the environment variable the token's PIN is read from
+// if [Config.PINEnvVar] isn't set.
+const DefaultPINEnvVar = "PKCS11_PIN"
+
+// Config identifies the token and key to sign with.
+type Config struct {
+	// ModulePath is the path to the PKCS #11 module (shared library) provided
+	// by the token's vendor, e.g. /usr/lib/softhsm/libsofthsm2.so.
+	ModulePath string
+	// Exactly one of Slot and TokenLabel selects the token.
+	Slot       *int
+	TokenLabel string
+	// At least one of KeyLabel and KeyID selects the key pair. KeyID is
+	// hex-encoded, as printed by tools like pkcs11-tool.
+	KeyLabel string
+	KeyID    string
+	// PINEnvVar is the environment variable that holds the user PIN of the
+	// token. The PIN is never stored in configuration files. Defaults to
+	// [DefaultPINEnvVar].
+	PINEnvVar string
+}
+
+// Signer is a [crypto.Signer] that signs using a key held in a PKCS #11
+// token. It must be closed once it's no longer needed to release the session
+// with the token.
+type Signer struct {
+	crypto.Signer
+	ctx *crypto11.Context
+}
+
+// NewSigner logs into the configured token and finds the key pair to sign
+// with.
+func NewSigner(c Config) (*Signer, error) {
+	errs := []error{}
+	switch {
+	case c.ModulePath == "":
+		errs = append(errs, errors.New("missing required field 'ModulePath'"))
+	case c.Slot == nil && c.TokenLabel == "":
+		errs = append(errs, errors.New("one of 'Slot' or 'TokenLabel' is required"))
+	case c.Slot != nil && c.TokenLabel != "":
+		errs = append(errs, errors.New("only one of 'Slot' or 'TokenLabel' may be set"))
+	case c.KeyLabel == "" && c.KeyID == "":
+		errs = append(errs, errors.New("one of 'KeyLabel' or 'KeyID' is required"))
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	var keyID []byte
+	if c.KeyID != "" {
+		id, err := hex.DecodeString(c.KeyID)
+		if err != nil {
+			return nil, fmt.Errorf("decoding KeyID %q: %w", c.KeyID, err)
+		}
+		keyID = id
+	}
+	var keyLabel []byte
+	if c.KeyLabel != "" {
+		keyLabel = []byte(c.KeyLabel)
+	}
+
+	pinEnvVar := c.PINEnvVar
+	if pinEnvVar == "" {
+		pinEnvVar = DefaultPINEnvVar
+	}
+	pin, ok := os.LookupEnv(pinEnvVar)
+	if !ok {
+		return nil, fmt.Errorf("environment variable %s is not set", pinEnvVar)
+	}
+
+	ctx, err := crypto11.Configure(&crypto11.Config{
+		Path:       c.ModulePath,
+		SlotNumber: c.Slot,
+		TokenLabel: c.TokenLabel,
+		Pin:        pin,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("opening PKCS #11 token: %w", err)
+	}
+
+	signer, err := ctx.FindKeyPair(keyID, keyLabel)
+	switch {
+	case err != nil:
+		ctx.Close()
+		return nil, fmt.Errorf("finding key pair: %w", err)
+	case signer == nil:
+		ctx.Close()
+		return nil, fmt.Errorf("no key pair with label %q and ID %q found in token", c.KeyLabel, c.KeyID)
+	}
+	return &Signer{Signer: signer, ctx: ctx}, nil
+}
+
+// Close logs out of the token and releases its resources.
+func (s *Signer) Close() error { return s.ctx.Close() }
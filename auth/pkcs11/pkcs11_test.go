@@ -0,0 +1,68 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkcs11
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewSigner_validation(t *testing.T) {
+	slot := 0
+
+	for _, tc := range []struct {
+		name string
+		want string
+		c    Config
+	}{
+		{
+			name: "missing module path",
+			want: "missing required field 'ModulePath'",
+			c:    Config{Slot: &slot, KeyLabel: "spacetime"},
+		},
+		{
+			name: "missing token",
+			want: "one of 'Slot' or 'TokenLabel' is required",
+			c:    Config{ModulePath: "/usr/lib/libpkcs11.so", KeyLabel: "spacetime"},
+		},
+		{
+			name: "slot and token label",
+			want: "only one of 'Slot' or 'TokenLabel' may be set",
+			c:    Config{ModulePath: "/usr/lib/libpkcs11.so", Slot: &slot, TokenLabel: "token", KeyLabel: "spacetime"},
+		},
+		{
+			name: "missing key",
+			want: "one of 'KeyLabel' or 'KeyID' is required",
+			c:    Config{ModulePath: "/usr/lib/libpkcs11.so", Slot: &slot},
+		},
+		{
+			name: "bad key ID",
+			want: `decoding KeyID "xyz"`,
+			c:    Config{ModulePath: "/usr/lib/libpkcs11.so", Slot: &slot, KeyID: "xyz"},
+		},
+		{
+			name: "missing PIN",
+			want: "environment variable PKCS11_TEST_PIN is not set",
+			c:    Config{ModulePath: "/usr/lib/libpkcs11.so", Slot: &slot, KeyLabel: "spacetime", PINEnvVar: "PKCS11_TEST_PIN"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewSigner(tc.c)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("unexpected validation error: got %v, but expected %q", err, tc.want)
+			}
+		})
+	}
+}
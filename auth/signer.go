@@ -0,0 +1,65 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// signerMethod is a [jwt.SigningMethod] that delegates signing to a
+// [crypto.Signer], so that the private key can be held by an HSM or smartcard
+// rather than loaded into memory. The jwt package's RSA methods require an
+// [*rsa.PrivateKey], which such keys can't provide.
+type signerMethod struct {
+	// rsaMethod is used to verify signatures, which only requires the public
+	// key.
+	rsaMethod *jwt.SigningMethodRSA
+}
+
+func (m signerMethod) Alg() string { return m.rsaMethod.Alg() }
+
+func (m signerMethod) Sign(signingString string, key any) ([]byte, error) {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("expected a crypto.Signer, got %T", key)
+	}
+	if !m.rsaMethod.Hash.Available() {
+		return nil, jwt.ErrHashUnavailable
+	}
+
+	hasher := m.rsaMethod.Hash.New()
+	hasher.Write([]byte(signingString))
+	// Passing a plain crypto.Hash as the options selects PKCS #1 v1.5
+	// signatures, as used by the RS* algorithms.
+	return signer.Sign(rand.Reader, hasher.Sum(nil), m.rsaMethod.Hash)
+}
+
+func (m signerMethod) Verify(signingString string, sig []byte, key any) error {
+	return m.rsaMethod.Verify(signingString, sig, key)
+}
+
+// validateSigner checks that `s` holds a key that can be used to sign
+// Spacetime JWTs.
+func validateSigner(s crypto.Signer) error {
+	if _, ok := s.Public().(*rsa.PublicKey); !ok {
+		return fmt.Errorf("Signer must hold an RSA key, got %T", s.Public())
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jonboulle/clockwork"
+)
+
+// opaqueSigner hides the type of the wrapped key, like a signer backed by an
+// HSM would, and counts how many signatures it's made.
+type opaqueSigner struct {
+	signer crypto.Signer
+	calls  *atomic.Int32
+}
+
+func (s opaqueSigner) Public() crypto.PublicKey { return s.signer.Public() }
+
+func (s opaqueSigner) Sign(r io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	s.calls.Add(1)
+	return s.signer.Sign(r, digest, opts)
+}
+
+func TestNewSpacetimeTokenSource_signer(t *testing.T) {
+	t.Parallel()
+
+	signer := opaqueSigner{signer: testKey.privateKey, calls: &atomic.Int32{}}
+	src, err := NewSpacetimeTokenSource(context.Background(), Config{
+		Email:        "some@example.com",
+		Signer:       signer,
+		PrivateKeyID: "1",
+		Clock:        clockwork.NewFakeClockAt(time.Date(2011, time.February, 16, 0, 0, 0, 0, time.UTC)),
+		Host:         "example.com",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tok, err := src.Token(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := jwt.Parse(tok, func(*jwt.Token) (any, error) {
+		return &testKey.privateKey.PublicKey, nil
+	}, jwt.WithoutClaimsValidation(), jwt.WithValidMethods([]string{"RS384"})); err != nil {
+		t.Fatalf("unable to verify token: %v", err)
+	}
+	if got := signer.calls.Load(); got != 1 {
+		t.Errorf("expected the signer to be called once, but got %d calls", got)
+	}
+}
+
+func TestNewSpacetimeTokenSource_nonRSASigner(t *testing.T) {
+	t.Parallel()
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewSpacetimeTokenSource(context.Background(), Config{
+		Email:        "some@example.com",
+		Signer:       ecKey,
+		PrivateKeyID: "1",
+		Clock:        clockwork.NewRealClock(),
+		Host:         "example.com",
+	})
+	if err == nil || !strings.Contains(err.Error(), "must hold an RSA key") {
+		t.Errorf("expected an error about the key type, got %v", err)
+	}
+}
@@ -0,0 +1,308 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jonboulle/clockwork"
+	"google.golang.org/grpc/credentials"
+)
+
+// DefaultIAMCredentialsURL is the base URL of the Google IAM Credentials API
+// used for service account impersonation.
+const DefaultIAMCredentialsURL = "https://iamcredentials.googleapis.com"
+
+// TokenSource supplies bearer tokens used to authenticate outgoing requests.
+// Implementations are expected to be safe for concurrent use and to handle
+// caching and refreshing of tokens themselves.
+type TokenSource interface {
+	Token(context.Context) (string, error)
+}
+
+// TokenSourceFunc is an adapter to allow the use of ordinary functions as
+// [TokenSource] implementations.
+type TokenSourceFunc func(context.Context) (string, error)
+
+func (f TokenSourceFunc) Token(ctx context.Context) (string, error) { return f(ctx) }
+
+// NewTokenSourceCredentials creates a [credentials.PerRPCCredentials]
+// implementation that sends the token provided by `spacetime` in the
+// "authorization" header. If `proxy` is non-nil, its token is sent in the
+// "proxy-authorization" header.
+func NewTokenSourceCredentials(spacetime, proxy TokenSource) credentials.PerRPCCredentials {
+	return authCredentials{spacetimeTokenSrc: spacetime, proxyTokenSrc: proxy}
+}
+
+// NewStaticTokenSource returns a [TokenSource] that always provides `token`.
+func NewStaticTokenSource(token string) TokenSource {
+	return TokenSourceFunc(func(context.Context) (string, error) {
+		if token == "" {
+			return "", errors.New("empty static token")
+		}
+		return token, nil
+	})
+}
+
+// NewEnvTokenSource returns a [TokenSource] that reads the token from the
+// named environment variable. The variable is consulted on every call, so
+// the token can be rotated by whatever process manages the environment.
+func NewEnvTokenSource(envVar string) TokenSource {
+	return TokenSourceFunc(func(context.Context) (string, error) {
+		tok, ok := os.LookupEnv(envVar)
+		switch {
+		case !ok:
+			return "", fmt.Errorf("environment variable %s is not set", envVar)
+		case tok == "":
+			return "", fmt.Errorf("environment variable %s is empty", envVar)
+		}
+		return tok, nil
+	})
+}
+
+// ClientCredentialsConfig configures a [TokenSource] that uses the OAuth 2.0
+// client credentials grant against an OIDC issuer.
+type ClientCredentialsConfig struct {
+	Client *http.Client
+	Clock  clockwork.Clock
+	// TokenURL is the issuer's token endpoint. If it's unset, it's
+	// discovered from the metadata of Issuer.
+	TokenURL string
+	// Issuer is the URL of the OIDC issuer, whose metadata is read from
+	// Issuer/.well-known/openid-configuration when TokenURL is unset.
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	// Audience is sent as the "audience" parameter, which some issuers
+	// require to select the API the token is minted for.
+	Audience string
+}
+
+// NewClientCredentialsTokenSource creates a [TokenSource] that exchanges the
+// configured client ID and secret for an access token, refreshing it once it
+// has expired.
+func NewClientCredentialsTokenSource(ctx context.Context, c ClientCredentialsConfig) (TokenSource, error) {
+	errs := []error{}
+	switch {
+	case c.Clock == nil:
+		errs = append(errs, errors.New("missing required field 'Clock'"))
+	case c.TokenURL == "" && c.Issuer == "":
+		errs = append(errs, errors.New("missing required field 'TokenURL' or 'Issuer'"))
+	case c.ClientID == "":
+		errs = append(errs, errors.New("missing required field 'ClientID'"))
+	case c.ClientSecret == "":
+		errs = append(errs, errors.New("missing required field 'ClientSecret'"))
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	if c.TokenURL == "" {
+		tokenURL, err := discoverTokenURL(ctx, c.Client, c.Issuer)
+		if err != nil {
+			return nil, err
+		}
+		c.TokenURL = tokenURL
+	}
+
+	src, err := reuseToken(ctx, c.Clock, func(ctx context.Context) (*expiringToken, error) {
+		params := url.Values{
+			"grant_type":    {"client_credentials"},
+			"client_id":     {c.ClientID},
+			"client_secret": {c.ClientSecret},
+		}
+		if len(c.Scopes) > 0 {
+			params.Set("scope", strings.Join(c.Scopes, " "))
+		}
+		if c.Audience != "" {
+			params.Set("audience", c.Audience)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, "POST", c.TokenURL, strings.NewReader(params.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", `application/x-www-form-urlencoded`)
+
+		type tokenResponse struct {
+			Error            string `json:"error"`
+			ErrorDescription string `json:"error_description"`
+			AccessToken      string `json:"access_token"`
+			ExpiresIn        int64  `json:"expires_in"`
+		}
+		r := tokenResponse{}
+		if err := doJSON(c.Client, req, &r); err != nil {
+			return nil, err
+		}
+		switch {
+		case r.Error != "":
+			return nil, fmt.Errorf("requesting client credentials token: %s: %s", r.Error, r.ErrorDescription)
+		case r.AccessToken == "":
+			return nil, errors.New("requesting client credentials token: response did not include an access_token")
+		}
+
+		lifetime := tokenLifetime
+		if r.ExpiresIn > 0 {
+			lifetime = time.Duration(r.ExpiresIn) * time.Second
+		}
+		return &expiringToken{tok: r.AccessToken, expiresAt: c.Clock.Now().Add(lifetime)}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return TokenSourceFunc(src), nil
+}
+
+// ImpersonationConfig configures a [TokenSource] that mints Google-signed ID
+// tokens on behalf of a service account using the IAM Credentials API.
+type ImpersonationConfig struct {
+	Client *http.Client
+	Clock  clockwork.Clock
+	// SourceToken provides the OAuth 2.0 access token of the caller, which
+	// must hold the "Service Account OpenID Connect Identity Token Creator"
+	// role on TargetServiceAccount.
+	SourceToken          TokenSource
+	TargetServiceAccount string
+	Audience             string
+	// IAMCredentialsURL overrides [DefaultIAMCredentialsURL].
+	IAMCredentialsURL string
+}
+
+// NewImpersonationTokenSource creates a [TokenSource] that provides ID tokens
+// for the configured service account, refreshing them once they've expired.
+func NewImpersonationTokenSource(ctx context.Context, c ImpersonationConfig) (TokenSource, error) {
+	errs := []error{}
+	switch {
+	case c.Clock == nil:
+		errs = append(errs, errors.New("missing required field 'Clock'"))
+	case c.SourceToken == nil:
+		errs = append(errs, errors.New("missing required field 'SourceToken'"))
+	case c.TargetServiceAccount == "":
+		errs = append(errs, errors.New("missing required field 'TargetServiceAccount'"))
+	case c.Audience == "":
+		errs = append(errs, errors.New("missing required field 'Audience'"))
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	endpoint := fmt.Sprintf("%s/v1/projects/-/serviceAccounts/%s:generateIdToken",
+		strings.TrimSuffix(cmp.Or(c.IAMCredentialsURL, DefaultIAMCredentialsURL), "/"),
+		url.PathEscape(c.TargetServiceAccount))
+
+	src, err := reuseToken(ctx, c.Clock, func(ctx context.Context) (*expiringToken, error) {
+		sourceToken, err := c.SourceToken.Token(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("getting source token: %w", err)
+		}
+
+		body, err := json.Marshal(map[string]any{
+			"audience":     c.Audience,
+			"includeEmail": true,
+		})
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+sourceToken)
+
+		type generateIDTokenResponse struct {
+			Error *struct {
+				Code    int    `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+			Token string `json:"token"`
+		}
+		r := generateIDTokenResponse{}
+		if err := doJSON(c.Client, req, &r); err != nil {
+			return nil, err
+		}
+		switch {
+		case r.Error != nil:
+			return nil, fmt.Errorf("impersonating %s: %d: %s", c.TargetServiceAccount, r.Error.Code, r.Error.Message)
+		case r.Token == "":
+			return nil, fmt.Errorf("impersonating %s: response did not include a token", c.TargetServiceAccount)
+		}
+
+		return &expiringToken{tok: r.Token, expiresAt: tokenExpiry(c.Clock, r.Token)}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return TokenSourceFunc(src), nil
+}
+
+// discoverTokenURL returns the token endpoint of the OIDC `issuer`, from
+// its provider metadata.
+func discoverTokenURL(ctx context.Context, client *http.Client, issuer string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return "", err
+	}
+	metadata := struct {
+		TokenEndpoint string `json:"token_endpoint"`
+	}{}
+	if err := doJSON(client, req, &metadata); err != nil {
+		return "", fmt.Errorf("discovering the token endpoint of %s: %w", issuer, err)
+	}
+	if metadata.TokenEndpoint == "" {
+		return "", fmt.Errorf("discovering the token endpoint of %s: the provider metadata has no token_endpoint", issuer)
+	}
+	return metadata.TokenEndpoint, nil
+}
+
+func doJSON(client *http.Client, req *http.Request, v any) error {
+	resp, err := cmp.Or(client, http.DefaultClient).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("decoding response from %s (status %s): %w", req.URL, resp.Status, err)
+	}
+	return nil
+}
+
+// tokenExpiry returns the expiration time encoded in the "exp" claim of the
+// provided JWT, falling back to the default token lifetime if it can't be
+// determined. The token's signature isn't checked since it's only ever
+// verified by the server we present it to.
+func tokenExpiry(clock clockwork.Clock, token string) time.Time {
+	claims := jwt.RegisteredClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token, &claims); err == nil && claims.ExpiresAt != nil {
+		return claims.ExpiresAt.Time
+	}
+	return clock.Now().Add(tokenLifetime)
+}
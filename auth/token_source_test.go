@@ -0,0 +1,243 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+)
+
+func TestNewClientCredentialsTokenSource(t *testing.T) {
+	t.Parallel()
+
+	numCalls := &atomic.Int64{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		numCalls.Add(1)
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if r.PostForm.Get("grant_type") != "client_credentials" ||
+			r.PostForm.Get("client_id") != "my-client" ||
+			r.PostForm.Get("client_secret") != "hunter2" {
+			json.NewEncoder(w).Encode(map[string]any{"error": "invalid_client", "error_description": "bad credentials"})
+			return
+		}
+		if got := r.PostForm.Get("scope"); got != "a b" {
+			t.Errorf("unexpected scope: got %q, want %q", got, "a b")
+		}
+		json.NewEncoder(w).Encode(map[string]any{"access_token": "tok", "expires_in": 60})
+	}))
+	defer srv.Close()
+
+	clock := clockwork.NewFakeClockAt(time.Date(2011, time.February, 16, 0, 0, 0, 0, time.UTC))
+	ts, err := NewClientCredentialsTokenSource(context.Background(), ClientCredentialsConfig{
+		Client:       srv.Client(),
+		Clock:        clock,
+		TokenURL:     srv.URL,
+		ClientID:     "my-client",
+		ClientSecret: "hunter2",
+		Scopes:       []string{"a", "b"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		tok, err := ts.Token(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if tok != "tok" {
+			t.Errorf("unexpected token: got %q, want %q", tok, "tok")
+		}
+	}
+	if nc := numCalls.Load(); nc != 1 {
+		t.Errorf("expected token endpoint to be called once, but got %d calls", nc)
+	}
+
+	clock.Advance(1 * time.Hour)
+	if _, err := ts.Token(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if nc := numCalls.Load(); nc != 2 {
+		t.Errorf("expected stale token to be refreshed, but got %d calls", nc)
+	}
+
+	_, err = NewClientCredentialsTokenSource(context.Background(), ClientCredentialsConfig{
+		Client:       srv.Client(),
+		Clock:        clock,
+		TokenURL:     srv.URL,
+		ClientID:     "my-client",
+		ClientSecret: "wrong",
+	})
+	want := "requesting client credentials token: invalid_client: bad credentials"
+	if got := cmp.Or(err, errors.New("")).Error(); got != want {
+		t.Errorf("unexpected error: got %q, but expected %q", got, want)
+	}
+}
+
+func TestNewClientCredentialsTokenSource_discovery(t *testing.T) {
+	t.Parallel()
+
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	mux.HandleFunc("/realms/staging/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"issuer": srv.URL + "/realms/staging", "token_endpoint": srv.URL + "/realms/staging/token"})
+	})
+	mux.HandleFunc("/realms/staging/token", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"access_token": "tok", "expires_in": 60})
+	})
+
+	ts, err := NewClientCredentialsTokenSource(context.Background(), ClientCredentialsConfig{
+		Client:       srv.Client(),
+		Clock:        clockwork.NewRealClock(),
+		Issuer:       srv.URL + "/realms/staging/",
+		ClientID:     "my-client",
+		ClientSecret: "hunter2",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tok, err := ts.Token(context.Background()); err != nil || tok != "tok" {
+		t.Errorf("unexpected token: got %q, %v, want %q", tok, err, "tok")
+	}
+}
+
+func TestNewClientCredentialsTokenSource_validation(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewClientCredentialsTokenSource(context.Background(), ClientCredentialsConfig{
+		Clock:    clockwork.NewRealClock(),
+		TokenURL: "https://example.com/token",
+		ClientID: "my-client",
+	})
+	want := "missing required field 'ClientSecret'"
+	if got := cmp.Or(err, errors.New("")).Error(); got != want {
+		t.Errorf("unexpected validation error: got %q, but expected %q", got, want)
+	}
+}
+
+func TestNewImpersonationTokenSource(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if want := "/v1/projects/-/serviceAccounts/sa@example.iam.gserviceaccount.com:generateIdToken"; r.URL.Path != want {
+			t.Errorf("unexpected path: got %q, want %q", r.URL.Path, want)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer source-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]any{"error": map[string]any{"code": 401, "message": "unauthenticated"}})
+			return
+		}
+		body := map[string]any{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if body["audience"] != "example.com" {
+			t.Errorf("unexpected audience: got %v, want %q", body["audience"], "example.com")
+		}
+		json.NewEncoder(w).Encode(map[string]any{"token": validToken})
+	}))
+	defer srv.Close()
+
+	conf := ImpersonationConfig{
+		Client:               srv.Client(),
+		Clock:                clockwork.NewFakeClockAt(time.Date(2011, time.February, 16, 0, 0, 0, 0, time.UTC)),
+		SourceToken:          NewStaticTokenSource("source-token"),
+		TargetServiceAccount: "sa@example.iam.gserviceaccount.com",
+		Audience:             "example.com",
+		IAMCredentialsURL:    srv.URL,
+	}
+	ts, err := NewImpersonationTokenSource(context.Background(), conf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tok, err := ts.Token(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tok != validToken {
+		t.Errorf("unexpected token: got %q, want %q", tok, validToken)
+	}
+
+	conf.SourceToken = NewStaticTokenSource("wrong")
+	_, err = NewImpersonationTokenSource(context.Background(), conf)
+	want := "impersonating sa@example.iam.gserviceaccount.com: 401: unauthenticated"
+	if got := cmp.Or(err, errors.New("")).Error(); got != want {
+		t.Errorf("unexpected error: got %q, but expected %q", got, want)
+	}
+}
+
+func TestNewEnvTokenSource(t *testing.T) {
+	t.Setenv("SPACETIME_TEST_TOKEN", "")
+	ts := NewEnvTokenSource("SPACETIME_TEST_TOKEN")
+
+	if _, err := ts.Token(context.Background()); err == nil {
+		t.Errorf("expected an error for an empty environment variable")
+	}
+
+	t.Setenv("SPACETIME_TEST_TOKEN", "tok")
+	tok, err := ts.Token(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tok != "tok" {
+		t.Errorf("unexpected token: got %q, want %q", tok, "tok")
+	}
+}
+
+func TestNewRoundTripper(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.Header.Get("Authorization"), "Bearer spacetime-tok"; got != want {
+			t.Errorf("unexpected authorization header: got %q, want %q", got, want)
+		}
+		if got, want := r.Header.Get("Proxy-Authorization"), "Bearer proxy-tok"; got != want {
+			t.Errorf("unexpected proxy-authorization header: got %q, want %q", got, want)
+		}
+	}))
+	defer srv.Close()
+
+	creds := NewTokenSourceCredentials(NewStaticTokenSource("spacetime-tok"), NewStaticTokenSource("proxy-tok"))
+	rt, err := NewRoundTripper(creds, srv.Client().Transport)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	client := &http.Client{Transport: rt}
+
+	rsp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rsp.Body.Close()
+
+	if _, err := client.Get(strings.Replace(srv.URL, "https://", "http://", 1)); err == nil {
+		t.Errorf("expected request over plain HTTP to fail, but it succeeded")
+	}
+}
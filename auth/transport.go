@@ -0,0 +1,151 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/spiffetls/tlsconfig"
+	"github.com/spiffe/go-spiffe/v2/workloadapi"
+	"google.golang.org/grpc/credentials"
+)
+
+// MutualTLSConfig configures TLS transport credentials that present a client
+// certificate and, optionally, verify the server against a custom CA bundle.
+type MutualTLSConfig struct {
+	// ClientCertFile and ClientKeyFile are paths to the PEM-encoded client
+	// certificate chain and its private key. Both must be set or both must be
+	// empty.
+	ClientCertFile string
+	ClientKeyFile  string
+	// CABundleFile is the path to a PEM-encoded bundle of CA certificates
+	// used to verify the server. If empty, the system certificate pool is
+	// used.
+	CABundleFile string
+	// ServerName overrides the name used to verify the server's certificate.
+	ServerName string
+}
+
+// NewMutualTLSCredentials creates [credentials.TransportCredentials] from the
+// provided [MutualTLSConfig].
+func NewMutualTLSCredentials(c MutualTLSConfig) (credentials.TransportCredentials, error) {
+	tlsConf, err := NewMutualTLSConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	return credentials.NewTLS(tlsConf), nil
+}
+
+// NewMutualTLSConfig creates a [tls.Config] from the provided
+// [MutualTLSConfig], for use with transports other than gRPC.
+func NewMutualTLSConfig(c MutualTLSConfig) (*tls.Config, error) {
+	if (c.ClientCertFile == "") != (c.ClientKeyFile == "") {
+		return nil, errors.New("the client certificate and private key must be provided together")
+	}
+
+	tlsConf := &tls.Config{ServerName: c.ServerName}
+	if c.ClientCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.ClientCertFile, c.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		tlsConf.Certificates = []tls.Certificate{cert}
+	}
+
+	if c.CABundleFile != "" {
+		pemBytes, err := os.ReadFile(c.CABundleFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pemBytes) {
+			return nil, fmt.Errorf("no PEM-encoded certificates found in CA bundle %s", c.CABundleFile)
+		}
+		tlsConf.RootCAs = pool
+	} else {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, fmt.Errorf("reading system tls cert pool: %w", err)
+		}
+		tlsConf.RootCAs = pool
+	}
+
+	return tlsConf, nil
+}
+
+// SPIFFEConfig configures mutual TLS transport credentials backed by an X.509
+// SVID obtained from the SPIFFE Workload API.
+type SPIFFEConfig struct {
+	// WorkloadAPIAddr is the address of the Workload API, such as
+	// "unix:///run/spire/agent.sock". If empty, the SPIFFE_ENDPOINT_SOCKET
+	// environment variable is used.
+	WorkloadAPIAddr string
+	// ServerID is the SPIFFE ID the server must present. If empty, any server
+	// in the same trust domain as the workload's own SVID is accepted.
+	ServerID string
+}
+
+// NewSPIFFECredentials connects to the SPIFFE Workload API and creates
+// [credentials.TransportCredentials] that present the workload's X.509 SVID
+// and verify the server using the trust bundles provided by the Workload
+// API. SVIDs and bundles are rotated automatically. The returned
+// [io.Closer] must be closed once the credentials are no longer needed.
+func NewSPIFFECredentials(ctx context.Context, c SPIFFEConfig) (credentials.TransportCredentials, io.Closer, error) {
+	tlsConf, closer, err := NewSPIFFETLSConfig(ctx, c)
+	if err != nil {
+		return nil, nil, err
+	}
+	return credentials.NewTLS(tlsConf), closer, nil
+}
+
+// NewSPIFFETLSConfig is like [NewSPIFFECredentials] but returns a
+// [tls.Config], for use with transports other than gRPC.
+func NewSPIFFETLSConfig(ctx context.Context, c SPIFFEConfig) (*tls.Config, io.Closer, error) {
+	clientOpts := []workloadapi.ClientOption{}
+	if c.WorkloadAPIAddr != "" {
+		clientOpts = append(clientOpts, workloadapi.WithAddr(c.WorkloadAPIAddr))
+	}
+
+	source, err := workloadapi.NewX509Source(ctx, workloadapi.WithClientOptions(clientOpts...))
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating X.509 source from the SPIFFE Workload API: %w", err)
+	}
+
+	var authorizer tlsconfig.Authorizer
+	if c.ServerID != "" {
+		serverID, err := spiffeid.FromString(c.ServerID)
+		if err != nil {
+			source.Close()
+			return nil, nil, fmt.Errorf("parsing server SPIFFE ID: %w", err)
+		}
+		authorizer = tlsconfig.AuthorizeID(serverID)
+	} else {
+		svid, err := source.GetX509SVID()
+		if err != nil {
+			source.Close()
+			return nil, nil, fmt.Errorf("getting X.509 SVID: %w", err)
+		}
+		authorizer = tlsconfig.AuthorizeMemberOf(svid.ID.TrustDomain())
+	}
+
+	return tlsconfig.MTLSClientConfig(source, source, authorizer), source, nil
+}
@@ -0,0 +1,142 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vault
+
+import (
+	"cmp"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// TransitConfig identifies a key in a Transit secrets engine.
+type TransitConfig struct {
+	Config
+	// Mount is the path the Transit engine is mounted at. Defaults to
+	// "transit".
+	Mount string
+	// KeyName is the name of the RSA key to sign with.
+	KeyName string
+}
+
+// TransitSigner is a [crypto.Signer] that signs using the latest version of
+// a key held in a Transit secrets engine. The key is never exported from
+// Vault. It can be used as the [aalyria.com/spacetime/auth.Config.Signer].
+type TransitSigner struct {
+	ctx     context.Context
+	client  *client
+	mount   string
+	name    string
+	version int
+	pub     *rsa.PublicKey
+}
+
+// transitHashes are the names Transit uses for the hash functions Spacetime
+// tokens are signed with.
+var transitHashes = map[crypto.Hash]string{
+	crypto.SHA256: "sha2-256",
+	crypto.SHA384: "sha2-384",
+	crypto.SHA512: "sha2-512",
+}
+
+// NewTransitSigner reads the public key of the latest version of the
+// configured key. `ctx` is used for that request and for every signing
+// request made by the returned signer, since [crypto.Signer.Sign] doesn't
+// accept one.
+func NewTransitSigner(ctx context.Context, c TransitConfig) (*TransitSigner, error) {
+	if c.KeyName == "" {
+		return nil, errors.New("missing required field 'KeyName'")
+	}
+	cl, err := newClient(c.Config)
+	if err != nil {
+		return nil, err
+	}
+	s := &TransitSigner{ctx: ctx, client: cl, mount: cmp.Or(c.Mount, "transit"), name: c.KeyName}
+
+	type keyVersion struct {
+		PublicKey string `json:"public_key"`
+	}
+	type keyInfo struct {
+		Type          string                `json:"type"`
+		LatestVersion int                   `json:"latest_version"`
+		Keys          map[string]keyVersion `json:"keys"`
+	}
+	info := keyInfo{}
+	if err := cl.do(ctx, http.MethodGet, s.mount+"/keys/"+s.name, nil, &info); err != nil {
+		return nil, fmt.Errorf("reading transit key %s/%s: %w", s.mount, s.name, err)
+	}
+	if !strings.HasPrefix(info.Type, "rsa-") {
+		return nil, fmt.Errorf("transit key %s/%s is a %s key, not an RSA key", s.mount, s.name, info.Type)
+	}
+
+	block, _ := pem.Decode([]byte(info.Keys[strconv.Itoa(info.LatestVersion)].PublicKey))
+	if block == nil {
+		return nil, fmt.Errorf("transit key %s/%s has no PEM-encoded public key for version %d", s.mount, s.name, info.LatestVersion)
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing public key of transit key %s/%s: %w", s.mount, s.name, err)
+	}
+	rsaPub, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("transit key %s/%s isn't an RSA key (got %T)", s.mount, s.name, pub)
+	}
+	s.version, s.pub = info.LatestVersion, rsaPub
+	return s, nil
+}
+
+func (s *TransitSigner) Public() crypto.PublicKey { return s.pub }
+
+// Sign signs `digest` with the key version whose public key was read by
+// NewTransitSigner, using PKCS #1 v1.5 padding. PSS signatures aren't
+// supported.
+func (s *TransitSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if _, ok := opts.(*rsa.PSSOptions); ok {
+		return nil, errors.New("PSS signatures aren't supported")
+	}
+	hash, ok := transitHashes[opts.HashFunc()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported hash function %v", opts.HashFunc())
+	}
+
+	type signResponse struct {
+		Signature string `json:"signature"`
+	}
+	resp := signResponse{}
+	if err := s.client.do(s.ctx, http.MethodPost, s.mount+"/sign/"+s.name+"/"+hash, map[string]any{
+		"input":               base64.StdEncoding.EncodeToString(digest),
+		"prehashed":           true,
+		"signature_algorithm": "pkcs1v15",
+		"key_version":         s.version,
+	}, &resp); err != nil {
+		return nil, fmt.Errorf("signing with transit key %s/%s: %w", s.mount, s.name, err)
+	}
+
+	// Signatures are formatted as "vault:v<version>:<base64 signature>".
+	parts := strings.Split(resp.Signature, ":")
+	if len(parts) != 3 || parts[0] != "vault" {
+		return nil, fmt.Errorf("signing with transit key %s/%s: unexpected signature format %q", s.mount, s.name, resp.Signature)
+	}
+	return base64.StdEncoding.DecodeString(parts[2])
+}
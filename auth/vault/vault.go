@@ -0,0 +1,181 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package vault fetches the private keys used to sign Spacetime JWTs from
+// HashiCorp Vault, or signs the JWTs using Vault's Transit secrets engine so
+// that the key never leaves Vault.
+//
+// The Vault server and token are read from the standard VAULT_ADDR,
+// VAULT_TOKEN and VAULT_NAMESPACE environment variables unless they're set
+// in the [Config].
+package vault // import "aalyria.com/spacetime/auth/vault"
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Config identifies the Vault server and the token used to authenticate
+// with it.
+type Config struct {
+	// Address of the Vault server. Defaults to $VAULT_ADDR.
+	Address string
+	// Token used to authenticate with Vault. Defaults to $VAULT_TOKEN.
+	Token string
+	// Namespace of the secrets, for Vault Enterprise. Defaults to
+	// $VAULT_NAMESPACE.
+	Namespace string
+	// Client is used to send requests to Vault. Defaults to
+	// [http.DefaultClient].
+	Client *http.Client
+}
+
+// client sends authenticated requests to the Vault HTTP API.
+type client struct {
+	addr, token, namespace string
+	http                   *http.Client
+}
+
+func newClient(c Config) (*client, error) {
+	cl := &client{
+		addr:      strings.TrimSuffix(cmp.Or(c.Address, os.Getenv("VAULT_ADDR")), "/"),
+		token:     cmp.Or(c.Token, os.Getenv("VAULT_TOKEN")),
+		namespace: cmp.Or(c.Namespace, os.Getenv("VAULT_NAMESPACE")),
+		http:      cmp.Or(c.Client, http.DefaultClient),
+	}
+	errs := []error{}
+	switch {
+	case cl.addr == "":
+		errs = append(errs, errors.New("no Vault address set; set VAULT_ADDR or the 'Address' field"))
+	case cl.token == "":
+		errs = append(errs, errors.New("no Vault token set; set VAULT_TOKEN or the 'Token' field"))
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return cl, nil
+}
+
+// do sends a request to the API path `path` (without the /v1/ prefix) and
+// decodes the "data" field of the response into `data`.
+func (c *client) do(ctx context.Context, method, path string, body, data any) error {
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.addr+"/v1/"+strings.TrimPrefix(path, "/"), reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", c.token)
+	req.Header.Set("X-Vault-Request", "true")
+	if c.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", c.namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	type vaultResponse struct {
+		Errors []string        `json:"errors"`
+		Data   json.RawMessage `json:"data"`
+	}
+	r := vaultResponse{}
+	if len(respBody) > 0 {
+		if err := json.Unmarshal(respBody, &r); err != nil {
+			return fmt.Errorf("decoding response from %s (status %s): %w", req.URL, resp.Status, err)
+		}
+	}
+	switch {
+	case resp.StatusCode/100 != 2 && len(r.Errors) > 0:
+		return fmt.Errorf("%s %s: %s: %s", method, req.URL.Path, resp.Status, strings.Join(r.Errors, "; "))
+	case resp.StatusCode/100 != 2:
+		return fmt.Errorf("%s %s: %s", method, req.URL.Path, resp.Status)
+	case len(r.Data) == 0:
+		return fmt.Errorf("%s %s: response has no data", method, req.URL.Path)
+	}
+	return json.Unmarshal(r.Data, data)
+}
+
+// KVConfig identifies a PEM-encoded private key stored in a KV secrets
+// engine.
+type KVConfig struct {
+	Config
+	// Mount is the path the KV engine is mounted at. Defaults to "secret".
+	Mount string
+	// Path of the secret within the engine.
+	Path string
+	// Field of the secret that holds the key. Defaults to "private_key".
+	Field string
+	// V1 reads from a version 1 KV engine, which doesn't keep versions of
+	// secrets. Defaults to version 2.
+	V1 bool
+}
+
+// FetchPrivateKey returns the PEM-encoded private key stored in the KV
+// secret, for use as the [aalyria.com/spacetime/auth.Config.PrivateKey].
+func FetchPrivateKey(ctx context.Context, c KVConfig) ([]byte, error) {
+	if c.Path == "" {
+		return nil, errors.New("missing required field 'Path'")
+	}
+	cl, err := newClient(c.Config)
+	if err != nil {
+		return nil, err
+	}
+	mount, field := cmp.Or(c.Mount, "secret"), cmp.Or(c.Field, "private_key")
+
+	secret := map[string]any{}
+	if c.V1 {
+		if err := cl.do(ctx, http.MethodGet, mount+"/"+c.Path, nil, &secret); err != nil {
+			return nil, fmt.Errorf("reading secret %s/%s: %w", mount, c.Path, err)
+		}
+	} else {
+		type kv2Data struct {
+			Data map[string]any `json:"data"`
+		}
+		d := kv2Data{}
+		if err := cl.do(ctx, http.MethodGet, mount+"/data/"+c.Path, nil, &d); err != nil {
+			return nil, fmt.Errorf("reading secret %s/%s: %w", mount, c.Path, err)
+		}
+		secret = d.Data
+	}
+
+	key, ok := secret[field].(string)
+	if !ok || key == "" {
+		return nil, fmt.Errorf("secret %s/%s has no %q field", mount, c.Path, field)
+	}
+	return []byte(key), nil
+}
@@ -0,0 +1,282 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package callctx attaches structured context about who makes calls to the
+// Spacetime APIs, and why, to every call made with a [context.Context]. The
+// context is sent as gRPC metadata, so servers and audit logs can trace each
+// change back to the operator, ticket, or automation job behind it.
+//
+// Describe the calls with an [Info], attach it to a context with [With], and
+// install the interceptors that send it:
+//
+//	ctx = callctx.With(ctx, callctx.Info{Operator: "jane", TicketID: "OPS-1234"})
+//	conn, err := grpc.NewClient(addr,
+//		grpc.WithChainUnaryInterceptor(callctx.UnaryClientInterceptor()),
+//		grpc.WithChainStreamInterceptor(callctx.StreamClientInterceptor()),
+//		...)
+//
+// Servers can read the context of a call with [FromIncomingContext].
+package callctx
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// The metadata keys the fields of an [Info] are sent as.
+const (
+	OperatorKey = "spacetime-operator"
+	TicketIDKey = "spacetime-ticket-id"
+	JobIDKey    = "spacetime-job-id"
+	// LabelKeyPrefix prefixes the keys of [Info.Labels].
+	LabelKeyPrefix = "spacetime-label-"
+	// RequestIDKey is the metadata key of the ID set with [WithRequestID].
+	RequestIDKey = "spacetime-request-id"
+)
+
+// Info describes who or what is making calls, and why. Empty fields aren't
+// sent.
+type Info struct {
+	// Operator is the person making the calls, or on whose behalf they're
+	// made.
+	Operator string
+	// TicketID identifies the ticket or change request the calls are made
+	// for.
+	TicketID string
+	// JobID identifies the automation job making the calls, such as a CI
+	// pipeline run.
+	JobID string
+	// Labels are any other context worth recording. Keys are lowercased, and
+	// may only contain letters, digits, '-', '_' and '.'.
+	Labels map[string]string
+}
+
+// IsZero reports whether `i` has no context to send.
+func (i Info) IsZero() bool {
+	return i.Operator == "" && i.TicketID == "" && i.JobID == "" && len(i.Labels) == 0
+}
+
+// Merge returns `i` with the non-empty fields and the labels of `other`
+// taking precedence.
+func (i Info) Merge(other Info) Info {
+	merged := Info{
+		Operator: cmp.Or(other.Operator, i.Operator),
+		TicketID: cmp.Or(other.TicketID, i.TicketID),
+		JobID:    cmp.Or(other.JobID, i.JobID),
+	}
+	if len(i.Labels)+len(other.Labels) > 0 {
+		merged.Labels = maps.Clone(i.Labels)
+		if merged.Labels == nil {
+			merged.Labels = map[string]string{}
+		}
+		maps.Copy(merged.Labels, other.Labels)
+	}
+	return merged
+}
+
+// Validate returns an error if `i` can't be sent as metadata.
+func (i Info) Validate() error {
+	for k := range i.Labels {
+		if k == "" || strings.IndexFunc(k, func(r rune) bool { return !isKeyRune(r) }) >= 0 {
+			return fmt.Errorf("invalid label key %q (may only contain letters, digits, '-', '_' and '.')", k)
+		}
+	}
+	return nil
+}
+
+func isKeyRune(r rune) bool {
+	return 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '-' || r == '_' || r == '.'
+}
+
+// Metadata returns `i` as gRPC metadata. Values are percent-encoded, since
+// metadata values are restricted to printable ASCII.
+func (i Info) Metadata() metadata.MD {
+	md := metadata.MD{}
+	set := func(k, v string) {
+		if v != "" {
+			md.Set(k, encodeValue(v))
+		}
+	}
+	set(OperatorKey, i.Operator)
+	set(TicketIDKey, i.TicketID)
+	set(JobIDKey, i.JobID)
+	for k, v := range i.Labels {
+		set(LabelKeyPrefix+strings.ToLower(k), v)
+	}
+	return md
+}
+
+// LogValue implements [slog.LogValuer], logging the non-empty fields of `i`.
+func (i Info) LogValue() slog.Value {
+	attrs := []slog.Attr{}
+	add := func(k, v string) {
+		if v != "" {
+			attrs = append(attrs, slog.String(k, v))
+		}
+	}
+	add("operator", i.Operator)
+	add("ticket_id", i.TicketID)
+	add("job_id", i.JobID)
+	for _, k := range slices.Sorted(maps.Keys(i.Labels)) {
+		add("label."+k, i.Labels[k])
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// FromMetadata returns the Info sent as `md`.
+func FromMetadata(md metadata.MD) Info {
+	get := func(k string) string {
+		if vs := md.Get(k); len(vs) > 0 {
+			return decodeValue(vs[0])
+		}
+		return ""
+	}
+	i := Info{Operator: get(OperatorKey), TicketID: get(TicketIDKey), JobID: get(JobIDKey)}
+	for k := range md {
+		if label, ok := strings.CutPrefix(k, LabelKeyPrefix); ok && label != "" {
+			if i.Labels == nil {
+				i.Labels = map[string]string{}
+			}
+			i.Labels[label] = get(k)
+		}
+	}
+	return i
+}
+
+// FromIncomingContext returns the Info sent by the client of the call being
+// served with `ctx`.
+func FromIncomingContext(ctx context.Context) Info {
+	md, _ := metadata.FromIncomingContext(ctx)
+	return FromMetadata(md)
+}
+
+type infoKey struct{}
+
+// With returns a context whose calls are made with `i`, merged into any Info
+// `ctx` already has.
+func With(ctx context.Context, i Info) context.Context {
+	return context.WithValue(ctx, infoKey{}, FromContext(ctx).Merge(i))
+}
+
+// FromContext returns the Info of the calls made with `ctx`.
+func FromContext(ctx context.Context) Info {
+	i, _ := ctx.Value(infoKey{}).(Info)
+	return i
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context whose calls are sent with the request ID
+// `id`. A client retrying a call that changes state sends every attempt with
+// the same ID, so that a server that has already applied the change can
+// recognize the retry and return the result of the first attempt instead of
+// applying it again.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request ID of the calls made with `ctx`,
+// or "" if it has none.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// RequestIDFromIncomingContext returns the request ID sent by the client of
+// the call being served with `ctx`, or "" if it didn't send one.
+func RequestIDFromIncomingContext(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if vs := md.Get(RequestIDKey); len(vs) > 0 {
+		return decodeValue(vs[0])
+	}
+	return ""
+}
+
+// outgoingContext returns `ctx` with its Info and request ID appended to its
+// outgoing metadata.
+func outgoingContext(ctx context.Context) (context.Context, error) {
+	if id := RequestIDFromContext(ctx); id != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, RequestIDKey, encodeValue(id))
+	}
+	i := FromContext(ctx)
+	if i.IsZero() {
+		return ctx, nil
+	}
+	if err := i.Validate(); err != nil {
+		return nil, err
+	}
+	kv := []string{}
+	for k, vs := range i.Metadata() {
+		for _, v := range vs {
+			kv = append(kv, k, v)
+		}
+	}
+	return metadata.AppendToOutgoingContext(ctx, kv...), nil
+}
+
+// UnaryClientInterceptor returns an interceptor that sends the Info of the
+// context of each unary call as metadata.
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, err := outgoingContext(ctx)
+		if err != nil {
+			return err
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor returns an interceptor that sends the Info of the
+// context of each stream as metadata.
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		ctx, err := outgoingContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
+
+// encodeValue percent-encodes the bytes of `v` that aren't printable ASCII,
+// and '%' itself.
+func encodeValue(v string) string {
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		if c := v[i]; c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// decodeValue undoes encodeValue, returning values it didn't encode as is.
+func decodeValue(v string) string {
+	decoded, err := url.PathUnescape(v)
+	if err != nil {
+		return v
+	}
+	return decoded
+}
@@ -0,0 +1,170 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package callctx
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// startServer starts a health server that sends the Info of each call it
+// serves on the returned channel, and returns a client connected to it.
+func startServer(t *testing.T) (*grpc.ClientConn, <-chan Info) {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	infos := make(chan Info, 1)
+	srv := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			infos <- FromIncomingContext(ctx)
+			return handler(ctx, req)
+		}),
+		grpc.ChainStreamInterceptor(func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			infos <- FromIncomingContext(ss.Context())
+			return handler(srv, ss)
+		}),
+	)
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient(lis.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithChainUnaryInterceptor(UnaryClientInterceptor()),
+		grpc.WithChainStreamInterceptor(StreamClientInterceptor()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, infos
+}
+
+func TestInterceptors(t *testing.T) {
+	t.Parallel()
+
+	conn, infos := startServer(t)
+	client := healthpb.NewHealthClient(conn)
+
+	ctx := With(context.Background(), Info{Operator: "Zoë Ops", JobID: "nightly-42"})
+	ctx = With(ctx, Info{TicketID: "OPS-1234", Labels: map[string]string{"Pipeline": "deploy 100%"}})
+	want := Info{
+		Operator: "Zoë Ops",
+		TicketID: "OPS-1234",
+		JobID:    "nightly-42",
+		Labels:   map[string]string{"pipeline": "deploy 100%"},
+	}
+
+	if _, err := client.Check(ctx, &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, <-infos); diff != "" {
+		t.Errorf("unary call Info mismatch (-want +got):\n%s", diff)
+	}
+
+	stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, <-infos); diff != "" {
+		t.Errorf("stream Info mismatch (-want +got):\n%s", diff)
+	}
+
+	// Calls without an Info send nothing.
+	if _, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-infos; !got.IsZero() {
+		t.Errorf("call without an Info sent %+v, want nothing", got)
+	}
+}
+
+func TestInterceptors_requestID(t *testing.T) {
+	t.Parallel()
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make(chan string, 1)
+	srv := grpc.NewServer(grpc.ChainUnaryInterceptor(func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ids <- RequestIDFromIncomingContext(ctx)
+		return handler(ctx, req)
+	}))
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient(lis.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithChainUnaryInterceptor(UnaryClientInterceptor()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	client := healthpb.NewHealthClient(conn)
+
+	ctx := WithRequestID(context.Background(), "req-1")
+	if _, err := client.Check(ctx, &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-ids; got != "req-1" {
+		t.Errorf("server got request ID %q, want %q", got, "req-1")
+	}
+
+	if _, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-ids; got != "" {
+		t.Errorf("call without a request ID sent %q, want nothing", got)
+	}
+}
+
+func TestInterceptors_rejectInvalidLabels(t *testing.T) {
+	t.Parallel()
+
+	conn, _ := startServer(t)
+	ctx := With(context.Background(), Info{Labels: map[string]string{"not a key": "v"}})
+	if _, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{}); err == nil {
+		t.Error("call with an invalid label key succeeded, want an error")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	t.Parallel()
+
+	base := Info{Operator: "alice", TicketID: "OPS-1", Labels: map[string]string{"a": "1", "b": "2"}}
+	got := base.Merge(Info{TicketID: "OPS-2", Labels: map[string]string{"b": "3"}})
+	want := Info{Operator: "alice", TicketID: "OPS-2", Labels: map[string]string{"a": "1", "b": "3"}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Merge() mismatch (-want +got):\n%s", diff)
+	}
+	if base.Labels["b"] != "2" {
+		t.Errorf("Merge() modified the labels of its receiver: %v", base.Labels)
+	}
+}
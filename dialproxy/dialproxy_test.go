@@ -0,0 +1,363 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dialproxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// listen starts serving connections accepted on a local port with `handle`.
+func listen(t *testing.T, handle func(net.Conn)) string {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lis.Close() })
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+	return lis.Addr().String()
+}
+
+func echoServer(t *testing.T) string {
+	return listen(t, func(conn net.Conn) { io.Copy(conn, conn) })
+}
+
+// tunnel relays data between the client and a connection to `addr`.
+func tunnel(client io.ReadWriter, addr string) {
+	upstream, err := net.Dial("tcp", addr)
+	if err != nil {
+		return
+	}
+	defer upstream.Close()
+	go io.Copy(upstream, client)
+	io.Copy(client, upstream)
+}
+
+// httpProxy returns the address of an HTTP CONNECT proxy that requires the
+// credentials user:password, and a counter of the tunnels it opened.
+func httpProxy(t *testing.T) (string, *atomic.Int32) {
+	tunnels := &atomic.Int32{}
+	want := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:password"))
+	addr := listen(t, func(conn net.Conn) {
+		br := bufio.NewReader(conn)
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return
+		}
+		if req.Method != http.MethodConnect || req.Header.Get("Proxy-Authorization") != want {
+			io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+			return
+		}
+		io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		tunnels.Add(1)
+		tunnel(struct {
+			io.Reader
+			io.Writer
+		}{br, conn}, req.Host)
+	})
+	return addr, tunnels
+}
+
+// socks5Proxy returns the address of a SOCKS5 proxy that requires the
+// credentials user:password, and a counter of the tunnels it opened.
+func socks5Proxy(t *testing.T) (string, *atomic.Int32) {
+	tunnels := &atomic.Int32{}
+	addr := listen(t, func(conn net.Conn) {
+		buf := make([]byte, 2)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return
+		}
+		methods := make([]byte, buf[1])
+		if _, err := io.ReadFull(conn, methods); err != nil {
+			return
+		}
+		conn.Write([]byte{socks5Version, socks5AuthPassword})
+
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return
+		}
+		user := make([]byte, buf[1])
+		io.ReadFull(conn, user)
+		io.ReadFull(conn, buf[:1])
+		password := make([]byte, buf[0])
+		io.ReadFull(conn, password)
+		if string(user) != "user" || string(password) != "password" {
+			conn.Write([]byte{socks5PasswordVersion, 1})
+			return
+		}
+		conn.Write([]byte{socks5PasswordVersion, 0})
+
+		req := make([]byte, 4)
+		if _, err := io.ReadFull(conn, req); err != nil {
+			return
+		}
+		var host string
+		switch req[3] {
+		case socks5AddrIPv4:
+			ip := make([]byte, net.IPv4len)
+			io.ReadFull(conn, ip)
+			host = net.IP(ip).String()
+		case socks5AddrDomain:
+			io.ReadFull(conn, buf[:1])
+			name := make([]byte, buf[0])
+			io.ReadFull(conn, name)
+			host = string(name)
+		default:
+			conn.Write([]byte{socks5Version, 0x08, 0, socks5AddrIPv4, 0, 0, 0, 0, 0, 0})
+			return
+		}
+		io.ReadFull(conn, buf)
+		port := binary.BigEndian.Uint16(buf)
+
+		conn.Write([]byte{socks5Version, 0, 0, socks5AddrIPv4, 0, 0, 0, 0, 0, 0})
+		tunnels.Add(1)
+		tunnel(conn, net.JoinHostPort(host, strconv.Itoa(int(port))))
+	})
+	return addr, tunnels
+}
+
+func checkEcho(t *testing.T, conn net.Conn) {
+	t.Helper()
+
+	defer conn.Close()
+	if _, err := io.WriteString(conn, "ping"); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, 4)
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "ping" {
+		t.Errorf("got %q back, want %q", got, "ping")
+	}
+}
+
+func TestDial(t *testing.T) {
+	t.Parallel()
+
+	echo := echoServer(t)
+	httpAddr, _ := httpProxy(t)
+	socksAddr, _ := socks5Proxy(t)
+
+	for _, tc := range []struct {
+		name, proxy string
+		wantErr     bool
+	}{
+		{name: "http", proxy: "http://user:password@" + httpAddr},
+		{name: "http without credentials", proxy: "http://" + httpAddr, wantErr: true},
+		{name: "socks5", proxy: "socks5://user:password@" + socksAddr},
+		{name: "socks5h", proxy: "socks5h://user:password@" + socksAddr},
+		{name: "socks5 with wrong password", proxy: "socks5://user:wrong@" + socksAddr, wantErr: true},
+		{name: "socks5 without credentials", proxy: "socks5://" + socksAddr, wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			proxy, err := url.Parse(tc.proxy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn, err := Dial(context.Background(), proxy, echo)
+			switch {
+			case tc.wantErr && err == nil:
+				conn.Close()
+				t.Fatalf("expected an error")
+			case tc.wantErr:
+				return
+			case err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			}
+			checkEcho(t, conn)
+		})
+	}
+}
+
+func TestDialOptions(t *testing.T) {
+	t.Parallel()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	_, port, _ := net.SplitHostPort(lis.Addr().String())
+
+	socksAddr, tunnels := socks5Proxy(t)
+	opts, err := DialOptions(Config{URL: "socks5h://user:password@" + socksAddr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The proxy resolves the name, so the target uses the default dns scheme
+	// with a name that only the proxy's resolver is guaranteed to know.
+	conn, err := grpc.NewClient(net.JoinHostPort("localhost", port), append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := tunnels.Load(); got != 1 {
+		t.Errorf("proxy opened %d tunnels, want 1", got)
+	}
+}
+
+func TestDialOptions_unixSocket(t *testing.T) {
+	t.Parallel()
+
+	sock := filepath.Join(t.TempDir(), "nbi.sock")
+	lis, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	socksAddr, tunnels := socks5Proxy(t)
+	opts, err := DialOptions(Config{URL: "socks5h://user:password@" + socksAddr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := grpc.NewClient("unix://"+sock, append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := tunnels.Load(); got != 0 {
+		t.Errorf("proxy opened %d tunnels, want none", got)
+	}
+}
+
+func TestConfig_environment(t *testing.T) {
+	echo := echoServer(t)
+	httpAddr, tunnels := httpProxy(t)
+	t.Setenv("HTTPS_PROXY", "user:password@"+httpAddr)
+	t.Setenv("NO_PROXY", "internal.example.com,10.0.0.0/8")
+
+	proxyFor, err := Config{}.proxyFunc()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for host, wantProxy := range map[string]bool{
+		"spacetime.example.com":    true,
+		"internal.example.com":     false,
+		"api.internal.example.com": false,
+		"10.1.2.3":                 false,
+		"192.168.1.1":              true,
+	} {
+		proxy, err := proxyFor(host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := proxy != nil; got != wantProxy {
+			t.Errorf("proxy for %s = %v, want proxied: %t", host, proxy, wantProxy)
+		}
+	}
+
+	proxy, _ := proxyFor("127.0.0.1")
+	conn, err := Dial(context.Background(), proxy, echo)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	checkEcho(t, conn)
+	if got := tunnels.Load(); got != 1 {
+		t.Errorf("proxy opened %d tunnels, want 1", got)
+	}
+
+	// An explicit URL overrides the environment.
+	if proxyFor, err := (Config{URL: Direct}).proxyFunc(); err != nil || proxyFor != nil {
+		t.Errorf("Direct config returned a proxy function (err: %v)", err)
+	}
+}
+
+func TestParseNoProxy(t *testing.T) {
+	t.Parallel()
+
+	np := parseNoProxy(" .example.com, localhost:8080,*.corp, ::1 ,192.168.0.0/16")
+	for host, want := range map[string]bool{
+		"example.com":        true,
+		"a.b.example.com":    true,
+		"notexample.com":     false,
+		"localhost":          true,
+		"LOCALHOST":          true,
+		"svc.corp":           true,
+		"::1":                true,
+		"[::1]":              true,
+		"192.168.10.10":      true,
+		"::ffff:192.168.1.1": true,
+		"192.169.0.1":        false,
+	} {
+		if got := np.matches(host); got != want {
+			t.Errorf("matches(%q) = %t, want %t", host, got, want)
+		}
+	}
+	if !parseNoProxy("*").matches("anything.example.com") {
+		t.Errorf("expected * to match every host")
+	}
+}
+
+func TestParseURL(t *testing.T) {
+	t.Parallel()
+
+	for raw, wantErr := range map[string]bool{
+		"proxy.example.com:3128":               false,
+		"https://proxy.example.com":            false,
+		"socks5h://u:p@proxy.example.com:1080": false,
+		"ftp://proxy.example.com":              true,
+		"http://":                              true,
+	} {
+		if _, err := parseURL(raw); (err != nil) != wantErr {
+			t.Errorf("parseURL(%q) returned error %v, want error: %t", raw, err, wantErr)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dialproxy
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Constants from RFC 1928 and RFC 1929.
+const (
+	socks5Version = 0x05
+
+	socks5AuthNone         = 0x00
+	socks5AuthPassword     = 0x02
+	socks5AuthNoAcceptable = 0xff
+	socks5PasswordVersion  = 0x01
+
+	socks5CmdConnect = 0x01
+
+	socks5AddrIPv4   = 0x01
+	socks5AddrDomain = 0x03
+	socks5AddrIPv6   = 0x04
+)
+
+// socks5Replies describes the failure replies defined by RFC 1928.
+var socks5Replies = map[byte]string{
+	0x01: "general SOCKS server failure",
+	0x02: "connection not allowed by ruleset",
+	0x03: "network unreachable",
+	0x04: "host unreachable",
+	0x05: "connection refused",
+	0x06: "TTL expired",
+	0x07: "command not supported",
+	0x08: "address type not supported",
+}
+
+// dialSOCKS5 opens a connection to `addr` through a SOCKS5 proxy.
+func dialSOCKS5(ctx context.Context, proxy *url.URL, addr string) (_ net.Conn, err error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port in %q: %w", addr, err)
+	}
+	// socks5 resolves names locally, socks5h leaves it to the proxy.
+	if _, err := netip.ParseAddr(host); err != nil && proxy.Scheme == "socks5" {
+		ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+		if err != nil {
+			return nil, err
+		}
+		host = ips[0].Unmap().String()
+	}
+
+	conn, err := dialProxy(ctx, proxy)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			conn.Close()
+		}
+	}()
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Unix(1, 0)) })
+	defer stop()
+
+	if err := socks5Authenticate(conn, proxy.User); err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+	if err := socks5Connect(conn, host, uint16(port)); err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+	if !stop() {
+		return nil, ctx.Err()
+	}
+	return conn, nil
+}
+
+func socks5Authenticate(conn net.Conn, user *url.Userinfo) error {
+	methods := []byte{socks5AuthNone}
+	if user != nil {
+		methods = append(methods, socks5AuthPassword)
+	}
+	if _, err := conn.Write(append([]byte{socks5Version, byte(len(methods))}, methods...)); err != nil {
+		return fmt.Errorf("sending SOCKS5 greeting: %w", err)
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("reading SOCKS5 greeting: %w", err)
+	}
+	if reply[0] != socks5Version {
+		return fmt.Errorf("unexpected SOCKS version %d", reply[0])
+	}
+
+	switch reply[1] {
+	case socks5AuthNone:
+		return nil
+
+	case socks5AuthPassword:
+		if user == nil {
+			return errors.New("SOCKS5 proxy selected password authentication, but no credentials were provided")
+		}
+		password, _ := user.Password()
+		if len(user.Username()) > 255 || len(password) > 255 {
+			return errors.New("SOCKS5 username and password must be at most 255 bytes long")
+		}
+		req := []byte{socks5PasswordVersion, byte(len(user.Username()))}
+		req = append(req, user.Username()...)
+		req = append(req, byte(len(password)))
+		req = append(req, password...)
+		if _, err := conn.Write(req); err != nil {
+			return fmt.Errorf("sending SOCKS5 credentials: %w", err)
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return fmt.Errorf("reading SOCKS5 authentication status: %w", err)
+		}
+		if reply[1] != 0 {
+			return errors.New("SOCKS5 proxy rejected the credentials")
+		}
+		return nil
+
+	case socks5AuthNoAcceptable:
+		return errors.New("SOCKS5 proxy didn't accept any of the offered authentication methods")
+
+	default:
+		return fmt.Errorf("SOCKS5 proxy selected unsupported authentication method %d", reply[1])
+	}
+}
+
+func socks5Connect(conn net.Conn, host string, port uint16) error {
+	req := []byte{socks5Version, socks5CmdConnect, 0x00}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		if ip.Is4() || ip.Is4In6() {
+			req = append(req, socks5AddrIPv4)
+			req = append(req, ip.Unmap().AsSlice()...)
+		} else {
+			req = append(req, socks5AddrIPv6)
+			req = append(req, ip.AsSlice()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return fmt.Errorf("host name %q is too long for SOCKS5", host)
+		}
+		req = append(req, socks5AddrDomain, byte(len(host)))
+		req = append(req, host...)
+	}
+	req = binary.BigEndian.AppendUint16(req, port)
+	if _, err := conn.Write(req); err != nil {
+		return fmt.Errorf("sending SOCKS5 connect request: %w", err)
+	}
+
+	// The reply is followed by the address the proxy bound, which isn't
+	// needed but has to be consumed.
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("reading SOCKS5 connect reply: %w", err)
+	}
+	if reply[1] != 0 {
+		msg, ok := socks5Replies[reply[1]]
+		if !ok {
+			msg = fmt.Sprintf("unknown error %d", reply[1])
+		}
+		return fmt.Errorf("SOCKS5 proxy failed to connect to %s: %s", net.JoinHostPort(host, strconv.Itoa(int(port))), msg)
+	}
+	var addrLen int
+	switch reply[3] {
+	case socks5AddrIPv4:
+		addrLen = net.IPv4len
+	case socks5AddrIPv6:
+		addrLen = net.IPv6len
+	case socks5AddrDomain:
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(conn, n); err != nil {
+			return fmt.Errorf("reading SOCKS5 connect reply: %w", err)
+		}
+		addrLen = int(n[0])
+	default:
+		return fmt.Errorf("unexpected SOCKS5 address type %d", reply[3])
+	}
+	if _, err := io.ReadFull(conn, make([]byte, addrLen+2)); err != nil {
+		return fmt.Errorf("reading SOCKS5 connect reply: %w", err)
+	}
+	return nil
+}
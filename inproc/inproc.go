@@ -0,0 +1,182 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package inproc connects gRPC clients and servers in the same process
+// through named, in-memory listeners rather than the network, for embedding
+// a client and a fake server in the same binary, such as in hermetic
+// integration tests.
+//
+//	lis, err := inproc.Listen("nbi")
+//	if err != nil {
+//		return err
+//	}
+//	go srv.Serve(lis)
+//
+//	conn, err := grpc.NewClient("inproc:///nbi", inproc.DialOptions()...)
+//
+// Since the connection never leaves the process, its transport credentials
+// report the same security level as TLS, so per-RPC credentials, such as
+// JWTs, can be sent without TLS.
+package inproc // import "aalyria.com/spacetime/inproc"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const (
+	// Scheme is the scheme of the targets that connect to a Listener, such
+	// as "inproc:///nbi".
+	Scheme = "inproc"
+
+	bufferSize = 1 << 20
+)
+
+var (
+	mu        sync.Mutex
+	listeners = map[string]*listener{}
+)
+
+// Listen returns a Listener that accepts the connections made to `name`,
+// until it's closed. Only one Listener can use a name at a time.
+func Listen(name string) (net.Listener, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, ok := listeners[name]; ok {
+		return nil, fmt.Errorf("inproc: %q is already in use", name)
+	}
+	l := &listener{Listener: bufconn.Listen(bufferSize), name: name}
+	listeners[name] = l
+	return l, nil
+}
+
+// Dial connects to the Listener named `name`.
+func Dial(ctx context.Context, name string) (net.Conn, error) {
+	mu.Lock()
+	l, ok := listeners[name]
+	mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("inproc: no listener named %q", name)
+	}
+	c, err := l.DialContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{Conn: c, name: name}, nil
+}
+
+// DialOptions returns the gRPC dial options that connect to targets with
+// the inproc scheme, using [TransportCredentials].
+func DialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithResolvers(passthrough{resolver.Get("passthrough")}),
+		grpc.WithContextDialer(Dial),
+		grpc.WithTransportCredentials(TransportCredentials()),
+	}
+}
+
+// passthrough resolves targets with the inproc scheme to their name.
+type passthrough struct{ resolver.Builder }
+
+func (passthrough) Scheme() string { return Scheme }
+
+type listener struct {
+	*bufconn.Listener
+	name string
+	once sync.Once
+}
+
+func (l *listener) Close() error {
+	l.once.Do(func() {
+		mu.Lock()
+		defer mu.Unlock()
+		delete(listeners, l.name)
+	})
+	return l.Listener.Close()
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &conn{Conn: c, name: l.name}, nil
+}
+
+func (l *listener) Addr() net.Addr { return addr(l.name) }
+
+// conn is a connection made through a Listener.
+type conn struct {
+	net.Conn
+	name string
+}
+
+func (c *conn) LocalAddr() net.Addr  { return addr(c.name) }
+func (c *conn) RemoteAddr() net.Addr { return addr(c.name) }
+
+type addr string
+
+func (addr) Network() string  { return Scheme }
+func (a addr) String() string { return string(a) }
+
+// TransportCredentials returns the credentials of connections made through
+// a Listener. They don't encrypt anything, but report the
+// PrivacyAndIntegrity security level, and refuse any other connection.
+// Servers that only accept in-process connections can use them too, with
+// grpc.Creds.
+func TransportCredentials() credentials.TransportCredentials { return creds{} }
+
+type creds struct{}
+
+// AuthInfo is the credentials.AuthInfo of in-process connections.
+type AuthInfo struct {
+	credentials.CommonAuthInfo
+}
+
+// AuthType implements credentials.AuthInfo.
+func (AuthInfo) AuthType() string { return Scheme }
+
+var errNotInProcess = errors.New("inproc: credentials used with a connection that isn't in-process")
+
+func (creds) handshake(c net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	if _, ok := c.(*conn); !ok {
+		return nil, nil, errNotInProcess
+	}
+	return c, AuthInfo{credentials.CommonAuthInfo{SecurityLevel: credentials.PrivacyAndIntegrity}}, nil
+}
+
+func (c creds) ClientHandshake(_ context.Context, _ string, rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return c.handshake(rawConn)
+}
+
+func (c creds) ServerHandshake(rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return c.handshake(rawConn)
+}
+
+func (creds) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{SecurityProtocol: Scheme}
+}
+
+func (c creds) Clone() credentials.TransportCredentials { return c }
+
+func (creds) OverrideServerName(string) error { return nil }
@@ -0,0 +1,129 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inproc
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+)
+
+// tokenCreds sends a fixed token, and like the Spacetime JWT credentials,
+// requires transport security.
+type tokenCreds struct{}
+
+func (tokenCreds) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer token"}, nil
+}
+
+func (tokenCreds) RequireTransportSecurity() bool { return true }
+
+func serve(t *testing.T, name string, opts ...grpc.ServerOption) (authz *string) {
+	t.Helper()
+
+	lis, err := Listen(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authz = new(string)
+	srv := grpc.NewServer(append(opts, grpc.UnaryInterceptor(func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		*authz = strings.Join(md.Get("authorization"), ",")
+		return handler(ctx, req)
+	}))...)
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	return authz
+}
+
+func TestDialOptions(t *testing.T) {
+	t.Parallel()
+
+	authz := serve(t, t.Name(), grpc.Creds(TransportCredentials()))
+	conn, err := grpc.NewClient(Scheme+":///"+t.Name(), append(DialOptions(), grpc.WithPerRPCCredentials(tokenCreds{}))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	resp, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatalf("Check() failed: %v", err)
+	}
+	if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("Check() = %v, want SERVING", resp.GetStatus())
+	}
+	if *authz != "Bearer token" {
+		t.Errorf("server got authorization %q, want the per-RPC credentials", *authz)
+	}
+}
+
+func TestListen_nameInUse(t *testing.T) {
+	t.Parallel()
+
+	lis, err := Listen(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Listen(t.Name()); err == nil {
+		t.Error("expected listening on a name in use to fail")
+	}
+
+	// Closing the listener frees the name.
+	lis.Close()
+	lis, err = Listen(t.Name())
+	if err != nil {
+		t.Fatalf("Listen() after closing failed: %v", err)
+	}
+	lis.Close()
+}
+
+func TestDial_unknownName(t *testing.T) {
+	t.Parallel()
+
+	if _, err := Dial(context.Background(), t.Name()); err == nil || !strings.Contains(err.Error(), "no listener") {
+		t.Errorf("expected dialing an unknown name to fail, got %v", err)
+	}
+}
+
+func TestTransportCredentials_refuseOtherConnections(t *testing.T) {
+	t.Parallel()
+
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	if _, _, err := TransportCredentials().ServerHandshake(server); err == nil {
+		t.Error("expected the handshake of a connection that isn't in-process to fail")
+	}
+
+	// Servers using other credentials accept in-process connections too.
+	serve(t, t.Name(), grpc.Creds(insecure.NewCredentials()))
+	conn, err := grpc.NewClient(Scheme+":///"+t.Name(), DialOptions()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{}); err != nil {
+		t.Errorf("Check() failed: %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package objstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"cloud.google.com/go/storage"
+	"github.com/googleapis/gax-go/v2"
+)
+
+// gcsWriter uploads to Cloud Storage with a resumable upload, a chunk at a
+// time. The object is only created once the last chunk is uploaded by
+// Close.
+type gcsWriter struct {
+	w      *storage.Writer
+	url    string
+	cancel context.CancelFunc
+	// closer closes the client if it was created by createGCS.
+	closer io.Closer
+	done   bool
+}
+
+func createGCS(ctx context.Context, bucket, object string, c Config) (*gcsWriter, error) {
+	client := c.GCSClient
+	var closer io.Closer
+	if client == nil {
+		var err error
+		if client, err = storage.NewClient(ctx); err != nil {
+			return nil, fmt.Errorf("creating the Cloud Storage client: %w", err)
+		}
+		closer = client
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	// Uploads without preconditions aren't retried by default, since they
+	// could overwrite an object written in the meantime. Each destination
+	// is only written by one export, so they're safe to retry here.
+	obj := client.Bucket(bucket).Object(object).Retryer(
+		storage.WithPolicy(storage.RetryAlways),
+		storage.WithBackoff(gax.Backoff{Initial: initialRetryDelay, Multiplier: 2}),
+		storage.WithMaxAttempts(c.maxAttempts()),
+	)
+	w := obj.NewWriter(ctx)
+	w.ChunkSize = c.partSize()
+	return &gcsWriter{
+		w:      w,
+		url:    "gs://" + bucket + "/" + object,
+		cancel: cancel,
+		closer: closer,
+	}, nil
+}
+
+func (w *gcsWriter) Write(p []byte) (int, error) { return w.w.Write(p) }
+
+func (w *gcsWriter) Close() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+	defer w.release()
+	if err := w.w.Close(); err != nil {
+		return fmt.Errorf("uploading %s: %w", w.url, err)
+	}
+	return nil
+}
+
+func (w *gcsWriter) Abort() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+	// Canceling the writer's context discards the upload, and makes Close
+	// return the context's error.
+	w.cancel()
+	w.w.Close()
+	w.release()
+	return nil
+}
+
+func (w *gcsWriter) release() {
+	w.cancel()
+	if w.closer != nil {
+		w.closer.Close()
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package objstore writes the output of exports and diagnostics either to a
+// local file or directly to object storage, so jobs on ephemeral runners can
+// upload them without staging them on local disk first.
+//
+// Destinations are given as a path or a URL:
+//
+//   - s3://BUCKET/KEY is uploaded to Amazon S3, in parts, using credentials
+//     from the default AWS credential chain.
+//   - gs://BUCKET/OBJECT is uploaded to Google Cloud Storage with a resumable
+//     upload, using Application Default Credentials.
+//   - Anything else, including file:// URLs, is a local path.
+//
+// In every case, nothing appears at the destination until [Writer.Close]
+// succeeds, so a failed export doesn't leave a truncated file behind.
+package objstore // import "aalyria.com/spacetime/objstore"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+const (
+	// DefaultPartSize is the size of the parts uploaded to object storage.
+	// It's a multiple of the 256 KiB that Cloud Storage requires, and above
+	// the 5 MiB minimum of S3.
+	DefaultPartSize = 8 << 20
+	// DefaultMaxAttempts is how many times uploading a part is attempted
+	// before giving up.
+	DefaultMaxAttempts = 5
+
+	// initialRetryDelay is how long to wait before retrying a failed part.
+	// The delay doubles with every attempt.
+	initialRetryDelay = 500 * time.Millisecond
+)
+
+// Writer writes a single file or object.
+type Writer interface {
+	io.Writer
+	// Close finishes writing. The output only exists at its destination
+	// once Close returns nil.
+	Close() error
+	// Abort discards what was written, and releases any partial upload. It
+	// has no effect after Close.
+	Abort() error
+}
+
+// Config configures how objects are uploaded.
+type Config struct {
+	// PartSize is the size of the parts uploaded to object storage.
+	// Defaults to DefaultPartSize.
+	PartSize int
+	// MaxAttempts is how many times uploading each part is attempted.
+	// Defaults to DefaultMaxAttempts.
+	MaxAttempts int
+	// S3Client is used for s3:// destinations. Defaults to a client using
+	// credentials from the default AWS credential chain.
+	S3Client S3Client
+	// GCSClient is used for gs:// destinations. Defaults to a client using
+	// Application Default Credentials.
+	GCSClient *storage.Client
+}
+
+func (c Config) partSize() int {
+	if c.PartSize > 0 {
+		return c.PartSize
+	}
+	return DefaultPartSize
+}
+
+func (c Config) maxAttempts() int {
+	if c.MaxAttempts > 0 {
+		return c.MaxAttempts
+	}
+	return DefaultMaxAttempts
+}
+
+// IsRemote reports whether `dest` is an object storage URL rather than a
+// local path.
+func IsRemote(dest string) bool {
+	return strings.HasPrefix(dest, "s3://") || strings.HasPrefix(dest, "gs://")
+}
+
+// Create returns a Writer to `dest`. `ctx` is used for every request made
+// to upload the output, so canceling it aborts the upload.
+func Create(ctx context.Context, dest string, c Config) (Writer, error) {
+	if !IsRemote(dest) {
+		return createFile(strings.TrimPrefix(dest, "file://"))
+	}
+
+	u, err := url.Parse(dest)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %q: %w", dest, err)
+	}
+	bucket, key := u.Host, strings.TrimPrefix(u.Path, "/")
+	if bucket == "" || key == "" {
+		return nil, fmt.Errorf("%q must name both a bucket and an object", dest)
+	}
+	switch u.Scheme {
+	case "s3":
+		return createS3(ctx, bucket, key, c)
+	case "gs":
+		return createGCS(ctx, bucket, key, c)
+	default:
+		return nil, fmt.Errorf("unsupported destination %q", dest)
+	}
+}
+
+// fileWriter writes to a temporary file next to its destination, and
+// renames it into place when closed.
+type fileWriter struct {
+	*os.File
+	path string
+	done bool
+}
+
+func createFile(path string) (*fileWriter, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return nil, err
+	}
+	return &fileWriter{File: f, path: path}, nil
+}
+
+func (w *fileWriter) Close() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+	if err := w.File.Close(); err != nil {
+		os.Remove(w.File.Name())
+		return err
+	}
+	if err := os.Rename(w.File.Name(), w.path); err != nil {
+		os.Remove(w.File.Name())
+		return err
+	}
+	return nil
+}
+
+func (w *fileWriter) Abort() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+	return errors.Join(w.File.Close(), os.Remove(w.File.Name()))
+}
+
+// withRetries calls `fn` until it succeeds, up to `attempts` times, backing
+// off exponentially between attempts.
+func withRetries(ctx context.Context, attempts int, fn func() error) error {
+	delay := initialRetryDelay
+	for i := 1; ; i++ {
+		err := fn()
+		if err == nil || i >= attempts || ctx.Err() != nil {
+			return err
+		}
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return errors.Join(err, ctx.Err())
+		case <-t.C:
+		}
+		delay *= 2
+	}
+}
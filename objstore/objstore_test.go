@@ -0,0 +1,225 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package objstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// fakeS3 stores the objects uploaded to it in memory.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string]string
+	// parts holds the parts of the multipart upload in progress, if any.
+	parts map[int32]string
+	// failParts is how many calls to UploadPart fail before one succeeds.
+	failParts int
+	aborted   bool
+}
+
+func newFakeS3() *fakeS3 { return &fakeS3{objects: map[string]string{}} }
+
+func (f *fakeS3) PutObject(_ context.Context, in *s3.PutObjectInput, _ ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	b, err := io.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	f.objects[*in.Bucket+"/"+*in.Key] = string(b)
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (f *fakeS3) CreateMultipartUpload(_ context.Context, in *s3.CreateMultipartUploadInput, _ ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.parts = map[int32]string{}
+	return &s3.CreateMultipartUploadOutput{UploadId: aws.String("upload-1")}, nil
+}
+
+func (f *fakeS3) UploadPart(_ context.Context, in *s3.UploadPartInput, _ ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failParts > 0 {
+		f.failParts--
+		return nil, errors.New("connection reset")
+	}
+	b, err := io.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	f.parts[*in.PartNumber] = string(b)
+	return &s3.UploadPartOutput{ETag: aws.String(string(b))}, nil
+}
+
+func (f *fakeS3) CompleteMultipartUpload(_ context.Context, in *s3.CompleteMultipartUploadInput, _ ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sb := &strings.Builder{}
+	for i, p := range in.MultipartUpload.Parts {
+		if *p.PartNumber != int32(i+1) || *p.ETag != f.parts[*p.PartNumber] {
+			return nil, errors.New("invalid part")
+		}
+		sb.WriteString(f.parts[*p.PartNumber])
+	}
+	f.objects[*in.Bucket+"/"+*in.Key] = sb.String()
+	return &s3.CompleteMultipartUploadOutput{}, nil
+}
+
+func (f *fakeS3) AbortMultipartUpload(_ context.Context, in *s3.AbortMultipartUploadInput, _ ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.aborted = true
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+func writeAll(t *testing.T, w Writer, chunks ...string) {
+	t.Helper()
+	for _, c := range chunks {
+		if _, err := io.WriteString(w, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCreate_file(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "export.jsonl")
+
+	w, err := Create(context.Background(), "file://"+path, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeAll(t, w, "hello, ", "world\n")
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected %s not to exist before Close, got %v", path, err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(path); err != nil || string(got) != "hello, world\n" {
+		t.Errorf("expected the file to hold what was written, got %q, %v", got, err)
+	}
+
+	w, err = Create(context.Background(), filepath.Join(dir, "aborted"), Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeAll(t, w, "partial")
+	if err := w.Abort(); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected only the closed file to be left, got %v", entries)
+	}
+}
+
+func TestCreate_badURL(t *testing.T) {
+	t.Parallel()
+
+	for _, dest := range []string{"s3://bucket-only", "gs:///no-bucket"} {
+		if _, err := Create(context.Background(), dest, Config{S3Client: newFakeS3()}); err == nil {
+			t.Errorf("Create(%q): expected an error, got nil", dest)
+		}
+	}
+}
+
+func TestS3_singleRequest(t *testing.T) {
+	t.Parallel()
+
+	fake := newFakeS3()
+	w, err := Create(context.Background(), "s3://bucket/dir/export.jsonl", Config{S3Client: fake, PartSize: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeAll(t, w, "small")
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.objects["bucket/dir/export.jsonl"]; got != "small" {
+		t.Errorf("expected the object to hold %q, got %q", "small", got)
+	}
+	if fake.parts != nil {
+		t.Errorf("expected no multipart upload, got parts %v", fake.parts)
+	}
+}
+
+func TestS3_multipart(t *testing.T) {
+	t.Parallel()
+
+	fake := newFakeS3()
+	// The first part fails once, and is retried.
+	fake.failParts = 1
+	w, err := Create(context.Background(), "s3://bucket/export.jsonl", Config{S3Client: fake, PartSize: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeAll(t, w, "012", "3456789")
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := fake.objects["bucket/export.jsonl"]; got != "0123456789" {
+		t.Errorf("expected the object to hold %q, got %q", "0123456789", got)
+	}
+	parts := []string{}
+	for _, n := range slices.Sorted(maps.Keys(fake.parts)) {
+		parts = append(parts, fake.parts[n])
+	}
+	if want := []string{"0123", "4567", "89"}; !slices.Equal(parts, want) {
+		t.Errorf("expected parts %q, got %q", want, parts)
+	}
+}
+
+func TestS3_abortsFailedUpload(t *testing.T) {
+	t.Parallel()
+
+	fake := newFakeS3()
+	fake.failParts = 1
+	w, err := Create(context.Background(), "s3://bucket/export.jsonl", Config{S3Client: fake, PartSize: 4, MaxAttempts: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(w, "0123456789"); err == nil {
+		t.Fatal("expected the write to fail, got nil")
+	}
+	if err := w.Close(); err == nil {
+		t.Error("expected Close to fail after a failed write, got nil")
+	}
+	if !fake.aborted {
+		t.Error("expected the multipart upload to be aborted")
+	}
+	if _, ok := fake.objects["bucket/export.jsonl"]; ok {
+		t.Error("expected no object to be created")
+	}
+}
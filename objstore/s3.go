@@ -0,0 +1,202 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package objstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// S3Client is the subset of the [s3.Client] API used to upload objects.
+type S3Client interface {
+	PutObject(context.Context, *s3.PutObjectInput, ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	CreateMultipartUpload(context.Context, *s3.CreateMultipartUploadInput, ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error)
+	UploadPart(context.Context, *s3.UploadPartInput, ...func(*s3.Options)) (*s3.UploadPartOutput, error)
+	CompleteMultipartUpload(context.Context, *s3.CompleteMultipartUploadInput, ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error)
+	AbortMultipartUpload(context.Context, *s3.AbortMultipartUploadInput, ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error)
+}
+
+// s3Writer buffers what's written to it into parts. Outputs that fit in a
+// single part are uploaded with one request when closed; larger ones are
+// uploaded with a multipart upload, a part at a time as they fill up.
+type s3Writer struct {
+	ctx         context.Context
+	client      S3Client
+	bucket, key string
+	partSize    int
+	attempts    int
+
+	buf []byte
+	// uploadID is set once a multipart upload has been started.
+	uploadID *string
+	parts    []types.CompletedPart
+	// err is the error that broke the upload, if any.
+	err  error
+	done bool
+}
+
+func createS3(ctx context.Context, bucket, key string, c Config) (*s3Writer, error) {
+	client := c.S3Client
+	if client == nil {
+		awsConf, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("loading AWS configuration: %w", err)
+		}
+		client = s3.NewFromConfig(awsConf)
+	}
+	return &s3Writer{
+		ctx:      ctx,
+		client:   client,
+		bucket:   bucket,
+		key:      key,
+		partSize: c.partSize(),
+		attempts: c.maxAttempts(),
+	}, nil
+}
+
+func (w *s3Writer) url() string { return "s3://" + w.bucket + "/" + w.key }
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	switch {
+	case w.err != nil:
+		return 0, w.err
+	case w.done:
+		return 0, errors.New("write after close")
+	case w.buf == nil:
+		w.buf = make([]byte, 0, w.partSize)
+	}
+
+	written := 0
+	for len(p) > 0 {
+		n := min(w.partSize-len(w.buf), len(p))
+		w.buf = append(w.buf, p[:n]...)
+		p = p[n:]
+		written += n
+		if len(w.buf) == w.partSize {
+			if err := w.uploadPart(); err != nil {
+				w.err = err
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// uploadPart uploads the buffered data as the next part of a multipart
+// upload, starting the upload if needed.
+func (w *s3Writer) uploadPart() error {
+	if w.uploadID == nil {
+		resp, err := w.client.CreateMultipartUpload(w.ctx, &s3.CreateMultipartUploadInput{
+			Bucket: aws.String(w.bucket),
+			Key:    aws.String(w.key),
+		})
+		if err != nil {
+			return fmt.Errorf("starting the upload to %s: %w", w.url(), err)
+		}
+		w.uploadID = resp.UploadId
+	}
+
+	num := aws.Int32(int32(len(w.parts) + 1))
+	var etag *string
+	if err := withRetries(w.ctx, w.attempts, func() error {
+		resp, err := w.client.UploadPart(w.ctx, &s3.UploadPartInput{
+			Bucket:     aws.String(w.bucket),
+			Key:        aws.String(w.key),
+			UploadId:   w.uploadID,
+			PartNumber: num,
+			Body:       bytes.NewReader(w.buf),
+		})
+		if err != nil {
+			return err
+		}
+		etag = resp.ETag
+		return nil
+	}); err != nil {
+		return fmt.Errorf("uploading part %d of %s: %w", *num, w.url(), err)
+	}
+	w.parts = append(w.parts, types.CompletedPart{ETag: etag, PartNumber: num})
+	w.buf = w.buf[:0]
+	return nil
+}
+
+func (w *s3Writer) Close() error {
+	if w.done {
+		return nil
+	}
+	if w.err != nil {
+		return errors.Join(w.err, w.Abort())
+	}
+
+	if w.uploadID == nil {
+		w.done = true
+		if err := withRetries(w.ctx, w.attempts, func() error {
+			_, err := w.client.PutObject(w.ctx, &s3.PutObjectInput{
+				Bucket: aws.String(w.bucket),
+				Key:    aws.String(w.key),
+				Body:   bytes.NewReader(w.buf),
+			})
+			return err
+		}); err != nil {
+			return fmt.Errorf("uploading %s: %w", w.url(), err)
+		}
+		return nil
+	}
+
+	if len(w.buf) > 0 {
+		if err := w.uploadPart(); err != nil {
+			return errors.Join(err, w.Abort())
+		}
+	}
+	if err := withRetries(w.ctx, w.attempts, func() error {
+		_, err := w.client.CompleteMultipartUpload(w.ctx, &s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(w.bucket),
+			Key:             aws.String(w.key),
+			UploadId:        w.uploadID,
+			MultipartUpload: &types.CompletedMultipartUpload{Parts: w.parts},
+		})
+		return err
+	}); err != nil {
+		return errors.Join(fmt.Errorf("completing the upload to %s: %w", w.url(), err), w.Abort())
+	}
+	w.done = true
+	return nil
+}
+
+func (w *s3Writer) Abort() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+	if w.uploadID == nil {
+		return nil
+	}
+	// S3 keeps the parts uploaded so far, and bills for them, until the
+	// upload is aborted, so do so even if the upload was canceled.
+	if _, err := w.client.AbortMultipartUpload(context.WithoutCancel(w.ctx), &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(w.bucket),
+		Key:      aws.String(w.key),
+		UploadId: w.uploadID,
+	}); err != nil {
+		return fmt.Errorf("aborting the upload to %s: %w", w.url(), err)
+	}
+	return nil
+}
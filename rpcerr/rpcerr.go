@@ -0,0 +1,285 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package rpcerr decodes the details attached to errors returned by the
+// Spacetime APIs into typed values, so clients can react to why a call failed
+// without matching on error messages.
+//
+// The APIs attach the standard [google.rpc error details] to their errors.
+// [FromError] decodes the ones clients are most likely to act on:
+//
+//	if d, ok := rpcerr.FromError(err); ok {
+//		if delay, ok := d.RetryDelay(); ok {
+//			time.Sleep(delay)
+//			// ... and retry.
+//		}
+//		if br, ok := d.BadRequest(); ok {
+//			for _, v := range br.ForField("platform.name") {
+//				fmt.Println(v.Description)
+//			}
+//		}
+//	}
+//
+// [google.rpc error details]: https://github.com/googleapis/googleapis/blob/master/google/rpc/error_details.proto
+package rpcerr
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// Details are the decoded details of a gRPC status. A zero Details has no
+// details and the OK code.
+type Details struct {
+	code    codes.Code
+	message string
+
+	quotaFailure        *QuotaFailure
+	preconditionFailure *PreconditionFailure
+	badRequest          *BadRequest
+	retryInfo           *RetryInfo
+	other               []*anypb.Any
+}
+
+// FromError decodes the details of `err`, which may wrap a gRPC error. Like
+// [status.FromError], it reports whether `err` was produced by gRPC; if it
+// wasn't, the returned Details have the Unknown code, `err`'s message, and
+// no details.
+func FromError(err error) (*Details, bool) {
+	st, ok := status.FromError(err)
+	return FromStatus(st), ok
+}
+
+// FromStatus decodes the details of `st`. Details of the same type are
+// merged: the violations of each are concatenated in order, and the first
+// RetryInfo wins. Details that can't be decoded are returned by
+// [Details.Other].
+func FromStatus(st *status.Status) *Details {
+	d := &Details{code: st.Code(), message: st.Message()}
+	for _, a := range st.Proto().GetDetails() {
+		if !d.decode(a) {
+			d.other = append(d.other, a)
+		}
+	}
+	return d
+}
+
+// decode adds the detail in `a` to `d`, and reports whether it could.
+func (d *Details) decode(a *anypb.Any) bool {
+	switch {
+	case a.MessageIs(&errdetails.QuotaFailure{}):
+		m := &errdetails.QuotaFailure{}
+		if a.UnmarshalTo(m) != nil {
+			return false
+		}
+		if d.quotaFailure == nil {
+			d.quotaFailure = &QuotaFailure{}
+		}
+		for _, v := range m.GetViolations() {
+			d.quotaFailure.Violations = append(d.quotaFailure.Violations, QuotaViolation{
+				Subject:     v.GetSubject(),
+				Description: v.GetDescription(),
+			})
+		}
+
+	case a.MessageIs(&errdetails.PreconditionFailure{}):
+		m := &errdetails.PreconditionFailure{}
+		if a.UnmarshalTo(m) != nil {
+			return false
+		}
+		if d.preconditionFailure == nil {
+			d.preconditionFailure = &PreconditionFailure{}
+		}
+		for _, v := range m.GetViolations() {
+			d.preconditionFailure.Violations = append(d.preconditionFailure.Violations, PreconditionViolation{
+				Type:        v.GetType(),
+				Subject:     v.GetSubject(),
+				Description: v.GetDescription(),
+			})
+		}
+
+	case a.MessageIs(&errdetails.BadRequest{}):
+		m := &errdetails.BadRequest{}
+		if a.UnmarshalTo(m) != nil {
+			return false
+		}
+		if d.badRequest == nil {
+			d.badRequest = &BadRequest{}
+		}
+		for _, v := range m.GetFieldViolations() {
+			d.badRequest.FieldViolations = append(d.badRequest.FieldViolations, FieldViolation{
+				Field:       v.GetField(),
+				Description: v.GetDescription(),
+			})
+		}
+
+	case a.MessageIs(&errdetails.RetryInfo{}):
+		m := &errdetails.RetryInfo{}
+		if a.UnmarshalTo(m) != nil {
+			return false
+		}
+		if d.retryInfo == nil {
+			d.retryInfo = &RetryInfo{Delay: m.GetRetryDelay().AsDuration()}
+		}
+
+	default:
+		return false
+	}
+	return true
+}
+
+// Code returns the status code of the error.
+func (d *Details) Code() codes.Code { return d.code }
+
+// Message returns the message of the error, without its details.
+func (d *Details) Message() string { return d.message }
+
+// QuotaFailure returns the quota checks that failed, if the error has any.
+func (d *Details) QuotaFailure() (*QuotaFailure, bool) {
+	return d.quotaFailure, d.quotaFailure != nil
+}
+
+// PreconditionFailure returns the preconditions that failed, if the error
+// has any.
+func (d *Details) PreconditionFailure() (*PreconditionFailure, bool) {
+	return d.preconditionFailure, d.preconditionFailure != nil
+}
+
+// BadRequest returns the fields of the request that were invalid, if the
+// error has any.
+func (d *Details) BadRequest() (*BadRequest, bool) {
+	return d.badRequest, d.badRequest != nil
+}
+
+// RetryInfo returns when the call may be retried, if the server said.
+func (d *Details) RetryInfo() (*RetryInfo, bool) {
+	return d.retryInfo, d.retryInfo != nil
+}
+
+// RetryDelay returns how long clients should wait before retrying the call,
+// if the server said.
+func (d *Details) RetryDelay() (time.Duration, bool) {
+	if d.retryInfo == nil {
+		return 0, false
+	}
+	return d.retryInfo.Delay, true
+}
+
+// Other returns the details of types this package doesn't decode, in the
+// order they were attached to the error.
+func (d *Details) Other() []*anypb.Any {
+	return slices.Clone(d.other)
+}
+
+// QuotaFailure describes how a quota check failed, e.g. because a project
+// exceeded its rate of requests.
+type QuotaFailure struct {
+	Violations []QuotaViolation
+}
+
+// QuotaViolation is a single quota that was exceeded.
+type QuotaViolation struct {
+	// Subject is what the quota applies to, e.g. "clientip:10.0.0.1" or
+	// "project:example".
+	Subject     string
+	Description string
+}
+
+// Subjects returns the subjects of the violations, without duplicates, in
+// the order they first appear.
+func (q *QuotaFailure) Subjects() []string {
+	subjects := []string{}
+	for _, v := range q.Violations {
+		if !slices.Contains(subjects, v.Subject) {
+			subjects = append(subjects, v.Subject)
+		}
+	}
+	return subjects
+}
+
+// PreconditionFailure describes the preconditions of the call that weren't
+// met, e.g. because an entity was modified since it was read.
+type PreconditionFailure struct {
+	Violations []PreconditionViolation
+}
+
+// PreconditionViolation is a single precondition that wasn't met.
+type PreconditionViolation struct {
+	// Type is a service-specific category of the violation, e.g. "TOS" or
+	// "VERSION".
+	Type string
+	// Subject is what failed the check, relative to Type.
+	Subject     string
+	Description string
+}
+
+// OfType returns the violations of type `t`.
+func (p *PreconditionFailure) OfType(t string) []PreconditionViolation {
+	return filter(p.Violations, func(v PreconditionViolation) bool { return v.Type == t })
+}
+
+// BadRequest describes the fields of the request that were invalid.
+type BadRequest struct {
+	FieldViolations []FieldViolation
+}
+
+// FieldViolation is a single field that was invalid.
+type FieldViolation struct {
+	// Field is the path to the field, e.g. "entity.platform.name" or
+	// "link.endpoints[1].interface_id".
+	Field       string
+	Description string
+}
+
+// Fields returns the paths of the invalid fields, without duplicates, in the
+// order they first appear.
+func (b *BadRequest) Fields() []string {
+	fields := []string{}
+	for _, v := range b.FieldViolations {
+		if !slices.Contains(fields, v.Field) {
+			fields = append(fields, v.Field)
+		}
+	}
+	return fields
+}
+
+// ForField returns the violations of the field at `path` and of the fields
+// nested within it, so that "platform" matches "platform.name".
+func (b *BadRequest) ForField(path string) []FieldViolation {
+	return filter(b.FieldViolations, func(v FieldViolation) bool {
+		rest, ok := strings.CutPrefix(v.Field, path)
+		return ok && (rest == "" || rest[0] == '.' || rest[0] == '[')
+	})
+}
+
+// RetryInfo is how long the server asked clients to wait before retrying.
+type RetryInfo struct {
+	Delay time.Duration
+}
+
+func filter[T any](s []T, keep func(T) bool) []T {
+	kept := []T{}
+	for _, v := range s {
+		if keep(v) {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
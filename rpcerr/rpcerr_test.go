@@ -0,0 +1,147 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rpcerr
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func withDetails(t *testing.T, st *status.Status, details ...protoadapt.MessageV1) error {
+	t.Helper()
+
+	st, err := st.WithDetails(details...)
+	if err != nil {
+		t.Fatalf("adding details: %v", err)
+	}
+	return st.Err()
+}
+
+func TestFromError(t *testing.T) {
+	t.Parallel()
+
+	err := withDetails(t, status.New(codes.InvalidArgument, "bad entity"),
+		&errdetails.BadRequest{FieldViolations: []*errdetails.BadRequest_FieldViolation{
+			{Field: "platform.name", Description: "must not be empty"},
+			{Field: "platform.transceiver_model[0].id", Description: "must be unique"},
+		}},
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(3 * time.Second)},
+		&errdetails.QuotaFailure{Violations: []*errdetails.QuotaFailure_Violation{
+			{Subject: "project:example", Description: "too many writes"},
+		}},
+		&errdetails.BadRequest{FieldViolations: []*errdetails.BadRequest_FieldViolation{
+			{Field: "platform.name", Description: "must be shorter"},
+		}},
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(time.Minute)},
+		&errdetails.PreconditionFailure{Violations: []*errdetails.PreconditionFailure_Violation{
+			{Type: "VERSION", Subject: "PLATFORM_DEFINITION/a", Description: "modified since it was read"},
+			{Type: "TOS", Subject: "project:example", Description: "terms not accepted"},
+		}},
+		wrapperspb.String("unknown"),
+	)
+
+	d, ok := FromError(fmt.Errorf("creating entity: %w", err))
+	if !ok {
+		t.Fatalf("FromError(%v) didn't recognize a gRPC error", err)
+	}
+	if d.Code() != codes.InvalidArgument {
+		t.Errorf("Code() = %v, want %v", d.Code(), codes.InvalidArgument)
+	}
+
+	br, ok := d.BadRequest()
+	if !ok {
+		t.Fatal("BadRequest() found no details")
+	}
+	if diff := cmp.Diff([]string{"platform.name", "platform.transceiver_model[0].id"}, br.Fields()); diff != "" {
+		t.Errorf("Fields() mismatch (-want +got):\n%s", diff)
+	}
+	wantNameViolations := []FieldViolation{
+		{Field: "platform.name", Description: "must not be empty"},
+		{Field: "platform.name", Description: "must be shorter"},
+	}
+	if diff := cmp.Diff(wantNameViolations, br.ForField("platform.name")); diff != "" {
+		t.Errorf(`ForField("platform.name") mismatch (-want +got):\n%s`, diff)
+	}
+	if got := br.ForField("platform"); len(got) != 3 {
+		t.Errorf(`ForField("platform") = %v, want all 3 violations`, got)
+	}
+	if got := br.ForField("platform.transceiver_model"); len(got) != 1 {
+		t.Errorf(`ForField("platform.transceiver_model") = %v, want 1 violation`, got)
+	}
+	if got := br.ForField("plat"); len(got) != 0 {
+		t.Errorf(`ForField("plat") = %v, want no violations`, got)
+	}
+
+	if delay, ok := d.RetryDelay(); !ok || delay != 3*time.Second {
+		t.Errorf("RetryDelay() = %v, %v, want the first delay (3s)", delay, ok)
+	}
+
+	qf, ok := d.QuotaFailure()
+	if !ok {
+		t.Fatal("QuotaFailure() found no details")
+	}
+	if diff := cmp.Diff([]string{"project:example"}, qf.Subjects()); diff != "" {
+		t.Errorf("Subjects() mismatch (-want +got):\n%s", diff)
+	}
+
+	pf, ok := d.PreconditionFailure()
+	if !ok {
+		t.Fatal("PreconditionFailure() found no details")
+	}
+	wantVersion := []PreconditionViolation{{Type: "VERSION", Subject: "PLATFORM_DEFINITION/a", Description: "modified since it was read"}}
+	if diff := cmp.Diff(wantVersion, pf.OfType("VERSION")); diff != "" {
+		t.Errorf(`OfType("VERSION") mismatch (-want +got):\n%s`, diff)
+	}
+
+	if other := d.Other(); len(other) != 1 || !other[0].MessageIs(&wrapperspb.StringValue{}) {
+		t.Errorf("Other() = %v, want the StringValue detail", other)
+	}
+}
+
+func TestFromError_withoutDetails(t *testing.T) {
+	t.Parallel()
+
+	d, ok := FromError(status.Error(codes.NotFound, "no such entity"))
+	if !ok || d.Code() != codes.NotFound || d.Message() != "no such entity" {
+		t.Errorf("FromError() = %v (code %v, message %q), %v, want a NotFound error", d, d.Code(), d.Message(), ok)
+	}
+	if _, ok := d.BadRequest(); ok {
+		t.Error("BadRequest() found details, want none")
+	}
+	if _, ok := d.RetryDelay(); ok {
+		t.Error("RetryDelay() found a delay, want none")
+	}
+	if _, ok := d.QuotaFailure(); ok {
+		t.Error("QuotaFailure() found details, want none")
+	}
+	if _, ok := d.PreconditionFailure(); ok {
+		t.Error("PreconditionFailure() found details, want none")
+	}
+
+	d, ok = FromError(errors.New("not from gRPC"))
+	if ok || d.Code() != codes.Unknown || d.Message() != "not from gRPC" {
+		t.Errorf("FromError() of a plain error = %v (code %v, message %q), %v, want Unknown and not ok", d, d.Code(), d.Message(), ok)
+	}
+}
@@ -0,0 +1,307 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package rpclog provides gRPC client interceptors that log every request
+// and response, to make failed calls to the Spacetime APIs easier to debug.
+//
+// Messages are logged as JSON using [log/slog]. Before they're logged, the
+// values of authentication headers and of fields that look like they hold
+// credentials or key material are replaced with [Redacted], so logs can be
+// shared without leaking secrets.
+//
+// Install the interceptors with [google.golang.org/grpc.WithChainUnaryInterceptor]
+// and [google.golang.org/grpc.WithChainStreamInterceptor]:
+//
+//	opts := rpclog.Options{Logger: slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))}
+//	conn, err := grpc.NewClient(addr,
+//		grpc.WithChainUnaryInterceptor(rpclog.UnaryClientInterceptor(opts)),
+//		grpc.WithChainStreamInterceptor(rpclog.StreamClientInterceptor(opts)),
+//		...)
+package rpclog
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Redacted replaces the values of sensitive headers and fields.
+const Redacted = "REDACTED"
+
+var (
+	// sensitiveHeaders are metadata keys whose values are always redacted.
+	sensitiveHeaders = []string{"authorization", "cookie", "proxy-authorization", "set-cookie", "x-api-key"}
+
+	// sensitiveFieldParts are substrings of the names of fields whose values
+	// are always redacted.
+	sensitiveFieldParts = []string{"access_token", "api_key", "credential", "id_token", "passphrase", "password", "priv_key", "private_key", "refresh_token", "secret"}
+
+	// sensitiveValuePatterns match values that are redacted regardless of the
+	// field they're in: PEM-encoded private keys, bearer tokens, and JWTs.
+	sensitiveValuePatterns = []*regexp.Regexp{
+		regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----`),
+		regexp.MustCompile(`(?i)^bearer\s`),
+		regexp.MustCompile(`^eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*$`),
+	}
+)
+
+// Options configures the interceptors.
+type Options struct {
+	// Logger receives a record for each call. Defaults to [slog.Default].
+	Logger *slog.Logger
+	// Level is the level calls are logged at. Defaults to [slog.LevelDebug].
+	Level slog.Leveler
+	// RedactFields lists the names of additional proto fields whose values
+	// should be redacted.
+	RedactFields []string
+}
+
+func (o Options) logger() *slog.Logger {
+	if o.Logger != nil {
+		return o.Logger
+	}
+	return slog.Default()
+}
+
+func (o Options) level() slog.Level {
+	if o.Level != nil {
+		return o.Level.Level()
+	}
+	return slog.LevelDebug
+}
+
+// UnaryClientInterceptor returns an interceptor that logs the request,
+// response, metadata, and status of each unary call once it completes.
+func UnaryClientInterceptor(opts Options) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, callOpts ...grpc.CallOption) error {
+		log := opts.logger()
+		if !log.Enabled(ctx, opts.level()) {
+			return invoker(ctx, method, req, reply, cc, callOpts...)
+		}
+
+		header, trailer := metadata.MD{}, metadata.MD{}
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, append(callOpts, grpc.Header(&header), grpc.Trailer(&trailer))...)
+
+		attrs := []slog.Attr{
+			slog.String("method", method),
+			slog.Duration("duration", time.Since(start)),
+			slog.String("code", status.Code(err).String()),
+			opts.metadataAttr("request_metadata", outgoingMetadata(ctx)),
+			opts.messageAttr("request", req),
+		}
+		if err != nil {
+			attrs = append(attrs, slog.String("error", err.Error()))
+		} else {
+			attrs = append(attrs, opts.messageAttr("response", reply))
+		}
+		attrs = append(attrs, opts.metadataAttr("response_header", header), opts.metadataAttr("response_trailer", trailer))
+		log.LogAttrs(ctx, opts.level(), "grpc call", attrs...)
+		return err
+	}
+}
+
+// StreamClientInterceptor returns an interceptor that logs each message sent
+// and received on a stream, followed by the stream's final status.
+func StreamClientInterceptor(opts Options) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, callOpts ...grpc.CallOption) (grpc.ClientStream, error) {
+		log := opts.logger()
+		if !log.Enabled(ctx, opts.level()) {
+			return streamer(ctx, desc, cc, method, callOpts...)
+		}
+
+		start := time.Now()
+		cs, err := streamer(ctx, desc, cc, method, callOpts...)
+		if err != nil {
+			log.LogAttrs(ctx, opts.level(), "grpc stream",
+				slog.String("method", method),
+				slog.Duration("duration", time.Since(start)),
+				slog.String("code", status.Code(err).String()),
+				slog.String("error", err.Error()),
+				opts.metadataAttr("request_metadata", outgoingMetadata(ctx)),
+			)
+			return nil, err
+		}
+		return &loggingStream{ClientStream: cs, opts: opts, log: log, method: method, start: start}, nil
+	}
+}
+
+type loggingStream struct {
+	grpc.ClientStream
+
+	opts   Options
+	log    *slog.Logger
+	method string
+	start  time.Time
+}
+
+func (s *loggingStream) SendMsg(m any) error {
+	err := s.ClientStream.SendMsg(m)
+	s.log.LogAttrs(s.Context(), s.opts.level(), "grpc stream send",
+		slog.String("method", s.method),
+		s.opts.messageAttr("request", m),
+	)
+	return err
+}
+
+func (s *loggingStream) RecvMsg(m any) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err == nil {
+		s.log.LogAttrs(s.Context(), s.opts.level(), "grpc stream recv",
+			slog.String("method", s.method),
+			s.opts.messageAttr("response", m),
+		)
+		return nil
+	}
+
+	attrs := []slog.Attr{
+		slog.String("method", s.method),
+		slog.Duration("duration", time.Since(s.start)),
+	}
+	if errors.Is(err, io.EOF) {
+		attrs = append(attrs, slog.String("code", "OK"))
+	} else {
+		attrs = append(attrs, slog.String("code", status.Code(err).String()), slog.String("error", err.Error()))
+	}
+	if header, herr := s.Header(); herr == nil {
+		attrs = append(attrs, s.opts.metadataAttr("response_header", header))
+	}
+	attrs = append(attrs, s.opts.metadataAttr("response_trailer", s.Trailer()))
+	s.log.LogAttrs(s.Context(), s.opts.level(), "grpc stream end", attrs...)
+	return err
+}
+
+func outgoingMetadata(ctx context.Context) metadata.MD {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	return md
+}
+
+func (o Options) metadataAttr(key string, md metadata.MD) slog.Attr {
+	redacted := map[string][]string{}
+	for k, vs := range md {
+		if slices.Contains(sensitiveHeaders, strings.ToLower(k)) {
+			redacted[k] = []string{Redacted}
+			continue
+		}
+		redacted[k] = make([]string, len(vs))
+		for i, v := range vs {
+			redacted[k][i] = redactValue(v)
+		}
+	}
+	return slog.Any(key, redacted)
+}
+
+// messageAttr returns an attribute holding the redacted JSON representation
+// of `m`. The JSON is embedded as-is by [slog.JSONHandler].
+func (o Options) messageAttr(key string, m any) slog.Attr {
+	pm, ok := m.(proto.Message)
+	if !ok {
+		return slog.Any(key, m)
+	}
+	js, err := protojson.Marshal(o.Redact(pm))
+	if err != nil {
+		return slog.String(key, "unable to marshal message: "+err.Error())
+	}
+	return slog.Any(key, json.RawMessage(js))
+}
+
+// Redact returns a copy of `m` with the same fields redacted as in logged
+// messages, for sharing messages outside of a log.
+func (o Options) Redact(m proto.Message) proto.Message {
+	m = proto.Clone(m)
+	o.redactMessage(m.ProtoReflect())
+	return m
+}
+
+func (o Options) redactMessage(m protoreflect.Message) {
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		sensitive := o.isSensitiveField(fd)
+
+		switch {
+		case fd.IsMap():
+			mv := v.Map()
+			mv.Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+				if nv, ok := o.redactValue(fd.MapValue(), v, sensitive); ok {
+					mv.Set(k, nv)
+				}
+				return true
+			})
+		case fd.IsList():
+			lv := v.List()
+			for i := 0; i < lv.Len(); i++ {
+				if nv, ok := o.redactValue(fd, lv.Get(i), sensitive); ok {
+					lv.Set(i, nv)
+				}
+			}
+		default:
+			if nv, ok := o.redactValue(fd, v, sensitive); ok {
+				m.Set(fd, nv)
+			}
+		}
+		return true
+	})
+}
+
+// redactValue returns the redacted form of a single field value, and whether
+// it differs from `v`. Messages are redacted in place.
+func (o Options) redactValue(fd protoreflect.FieldDescriptor, v protoreflect.Value, sensitive bool) (protoreflect.Value, bool) {
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		o.redactMessage(v.Message())
+	case protoreflect.StringKind:
+		if sensitive || redactValue(v.String()) != v.String() {
+			return protoreflect.ValueOfString(Redacted), true
+		}
+	case protoreflect.BytesKind:
+		if sensitive || redactValue(string(v.Bytes())) != string(v.Bytes()) {
+			return protoreflect.ValueOfBytes([]byte(Redacted)), true
+		}
+	}
+	return v, false
+}
+
+func (o Options) isSensitiveField(fd protoreflect.FieldDescriptor) bool {
+	name := strings.ToLower(string(fd.Name()))
+	if slices.Contains(o.RedactFields, string(fd.Name())) {
+		return true
+	}
+	for _, part := range sensitiveFieldParts {
+		if strings.Contains(name, part) {
+			return true
+		}
+	}
+	return false
+}
+
+func redactValue(v string) string {
+	for _, re := range sensitiveValuePatterns {
+		if re.MatchString(v) {
+			return Redacted
+		}
+	}
+	return v
+}
@@ -0,0 +1,157 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+	"aalyria.com/spacetime/github/tools/forwarder"
+	"aalyria.com/spacetime/github/tools/nbictl"
+)
+
+// stringsFlag is a flag that may be repeated, or given a comma-separated
+// list of values.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *stringsFlag) Set(v string) error {
+	*f = append(*f, strings.Split(v, ",")...)
+	return nil
+}
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "fatal error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	var sinkURLs, types, changes, typeWorkers stringsFlag
+	flag.Var(&sinkURLs, "sink", "A destination for the events: http(s)://HOST/PATH for a webhook, kafka://BROKER[,BROKER...]/TOPIC or pubsub://PROJECT/TOPIC. May be repeated.")
+	flag.Var(&types, "type", "An entity type to forward the changes of, such as NETWORK_NODE. May be repeated.")
+	flag.Var(&changes, "change", "A kind of change to forward: created, updated or deleted. May be repeated. Defaults to all of them.")
+	filter := flag.String("filter", "", "A CEL expression over the fields of the Entity message that selects the changes to forward, such as: platform.type == \"GROUND_STATION\". Deletes are matched against the last version of the entity.")
+	configDir := flag.String("config_dir", "", "The nbictl configuration directory to read the NBI connection settings from. Defaults to that of nbictl.")
+	contextName := flag.String("context", "", "The nbictl context to connect to the NBI with. May be omitted if the configuration defines a single context.")
+	pollInterval := flag.Duration("poll_interval", forwarder.DefaultPollInterval, "How often to poll the NBI for changes.")
+	settleDelay := flag.Duration("settle_delay", forwarder.DefaultSettleDelay, "How far behind the present to poll, so that changes are visible to reads before they're looked for.")
+	maxAttempts := flag.Int("max_attempts", forwarder.DefaultMaxAttempts, "How many times to attempt delivering an event to a sink before dropping it.")
+	workers := flag.Int("workers", forwarder.DefaultWorkers, "How many events of each entity type to deliver at once. The events of an entity are always delivered one at a time, in commit order.")
+	flag.Var(&typeWorkers, "type_workers", "TYPE=N to deliver N events of the entity type TYPE at once instead of --workers, such as NETWORK_NODE=16. May be repeated.")
+	catchUpChunk := flag.Duration("catch_up_chunk", forwarder.DefaultCatchUpChunk, "The longest interval of commit times to list the changes of at once, when catching up after an outage.")
+	maxCatchUp := flag.Duration("max_catch_up", 0, "If set, how far behind to replay the changes missed during an outage. Further behind, the current entities are relisted instead, with resync events.")
+	stateFile := flag.String("state_file", "", "A file in which to record how far the forwarder got, so that it resumes from there after a restart. If unset, only the changes made after it starts are forwarded.")
+	secretFile := flag.String("webhook_secret_file", "", "A file holding the secret used to sign the requests made to webhooks, in the X-Spacetime-Signature header.")
+	flag.Parse()
+
+	if len(sinkURLs) == 0 {
+		return errors.New("at least one --sink is required")
+	}
+	opts := forwarder.Options{
+		Filter:       *filter,
+		Changes:      changes,
+		PollInterval: *pollInterval,
+		SettleDelay:  *settleDelay,
+		MaxAttempts:  *maxAttempts,
+		Workers:      *workers,
+		CatchUpChunk: *catchUpChunk,
+		MaxCatchUp:   *maxCatchUp,
+		StateFile:    *stateFile,
+		Logger:       slog.New(slog.NewJSONHandler(os.Stderr, nil)),
+	}
+	for _, t := range types {
+		typ, err := parseEntityType(t)
+		if err != nil {
+			return err
+		}
+		opts.Types = append(opts.Types, typ)
+	}
+	for _, tw := range typeWorkers {
+		t, n, ok := strings.Cut(tw, "=")
+		if !ok {
+			return fmt.Errorf("invalid --type_workers %q, expected TYPE=N", tw)
+		}
+		typ, err := parseEntityType(t)
+		if err != nil {
+			return err
+		}
+		if opts.TypeWorkers == nil {
+			opts.TypeWorkers = map[nbipb.EntityType]int{}
+		}
+		if opts.TypeWorkers[typ], err = strconv.Atoi(n); err != nil {
+			return fmt.Errorf("invalid --type_workers %q: %w", tw, err)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	sinkOpts := forwarder.SinkOptions{}
+	if *secretFile != "" {
+		secret, err := os.ReadFile(*secretFile)
+		if err != nil {
+			return fmt.Errorf("reading the webhook secret: %w", err)
+		}
+		sinkOpts.WebhookSecret = []byte(strings.TrimSpace(string(secret)))
+	}
+	sinks := []forwarder.Sink{}
+	defer func() {
+		for _, s := range sinks {
+			s.Close()
+		}
+	}()
+	for _, u := range sinkURLs {
+		s, err := forwarder.OpenSink(ctx, u, sinkOpts)
+		if err != nil {
+			return err
+		}
+		sinks = append(sinks, s)
+	}
+
+	conn, err := nbictl.Dial(ctx, *configDir, *contextName)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	f, err := forwarder.New(nbipb.NewNetOpsClient(conn), sinks, opts)
+	if err != nil {
+		return err
+	}
+	if err := f.Run(ctx); !errors.Is(err, context.Canceled) {
+		return err
+	}
+	return nil
+}
+
+func parseEntityType(t string) (nbipb.EntityType, error) {
+	v, ok := nbipb.EntityType_value[strings.ToUpper(t)]
+	if !ok || v == int32(nbipb.EntityType_ENTITY_TYPE_UNSPECIFIED) {
+		return 0, fmt.Errorf("unknown entity type %q", t)
+	}
+	return nbipb.EntityType(v), nil
+}
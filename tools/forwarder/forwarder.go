@@ -0,0 +1,607 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package forwarder forwards the changes made to the entities of a Spacetime
+// instance to HTTP webhooks, Kafka topics and Pub/Sub topics, so that
+// downstream systems, such as inventories or ticketing systems, can stay in
+// sync with the network model.
+//
+// The NBI has no streaming watch API, so changes are found by polling
+// ListEntitiesOverTime for the versions committed since the last poll. Each
+// version is delivered at least once to every sink as an [Event], in commit
+// order for each entity, while the events of different entities are
+// delivered in parallel. After a long outage, the missed versions are listed
+// one chunk of time at a time or, past Options.MaxCatchUp, skipped in favor of
+// a relist of the current entities, announced by a [ChangeResync] event.
+package forwarder
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	commonpb "aalyria.com/spacetime/api/common"
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+	outputv1 "aalyria.com/spacetime/github/tools/nbictl/output/v1"
+)
+
+const (
+	DefaultPollInterval = 5 * time.Second
+	// DefaultSettleDelay is how far behind the present the forwarder polls,
+	// since a commit may not be visible to reads as soon as it's assigned a
+	// timestamp.
+	DefaultSettleDelay = 2 * time.Second
+	DefaultMaxAttempts = 5
+	DefaultWorkers     = 4
+	// DefaultCatchUpChunk is the longest interval of commit times a poll
+	// lists the versions of at once.
+	DefaultCatchUpChunk = time.Hour
+
+	// ChangeResync is the change of the events that relist the entities of a
+	// type, when the forwarder is too far behind to replay the changes it
+	// missed. The relist starts with an event without an EntityID, after
+	// which the receivers should discard what they know of the entities of
+	// that type: it's followed by an event for each current entity.
+	ChangeResync = "resync"
+
+	// retryBackoff is how long to wait before retrying a delivery the first
+	// time. It doubles with each retry, up to retryMaxBackoff.
+	retryBackoff    = 500 * time.Millisecond
+	retryMaxBackoff = 30 * time.Second
+)
+
+// Event is a change to an entity, as delivered to the sinks in JSON.
+type Event struct {
+	// ID uniquely identifies the event, so that receivers can discard the
+	// events delivered more than once.
+	ID string `json:"id"`
+	// Change is one of outputv1.ChangeCreated, outputv1.ChangeUpdated,
+	// outputv1.ChangeDeleted and ChangeResync.
+	Change     string    `json:"change"`
+	EntityType string    `json:"entityType"`
+	EntityID   string    `json:"entityId"`
+	CommitTime time.Time `json:"commitTime"`
+	// CommitTimestamp is the commit_timestamp of the version, in
+	// microseconds since the Unix epoch.
+	CommitTimestamp int64 `json:"commitTimestamp"`
+	// ModifiedBy identifies who committed the version. It's absent if the
+	// server didn't record it.
+	ModifiedBy string `json:"modifiedBy,omitempty"`
+	// Entity is the entity as committed, in the protobuf JSON format. It's
+	// absent for deletes.
+	Entity json.RawMessage `json:"entity,omitempty"`
+
+	// matched is the version of the entity the filter is evaluated against:
+	// the committed version, or the last version before a delete.
+	matched *nbipb.Entity
+}
+
+// Options configure a Forwarder.
+type Options struct {
+	// Types are the entity types to forward the changes of. At least one
+	// is required.
+	Types []nbipb.EntityType
+	// Filter, if set, is a CEL expression over the fields of the Entity
+	// message that selects the changes to forward. Deletes are matched
+	// against the last version of the entity.
+	Filter string
+	// Changes are the kinds of changes to forward (see Event.Change).
+	// Defaults to all of them. ChangeResync events are always forwarded.
+	Changes []string
+	// PollInterval defaults to DefaultPollInterval.
+	PollInterval time.Duration
+	// SettleDelay defaults to DefaultSettleDelay. Use a negative delay to
+	// poll up to the present.
+	SettleDelay time.Duration
+	// MaxAttempts is how many times a delivery is attempted before the
+	// event is dropped for that sink. Defaults to DefaultMaxAttempts.
+	MaxAttempts int
+	// Workers is how many events of each entity type are delivered at
+	// once. The events of an entity are always delivered by the same
+	// worker, one at a time, in commit order. Defaults to DefaultWorkers.
+	Workers int
+	// TypeWorkers overrides Workers for some entity types.
+	TypeWorkers map[nbipb.EntityType]int
+	// CatchUpChunk is the longest interval of commit times listed at once,
+	// so that catching up after a long outage doesn't list every missed
+	// version in a single call. The progress is recorded in StateFile after
+	// each chunk. Defaults to DefaultCatchUpChunk.
+	CatchUpChunk time.Duration
+	// MaxCatchUp, if set, is how far behind the forwarder replays the
+	// changes it missed. Further behind, it relists the current entities
+	// instead, with ChangeResync events, and carries on from there.
+	MaxCatchUp time.Duration
+	// StateFile, if set, is where the forwarder records how far it got, so
+	// that it resumes from there after a restart rather than skipping the
+	// changes made while it was down.
+	StateFile string
+	// Logger defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// Forwarder polls the NBI for changes and delivers them to sinks.
+type Forwarder struct {
+	client  nbipb.NetOpsClient
+	sinks   []Sink
+	opts    Options
+	log     *slog.Logger
+	filter  cel.Program
+	changes map[string]bool
+	// cursor is the commit timestamp, in microseconds, from which the next
+	// poll looks for changes.
+	cursor int64
+}
+
+// New returns a Forwarder that delivers the changes read through `client` to
+// `sinks`.
+func New(client nbipb.NetOpsClient, sinks []Sink, opts Options) (*Forwarder, error) {
+	if len(opts.Types) == 0 {
+		return nil, errors.New("at least one entity type is required")
+	}
+	if len(opts.Changes) == 0 {
+		opts.Changes = []string{outputv1.ChangeCreated, outputv1.ChangeUpdated, outputv1.ChangeDeleted}
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = DefaultPollInterval
+	}
+	if opts.SettleDelay == 0 {
+		opts.SettleDelay = DefaultSettleDelay
+	}
+	if opts.MaxAttempts < 1 {
+		opts.MaxAttempts = DefaultMaxAttempts
+	}
+	if opts.Workers < 1 {
+		opts.Workers = DefaultWorkers
+	}
+	for t, n := range opts.TypeWorkers {
+		if n < 1 {
+			return nil, fmt.Errorf("the number of workers for %s must be at least 1, got %d", t, n)
+		}
+	}
+	if opts.CatchUpChunk <= 0 {
+		opts.CatchUpChunk = DefaultCatchUpChunk
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+
+	f := &Forwarder{client: client, sinks: sinks, opts: opts, log: opts.Logger, changes: map[string]bool{ChangeResync: true}}
+	for _, c := range opts.Changes {
+		switch c {
+		case outputv1.ChangeCreated, outputv1.ChangeUpdated, outputv1.ChangeDeleted:
+			f.changes[c] = true
+		default:
+			return nil, fmt.Errorf("unknown change %q, expected one of %s, %s or %s", c, outputv1.ChangeCreated, outputv1.ChangeUpdated, outputv1.ChangeDeleted)
+		}
+	}
+	if opts.Filter != "" {
+		var err error
+		if f.filter, err = compileFilter(opts.Filter); err != nil {
+			return nil, err
+		}
+	}
+
+	cursor, err := readCursor(opts.StateFile)
+	switch {
+	case err != nil:
+		return nil, err
+	case cursor > 0:
+		f.cursor = cursor
+	default:
+		// Only forward the changes made from now on, and persist the cursor
+		// right away so they're not lost if the forwarder restarts before
+		// its first poll gets to advance it.
+		f.cursor = f.pollEnd()
+		if err := writeCursor(opts.StateFile, f.cursor); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// compileFilter compiles `expr`, a CEL expression that selects the entities
+// for which it's true.
+func compileFilter(expr string) (cel.Program, error) {
+	env, err := cel.NewEnv(
+		cel.Types(&nbipb.Entity{}),
+		cel.DeclareContextProto((&nbipb.Entity{}).ProtoReflect().Descriptor()),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("creating the expression environment: %w", err)
+	}
+	ast, iss := env.Compile(expr)
+	if iss.Err() != nil {
+		return nil, fmt.Errorf("invalid filter: %w", iss.Err())
+	}
+	if ast.OutputType() != cel.BoolType {
+		return nil, fmt.Errorf("invalid filter: the expression must evaluate to a bool, not %s", ast.OutputType())
+	}
+	prg, err := env.Program(ast)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter: %w", err)
+	}
+	return prg, nil
+}
+
+// Run forwards changes until `ctx` is done. Failed polls are logged and
+// retried at the next poll, so Run only returns the context's error.
+func (f *Forwarder) Run(ctx context.Context) error {
+	ticker := time.NewTicker(f.opts.PollInterval)
+	defer ticker.Stop()
+	for {
+		if err := f.poll(ctx); err != nil && ctx.Err() == nil {
+			f.log.Error("polling for changes failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// pollEnd returns the commit timestamp up to which a poll started now looks
+// for changes.
+func (f *Forwarder) pollEnd() int64 {
+	return time.Now().Add(-max(f.opts.SettleDelay, 0)).UnixMicro()
+}
+
+// poll delivers the changes committed since the last poll, one chunk of
+// at most CatchUpChunk at a time, or relists the entities if the last poll is
+// more than MaxCatchUp ago. The cursor is only advanced once every change of
+// a chunk has been delivered or dropped.
+func (f *Forwarder) poll(ctx context.Context) error {
+	end := f.pollEnd()
+	if f.opts.MaxCatchUp > 0 && end-f.cursor > f.opts.MaxCatchUp.Microseconds() {
+		f.log.Warn("too far behind to replay the missed changes, relisting the entities", "since", time.UnixMicro(f.cursor).UTC())
+		if err := f.resync(ctx, end); err != nil {
+			return err
+		}
+		return f.advance(end)
+	}
+
+	for f.cursor < end {
+		chunkEnd := min(end, f.cursor+f.opts.CatchUpChunk.Microseconds())
+		if err := f.forward(ctx, f.cursor, chunkEnd); err != nil {
+			return err
+		}
+		if err := f.advance(chunkEnd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// advance moves the cursor to `cursor`, recording it in the state file.
+func (f *Forwarder) advance(cursor int64) error {
+	f.cursor = cursor
+	return writeCursor(f.opts.StateFile, cursor)
+}
+
+// forward delivers the changes committed in [start, end).
+func (f *Forwarder) forward(ctx context.Context, start, end int64) error {
+	events := []*Event{}
+	for _, typ := range f.opts.Types {
+		rsp, err := f.client.ListEntitiesOverTime(ctx, &nbipb.ListEntitiesOverTimeRequest{
+			Type: typ.Enum(),
+			// The interval starts just before `start` so that the versions
+			// that precede the first ones of the interval are included, to
+			// tell creates from updates.
+			Interval: &commonpb.TimeInterval{
+				StartTime: &commonpb.DateTime{UnixTimeUsec: proto.Int64(start - 1)},
+				EndTime:   &commonpb.DateTime{UnixTimeUsec: proto.Int64(end)},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("listing the changes to %s entities: %w", typ, err)
+		}
+		evs, err := changeEvents(rsp.GetEntities(), start, end)
+		if err != nil {
+			return err
+		}
+		events = append(events, evs...)
+	}
+	slices.SortStableFunc(events, func(a, b *Event) int { return cmp.Compare(a.CommitTimestamp, b.CommitTimestamp) })
+	return f.deliverAll(ctx, events)
+}
+
+// resync relists the current entities of each type, announced by a
+// ChangeResync event without an entity. `end` is the commit timestamp the
+// forwarder carries on from.
+func (f *Forwarder) resync(ctx context.Context, end int64) error {
+	events := []*Event{}
+	for _, typ := range f.opts.Types {
+		rsp, err := f.client.ListEntities(ctx, &nbipb.ListEntitiesRequest{Type: typ.Enum()})
+		if err != nil {
+			return fmt.Errorf("relisting the %s entities: %w", typ, err)
+		}
+		marker := &Event{
+			ID:              fmt.Sprintf("%s/%s/%d", typ, ChangeResync, end),
+			Change:          ChangeResync,
+			EntityType:      typ.String(),
+			CommitTime:      time.UnixMicro(end).UTC(),
+			CommitTimestamp: end,
+		}
+		events = append(events, marker)
+
+		entities := rsp.GetEntities()
+		slices.SortFunc(entities, func(a, b *nbipb.Entity) int { return cmp.Compare(a.GetId(), b.GetId()) })
+		for _, e := range entities {
+			ev, err := newEvent(e, nil)
+			if err != nil {
+				return err
+			}
+			// The version may have been forwarded before, so the event
+			// gets an ID of its own, lest receivers discard it.
+			ev.ID = marker.ID + "/" + e.GetId()
+			ev.Change = ChangeResync
+			events = append(events, ev)
+		}
+	}
+	return f.deliverAll(ctx, events)
+}
+
+// deliverAll delivers the selected `events`, which are in commit order. The
+// events of each entity type are spread over that type's workers by entity
+// ID, so that the events of an entity are delivered in order, by the same
+// worker, while those of different entities are delivered in parallel. An
+// event without an entity, such as a resync marker, is only delivered once
+// every event before it has been, and before any event after it.
+func (f *Forwarder) deliverAll(ctx context.Context, events []*Event) error {
+	queues := map[string][]chan *Event{}
+	wg := &sync.WaitGroup{}
+	mu := &sync.Mutex{}
+	var firstErr error
+	// flush waits for the events queued so far to be delivered.
+	flush := func() {
+		for _, qs := range queues {
+			for _, q := range qs {
+				close(q)
+			}
+		}
+		wg.Wait()
+		clear(queues)
+	}
+	defer flush()
+
+	for _, ev := range events {
+		ok, err := f.selected(ev)
+		if err != nil {
+			f.log.Warn("evaluating the filter failed, forwarding the event anyway", "event", ev.ID, "error", err)
+		} else if !ok {
+			continue
+		}
+		if ev.EntityID == "" {
+			flush()
+			if err := f.deliver(ctx, ev); err != nil {
+				return err
+			}
+			continue
+		}
+
+		qs, ok := queues[ev.EntityType]
+		if !ok {
+			n := f.opts.Workers
+			if tn, ok := f.opts.TypeWorkers[nbipb.EntityType(nbipb.EntityType_value[ev.EntityType])]; ok {
+				n = tn
+			}
+			// The queues can hold every event, so that an entity whose
+			// deliveries are slow doesn't hold up the others.
+			qs = make([]chan *Event, n)
+			for i := range qs {
+				qs[i] = make(chan *Event, len(events))
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for ev := range qs[i] {
+						if err := f.deliver(ctx, ev); err != nil {
+							mu.Lock()
+							firstErr = cmp.Or(firstErr, err)
+							mu.Unlock()
+						}
+					}
+				}()
+			}
+			queues[ev.EntityType] = qs
+		}
+		h := fnv.New32a()
+		h.Write([]byte(ev.EntityID))
+		qs[h.Sum32()%uint32(len(qs))] <- ev
+	}
+	flush()
+	return firstErr
+}
+
+// changeEvents returns the events for the `versions` of entities committed in
+// [start, end), sorted by commit timestamp. `versions` must include the
+// version that precedes each of them, if any.
+func changeEvents(versions []*nbipb.Entity, start, end int64) ([]*Event, error) {
+	byID := map[string][]*nbipb.Entity{}
+	for _, v := range versions {
+		byID[v.GetId()] = append(byID[v.GetId()], v)
+	}
+
+	events := []*Event{}
+	for _, vs := range byID {
+		slices.SortFunc(vs, func(a, b *nbipb.Entity) int { return cmp.Compare(a.GetCommitTimestamp(), b.GetCommitTimestamp()) })
+		for i, v := range vs {
+			if ts := v.GetCommitTimestamp(); ts < start || ts >= end {
+				continue
+			}
+			var prev *nbipb.Entity
+			if i > 0 && vs[i-1].Value != nil {
+				prev = vs[i-1]
+			}
+			ev, err := newEvent(v, prev)
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, ev)
+		}
+	}
+	slices.SortStableFunc(events, func(a, b *Event) int { return cmp.Compare(a.CommitTimestamp, b.CommitTimestamp) })
+	return events, nil
+}
+
+// newEvent returns the event for the version `v` of an entity, whose
+// previous version was `prev`, or nil if the entity didn't exist.
+func newEvent(v, prev *nbipb.Entity) (*Event, error) {
+	ev := &Event{
+		ID:              fmt.Sprintf("%s/%s/%d", v.GetGroup().GetType(), v.GetId(), v.GetCommitTimestamp()),
+		EntityType:      v.GetGroup().GetType().String(),
+		EntityID:        v.GetId(),
+		CommitTime:      time.UnixMicro(v.GetCommitTimestamp()).UTC(),
+		CommitTimestamp: v.GetCommitTimestamp(),
+		ModifiedBy:      v.GetLastModifiedBy(),
+		matched:         v,
+	}
+	switch {
+	case v.Value == nil:
+		ev.Change = outputv1.ChangeDeleted
+		ev.matched = prev
+	case prev == nil:
+		ev.Change = outputv1.ChangeCreated
+	default:
+		ev.Change = outputv1.ChangeUpdated
+	}
+	if v.Value != nil {
+		js, err := protojson.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("encoding entity %s: %w", ev.ID, err)
+		}
+		ev.Entity = js
+	}
+	return ev, nil
+}
+
+// selected reports whether `ev` should be forwarded.
+func (f *Forwarder) selected(ev *Event) (bool, error) {
+	if !f.changes[ev.Change] {
+		return false, nil
+	}
+	if f.filter == nil || ev.matched == nil {
+		return true, nil
+	}
+	vars, err := cel.ContextProtoVars(ev.matched)
+	if err != nil {
+		return false, err
+	}
+	val, _, err := f.filter.Eval(vars)
+	if err != nil {
+		return false, err
+	}
+	ok, _ := val.Value().(bool)
+	return ok, nil
+}
+
+// deliver sends `ev` to every sink. Deliveries that still fail after
+// MaxAttempts are logged and dropped, so a broken sink doesn't hold up the
+// others. It only returns an error if `ctx` is done.
+func (f *Forwarder) deliver(ctx context.Context, ev *Event) error {
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return fmt.Errorf("encoding event %s: %w", ev.ID, err)
+	}
+	msg := Message{ID: ev.ID, Key: ev.EntityType + "/" + ev.EntityID, Payload: payload}
+	for _, s := range f.sinks {
+		if err := f.send(ctx, s, msg); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			f.log.Error("dropping event", "sink", s.Name(), "event", ev.ID, "error", err)
+		}
+	}
+	return nil
+}
+
+// send delivers `msg` to `s`, retrying failures that aren't permanent with a
+// jittered exponential backoff.
+func (f *Forwarder) send(ctx context.Context, s Sink, msg Message) error {
+	delay := retryBackoff
+	for attempt := 1; ; attempt++ {
+		err := s.Send(ctx, msg)
+		if err == nil || isPermanent(err) || attempt >= f.opts.MaxAttempts {
+			return err
+		}
+
+		wait := delay/2 + rand.N(delay+1)
+		f.log.Warn("retrying delivery", "sink", s.Name(), "event", msg.ID, "attempt", attempt, "backoff", wait, "error", err)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		delay = min(2*delay, retryMaxBackoff)
+	}
+}
+
+// readCursor returns the cursor recorded in `path`, or 0 if there's none.
+func readCursor(path string) (int64, error) {
+	if path == "" {
+		return 0, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("reading the state file: %w", err)
+	}
+	cursor, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid state file %s: %w", path, err)
+	}
+	return cursor, nil
+}
+
+// writeCursor records `cursor` in `path`, replacing the file atomically so a
+// crash can't leave it truncated.
+func writeCursor(path string, cursor int64) error {
+	if path == "" {
+		return nil
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("writing the state file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := fmt.Fprintln(tmp, cursor); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing the state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing the state file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("writing the state file: %w", err)
+	}
+	return nil
+}
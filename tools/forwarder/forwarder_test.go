@@ -0,0 +1,490 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package forwarder
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	commonpb "aalyria.com/spacetime/api/common"
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+	"aalyria.com/spacetime/github/tools/nbictl/nbitest"
+	outputv1 "aalyria.com/spacetime/github/tools/nbictl/output/v1"
+)
+
+var testSecret = []byte("hunter2")
+
+func startNBITestServer(t *testing.T) (*nbitest.Server, nbipb.NetOpsClient) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	g, ctx := errgroup.WithContext(ctx)
+	t.Cleanup(func() {
+		cancel()
+		if err := g.Wait(); err != nil {
+			t.Errorf("serving: %v", err)
+		}
+	})
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := nbitest.New()
+	g.Go(func() error { return srv.Serve(ctx, lis) })
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return srv, nbipb.NewNetOpsClient(conn)
+}
+
+// fakeWebhook records the events delivered to it, and responds with the
+// queued status codes before accepting them.
+type fakeWebhook struct {
+	t *testing.T
+
+	mu       sync.Mutex
+	statuses []int
+	events   []Event
+	attempts int
+}
+
+func startFakeWebhook(t *testing.T, statuses ...int) (*fakeWebhook, Sink) {
+	t.Helper()
+
+	w := &fakeWebhook{t: t, statuses: statuses}
+	srv := httptest.NewServer(w)
+	t.Cleanup(srv.Close)
+	sink, err := OpenSink(context.Background(), srv.URL+"/events", SinkOptions{WebhookSecret: testSecret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w, sink
+}
+
+func (w *fakeWebhook) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.attempts++
+	if len(w.statuses) > 0 {
+		code := w.statuses[0]
+		w.statuses = w.statuses[1:]
+		rw.WriteHeader(code)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.t.Error(err)
+	}
+	mac := hmac.New(sha256.New, testSecret)
+	mac.Write(body)
+	if got, want := r.Header.Get(SignatureHeader), "sha256="+hex.EncodeToString(mac.Sum(nil)); got != want {
+		w.t.Errorf("%s = %q, want %q", SignatureHeader, got, want)
+	}
+	ev := Event{}
+	if err := json.Unmarshal(body, &ev); err != nil {
+		w.t.Errorf("invalid event %s: %v", body, err)
+	}
+	if got := r.Header.Get(EventIDHeader); got != ev.ID {
+		w.t.Errorf("%s = %q, want the ID of the event %q", EventIDHeader, got, ev.ID)
+	}
+	w.events = append(w.events, ev)
+}
+
+// changes returns the change and entity ID of each event received.
+func (w *fakeWebhook) changes() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	changes := []string{}
+	for _, ev := range w.events {
+		changes = append(changes, strings.TrimSpace(ev.Change+" "+ev.EntityID))
+	}
+	return changes
+}
+
+func platform(id, name string) *nbipb.Entity {
+	return &nbipb.Entity{
+		Group: &nbipb.EntityGroup{Type: nbipb.EntityType_PLATFORM_DEFINITION.Enum()},
+		Id:    proto.String(id),
+		Value: &nbipb.Entity_Platform{Platform: &commonpb.PlatformDefinition{Name: proto.String(name)}},
+	}
+}
+
+func newTestForwarder(t *testing.T, client nbipb.NetOpsClient, sink Sink, opts Options) *Forwarder {
+	t.Helper()
+
+	opts.Types = []nbipb.EntityType{nbipb.EntityType_PLATFORM_DEFINITION}
+	opts.SettleDelay = -1
+	f, err := New(client, []Sink{sink}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestForwarder_poll(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	srv, client := startNBITestServer(t)
+	srv.Put(platform("before", "Paris"))
+	hook, sink := startFakeWebhook(t)
+	f := newTestForwarder(t, client, sink, Options{})
+
+	srv.Put(platform("gs", "London"))
+	srv.Put(platform("gs", "Londres"))
+	if _, err := client.DeleteEntity(ctx, &nbipb.DeleteEntityRequest{Type: nbipb.EntityType_PLATFORM_DEFINITION.Enum(), Id: proto.String("gs"), IgnoreConsistencyCheck: proto.Bool(true)}); err != nil {
+		t.Fatal(err)
+	}
+	srv.Put(platform("gs", "London"))
+	if err := f.poll(ctx); err != nil {
+		t.Fatalf("poll() = %v", err)
+	}
+
+	want := []string{"created gs", "updated gs", "deleted gs", "created gs"}
+	if got := hook.changes(); !slices.Equal(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+	hook.mu.Lock()
+	ev := hook.events[1]
+	hook.mu.Unlock()
+	updated := &nbipb.Entity{}
+	if err := protojson.Unmarshal(ev.Entity, updated); err != nil || updated.GetPlatform().GetName() != "Londres" {
+		t.Errorf("entity of the update = %s (%v), want the updated platform", ev.Entity, err)
+	}
+	if ev.EntityType != "PLATFORM_DEFINITION" || ev.CommitTime.UnixMicro() != ev.CommitTimestamp {
+		t.Errorf("invalid event %+v", ev)
+	}
+
+	// The next poll only forwards the changes made since.
+	srv.Put(platform("gs2", "Lyon"))
+	if err := f.poll(ctx); err != nil {
+		t.Fatalf("poll() = %v", err)
+	}
+	if got := hook.changes(); len(got) != 5 || got[4] != "created gs2" {
+		t.Errorf("events after the second poll = %v, want one more, for gs2", got)
+	}
+}
+
+func TestForwarder_filter(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	srv, client := startNBITestServer(t)
+	hook, sink := startFakeWebhook(t)
+	f := newTestForwarder(t, client, sink, Options{
+		Filter:  `platform.name == "London"`,
+		Changes: []string{outputv1.ChangeCreated, outputv1.ChangeDeleted},
+	})
+
+	srv.Put(platform("gs", "London"), platform("other", "Paris"))
+	srv.Put(platform("gs", "London"))
+	for _, id := range []string{"gs", "other"} {
+		if _, err := client.DeleteEntity(ctx, &nbipb.DeleteEntityRequest{Type: nbipb.EntityType_PLATFORM_DEFINITION.Enum(), Id: proto.String(id), IgnoreConsistencyCheck: proto.Bool(true)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.poll(ctx); err != nil {
+		t.Fatalf("poll() = %v", err)
+	}
+
+	// Deletes are matched against the last version of the entity.
+	want := []string{"created gs", "deleted gs"}
+	if got := hook.changes(); !slices.Equal(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+}
+
+func TestForwarder_retries(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name         string
+		statuses     []int
+		wantAttempts int
+		wantChanges  []string
+	}{
+		{
+			name:         "retried",
+			statuses:     []int{http.StatusServiceUnavailable, http.StatusTooManyRequests},
+			wantAttempts: 3,
+			wantChanges:  []string{"created gs"},
+		},
+		{
+			name:         "rejected",
+			statuses:     []int{http.StatusBadRequest},
+			wantAttempts: 1,
+			wantChanges:  []string{},
+		},
+		{
+			name:         "gave up",
+			statuses:     []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway},
+			wantAttempts: 3,
+			wantChanges:  []string{},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv, client := startNBITestServer(t)
+			hook, sink := startFakeWebhook(t, tc.statuses...)
+			f := newTestForwarder(t, client, sink, Options{MaxAttempts: 3})
+
+			srv.Put(platform("gs", "London"))
+			if err := f.poll(ctx); err != nil {
+				t.Fatalf("poll() = %v", err)
+			}
+			if got := hook.changes(); !slices.Equal(got, tc.wantChanges) {
+				t.Errorf("events = %v, want %v", got, tc.wantChanges)
+			}
+			hook.mu.Lock()
+			defer hook.mu.Unlock()
+			if hook.attempts != tc.wantAttempts {
+				t.Errorf("got %d attempts, want %d", hook.attempts, tc.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestForwarder_stateFile(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	srv, client := startNBITestServer(t)
+	hook, sink := startFakeWebhook(t)
+	opts := Options{StateFile: filepath.Join(t.TempDir(), "cursor")}
+	if err := newTestForwarder(t, client, sink, opts).poll(ctx); err != nil {
+		t.Fatalf("poll() = %v", err)
+	}
+
+	// Changes made while the forwarder is down are forwarded once it
+	// restarts.
+	srv.Put(platform("gs", "London"))
+	if err := newTestForwarder(t, client, sink, opts).poll(ctx); err != nil {
+		t.Fatalf("poll() = %v", err)
+	}
+	if got, want := hook.changes(), []string{"created gs"}; !slices.Equal(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+}
+
+// concurrentSink records the messages sent to it by key, and how many are
+// sent at once.
+type concurrentSink struct {
+	t *testing.T
+
+	mu        sync.Mutex
+	sending   map[string]bool
+	active    int
+	maxActive int
+	events    map[string][]int64
+}
+
+func (s *concurrentSink) Name() string { return "concurrent" }
+func (s *concurrentSink) Close() error { return nil }
+
+func (s *concurrentSink) Send(_ context.Context, msg Message) error {
+	s.mu.Lock()
+	if s.sending[msg.Key] {
+		s.t.Errorf("%s sent while another event of %s is being sent", msg.ID, msg.Key)
+	}
+	s.sending[msg.Key] = true
+	s.active++
+	s.maxActive = max(s.maxActive, s.active)
+	s.mu.Unlock()
+
+	time.Sleep(2 * time.Millisecond)
+	ev := Event{}
+	if err := json.Unmarshal(msg.Payload, &ev); err != nil {
+		s.t.Error(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sending[msg.Key] = false
+	s.active--
+	s.events[msg.Key] = append(s.events[msg.Key], ev.CommitTimestamp)
+	return nil
+}
+
+func TestForwarder_deliversEntitiesInParallel(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	srv, client := startNBITestServer(t)
+	sink := &concurrentSink{t: t, sending: map[string]bool{}, events: map[string][]int64{}}
+	f := newTestForwarder(t, client, sink, Options{Workers: 4})
+
+	for version := range 3 {
+		for i := range 8 {
+			srv.Put(platform(fmt.Sprintf("gs-%d", i), fmt.Sprintf("v%d", version)))
+		}
+	}
+	if err := f.poll(ctx); err != nil {
+		t.Fatalf("poll() = %v", err)
+	}
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if len(sink.events) != 8 {
+		t.Errorf("got the events of %d entities, want 8", len(sink.events))
+	}
+	for key, timestamps := range sink.events {
+		if len(timestamps) != 3 || !slices.IsSorted(timestamps) {
+			t.Errorf("events of %s were delivered in the order %v, want 3 in commit order", key, timestamps)
+		}
+	}
+	if sink.maxActive < 2 || sink.maxActive > 4 {
+		t.Errorf("up to %d events were delivered at once, want between 2 and the 4 workers", sink.maxActive)
+	}
+}
+
+// countingClient counts the calls to ListEntitiesOverTime.
+type countingClient struct {
+	nbipb.NetOpsClient
+	overTime int
+}
+
+func (c *countingClient) ListEntitiesOverTime(ctx context.Context, req *nbipb.ListEntitiesOverTimeRequest, opts ...grpc.CallOption) (*nbipb.ListEntitiesOverTimeResponse, error) {
+	c.overTime++
+	return c.NetOpsClient.ListEntitiesOverTime(ctx, req, opts...)
+}
+
+func TestForwarder_catchesUpInChunks(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	srv, client := startNBITestServer(t)
+	hook, sink := startFakeWebhook(t)
+	stateFile := filepath.Join(t.TempDir(), "cursor")
+	since := time.Now().Add(-time.Hour).UnixMicro()
+	if err := writeCursor(stateFile, since); err != nil {
+		t.Fatal(err)
+	}
+	counting := &countingClient{NetOpsClient: client}
+	f := newTestForwarder(t, counting, sink, Options{StateFile: stateFile, CatchUpChunk: 10 * time.Minute})
+
+	srv.Put(platform("gs", "London"))
+	if err := f.poll(ctx); err != nil {
+		t.Fatalf("poll() = %v", err)
+	}
+	// An hour and the time the test took so far is 7 chunks of 10 minutes.
+	if counting.overTime != 7 {
+		t.Errorf("got %d calls to ListEntitiesOverTime, want 7", counting.overTime)
+	}
+	if got, want := hook.changes(), []string{"created gs"}; !slices.Equal(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+	if cursor, err := readCursor(stateFile); err != nil || cursor != f.cursor || cursor < since+time.Hour.Microseconds() {
+		t.Errorf("recorded cursor = %d (%v), want the end of the last chunk %d", cursor, err, f.cursor)
+	}
+}
+
+func TestForwarder_resyncsWhenTooFarBehind(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	srv, client := startNBITestServer(t)
+	srv.Put(platform("gs", "London"), platform("deleted", "Paris"))
+	if _, err := client.DeleteEntity(ctx, &nbipb.DeleteEntityRequest{Type: nbipb.EntityType_PLATFORM_DEFINITION.Enum(), Id: proto.String("deleted"), IgnoreConsistencyCheck: proto.Bool(true)}); err != nil {
+		t.Fatal(err)
+	}
+	hook, sink := startFakeWebhook(t)
+	stateFile := filepath.Join(t.TempDir(), "cursor")
+	if err := writeCursor(stateFile, time.Now().Add(-time.Hour).UnixMicro()); err != nil {
+		t.Fatal(err)
+	}
+	counting := &countingClient{NetOpsClient: client}
+	f := newTestForwarder(t, counting, sink, Options{
+		StateFile:  stateFile,
+		MaxCatchUp: 30 * time.Minute,
+		// Resync events are forwarded regardless.
+		Changes: []string{outputv1.ChangeDeleted},
+	})
+
+	if err := f.poll(ctx); err != nil {
+		t.Fatalf("poll() = %v", err)
+	}
+	if counting.overTime != 0 {
+		t.Errorf("got %d calls to ListEntitiesOverTime, want the entities relisted instead", counting.overTime)
+	}
+	if got, want := hook.changes(), []string{"resync", "resync gs"}; !slices.Equal(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+
+	// Once resynced, the forwarder carries on polling for changes.
+	if _, err := client.DeleteEntity(ctx, &nbipb.DeleteEntityRequest{Type: nbipb.EntityType_PLATFORM_DEFINITION.Enum(), Id: proto.String("gs"), IgnoreConsistencyCheck: proto.Bool(true)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.poll(ctx); err != nil {
+		t.Fatalf("poll() = %v", err)
+	}
+	if got, want := hook.changes(), []string{"resync", "resync gs", "deleted gs"}; !slices.Equal(got, want) {
+		t.Errorf("events after the next poll = %v, want %v", got, want)
+	}
+}
+
+func TestNew_invalidOptions(t *testing.T) {
+	t.Parallel()
+
+	for name, opts := range map[string]Options{
+		"no types":        {},
+		"unknown change":  {Types: []nbipb.EntityType{nbipb.EntityType_PLATFORM_DEFINITION}, Changes: []string{"renamed"}},
+		"invalid filter":  {Types: []nbipb.EntityType{nbipb.EntityType_PLATFORM_DEFINITION}, Filter: "platform.nme"},
+		"non-bool filter": {Types: []nbipb.EntityType{nbipb.EntityType_PLATFORM_DEFINITION}, Filter: "id"},
+		"no type workers": {Types: []nbipb.EntityType{nbipb.EntityType_PLATFORM_DEFINITION}, TypeWorkers: map[nbipb.EntityType]int{nbipb.EntityType_PLATFORM_DEFINITION: 0}},
+	} {
+		if _, err := New(nil, nil, opts); err == nil {
+			t.Errorf("%s: New() succeeded, want an error", name)
+		}
+	}
+}
+
+func TestOpenSink_invalid(t *testing.T) {
+	t.Parallel()
+
+	for _, u := range []string{"ftp://example.com/events", "kafka:///events", "kafka://broker:9092", "pubsub://project", "://"} {
+		if _, err := OpenSink(context.Background(), u, SinkOptions{}); err == nil {
+			t.Errorf("OpenSink(%q) succeeded, want an error", u)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package forwarder
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	// EventIDHeader is the HTTP header that holds the ID of the event
+	// delivered to a webhook.
+	EventIDHeader = "X-Spacetime-Event-Id"
+	// SignatureHeader is the HTTP header that holds the signature of the
+	// event delivered to a webhook, if a secret is configured: "sha256="
+	// followed by the hex-encoded HMAC-SHA256 of the request body.
+	SignatureHeader = "X-Spacetime-Signature"
+
+	// eventIDAttribute is the Kafka header and Pub/Sub attribute that holds
+	// the ID of the event.
+	eventIDAttribute = "spacetime-event-id"
+)
+
+// Message is an event as delivered to a sink.
+type Message struct {
+	ID string
+	// Key identifies the entity the event is about. Sinks that partition
+	// messages use it to keep the events of an entity in order.
+	Key     string
+	Payload []byte
+}
+
+// Sink is a destination for events.
+type Sink interface {
+	// Name identifies the sink in logs. It mustn't include credentials.
+	Name() string
+	// Send delivers `msg`. Errors are retried unless they're permanent.
+	// Send is called concurrently for the messages of different entities,
+	// but never for two messages with the same Key.
+	Send(ctx context.Context, msg Message) error
+	Close() error
+}
+
+// permanentError is a delivery failure that retrying won't fix, such as the
+// receiver rejecting the message.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+func isPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
+
+// SinkOptions configure the sinks returned by OpenSink.
+type SinkOptions struct {
+	// WebhookSecret, if set, is used to sign the requests made to webhooks
+	// (see SignatureHeader).
+	WebhookSecret []byte
+	// HTTPClient is used to call webhooks. Defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// OpenSink returns the sink described by `rawURL`, which is one of:
+//
+//   - http://HOST/PATH or https://HOST/PATH, a webhook that events are POSTed
+//     to;
+//   - kafka://BROKER[,BROKER...]/TOPIC, a Kafka topic that events are
+//     written to, keyed by entity;
+//   - pubsub://PROJECT/TOPIC, a Pub/Sub topic that events are published to,
+//     with the entity as the ordering key.
+func OpenSink(ctx context.Context, rawURL string, opts SinkOptions) (Sink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sink: %w", err)
+	}
+	topic := strings.TrimPrefix(u.Path, "/")
+
+	switch u.Scheme {
+	case "http", "https":
+		client := opts.HTTPClient
+		if client == nil {
+			client = http.DefaultClient
+		}
+		return &webhookSink{url: u, secret: opts.WebhookSecret, client: client}, nil
+
+	case "kafka":
+		if u.Host == "" || topic == "" {
+			return nil, fmt.Errorf("invalid sink %q: expected kafka://BROKER[,BROKER...]/TOPIC", rawURL)
+		}
+		return &kafkaSink{w: &kafka.Writer{
+			Addr:         kafka.TCP(strings.Split(u.Host, ",")...),
+			Topic:        topic,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+			// The forwarder retries failed deliveries itself.
+			MaxAttempts: 1,
+		}}, nil
+
+	case "pubsub":
+		if u.Host == "" || topic == "" {
+			return nil, fmt.Errorf("invalid sink %q: expected pubsub://PROJECT/TOPIC", rawURL)
+		}
+		client, err := pubsub.NewClient(ctx, u.Host)
+		if err != nil {
+			return nil, fmt.Errorf("creating the Pub/Sub client: %w", err)
+		}
+		t := client.Topic(topic)
+		t.EnableMessageOrdering = true
+		return &pubsubSink{client: client, topic: t}, nil
+
+	default:
+		return nil, fmt.Errorf("invalid sink %q: unsupported scheme %q, expected http, https, kafka or pubsub", rawURL, u.Scheme)
+	}
+}
+
+type webhookSink struct {
+	url    *url.URL
+	secret []byte
+	client *http.Client
+}
+
+func (s *webhookSink) Name() string { return s.url.Redacted() }
+
+func (s *webhookSink) Send(ctx context.Context, msg Message) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url.String(), bytes.NewReader(msg.Payload))
+	if err != nil {
+		return &permanentError{err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventIDHeader, msg.ID)
+	if len(s.secret) > 0 {
+		mac := hmac.New(sha256.New, s.secret)
+		mac.Write(msg.Payload)
+		req.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	rsp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	// Drain the body so the connection can be reused.
+	io.Copy(io.Discard, io.LimitReader(rsp.Body, 64<<10))
+
+	switch code := rsp.StatusCode; {
+	case code >= 200 && code < 300:
+		return nil
+	case code == http.StatusRequestTimeout, code == http.StatusTooManyRequests, code >= 500:
+		return fmt.Errorf("the webhook responded with %s", rsp.Status)
+	default:
+		return &permanentError{fmt.Errorf("the webhook rejected the event with %s", rsp.Status)}
+	}
+}
+
+func (s *webhookSink) Close() error { return nil }
+
+type kafkaSink struct {
+	w *kafka.Writer
+}
+
+func (s *kafkaSink) Name() string { return "kafka://" + s.w.Addr.String() + "/" + s.w.Topic }
+
+func (s *kafkaSink) Send(ctx context.Context, msg Message) error {
+	return s.w.WriteMessages(ctx, kafka.Message{
+		Key:     []byte(msg.Key),
+		Value:   msg.Payload,
+		Headers: []kafka.Header{{Key: eventIDAttribute, Value: []byte(msg.ID)}},
+	})
+}
+
+func (s *kafkaSink) Close() error { return s.w.Close() }
+
+type pubsubSink struct {
+	client *pubsub.Client
+	topic  *pubsub.Topic
+}
+
+func (s *pubsubSink) Name() string { return "pubsub://" + s.client.Project() + "/" + s.topic.ID() }
+
+func (s *pubsubSink) Send(ctx context.Context, msg Message) error {
+	res := s.topic.Publish(ctx, &pubsub.Message{
+		Data:        msg.Payload,
+		OrderingKey: msg.Key,
+		Attributes:  map[string]string{eventIDAttribute: msg.ID},
+	})
+	if _, err := res.Get(ctx); err != nil {
+		// Publishing is paused for an ordering key after a failure, to
+		// keep its messages in order, until it's resumed.
+		s.topic.ResumePublish(msg.Key)
+		return err
+	}
+	return nil
+}
+
+func (s *pubsubSink) Close() error {
+	s.topic.Stop()
+	return s.client.Close()
+}
@@ -0,0 +1,68 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/go-logr/logr"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+	"aalyria.com/spacetime/github/tools/k8soperator"
+	"aalyria.com/spacetime/github/tools/nbictl"
+)
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "fatal error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	configDir := flag.String("config_dir", "", "The nbictl configuration directory to read the NBI connection settings from. Defaults to that of nbictl.")
+	contextName := flag.String("context", "", "The nbictl context to connect to the NBI with. May be omitted if the configuration defines a single context.")
+	leaderElect := flag.Bool("leader_elect", false, "Elect a leader among the replicas of the operator, so only one reconciles the resources at a time.")
+	flag.Parse()
+
+	ctrl.SetLogger(logr.FromSlogHandler(slog.NewJSONHandler(os.Stderr, nil)))
+	ctx := ctrl.SetupSignalHandler()
+
+	conn, err := nbictl.Dial(ctx, *configDir, *contextName)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	cfg, err := ctrl.GetConfig()
+	if err != nil {
+		return fmt.Errorf("unable to load the Kubernetes client configuration: %w", err)
+	}
+	mgr, err := ctrl.NewManager(cfg, ctrl.Options{
+		LeaderElection:   *leaderElect,
+		LeaderElectionID: "spacetime-operator." + k8soperator.Group,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to create the controller manager: %w", err)
+	}
+	if err := k8soperator.Setup(mgr, nbipb.NewNetOpsClient(conn)); err != nil {
+		return err
+	}
+	return mgr.Start(ctx)
+}
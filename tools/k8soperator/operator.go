@@ -0,0 +1,308 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package k8soperator implements a Kubernetes controller that keeps the
+// entities of a Spacetime instance in sync with custom resources, so that
+// GitOps pipelines can own the network model like any other Kubernetes
+// object.
+//
+// There's a custom resource kind for each supported entity type (see
+// config/crds.yaml). The value of the entity is given by `spec.value`, in the
+// protobuf JSON format, and its ID by `spec.id`, which defaults to the name
+// of the resource. The controller creates or updates the entity whenever the
+// resource changes, overwriting changes made by other clients of the NBI,
+// and deletes it when the resource is deleted. The outcome is reported by the
+// "Accepted" condition of the resource's status.
+package k8soperator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+	"aalyria.com/spacetime/github/tools/nbictl/entity"
+)
+
+const (
+	// Group and Version are those of the custom resources.
+	Group   = "spacetime.aalyria.com"
+	Version = "v1alpha1"
+
+	// finalizer keeps a resource around until its entity has been deleted.
+	finalizer = Group + "/delete-entity"
+
+	// ConditionAccepted is the type of the condition that reports whether
+	// the NBI accepted the resource's entity.
+	ConditionAccepted = "Accepted"
+
+	// Reasons of the Accepted condition.
+	ReasonSynced      = "Synced"
+	ReasonInvalidSpec = "InvalidSpec"
+	ReasonRejected    = "Rejected"
+	ReasonSyncFailed  = "SyncFailed"
+
+	// resyncInterval is how often resources are reconciled even if they
+	// haven't changed, to undo changes made to the entities by other clients.
+	resyncInterval = 5 * time.Minute
+)
+
+// errInvalidSpec is returned when the spec of a resource doesn't describe a
+// valid entity.
+var errInvalidSpec = errors.New("invalid spec")
+
+// entityKind describes the custom resource kind that mirrors an entity type.
+type entityKind struct {
+	entity.Kind
+	// kind is the name of the custom resource kind, the CamelCase form of
+	// the entity kind's name: "network_node" is mirrored by NetworkNode.
+	kind string
+}
+
+var entityKinds = func() []entityKind {
+	var ks []entityKind
+	for _, k := range entity.Kinds {
+		var kind strings.Builder
+		for _, w := range strings.Split(k.Name, "_") {
+			kind.WriteString(strings.ToUpper(w[:1]) + w[1:])
+		}
+		ks = append(ks, entityKind{Kind: k, kind: kind.String()})
+	}
+	return ks
+}()
+
+func (k entityKind) gvk() schema.GroupVersionKind {
+	return schema.GroupVersionKind{Group: Group, Version: Version, Kind: k.kind}
+}
+
+// newObject returns an empty resource of kind `k`.
+func (k entityKind) newObject() *unstructured.Unstructured {
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(k.gvk())
+	return u
+}
+
+// entityStatus is the status of the custom resources.
+type entityStatus struct {
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// EntityID is the ID of the entity last created or updated, so it can
+	// be deleted if the ID in the spec changes.
+	EntityID        string             `json:"entityId,omitempty"`
+	CommitTimestamp int64              `json:"commitTimestamp,omitempty"`
+	Conditions      []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// Setup registers a controller for each of the custom resource kinds with
+// `mgr`, which reconcile them against the NBI through `nbi`.
+func Setup(mgr ctrl.Manager, nbi nbipb.NetOpsClient) error {
+	for _, k := range entityKinds {
+		r := &reconciler{kind: k, k8s: mgr.GetClient(), nbi: nbi}
+		if err := ctrl.NewControllerManagedBy(mgr).Named("spacetime-" + k.kind).For(k.newObject()).Complete(r); err != nil {
+			return fmt.Errorf("unable to set up the %s controller: %w", k.kind, err)
+		}
+	}
+	return nil
+}
+
+type reconciler struct {
+	kind entityKind
+	k8s  client.Client
+	nbi  nbipb.NetOpsClient
+}
+
+func (r *reconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	obj := r.kind.newObject()
+	if err := r.k8s.Get(ctx, req.NamespacedName, obj); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	st, err := statusOf(obj)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	id, _, _ := unstructured.NestedString(obj.Object, "spec", "id")
+	if id == "" {
+		id = obj.GetName()
+	}
+
+	if !obj.GetDeletionTimestamp().IsZero() {
+		if !controllerutil.ContainsFinalizer(obj, finalizer) {
+			return ctrl.Result{}, nil
+		}
+		if st.EntityID != "" {
+			if err := deleteEntity(ctx, r.nbi, r.kind, st.EntityID); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		controllerutil.RemoveFinalizer(obj, finalizer)
+		return ctrl.Result{}, r.k8s.Update(ctx, obj)
+	}
+	if controllerutil.AddFinalizer(obj, finalizer) {
+		if err := r.k8s.Update(ctx, obj); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	value, _, _ := unstructured.NestedMap(obj.Object, "spec", "value")
+	ts, syncErr := syncEntity(ctx, r.nbi, r.kind, id, value)
+	if syncErr == nil {
+		if st.EntityID != "" && st.EntityID != id {
+			if err := deleteEntity(ctx, r.nbi, r.kind, st.EntityID); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		st.EntityID, st.CommitTimestamp = id, ts
+	}
+	st.ObservedGeneration = obj.GetGeneration()
+	cond := acceptedCondition(syncErr)
+	cond.ObservedGeneration = obj.GetGeneration()
+	meta.SetStatusCondition(&st.Conditions, cond)
+	if err := r.updateStatus(ctx, obj, st); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	switch {
+	case syncErr == nil, cond.Reason != ReasonSyncFailed:
+		// Rejected specs are only retried once they change, or when
+		// resyncing.
+		return ctrl.Result{RequeueAfter: resyncInterval}, nil
+	default:
+		return ctrl.Result{}, syncErr
+	}
+}
+
+// updateStatus writes `st` to the status of `obj`, unless it's unchanged.
+func (r *reconciler) updateStatus(ctx context.Context, obj *unstructured.Unstructured, st *entityStatus) error {
+	m, err := runtime.DefaultUnstructuredConverter.ToUnstructured(st)
+	if err != nil {
+		return fmt.Errorf("unable to convert the status: %w", err)
+	}
+	prev, _, _ := unstructured.NestedMap(obj.Object, "status")
+	if equality.Semantic.DeepEqual(prev, m) {
+		return nil
+	}
+	if err := unstructured.SetNestedMap(obj.Object, m, "status"); err != nil {
+		return err
+	}
+	return r.k8s.Status().Update(ctx, obj)
+}
+
+func statusOf(obj *unstructured.Unstructured) (*entityStatus, error) {
+	st := &entityStatus{}
+	m, ok, err := unstructured.NestedMap(obj.Object, "status")
+	if err != nil || !ok {
+		return st, err
+	}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(m, st); err != nil {
+		return nil, fmt.Errorf("unable to parse the status of %s: %w", obj.GetName(), err)
+	}
+	return st, nil
+}
+
+// acceptedCondition returns the Accepted condition that reports `err`, the
+// outcome of syncEntity.
+func acceptedCondition(err error) metav1.Condition {
+	c := metav1.Condition{Type: ConditionAccepted, Status: metav1.ConditionFalse}
+	switch code := status.Code(err); {
+	case err == nil:
+		c.Status, c.Reason, c.Message = metav1.ConditionTrue, ReasonSynced, "The entity matches the spec."
+		return c
+	case errors.Is(err, errInvalidSpec):
+		c.Reason = ReasonInvalidSpec
+	case code == codes.InvalidArgument, code == codes.FailedPrecondition, code == codes.AlreadyExists, code == codes.PermissionDenied:
+		c.Reason = ReasonRejected
+	default:
+		c.Reason = ReasonSyncFailed
+	}
+	c.Message = err.Error()
+	return c
+}
+
+// buildEntity returns the entity of kind `k` with the given ID whose value
+// is `value`, the protobuf JSON representation of the value as decoded into
+// an unstructured object.
+func buildEntity(k entityKind, id string, value map[string]any) (*nbipb.Entity, error) {
+	// A missing value is an empty message.
+	js := []byte("{}")
+	if value != nil {
+		var err error
+		if js, err = json.Marshal(value); err != nil {
+			return nil, fmt.Errorf("%w: %w", errInvalidSpec, err)
+		}
+	}
+	e, err := k.Build(id, func(m proto.Message) error { return protojson.Unmarshal(js, m) })
+	if err != nil {
+		return nil, fmt.Errorf("%w: unable to parse spec.value as a %s: %w", errInvalidSpec, k.Field, err)
+	}
+	if err := entity.Validate(e); err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidSpec, err)
+	}
+	return e, nil
+}
+
+// syncEntity creates or updates the entity with the given ID so that its
+// value is `value`, and returns its commit timestamp. Entities that already
+// match aren't modified.
+func syncEntity(ctx context.Context, nbi nbipb.NetOpsClient, k entityKind, id string, value map[string]any) (int64, error) {
+	e, err := buildEntity(k, id, value)
+	if err != nil {
+		return 0, err
+	}
+
+	cur, err := nbi.GetEntity(ctx, &nbipb.GetEntityRequest{Type: k.Type.Enum(), Id: proto.String(id)})
+	switch {
+	case status.Code(err) == codes.NotFound:
+		created, err := nbi.CreateEntity(ctx, &nbipb.CreateEntityRequest{Entity: e})
+		return created.GetCommitTimestamp(), err
+	case err != nil:
+		return 0, err
+	case proto.Equal(k.Value(cur), k.Value(e)):
+		return cur.GetCommitTimestamp(), nil
+	}
+
+	// The resource is the source of truth, so changes made by other
+	// clients are overwritten.
+	updated, err := nbi.UpdateEntity(ctx, &nbipb.UpdateEntityRequest{Entity: e, IgnoreConsistencyCheck: proto.Bool(true)})
+	return updated.GetCommitTimestamp(), err
+}
+
+// deleteEntity deletes the entity with the given ID. An entity that's already
+// gone isn't an error.
+func deleteEntity(ctx context.Context, nbi nbipb.NetOpsClient, k entityKind, id string) error {
+	_, err := nbi.DeleteEntity(ctx, &nbipb.DeleteEntityRequest{
+		Type:                   k.Type.Enum(),
+		Id:                     proto.String(id),
+		IgnoreConsistencyCheck: proto.Bool(true),
+	})
+	if status.Code(err) == codes.NotFound {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,193 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package k8soperator
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+	"aalyria.com/spacetime/github/tools/nbictl/nbitest"
+)
+
+var platformKind = entityKinds[0]
+
+// platformValue returns the value of a platform as it's decoded from the
+// spec of a resource.
+func platformValue(name string) map[string]any {
+	return map[string]any{
+		"name": name,
+		"coordinates": map[string]any{
+			"geodeticWgs84": map[string]any{"latitudeDeg": 1.0, "longitudeDeg": int64(2)},
+		},
+	}
+}
+
+func startNBITestServer(t *testing.T) (*nbitest.Server, nbipb.NetOpsClient) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	g, ctx := errgroup.WithContext(ctx)
+	t.Cleanup(func() {
+		cancel()
+		if err := g.Wait(); err != nil {
+			t.Errorf("serving: %v", err)
+		}
+	})
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := nbitest.New()
+	g.Go(func() error { return srv.Serve(ctx, lis) })
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return srv, nbipb.NewNetOpsClient(conn)
+}
+
+func TestEntityKinds(t *testing.T) {
+	t.Parallel()
+
+	// The kinds must match the custom resource definitions in
+	// config/crds.yaml.
+	want := []string{"Platform", "AntennaPattern", "NetworkNode", "InterfaceLink", "TransceiverLink"}
+	var got []string
+	for _, k := range entityKinds {
+		got = append(got, k.kind)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("entityKinds mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestSyncEntity(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	srv, nbi := startNBITestServer(t)
+
+	created, err := syncEntity(ctx, nbi, platformKind, "gs", platformValue("gs"))
+	if err != nil {
+		t.Fatalf("syncEntity() creating = %v", err)
+	}
+	stored, ok := srv.Entity(nbipb.EntityType_PLATFORM_DEFINITION, "gs")
+	if !ok || stored.GetPlatform().GetCoordinates().GetGeodeticWgs84().GetLongitudeDeg() != 2 {
+		t.Fatalf("stored entity = %v, want the platform", stored)
+	}
+
+	// Syncing an unchanged spec doesn't modify the entity.
+	if ts, err := syncEntity(ctx, nbi, platformKind, "gs", platformValue("gs")); err != nil || ts != created {
+		t.Errorf("syncEntity() unchanged = %d, %v, want %d, nil", ts, err, created)
+	}
+
+	// Changes made by other clients are overwritten.
+	stored.GetPlatform().Name = proto.String("renamed")
+	srv.Put(stored)
+	updated, err := syncEntity(ctx, nbi, platformKind, "gs", platformValue("gs"))
+	if err != nil {
+		t.Fatalf("syncEntity() after a change = %v", err)
+	}
+	if stored, _ := srv.Entity(nbipb.EntityType_PLATFORM_DEFINITION, "gs"); stored.GetPlatform().GetName() != "gs" || updated != stored.GetCommitTimestamp() {
+		t.Errorf("stored entity = %v, want it restored with commit timestamp %d", stored, updated)
+	}
+
+	if err := deleteEntity(ctx, nbi, platformKind, "gs"); err != nil {
+		t.Fatalf("deleteEntity() = %v", err)
+	}
+	if _, ok := srv.Entity(nbipb.EntityType_PLATFORM_DEFINITION, "gs"); ok {
+		t.Error("the entity wasn't deleted")
+	}
+	if err := deleteEntity(ctx, nbi, platformKind, "gs"); err != nil {
+		t.Errorf("deleteEntity() of a deleted entity = %v, want nil", err)
+	}
+}
+
+func TestAcceptedCondition(t *testing.T) {
+	t.Parallel()
+
+	_, nbi := startNBITestServer(t)
+	syncErr := func(value map[string]any) error {
+		_, err := syncEntity(context.Background(), nbi, platformKind, "gs", value)
+		return err
+	}
+
+	for _, tc := range []struct {
+		name        string
+		err         error
+		wantStatus  metav1.ConditionStatus
+		wantReason  string
+		wantMessage string
+	}{
+		{
+			name:       "synced",
+			err:        syncErr(platformValue("gs")),
+			wantStatus: metav1.ConditionTrue,
+			wantReason: ReasonSynced,
+		},
+		{
+			name:        "unknown field",
+			err:         syncErr(map[string]any{"nme": "gs"}),
+			wantStatus:  metav1.ConditionFalse,
+			wantReason:  ReasonInvalidSpec,
+			wantMessage: "unable to parse spec.value as a platform",
+		},
+		{
+			name:        "invalid platform",
+			err:         syncErr(map[string]any{"name": "gs"}),
+			wantStatus:  metav1.ConditionFalse,
+			wantReason:  ReasonInvalidSpec,
+			wantMessage: "either coordinates or motion_ref_id is required",
+		},
+		{
+			name:        "rejected",
+			err:         status.Error(codes.InvalidArgument, "no"),
+			wantStatus:  metav1.ConditionFalse,
+			wantReason:  ReasonRejected,
+			wantMessage: "no",
+		},
+		{
+			name:        "unavailable",
+			err:         status.Error(codes.Unavailable, "try again"),
+			wantStatus:  metav1.ConditionFalse,
+			wantReason:  ReasonSyncFailed,
+			wantMessage: "try again",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			c := acceptedCondition(tc.err)
+			if c.Type != ConditionAccepted || c.Status != tc.wantStatus || c.Reason != tc.wantReason || !strings.Contains(c.Message, tc.wantMessage) {
+				t.Errorf("acceptedCondition(%v) = %+v, want status %s, reason %s and a message containing %q", tc.err, c, tc.wantStatus, tc.wantReason, tc.wantMessage)
+			}
+		})
+	}
+}
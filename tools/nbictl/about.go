@@ -0,0 +1,47 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"aalyria.com/spacetime/about"
+)
+
+func AboutLicenses(appCtx *cli.Context) error {
+	mods, err := about.Modules()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(appCtx.App.Writer, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MODULE\tVERSION\tLICENSE")
+	for _, m := range mods {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", m.Path, m.Version, m.License)
+	}
+	return w.Flush()
+}
+
+func AboutSBOM(appCtx *cli.Context) error {
+	doc, err := about.SBOM(appName, time.Now())
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(appCtx.App.Writer, "%s\n", doc)
+	return err
+}
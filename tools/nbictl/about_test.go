@@ -0,0 +1,55 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestAboutLicenses(t *testing.T) {
+	t.Parallel()
+
+	app := newTestApp()
+	checkErr(t, app.Run([]string{"nbictl", "about", "licenses"}))
+
+	lines := strings.Split(strings.TrimSpace(app.stdout.String()), "\n")
+	if len(lines) < 2 || !strings.HasPrefix(lines[0], "MODULE") {
+		t.Fatalf("expected a header and at least one module, got:\n%s", app.stdout.String())
+	}
+	if fields := strings.Fields(lines[1]); fields[0] != "aalyria.com/spacetime" || fields[len(fields)-1] != "Apache-2.0" {
+		t.Errorf("expected the main module first, got line %q", lines[1])
+	}
+}
+
+func TestAboutSBOM(t *testing.T) {
+	t.Parallel()
+
+	app := newTestApp()
+	checkErr(t, app.Run([]string{"nbictl", "about", "sbom"}))
+
+	doc := struct {
+		SPDXVersion string `json:"spdxVersion"`
+		Name        string `json:"name"`
+		Packages    []struct {
+			Name string `json:"name"`
+		} `json:"packages"`
+	}{}
+	checkErr(t, json.Unmarshal(app.stdout.Bytes(), &doc))
+	if doc.SPDXVersion != "SPDX-2.3" || doc.Name != "nbictl" || len(doc.Packages) == 0 {
+		t.Errorf("unexpected SBOM:\n%s", app.stdout.String())
+	}
+}
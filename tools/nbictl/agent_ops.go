@@ -0,0 +1,162 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/urfave/cli/v2"
+	"google.golang.org/grpc"
+
+	agentopspb "aalyria.com/spacetime/api/agentops/v1alpha"
+	"aalyria.com/spacetime/auth"
+)
+
+const defaultDiagnosticsFile = "agent-diagnostics.tar.gz"
+
+// agentOpsFlags returns the flags shared by the agent-ops subcommands,
+// followed by `extra`.
+func agentOpsFlags(extra ...cli.Flag) []cli.Flag {
+	return append([]cli.Flag{
+		&cli.StringFlag{
+			Name:     "agent",
+			Usage:    "[REQUIRED] Address of the agent's remote ops endpoint, as host:port.",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:  "node",
+			Usage: "ID of the node to act on. Required by every subcommand but diagnostics, where it limits the events included.",
+		},
+		&cli.StringFlag{
+			Name:     "reason",
+			Usage:    "[REQUIRED] Why the action is being taken. Recorded in the agent's audit log.",
+			Required: true,
+		},
+		&cli.PathFlag{
+			Name:     "client_cert",
+			Usage:    "[REQUIRED] Path to the PEM-encoded client certificate that identifies you to the agent.",
+			Required: true,
+		},
+		&cli.PathFlag{
+			Name:     "client_key",
+			Usage:    "[REQUIRED] Path to the PEM-encoded private key of --client_cert.",
+			Required: true,
+		},
+		&cli.PathFlag{
+			Name:  "ca_bundle",
+			Usage: "Path to a PEM-encoded bundle of CA certificates to verify the agent against. Defaults to the system certificate pool.",
+		},
+	}, extra...)
+}
+
+// dialAgentOps connects to the AgentOps service of the agent selected by
+// the --agent flag, presenting the configured client certificate.
+func dialAgentOps(appCtx *cli.Context) (*grpc.ClientConn, error) {
+	creds, err := auth.NewMutualTLSCredentials(auth.MutualTLSConfig{
+		ClientCertFile: appCtx.Path("client_cert"),
+		ClientKeyFile:  appCtx.Path("client_key"),
+		CABundleFile:   appCtx.Path("ca_bundle"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	conn, err := grpc.NewClient(appCtx.String("agent"), slices.Concat(readOnlyDialOpts(appCtx, nil), retryDialOpts(appCtx), callInfoDialOpts(), []grpc.DialOption{grpc.WithTransportCredentials(creds)})...)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to agent %s: %w", appCtx.String("agent"), err)
+	}
+	return conn, nil
+}
+
+// withAgentOps calls `fn` with a client of the agent's AgentOps service,
+// after checking that --node is set.
+func withAgentOps(fn func(*cli.Context, agentopspb.AgentOpsClient) error) cli.ActionFunc {
+	return func(appCtx *cli.Context) error {
+		if appCtx.String("node") == "" {
+			return errors.New("--node is required")
+		}
+		conn, err := dialAgentOps(appCtx)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		return fn(appCtx, agentopspb.NewAgentOpsClient(conn))
+	}
+}
+
+func AgentOpsRestartBackend(appCtx *cli.Context, client agentopspb.AgentOpsClient) error {
+	if _, err := client.RestartBackend(appCtx.Context, &agentopspb.RestartBackendRequest{
+		NodeId: appCtx.String("node"),
+		Reason: appCtx.String("reason"),
+	}); err != nil {
+		return fmt.Errorf("AgentOps.RestartBackend: %w", err)
+	}
+	fmt.Fprintf(appCtx.App.ErrWriter, "restarted the enactment backend of node %s.\n", appCtx.String("node"))
+	return nil
+}
+
+func AgentOpsFlushQueue(appCtx *cli.Context, client agentopspb.AgentOpsClient) error {
+	resp, err := client.FlushQueue(appCtx.Context, &agentopspb.FlushQueueRequest{
+		NodeId: appCtx.String("node"),
+		Reason: appCtx.String("reason"),
+	})
+	if err != nil {
+		return fmt.Errorf("AgentOps.FlushQueue: %w", err)
+	}
+	for _, id := range resp.GetFlushedEntryIds() {
+		fmt.Fprintln(appCtx.App.Writer, id)
+	}
+	fmt.Fprintf(appCtx.App.ErrWriter, "flushed %d pending entries from node %s.\n", len(resp.GetFlushedEntryIds()), appCtx.String("node"))
+	return nil
+}
+
+func AgentOpsResync(appCtx *cli.Context, client agentopspb.AgentOpsClient) error {
+	if _, err := client.ResyncState(appCtx.Context, &agentopspb.ResyncStateRequest{
+		NodeId: appCtx.String("node"),
+		Reason: appCtx.String("reason"),
+	}); err != nil {
+		return fmt.Errorf("AgentOps.ResyncState: %w", err)
+	}
+	fmt.Fprintf(appCtx.App.ErrWriter, "node %s is resyncing its schedule with the controller.\n", appCtx.String("node"))
+	return nil
+}
+
+func AgentOpsDiagnostics(appCtx *cli.Context) error {
+	conn, err := dialAgentOps(appCtx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	resp, err := agentopspb.NewAgentOpsClient(conn).CaptureDiagnostics(appCtx.Context, &agentopspb.CaptureDiagnosticsRequest{
+		NodeId: appCtx.String("node"),
+		Reason: appCtx.String("reason"),
+	})
+	if err != nil {
+		return fmt.Errorf("AgentOps.CaptureDiagnostics: %w", err)
+	}
+
+	outPath := defaultDiagnosticsFile
+	if appCtx.IsSet("output_file") {
+		outPath = appCtx.Path("output_file")
+	}
+	if err := os.WriteFile(outPath, resp.GetBundle(), 0o600); err != nil {
+		return fmt.Errorf("writing to output file %s: %w", outPath, err)
+	}
+	fmt.Fprintf(appCtx.App.ErrWriter, "wrote diagnostics bundle to %s.\n", outPath)
+	return nil
+}
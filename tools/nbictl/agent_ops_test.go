@@ -0,0 +1,170 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	agentopspb "aalyria.com/spacetime/api/agentops/v1alpha"
+)
+
+type fakeAgentOps struct {
+	agentopspb.UnimplementedAgentOpsServer
+
+	reqs chan proto.Message
+}
+
+func (f *fakeAgentOps) FlushQueue(_ context.Context, req *agentopspb.FlushQueueRequest) (*agentopspb.FlushQueueResponse, error) {
+	f.reqs <- req
+	return &agentopspb.FlushQueueResponse{FlushedEntryIds: []string{"entry-1", "entry-2"}}, nil
+}
+
+func (f *fakeAgentOps) CaptureDiagnostics(_ context.Context, req *agentopspb.CaptureDiagnosticsRequest) (*agentopspb.CaptureDiagnosticsResponse, error) {
+	f.reqs <- req
+	return &agentopspb.CaptureDiagnosticsResponse{Bundle: []byte("bundle")}, nil
+}
+
+// writeTestCert writes a PEM-encoded certificate for `tmpl` and its key to
+// `dir`, signed by `parent` or self-signed if it's nil, and returns their
+// paths along with the parsed certificate and key.
+func writeTestCert(t *testing.T, dir, name string, tmpl *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (certFile, keyFile string, _ *x509.Certificate, _ *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	checkErr(t, err)
+	tmpl.SerialNumber = big.NewInt(time.Now().UnixNano())
+	tmpl.NotBefore, tmpl.NotAfter = time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	checkErr(t, err)
+	cert, err := x509.ParseCertificate(der)
+	checkErr(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	checkErr(t, err)
+
+	certFile, keyFile = filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	checkErr(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	checkErr(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certFile, keyFile, cert, key
+}
+
+func TestAgentOps(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	caFile, _, caCert, caKey := writeTestCert(t, dir, "ca", &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	serverCertFile, serverKeyFile, _, _ := writeTestCert(t, dir, "agent", &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "agent"},
+		DNSNames:    []string{"localhost"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, caCert, caKey)
+	clientCertFile, clientKeyFile, _, _ := writeTestCert(t, dir, "operator", &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "operator"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, caCert, caKey)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	g, ctx := errgroup.WithContext(ctx)
+	defer func() { checkErr(t, g.Wait()) }()
+	defer cancel()
+
+	serverCert, err := tls.LoadX509KeyPair(serverCertFile, serverKeyFile)
+	checkErr(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+	lis, err := net.Listen("tcp", "localhost:0")
+	checkErr(t, err)
+	srv := grpc.NewServer(grpc.Creds(credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	})))
+	fake := &fakeAgentOps{reqs: make(chan proto.Message, 1)}
+	agentopspb.RegisterAgentOpsServer(srv, fake)
+	g.Go(func() error { return srv.Serve(lis) })
+	g.Go(func() error {
+		<-ctx.Done()
+		srv.Stop()
+		return nil
+	})
+
+	_, port, err := net.SplitHostPort(lis.Addr().String())
+	checkErr(t, err)
+	connFlags := []string{
+		"--agent", net.JoinHostPort("localhost", port),
+		"--client_cert", clientCertFile,
+		"--client_key", clientKeyFile,
+		"--ca_bundle", caFile,
+	}
+
+	t.Run("flush-queue", func(t *testing.T) {
+		app := newTestApp()
+		checkErr(t, app.Run(append([]string{"nbictl", "agent-ops", "flush-queue", "--node", "node-a", "--reason", "stale routes"}, connFlags...)))
+		if diff := cmp.Diff(&agentopspb.FlushQueueRequest{NodeId: "node-a", Reason: "stale routes"}, <-fake.reqs, protocmp.Transform()); diff != "" {
+			t.Errorf("unexpected request (-want +got):\n%s", diff)
+		}
+		if got, want := app.stdout.String(), "entry-1\nentry-2\n"; got != want {
+			t.Errorf("got output %q, want %q", got, want)
+		}
+	})
+
+	t.Run("flush-queue requires a node", func(t *testing.T) {
+		err := newTestApp().Run(append([]string{"nbictl", "agent-ops", "flush-queue", "--reason", "stale routes"}, connFlags...))
+		if err == nil || !strings.Contains(err.Error(), "--node is required") {
+			t.Errorf("expected missing --node to cause an error, got %v", err)
+		}
+	})
+
+	t.Run("diagnostics", func(t *testing.T) {
+		outFile := filepath.Join(dir, "bundle.tar.gz")
+		checkErr(t, newTestApp().Run(append([]string{"nbictl", "agent-ops", "diagnostics", "--reason", "bug report", "--output_file", outFile}, connFlags...)))
+		if diff := cmp.Diff(&agentopspb.CaptureDiagnosticsRequest{Reason: "bug report"}, <-fake.reqs, protocmp.Transform()); diff != "" {
+			t.Errorf("unexpected request (-want +got):\n%s", diff)
+		}
+		data, err := os.ReadFile(outFile)
+		checkErr(t, err)
+		if got, want := string(data), "bundle"; got != want {
+			t.Errorf("got bundle %q, want %q", got, want)
+		}
+	})
+}
@@ -0,0 +1,457 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+// This file contains the entity commands in a form that other Go programs
+// can call directly, without exec-ing the nbictl binary or going through
+// flag parsing. The command-line actions are thin wrappers around them.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"text/template"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+	outputv1 "aalyria.com/spacetime/github/tools/nbictl/output/v1"
+)
+
+// IOStreams are the streams a command reads its input from and writes its
+// output to. Out receives the command's results, such as entities or JSON
+// documents, and ErrOut receives status messages.
+type IOStreams struct {
+	In     io.Reader
+	Out    io.Writer
+	ErrOut io.Writer
+}
+
+func ioStreamsFromContext(appCtx *cli.Context) IOStreams {
+	return IOStreams{In: appCtx.App.Reader, Out: appCtx.App.Writer, ErrOut: appCtx.App.ErrWriter}
+}
+
+// EntityOutputOptions controls how GetEntity and ListEntities print
+// entities.
+type EntityOutputOptions struct {
+	// Table prints one row per entity instead of the entities as textproto.
+	Table bool
+	// Columns are the computed columns of the table, as NAME=EXPRESSION
+	// where the expression is written in CEL. They require Table.
+	Columns []string
+	// ShowColumns are the names of the columns of the table to print, in
+	// order. Defaults to the type, ID, and computed columns.
+	ShowColumns []string
+	// SortBy are the names of the columns to sort the table by, each
+	// optionally prefixed with "-" to sort in descending order.
+	SortBy []string
+	// MaxWidth, if positive, is the width the table is truncated to fit in.
+	MaxWidth int
+	// RawEnums prints enum values as their integer values instead of their
+	// names.
+	RawEnums bool
+	// HumanReadable annotates bit rates, frequencies, and byte counts with
+	// human-readable values.
+	HumanReadable bool
+	// Template, if set, renders the JSON representation of the entity, or of
+	// the ListEntitiesResponse when listing, with the template instead.
+	Template *template.Template
+}
+
+func entityOutputOptionsFromFlags(appCtx *cli.Context) (EntityOutputOptions, error) {
+	tmpl, err := parseOutputTemplate(appCtx.String(entityOutputFormatFlag.Name))
+	if err != nil {
+		return EntityOutputOptions{}, err
+	}
+	opts := EntityOutputOptions{
+		Table:         appCtx.String(entityOutputFormatFlag.Name) == "table",
+		RawEnums:      appCtx.Bool(rawEnumsFlag.Name),
+		HumanReadable: appCtx.Bool(humanReadableFlag.Name),
+		Template:      tmpl,
+		ShowColumns:   appCtx.StringSlice(columnsFlag.Name),
+		SortBy:        appCtx.StringSlice(sortByFlag.Name),
+		MaxWidth:      terminalWidth(appCtx.App.Writer),
+	}
+	if c, ok := appCtx.Generic(columnFlagName).(*repeatedFlagValues); ok {
+		opts.Columns = *c
+	}
+	switch {
+	case len(opts.Columns) > 0 && !opts.Table:
+		return EntityOutputOptions{}, errors.New("--column requires --output=table")
+	case len(opts.ShowColumns) > 0 && !opts.Table:
+		return EntityOutputOptions{}, errors.New("--columns requires --output=table")
+	case len(opts.SortBy) > 0 && !opts.Table:
+		return EntityOutputOptions{}, errors.New("--sort_by requires --output=table")
+	}
+	return opts, nil
+}
+
+func (o EntityOutputOptions) columns() ([]tableColumn, error) {
+	if len(o.Columns) > 0 && !o.Table {
+		return nil, errors.New("columns require table output")
+	}
+	return compileTableColumns(o.Columns)
+}
+
+// writeTemplate renders the JSON representation of `m` with the template.
+func (o EntityOutputOptions) writeTemplate(w io.Writer, m proto.Message) error {
+	js, err := protojson.MarshalOptions{UseEnumNumbers: o.RawEnums}.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("unable to convert the response into JSON: %w", err)
+	}
+	return executeTemplate(w, o.Template, js)
+}
+
+// write prints `entities` to `w`.
+func (o EntityOutputOptions) write(w io.Writer, entities []*nbipb.Entity, cols []tableColumn, joins *joinedEntities) error {
+	if o.Table {
+		layout := tableLayout{columns: o.ShowColumns, sortBy: o.SortBy, maxWidth: o.MaxWidth}
+		return writeEntityTable(w, entities, cols, joins, layout)
+	}
+	text := outputOptions{rawEnums: o.RawEnums, humanReadable: o.HumanReadable, joins: joins}
+	out, err := text.marshal(&nbipb.TxtpbEntities{Entity: entities})
+	if err != nil {
+		return fmt.Errorf("unable to convert the response into textproto format: %w", err)
+	}
+	_, err = fmt.Fprintln(w, string(out))
+	return err
+}
+
+// GetOptions are the options of GetEntity.
+type GetOptions struct {
+	Type nbipb.EntityType
+	ID   string
+	// Fields, if set, are the paths of the only fields to return and
+	// print, in the format of EntityFilter.field_masks. The entity's ID,
+	// type, and commit timestamps are always included.
+	Fields []string
+	Output EntityOutputOptions
+}
+
+// GetEntity prints the entity with the given type and ID.
+func GetEntity(ctx context.Context, client nbipb.NetOpsClient, opts GetOptions, streams IOStreams) error {
+	cols, err := opts.Output.columns()
+	if err != nil {
+		return err
+	}
+	proj, err := parseFieldProjection(opts.Fields)
+	if err != nil {
+		return err
+	}
+	entity, err := client.GetEntity(ctx, &nbipb.GetEntityRequest{Type: opts.Type.Enum(), Id: proto.String(opts.ID)})
+	if err != nil {
+		return fmt.Errorf("unable to get the entity: %w", err)
+	}
+	entities := []*nbipb.Entity{entity}
+	if len(opts.Fields) > 0 {
+		// GetEntity always returns the whole entity, so the fields are
+		// projected locally.
+		proj.project(entities)
+	}
+	if opts.Output.Template != nil {
+		return opts.Output.writeTemplate(streams.Out, entity)
+	}
+	return opts.Output.write(streams.Out, entities, cols, nil)
+}
+
+// ListOptions are the options of ListEntities.
+type ListOptions struct {
+	Type nbipb.EntityType
+	// FieldMasks, if set, limits the fields that are returned; see the
+	// EntityFilter.field_masks documentation.
+	FieldMasks []string
+	// Fields, if set, are the paths of the only fields to return and
+	// print. Unlike FieldMasks, entities are listed even if none of the
+	// fields are set. The two can't be combined.
+	Fields []string
+	// Joins are fields of referenced entities to show next to each
+	// reference, such as "platform.name"; see the --join flag.
+	Joins []string
+	// Include, if set, limits the entities printed to those for which it
+	// returns true.
+	Include func(*nbipb.Entity) bool
+	Output  EntityOutputOptions
+}
+
+// ListEntities prints all of the entities of a given type.
+func ListEntities(ctx context.Context, client nbipb.NetOpsClient, opts ListOptions, streams IOStreams) error {
+	cols, err := opts.Output.columns()
+	if err != nil {
+		return err
+	}
+	joins, err := parseJoins(opts.Joins)
+	if err != nil {
+		return err
+	}
+	if len(joins) > 0 && opts.Output.Template != nil {
+		return errors.New("joins can't be combined with template output")
+	}
+
+	if _, err := parseFieldProjection(opts.Fields); err != nil {
+		return err
+	}
+
+	filter := &nbipb.EntityFilter{}
+	switch {
+	case len(opts.Fields) > 0 && len(opts.FieldMasks) > 0:
+		return errors.New("fields can't be combined with field masks")
+	case len(opts.Fields) > 0:
+		// "id" is set in every entity, so none are filtered out.
+		filter.FieldMasks = append(slices.Clip(opts.Fields), "id")
+	case len(opts.FieldMasks) > 0:
+		filter.FieldMasks = opts.FieldMasks
+	}
+	res, err := client.ListEntities(ctx, &nbipb.ListEntitiesRequest{Type: opts.Type.Enum(), Filter: filter})
+	if err != nil {
+		return fmt.Errorf("unable to list entities: %w", err)
+	}
+	if opts.Include != nil {
+		res.Entities = slices.DeleteFunc(res.Entities, func(e *nbipb.Entity) bool { return !opts.Include(e) })
+	}
+	joined, err := resolveJoins(ctx, client, joins, res.Entities)
+	if err != nil {
+		return err
+	}
+	if opts.Output.Template != nil {
+		err = opts.Output.writeTemplate(streams.Out, &nbipb.ListEntitiesResponse{Entities: res.Entities})
+	} else {
+		err = opts.Output.write(streams.Out, res.Entities, cols, joined)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(streams.ErrOut, "successfully queried a list of entities. number of entities: %d\n", len(res.Entities))
+	return nil
+}
+
+// BulkOptions controls how CreateEntities, UpdateEntities, and
+// DeleteEntities process many entities.
+type BulkOptions struct {
+	// Concurrency is the maximum number of entities to process at the same
+	// time. Defaults to 16.
+	Concurrency int
+	// QPS, if positive, is the maximum number of requests to send per
+	// second.
+	QPS float64
+	// JSON prints an outputv1.BatchResult to Out once every entity has been
+	// processed.
+	JSON bool
+	// Template, if set along with JSON, renders the BatchResult with the
+	// template instead of printing it as JSON.
+	Template *template.Template
+	// Timestamps is the format of the timestamps of the JSON document.
+	// Defaults to TimestampsRFC3339.
+	Timestamps TimestampFormat
+	// WaitForReads, if positive, is how long to wait after each change
+	// until reading the entity back reflects it, so callers that use the
+	// entities right away don't observe stale reads. Changes that aren't
+	// visible in time fail, even though they were applied.
+	WaitForReads time.Duration
+	// VerifyBlobs reads back each entity once it's created or updated, and
+	// fails it unless its blob fields were stored intact. It's ignored when
+	// deleting.
+	VerifyBlobs bool
+	// Preflight are the server limits that entities are checked against
+	// before any are created or updated. It's ignored when deleting.
+	Preflight PreflightOptions
+}
+
+// CreateOptions are the options of CreateEntities.
+type CreateOptions struct {
+	Entities []*nbipb.Entity
+	// Source, if set, is called for the entities to create, until it
+	// returns io.EOF, instead of using Entities. Each entity is created as
+	// it's read, so only the entities being created are held in memory, but
+	// an entity only waits for the entities it references that were read
+	// before it, and only the request size limit of Bulk.Preflight is
+	// checked, as each entity is created.
+	Source func() (*nbipb.Entity, error)
+	Bulk   BulkOptions
+}
+
+// CreateEntities creates each of the provided entities, in parallel except
+// that entities are only created once the entities they reference have been.
+// It attempts all of them even if some fail, except those that reference a
+// failed entity, and returns an error describing every failure.
+// None are attempted if any would exceed the limits in opts.Bulk.Preflight,
+// unless they're read from opts.Source.
+func CreateEntities(ctx context.Context, client nbipb.NetOpsClient, opts CreateOptions, streams IOStreams) error {
+	results := newBatchRecorder(outputv1.OperationCreate)
+	bulk := newBulkRunner(opts.Bulk, streams.ErrOut)
+	bulk.onSkip = func(e *nbipb.Entity, err error) { results.record(e.GetGroup().GetType(), e.GetId(), err) }
+	check, err := bulkPreflight(ctx, client, opts.Bulk.Preflight, opts.Entities, opts.Source != nil, bulk.log)
+	if err != nil {
+		return err
+	}
+	create := func(ctx context.Context, e *nbipb.Entity) error {
+		if err := check(e); err != nil {
+			return results.record(e.GetGroup().GetType(), e.GetId(), fmt.Errorf("create failed for entity %s/%s: %w", e.GetGroup().GetType(), e.GetId(), err))
+		}
+		res, err := client.CreateEntity(ctx, &nbipb.CreateEntityRequest{Entity: e})
+		if err != nil {
+			return results.record(e.GetGroup().GetType(), e.GetId(), fmt.Errorf("create failed for entity %s/%s: %w", e.GetGroup().GetType(), e.GetId(), err))
+		}
+		if opts.Bulk.WaitForReads > 0 {
+			if err := waitUntilReadable(ctx, client, res.GetGroup().GetType(), res.GetId(), res.GetCommitTimestamp(), false, opts.Bulk.WaitForReads); err != nil {
+				return results.record(res.GetGroup().GetType(), res.GetId(), fmt.Errorf("created entity %s/%s, but %w", res.GetGroup().GetType(), res.GetId(), err))
+			}
+		}
+		if opts.Bulk.VerifyBlobs {
+			if err := verifyStoredBlobs(ctx, client, e); err != nil {
+				return results.record(res.GetGroup().GetType(), res.GetId(), fmt.Errorf("created entity %s/%s, but %w", res.GetGroup().GetType(), res.GetId(), err))
+			}
+		}
+		fmt.Fprintf(bulk.log, "successfully created:  %s/%s\n", res.GetGroup().GetType(), res.GetId())
+		return results.record(res.GetGroup().GetType(), res.GetId(), nil)
+	}
+	if opts.Source != nil {
+		err = bulk.runStream(ctx, opts.Source, create)
+	} else {
+		err = bulk.runWithDependencies(ctx, opts.Entities, entityDependencies(opts.Entities, false), create)
+	}
+	return results.finish(opts.Bulk, streams.Out, err)
+}
+
+// UpdateOptions are the options of UpdateEntities.
+type UpdateOptions struct {
+	// Entities to update. An entity whose commit timestamp is set is only
+	// updated if it matches the commit timestamp of the stored entity.
+	// Otherwise, the commit timestamp of the stored entity is fetched first,
+	// so that changes made between the two requests aren't overwritten.
+	Entities []*nbipb.Entity
+	// Source, if set, is called for the entities to update instead of
+	// using Entities, as with CreateOptions.Source.
+	Source func() (*nbipb.Entity, error)
+	// Force updates the entities regardless of their commit timestamps.
+	Force bool
+	Bulk  BulkOptions
+}
+
+// UpdateEntities updates, or creates if missing, each of the provided
+// entities, in the same order as CreateEntities. It attempts all of them even
+// if some fail, except those that reference a failed entity, and returns an
+// error describing every failure. None are attempted if any would exceed the
+// limits in opts.Bulk.Preflight, unless they're read from opts.Source.
+// Entities that were modified concurrently fail with a *ConflictError.
+func UpdateEntities(ctx context.Context, client nbipb.NetOpsClient, opts UpdateOptions, streams IOStreams) error {
+	results := newBatchRecorder(outputv1.OperationUpdate)
+	bulk := newBulkRunner(opts.Bulk, streams.ErrOut)
+	bulk.onSkip = func(e *nbipb.Entity, err error) { results.record(e.GetGroup().GetType(), e.GetId(), err) }
+	check, err := bulkPreflight(ctx, client, opts.Bulk.Preflight, opts.Entities, opts.Source != nil, bulk.log)
+	if err != nil {
+		return err
+	}
+	update := func(ctx context.Context, e *nbipb.Entity) error {
+		if err := check(e); err != nil {
+			return results.record(e.GetGroup().GetType(), e.GetId(), fmt.Errorf("update failed for entity %s/%s: %w", e.GetGroup().GetType(), e.GetId(), err))
+		}
+		req := &nbipb.UpdateEntityRequest{Entity: e}
+		switch {
+		case opts.Force:
+			req.IgnoreConsistencyCheck = proto.Bool(true)
+		case e.CommitTimestamp == nil:
+			ts, err := storedCommitTimestamp(ctx, client, e)
+			switch {
+			case status.Code(err) == codes.NotFound:
+				// The entity will be created, so there's nothing to overwrite.
+				req.IgnoreConsistencyCheck = proto.Bool(true)
+			case err != nil:
+				return results.record(e.GetGroup().GetType(), e.GetId(), fmt.Errorf("update failed for entity %s/%s: fetching its commit timestamp: %w", e.GetGroup().GetType(), e.GetId(), err))
+			default:
+				req.Entity = proto.Clone(e).(*nbipb.Entity)
+				req.Entity.CommitTimestamp = proto.Int64(ts)
+			}
+		}
+		res, err := client.UpdateEntity(ctx, req)
+		if err != nil {
+			err = withConflictDetails(ctx, client, e, req.GetEntity().GetCommitTimestamp(), err)
+			return results.record(e.GetGroup().GetType(), e.GetId(), fmt.Errorf("update failed for entity %s/%s: %w", e.GetGroup().GetType(), e.GetId(), err))
+		}
+		if opts.Bulk.WaitForReads > 0 {
+			if err := waitUntilReadable(ctx, client, res.GetGroup().GetType(), res.GetId(), res.GetCommitTimestamp(), false, opts.Bulk.WaitForReads); err != nil {
+				return results.record(res.GetGroup().GetType(), res.GetId(), fmt.Errorf("updated entity %s/%s, but %w", res.GetGroup().GetType(), res.GetId(), err))
+			}
+		}
+		if opts.Bulk.VerifyBlobs {
+			if err := verifyStoredBlobs(ctx, client, e); err != nil {
+				return results.record(res.GetGroup().GetType(), res.GetId(), fmt.Errorf("updated entity %s/%s, but %w", res.GetGroup().GetType(), res.GetId(), err))
+			}
+		}
+		fmt.Fprintf(bulk.log, "successfully updated: %s/%s\n", res.GetGroup().GetType(), res.GetId())
+		return results.record(res.GetGroup().GetType(), res.GetId(), nil)
+	}
+	if opts.Source != nil {
+		err = bulk.runStream(ctx, opts.Source, update)
+	} else {
+		err = bulk.runWithDependencies(ctx, opts.Entities, entityDependencies(opts.Entities, false), update)
+	}
+	return results.finish(opts.Bulk, streams.Out, err)
+}
+
+// DeleteOptions are the options of DeleteEntities.
+type DeleteOptions struct {
+	// Entities to delete. Only their type, ID, and commit timestamp are
+	// used. An entity is only deleted if its commit timestamp matches that of
+	// the stored entity; if it isn't set, the commit timestamp of the stored
+	// entity is fetched first.
+	Entities []*nbipb.Entity
+	// IgnoreConsistencyCheck deletes the entities regardless of their commit
+	// timestamps.
+	IgnoreConsistencyCheck bool
+	Bulk                   BulkOptions
+}
+
+// DeleteEntities deletes each of the provided entities, in parallel except
+// that entities are only deleted once the entities that reference them have
+// been. It attempts all of them even if some fail, except those referenced by
+// a failed entity, and returns an error describing every failure.
+// Entities that were modified concurrently fail with a *ConflictError.
+func DeleteEntities(ctx context.Context, client nbipb.NetOpsClient, opts DeleteOptions, streams IOStreams) error {
+	results := newBatchRecorder(outputv1.OperationDelete)
+	bulk := newBulkRunner(opts.Bulk, streams.ErrOut)
+	bulk.onSkip = func(e *nbipb.Entity, err error) { results.record(e.GetGroup().GetType(), e.GetId(), err) }
+	err := bulk.runWithDependencies(ctx, opts.Entities, entityDependencies(opts.Entities, true), func(ctx context.Context, e *nbipb.Entity) error {
+		req := &nbipb.DeleteEntityRequest{Type: e.GetGroup().GetType().Enum(), Id: proto.String(e.GetId()), LastCommitTimestamp: e.CommitTimestamp}
+		switch {
+		case opts.IgnoreConsistencyCheck:
+			req.IgnoreConsistencyCheck = proto.Bool(true)
+		case req.LastCommitTimestamp == nil:
+			ts, err := storedCommitTimestamp(ctx, client, e)
+			if err != nil {
+				return results.record(req.GetType(), req.GetId(), fmt.Errorf("deletion failed for entity %s/%s: fetching its commit timestamp: %w", req.GetType(), req.GetId(), err))
+			}
+			req.LastCommitTimestamp = proto.Int64(ts)
+		}
+		if _, err := client.DeleteEntity(ctx, req); err != nil {
+			err = withConflictDetails(ctx, client, e, req.GetLastCommitTimestamp(), err)
+			return results.record(req.GetType(), req.GetId(), fmt.Errorf("deletion failed for entity %s/%s: %w", req.GetType(), req.GetId(), err))
+		}
+		if opts.Bulk.WaitForReads > 0 {
+			if err := waitUntilReadable(ctx, client, req.GetType(), req.GetId(), 0, true, opts.Bulk.WaitForReads); err != nil {
+				return results.record(req.GetType(), req.GetId(), fmt.Errorf("deleted entity %s/%s, but %w", req.GetType(), req.GetId(), err))
+			}
+		}
+		fmt.Fprintf(bulk.log, "successfully deleted: %s/%s\n", req.GetType(), req.GetId())
+		return results.record(req.GetType(), req.GetId(), nil)
+	})
+	return results.finish(opts.Bulk, streams.Out, err)
+}
@@ -0,0 +1,149 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+	outputv1 "aalyria.com/spacetime/github/tools/nbictl/output/v1"
+)
+
+// mutatingNetOpsClient accepts every create and delete request, except for
+// entities with IDs in `fail`, and records the delete requests it receives.
+type mutatingNetOpsClient struct {
+	nbipb.NetOpsClient
+
+	fail map[string]bool
+
+	mu             sync.Mutex
+	deleteRequests []*nbipb.DeleteEntityRequest
+}
+
+func (c *mutatingNetOpsClient) CreateEntity(_ context.Context, req *nbipb.CreateEntityRequest, _ ...grpc.CallOption) (*nbipb.Entity, error) {
+	if c.fail[req.GetEntity().GetId()] {
+		return nil, status.Error(codes.AlreadyExists, "already exists")
+	}
+	return req.GetEntity(), nil
+}
+
+func (c *mutatingNetOpsClient) DeleteEntity(_ context.Context, req *nbipb.DeleteEntityRequest, _ ...grpc.CallOption) (*nbipb.DeleteEntityResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deleteRequests = append(c.deleteRequests, req)
+	return &nbipb.DeleteEntityResponse{}, nil
+}
+
+func newTestStreams() (IOStreams, *bytes.Buffer, *bytes.Buffer) {
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	return IOStreams{In: strings.NewReader(""), Out: stdout, ErrOut: stderr}, stdout, stderr
+}
+
+func TestCreateEntities(t *testing.T) {
+	t.Parallel()
+
+	client := &mutatingNetOpsClient{fail: map[string]bool{"b": true}}
+	streams, stdout, stderr := newTestStreams()
+	entities := []*nbipb.Entity{
+		{Group: &nbipb.EntityGroup{Type: nbipb.EntityType_NETWORK_NODE.Enum()}, Id: proto.String("a")},
+		{Group: &nbipb.EntityGroup{Type: nbipb.EntityType_NETWORK_NODE.Enum()}, Id: proto.String("b")},
+	}
+
+	err := CreateEntities(context.Background(), client, CreateOptions{Entities: entities, Bulk: BulkOptions{JSON: true}}, streams)
+	if want := "1 of 2 entities failed:\ncreate failed for entity NETWORK_NODE/b"; err == nil || !strings.HasPrefix(err.Error(), want) {
+		t.Fatalf("expected error to start with %q, got %v", want, err)
+	}
+	if want := "successfully created:  NETWORK_NODE/a\n"; stderr.String() != want {
+		t.Errorf("unexpected stderr: want %q, got %q", want, stderr.String())
+	}
+
+	got := &outputv1.BatchResult{}
+	checkErr(t, json.Unmarshal(stdout.Bytes(), got))
+	if got.Succeeded != 1 || got.Failed != 1 || len(got.Results) != 2 || got.Results[1].EntityID != "b" || got.Results[1].Error == "" {
+		t.Errorf("unexpected batch result: %+v", got)
+	}
+}
+
+func TestDeleteEntities(t *testing.T) {
+	t.Parallel()
+
+	client := &mutatingNetOpsClient{}
+	streams, _, _ := newTestStreams()
+	opts := DeleteOptions{
+		Entities: []*nbipb.Entity{{
+			Group:           &nbipb.EntityGroup{Type: nbipb.EntityType_PLATFORM_DEFINITION.Enum()},
+			Id:              proto.String("sat"),
+			CommitTimestamp: proto.Int64(123),
+		}},
+		IgnoreConsistencyCheck: true,
+	}
+	checkErr(t, DeleteEntities(context.Background(), client, opts, streams))
+
+	want := []*nbipb.DeleteEntityRequest{{
+		Type:                   nbipb.EntityType_PLATFORM_DEFINITION.Enum(),
+		Id:                     proto.String("sat"),
+		LastCommitTimestamp:    proto.Int64(123),
+		IgnoreConsistencyCheck: proto.Bool(true),
+	}}
+	if diff := cmp.Diff(want, client.deleteRequests, protocmp.Transform()); diff != "" {
+		t.Fatalf("unexpected requests (-want +got):\n%s", diff)
+	}
+}
+
+func TestListEntities_table(t *testing.T) {
+	t.Parallel()
+
+	client := &listOnlyNetOpsClient{entities: parseTestEntities(t, joinTestPlatforms)}
+	streams, stdout, _ := newTestStreams()
+	opts := ListOptions{
+		Type:   nbipb.EntityType_PLATFORM_DEFINITION,
+		Output: EntityOutputOptions{Table: true, Columns: []string{"name=platform.name"}},
+	}
+	checkErr(t, ListEntities(context.Background(), client, opts, streams))
+
+	want := []string{
+		"TYPE                 ID   name",
+		"PLATFORM_DEFINITION  sat  Satellite",
+		"PLATFORM_DEFINITION  gs   Ground Station",
+	}
+	if diff := cmp.Diff(want, strings.Split(strings.TrimSpace(stdout.String()), "\n")); diff != "" {
+		t.Fatalf("unexpected table (-want +got):\n%s", diff)
+	}
+}
+
+func TestEntityOutputOptions_columnsRequireTable(t *testing.T) {
+	t.Parallel()
+
+	streams, _, _ := newTestStreams()
+	opts := GetOptions{Type: nbipb.EntityType_NETWORK_NODE, ID: "a", Output: EntityOutputOptions{Columns: []string{"name=network_node.name"}}}
+	switch want, err := "columns require table output", GetEntity(context.Background(), &listOnlyNetOpsClient{}, opts, streams); {
+	case err == nil:
+		t.Fatal("expected columns without table output to cause an error, got nil")
+	case !strings.Contains(err.Error(), want):
+		t.Fatalf("expected error to contain %s, but got %s", want, err.Error())
+	}
+}
@@ -0,0 +1,387 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"github.com/jonboulle/clockwork"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+)
+
+const defaultVerifyInterval = 10 * time.Second
+
+// ApplyOptions are the options of ApplyEntities.
+type ApplyOptions struct {
+	// Entities to create or update. Entities that are identical to their
+	// stored version are skipped.
+	Entities []*nbipb.Entity
+	// CanaryPercent, if between 0 and 100, is the percentage of the changed
+	// entities to apply first. The rest are only applied once the checks
+	// have passed for BakeTime. Otherwise, every entity is applied at once
+	// and the checks decide whether the whole change is rolled back.
+	CanaryPercent float64
+	// Checks are verification expressions of the form TYPE:EXPRESSION. The
+	// CEL expression is evaluated against every stored entity of TYPE, and
+	// the check fails if it's false for any of them, e.g.
+	// `INTERFACE_LINK_REPORT:interface_link_report.access_intervals.all(i, i.accessibility == 1)`.
+	Checks []string
+	// BakeTime is how long the checks must keep passing before the change
+	// is considered healthy. If zero, they're evaluated once.
+	BakeTime time.Duration
+	// CheckInterval is how often the checks are evaluated during BakeTime.
+	// Defaults to 10 seconds.
+	CheckInterval time.Duration
+	// Atomic also rolls back the verified canary, along with the rest of
+	// the change, if any of the remaining entities can't be applied. The
+	// NBI has no transactional commits, so this only makes the change all
+	// or nothing once it completes: until the rollback finishes, other
+	// clients can observe the change partially applied.
+	Atomic bool
+	Bulk   BulkOptions
+	// Clock is used to wait between checks. Defaults to the real clock.
+	Clock clockwork.Clock
+}
+
+// applyCheck is a compiled verification expression.
+type applyCheck struct {
+	spec string
+	typ  nbipb.EntityType
+	prg  cel.Program
+}
+
+// appliedEntity is an entity changed by ApplyEntities, along with what's
+// needed to undo the change.
+type appliedEntity struct {
+	entity *nbipb.Entity
+	// previous is the stored version of the entity before the change, or
+	// nil if the entity was created.
+	previous *nbipb.Entity
+	// commitTimestamp is the commit timestamp of the applied change, or 0
+	// if the change hasn't been applied.
+	commitTimestamp int64
+}
+
+// ApplyEntities creates or updates each of the provided entities in stages.
+// The canary stage is applied first and verified with the checks; if any of
+// them fail, or any entity of the stage can't be applied, the stage is
+// rolled back to the stored versions fetched beforehand and an error is
+// returned. Otherwise, the remaining entities are applied, and if any of
+// them fail, the whole change is rolled back when Atomic is set.
+func ApplyEntities(ctx context.Context, client nbipb.NetOpsClient, opts ApplyOptions, streams IOStreams) error {
+	checks, err := compileApplyChecks(opts.Checks)
+	if err != nil {
+		return err
+	}
+	if opts.CheckInterval <= 0 {
+		opts.CheckInterval = defaultVerifyInterval
+	}
+	if opts.Clock == nil {
+		opts.Clock = clockwork.NewRealClock()
+	}
+	if err := preflight(ctx, client, opts.Bulk.Preflight, opts.Entities, streams.ErrOut); err != nil {
+		return err
+	}
+
+	changes, err := fetchChanges(ctx, client, opts)
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		fmt.Fprintln(streams.ErrOut, "no changes to apply")
+		return nil
+	}
+
+	canary := changes
+	if opts.CanaryPercent > 0 && opts.CanaryPercent < 100 {
+		n := int(math.Ceil(float64(len(changes)) * opts.CanaryPercent / 100))
+		canary = changes[:max(1, min(n, len(changes)))]
+	}
+	rest := changes[len(canary):]
+
+	fmt.Fprintf(streams.ErrOut, "applying %d of %d changed entities as a canary\n", len(canary), len(changes))
+	if err := applyStage(ctx, client, opts, canary, streams.ErrOut); err != nil {
+		return rollBack(ctx, client, opts, canary, streams.ErrOut, fmt.Errorf("applying the canary: %w", err))
+	}
+	if err := verify(ctx, opts, client, checks, streams.ErrOut); err != nil {
+		return rollBack(ctx, client, opts, canary, streams.ErrOut, err)
+	}
+	if len(rest) == 0 {
+		fmt.Fprintf(streams.ErrOut, "successfully applied %d entities\n", len(changes))
+		return nil
+	}
+
+	fmt.Fprintf(streams.ErrOut, "canary is healthy, applying the remaining %d entities\n", len(rest))
+	if err := applyStage(ctx, client, opts, rest, streams.ErrOut); err != nil {
+		err = fmt.Errorf("applying the remaining entities: %w", err)
+		if opts.Atomic {
+			return rollBack(ctx, client, opts, changes, streams.ErrOut, err)
+		}
+		// The canary has been verified, so it isn't rolled back along with
+		// the entities that failed.
+		return err
+	}
+	fmt.Fprintf(streams.ErrOut, "successfully applied %d entities\n", len(changes))
+	return nil
+}
+
+// parseCanaryPercent parses a --canary value such as "10%" or "10".
+func parseCanaryPercent(s string) (float64, error) {
+	pct, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "%"), 64)
+	if err != nil || pct <= 0 || pct > 100 {
+		return 0, fmt.Errorf("invalid canary size %q: expected a percentage between 0 and 100, e.g. 10%%", s)
+	}
+	return pct, nil
+}
+
+// compileApplyChecks compiles checks of the form TYPE:EXPRESSION. Like table
+// columns, the expressions are type-checked against the Entity message, and
+// must evaluate to a bool.
+func compileApplyChecks(specs []string) ([]applyCheck, error) {
+	env, err := newEntityExprEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	checks := []applyCheck{}
+	for _, spec := range specs {
+		typName, expr, ok := strings.Cut(spec, ":")
+		typName, expr = strings.TrimSpace(typName), strings.TrimSpace(expr)
+		if !ok || typName == "" || expr == "" {
+			return nil, fmt.Errorf("invalid check %q: expected TYPE:EXPRESSION", spec)
+		}
+		typ, found := nbipb.EntityType_value[typName]
+		if !found {
+			return nil, fmt.Errorf("invalid check %q: unknown entity type %q", spec, typName)
+		}
+		ast, iss := env.Compile(expr)
+		if iss.Err() != nil {
+			return nil, fmt.Errorf("invalid check %q: %w", spec, iss.Err())
+		}
+		if ast.OutputType() != cel.BoolType {
+			return nil, fmt.Errorf("invalid check %q: the expression must evaluate to a bool, not %s", spec, ast.OutputType())
+		}
+		prg, err := env.Program(ast)
+		if err != nil {
+			return nil, fmt.Errorf("invalid check %q: %w", spec, err)
+		}
+		checks = append(checks, applyCheck{spec: spec, typ: nbipb.EntityType(typ), prg: prg})
+	}
+	return checks, nil
+}
+
+// fetchChanges returns the entities that differ from their stored version,
+// sorted by type and ID so the canary is the same across runs.
+func fetchChanges(ctx context.Context, client nbipb.NetOpsClient, opts ApplyOptions) ([]*appliedEntity, error) {
+	mu := &sync.Mutex{}
+	changes := []*appliedEntity{}
+	bulk := newBulkRunner(BulkOptions{Concurrency: opts.Bulk.Concurrency, QPS: opts.Bulk.QPS}, io.Discard)
+	err := bulk.run(ctx, opts.Entities, func(ctx context.Context, e *nbipb.Entity) error {
+		stored, err := client.GetEntity(ctx, &nbipb.GetEntityRequest{Type: e.GetGroup().GetType().Enum(), Id: proto.String(e.GetId())})
+		switch {
+		case status.Code(err) == codes.NotFound:
+			stored = nil
+		case err != nil:
+			return fmt.Errorf("fetching the stored version of entity %s/%s: %w", e.GetGroup().GetType(), e.GetId(), err)
+		case sameContent(e, stored):
+			return nil
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		changes = append(changes, &appliedEntity{entity: e, previous: stored})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		a, b := changes[i].entity, changes[j].entity
+		if a.GetGroup().GetType() != b.GetGroup().GetType() {
+			return a.GetGroup().GetType() < b.GetGroup().GetType()
+		}
+		return a.GetId() < b.GetId()
+	})
+	return changes, nil
+}
+
+// sameContent reports whether `a` and `b` are equal, ignoring the fields set
+// by the server.
+func sameContent(a, b *nbipb.Entity) bool {
+	strip := func(e *nbipb.Entity) *nbipb.Entity {
+		e = proto.Clone(e).(*nbipb.Entity)
+		e.CommitTimestamp, e.NextCommitTimestamp, e.LastModifiedBy = nil, nil, nil
+		return e
+	}
+	return proto.Equal(strip(a), strip(b))
+}
+
+// applyStage updates each of the entities of `stage`, based on the commit
+// timestamp of the version fetched by fetchChanges so changes made since
+// then aren't overwritten.
+func applyStage(ctx context.Context, client nbipb.NetOpsClient, opts ApplyOptions, stage []*appliedEntity, log io.Writer) error {
+	byEntity := map[*nbipb.Entity]*appliedEntity{}
+	entities := []*nbipb.Entity{}
+	for _, a := range stage {
+		byEntity[a.entity] = a
+		entities = append(entities, a.entity)
+	}
+
+	mu := &sync.Mutex{}
+	bulk := newBulkRunner(opts.Bulk, log)
+	return bulk.runWithDependencies(ctx, entities, entityDependencies(entities, false), func(ctx context.Context, e *nbipb.Entity) error {
+		a := byEntity[e]
+		req := &nbipb.UpdateEntityRequest{Entity: proto.Clone(e).(*nbipb.Entity)}
+		if a.previous == nil {
+			req.IgnoreConsistencyCheck = proto.Bool(true)
+		} else {
+			req.Entity.CommitTimestamp = a.previous.CommitTimestamp
+		}
+		res, err := client.UpdateEntity(ctx, req)
+		if err != nil {
+			err = withConflictDetails(ctx, client, e, req.GetEntity().GetCommitTimestamp(), err)
+			return fmt.Errorf("update failed for entity %s/%s: %w", e.GetGroup().GetType(), e.GetId(), err)
+		}
+		if opts.Bulk.VerifyBlobs {
+			if err := verifyStoredBlobs(ctx, client, e); err != nil {
+				return fmt.Errorf("updated entity %s/%s, but %w", res.GetGroup().GetType(), res.GetId(), err)
+			}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		a.commitTimestamp = res.GetCommitTimestamp()
+		fmt.Fprintf(bulk.log, "successfully updated: %s/%s\n", res.GetGroup().GetType(), res.GetId())
+		return nil
+	})
+}
+
+// verify evaluates the checks every CheckInterval until they have passed for
+// BakeTime, and returns an error as soon as one of them fails.
+func verify(ctx context.Context, opts ApplyOptions, client nbipb.NetOpsClient, checks []applyCheck, log io.Writer) error {
+	deadline := opts.Clock.Now().Add(opts.BakeTime)
+	for {
+		if err := evaluateChecks(ctx, client, checks); err != nil {
+			return fmt.Errorf("verification failed: %w", err)
+		}
+		remaining := deadline.Sub(opts.Clock.Now())
+		if remaining <= 0 {
+			return nil
+		}
+		fmt.Fprintf(log, "checks passed, verifying again in %s (%s remaining)\n", min(opts.CheckInterval, remaining), remaining.Round(time.Second))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-opts.Clock.After(min(opts.CheckInterval, remaining)):
+		}
+	}
+}
+
+// evaluateChecks returns an error listing the entities for which each of the
+// checks is false.
+func evaluateChecks(ctx context.Context, client nbipb.NetOpsClient, checks []applyCheck) error {
+	listed := map[nbipb.EntityType][]*nbipb.Entity{}
+	errs := []error{}
+	for _, c := range checks {
+		entities, ok := listed[c.typ]
+		if !ok {
+			res, err := client.ListEntities(ctx, &nbipb.ListEntitiesRequest{Type: c.typ.Enum()})
+			if err != nil {
+				return fmt.Errorf("listing %s entities: %w", c.typ, err)
+			}
+			entities = res.GetEntities()
+			listed[c.typ] = entities
+		}
+
+		failed := []string{}
+		for _, e := range entities {
+			vars, err := cel.ContextProtoVars(e)
+			if err != nil {
+				return err
+			}
+			val, _, err := c.prg.Eval(vars)
+			if err != nil {
+				return fmt.Errorf("evaluating check %q for entity %s/%s: %w", c.spec, e.GetGroup().GetType(), e.GetId(), err)
+			}
+			if ok, _ := val.Value().(bool); !ok {
+				failed = append(failed, e.GetId())
+			}
+		}
+		if len(failed) > 0 {
+			errs = append(errs, fmt.Errorf("check %q is false for %s", c.spec, strings.Join(failed, ", ")))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// rollBack restores the stored version of each applied entity of `stage`,
+// deleting those that were created, and returns `cause` along with any
+// entity that couldn't be restored.
+func rollBack(ctx context.Context, client nbipb.NetOpsClient, opts ApplyOptions, stage []*appliedEntity, log io.Writer, cause error) error {
+	byEntity := map[*nbipb.Entity]*appliedEntity{}
+	entities := []*nbipb.Entity{}
+	for _, a := range stage {
+		if a.commitTimestamp != 0 {
+			byEntity[a.entity] = a
+			entities = append(entities, a.entity)
+		}
+	}
+	fmt.Fprintf(log, "%v\nrolling back %d entities\n", cause, len(entities))
+
+	bulk := newBulkRunner(opts.Bulk, log)
+	mu := &sync.Mutex{}
+	logf := func(format string, args ...any) {
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprintf(bulk.log, format, args...)
+	}
+	err := bulk.run(ctx, entities, func(ctx context.Context, e *nbipb.Entity) error {
+		a := byEntity[e]
+		if a.previous == nil {
+			req := &nbipb.DeleteEntityRequest{Type: e.GetGroup().GetType().Enum(), Id: proto.String(e.GetId()), LastCommitTimestamp: proto.Int64(a.commitTimestamp)}
+			if _, err := client.DeleteEntity(ctx, req); err != nil {
+				err = withConflictDetails(ctx, client, e, a.commitTimestamp, err)
+				return fmt.Errorf("rollback failed for entity %s/%s: %w", e.GetGroup().GetType(), e.GetId(), err)
+			}
+			logf("rolled back: deleted %s/%s\n", e.GetGroup().GetType(), e.GetId())
+			return nil
+		}
+
+		prev := proto.Clone(a.previous).(*nbipb.Entity)
+		prev.CommitTimestamp, prev.NextCommitTimestamp, prev.LastModifiedBy = proto.Int64(a.commitTimestamp), nil, nil
+		if _, err := client.UpdateEntity(ctx, &nbipb.UpdateEntityRequest{Entity: prev}); err != nil {
+			err = withConflictDetails(ctx, client, prev, a.commitTimestamp, err)
+			return fmt.Errorf("rollback failed for entity %s/%s: %w", e.GetGroup().GetType(), e.GetId(), err)
+		}
+		logf("rolled back: restored %s/%s\n", e.GetGroup().GetType(), e.GetId())
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%w; some entities couldn't be rolled back: %w", cause, err)
+	}
+	return fmt.Errorf("%w; the change was rolled back", cause)
+}
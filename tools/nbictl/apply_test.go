@@ -0,0 +1,277 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jonboulle/clockwork"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+	resourcespb "aalyria.com/spacetime/api/nbi/v1alpha/resources"
+	"aalyria.com/spacetime/github/tools/nbictl/nbitest"
+)
+
+func startNBITestServer(t *testing.T) (*nbitest.Server, nbipb.NetOpsClient) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	g, ctx := errgroup.WithContext(ctx)
+	t.Cleanup(func() {
+		cancel()
+		if err := g.Wait(); err != nil {
+			t.Errorf("serving: %v", err)
+		}
+	})
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := nbitest.New()
+	g.Go(func() error { return srv.Serve(ctx, lis) })
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return srv, nbipb.NewNetOpsClient(conn)
+}
+
+func testNetworkNode(id, name string) *nbipb.Entity {
+	return &nbipb.Entity{
+		Group: &nbipb.EntityGroup{Type: nbipb.EntityType_NETWORK_NODE.Enum()},
+		Id:    proto.String(id),
+		Value: &nbipb.Entity_NetworkNode{NetworkNode: &resourcespb.NetworkNode{Name: proto.String(name)}},
+	}
+}
+
+// nodeNames returns the name of each stored network node, by ID.
+func nodeNames(srv *nbitest.Server, ids ...string) map[string]string {
+	names := map[string]string{}
+	for _, id := range ids {
+		if e, ok := srv.Entity(nbipb.EntityType_NETWORK_NODE, id); ok {
+			names[id] = e.GetNetworkNode().GetName()
+		}
+	}
+	return names
+}
+
+func TestApplyEntities(t *testing.T) {
+	t.Parallel()
+
+	const check = `NETWORK_NODE:network_node.name != "broken"`
+	for _, tc := range []struct {
+		name     string
+		nodes    []*nbipb.Entity
+		wantErr  string
+		wantLog  string
+		wantName map[string]string
+	}{
+		{
+			name: "healthy canary",
+			nodes: []*nbipb.Entity{
+				testNetworkNode("a", "a-v2"), testNetworkNode("b", "b-v2"),
+				testNetworkNode("c", "c-v1"), testNetworkNode("d", "d-v1"),
+			},
+			wantLog:  "applying 1 of 3 changed entities as a canary",
+			wantName: map[string]string{"a": "a-v2", "b": "b-v2", "d": "d-v1"},
+		},
+		{
+			name: "failed canary is rolled back",
+			nodes: []*nbipb.Entity{
+				testNetworkNode("a", "broken"), testNetworkNode("b", "b-v2"),
+				testNetworkNode("c", "c-v1"), testNetworkNode("d", "d-v1"),
+			},
+			wantErr:  `check "NETWORK_NODE:network_node.name != \"broken\"" is false for a`,
+			wantLog:  "rolled back: restored NETWORK_NODE/a",
+			wantName: map[string]string{"a": "a-v1", "b": "b-v1"},
+		},
+		{
+			name: "created entities are deleted on rollback",
+			nodes: []*nbipb.Entity{
+				testNetworkNode("0", "broken"), testNetworkNode("a", "a-v2"),
+			},
+			wantErr:  "the change was rolled back",
+			wantLog:  "rolled back: deleted NETWORK_NODE/0",
+			wantName: map[string]string{"a": "a-v1", "b": "b-v1"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv, client := startNBITestServer(t)
+			srv.Put(testNetworkNode("a", "a-v1"), testNetworkNode("b", "b-v1"), testNetworkNode("c", "c-v1"))
+			streams, _, stderr := newTestStreams()
+
+			err := ApplyEntities(context.Background(), client, ApplyOptions{
+				Entities:      tc.nodes,
+				CanaryPercent: 25,
+				Checks:        []string{check},
+			}, streams)
+			switch {
+			case tc.wantErr == "" && err != nil:
+				t.Fatalf("ApplyEntities() failed unexpectedly: %v", err)
+			case tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+				t.Fatalf("ApplyEntities() = %v, want error containing %q", err, tc.wantErr)
+			}
+			if !strings.Contains(stderr.String(), tc.wantLog) {
+				t.Errorf("ApplyEntities() wrote %q, want it to contain %q", stderr.String(), tc.wantLog)
+			}
+			if diff := cmp.Diff(tc.wantName, nodeNames(srv, "0", "a", "b", "d")); diff != "" {
+				t.Errorf("stored network nodes mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestApplyEntities_bakeTime(t *testing.T) {
+	t.Parallel()
+
+	srv, client := startNBITestServer(t)
+	srv.Put(testNetworkNode("a", "a-v1"), testNetworkNode("b", "b-v1"))
+	streams, _, _ := newTestStreams()
+	clock := clockwork.NewFakeClock()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- ApplyEntities(context.Background(), client, ApplyOptions{
+			Entities:      []*nbipb.Entity{testNetworkNode("a", "a-v2"), testNetworkNode("b", "b-v2")},
+			CanaryPercent: 50,
+			Checks:        []string{`NETWORK_NODE:network_node.name != "broken"`},
+			BakeTime:      time.Minute,
+			CheckInterval: 20 * time.Second,
+			Clock:         clock,
+		}, streams)
+	}()
+
+	// The checks pass at 0s, then keep being evaluated until the bake time
+	// has passed. A node breaking in the meantime rolls back the canary.
+	clock.BlockUntil(1)
+	srv.Put(testNetworkNode("b", "broken"))
+	clock.Advance(20 * time.Second)
+
+	err := <-errCh
+	if err == nil || !strings.Contains(err.Error(), "verification failed") {
+		t.Fatalf("ApplyEntities() = %v, want a verification failure", err)
+	}
+	if diff := cmp.Diff(map[string]string{"a": "a-v1", "b": "broken"}, nodeNames(srv, "a", "b")); diff != "" {
+		t.Errorf("stored network nodes mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// failingUpdateClient fails the updates of the entity with ID `id`.
+type failingUpdateClient struct {
+	nbipb.NetOpsClient
+	id string
+}
+
+func (c *failingUpdateClient) UpdateEntity(ctx context.Context, req *nbipb.UpdateEntityRequest, opts ...grpc.CallOption) (*nbipb.Entity, error) {
+	if req.GetEntity().GetId() == c.id {
+		return nil, status.Error(codes.Unavailable, "injected failure")
+	}
+	return c.NetOpsClient.UpdateEntity(ctx, req, opts...)
+}
+
+func TestApplyEntities_atomic(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		atomic   bool
+		wantErr  string
+		wantName map[string]string
+	}{
+		{
+			atomic:   false,
+			wantErr:  "applying the remaining entities",
+			wantName: map[string]string{"a": "a-v2", "b": "b-v1", "c": "c-v2"},
+		},
+		{
+			atomic:   true,
+			wantErr:  "the change was rolled back",
+			wantName: map[string]string{"a": "a-v1", "b": "b-v1", "c": "c-v1"},
+		},
+	} {
+		t.Run(fmt.Sprintf("atomic=%t", tc.atomic), func(t *testing.T) {
+			t.Parallel()
+
+			srv, client := startNBITestServer(t)
+			srv.Put(testNetworkNode("a", "a-v1"), testNetworkNode("b", "b-v1"), testNetworkNode("c", "c-v1"))
+			streams, _, _ := newTestStreams()
+
+			err := ApplyEntities(context.Background(), &failingUpdateClient{NetOpsClient: client, id: "b"}, ApplyOptions{
+				Entities:      []*nbipb.Entity{testNetworkNode("a", "a-v2"), testNetworkNode("b", "b-v2"), testNetworkNode("c", "c-v2")},
+				CanaryPercent: 10,
+				Atomic:        tc.atomic,
+			}, streams)
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("ApplyEntities() = %v, want error containing %q", err, tc.wantErr)
+			}
+			if diff := cmp.Diff(tc.wantName, nodeNames(srv, "a", "b", "c")); diff != "" {
+				t.Errorf("stored network nodes mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParseCanaryPercent(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		in      string
+		want    float64
+		wantErr bool
+	}{
+		{in: "10%", want: 10},
+		{in: "12.5", want: 12.5},
+		{in: "100%", want: 100},
+		{in: "0%", wantErr: true},
+		{in: "150%", wantErr: true},
+		{in: "ten", wantErr: true},
+	} {
+		got, err := parseCanaryPercent(tc.in)
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Errorf("parseCanaryPercent(%q) = %v, %v; want %v, error: %t", tc.in, got, err, tc.want, tc.wantErr)
+		}
+	}
+}
+
+func TestCompileApplyChecks_errors(t *testing.T) {
+	t.Parallel()
+
+	for _, spec := range []string{
+		"network_node.name == 'a'",
+		"NOT_A_TYPE:true",
+		"NETWORK_NODE:network_node.nmae == 'a'",
+		"NETWORK_NODE:network_node.name",
+	} {
+		if _, err := compileApplyChecks([]string{spec}); err == nil {
+			t.Errorf("compileApplyChecks(%q) succeeded, want an error", spec)
+		}
+	}
+}
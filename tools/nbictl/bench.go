@@ -0,0 +1,223 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+	outputv1 "aalyria.com/spacetime/github/tools/nbictl/output/v1"
+)
+
+// benchRPCs are the RPCs `bench` can call. They're all read-only, so
+// benchmarking doesn't change the server's state.
+var benchRPCs = map[string]func(context.Context, nbipb.NetOpsClient, BenchOptions) error{
+	"GetEntity": func(ctx context.Context, client nbipb.NetOpsClient, opts BenchOptions) error {
+		_, err := client.GetEntity(ctx, &nbipb.GetEntityRequest{Type: opts.Type.Enum(), Id: proto.String(opts.ID)})
+		return err
+	},
+	"ListEntities": func(ctx context.Context, client nbipb.NetOpsClient, opts BenchOptions) error {
+		_, err := client.ListEntities(ctx, &nbipb.ListEntitiesRequest{Type: opts.Type.Enum()})
+		return err
+	},
+	"VersionInfo": func(ctx context.Context, client nbipb.NetOpsClient, _ BenchOptions) error {
+		_, err := client.VersionInfo(ctx, &nbipb.VersionInfoRequest{})
+		return err
+	},
+}
+
+// BenchOptions are the options of RunBenchmark.
+type BenchOptions struct {
+	// RPC is the name of the NetOps method to call; one of GetEntity,
+	// ListEntities and VersionInfo.
+	RPC string
+	// Type and ID identify the entities to get or list.
+	Type nbipb.EntityType
+	ID   string
+	// Concurrency is the number of calls to keep in flight. Defaults to 1.
+	Concurrency int
+	// Duration is how long to keep calling the RPC for.
+	Duration time.Duration
+	// QPS, if positive, is the maximum number of calls to start per second.
+	QPS float64
+	// JSON prints an outputv1.BenchmarkResult instead of a text summary.
+	JSON bool
+	// Template, if set along with JSON, renders the BenchmarkResult with the
+	// template instead of printing it as JSON.
+	Template *template.Template
+	// Timestamps is the format of the timestamps of the JSON document.
+	// Defaults to TimestampsRFC3339.
+	Timestamps TimestampFormat
+}
+
+// RunBenchmark calls an RPC repeatedly for a while and prints a summary of the
+// latency of the calls and of the errors they failed with. Calls that are
+// still in flight once the duration is up are waited for and counted.
+func RunBenchmark(ctx context.Context, client nbipb.NetOpsClient, opts BenchOptions, streams IOStreams) error {
+	call, ok := benchRPCs[opts.RPC]
+	switch {
+	case !ok:
+		return fmt.Errorf("unsupported RPC %q, expected one of [%s]", opts.RPC, strings.Join(slices.Sorted(maps.Keys(benchRPCs)), ", "))
+	case opts.Duration <= 0:
+		return fmt.Errorf("the duration must be positive, got %v", opts.Duration)
+	case opts.RPC == "GetEntity" && opts.ID == "":
+		return errors.New("benchmarking GetEntity requires an entity ID")
+	}
+	concurrency := max(opts.Concurrency, 1)
+
+	// Workers stop starting calls once `runCtx` is done, but the calls
+	// themselves use `ctx` so that the last ones aren't counted as failed.
+	runCtx, cancel := context.WithTimeout(ctx, opts.Duration)
+	defer cancel()
+	wait, stop := newRateLimiter(opts.QPS)
+	defer stop()
+
+	mu := &sync.Mutex{}
+	latencies := []time.Duration{}
+	errCodes := map[string]int{}
+	wg := &sync.WaitGroup{}
+	start := time.Now()
+	for range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for wait(runCtx) == nil {
+				callStart := time.Now()
+				err := call(ctx, client, opts)
+				elapsed := time.Since(callStart)
+
+				mu.Lock()
+				if err != nil {
+					errCodes[status.Code(err).String()]++
+				} else {
+					latencies = append(latencies, elapsed)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	result := benchmarkResult(opts.RPC, concurrency, time.Since(start), latencies, errCodes)
+	if opts.JSON {
+		return writeDocument(streams.Out, opts.Template, opts.Timestamps, result)
+	}
+	return writeBenchmarkResult(streams, result)
+}
+
+func benchmarkResult(rpc string, concurrency int, elapsed time.Duration, latencies []time.Duration, errCodes map[string]int) *outputv1.BenchmarkResult {
+	r := outputv1.NewBenchmarkResult(rpc)
+	r.Concurrency = concurrency
+	r.DurationSeconds = elapsed.Seconds()
+	for code, n := range errCodes {
+		r.ErrorCodes = append(r.ErrorCodes, outputv1.ErrorCount{Code: code, Count: n})
+		r.Errors += n
+	}
+	slices.SortFunc(r.ErrorCodes, func(a, b outputv1.ErrorCount) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.Code, b.Code))
+	})
+	r.Calls = len(latencies) + r.Errors
+	if r.Calls > 0 {
+		r.ErrorRate = float64(r.Errors) / float64(r.Calls)
+	}
+	if elapsed > 0 {
+		r.CallsPerSecond = float64(r.Calls) / elapsed.Seconds()
+	}
+	if len(latencies) == 0 {
+		return r
+	}
+
+	slices.Sort(latencies)
+	var total time.Duration
+	for _, l := range latencies {
+		total += l
+	}
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	// percentile uses the nearest-rank method.
+	percentile := func(p float64) time.Duration {
+		return latencies[max(int(math.Ceil(p/100*float64(len(latencies))))-1, 0)]
+	}
+	r.Latency = &outputv1.LatencySummary{
+		MinMs:  ms(latencies[0]),
+		MeanMs: ms(total / time.Duration(len(latencies))),
+		P50Ms:  ms(percentile(50)),
+		P90Ms:  ms(percentile(90)),
+		P99Ms:  ms(percentile(99)),
+		MaxMs:  ms(latencies[len(latencies)-1]),
+	}
+	return r
+}
+
+func writeBenchmarkResult(streams IOStreams, r *outputv1.BenchmarkResult) error {
+	w := streams.Out
+	fmt.Fprintf(w, "%s: %d calls in %.1fs with %d in flight (%.1f calls/s)\n", r.RPC, r.Calls, r.DurationSeconds, r.Concurrency, r.CallsPerSecond)
+	fmt.Fprintf(w, "errors: %d (%.2f%%)\n", r.Errors, 100*r.ErrorRate)
+	for _, e := range r.ErrorCodes {
+		fmt.Fprintf(w, "  %s: %d\n", e.Code, e.Count)
+	}
+	if l := r.Latency; l != nil {
+		_, err := fmt.Fprintf(w, "latency: min %.2fms, mean %.2fms, p50 %.2fms, p90 %.2fms, p99 %.2fms, max %.2fms\n", l.MinMs, l.MeanMs, l.P50Ms, l.P90Ms, l.P99Ms, l.MaxMs)
+		return err
+	}
+	_, err := fmt.Fprintln(w, "latency: no calls succeeded")
+	return err
+}
+
+func Bench(appCtx *cli.Context) error {
+	tmpl, err := outputTemplateFromFlags(appCtx)
+	if err != nil {
+		return err
+	}
+	opts := BenchOptions{
+		RPC:         appCtx.String("rpc"),
+		ID:          appCtx.String("id"),
+		Concurrency: appCtx.Int("concurrency"),
+		Duration:    appCtx.Duration("duration"),
+		QPS:         appCtx.Float64(qpsFlag.Name),
+		JSON:        jsonOutputRequested(appCtx),
+		Template:    tmpl,
+		Timestamps:  timestampFormatFromFlags(appCtx),
+	}
+	entityType, found := nbipb.EntityType_value[appCtx.String("type")]
+	if !found {
+		return fmt.Errorf("invalid type: %q", appCtx.String("type"))
+	}
+	opts.Type = nbipb.EntityType(entityType)
+
+	conn, err := openServiceConnection(appCtx, nbipb.NetOps_ServiceDesc.ServiceName)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return RunBenchmark(appCtx.Context, nbipb.NewNetOpsClient(conn), opts, ioStreamsFromContext(appCtx))
+}
@@ -0,0 +1,120 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+	outputv1 "aalyria.com/spacetime/github/tools/nbictl/output/v1"
+)
+
+func TestRunBenchmark(t *testing.T) {
+	t.Parallel()
+
+	srv, client := startNBITestServer(t)
+	srv.Put(testNetworkNode("a", "first"))
+
+	t.Run("json", func(t *testing.T) {
+		t.Parallel()
+
+		streams, stdout, _ := newTestStreams()
+		checkErr(t, RunBenchmark(context.Background(), client, BenchOptions{
+			RPC:         "ListEntities",
+			Type:        nbipb.EntityType_NETWORK_NODE,
+			Concurrency: 4,
+			Duration:    100 * time.Millisecond,
+			JSON:        true,
+		}, streams))
+
+		got := outputv1.BenchmarkResult{}
+		checkErr(t, json.Unmarshal(stdout.Bytes(), &got))
+		if got.Calls == 0 || got.Errors != 0 || got.Concurrency != 4 || got.Latency == nil {
+			t.Fatalf("unexpected result: %+v", got)
+		}
+		if l := got.Latency; l.MinMs > l.P50Ms || l.P50Ms > l.P99Ms || l.P99Ms > l.MaxMs {
+			t.Errorf("latencies aren't ordered: %+v", l)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		t.Parallel()
+
+		streams, stdout, _ := newTestStreams()
+		checkErr(t, RunBenchmark(context.Background(), client, BenchOptions{
+			RPC:      "GetEntity",
+			Type:     nbipb.EntityType_NETWORK_NODE,
+			ID:       "missing",
+			Duration: 50 * time.Millisecond,
+		}, streams))
+
+		out := stdout.String()
+		if !strings.Contains(out, "(100.00%)") || !strings.Contains(out, "NotFound: ") || !strings.Contains(out, "no calls succeeded") {
+			t.Errorf("unexpected output:\n%s", out)
+		}
+	})
+}
+
+func TestRunBenchmark_rejectsInvalidOptions(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name    string
+		opts    BenchOptions
+		wantErr string
+	}{
+		{"unknown RPC", BenchOptions{RPC: "CreateEntity", Duration: time.Second}, `unsupported RPC "CreateEntity"`},
+		{"no duration", BenchOptions{RPC: "VersionInfo"}, "duration must be positive"},
+		{"GetEntity without ID", BenchOptions{RPC: "GetEntity", Duration: time.Second}, "requires an entity ID"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			streams, _, _ := newTestStreams()
+			if err := RunBenchmark(context.Background(), nil, tc.opts, streams); err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("expected error to contain %q, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestBenchmarkResult(t *testing.T) {
+	t.Parallel()
+
+	latencies := []time.Duration{}
+	for i := 100; i > 0; i-- {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	got := benchmarkResult("VersionInfo", 2, 10*time.Second, latencies, map[string]int{"Unavailable": 5, "DeadlineExceeded": 20, "Internal": 5})
+
+	want := outputv1.NewBenchmarkResult("VersionInfo")
+	want.Concurrency = 2
+	want.DurationSeconds = 10
+	want.Calls = 130
+	want.Errors = 30
+	want.ErrorRate = 30.0 / 130
+	want.CallsPerSecond = 13
+	want.Latency = &outputv1.LatencySummary{MinMs: 1, MeanMs: 50.5, P50Ms: 50, P90Ms: 90, P99Ms: 99, MaxMs: 100}
+	want.ErrorCodes = []outputv1.ErrorCount{{Code: "DeadlineExceeded", Count: 20}, {Code: "Internal", Count: 5}, {Code: "Unavailable", Count: 5}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("benchmarkResult mismatch (-want +got):\n%s", diff)
+	}
+}
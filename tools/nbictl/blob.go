@@ -0,0 +1,172 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+)
+
+// compressionNone disables compression. Any other value of the
+// `--compression` flag names a compressor registered with gRPC's encoding
+// package, of which nbictl installs gzip.
+const compressionNone = "none"
+
+var (
+	compressionFlag = &cli.StringFlag{
+		Name:  "compression",
+		Usage: "Compression of the requests sent to the server. Allowed values: [gzip, none]. Large payloads like antenna patterns and coverage grids shrink considerably, at the cost of some CPU time.",
+		Value: "gzip",
+		Action: func(_ *cli.Context, name string) error {
+			if name != compressionNone && encoding.GetCompressor(name) == nil {
+				return fmt.Errorf("unknown --compression %q, expected one of [gzip, none]", name)
+			}
+			return nil
+		},
+	}
+	verifyBlobsFlag = &cli.BoolFlag{
+		Name:  "verify_blobs",
+		Usage: "Read each entity back once it's written and check that its blob fields, such as antenna pattern gains and S2 cell IDs, were stored intact, by comparing their SHA-256 digests. Useful when sending large entities over unreliable links.",
+	}
+)
+
+// compressionDialOpts returns the dial options that compress requests as the
+// `--compression` flag selects.
+func compressionDialOpts(appCtx *cli.Context) []grpc.DialOption {
+	name := appCtx.String(compressionFlag.Name)
+	switch name {
+	case "":
+		return nil
+	case compressionNone:
+		name = encoding.Identity
+	}
+	return []grpc.DialOption{grpc.WithDefaultCallOptions(grpc.UseCompressor(name))}
+}
+
+// blobDigests returns the SHA-256 digest of each blob field of `m`, keyed by
+// its path (e.g. "antenna_pattern.custom_phi_theta_pattern.gain_value").
+// Blob fields are bytes fields and repeated fields, which is where large
+// payloads such as antenna pattern gains and S2 coverings are held. The NBI
+// has no way to transfer them in chunks, so they're always sent within their
+// entity.
+func blobDigests(m proto.Message) map[string][sha256.Size]byte {
+	digests := map[string][sha256.Size]byte{}
+	addBlobDigests(digests, "", m.ProtoReflect())
+	return digests
+}
+
+func addBlobDigests(digests map[string][sha256.Size]byte, prefix string, m protoreflect.Message) {
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		path := prefix + string(fd.Name())
+		switch {
+		case fd.IsMap():
+			if fd.MapValue().Kind() != protoreflect.MessageKind {
+				break
+			}
+			v.Map().Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+				addBlobDigests(digests, fmt.Sprintf("%s[%v].", path, k.Interface()), v.Message())
+				return true
+			})
+		case fd.IsList():
+			h := sha256.New()
+			for i := range v.List().Len() {
+				hashValue(h, fd, v.List().Get(i))
+			}
+			digests[path] = [sha256.Size]byte(h.Sum(nil))
+		case fd.Kind() == protoreflect.MessageKind || fd.Kind() == protoreflect.GroupKind:
+			addBlobDigests(digests, path+".", v.Message())
+		case fd.Kind() == protoreflect.BytesKind:
+			digests[path] = sha256.Sum256(v.Bytes())
+		}
+		return true
+	})
+}
+
+// hashValue writes an element of a repeated field to `h`.
+func hashValue(h hash.Hash, fd protoreflect.FieldDescriptor, v protoreflect.Value) {
+	var n uint64
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		b, _ := proto.MarshalOptions{Deterministic: true}.Marshal(v.Message().Interface())
+		writeWithLength(h, b)
+		return
+	case protoreflect.BytesKind:
+		writeWithLength(h, v.Bytes())
+		return
+	case protoreflect.StringKind:
+		writeWithLength(h, []byte(v.String()))
+		return
+	case protoreflect.DoubleKind, protoreflect.FloatKind:
+		n = math.Float64bits(v.Float())
+	case protoreflect.BoolKind:
+		if v.Bool() {
+			n = 1
+		}
+	case protoreflect.EnumKind:
+		n = uint64(v.Enum())
+	case protoreflect.Uint32Kind, protoreflect.Uint64Kind, protoreflect.Fixed32Kind, protoreflect.Fixed64Kind:
+		n = v.Uint()
+	default:
+		n = uint64(v.Int())
+	}
+	h.Write(binary.LittleEndian.AppendUint64(nil, n))
+}
+
+// writeWithLength writes `b` to `h` prefixed by its length, so that the
+// elements ["ab", "c"] and ["a", "bc"] hash differently.
+func writeWithLength(h hash.Hash, b []byte) {
+	h.Write(binary.LittleEndian.AppendUint64(nil, uint64(len(b))))
+	h.Write(b)
+}
+
+// verifyStoredBlobs reads back the entity that `sent` was written as and
+// checks that each of the blob fields of `sent` was stored intact.
+func verifyStoredBlobs(ctx context.Context, client nbipb.NetOpsClient, sent *nbipb.Entity) error {
+	want := blobDigests(sent)
+	if len(want) == 0 {
+		return nil
+	}
+	stored, err := client.GetEntity(ctx, &nbipb.GetEntityRequest{Type: sent.GetGroup().GetType().Enum(), Id: proto.String(sent.GetId())})
+	if err != nil {
+		return fmt.Errorf("reading it back to verify its blob fields: %w", err)
+	}
+	got := blobDigests(stored)
+
+	mismatched := []string{}
+	for path, digest := range want {
+		if got[path] != digest {
+			mismatched = append(mismatched, path)
+		}
+	}
+	if len(mismatched) == 0 {
+		return nil
+	}
+	slices.Sort(mismatched)
+	return fmt.Errorf("the stored entity's blob fields don't match what was sent: %s", strings.Join(mismatched, ", "))
+}
@@ -0,0 +1,96 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/rules_go/go/tools/bazel"
+	"google.golang.org/protobuf/proto"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+	resourcespb "aalyria.com/spacetime/api/nbi/v1alpha/resources"
+)
+
+func testSurfaceRegion(id string, cells ...int64) *nbipb.Entity {
+	return &nbipb.Entity{
+		Group: &nbipb.EntityGroup{Type: nbipb.EntityType_SURFACE_REGION.Enum()},
+		Id:    proto.String(id),
+		Value: &nbipb.Entity_SurfaceRegion{SurfaceRegion: &resourcespb.SurfaceRegion{S2CellIds: cells, Name: proto.String(id)}},
+	}
+}
+
+func TestBlobDigests(t *testing.T) {
+	t.Parallel()
+
+	digests := blobDigests(testSurfaceRegion("region", 1, 2, 3))
+	if len(digests) != 1 {
+		t.Fatalf("blobDigests returned %d digests, want 1 for surface_region.s2_cell_ids: %v", len(digests), digests)
+	}
+	const path = "surface_region.s2_cell_ids"
+	if _, ok := digests[path]; !ok {
+		t.Fatalf("blobDigests has no digest for %s: %v", path, digests)
+	}
+	if blobDigests(testSurfaceRegion("region", 1, 2, 3))[path] != digests[path] {
+		t.Error("blobDigests of the same cells differ")
+	}
+	if blobDigests(testSurfaceRegion("region", 1, 3, 2))[path] == digests[path] {
+		t.Error("blobDigests of reordered cells are the same")
+	}
+}
+
+func TestVerifyStoredBlobs(t *testing.T) {
+	t.Parallel()
+
+	srv, client := startNBITestServer(t)
+	ctx := context.Background()
+
+	sent := testSurfaceRegion("region", 1, 2, 3)
+	srv.Put(sent)
+	if err := verifyStoredBlobs(ctx, client, sent); err != nil {
+		t.Errorf("verifyStoredBlobs of an intact entity: %v", err)
+	}
+
+	srv.Put(testSurfaceRegion("region", 1, 2))
+	if err := verifyStoredBlobs(ctx, client, sent); err == nil || !strings.Contains(err.Error(), "surface_region.s2_cell_ids") {
+		t.Errorf("verifyStoredBlobs of a truncated entity returned %v, want an error naming surface_region.s2_cell_ids", err)
+	}
+}
+
+func TestCreateEntities_verifyBlobs(t *testing.T) {
+	t.Parallel()
+
+	_, client := startNBITestServer(t)
+	streams, _, _ := newTestStreams()
+	opts := CreateOptions{
+		Entities: []*nbipb.Entity{testSurfaceRegion("region", 1, 2, 3)},
+		Bulk:     BulkOptions{VerifyBlobs: true},
+	}
+	checkErr(t, CreateEntities(context.Background(), client, opts, streams))
+}
+
+func TestCompressionFlag(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := bazel.NewTmpDir("nbictl")
+	checkErr(t, err)
+
+	checkErr(t, newTestApp().Run([]string{"nbictl", "--config_dir", tmpDir, "--compression", "none", "list-configs"}))
+	if err := newTestApp().Run([]string{"nbictl", "--config_dir", tmpDir, "--compression", "brotli", "list-configs"}); err == nil || !strings.Contains(err.Error(), `unknown --compression "brotli"`) {
+		t.Errorf("--compression=brotli returned %v, want an unknown compression error", err)
+	}
+}
@@ -0,0 +1,533 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+)
+
+const (
+	defaultBulkConcurrency = 16
+
+	progressBarWidth = 30
+	// progressRedrawInterval limits how often the progress bar is redrawn,
+	// since operations on small entities can complete much faster than a
+	// terminal can usefully display.
+	progressRedrawInterval = 100 * time.Millisecond
+)
+
+var (
+	concurrencyFlag = &cli.IntFlag{
+		Name:  "concurrency",
+		Usage: "Maximum number of entities to process at the same time.",
+		Value: defaultBulkConcurrency,
+		Action: func(_ *cli.Context, n int) error {
+			if n < 1 {
+				return fmt.Errorf("--concurrency must be at least 1, got %d", n)
+			}
+			return nil
+		},
+	}
+	qpsFlag = &cli.Float64Flag{
+		Name:  "qps",
+		Usage: "Maximum number of requests to send per second. If unset or 0, requests are only limited by --concurrency.",
+		Action: func(_ *cli.Context, qps float64) error {
+			if qps < 0 {
+				return fmt.Errorf("--qps can't be negative, got %v", qps)
+			}
+			return nil
+		},
+	}
+)
+
+// bulkRunner applies an operation to many entities using a pool of workers,
+// optionally limited to a number of operations per second. Unlike an
+// errgroup, it keeps going when an operation fails and reports every failure
+// once all of them have been attempted.
+type bulkRunner struct {
+	concurrency int
+	qps         float64
+
+	// log is where progress messages about individual entities should be
+	// written, so they don't garble the progress bar.
+	log      io.Writer
+	progress *progressBar
+	// onSkip, if set, is called with each entity that isn't attempted
+	// because an entity it depends on failed, and the resulting error.
+	onSkip func(*nbipb.Entity, error)
+}
+
+// newBulkRunner returns a runner that writes its progress to `errOut`.
+func newBulkRunner(opts BulkOptions, errOut io.Writer) *bulkRunner {
+	b := &bulkRunner{
+		concurrency: opts.Concurrency,
+		qps:         opts.QPS,
+		log:         errOut,
+	}
+	if b.concurrency < 1 {
+		b.concurrency = defaultBulkConcurrency
+	}
+	if isTerminal(errOut) {
+		b.progress = &progressBar{out: errOut}
+		b.log = b.progress
+	}
+	return b
+}
+
+func bulkOptionsFromFlags(appCtx *cli.Context) (BulkOptions, error) {
+	tmpl, err := outputTemplateFromFlags(appCtx)
+	if err != nil {
+		return BulkOptions{}, err
+	}
+	return BulkOptions{
+		Concurrency:  appCtx.Int(concurrencyFlag.Name),
+		QPS:          appCtx.Float64(qpsFlag.Name),
+		JSON:         jsonOutputRequested(appCtx),
+		Template:     tmpl,
+		Timestamps:   timestampFormatFromFlags(appCtx),
+		WaitForReads: appCtx.Duration(waitForReadsFlag.Name),
+		VerifyBlobs:  appCtx.Bool(verifyBlobsFlag.Name),
+		Preflight:    preflightOptionsFromFlags(appCtx),
+	}, nil
+}
+
+// run calls `f` for each of `entities` and returns an error that wraps
+// the error of every call that failed, if any did.
+func (b *bulkRunner) run(ctx context.Context, entities []*nbipb.Entity, f func(context.Context, *nbipb.Entity) error) error {
+	return b.runWithDependencies(ctx, entities, nil, f)
+}
+
+// bulkResult is the outcome of calling the operation of a bulkRunner for the
+// entity at `index`.
+type bulkResult struct {
+	index int
+	err   error
+	// canceled is set if the operation wasn't called because the context
+	// was canceled first.
+	canceled bool
+}
+
+// runWithDependencies is like run, but only calls `f` for an entity once it
+// has returned successfully for each of the entities it depends on, given
+// as indices into `entities` by `deps` (see entityDependencies). Entities
+// that depend on one that failed aren't attempted, and fail too. Independent
+// entities are processed in parallel, in the order they're provided.
+//
+// Entities that are part of a dependency cycle are started once nothing
+// else can be, without waiting for each other.
+func (b *bulkRunner) runWithDependencies(ctx context.Context, entities []*nbipb.Entity, deps [][]int, f func(context.Context, *nbipb.Entity) error) error {
+	wait, stop := newRateLimiter(b.qps)
+	defer stop()
+	if b.progress != nil {
+		b.progress.start(len(entities))
+		defer b.progress.finish()
+	}
+
+	pending := make([]int, len(entities))
+	dependents := make([][]int, len(entities))
+	for i, d := range deps {
+		pending[i] = len(d)
+		for _, j := range d {
+			dependents[j] = append(dependents[j], i)
+		}
+	}
+	started := make([]bool, len(entities))
+	ready := []int{}
+	for i := range entities {
+		if pending[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+
+	work := make(chan int)
+	results := make(chan bulkResult)
+	wg := &sync.WaitGroup{}
+	for range min(b.concurrency, len(entities)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				if err := wait(ctx); err != nil {
+					results <- bulkResult{index: i, canceled: true}
+					continue
+				}
+				results <- bulkResult{index: i, err: f(ctx, entities[i])}
+			}
+		}()
+	}
+
+	log := moduleLoggerTo(ctx, logModuleBulk, b.log)
+	errs := []error{}
+	processed, inFlight := 0, 0
+	finish := func(i int, err error) {
+		processed++
+		if err != nil {
+			errs = append(errs, err)
+		}
+		e := entities[i]
+		log.Debug("processed entity", "type", e.GetGroup().GetType().String(), "id", e.GetId(), "error", err)
+		if b.progress != nil {
+			b.progress.add(err != nil)
+		}
+	}
+	// skipDependents fails every entity that transitively depends on the
+	// entity at `failed`.
+	var skipDependents func(failed int)
+	skipDependents = func(failed int) {
+		for _, d := range dependents[failed] {
+			if started[d] {
+				continue
+			}
+			started[d] = true
+			e, dep := entities[d], entities[failed]
+			err := fmt.Errorf("skipped entity %s/%s because entity %s/%s, which it depends on, failed", e.GetGroup().GetType(), e.GetId(), dep.GetGroup().GetType(), dep.GetId())
+			if b.onSkip != nil {
+				b.onSkip(e, err)
+			}
+			finish(d, err)
+			skipDependents(d)
+		}
+	}
+
+	done := ctx.Done()
+	for processed < len(entities) {
+		var send chan int
+		next := -1
+		for len(ready) > 0 && started[ready[0]] {
+			ready = ready[1:]
+		}
+		if len(ready) > 0 && ctx.Err() == nil {
+			send, next = work, ready[0]
+		}
+		if send == nil && inFlight == 0 {
+			if ctx.Err() != nil {
+				break
+			}
+			// Everything that's left is waiting on a cycle.
+			for i := range entities {
+				if !started[i] {
+					ready = append(ready, i)
+				}
+			}
+			continue
+		}
+
+		select {
+		case send <- next:
+			started[next] = true
+			ready = ready[1:]
+			inFlight++
+		case r := <-results:
+			inFlight--
+			if r.canceled {
+				continue
+			}
+			finish(r.index, r.err)
+			if r.err != nil {
+				skipDependents(r.index)
+				continue
+			}
+			for _, d := range dependents[r.index] {
+				if pending[d]--; pending[d] == 0 && !started[d] {
+					ready = append(ready, d)
+				}
+			}
+		case <-done:
+			// Stop feeding work, but wait for the operations in flight.
+			done = nil
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	err := joinBulkErrors(errs, len(entities))
+	if processed < len(entities) {
+		err = errors.Join(fmt.Errorf("%d of %d entities weren't attempted: %w", len(entities)-processed, len(entities), ctx.Err()), err)
+	}
+	return err
+}
+
+// runStream is like runWithDependencies, but reads the entities from `next`
+// until it returns io.EOF, one at a time as workers become available, so
+// that no more than one entity per worker, plus the one being read, is held
+// at once. An entity is only started once the entities it references, and
+// any earlier entity with the same type and ID, that are being processed
+// have returned, and fails without being attempted if one of them failed.
+// References to entities that haven't been read yet aren't waited for, so
+// entities should come after those they reference.
+//
+// Reading stops at the first error returned by `next`, which is returned
+// along with those of the entities read until then.
+func (b *bulkRunner) runStream(ctx context.Context, next func() (*nbipb.Entity, error), f func(context.Context, *nbipb.Entity) error) error {
+	wait, stop := newRateLimiter(b.qps)
+	defer stop()
+	if b.progress != nil {
+		b.progress.start(-1)
+		defer b.progress.finish()
+	}
+
+	log := moduleLoggerTo(ctx, logModuleBulk, b.log)
+	mu := &sync.Mutex{}
+	errs := []error{}
+	processed := 0
+	// running holds a channel for each entity being processed, which is
+	// closed once it's done. failed holds the entities that failed, which
+	// the entities that depend on them are skipped for.
+	running := map[entityRef]chan struct{}{}
+	failed := map[entityRef]bool{}
+	// finish must be called with `mu` held.
+	finish := func(e *nbipb.Entity, err error) {
+		processed++
+		if err != nil {
+			errs = append(errs, err)
+			failed[entityRef{entityType: e.GetGroup().GetType(), id: e.GetId()}] = true
+		}
+		log.Debug("processed entity", "type", e.GetGroup().GetType().String(), "id", e.GetId(), "error", err)
+		if b.progress != nil {
+			b.progress.add(err != nil)
+		}
+	}
+
+	work := make(chan *nbipb.Entity)
+	wg := &sync.WaitGroup{}
+	for range b.concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for e := range work {
+				canceled := wait(ctx) != nil
+				var err error
+				if !canceled {
+					err = f(ctx, e)
+				}
+
+				mu.Lock()
+				if !canceled {
+					finish(e, err)
+				}
+				ref := entityRef{entityType: e.GetGroup().GetType(), id: e.GetId()}
+				close(running[ref])
+				delete(running, ref)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	read := 0
+	var readErr error
+	// feed hands `e` to a worker once the entities it depends on are done,
+	// or fails it if one of them failed. It returns false if `ctx` is done
+	// first.
+	feed := func(e *nbipb.Entity) bool {
+		ref := entityRef{entityType: e.GetGroup().GetType(), id: e.GetId()}
+		for _, dep := range append([]entityRef{ref}, collectReferences(e.ProtoReflect())...) {
+			mu.Lock()
+			done, ok := running[dep]
+			mu.Unlock()
+			if ok {
+				select {
+				case <-done:
+				case <-ctx.Done():
+					return false
+				}
+			}
+
+			mu.Lock()
+			depFailed := dep != ref && failed[dep]
+			if depFailed {
+				err := fmt.Errorf("skipped entity %s/%s because entity %s/%s, which it depends on, failed", ref.entityType, ref.id, dep.entityType, dep.id)
+				if b.onSkip != nil {
+					b.onSkip(e, err)
+				}
+				finish(e, err)
+			}
+			mu.Unlock()
+			if depFailed {
+				return true
+			}
+		}
+
+		mu.Lock()
+		running[ref] = make(chan struct{})
+		mu.Unlock()
+		select {
+		case work <- e:
+			return true
+		case <-ctx.Done():
+			mu.Lock()
+			close(running[ref])
+			delete(running, ref)
+			mu.Unlock()
+			return false
+		}
+	}
+	for ctx.Err() == nil {
+		e, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			readErr = err
+			break
+		}
+		read++
+		if !feed(e) {
+			break
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	err := joinBulkErrors(errs, read)
+	if ctx.Err() != nil {
+		err = errors.Join(fmt.Errorf("stopped reading entities after %d, of which %d weren't attempted: %w", read, read-processed, ctx.Err()), err)
+	}
+	return errors.Join(readErr, err)
+}
+
+// joinBulkErrors returns an error that wraps `errs`, the errors of the
+// entities that failed out of `total`, or nil if there are none.
+func joinBulkErrors(errs []error, total int) error {
+	switch {
+	case total == 1 && len(errs) == 1:
+		// There's nothing to aggregate.
+		return errs[0]
+	case len(errs) > 0:
+		// Workers finish in an arbitrary order, so sort the errors to keep the
+		// output stable between runs.
+		slices.SortFunc(errs, func(a, b error) int { return strings.Compare(a.Error(), b.Error()) })
+		return fmt.Errorf("%d of %d entities failed:\n%w", len(errs), total, errors.Join(errs...))
+	default:
+		return nil
+	}
+}
+
+// newRateLimiter returns a function that blocks until the next operation is
+// allowed to start, spacing them evenly so no more than `qps` start per
+// second. A non-positive `qps` disables the limit.
+func newRateLimiter(qps float64) (wait func(context.Context) error, stop func()) {
+	if qps <= 0 {
+		return func(ctx context.Context) error { return ctx.Err() }, func() {}
+	}
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / qps))
+	return func(ctx context.Context) error {
+		select {
+		case <-ticker.C:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}, ticker.Stop
+}
+
+// progressBar renders the progress of a bulkRunner on a single terminal
+// line. It's also an io.Writer: anything written to it is printed above the
+// bar.
+type progressBar struct {
+	out io.Writer
+
+	mu                  sync.Mutex
+	total, done, failed int
+	running             bool
+	lastDraw            time.Time
+}
+
+// start resets the bar for `total` operations, or an unknown number of them
+// if `total` is negative.
+func (p *progressBar) start(total int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.total, p.done, p.failed, p.running = total, 0, 0, true
+	p.draw()
+}
+
+func (p *progressBar) add(failed bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done++
+	if failed {
+		p.failed++
+	}
+	if p.done == p.total || time.Since(p.lastDraw) >= progressRedrawInterval {
+		p.draw()
+	}
+}
+
+func (p *progressBar) finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.draw()
+	fmt.Fprintln(p.out)
+	p.running = false
+}
+
+func (p *progressBar) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.running {
+		return p.out.Write(b)
+	}
+	fmt.Fprint(p.out, "\r\x1b[K")
+	n, err := p.out.Write(b)
+	p.draw()
+	return n, err
+}
+
+// draw must be called with `p.mu` held.
+func (p *progressBar) draw() {
+	fmt.Fprintf(p.out, "\r\x1b[K%s", p.render())
+	p.lastDraw = time.Now()
+}
+
+func (p *progressBar) render() string {
+	if p.total < 0 {
+		// The total isn't known, as when entities are processed as they're
+		// read.
+		line := fmt.Sprintf("%d processed", p.done)
+		if p.failed > 0 {
+			line += fmt.Sprintf(" (%d failed)", p.failed)
+		}
+		return line
+	}
+	filled := progressBarWidth
+	if p.total > 0 {
+		filled = progressBarWidth * p.done / p.total
+	}
+	line := fmt.Sprintf("[%s%s] %d/%d", strings.Repeat("=", filled), strings.Repeat(" ", progressBarWidth-filled), p.done, p.total)
+	if p.failed > 0 {
+		line += fmt.Sprintf(" (%d failed)", p.failed)
+	}
+	return line
+}
+
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
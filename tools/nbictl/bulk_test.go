@@ -0,0 +1,321 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+)
+
+func testBulkEntities(n int) []*nbipb.Entity {
+	entities := []*nbipb.Entity{}
+	for i := range n {
+		entities = append(entities, &nbipb.Entity{Id: proto.String(fmt.Sprintf("entity-%02d", i))})
+	}
+	return entities
+}
+
+func TestBulkRunner_limitsConcurrency(t *testing.T) {
+	t.Parallel()
+
+	b := &bulkRunner{concurrency: 4}
+	active, maxActive, calls := &atomic.Int32{}, &atomic.Int32{}, &atomic.Int32{}
+	checkErr(t, b.run(context.Background(), testBulkEntities(40), func(context.Context, *nbipb.Entity) error {
+		n := active.Add(1)
+		defer active.Add(-1)
+		for {
+			m := maxActive.Load()
+			if n <= m || maxActive.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		calls.Add(1)
+		time.Sleep(time.Millisecond)
+		return nil
+	}))
+
+	if got := calls.Load(); got != 40 {
+		t.Errorf("expected 40 calls, got %d", got)
+	}
+	if got := maxActive.Load(); got > 4 {
+		t.Errorf("expected at most 4 concurrent calls, got %d", got)
+	}
+}
+
+func TestBulkRunner_aggregatesErrors(t *testing.T) {
+	t.Parallel()
+
+	b := &bulkRunner{concurrency: 2}
+	calls := &atomic.Int32{}
+	err := b.run(context.Background(), testBulkEntities(5), func(_ context.Context, e *nbipb.Entity) error {
+		calls.Add(1)
+		if e.GetId() == "entity-01" || e.GetId() == "entity-03" {
+			return fmt.Errorf("%s: boom", e.GetId())
+		}
+		return nil
+	})
+
+	if got := calls.Load(); got != 5 {
+		t.Errorf("expected every entity to be attempted despite the failures, got %d calls", got)
+	}
+	want := "2 of 5 entities failed:\nentity-01: boom\nentity-03: boom"
+	if err == nil || err.Error() != want {
+		t.Fatalf("expected error %q, got %v", want, err)
+	}
+}
+
+func TestBulkRunner_limitsQPS(t *testing.T) {
+	t.Parallel()
+
+	b := &bulkRunner{concurrency: 10, qps: 100}
+	start := time.Now()
+	checkErr(t, b.run(context.Background(), testBulkEntities(6), func(context.Context, *nbipb.Entity) error { return nil }))
+	// 6 requests at 100 QPS are spaced at least 10ms apart.
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("expected 6 requests at 100 QPS to take at least 50ms, took %s", elapsed)
+	}
+}
+
+func TestBulkRunner_stopsWhenCanceled(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	b := &bulkRunner{concurrency: 1}
+	err := b.run(ctx, testBulkEntities(10), func(_ context.Context, e *nbipb.Entity) error {
+		if e.GetId() == "entity-02" {
+			cancel()
+		}
+		return nil
+	})
+
+	switch want := "entities weren't attempted: context canceled"; {
+	case err == nil:
+		t.Fatal("expected canceling the context to cause an error, got nil")
+	case !strings.Contains(err.Error(), want) || !errors.Is(err, context.Canceled):
+		t.Fatalf("expected error to contain %q, but got %q", want, err.Error())
+	}
+}
+
+func TestBulkRunner_ordersDependencies(t *testing.T) {
+	t.Parallel()
+
+	// entity-00 depends on entity-01, which depends on entity-02. entity-03
+	// is independent.
+	entities := testBulkEntities(4)
+	deps := [][]int{{1}, {2}, nil, nil}
+
+	b := &bulkRunner{concurrency: 4}
+	mu := &sync.Mutex{}
+	order := []string{}
+	checkErr(t, b.runWithDependencies(context.Background(), entities, deps, func(_ context.Context, e *nbipb.Entity) error {
+		mu.Lock()
+		defer mu.Unlock()
+		order = append(order, e.GetId())
+		return nil
+	}))
+
+	pos := map[string]int{}
+	for i, id := range order {
+		pos[id] = i
+	}
+	if len(order) != 4 || pos["entity-02"] > pos["entity-01"] || pos["entity-01"] > pos["entity-00"] {
+		t.Errorf("entities weren't processed in dependency order: %v", order)
+	}
+}
+
+func TestBulkRunner_skipsDependentsOfFailures(t *testing.T) {
+	t.Parallel()
+
+	entities := testBulkEntities(3)
+	deps := [][]int{{1}, {2}, nil}
+
+	b := &bulkRunner{concurrency: 2}
+	calls := &atomic.Int32{}
+	err := b.runWithDependencies(context.Background(), entities, deps, func(_ context.Context, e *nbipb.Entity) error {
+		calls.Add(1)
+		if e.GetId() == "entity-02" {
+			return fmt.Errorf("%s: boom", e.GetId())
+		}
+		return nil
+	})
+
+	if got := calls.Load(); got != 1 {
+		t.Errorf("expected only entity-02 to be attempted, got %d calls", got)
+	}
+	want := "3 of 3 entities failed:\n" +
+		"entity-02: boom\n" +
+		"skipped entity ENTITY_TYPE_UNSPECIFIED/entity-00 because entity ENTITY_TYPE_UNSPECIFIED/entity-01, which it depends on, failed\n" +
+		"skipped entity ENTITY_TYPE_UNSPECIFIED/entity-01 because entity ENTITY_TYPE_UNSPECIFIED/entity-02, which it depends on, failed"
+	if err == nil || err.Error() != want {
+		t.Fatalf("expected error %q, got %v", want, err)
+	}
+}
+
+func TestBulkRunner_breaksCycles(t *testing.T) {
+	t.Parallel()
+
+	b := &bulkRunner{concurrency: 2}
+	calls := &atomic.Int32{}
+	checkErr(t, b.runWithDependencies(context.Background(), testBulkEntities(3), [][]int{{1}, {0}, {0}}, func(context.Context, *nbipb.Entity) error {
+		calls.Add(1)
+		return nil
+	}))
+	if got := calls.Load(); got != 3 {
+		t.Errorf("expected every entity to be attempted, got %d calls", got)
+	}
+}
+
+// streamOf returns a function that yields `entities` one at a time, like an
+// entityDecoder, and counts how many of them have been read.
+func streamOf(entities []*nbipb.Entity, read *atomic.Int32) func() (*nbipb.Entity, error) {
+	return func() (*nbipb.Entity, error) {
+		if len(entities) == 0 {
+			return nil, io.EOF
+		}
+		e := entities[0]
+		entities = entities[1:]
+		read.Add(1)
+		return e, nil
+	}
+}
+
+func TestBulkRunner_runStreamBoundsHeldEntities(t *testing.T) {
+	t.Parallel()
+
+	b := &bulkRunner{concurrency: 4}
+	read, done, maxHeld := &atomic.Int32{}, &atomic.Int32{}, &atomic.Int32{}
+	checkErr(t, b.runStream(context.Background(), streamOf(testBulkEntities(200), read), func(context.Context, *nbipb.Entity) error {
+		// The entities read but not done yet are those being processed
+		// and the one waiting for a worker.
+		held := read.Load() - done.Load()
+		for {
+			m := maxHeld.Load()
+			if held <= m || maxHeld.CompareAndSwap(m, held) {
+				break
+			}
+		}
+		time.Sleep(100 * time.Microsecond)
+		done.Add(1)
+		return nil
+	}))
+
+	if got := done.Load(); got != 200 {
+		t.Errorf("expected 200 calls, got %d", got)
+	}
+	if got := maxHeld.Load(); got > 5 {
+		t.Errorf("expected at most 5 entities to be held at once with 4 workers, got %d", got)
+	}
+}
+
+func TestBulkRunner_runStreamOrdersSameEntity(t *testing.T) {
+	t.Parallel()
+
+	// Three versions of entity-00, interleaved with other entities.
+	entities := []*nbipb.Entity{}
+	for i, e := range testBulkEntities(6) {
+		if i%2 == 0 {
+			e = &nbipb.Entity{Id: proto.String("entity-00")}
+		}
+		e.CommitTimestamp = proto.Int64(int64(i))
+		entities = append(entities, e)
+	}
+
+	b := &bulkRunner{concurrency: 4}
+	mu := &sync.Mutex{}
+	versions := []int64{}
+	checkErr(t, b.runStream(context.Background(), streamOf(entities, &atomic.Int32{}), func(_ context.Context, e *nbipb.Entity) error {
+		if e.GetId() != "entity-00" {
+			return nil
+		}
+		// Earlier versions take longer, so they'd finish last if they
+		// weren't ordered.
+		time.Sleep(time.Duration(5-e.GetCommitTimestamp()) * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		versions = append(versions, e.GetCommitTimestamp())
+		return nil
+	}))
+
+	if want := []int64{0, 2, 4}; !slices.Equal(versions, want) {
+		t.Errorf("versions of entity-00 were processed in the order %v, want %v", versions, want)
+	}
+}
+
+func TestBulkRunner_runStreamStopsAtReadErrors(t *testing.T) {
+	t.Parallel()
+
+	b := &bulkRunner{concurrency: 2}
+	calls := &atomic.Int32{}
+	read := 0
+	err := b.runStream(context.Background(), func() (*nbipb.Entity, error) {
+		if read++; read > 3 {
+			return nil, errors.New("malformed entity")
+		}
+		return &nbipb.Entity{Id: proto.String(fmt.Sprintf("entity-%02d", read))}, nil
+	}, func(context.Context, *nbipb.Entity) error {
+		calls.Add(1)
+		return nil
+	})
+
+	if got := calls.Load(); got != 3 {
+		t.Errorf("expected the 3 entities read before the error to be processed, got %d calls", got)
+	}
+	if err == nil || err.Error() != "malformed entity" {
+		t.Errorf("expected the read error, got %v", err)
+	}
+}
+
+func TestProgressBar(t *testing.T) {
+	t.Parallel()
+
+	out := &bytes.Buffer{}
+	p := &progressBar{out: out}
+	p.start(4)
+	p.add(false)
+	fmt.Fprintln(p, "successfully created: entity-00")
+	p.add(true)
+	p.add(false)
+	p.add(false)
+	p.finish()
+
+	lines := strings.Split(out.String(), "\r\x1b[K")
+	if got, want := lines[len(lines)-1], "[==============================] 4/4 (1 failed)\n"; got != want {
+		t.Errorf("unexpected final progress: want %q, got %q", want, got)
+	}
+	if !strings.Contains(out.String(), "\r\x1b[Ksuccessfully created: entity-00\n\r\x1b[K[=======                       ] 1/4") {
+		t.Errorf("expected the message to be printed above the progress bar, got %q", out.String())
+	}
+
+	// Once finished, writes pass through unchanged.
+	out.Reset()
+	fmt.Fprint(p, "done\n")
+	if got := out.String(); got != "done\n" {
+		t.Errorf("expected writes after finish to pass through, got %q", got)
+	}
+}
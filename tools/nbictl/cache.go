@@ -0,0 +1,343 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+)
+
+const (
+	entityCacheDirName = "cache"
+	cachedEntityExt    = ".binpb"
+	// cacheMaxIndividualFetches is the number of changed entities above which
+	// a cached listing is refreshed with a single ListEntities call, rather
+	// than one GetEntity call per changed entity.
+	cacheMaxIndividualFetches = 8
+	defaultCacheMaxAge        = 7 * 24 * time.Hour
+)
+
+// entityCache stores the entities read from one context on disk, keyed by
+// their type, ID, and commit timestamp. Only the latest version read of each
+// entity is kept.
+type entityCache struct {
+	dir string
+}
+
+// entityCacheForContext returns the cache of the selected context, or nil if
+// caching is disabled.
+func entityCacheForContext(appCtx *cli.Context) (*entityCache, error) {
+	if appCtx.Bool("no_cache") || checkLocalStateWritable(appCtx) != nil {
+		return nil, nil
+	}
+	confDir, err := getAppConfDir(appCtx)
+	if err != nil {
+		return nil, err
+	}
+	setting, err := readConfig(appCtx.String("context"), filepath.Join(confDir, confFileName))
+	if err != nil {
+		return nil, fmt.Errorf("unable to obtain context information: %w", err)
+	}
+	if setting.GetName() == "" {
+		return nil, nil
+	}
+	return &entityCache{dir: filepath.Join(confDir, entityCacheDirName, url.PathEscape(setting.GetName()))}, nil
+}
+
+func (c *entityCache) typeDir(t nbipb.EntityType) string {
+	return filepath.Join(c.dir, t.String())
+}
+
+func (c *entityCache) entityDir(t nbipb.EntityType, id string) string {
+	return filepath.Join(c.typeDir(t), url.PathEscape(id))
+}
+
+func (c *entityCache) path(t nbipb.EntityType, id string, commitTimestamp int64) string {
+	return filepath.Join(c.entityDir(t, id), strconv.FormatInt(commitTimestamp, 10)+cachedEntityExt)
+}
+
+// hasType reports whether any entity of type `t` is cached.
+func (c *entityCache) hasType(t nbipb.EntityType) bool {
+	entries, err := os.ReadDir(c.typeDir(t))
+	return err == nil && len(entries) > 0
+}
+
+// load returns the cached version of an entity, if it's the one with
+// `commitTimestamp`.
+func (c *entityCache) load(t nbipb.EntityType, id string, commitTimestamp int64) (*nbipb.Entity, bool) {
+	path := c.path(t, id, commitTimestamp)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	e := &nbipb.Entity{}
+	if err := proto.Unmarshal(data, e); err != nil {
+		return nil, false
+	}
+	// The modification time records when the entry was last used, for `cache
+	// gc`.
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return e, true
+}
+
+// store caches `e`, replacing any other version of it. Caching is best
+// effort, so failures are ignored: the entity will just be fetched again.
+func (c *entityCache) store(e *nbipb.Entity) {
+	if e.CommitTimestamp == nil {
+		return
+	}
+	t := e.GetGroup().GetType()
+	data, err := proto.Marshal(e)
+	if err != nil {
+		return
+	}
+	dir := c.entityDir(t, e.GetId())
+	if err := os.MkdirAll(dir, 0o777); err != nil {
+		return
+	}
+	path := c.path(t, e.GetId(), e.GetCommitTimestamp())
+	if err := writeFileAtomic(path, data, 0o600); err != nil {
+		return
+	}
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if p := filepath.Join(dir, entry.Name()); p != path {
+			os.Remove(p)
+		}
+	}
+}
+
+// cachingNetOpsClient serves GetEntity and unfiltered ListEntities calls from
+// an entityCache when possible. It first lists the ID and commit timestamp of
+// every entity of the requested type, which is much smaller than the
+// entities themselves, and then only fetches the entities that changed.
+type cachingNetOpsClient struct {
+	nbipb.NetOpsClient
+	cache *entityCache
+
+	mu sync.Mutex
+	// versions memoizes the versions listed for each type.
+	versions map[nbipb.EntityType][]*nbipb.Entity
+}
+
+func newCachingNetOpsClient(client nbipb.NetOpsClient, cache *entityCache) nbipb.NetOpsClient {
+	if cache == nil {
+		return client
+	}
+	return &cachingNetOpsClient{NetOpsClient: client, cache: cache, versions: map[nbipb.EntityType][]*nbipb.Entity{}}
+}
+
+// listVersions returns the type, ID, and commit timestamp of every entity of
+// type `t`.
+func (c *cachingNetOpsClient) listVersions(ctx context.Context, t nbipb.EntityType, opts ...grpc.CallOption) ([]*nbipb.Entity, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if v, ok := c.versions[t]; ok {
+		return v, nil
+	}
+	res, err := c.NetOpsClient.ListEntities(ctx, &nbipb.ListEntitiesRequest{
+		Type:   t.Enum(),
+		Filter: &nbipb.EntityFilter{FieldMasks: []string{"id"}},
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	c.versions[t] = res.GetEntities()
+	return res.GetEntities(), nil
+}
+
+func (c *cachingNetOpsClient) GetEntity(ctx context.Context, req *nbipb.GetEntityRequest, opts ...grpc.CallOption) (*nbipb.Entity, error) {
+	if c.cache.hasType(req.GetType()) {
+		versions, err := c.listVersions(ctx, req.GetType(), opts...)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range versions {
+			if v.GetId() != req.GetId() {
+				continue
+			}
+			if e, ok := c.cache.load(req.GetType(), v.GetId(), v.GetCommitTimestamp()); ok {
+				moduleLogger(ctx, logModuleCache).Debug("cache hit", "type", req.GetType().String(), "id", req.GetId())
+				return e, nil
+			}
+			break
+		}
+	}
+
+	e, err := c.NetOpsClient.GetEntity(ctx, req, opts...)
+	if err != nil {
+		return nil, err
+	}
+	c.cache.store(e)
+	return e, nil
+}
+
+func (c *cachingNetOpsClient) ListEntities(ctx context.Context, req *nbipb.ListEntitiesRequest, opts ...grpc.CallOption) (*nbipb.ListEntitiesResponse, error) {
+	unfiltered := req.Interval == nil && proto.Size(req.GetFilter()) == 0 && !req.GetComputeCartesianCoordinates()
+	if !unfiltered || !c.cache.hasType(req.GetType()) {
+		return c.listAndStore(ctx, req, unfiltered, opts...)
+	}
+
+	versions, err := c.listVersions(ctx, req.GetType(), opts...)
+	if err != nil {
+		return nil, err
+	}
+	entities := make([]*nbipb.Entity, len(versions))
+	changed := []int{}
+	for i, v := range versions {
+		if e, ok := c.cache.load(req.GetType(), v.GetId(), v.GetCommitTimestamp()); ok {
+			entities[i] = e
+		} else {
+			changed = append(changed, i)
+		}
+	}
+	log := moduleLogger(ctx, logModuleCache)
+	if len(changed) > cacheMaxIndividualFetches {
+		log.Debug("too many changed entities to fetch individually, listing all of them", "type", req.GetType().String(), "changed", len(changed))
+		return c.listAndStore(ctx, req, unfiltered, opts...)
+	}
+	log.Debug("listing from cache", "type", req.GetType().String(), "cached", len(versions)-len(changed), "changed", len(changed))
+
+	for _, i := range changed {
+		e, err := c.NetOpsClient.GetEntity(ctx, &nbipb.GetEntityRequest{Type: req.Type, Id: versions[i].Id}, opts...)
+		switch {
+		case status.Code(err) == codes.NotFound:
+			// Deleted since it was listed.
+			continue
+		case err != nil:
+			return nil, err
+		}
+		c.cache.store(e)
+		entities[i] = e
+	}
+
+	res := &nbipb.ListEntitiesResponse{}
+	for _, e := range entities {
+		if e != nil {
+			res.Entities = append(res.Entities, e)
+		}
+	}
+	return res, nil
+}
+
+// listAndStore forwards `req` to the server, caching the entities it returns
+// if they're complete.
+func (c *cachingNetOpsClient) listAndStore(ctx context.Context, req *nbipb.ListEntitiesRequest, complete bool, opts ...grpc.CallOption) (*nbipb.ListEntitiesResponse, error) {
+	res, err := c.NetOpsClient.ListEntities(ctx, req, opts...)
+	if err != nil || !complete {
+		return res, err
+	}
+	for _, e := range res.GetEntities() {
+		c.cache.store(e)
+	}
+	return res, nil
+}
+
+func CacheGC(appCtx *cli.Context) error {
+	if err := checkLocalStateWritable(appCtx); err != nil {
+		return err
+	}
+	confDir, err := getAppConfDir(appCtx)
+	if err != nil {
+		return err
+	}
+	conf, err := readConfigs(filepath.Join(confDir, confFileName))
+	if err != nil {
+		return err
+	}
+	contexts := map[string]bool{}
+	for _, c := range conf.GetConfigs() {
+		contexts[url.PathEscape(c.GetName())] = true
+	}
+	maxAge := defaultCacheMaxAge
+	if appCtx.IsSet("max_age") {
+		maxAge = appCtx.Duration("max_age")
+	}
+
+	removed, freed, err := gcEntityCache(filepath.Join(confDir, entityCacheDirName), contexts, time.Now().Add(-maxAge), appCtx.Bool("all"))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(appCtx.App.ErrWriter, "removed %d cached entities, freeing %d bytes\n", removed, freed)
+	return nil
+}
+
+// gcEntityCache removes the cached entities that weren't used since
+// `cutoff`, those of contexts that aren't in `contexts`, or all of them if
+// `all` is set, along with the directories left empty.
+func gcEntityCache(dir string, contexts map[string]bool, cutoff time.Time, all bool) (removed int, freed int64, _ error) {
+	emptyDirs := []string{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			return nil
+		case err != nil:
+			return err
+		case d.IsDir():
+			if path != dir {
+				emptyDirs = append(emptyDirs, path)
+			}
+			return nil
+		case !strings.HasSuffix(path, cachedEntityExt):
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		context, _, _ := strings.Cut(rel, string(filepath.Separator))
+		if !all && contexts[context] && info.ModTime().After(cutoff) {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		removed++
+		freed += info.Size()
+		return nil
+	})
+	if err != nil {
+		return removed, freed, fmt.Errorf("unable to clean up the cache: %w", err)
+	}
+	// Remove the deepest directories first; os.Remove fails on the ones that
+	// still hold entities.
+	for i := len(emptyDirs) - 1; i >= 0; i-- {
+		os.Remove(emptyDirs[i])
+	}
+	return removed, freed, nil
+}
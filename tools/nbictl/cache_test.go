@@ -0,0 +1,239 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/rules_go/go/tools/bazel"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	commonpb "aalyria.com/spacetime/api/common"
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+)
+
+// storedNetOpsClient serves the entities in `stored`, in order, and counts
+// the requests it receives. Listings with the "id" field mask only return
+// the entities' type, ID, and commit timestamp.
+type storedNetOpsClient struct {
+	nbipb.NetOpsClient
+
+	mu                            sync.Mutex
+	stored                        []*nbipb.Entity
+	fullLists, versionLists, gets int
+}
+
+func (c *storedNetOpsClient) ListEntities(_ context.Context, req *nbipb.ListEntitiesRequest, _ ...grpc.CallOption) (*nbipb.ListEntitiesResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	versionsOnly := cmp.Equal(req.GetFilter().GetFieldMasks(), []string{"id"})
+	if versionsOnly {
+		c.versionLists++
+	} else {
+		c.fullLists++
+	}
+	res := &nbipb.ListEntitiesResponse{}
+	for _, e := range c.stored {
+		if versionsOnly {
+			e = &nbipb.Entity{Group: e.Group, Id: e.Id, CommitTimestamp: e.CommitTimestamp}
+		}
+		res.Entities = append(res.Entities, proto.Clone(e).(*nbipb.Entity))
+	}
+	return res, nil
+}
+
+func (c *storedNetOpsClient) GetEntity(_ context.Context, req *nbipb.GetEntityRequest, _ ...grpc.CallOption) (*nbipb.Entity, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gets++
+	for _, e := range c.stored {
+		if e.GetId() == req.GetId() {
+			return proto.Clone(e).(*nbipb.Entity), nil
+		}
+	}
+	return nil, status.Error(codes.NotFound, "not found")
+}
+
+// modify bumps the commit timestamp and changes the name of each entity in
+// `ids`.
+func (c *storedNetOpsClient) modify(ids ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range c.stored {
+		for _, id := range ids {
+			if e.GetId() == id {
+				e.CommitTimestamp = proto.Int64(e.GetCommitTimestamp() + 1)
+				e.GetPlatform().Name = proto.String(e.GetPlatform().GetName() + "'")
+			}
+		}
+	}
+}
+
+func (c *storedNetOpsClient) resetCounts() (fullLists, versionLists, gets int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fullLists, versionLists, gets = c.fullLists, c.versionLists, c.gets
+	c.fullLists, c.versionLists, c.gets = 0, 0, 0
+	return fullLists, versionLists, gets
+}
+
+func newStoredNetOpsClient(ids ...string) *storedNetOpsClient {
+	c := &storedNetOpsClient{}
+	for _, id := range ids {
+		c.stored = append(c.stored, &nbipb.Entity{
+			Group:           &nbipb.EntityGroup{Type: nbipb.EntityType_PLATFORM_DEFINITION.Enum()},
+			Id:              proto.String(id),
+			CommitTimestamp: proto.Int64(1),
+			Value:           &nbipb.Entity_Platform{Platform: &commonpb.PlatformDefinition{Name: proto.String(id)}},
+		})
+	}
+	return c
+}
+
+func newTestEntityCache(t *testing.T) *entityCache {
+	dir, err := bazel.NewTmpDir("nbictl")
+	checkErr(t, err)
+	return &entityCache{dir: filepath.Join(dir, entityCacheDirName, "DEFAULT")}
+}
+
+func TestCachingNetOpsClient_ListEntities(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	server := newStoredNetOpsClient("a", "b", "c", "d", "e", "f", "g", "h", "i", "j")
+	cache := newTestEntityCache(t)
+	req := &nbipb.ListEntitiesRequest{Type: nbipb.EntityType_PLATFORM_DEFINITION.Enum(), Filter: &nbipb.EntityFilter{}}
+
+	for _, tc := range []struct {
+		name          string
+		modify        []string
+		wantFullLists int
+		wantVersions  int
+		wantGets      int
+	}{
+		{name: "cold cache", wantFullLists: 1},
+		{name: "unchanged", wantVersions: 1},
+		{name: "some changed", modify: []string{"b", "e"}, wantVersions: 1, wantGets: 2},
+		{name: "most changed", modify: []string{"a", "b", "c", "d", "e", "f", "g", "h", "i"}, wantVersions: 1, wantFullLists: 1},
+		{name: "unchanged after refresh", wantVersions: 1},
+	} {
+		server.modify(tc.modify...)
+		// Each invocation of nbictl uses a new client.
+		res, err := newCachingNetOpsClient(server, cache).ListEntities(ctx, req)
+		checkErr(t, err)
+
+		if diff := cmp.Diff(server.stored, res.GetEntities(), protocmp.Transform()); diff != "" {
+			t.Errorf("%s: unexpected entities (-want +got):\n%s", tc.name, diff)
+		}
+		fullLists, versionLists, gets := server.resetCounts()
+		if fullLists != tc.wantFullLists || versionLists != tc.wantVersions || gets != tc.wantGets {
+			t.Errorf("%s: want %d full listings, %d version listings, and %d gets, got %d, %d, and %d",
+				tc.name, tc.wantFullLists, tc.wantVersions, tc.wantGets, fullLists, versionLists, gets)
+		}
+	}
+
+	// Filtered listings are always sent to the server.
+	filtered := &nbipb.ListEntitiesRequest{Type: req.Type, Filter: &nbipb.EntityFilter{FieldMasks: []string{"platform.name"}}}
+	_, err := newCachingNetOpsClient(server, cache).ListEntities(ctx, filtered)
+	checkErr(t, err)
+	if fullLists, versionLists, _ := server.resetCounts(); fullLists != 1 || versionLists != 0 {
+		t.Errorf("expected filtered listings to bypass the cache, got %d full and %d version listings", fullLists, versionLists)
+	}
+}
+
+func TestCachingNetOpsClient_GetEntity(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	server := newStoredNetOpsClient("a", "b")
+	cache := newTestEntityCache(t)
+	req := &nbipb.GetEntityRequest{Type: nbipb.EntityType_PLATFORM_DEFINITION.Enum(), Id: proto.String("a")}
+
+	for _, tc := range []struct {
+		name         string
+		modify       []string
+		wantVersions int
+		wantGets     int
+	}{
+		{name: "cold cache", wantGets: 1},
+		{name: "unchanged", wantVersions: 1},
+		{name: "other entity changed", modify: []string{"b"}, wantVersions: 1},
+		{name: "changed", modify: []string{"a"}, wantVersions: 1, wantGets: 1},
+	} {
+		server.modify(tc.modify...)
+		got, err := newCachingNetOpsClient(server, cache).GetEntity(ctx, req)
+		checkErr(t, err)
+
+		if diff := cmp.Diff(server.stored[0], got, protocmp.Transform()); diff != "" {
+			t.Errorf("%s: unexpected entity (-want +got):\n%s", tc.name, diff)
+		}
+		_, versionLists, gets := server.resetCounts()
+		if versionLists != tc.wantVersions || gets != tc.wantGets {
+			t.Errorf("%s: want %d version listings and %d gets, got %d and %d", tc.name, tc.wantVersions, tc.wantGets, versionLists, gets)
+		}
+	}
+
+	entries, err := os.ReadDir(cache.entityDir(nbipb.EntityType_PLATFORM_DEFINITION, "a"))
+	checkErr(t, err)
+	if len(entries) != 1 {
+		t.Errorf("expected only the latest version to be cached, got %d entries", len(entries))
+	}
+}
+
+func TestGCEntityCache(t *testing.T) {
+	t.Parallel()
+
+	dir, err := bazel.NewTmpDir("nbictl")
+	checkErr(t, err)
+	cacheDir := filepath.Join(dir, entityCacheDirName)
+	server := newStoredNetOpsClient("recent", "stale")
+	for _, context := range []string{"DEFAULT", "deleted"} {
+		cache := &entityCache{dir: filepath.Join(cacheDir, context)}
+		for _, e := range server.stored {
+			cache.store(e)
+		}
+	}
+	staleFile := (&entityCache{dir: filepath.Join(cacheDir, "DEFAULT")}).path(nbipb.EntityType_PLATFORM_DEFINITION, "stale", 1)
+	old := time.Now().Add(-48 * time.Hour)
+	checkErr(t, os.Chtimes(staleFile, old, old))
+
+	removed, freed, err := gcEntityCache(cacheDir, map[string]bool{"DEFAULT": true}, time.Now().Add(-24*time.Hour), false)
+	checkErr(t, err)
+	if removed != 3 || freed == 0 {
+		t.Errorf("expected the stale entity and the deleted context's entities to be removed, got %d removed (%d bytes)", removed, freed)
+	}
+	if _, err := os.Stat(filepath.Join(cacheDir, "deleted")); !os.IsNotExist(err) {
+		t.Errorf("expected the deleted context's directory to be removed, got %v", err)
+	}
+	if _, err := os.Stat(staleFile); !os.IsNotExist(err) {
+		t.Errorf("expected the stale entity to be removed, got %v", err)
+	}
+
+	removed, _, err = gcEntityCache(cacheDir, map[string]bool{"DEFAULT": true}, time.Now().Add(-24*time.Hour), true)
+	checkErr(t, err)
+	if removed != 1 {
+		t.Errorf("expected --all to remove the remaining entity, got %d removed", removed)
+	}
+}
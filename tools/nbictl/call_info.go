@@ -0,0 +1,91 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"google.golang.org/grpc"
+
+	"aalyria.com/spacetime/callctx"
+)
+
+var (
+	operatorFlag = &cli.StringFlag{
+		Name:    "operator",
+		Usage:   "Name of the person running the command, sent with every request so that the server's audit log and the agent's event log can attribute changes.",
+		EnvVars: []string{"NBICTL_OPERATOR"},
+	}
+	ticketIDFlag = &cli.StringFlag{
+		Name:    "ticket_id",
+		Usage:   "ID of the ticket or change request the command is run for, sent with every request.",
+		EnvVars: []string{"NBICTL_TICKET_ID"},
+	}
+	jobIDFlag = &cli.StringFlag{
+		Name:    "job_id",
+		Usage:   "ID of the automation job (e.g. a CI pipeline run) running the command, sent with every request.",
+		EnvVars: []string{"NBICTL_JOB_ID"},
+	}
+	callLabelFlag = &cli.StringSliceFlag{
+		Name:  "call_label",
+		Usage: "Additional context to send with every request, as `KEY=VALUE`. May be repeated.",
+	}
+)
+
+// callInfoFromFlags returns the call context set by the global flags.
+func callInfoFromFlags(appCtx *cli.Context) (callctx.Info, error) {
+	info := callctx.Info{
+		Operator: appCtx.String(operatorFlag.Name),
+		TicketID: appCtx.String(ticketIDFlag.Name),
+		JobID:    appCtx.String(jobIDFlag.Name),
+	}
+	for _, label := range appCtx.StringSlice(callLabelFlag.Name) {
+		k, v, ok := strings.Cut(label, "=")
+		if !ok {
+			return callctx.Info{}, fmt.Errorf("invalid --%s %q (must be KEY=VALUE)", callLabelFlag.Name, label)
+		}
+		if info.Labels == nil {
+			info.Labels = map[string]string{}
+		}
+		info.Labels[k] = v
+	}
+	if err := info.Validate(); err != nil {
+		return callctx.Info{}, fmt.Errorf("invalid --%s: %w", callLabelFlag.Name, err)
+	}
+	return info, nil
+}
+
+// applyCallInfo attaches the call context set by the global flags to the
+// context of the command, so it's sent with the requests the command makes.
+func applyCallInfo(appCtx *cli.Context) error {
+	info, err := callInfoFromFlags(appCtx)
+	if err != nil || info.IsZero() {
+		return err
+	}
+	appCtx.Context = callctx.With(appCtx.Context, info)
+	return nil
+}
+
+// callInfoDialOpts returns the dial options that send the call context of
+// each request. They must come before the rpclog interceptors, so that the
+// gRPC log records the context too.
+func callInfoDialOpts() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(callctx.UnaryClientInterceptor()),
+		grpc.WithChainStreamInterceptor(callctx.StreamClientInterceptor()),
+	}
+}
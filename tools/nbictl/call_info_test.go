@@ -0,0 +1,77 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/urfave/cli/v2"
+
+	"aalyria.com/spacetime/callctx"
+)
+
+// runWithCallInfo runs a command that records the call context of its
+// context with the provided global flags, and returns it.
+func runWithCallInfo(t *testing.T, globalArgs ...string) (callctx.Info, error) {
+	t.Helper()
+
+	var info callctx.Info
+	app := newTestApp()
+	app.Commands = append(app.Commands, &cli.Command{
+		Name:   "record-call-info",
+		Hidden: true,
+		Action: func(appCtx *cli.Context) error {
+			info = callctx.FromContext(appCtx.Context)
+			return nil
+		},
+	})
+	err := app.Run(append(append([]string{"nbictl"}, globalArgs...), "record-call-info"))
+	return info, err
+}
+
+func TestCallInfo(t *testing.T) {
+	t.Parallel()
+
+	info, err := runWithCallInfo(t)
+	checkErr(t, err)
+	if !info.IsZero() {
+		t.Errorf("command without call context flags got %+v, want nothing", info)
+	}
+
+	info, err = runWithCallInfo(t,
+		"--operator", "alice",
+		"--ticket_id", "OPS-1234",
+		"--job_id", "nightly-42",
+		"--call_label", "pipeline=deploy",
+		"--call_label", "stage=canary",
+	)
+	checkErr(t, err)
+	want := callctx.Info{
+		Operator: "alice",
+		TicketID: "OPS-1234",
+		JobID:    "nightly-42",
+		Labels:   map[string]string{"pipeline": "deploy", "stage": "canary"},
+	}
+	if diff := cmp.Diff(want, info); diff != "" {
+		t.Errorf("call context mismatch (-want +got):\n%s", diff)
+	}
+
+	for _, label := range []string{"no-value", "bad key=value"} {
+		if _, err := runWithCallInfo(t, "--call_label", label); err == nil {
+			t.Errorf("command with --call_label %q succeeded, want an error", label)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/jhump/protoreflect/grpcreflect"
+	"github.com/urfave/cli/v2"
+	"google.golang.org/protobuf/encoding/prototext"
+
+	"aalyria.com/spacetime/rpclog"
+)
+
+const (
+	defaultCollectTimeout = 10 * time.Second
+	// collectLogLimit is how much of the end of the --grpc_log file is
+	// included in a diagnostics bundle.
+	collectLogLimit = 4 << 20
+)
+
+// Collect writes a gzip-compressed tar archive of what's needed to diagnose
+// problems with nbictl, to attach to a support ticket: its version, its
+// configuration with any secrets redacted, the result of connecting to the
+// selected context, and the end of the --grpc_log file. Anything that can't
+// be collected is listed in the archive's collection_errors.txt instead of
+// failing the command.
+func Collect(appCtx *cli.Context) error {
+	now := time.Now()
+	path := appCtx.Path("output_file")
+	if path == "" {
+		path = "nbictl-diagnostics-" + now.UTC().Format("20060102-150405") + ".tar.gz"
+	}
+	timeout := defaultCollectTimeout
+	if appCtx.IsSet("timeout") {
+		timeout = appCtx.Duration("timeout")
+	}
+
+	files := []struct {
+		name string
+		data func() ([]byte, error)
+	}{
+		{"version.txt", func() ([]byte, error) { return versionInfo(), nil }},
+		{"config.textproto", func() ([]byte, error) { return redactedConfigs(appCtx) }},
+		// The connection is probed before the log is read, so the log
+		// includes the probe's calls.
+		{"connection.txt", func() ([]byte, error) { return probeConnection(appCtx, timeout) }},
+		{"grpc.log", func() ([]byte, error) { return grpcLogTail(appCtx) }},
+	}
+
+	buf := &bytes.Buffer{}
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+	add := func(name string, data []byte) error {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), ModTime: now}); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	failures := []string{}
+	for _, f := range files {
+		data, err := f.data()
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v\n", f.name, err))
+			continue
+		}
+		if err := add(f.name, data); err != nil {
+			return err
+		}
+	}
+	if len(failures) > 0 {
+		if err := add("collection_errors.txt", []byte(strings.Join(failures, ""))); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gw.Close(); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o600); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+
+	fmt.Fprintf(appCtx.App.Writer, "wrote %s\n", path)
+	if len(failures) > 0 {
+		fmt.Fprintf(appCtx.App.ErrWriter, "%d item(s) couldn't be collected; see collection_errors.txt in the archive\n", len(failures))
+	}
+	return nil
+}
+
+func versionInfo() []byte {
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "go: %s\nplatform: %s/%s\n\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		sb.WriteString(bi.String())
+	} else {
+		sb.WriteString("no build information available\n")
+	}
+	return []byte(sb.String())
+}
+
+// redactedConfigs returns every configuration profile, with the values of
+// fields that look like they hold credentials redacted.
+func redactedConfigs(appCtx *cli.Context) ([]byte, error) {
+	confFile, err := getConfFileForContext(appCtx)
+	if err != nil {
+		return nil, err
+	}
+	confs, err := readConfigs(confFile)
+	if err != nil {
+		return nil, err
+	}
+	return prototext.MarshalOptions{Multiline: true}.Marshal(rpclog.Options{}.Redact(confs))
+}
+
+// probeConnection connects to the selected context and lists the services
+// the server exposes. A failure to connect is reported in the result, since
+// that's usually what the bundle is meant to diagnose.
+func probeConnection(appCtx *cli.Context, timeout time.Duration) ([]byte, error) {
+	confFile, err := getConfFileForContext(appCtx)
+	if err != nil {
+		return nil, err
+	}
+	setting, err := readConfig(appCtx.String("context"), confFile)
+	if err != nil {
+		return nil, err
+	}
+
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "context: %s\nurl: %s\n", setting.GetName(), setting.GetUrl())
+
+	ctx, cancel := context.WithTimeout(appCtx.Context, timeout)
+	defer cancel()
+	start := time.Now()
+	svcs, err := func() ([]string, error) {
+		conn, err := openConnection(appCtx)
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+		refClient := grpcreflect.NewClientAuto(ctx, conn)
+		defer refClient.Reset()
+		return refClient.ListServices()
+	}()
+	fmt.Fprintf(sb, "elapsed: %s\n", time.Since(start).Round(time.Millisecond))
+	if err != nil {
+		fmt.Fprintf(sb, "error: %v\n", err)
+		return []byte(sb.String()), nil
+	}
+	sb.WriteString("services:\n")
+	for _, svc := range svcs {
+		fmt.Fprintf(sb, "  %s\n", svc)
+	}
+	return []byte(sb.String()), nil
+}
+
+// grpcLogTail returns the end of the file the --grpc_log flag points to.
+// The interceptors that write it have already redacted its contents.
+func grpcLogTail(appCtx *cli.Context) ([]byte, error) {
+	path := appCtx.String("grpc_log")
+	if path == "" || path == "-" {
+		return nil, errors.New("--grpc_log isn't set to a file")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > collectLogLimit {
+		if _, err := f.Seek(info.Size()-collectLogLimit, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+	return io.ReadAll(f)
+}
@@ -0,0 +1,129 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/rules_go/go/tools/bazel"
+	"golang.org/x/sync/errgroup"
+)
+
+func readTarball(t *testing.T, path string) map[string]string {
+	t.Helper()
+
+	f, err := os.Open(path)
+	checkErr(t, err)
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	checkErr(t, err)
+	tr := tar.NewReader(gr)
+	files := map[string]string{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		checkErr(t, err)
+		data, err := io.ReadAll(tr)
+		checkErr(t, err)
+		files[hdr.Name] = string(data)
+	}
+}
+
+func TestCollect(t *testing.T) {
+	t.Parallel()
+
+	dir, err := bazel.NewTmpDir("nbictl")
+	checkErr(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	g, ctx := errgroup.WithContext(ctx)
+	defer func() { checkErr(t, g.Wait()) }()
+	defer cancel()
+	srv := startInsecureServer(ctx, t, g)
+
+	keys := generateKeysForTesting(t, dir, "--org", "example org")
+	checkErr(t, newTestApp().Run([]string{
+		"nbictl", "--config_dir", dir,
+		"set-config",
+		"--transport_security", "insecure",
+		"--user_id", "usr1",
+		"--key_id", "key1",
+		"--priv_key", keys.key,
+		"--url", srv.listener.Addr().String(),
+	}))
+
+	t.Run("connected", func(t *testing.T) {
+		out := filepath.Join(dir, "bundle.tar.gz")
+		logFile := filepath.Join(dir, "grpc.log")
+		app := newTestApp()
+		checkErr(t, app.Run([]string{"nbictl", "--config_dir", dir, "--grpc_log", logFile, "collect", "--output_file", out}))
+		if got, want := app.stdout.String(), "wrote "+out+"\n"; got != want {
+			t.Errorf("got output %q, want %q", got, want)
+		}
+
+		files := readTarball(t, out)
+		for _, name := range []string{"version.txt", "config.textproto", "connection.txt", "grpc.log"} {
+			if _, ok := files[name]; !ok {
+				t.Errorf("bundle is missing %s; has %v", name, files)
+			}
+		}
+		if _, ok := files["collection_errors.txt"]; ok {
+			t.Errorf("unexpected collection errors: %s", files["collection_errors.txt"])
+		}
+		if conf := files["config.textproto"]; strings.Contains(conf, keys.key) || !strings.Contains(conf, "REDACTED") || !strings.Contains(conf, "key1") {
+			t.Errorf("expected the private key path to be redacted and the rest kept, got:\n%s", conf)
+		}
+		if conn := files["connection.txt"]; !strings.Contains(conn, "aalyria.spacetime.api.nbi.v1alpha.NetOps") {
+			t.Errorf("expected the NetOps service to be listed, got:\n%s", conn)
+		}
+	})
+
+	t.Run("unreachable", func(t *testing.T) {
+		unreachableDir, err := bazel.NewTmpDir("nbictl")
+		checkErr(t, err)
+		checkErr(t, newTestApp().Run([]string{
+			"nbictl", "--config_dir", unreachableDir,
+			"set-config",
+			"--transport_security", "insecure",
+			"--user_id", "usr1",
+			"--key_id", "key1",
+			"--priv_key", keys.key,
+			"--url", "127.0.0.1:1",
+		}))
+
+		out := filepath.Join(unreachableDir, "bundle.tar.gz")
+		app := newTestApp()
+		checkErr(t, app.Run([]string{"nbictl", "--config_dir", unreachableDir, "collect", "--output_file", out, "--timeout", "2s"}))
+		files := readTarball(t, out)
+		if conn := files["connection.txt"]; !strings.Contains(conn, "error:") {
+			t.Errorf("expected the connection error to be recorded, got:\n%s", conn)
+		}
+		if errs := files["collection_errors.txt"]; !strings.Contains(errs, "grpc.log: --grpc_log isn't set to a file") {
+			t.Errorf("expected the missing gRPC log to be recorded, got:\n%s", errs)
+		}
+		if !strings.Contains(app.stderr.String(), "1 item(s) couldn't be collected") {
+			t.Errorf("expected a warning about missing items, got %q", app.stderr.String())
+		}
+	})
+}
@@ -0,0 +1,276 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+)
+
+const (
+	// completionTimeout bounds how long completing entity IDs waits for the
+	// server, so a slow connection doesn't freeze the prompt.
+	completionTimeout = 2 * time.Second
+
+	completeCommandName = "__complete"
+)
+
+// completionScripts are the scripts printed by `nbictl completion`. Each of
+// them calls the hidden `__complete` command with the words on the command
+// line, the last of which is the one being completed.
+var completionScripts = map[string]string{
+	"bash": `# bash completion for nbictl
+_nbictl_complete() {
+  local IFS=$'\n'
+  COMPREPLY=($("${COMP_WORDS[0]}" __complete "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null))
+}
+complete -o default -F _nbictl_complete nbictl
+`,
+	"zsh": `#compdef nbictl
+# zsh completion for nbictl
+_nbictl() {
+  local -a candidates
+  candidates=("${(@f)$("${words[1]}" __complete "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+  compadd -a candidates
+}
+if [ "$funcstack[1]" = "_nbictl" ]; then
+  _nbictl "$@"
+else
+  compdef _nbictl nbictl
+fi
+`,
+	"fish": `# fish completion for nbictl
+function __nbictl_complete
+  set -l args (commandline -opc)
+  nbictl __complete $args[2..-1] (commandline -ct) 2>/dev/null
+end
+complete -c nbictl -f -a '(__nbictl_complete)'
+`,
+}
+
+func completionShells() []string {
+	shells := []string{}
+	for sh := range completionScripts {
+		shells = append(shells, sh)
+	}
+	slices.Sort(shells)
+	return shells
+}
+
+func Completion(appCtx *cli.Context) error {
+	if appCtx.Args().Len() != 1 {
+		return fmt.Errorf("expected 1 argument, one of [%s]", strings.Join(completionShells(), ", "))
+	}
+	script, ok := completionScripts[appCtx.Args().First()]
+	if !ok {
+		return fmt.Errorf("unsupported shell %q is not one of [%s]", appCtx.Args().First(), strings.Join(completionShells(), ", "))
+	}
+	_, err := fmt.Fprint(appCtx.App.Writer, script)
+	return err
+}
+
+// Complete prints the candidates for the last of its arguments, given the
+// ones before it, one per line. It's called by the completion scripts, so
+// errors are never reported: at worst, nothing is completed.
+func Complete(appCtx *cli.Context) error {
+	words := appCtx.Args().Slice()
+	current := ""
+	if len(words) > 0 {
+		current, words = words[len(words)-1], words[:len(words)-1]
+	}
+
+	// Global flags, such as --context, have to apply when fetching entity IDs,
+	// so they're parsed by running the command again with them in place.
+	if globals, rest := splitGlobalArgs(appCtx.App.Flags, words); len(globals) > 0 {
+		app := App()
+		app.Writer, app.ErrWriter = appCtx.App.Writer, appCtx.App.ErrWriter
+		app.ExitErrHandler = func(*cli.Context, error) {}
+		args := slices.Concat([]string{appName}, inheritedGlobalArgs(appCtx), globals, []string{completeCommandName}, rest, []string{current})
+		_ = app.RunContext(appCtx.Context, args)
+		return nil
+	}
+
+	var candidates []string
+	if len(words) == 0 && strings.HasPrefix(current, "-") {
+		for _, f := range appCtx.App.Flags {
+			candidates = append(candidates, "--"+f.Names()[0])
+		}
+	} else {
+		c := &commandCompleter{
+			commands:  appCtx.App.Commands,
+			entityIDs: func(t nbipb.EntityType) []string { return fetchEntityIDs(appCtx, t) },
+		}
+		candidates = c.candidates(words, current)
+	}
+	for _, cand := range candidates {
+		if strings.HasPrefix(cand, current) {
+			fmt.Fprintln(appCtx.App.Writer, cand)
+		}
+	}
+	return nil
+}
+
+// splitGlobalArgs splits the global flags, and their values, from the start
+// of `words`.
+func splitGlobalArgs(flags []cli.Flag, words []string) (globals, rest []string) {
+	for len(words) > 0 && strings.HasPrefix(words[0], "-") {
+		name, _, hasValue := strings.Cut(strings.TrimLeft(words[0], "-"), "=")
+		i := slices.IndexFunc(flags, func(f cli.Flag) bool { return slices.Contains(f.Names(), name) })
+		if i < 0 {
+			break
+		}
+		n := 1
+		if _, isBool := flags[i].(*cli.BoolFlag); !isBool && !hasValue && len(words) > 1 {
+			n = 2
+		}
+		globals, words = append(globals, words[:n]...), words[n:]
+	}
+	return globals, words
+}
+
+// fetchEntityIDs returns the sorted IDs of the entities of type `t`, or nil
+// if they can't be fetched within the completionTimeout.
+func fetchEntityIDs(appCtx *cli.Context, t nbipb.EntityType) []string {
+	ctx, cancel := context.WithTimeout(appCtx.Context, completionTimeout)
+	defer cancel()
+	conn, err := openConnection(appCtx)
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+	res, err := nbipb.NewNetOpsClient(conn).ListEntities(ctx, &nbipb.ListEntitiesRequest{Type: t.Enum(), Filter: &nbipb.EntityFilter{FieldMasks: []string{"id"}}})
+	if err != nil {
+		return nil
+	}
+
+	ids := []string{}
+	for _, e := range res.GetEntities() {
+		ids = append(ids, e.GetId())
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// commandCompleter completes command names, flag names, entity types, and
+// the IDs of entities of the type given with `--type`.
+type commandCompleter struct {
+	commands []*cli.Command
+	// extraCommands are completed along with the top-level commands.
+	extraCommands []string
+	entityIDs     func(nbipb.EntityType) []string
+}
+
+// Do implements readline.AutoCompleter.
+func (c *commandCompleter) Do(line []rune, pos int) ([][]rune, int) {
+	words := strings.Fields(string(line[:pos]))
+	current := ""
+	if len(words) > 0 && !strings.HasSuffix(string(line[:pos]), " ") {
+		current, words = words[len(words)-1], words[:len(words)-1]
+	}
+
+	out := [][]rune{}
+	for _, cand := range c.candidates(words, current) {
+		if strings.HasPrefix(cand, current) {
+			out = append(out, []rune(strings.TrimPrefix(cand, current)+" "))
+		}
+	}
+	return out, len([]rune(current))
+}
+
+// candidates returns the words that could follow `words`. They aren't
+// filtered by `current`, the partial word being completed, which is only
+// used to tell flags from other arguments.
+func (c *commandCompleter) candidates(words []string, current string) []string {
+	// Find the (sub)command being completed.
+	commands := c.commands
+	var cmd *cli.Command
+	i := 0
+	for ; i < len(words); i++ {
+		next := findCommand(commands, words[i])
+		if next == nil {
+			break
+		}
+		cmd, commands = next, next.Subcommands
+	}
+	if i == len(words) && (cmd == nil || len(cmd.Subcommands) > 0) && !strings.HasPrefix(current, "-") {
+		names := []string{}
+		for _, sub := range commands {
+			if !sub.Hidden {
+				names = append(names, sub.Name)
+			}
+		}
+		if cmd == nil {
+			names = append(names, c.extraCommands...)
+		}
+		return names
+	}
+	if cmd == nil {
+		return nil
+	}
+
+	if strings.HasPrefix(current, "-") {
+		names := []string{}
+		for _, f := range cmd.Flags {
+			names = append(names, "--"+f.Names()[0])
+		}
+		return names
+	}
+	if len(words) == 0 {
+		return nil
+	}
+	switch words[len(words)-1] {
+	case "--type", "-t":
+		return entityTypeList
+	case "--id":
+		if t, ok := entityTypeFromWords(words); ok && c.entityIDs != nil {
+			return c.entityIDs(t)
+		}
+	}
+	return nil
+}
+
+func findCommand(commands []*cli.Command, name string) *cli.Command {
+	for _, cmd := range commands {
+		if cmd.HasName(name) {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func entityTypeFromWords(words []string) (nbipb.EntityType, bool) {
+	for i, w := range words {
+		v := ""
+		switch {
+		case (w == "--type" || w == "-t") && i+1 < len(words):
+			v = words[i+1]
+		case strings.HasPrefix(w, "--type="):
+			v = strings.TrimPrefix(w, "--type=")
+		default:
+			continue
+		}
+		if t, ok := nbipb.EntityType_value[v]; ok {
+			return nbipb.EntityType(t), true
+		}
+	}
+	return 0, false
+}
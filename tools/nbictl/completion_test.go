@@ -0,0 +1,151 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/rules_go/go/tools/bazel"
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/protobuf/proto"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+)
+
+func TestCommandCompleter(t *testing.T) {
+	t.Parallel()
+
+	requested := []nbipb.EntityType{}
+	c := &commandCompleter{
+		commands: App().Commands,
+		entityIDs: func(t nbipb.EntityType) []string {
+			requested = append(requested, t)
+			return []string{"node-a", "node-b", "other"}
+		},
+	}
+	complete := func(line string) []string {
+		candidates, _ := c.Do([]rune(line), len([]rune(line)))
+		got := []string{}
+		for _, cand := range candidates {
+			got = append(got, line+string(cand))
+		}
+		return got
+	}
+
+	for _, tc := range []struct {
+		line string
+		want []string
+	}{
+		{line: "list-f", want: []string{"list-features "}},
+		{line: "grpcurl desc", want: []string{"grpcurl describe "}},
+		{line: "get --i", want: []string{"get --id "}},
+		{line: "list -t NETWORK_N", want: []string{"list -t NETWORK_NODE "}},
+		{line: "get --type NETWORK_NODE --id node-", want: []string{"get --type NETWORK_NODE --id node-a ", "get --type NETWORK_NODE --id node-b "}},
+		{line: "get --id ", want: []string{}},
+		{line: "get --type NETWORK_NODE ", want: []string{}},
+	} {
+		if diff := cmp.Diff(tc.want, complete(tc.line)); diff != "" {
+			t.Errorf("unexpected completions for %q (-want +got):\n%s", tc.line, diff)
+		}
+	}
+
+	if diff := cmp.Diff([]nbipb.EntityType{nbipb.EntityType_NETWORK_NODE}, requested); diff != "" {
+		t.Errorf("unexpected entity types requested (-want +got):\n%s", diff)
+	}
+}
+
+func TestComplete(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 90*time.Second)
+	g, ctx := errgroup.WithContext(ctx)
+	defer func() { checkErr(t, g.Wait()) }()
+	defer cancel()
+
+	confDir, err := bazel.NewTmpDir("nbictl")
+	checkErr(t, err)
+	srv := startInsecureServer(ctx, t, g)
+	srv.ListEntityResponse = &nbipb.ListEntitiesResponse{Entities: []*nbipb.Entity{
+		{Id: proto.String("node-b")},
+		{Id: proto.String("node-a")},
+		{Id: proto.String("other")},
+	}}
+
+	keys := generateKeysForTesting(t, confDir, "--org", "example org")
+	for _, name := range []string{"DEFAULT", "staging"} {
+		checkErr(t, newTestApp().Run([]string{
+			"nbictl", "--config_dir", confDir, "--context", name,
+			"set-config",
+			"--transport_security", "insecure",
+			"--user_id", "usr1",
+			"--key_id", "key1",
+			"--priv_key", keys.key,
+			"--url", srv.listener.Addr().String(),
+		}))
+	}
+
+	for _, tc := range []struct {
+		name  string
+		words []string
+		want  []string
+	}{
+		{name: "commands", words: []string{"get-"}, want: []string{"get-link-budget", "get-config"}},
+		{name: "global flags", words: []string{"--con"}, want: []string{"--context", "--config_dir"}},
+		{name: "command flags", words: []string{"delete", "--i"}, want: []string{"--id", "--ids", "--ignore_consistency_check"}},
+		{name: "entity types", words: []string{"get", "--type", "NETWORK_"}, want: []string{"NETWORK_NODE", "NETWORK_STATS_REPORT"}},
+		// With two contexts and no --context, the IDs can't be fetched.
+		{name: "entity IDs without context", words: []string{"get", "--type", "NETWORK_NODE", "--id", "node"}, want: []string{}},
+		{name: "entity IDs", words: []string{"--context", "staging", "get", "--type", "NETWORK_NODE", "--id", "node"}, want: []string{"node-a", "node-b"}},
+		{name: "entity IDs with flag value", words: []string{"--context=staging", "delete", "-t", "NETWORK_NODE", "--id", ""}, want: []string{"node-a", "node-b", "other"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			app := newTestApp()
+			checkErr(t, app.Run(append([]string{"nbictl", "--config_dir", confDir, "__complete"}, tc.words...)))
+			got := strings.Fields(app.stdout.String())
+			if got == nil {
+				got = []string{}
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Fatalf("unexpected candidates (-want +got):\n%s", diff)
+			}
+			if app.stderr.Len() > 0 {
+				t.Fatalf("expected nothing on stderr, got:\n%s", app.stderr)
+			}
+		})
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	t.Parallel()
+
+	for _, sh := range []string{"bash", "fish", "zsh"} {
+		app := newTestApp()
+		checkErr(t, app.Run([]string{"nbictl", "completion", sh}))
+		if !strings.Contains(app.stdout.String(), "nbictl __complete") && !strings.Contains(app.stdout.String(), `" __complete`) {
+			t.Errorf("expected the %s script to call __complete, got:\n%s", sh, app.stdout)
+		}
+	}
+
+	switch want, err := `unsupported shell "tcsh" is not one of [bash, fish, zsh]`, newTestApp().Run([]string{"nbictl", "completion", "tcsh"}); {
+	case err == nil:
+		t.Fatal("expected an unsupported shell to cause an error, got nil")
+	case !strings.Contains(err.Error(), want):
+		t.Fatalf("expected error to contain %s, but got %s", want, err.Error())
+	}
+}
@@ -15,16 +15,21 @@
 package nbictl
 
 import (
+	"cmp"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"text/tabwriter"
 
 	"aalyria.com/spacetime/github/tools/nbictl/nbictlpb"
 	"github.com/urfave/cli/v2"
 	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
 )
 
 func getAppConfDir(appCtx *cli.Context) (string, error) {
@@ -70,8 +75,10 @@ func readConfig(context, confFilePath string) (*nbictlpb.Config, error) {
 	return nil, fmt.Errorf("unable to get the context with the name: %q (expected one of [%s])", context, strings.Join(confNames, ", "))
 }
 
+// readConfigs reads the configuration file at `confFilePath`, migrating it
+// in memory if it was written by an older version of nbictl.
 func readConfigs(confFilePath string) (*nbictlpb.AppConfig, error) {
-	confProto := &nbictlpb.AppConfig{}
+	confProto := &nbictlpb.AppConfig{SchemaVersion: configSchemaVersion}
 	confBytes, err := os.ReadFile(confFilePath)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -83,6 +90,9 @@ func readConfigs(confFilePath string) (*nbictlpb.AppConfig, error) {
 	if err := prototext.Unmarshal(confBytes, confProto); err != nil {
 		return nil, fmt.Errorf("invalid file content: %w", err)
 	}
+	if _, err := migrateAppConfig(confProto); err != nil {
+		return nil, err
+	}
 	return confProto, nil
 }
 
@@ -141,7 +151,103 @@ func GetConfig(appCtx *cli.Context) error {
 	return fmt.Errorf("unable to find config %q in file %q.", confName, confFile)
 }
 
+// DescribeConfig prints the settings that the selected context authenticates
+// with, after applying their defaults, along with where each came from.
+func DescribeConfig(appCtx *cli.Context) error {
+	confFile, err := getConfFileForContext(appCtx)
+	if err != nil {
+		return err
+	}
+	setting, err := readConfig(appCtx.String("context"), confFile)
+	if err != nil {
+		return err
+	}
+	audience, err := tokenAudience(setting)
+	if err != nil {
+		return err
+	}
+
+	// defaulted describes a value that wasn't configured and was derived
+	// from `source` instead.
+	defaulted := func(value, configured, source string) string {
+		if configured != "" {
+			return configured
+		}
+		return fmt.Sprintf("%s (default: %s)", value, source)
+	}
+	audienceSource := "host of url"
+	if setting.GetAudience() != "" {
+		audienceSource = "audience"
+	}
+
+	rows := [][2]string{
+		{"context", setting.GetName()},
+		{"url", setting.GetUrl()},
+		{"auth strategy", authStrategyName(setting.GetAuthStrategy())},
+	}
+	switch s := setting.GetAuthStrategy().GetType().(type) {
+	case *nbictlpb.Config_AuthStrategy_StaticToken_:
+		// Static tokens are sent as-is.
+
+	case *nbictlpb.Config_AuthStrategy_ServiceAccountImpersonation_:
+		rows = append(rows, [2]string{"audience", defaulted(audience, s.ServiceAccountImpersonation.GetAudience(), audienceSource)})
+
+	case *nbictlpb.Config_AuthStrategy_ClientCredentials_:
+		cc := s.ClientCredentials
+		scopes := cmp.Or(strings.Join(cc.GetScopes(), " "), strings.Join(setting.GetScopes(), " "))
+		rows = append(rows,
+			[2]string{"issuer", cmp.Or(setting.GetIssuer(), "(none)")},
+			[2]string{"token url", cmp.Or(cc.GetTokenUrl(), "(discovered from issuer)")},
+			[2]string{"audience", cmp.Or(cc.GetAudience(), setting.GetAudience(), "(none)")},
+			[2]string{"scopes", cmp.Or(scopes, "(none)")},
+		)
+
+	default:
+		rows = append(rows,
+			[2]string{"issuer", defaulted(setting.GetEmail(), setting.GetIssuer(), "user_id")},
+			[2]string{"audience", defaulted(audience, setting.GetAudience(), "host of url")},
+			[2]string{"scopes", cmp.Or(strings.Join(setting.GetScopes(), " "), "(none)")},
+		)
+	}
+
+	w := tabwriter.NewWriter(appCtx.App.Writer, 0, 0, 2, ' ', 0)
+	for _, r := range rows {
+		fmt.Fprintf(w, "%s:\t%s\n", r[0], r[1])
+	}
+	return w.Flush()
+}
+
+// authStrategyName returns the --auth_strategy value that selects `s`.
+func authStrategyName(s *nbictlpb.Config_AuthStrategy) string {
+	switch s.GetType().(type) {
+	case nil, *nbictlpb.Config_AuthStrategy_PrivateKey:
+		return "private_key"
+	case *nbictlpb.Config_AuthStrategy_ClientCredentials_:
+		return "client_credentials"
+	case *nbictlpb.Config_AuthStrategy_ServiceAccountImpersonation_:
+		return "service_account_impersonation"
+	case *nbictlpb.Config_AuthStrategy_StaticToken_:
+		return "static_token"
+	case *nbictlpb.Config_AuthStrategy_Pkcs11_:
+		return "pkcs11"
+	case *nbictlpb.Config_AuthStrategy_AwsKms_:
+		return "aws_kms"
+	case *nbictlpb.Config_AuthStrategy_GcpKms_:
+		return "gcp_kms"
+	case *nbictlpb.Config_AuthStrategy_VaultKv_:
+		return "vault_kv"
+	case *nbictlpb.Config_AuthStrategy_VaultTransit_:
+		return "vault_transit"
+	default:
+		return fmt.Sprintf("%T", s.GetType())
+	}
+}
+
 func SetConfig(appCtx *cli.Context) error {
+	if err := checkLocalStateWritable(appCtx); err != nil {
+		return err
+	}
+
 	confName := "DEFAULT"
 	if appCtx.IsSet("context") {
 		confName = appCtx.String("context")
@@ -170,6 +276,32 @@ func SetConfig(appCtx *cli.Context) error {
 			Type: &nbictlpb.Config_TransportSecurity_SystemCertPool{},
 		}
 
+	case "mutual_tls":
+		transportSecurityPb = &nbictlpb.Config_TransportSecurity{
+			Type: &nbictlpb.Config_TransportSecurity_MutualTls_{
+				MutualTls: &nbictlpb.Config_TransportSecurity_MutualTls{
+					ClientCertFilePath: appCtx.String("client_cert"),
+					ClientKeyFilePath:  appCtx.String("client_key"),
+					CaBundleFilePath:   appCtx.String("ca_bundle"),
+				},
+			},
+		}
+
+	case "spiffe":
+		transportSecurityPb = &nbictlpb.Config_TransportSecurity{
+			Type: &nbictlpb.Config_TransportSecurity_Spiffe_{
+				Spiffe: &nbictlpb.Config_TransportSecurity_Spiffe{
+					WorkloadApiAddr: appCtx.String("spiffe_socket"),
+					ServerId:        appCtx.String("server_spiffe_id"),
+				},
+			},
+		}
+
+	case "local":
+		transportSecurityPb = &nbictlpb.Config_TransportSecurity{
+			Type: &nbictlpb.Config_TransportSecurity_Local{},
+		}
+
 	case "":
 		transportSecurityPb = nil
 
@@ -177,6 +309,11 @@ func SetConfig(appCtx *cli.Context) error {
 		return fmt.Errorf("unexpected transport security selection: %s", transportSecurity)
 	}
 
+	authStrategyPb, err := authStrategyFromFlags(appCtx)
+	if err != nil {
+		return err
+	}
+
 	contextToCreate := &nbictlpb.Config{
 		Name:              confName,
 		KeyId:             keyID,
@@ -184,62 +321,228 @@ func SetConfig(appCtx *cli.Context) error {
 		PrivKey:           privKey,
 		Url:               url,
 		TransportSecurity: transportSecurityPb,
+		AuthStrategy:      authStrategyPb,
+		EnabledFeatures:   appCtx.StringSlice("enabled_features"),
+		ProxyUrl:          appCtx.String("proxy"),
+		Audience:          appCtx.String("audience"),
+		Issuer:            appCtx.String("issuer"),
+		Scopes:            appCtx.StringSlice("scopes"),
+	}
+	if appCtx.IsSet("default_timeout") {
+		timeout := appCtx.Duration("default_timeout")
+		if timeout < 0 {
+			return fmt.Errorf("--default_timeout can't be negative, got %v", timeout)
+		}
+		contextToCreate.Timeout = durationpb.New(timeout)
+	}
+	// The global --read_only flag has the same name, so only the flag
+	// passed to set-config itself counts.
+	if slices.Contains(appCtx.LocalFlagNames(), "read_only") {
+		contextToCreate.ReadOnly = proto.Bool(appCtx.Bool("read_only"))
 	}
 
 	return setConfig(appCtx.App.Writer, appCtx.App.ErrWriter, contextToCreate, confPath)
 }
 
-func setConfig(outWriter, errWriter io.Writer, confToCreate *nbictlpb.Config, confFile string) error {
-	if confToCreate.GetName() == "" {
-		return errors.New("missing required --context flag")
-	}
-
-	confProto, err := readConfigs(confFile)
-	if err != nil {
-		return fmt.Errorf("unable to get configs from file %s: %w", confFile, err)
-	}
-
-	found := false
-	for _, confProto := range confProto.GetConfigs() {
-		if confProto.GetName() != confToCreate.GetName() {
-			continue
+func authStrategyFromFlags(appCtx *cli.Context) (*nbictlpb.Config_AuthStrategy, error) {
+	switch authStrategy := appCtx.String("auth_strategy"); authStrategy {
+	case "private_key":
+		return &nbictlpb.Config_AuthStrategy{
+			Type: &nbictlpb.Config_AuthStrategy_PrivateKey{},
+		}, nil
+
+	case "client_credentials":
+		return &nbictlpb.Config_AuthStrategy{
+			Type: &nbictlpb.Config_AuthStrategy_ClientCredentials_{
+				ClientCredentials: &nbictlpb.Config_AuthStrategy_ClientCredentials{
+					TokenUrl:         appCtx.String("token_url"),
+					ClientId:         appCtx.String("client_id"),
+					ClientSecretFile: appCtx.String("client_secret_file"),
+				},
+			},
+		}, nil
+
+	case "service_account_impersonation":
+		if !appCtx.IsSet("impersonate_service_account") {
+			return nil, errors.New("--impersonate_service_account is required for the service_account_impersonation auth strategy")
 		}
-		if confToCreate.GetEmail() != "" {
-			confProto.Email = confToCreate.GetEmail()
+		return &nbictlpb.Config_AuthStrategy{
+			Type: &nbictlpb.Config_AuthStrategy_ServiceAccountImpersonation_{
+				ServiceAccountImpersonation: &nbictlpb.Config_AuthStrategy_ServiceAccountImpersonation{
+					TargetServiceAccount: appCtx.String("impersonate_service_account"),
+					SourceTokenEnvVar:    appCtx.String("token_env_var"),
+				},
+			},
+		}, nil
+
+	case "static_token":
+		if !appCtx.IsSet("token_env_var") {
+			return nil, errors.New("--token_env_var is required for the static_token auth strategy")
 		}
-		if confToCreate.GetPrivKey() != "" {
-			confProto.PrivKey = confToCreate.GetPrivKey()
+		return &nbictlpb.Config_AuthStrategy{
+			Type: &nbictlpb.Config_AuthStrategy_StaticToken_{
+				StaticToken: &nbictlpb.Config_AuthStrategy_StaticToken{
+					EnvVar: appCtx.String("token_env_var"),
+				},
+			},
+		}, nil
+
+	case "pkcs11":
+		if !appCtx.IsSet("pkcs11_module") {
+			return nil, errors.New("--pkcs11_module is required for the pkcs11 auth strategy")
 		}
-		if confToCreate.GetKeyId() != "" {
-			confProto.KeyId = confToCreate.GetKeyId()
+		p := &nbictlpb.Config_AuthStrategy_Pkcs11{
+			ModulePath: appCtx.String("pkcs11_module"),
+			TokenLabel: appCtx.String("pkcs11_token_label"),
+			KeyLabel:   appCtx.String("pkcs11_key_label"),
+			KeyId:      appCtx.String("pkcs11_key_id"),
+			PinEnvVar:  appCtx.String("pkcs11_pin_env_var"),
 		}
-		if confToCreate.GetUrl() != "" {
-			confProto.Url = confToCreate.GetUrl()
+		if appCtx.IsSet("pkcs11_slot") {
+			p.Slot = proto.Int32(int32(appCtx.Int("pkcs11_slot")))
 		}
-		if confToCreate.GetTransportSecurity() != nil {
-			confProto.TransportSecurity = confToCreate.GetTransportSecurity()
+		return &nbictlpb.Config_AuthStrategy{
+			Type: &nbictlpb.Config_AuthStrategy_Pkcs11_{Pkcs11: p},
+		}, nil
+
+	case "aws_kms":
+		if !appCtx.IsSet("kms_key") {
+			return nil, errors.New("--kms_key is required for the aws_kms auth strategy")
 		}
-		found = true
-		confToCreate = confProto
-		break
-	}
+		return &nbictlpb.Config_AuthStrategy{
+			Type: &nbictlpb.Config_AuthStrategy_AwsKms_{
+				AwsKms: &nbictlpb.Config_AuthStrategy_AwsKms{
+					KeyId:  appCtx.String("kms_key"),
+					Region: appCtx.String("aws_region"),
+				},
+			},
+		}, nil
+
+	case "gcp_kms":
+		if !appCtx.IsSet("kms_key") {
+			return nil, errors.New("--kms_key is required for the gcp_kms auth strategy")
+		}
+		return &nbictlpb.Config_AuthStrategy{
+			Type: &nbictlpb.Config_AuthStrategy_GcpKms_{
+				GcpKms: &nbictlpb.Config_AuthStrategy_GcpKms{
+					KeyVersionName: appCtx.String("kms_key"),
+				},
+			},
+		}, nil
+
+	case "vault_kv":
+		if !appCtx.IsSet("vault_path") {
+			return nil, errors.New("--vault_path is required for the vault_kv auth strategy")
+		}
+		return &nbictlpb.Config_AuthStrategy{
+			Type: &nbictlpb.Config_AuthStrategy_VaultKv_{
+				VaultKv: &nbictlpb.Config_AuthStrategy_VaultKv{
+					Mount: appCtx.String("vault_mount"),
+					Path:  appCtx.String("vault_path"),
+					Field: appCtx.String("vault_field"),
+				},
+			},
+		}, nil
+
+	case "vault_transit":
+		if !appCtx.IsSet("vault_key") {
+			return nil, errors.New("--vault_key is required for the vault_transit auth strategy")
+		}
+		return &nbictlpb.Config_AuthStrategy{
+			Type: &nbictlpb.Config_AuthStrategy_VaultTransit_{
+				VaultTransit: &nbictlpb.Config_AuthStrategy_VaultTransit{
+					Mount:   appCtx.String("vault_mount"),
+					KeyName: appCtx.String("vault_key"),
+				},
+			},
+		}, nil
 
-	if !found {
-		confProto.Configs = append(confProto.Configs, confToCreate)
-	}
+	case "":
+		return nil, nil
 
-	nbiConfigTextProto, err := prototext.Marshal(confProto)
-	if err != nil {
-		return fmt.Errorf("unable to convert proto into textproto format: %w", err)
+	default:
+		return nil, fmt.Errorf("unexpected auth strategy selection: %s", authStrategy)
 	}
+}
 
-	contextDir := filepath.Dir(confFile)
-	if err = os.MkdirAll(contextDir, 0o777); err != nil {
-		return fmt.Errorf("unable to create directory: %w", err)
+func setConfig(outWriter, errWriter io.Writer, confToCreate *nbictlpb.Config, confFile string) error {
+	if confToCreate.GetName() == "" {
+		return errors.New("missing required --context flag")
 	}
 
-	if err = os.WriteFile(confFile, nbiConfigTextProto, 0o777); err != nil {
-		return fmt.Errorf("unable to update the configuration information: %w", err)
+	// Hold the lock across the read-modify-write so that concurrent
+	// invocations don't clobber each other's changes.
+	err := withFileLock(confFile, func() error {
+		confProto, err := readConfigs(confFile)
+		if err != nil {
+			return fmt.Errorf("unable to get configs from file %s: %w", confFile, err)
+		}
+
+		found := false
+		for _, confProto := range confProto.GetConfigs() {
+			if confProto.GetName() != confToCreate.GetName() {
+				continue
+			}
+			if confToCreate.GetEmail() != "" {
+				confProto.Email = confToCreate.GetEmail()
+			}
+			if confToCreate.GetPrivKey() != "" {
+				confProto.PrivKey = confToCreate.GetPrivKey()
+			}
+			if confToCreate.GetKeyId() != "" {
+				confProto.KeyId = confToCreate.GetKeyId()
+			}
+			if confToCreate.GetUrl() != "" {
+				confProto.Url = confToCreate.GetUrl()
+			}
+			if confToCreate.GetTransportSecurity() != nil {
+				confProto.TransportSecurity = confToCreate.GetTransportSecurity()
+			}
+			if confToCreate.GetAuthStrategy() != nil {
+				confProto.AuthStrategy = confToCreate.GetAuthStrategy()
+			}
+			if len(confToCreate.GetEnabledFeatures()) > 0 {
+				confProto.EnabledFeatures = confToCreate.GetEnabledFeatures()
+			}
+			if confToCreate.GetProxyUrl() != "" {
+				confProto.ProxyUrl = confToCreate.GetProxyUrl()
+			}
+			if confToCreate.GetTimeout() != nil {
+				confProto.Timeout = confToCreate.GetTimeout()
+			}
+			if confToCreate.ReadOnly != nil {
+				confProto.ReadOnly = confToCreate.ReadOnly
+			}
+			if confToCreate.GetAudience() != "" {
+				confProto.Audience = confToCreate.GetAudience()
+			}
+			if confToCreate.GetIssuer() != "" {
+				confProto.Issuer = confToCreate.GetIssuer()
+			}
+			if len(confToCreate.GetScopes()) > 0 {
+				confProto.Scopes = confToCreate.GetScopes()
+			}
+			found = true
+			confToCreate = confProto
+			break
+		}
+
+		if !found {
+			confProto.Configs = append(confProto.Configs, confToCreate)
+		}
+
+		nbiConfigTextProto, err := prototext.Marshal(confProto)
+		if err != nil {
+			return fmt.Errorf("unable to convert proto into textproto format: %w", err)
+		}
+
+		if err = writeFileAtomic(confFile, nbiConfigTextProto, 0o777); err != nil {
+			return fmt.Errorf("unable to update the configuration information: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	protoMessage, err := prototext.MarshalOptions{Multiline: true}.Marshal(confToCreate)
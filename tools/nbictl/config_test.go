@@ -15,6 +15,8 @@
 package nbictl
 
 import (
+	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
@@ -24,6 +26,7 @@ import (
 	"aalyria.com/spacetime/github/tools/nbictl/nbictlpb"
 	"github.com/bazelbuild/rules_go/go/tools/bazel"
 	"github.com/google/go-cmp/cmp"
+	"golang.org/x/sync/errgroup"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/testing/protocmp"
 )
@@ -44,7 +47,8 @@ var (
 		Url:     "update_url",
 	}
 	testConfigs = &nbictlpb.AppConfig{
-		Configs: []*nbictlpb.Config{testConfig},
+		SchemaVersion: configSchemaVersion,
+		Configs:       []*nbictlpb.Config{testConfig},
 	}
 )
 
@@ -172,7 +176,8 @@ func TestSetConfig_UpdatePrivateKey(t *testing.T) {
 	updatedContext := proto.Clone(testConfig).(*nbictlpb.Config)
 	updatedContext.PrivKey = "private_key.updated"
 	wantContexts := &nbictlpb.AppConfig{
-		Configs: []*nbictlpb.Config{updatedContext, testConfigForUpdate},
+		SchemaVersion: configSchemaVersion,
+		Configs:       []*nbictlpb.Config{updatedContext, testConfigForUpdate},
 	}
 	assertProtosEqual(t, wantContexts, gotContexts)
 }
@@ -200,7 +205,8 @@ func TestSetConfig_UpdateKeyId(t *testing.T) {
 	updatedContext := proto.Clone(testConfigForUpdate).(*nbictlpb.Config)
 	updatedContext.KeyId = "key_id.updated"
 	wantContexts := &nbictlpb.AppConfig{
-		Configs: []*nbictlpb.Config{testConfig, updatedContext},
+		SchemaVersion: configSchemaVersion,
+		Configs:       []*nbictlpb.Config{testConfig, updatedContext},
 	}
 	assertProtosEqual(t, wantContexts, gotContexts)
 }
@@ -228,7 +234,8 @@ func TestSetConfig_UpdateUserID(t *testing.T) {
 	updatedContext := proto.Clone(testConfig).(*nbictlpb.Config)
 	updatedContext.Email = "email.updated"
 	wantContexts := &nbictlpb.AppConfig{
-		Configs: []*nbictlpb.Config{updatedContext, testConfigForUpdate},
+		SchemaVersion: configSchemaVersion,
+		Configs:       []*nbictlpb.Config{updatedContext, testConfigForUpdate},
 	}
 	assertProtosEqual(t, wantContexts, gotContexts)
 }
@@ -254,11 +261,52 @@ func TestSetConfig_UpdateUrl(t *testing.T) {
 	updatedContext := proto.Clone(testConfig).(*nbictlpb.Config)
 	updatedContext.Url = "url.updated"
 	wantContexts := &nbictlpb.AppConfig{
-		Configs: []*nbictlpb.Config{updatedContext, testConfigForUpdate},
+		SchemaVersion: configSchemaVersion,
+		Configs:       []*nbictlpb.Config{updatedContext, testConfigForUpdate},
 	}
 	assertProtosEqual(t, wantContexts, gotContexts)
 }
 
+func TestSetConfig_Concurrent(t *testing.T) {
+	t.Parallel()
+
+	confDir, err := bazel.NewTmpDir("nbictl")
+	checkErr(t, err)
+	confFile := filepath.Join(confDir, confFileName)
+
+	const numWriters = 20
+	g := errgroup.Group{}
+	for i := 0; i < numWriters; i++ {
+		g.Go(func() error {
+			return setConfig(io.Discard, io.Discard, &nbictlpb.Config{Name: fmt.Sprintf("context_%d", i)}, confFile)
+		})
+	}
+	checkErr(t, g.Wait())
+
+	gotContexts, err := readConfigs(confFile)
+	checkErr(t, err)
+	if got := len(gotContexts.GetConfigs()); got != numWriters {
+		t.Fatalf("expected %d contexts after concurrent writes, got %d", numWriters, got)
+	}
+}
+
+func TestSetConfig_NoLocalState(t *testing.T) {
+	t.Parallel()
+
+	confDir, err := bazel.NewTmpDir("nbictl")
+	checkErr(t, err)
+
+	app := App()
+	app.Writer, app.ErrWriter = io.Discard, io.Discard
+	err = app.Run([]string{"nbictl", "--config_dir", confDir, "--no_local_state", "set-config", "--url", "example.com"})
+	if !errors.Is(err, errNoLocalState) {
+		t.Fatalf("expected set-config to fail with %v, got %v", errNoLocalState, err)
+	}
+	if _, err := os.Stat(filepath.Join(confDir, confFileName)); !os.IsNotExist(err) {
+		t.Fatalf("expected config file to not exist, got %v", err)
+	}
+}
+
 func checkErr(t *testing.T, err error) {
 	t.Helper()
 
@@ -274,3 +322,55 @@ func assertProtosEqual(t *testing.T, want, got interface{}) {
 		t.Fatalf("proto mismatch: (-want +got):\n%s", diff)
 	}
 }
+
+func TestDescribeConfig(t *testing.T) {
+	t.Parallel()
+
+	dir, err := bazel.NewTmpDir("nbictl")
+	checkErr(t, err)
+	keys := generateKeysForTesting(t, dir, "--org", exampleCertOrganization)
+	setConfig := func(context string, flags ...string) {
+		t.Helper()
+		checkErr(t, newTestApp().Run(append([]string{
+			"nbictl", "--config_dir", dir, "--context", context,
+			"set-config",
+			"--user_id", "usr1",
+			"--key_id", "key1",
+			"--priv_key", keys.key,
+			"--url", "spacetime.example.com:443",
+		}, flags...)))
+	}
+	setConfig("DEFAULT")
+	setConfig("custom", "--audience", "aud1", "--issuer", "https://issuer.example.com", "--scopes", "read", "--scopes", "write")
+
+	for _, tc := range []struct {
+		context string
+		want    []string
+	}{
+		{
+			context: "DEFAULT",
+			want: []string{
+				"auth strategy:  private_key",
+				"issuer:         usr1 (default: user_id)",
+				"audience:       spacetime.example.com:443 (default: host of url)",
+				"scopes:         (none)",
+			},
+		},
+		{
+			context: "custom",
+			want: []string{
+				"issuer:         https://issuer.example.com",
+				"audience:       aud1",
+				"scopes:         read write",
+			},
+		},
+	} {
+		app := newTestApp()
+		checkErr(t, app.Run([]string{"nbictl", "--config_dir", dir, "--context", tc.context, "describe-config"}))
+		for _, line := range tc.want {
+			if !strings.Contains(app.stdout.String(), line+"\n") {
+				t.Errorf("context %s: expected output to contain %q, got:\n%s", tc.context, line, app.stdout.String())
+			}
+		}
+	}
+}
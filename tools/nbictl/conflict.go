@@ -0,0 +1,188 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+)
+
+// diffContextLines is the number of unchanged lines shown around each change
+// in the diff of a ConflictError.
+const diffContextLines = 2
+
+// ConflictError is returned when an entity can't be updated or deleted
+// because it was modified since the commit timestamp the change was based
+// on.
+type ConflictError struct {
+	Type nbipb.EntityType
+	ID   string
+	// ExpectedCommitTimestamp is the commit timestamp the change was based
+	// on, and CurrentCommitTimestamp is that of the stored entity.
+	ExpectedCommitTimestamp, CurrentCommitTimestamp int64
+	// Diff is a line diff from the stored entity to the provided one, or ""
+	// if only the entity's ID was provided, as when deleting it.
+	Diff string
+	// Err is the error returned by the server.
+	Err error
+}
+
+func (e *ConflictError) Error() string {
+	msg := fmt.Sprintf("%s/%s was modified concurrently: the change was based on commit_timestamp %d, but the stored entity is at %d. Fetch the entity again and reapply the change, or use --force to overwrite the stored version",
+		e.Type, e.ID, e.ExpectedCommitTimestamp, e.CurrentCommitTimestamp)
+	if e.Diff != "" {
+		msg += "\n--- stored\n+++ provided\n" + e.Diff
+	}
+	return msg
+}
+
+func (e *ConflictError) Unwrap() error { return e.Err }
+
+// isConflict reports whether `err` is the server rejecting a change because
+// its commit timestamp doesn't match the stored entity's.
+func isConflict(err error) bool {
+	switch status.Code(err) {
+	case codes.FailedPrecondition, codes.Aborted:
+		return true
+	default:
+		return false
+	}
+}
+
+// storedCommitTimestamp returns the commit timestamp of the stored version of
+// `e`.
+func storedCommitTimestamp(ctx context.Context, client nbipb.NetOpsClient, e *nbipb.Entity) (int64, error) {
+	stored, err := client.GetEntity(ctx, &nbipb.GetEntityRequest{Type: e.GetGroup().GetType().Enum(), Id: proto.String(e.GetId())})
+	if err != nil {
+		return 0, err
+	}
+	return stored.GetCommitTimestamp(), nil
+}
+
+// withConflictDetails returns a ConflictError describing how the stored
+// entity differs from `provided` if `err` is a conflict, and `err` otherwise.
+// `expected` is the commit timestamp sent with the rejected request.
+func withConflictDetails(ctx context.Context, client nbipb.NetOpsClient, provided *nbipb.Entity, expected int64, err error) error {
+	if !isConflict(err) {
+		return err
+	}
+	stored, getErr := client.GetEntity(ctx, &nbipb.GetEntityRequest{Type: provided.GetGroup().GetType().Enum(), Id: proto.String(provided.GetId())})
+	if getErr != nil {
+		// The original error is still the more useful of the two.
+		return err
+	}
+
+	conflict := &ConflictError{
+		Type:                    provided.GetGroup().GetType(),
+		ID:                      provided.GetId(),
+		ExpectedCommitTimestamp: expected,
+		CurrentCommitTimestamp:  stored.GetCommitTimestamp(),
+		Err:                     err,
+	}
+	if provided.GetValue() != nil {
+		conflict.Diff = entityDiff(stored, provided)
+	}
+	return conflict
+}
+
+// entityDiff returns a line diff of the textproto representations of `a` and
+// `b`, ignoring the fields set by the server on every commit.
+func entityDiff(a, b *nbipb.Entity) string {
+	lines := func(e *nbipb.Entity) []string {
+		e = proto.Clone(e).(*nbipb.Entity)
+		e.CommitTimestamp, e.NextCommitTimestamp, e.LastModifiedBy = nil, nil, nil
+		txt, err := prototext.MarshalOptions{Multiline: true, Indent: "  "}.Marshal(e)
+		if err != nil {
+			return []string{fmt.Sprintf("<%v>", err)}
+		}
+		return strings.Split(strings.TrimSuffix(string(txt), "\n"), "\n")
+	}
+	return diffLines(lines(a), lines(b))
+}
+
+// diffLines returns the lines of `a` missing from `b` prefixed with "-", the
+// lines of `b` missing from `a` prefixed with "+", and up to
+// diffContextLines of the unchanged lines around them, based on their
+// longest common subsequence.
+func diffLines(a, b []string) string {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and
+	// b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	type line struct {
+		op   byte
+		text string
+	}
+	ops := []line{}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, line{' ', a[i]})
+			i, j = i+1, j+1
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, line{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, line{'+', b[j]})
+			j++
+		}
+	}
+
+	// Only keep the unchanged lines that are close to a change.
+	keep := make([]bool, len(ops))
+	for k, op := range ops {
+		if op.op == ' ' {
+			continue
+		}
+		for c := max(0, k-diffContextLines); c <= min(len(ops)-1, k+diffContextLines); c++ {
+			keep[c] = true
+		}
+	}
+	out := &strings.Builder{}
+	skipped := false
+	for k, op := range ops {
+		if !keep[k] {
+			skipped = true
+			continue
+		}
+		if skipped && out.Len() > 0 {
+			out.WriteString("  ...\n")
+		}
+		skipped = false
+		fmt.Fprintf(out, "%c %s\n", op.op, op.text)
+	}
+	return out.String()
+}
@@ -0,0 +1,224 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	commonpb "aalyria.com/spacetime/api/common"
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+)
+
+// versionedNetOpsClient stores entities by ID and enforces the consistency
+// checks of the NBI, bumping the commit timestamp of an entity on every
+// update.
+type versionedNetOpsClient struct {
+	nbipb.NetOpsClient
+
+	mu             sync.Mutex
+	stored         map[string]*nbipb.Entity
+	updateRequests []*nbipb.UpdateEntityRequest
+	deleteRequests []*nbipb.DeleteEntityRequest
+}
+
+func (c *versionedNetOpsClient) GetEntity(_ context.Context, req *nbipb.GetEntityRequest, _ ...grpc.CallOption) (*nbipb.Entity, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.stored[req.GetId()]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "not found")
+	}
+	return proto.Clone(e).(*nbipb.Entity), nil
+}
+
+func (c *versionedNetOpsClient) UpdateEntity(_ context.Context, req *nbipb.UpdateEntityRequest, _ ...grpc.CallOption) (*nbipb.Entity, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.updateRequests = append(c.updateRequests, req)
+	stored, ok := c.stored[req.GetEntity().GetId()]
+	if !req.GetIgnoreConsistencyCheck() && (!ok || stored.GetCommitTimestamp() != req.GetEntity().GetCommitTimestamp()) {
+		return nil, status.Error(codes.FailedPrecondition, "commit_timestamp mismatch")
+	}
+	e := proto.Clone(req.GetEntity()).(*nbipb.Entity)
+	e.CommitTimestamp = proto.Int64(stored.GetCommitTimestamp() + 1)
+	c.stored[e.GetId()] = e
+	return e, nil
+}
+
+func (c *versionedNetOpsClient) DeleteEntity(_ context.Context, req *nbipb.DeleteEntityRequest, _ ...grpc.CallOption) (*nbipb.DeleteEntityResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deleteRequests = append(c.deleteRequests, req)
+	stored, ok := c.stored[req.GetId()]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "not found")
+	}
+	if !req.GetIgnoreConsistencyCheck() && stored.GetCommitTimestamp() != req.GetLastCommitTimestamp() {
+		return nil, status.Error(codes.FailedPrecondition, "commit_timestamp mismatch")
+	}
+	delete(c.stored, req.GetId())
+	return &nbipb.DeleteEntityResponse{}, nil
+}
+
+func platformEntity(id, name string, commitTimestamp *int64) *nbipb.Entity {
+	return &nbipb.Entity{
+		Group:           &nbipb.EntityGroup{Type: nbipb.EntityType_PLATFORM_DEFINITION.Enum()},
+		Id:              proto.String(id),
+		CommitTimestamp: commitTimestamp,
+		Value:           &nbipb.Entity_Platform{Platform: &commonpb.PlatformDefinition{Name: proto.String(name)}},
+	}
+}
+
+func TestUpdateEntities_consistencyChecks(t *testing.T) {
+	t.Parallel()
+
+	client := &versionedNetOpsClient{stored: map[string]*nbipb.Entity{
+		"fetched": platformEntity("fetched", "old", proto.Int64(10)),
+		"stale":   platformEntity("stale", "concurrent edit", proto.Int64(20)),
+	}}
+	streams, _, _ := newTestStreams()
+	opts := UpdateOptions{Entities: []*nbipb.Entity{
+		platformEntity("fetched", "new", nil),
+		platformEntity("stale", "new", proto.Int64(19)),
+		platformEntity("missing", "new", nil),
+	}}
+
+	err := UpdateEntities(context.Background(), client, opts, streams)
+	if err == nil || !strings.HasPrefix(err.Error(), "1 of 3 entities failed:\nupdate failed for entity PLATFORM_DEFINITION/stale") {
+		t.Fatalf("expected only the stale entity to fail, got %v", err)
+	}
+	conflict := &ConflictError{}
+	if !errors.As(err, &conflict) {
+		t.Fatalf("expected a *ConflictError, got %v", err)
+	}
+	if conflict.ID != "stale" || conflict.ExpectedCommitTimestamp != 19 || conflict.CurrentCommitTimestamp != 20 {
+		t.Errorf("unexpected conflict: %+v", conflict)
+	}
+	if status.Code(conflict) != codes.FailedPrecondition {
+		t.Errorf("expected the conflict to wrap the server's error, got %v", conflict.Err)
+	}
+	for _, want := range []string{`- `, `"concurrent edit"`, `+ `, `"new"`, "--force"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected error to contain %q, got:\n%v", want, err)
+		}
+	}
+	if strings.Contains(conflict.Diff, "commit_timestamp") {
+		t.Errorf("expected the diff to ignore commit timestamps, got:\n%s", conflict.Diff)
+	}
+
+	for _, req := range client.updateRequests {
+		switch id := req.GetEntity().GetId(); id {
+		case "fetched":
+			if req.GetIgnoreConsistencyCheck() || req.GetEntity().GetCommitTimestamp() != 10 {
+				t.Errorf("expected the stored commit timestamp to be sent for %q, got %v", id, req)
+			}
+		case "missing":
+			if !req.GetIgnoreConsistencyCheck() {
+				t.Errorf("expected missing entities to be created, got %v", req)
+			}
+		}
+	}
+	if opts.Entities[0].CommitTimestamp != nil {
+		t.Errorf("expected the provided entities to be left unchanged")
+	}
+}
+
+func TestUpdateEntities_force(t *testing.T) {
+	t.Parallel()
+
+	client := &versionedNetOpsClient{stored: map[string]*nbipb.Entity{
+		"stale": platformEntity("stale", "concurrent edit", proto.Int64(20)),
+	}}
+	streams, _, _ := newTestStreams()
+	opts := UpdateOptions{Entities: []*nbipb.Entity{platformEntity("stale", "new", proto.Int64(19))}, Force: true}
+	checkErr(t, UpdateEntities(context.Background(), client, opts, streams))
+
+	if got := client.stored["stale"].GetPlatform().GetName(); got != "new" {
+		t.Errorf("expected the stored entity to be overwritten, got name %q", got)
+	}
+}
+
+func TestDeleteEntities_consistencyChecks(t *testing.T) {
+	t.Parallel()
+
+	client := &versionedNetOpsClient{stored: map[string]*nbipb.Entity{
+		"fetched": platformEntity("fetched", "old", proto.Int64(10)),
+		"stale":   platformEntity("stale", "concurrent edit", proto.Int64(20)),
+	}}
+	streams, _, _ := newTestStreams()
+	opts := DeleteOptions{Entities: []*nbipb.Entity{
+		platformEntity("fetched", "old", nil),
+		platformEntity("stale", "old", proto.Int64(19)),
+	}}
+
+	err := DeleteEntities(context.Background(), client, opts, streams)
+	conflict := &ConflictError{}
+	if !errors.As(err, &conflict) || conflict.ID != "stale" {
+		t.Fatalf("expected a conflict for the stale entity, got %v", err)
+	}
+	if _, ok := client.stored["fetched"]; ok {
+		t.Errorf("expected the entity without a commit timestamp to be deleted")
+	}
+	if _, ok := client.stored["stale"]; !ok {
+		t.Errorf("expected the stale entity to be kept")
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name string
+		a, b []string
+		want string
+	}{
+		{
+			name: "identical",
+			a:    []string{"a", "b"},
+			b:    []string{"a", "b"},
+			want: "",
+		},
+		{
+			name: "changed line",
+			a:    []string{"a", "b", "c"},
+			b:    []string{"a", "x", "c"},
+			want: "  a\n- b\n+ x\n  c\n",
+		},
+		{
+			name: "distant changes",
+			a:    []string{"1", "2", "3", "4", "5", "6", "7", "8"},
+			b:    []string{"0", "2", "3", "4", "5", "6", "7", "9"},
+			want: "- 1\n+ 0\n  2\n  3\n  ...\n  6\n  7\n- 8\n+ 9\n",
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := diffLines(tc.a, tc.b); got != tc.want {
+				t.Errorf("diffLines(%q, %q): want %q, got %q", tc.a, tc.b, tc.want, got)
+			}
+		})
+	}
+}
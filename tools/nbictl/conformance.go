@@ -0,0 +1,84 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+	"aalyria.com/spacetime/github/tools/nbictl/conformance"
+)
+
+func ConformanceList(appCtx *cli.Context) error {
+	w := tabwriter.NewWriter(appCtx.App.Writer, 0, 0, 2, ' ', 0)
+	for _, c := range conformance.Cases() {
+		fmt.Fprintf(w, "%s\t%s\n", c.Name, c.Description)
+	}
+	return w.Flush()
+}
+
+func ConformanceRun(appCtx *cli.Context) error {
+	opts := conformance.Options{IDPrefix: appCtx.String("id_prefix")}
+	if opts.IDPrefix == "" {
+		opts.IDPrefix = fmt.Sprintf("nbictl-conformance-%d-", time.Now().Unix())
+	}
+	if pattern := appCtx.String("run"); pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid --run pattern: %w", err)
+		}
+		opts.Run = re
+	}
+	if target := appCtx.String("target"); target != "" {
+		if err := appCtx.Set("context", target); err != nil {
+			return err
+		}
+	}
+
+	conn, err := openServiceConnection(appCtx, nbipb.NetOps_ServiceDesc.ServiceName)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	results := conformance.Run(appCtx.Context, nbipb.NewNetOpsClient(conn), opts)
+	if len(results) == 0 {
+		return fmt.Errorf("--run %q doesn't match any case", appCtx.String("run"))
+	}
+
+	failed := 0
+	for _, r := range results {
+		if r.Passed() {
+			fmt.Fprintf(appCtx.App.Writer, "PASS  %s (%s)\n", r.Case.Name, r.Duration.Round(time.Millisecond))
+			continue
+		}
+		failed++
+		fmt.Fprintf(appCtx.App.Writer, "FAIL  %s (%s)\n", r.Case.Name, r.Duration.Round(time.Millisecond))
+		for _, line := range strings.Split(r.Err.Error(), "\n") {
+			fmt.Fprintf(appCtx.App.Writer, "      %s\n", line)
+		}
+	}
+	fmt.Fprintf(appCtx.App.Writer, "%d passed, %d failed\n", len(results)-failed, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d conformance cases failed", failed, len(results))
+	}
+	return nil
+}
@@ -0,0 +1,422 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package conformance checks that an implementation of the NetOps service
+// behaves like Spacetime: the semantics of creating, reading, updating and
+// deleting entities, the commit timestamps that version them, and the
+// history returned by ListEntitiesOverTime. It runs against any
+// implementation, such as the fake in the nbitest package, a private fork,
+// or the hosted service, and is exposed by `nbictl conformance run`.
+//
+// The suite only creates NETWORK_NODE entities with IDs starting with
+// [Options.IDPrefix], and deletes them once each case completes.
+package conformance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	commonpb "aalyria.com/spacetime/api/common"
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+	respb "aalyria.com/spacetime/api/nbi/v1alpha/resources"
+)
+
+// Case is a single behavioral expectation.
+type Case struct {
+	Name        string
+	Description string
+
+	run func(context.Context, *env) error
+}
+
+// Options configures a run of the suite.
+type Options struct {
+	// IDPrefix is prepended to the IDs of the entities the suite creates, so
+	// runs don't collide with each other or with existing entities.
+	IDPrefix string
+	// Run, if set, limits the suite to the cases whose names it matches.
+	Run *regexp.Regexp
+}
+
+// Result is the outcome of a Case.
+type Result struct {
+	Case     Case
+	Err      error
+	Duration time.Duration
+}
+
+// Passed reports whether the case met its expectations.
+func (r Result) Passed() bool { return r.Err == nil }
+
+// Cases returns every case of the suite, in the order they run.
+func Cases() []Case {
+	return []Case{
+		{
+			Name:        "create_assigns_commit_timestamp",
+			Description: "CreateEntity returns the entity with its commit_timestamp set.",
+			run:         testCreateAssignsCommitTimestamp,
+		},
+		{
+			Name:        "create_existing_fails",
+			Description: "CreateEntity fails with AlreadyExists if the entity exists.",
+			run:         testCreateExistingFails,
+		},
+		{
+			Name:        "get_returns_current_version",
+			Description: "GetEntity returns the entity as it was last created or updated.",
+			run:         testGetReturnsCurrentVersion,
+		},
+		{
+			Name:        "get_missing_fails",
+			Description: "GetEntity fails with NotFound if the entity doesn't exist.",
+			run:         testGetMissingFails,
+		},
+		{
+			Name:        "get_requires_type",
+			Description: "GetEntity fails with InvalidArgument if the type is unspecified.",
+			run:         testGetRequiresType,
+		},
+		{
+			Name:        "update_checks_commit_timestamp",
+			Description: "UpdateEntity fails with FailedPrecondition unless the commit_timestamp is the current one, or the check is ignored.",
+			run:         testUpdateChecksCommitTimestamp,
+		},
+		{
+			Name:        "commit_timestamps_increase",
+			Description: "Each version of an entity has a later commit_timestamp than the one before.",
+			run:         testCommitTimestampsIncrease,
+		},
+		{
+			Name:        "delete_checks_commit_timestamp",
+			Description: "DeleteEntity fails with FailedPrecondition unless the last_commit_timestamp is the current one, and deleted entities can't be read.",
+			run:         testDeleteChecksCommitTimestamp,
+		},
+		{
+			Name:        "delete_missing_fails",
+			Description: "DeleteEntity fails with NotFound if the entity doesn't exist.",
+			run:         testDeleteMissingFails,
+		},
+		{
+			Name:        "list_includes_entities",
+			Description: "ListEntities returns the current version of every entity of the type.",
+			run:         testListIncludesEntities,
+		},
+		{
+			Name:        "list_over_time_returns_history",
+			Description: "ListEntitiesOverTime returns each version in the interval, with next_commit_timestamp set on superseded ones.",
+			run:         testListOverTimeReturnsHistory,
+		},
+	}
+}
+
+// Run runs the cases selected by `opts` against `client`, one at a time,
+// and returns their results.
+func Run(ctx context.Context, client nbipb.NetOpsClient, opts Options) []Result {
+	results := []Result{}
+	for _, c := range Cases() {
+		if opts.Run != nil && !opts.Run.MatchString(c.Name) {
+			continue
+		}
+		e := &env{client: client, prefix: fmt.Sprintf("%s%s-", opts.IDPrefix, c.Name)}
+		start := time.Now()
+		err := c.run(ctx, e)
+		if cleanupErr := e.cleanup(ctx); cleanupErr != nil {
+			err = errors.Join(err, fmt.Errorf("cleaning up: %w", cleanupErr))
+		}
+		results = append(results, Result{Case: c, Err: err, Duration: time.Since(start)})
+	}
+	return results
+}
+
+// env is the state of a running Case.
+type env struct {
+	client  nbipb.NetOpsClient
+	prefix  string
+	created []string
+}
+
+// node returns a new NETWORK_NODE entity with an ID unique to the case.
+func (e *env) node(suffix, name string) *nbipb.Entity {
+	return &nbipb.Entity{
+		Group: &nbipb.EntityGroup{Type: nbipb.EntityType_NETWORK_NODE.Enum()},
+		Id:    proto.String(e.prefix + suffix),
+		Value: &nbipb.Entity_NetworkNode{NetworkNode: &respb.NetworkNode{Name: proto.String(name)}},
+	}
+}
+
+// create creates `entity` and records it for cleanup.
+func (e *env) create(ctx context.Context, entity *nbipb.Entity) (*nbipb.Entity, error) {
+	created, err := e.client.CreateEntity(ctx, &nbipb.CreateEntityRequest{Entity: entity})
+	if err != nil {
+		return nil, fmt.Errorf("CreateEntity(%s): %w", entity.GetId(), err)
+	}
+	e.created = append(e.created, entity.GetId())
+	return created, nil
+}
+
+func (e *env) update(ctx context.Context, entity *nbipb.Entity) (*nbipb.Entity, error) {
+	updated, err := e.client.UpdateEntity(ctx, &nbipb.UpdateEntityRequest{Entity: entity})
+	if err != nil {
+		return nil, fmt.Errorf("UpdateEntity(%s): %w", entity.GetId(), err)
+	}
+	return updated, nil
+}
+
+// cleanup deletes the entities the case created that still exist.
+func (e *env) cleanup(ctx context.Context) error {
+	errs := []error{}
+	for _, id := range e.created {
+		_, err := e.client.DeleteEntity(ctx, &nbipb.DeleteEntityRequest{
+			Type:                   nbipb.EntityType_NETWORK_NODE.Enum(),
+			Id:                     proto.String(id),
+			IgnoreConsistencyCheck: proto.Bool(true),
+		})
+		if err != nil && status.Code(err) != codes.NotFound {
+			errs = append(errs, fmt.Errorf("deleting %s: %w", id, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// expectCode returns an error unless `err` has the status code `want`.
+func expectCode(rpc string, err error, want codes.Code) error {
+	if got := status.Code(err); got != want {
+		return fmt.Errorf("%s: got %v (%v), want %v", rpc, got, err, want)
+	}
+	return nil
+}
+
+// expectSameValue returns an error unless `got` has the ID, type and value
+// of `want`. Other fields, like last_modified_by, are set by the server.
+func expectSameValue(rpc string, got, want *nbipb.Entity) error {
+	switch {
+	case got.GetId() != want.GetId():
+		return fmt.Errorf("%s: got ID %q, want %q", rpc, got.GetId(), want.GetId())
+	case got.GetGroup().GetType() != want.GetGroup().GetType():
+		return fmt.Errorf("%s: got type %v, want %v", rpc, got.GetGroup().GetType(), want.GetGroup().GetType())
+	case !proto.Equal(got.GetNetworkNode(), want.GetNetworkNode()):
+		return fmt.Errorf("%s: got value %v, want %v", rpc, got.GetNetworkNode(), want.GetNetworkNode())
+	}
+	return nil
+}
+
+func testCreateAssignsCommitTimestamp(ctx context.Context, e *env) error {
+	want := e.node("a", "created")
+	got, err := e.create(ctx, want)
+	if err != nil {
+		return err
+	}
+	if got.GetCommitTimestamp() <= 0 {
+		return fmt.Errorf("CreateEntity: got commit_timestamp %d, want a positive one", got.GetCommitTimestamp())
+	}
+	return expectSameValue("CreateEntity", got, want)
+}
+
+func testCreateExistingFails(ctx context.Context, e *env) error {
+	if _, err := e.create(ctx, e.node("a", "first")); err != nil {
+		return err
+	}
+	_, err := e.client.CreateEntity(ctx, &nbipb.CreateEntityRequest{Entity: e.node("a", "second")})
+	return expectCode("CreateEntity of an existing entity", err, codes.AlreadyExists)
+}
+
+func testGetReturnsCurrentVersion(ctx context.Context, e *env) error {
+	created, err := e.create(ctx, e.node("a", "first"))
+	if err != nil {
+		return err
+	}
+	want := e.node("a", "second")
+	want.CommitTimestamp = created.CommitTimestamp
+	updated, err := e.update(ctx, want)
+	if err != nil {
+		return err
+	}
+
+	got, err := e.client.GetEntity(ctx, &nbipb.GetEntityRequest{Type: want.GetGroup().GetType().Enum(), Id: want.Id})
+	if err != nil {
+		return fmt.Errorf("GetEntity: %w", err)
+	}
+	if got.GetCommitTimestamp() != updated.GetCommitTimestamp() {
+		return fmt.Errorf("GetEntity: got commit_timestamp %d, want %d", got.GetCommitTimestamp(), updated.GetCommitTimestamp())
+	}
+	return expectSameValue("GetEntity", got, want)
+}
+
+func testGetMissingFails(ctx context.Context, e *env) error {
+	_, err := e.client.GetEntity(ctx, &nbipb.GetEntityRequest{
+		Type: nbipb.EntityType_NETWORK_NODE.Enum(),
+		Id:   proto.String(e.prefix + "missing"),
+	})
+	return expectCode("GetEntity of a missing entity", err, codes.NotFound)
+}
+
+func testGetRequiresType(ctx context.Context, e *env) error {
+	_, err := e.client.GetEntity(ctx, &nbipb.GetEntityRequest{Id: proto.String(e.prefix + "a")})
+	return expectCode("GetEntity without a type", err, codes.InvalidArgument)
+}
+
+func testUpdateChecksCommitTimestamp(ctx context.Context, e *env) error {
+	created, err := e.create(ctx, e.node("a", "first"))
+	if err != nil {
+		return err
+	}
+
+	stale := e.node("a", "stale")
+	stale.CommitTimestamp = proto.Int64(created.GetCommitTimestamp() - 1)
+	_, err = e.client.UpdateEntity(ctx, &nbipb.UpdateEntityRequest{Entity: stale})
+	if err := expectCode("UpdateEntity with a stale commit_timestamp", err, codes.FailedPrecondition); err != nil {
+		return err
+	}
+
+	current := e.node("a", "current")
+	current.CommitTimestamp = created.CommitTimestamp
+	if _, err := e.update(ctx, current); err != nil {
+		return err
+	}
+
+	_, err = e.client.UpdateEntity(ctx, &nbipb.UpdateEntityRequest{Entity: stale, IgnoreConsistencyCheck: proto.Bool(true)})
+	if err != nil {
+		return fmt.Errorf("UpdateEntity ignoring the consistency check: %w", err)
+	}
+	return nil
+}
+
+func testCommitTimestampsIncrease(ctx context.Context, e *env) error {
+	latest, err := e.create(ctx, e.node("a", "version-0"))
+	if err != nil {
+		return err
+	}
+	for i := 1; i <= 5; i++ {
+		next := e.node("a", fmt.Sprintf("version-%d", i))
+		next.CommitTimestamp = latest.CommitTimestamp
+		updated, err := e.update(ctx, next)
+		if err != nil {
+			return err
+		}
+		if updated.GetCommitTimestamp() <= latest.GetCommitTimestamp() {
+			return fmt.Errorf("UpdateEntity: got commit_timestamp %d, want one later than the previous version's (%d)", updated.GetCommitTimestamp(), latest.GetCommitTimestamp())
+		}
+		latest = updated
+	}
+	return nil
+}
+
+func testDeleteChecksCommitTimestamp(ctx context.Context, e *env) error {
+	created, err := e.create(ctx, e.node("a", "first"))
+	if err != nil {
+		return err
+	}
+	req := &nbipb.DeleteEntityRequest{
+		Type:                created.GetGroup().GetType().Enum(),
+		Id:                  created.Id,
+		LastCommitTimestamp: proto.Int64(created.GetCommitTimestamp() - 1),
+	}
+	_, err = e.client.DeleteEntity(ctx, req)
+	if err := expectCode("DeleteEntity with a stale last_commit_timestamp", err, codes.FailedPrecondition); err != nil {
+		return err
+	}
+
+	req.LastCommitTimestamp = created.CommitTimestamp
+	if _, err := e.client.DeleteEntity(ctx, req); err != nil {
+		return fmt.Errorf("DeleteEntity: %w", err)
+	}
+	_, err = e.client.GetEntity(ctx, &nbipb.GetEntityRequest{Type: req.Type, Id: req.Id})
+	return expectCode("GetEntity of a deleted entity", err, codes.NotFound)
+}
+
+func testDeleteMissingFails(ctx context.Context, e *env) error {
+	_, err := e.client.DeleteEntity(ctx, &nbipb.DeleteEntityRequest{
+		Type:                   nbipb.EntityType_NETWORK_NODE.Enum(),
+		Id:                     proto.String(e.prefix + "missing"),
+		IgnoreConsistencyCheck: proto.Bool(true),
+	})
+	return expectCode("DeleteEntity of a missing entity", err, codes.NotFound)
+}
+
+func testListIncludesEntities(ctx context.Context, e *env) error {
+	want := map[string]int64{}
+	for _, suffix := range []string{"a", "b", "c"} {
+		created, err := e.create(ctx, e.node(suffix, suffix))
+		if err != nil {
+			return err
+		}
+		want[created.GetId()] = created.GetCommitTimestamp()
+	}
+
+	rsp, err := e.client.ListEntities(ctx, &nbipb.ListEntitiesRequest{Type: nbipb.EntityType_NETWORK_NODE.Enum()})
+	if err != nil {
+		return fmt.Errorf("ListEntities: %w", err)
+	}
+	for _, got := range rsp.GetEntities() {
+		ts, ok := want[got.GetId()]
+		if !ok {
+			continue
+		}
+		if got.GetCommitTimestamp() != ts {
+			return fmt.Errorf("ListEntities: got commit_timestamp %d for %s, want %d", got.GetCommitTimestamp(), got.GetId(), ts)
+		}
+		delete(want, got.GetId())
+	}
+	if len(want) > 0 {
+		return fmt.Errorf("ListEntities: missing %d of the 3 entities created", len(want))
+	}
+	return nil
+}
+
+func testListOverTimeReturnsHistory(ctx context.Context, e *env) error {
+	created, err := e.create(ctx, e.node("a", "first"))
+	if err != nil {
+		return err
+	}
+	second := e.node("a", "second")
+	second.CommitTimestamp = created.CommitTimestamp
+	updated, err := e.update(ctx, second)
+	if err != nil {
+		return err
+	}
+
+	rsp, err := e.client.ListEntitiesOverTime(ctx, &nbipb.ListEntitiesOverTimeRequest{
+		Type: nbipb.EntityType_NETWORK_NODE.Enum(),
+		Ids:  []string{created.GetId()},
+		Interval: &commonpb.TimeInterval{
+			StartTime: &commonpb.DateTime{UnixTimeUsec: proto.Int64(created.GetCommitTimestamp())},
+			EndTime:   &commonpb.DateTime{UnixTimeUsec: proto.Int64(updated.GetCommitTimestamp() + 1)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("ListEntitiesOverTime: %w", err)
+	}
+
+	versions := rsp.GetEntities()
+	if len(versions) != 2 {
+		return fmt.Errorf("ListEntitiesOverTime: got %d versions, want 2", len(versions))
+	}
+	if got, want := versions[0].GetCommitTimestamp(), created.GetCommitTimestamp(); got != want {
+		return fmt.Errorf("ListEntitiesOverTime: got commit_timestamp %d for the first version, want %d", got, want)
+	}
+	if got, want := versions[0].GetNextCommitTimestamp(), updated.GetCommitTimestamp(); got != want {
+		return fmt.Errorf("ListEntitiesOverTime: got next_commit_timestamp %d for the first version, want %d", got, want)
+	}
+	if got, want := versions[1].GetCommitTimestamp(), updated.GetCommitTimestamp(); got != want {
+		return fmt.Errorf("ListEntitiesOverTime: got commit_timestamp %d for the second version, want %d", got, want)
+	}
+	return expectSameValue("ListEntitiesOverTime", versions[1], second)
+}
@@ -0,0 +1,100 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conformance
+
+import (
+	"context"
+	"net"
+	"regexp"
+	"testing"
+
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+	"aalyria.com/spacetime/github/tools/nbictl/nbitest"
+)
+
+func startFake(t *testing.T) (*nbitest.Server, nbipb.NetOpsClient) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	g, ctx := errgroup.WithContext(ctx)
+	t.Cleanup(func() {
+		cancel()
+		if err := g.Wait(); err != nil {
+			t.Errorf("serving: %v", err)
+		}
+	})
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := nbitest.New()
+	g.Go(func() error { return srv.Serve(ctx, lis) })
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return srv, nbipb.NewNetOpsClient(conn)
+}
+
+func TestRun_fakeConforms(t *testing.T) {
+	t.Parallel()
+
+	srv, client := startFake(t)
+	results := Run(context.Background(), client, Options{IDPrefix: "test-"})
+	if got, want := len(results), len(Cases()); got != want {
+		t.Errorf("got %d results, want %d", got, want)
+	}
+	for _, r := range results {
+		if !r.Passed() {
+			t.Errorf("%s: %v", r.Case.Name, r.Err)
+		}
+	}
+
+	rsp, err := srv.ListEntities(context.Background(), &nbipb.ListEntitiesRequest{Type: nbipb.EntityType_NETWORK_NODE.Enum()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(rsp.GetEntities()); n != 0 {
+		t.Errorf("expected the suite to delete the entities it created, %d remain", n)
+	}
+}
+
+func TestRun_reportsFailures(t *testing.T) {
+	t.Parallel()
+
+	srv, client := startFake(t)
+	srv.InjectFault("GetEntity", nbitest.Fault{Err: status.Error(codes.Unavailable, "injected")})
+	results := Run(context.Background(), client, Options{
+		IDPrefix: "test-",
+		Run:      regexp.MustCompile(`^get_`),
+	})
+	if len(results) != 3 {
+		t.Fatalf("expected only the 3 get_ cases to run, got %d", len(results))
+	}
+	for _, r := range results {
+		if r.Passed() {
+			t.Errorf("%s: expected the case to fail while GetEntity is unavailable", r.Case.Name)
+		}
+	}
+}
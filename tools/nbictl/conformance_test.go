@@ -0,0 +1,43 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"strings"
+	"testing"
+
+	"aalyria.com/spacetime/github/tools/nbictl/conformance"
+)
+
+func TestConformanceList(t *testing.T) {
+	t.Parallel()
+
+	app := newTestApp()
+	checkErr(t, app.Run([]string{"nbictl", "conformance", "list"}))
+	for _, c := range conformance.Cases() {
+		if !strings.Contains(app.stdout.String(), c.Name) {
+			t.Errorf("expected the output to list %s, got:\n%s", c.Name, app.stdout.String())
+		}
+	}
+}
+
+func TestConformanceRun_rejectsInvalidPattern(t *testing.T) {
+	t.Parallel()
+
+	err := newTestApp().Run([]string{"nbictl", "conformance", "run", "--run", "("})
+	if want := "invalid --run pattern"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("expected error to contain %q, got %v", want, err)
+	}
+}
@@ -21,44 +21,116 @@ import (
 	"crypto/x509"
 	"errors"
 	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/jonboulle/clockwork"
 	"github.com/urfave/cli/v2"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/credentials/local"
 	"google.golang.org/grpc/encoding/gzip"
 	_ "google.golang.org/grpc/encoding/gzip" // Install the gzip compressor
 
 	"aalyria.com/spacetime/auth"
+	"aalyria.com/spacetime/auth/awskms"
+	"aalyria.com/spacetime/auth/gcpkms"
+	"aalyria.com/spacetime/auth/pkcs11"
+	"aalyria.com/spacetime/auth/vault"
+	"aalyria.com/spacetime/dialproxy"
 	"aalyria.com/spacetime/github/tools/nbictl/nbictlpb"
+	"aalyria.com/spacetime/inproc"
+	"aalyria.com/spacetime/rpclog"
 )
 
-func openConnection(appCtx *cli.Context) (*grpc.ClientConn, error) {
+// defaultImpersonationSourceTokenEnvVar is the environment variable that
+// holds the caller's access token when impersonating a service account. It
+// matches the variable used by other Google Cloud tooling.
+const defaultImpersonationSourceTokenEnvVar = "GOOGLE_OAUTH_ACCESS_TOKEN"
+
+// clientConn is a connection opened by [openConnection]. Callers must close
+// it once they're done with it.
+type clientConn interface {
+	grpc.ClientConnInterface
+	Close() error
+}
+
+func openConnection(appCtx *cli.Context) (clientConn, error) {
 	ctxName := appCtx.String("context")
 
 	appConfDir, err := getAppConfDir(appCtx)
 	if err != nil {
 		return nil, err
 	}
-	setting, err := readConfig(ctxName, filepath.Join(appConfDir, confFileName))
+	confFile := filepath.Join(appConfDir, confFileName)
+
+	open := func(ctx context.Context) (*grpc.ClientConn, error) {
+		setting, err := readConfig(ctxName, confFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to obtain context information: %w", err)
+		}
+		moduleLogger(ctx, logModuleConnection).Debug("opening connection", "context", setting.GetName(), "url", setting.GetUrl())
+		logOpts, err := grpcLogDialOpts(ctx, appCtx)
+		if err != nil {
+			return nil, err
+		}
+		return dial(ctx, setting, nil, slices.Concat(readOnlyDialOpts(appCtx, setting), retryDialOpts(appCtx), compressionDialOpts(appCtx), callInfoDialOpts(), logOpts)...)
+	}
+
+	if cache := connectionCacheFromContext(appCtx.Context); cache != nil {
+		return cache.get(strings.Join([]string{confFile, ctxName, appCtx.String("grpc_log"), appCtx.String(compressionFlag.Name), strconv.FormatBool(appCtx.Bool(readOnlyFlag.Name)), strconv.Itoa(appCtx.Int(retriesFlag.Name)), strconv.FormatBool(appCtx.Bool(retryMutationsFlag.Name))}, "\x00"), open)
+	}
+	return open(appCtx.Context)
+}
+
+// Dial connects to the NBI with the settings of the context (configuration
+// profile) named `contextName` in the nbictl configuration directory
+// `configDir`, so that other tools built on the NBI Go client, such as the
+// Terraform provider, can reuse nbictl's configuration and credentials. An
+// empty `configDir` selects nbictl's default directory, and an empty
+// `contextName` the only context it defines.
+//
+// Calls are refused if the context is read-only, retried when the server is
+// unavailable if they don't modify state, and sent with the callctx.Info of
+// their context, like those nbictl makes. `opts` are applied after nbictl's own dial options.
+func Dial(ctx context.Context, configDir, contextName string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	if configDir == "" {
+		confDir, err := os.UserConfigDir()
+		if err != nil {
+			return nil, fmt.Errorf("unable to obtain the default config directory: %w", err)
+		}
+		configDir = filepath.Join(confDir, appName)
+	}
+	setting, err := readConfig(contextName, filepath.Join(configDir, confFileName))
 	if err != nil {
 		return nil, fmt.Errorf("unable to obtain context information: %w", err)
 	}
-	return dial(appCtx.Context, setting, nil)
+
+	dialOpts := []grpc.DialOption{}
+	if setting.GetReadOnly() {
+		dialOpts = append(dialOpts,
+			grpc.WithChainUnaryInterceptor(readOnlyUnaryInterceptor),
+			grpc.WithChainStreamInterceptor(readOnlyStreamInterceptor))
+	}
+	dialOpts = append(dialOpts, grpc.WithChainUnaryInterceptor(retryUnaryInterceptor(defaultRetries, false, retryBackoff)))
+	return dial(ctx, setting, nil, slices.Concat(dialOpts, callInfoDialOpts(), opts)...)
 }
 
-func dial(ctx context.Context, setting *nbictlpb.Config, httpClient *http.Client) (*grpc.ClientConn, error) {
+func dial(ctx context.Context, setting *nbictlpb.Config, httpClient *http.Client, extraOpts ...grpc.DialOption) (*grpc.ClientConn, error) {
 	dialOpts, err := getDialOpts(ctx, setting, httpClient)
 	if err != nil {
 		return nil, fmt.Errorf("unable to construct dial options: %w", err)
 	}
-	conn, err := grpc.NewClient(setting.GetUrl(), dialOpts...)
+	conn, err := grpc.NewClient(setting.GetUrl(), append(dialOpts, extraOpts...)...)
 	if err != nil {
 		return nil, fmt.Errorf("unable to connect to the server: %w", err)
 	}
@@ -68,12 +140,50 @@ func dial(ctx context.Context, setting *nbictlpb.Config, httpClient *http.Client
 func getDialOpts(ctx context.Context, setting *nbictlpb.Config, httpClient *http.Client) ([]grpc.DialOption, error) {
 	dialOpts := []grpc.DialOption{
 		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(1024*1024*256), grpc.UseCompressor(gzip.Name)),
+		grpc.WithChainUnaryInterceptor(unimplementedInterceptor),
+	}
+
+	// Servers in the same process are connected to through their in-memory
+	// listener rather than the network.
+	inProcess := strings.HasPrefix(setting.GetUrl(), inproc.Scheme+":")
+	if inProcess {
+		dialOpts = append(dialOpts, inproc.DialOptions()...)
+	}
+
+	proxyConf := dialproxy.Config{URL: setting.GetProxyUrl()}
+	if !inProcess {
+		proxyOpts, err := dialproxy.DialOptions(proxyConf)
+		if err != nil {
+			return nil, fmt.Errorf("configuring the proxy: %w", err)
+		}
+		dialOpts = append(dialOpts, proxyOpts...)
+	}
+	// Tokens are requested through the same proxy as the RPCs. Without an
+	// explicit proxy, the default client already honors the environment.
+	if httpClient == nil && setting.GetProxyUrl() != "" {
+		proxy, err := dialproxy.HTTPProxy(proxyConf)
+		if err != nil {
+			return nil, fmt.Errorf("configuring the proxy: %w", err)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = proxy
+		httpClient = &http.Client{Transport: transport}
 	}
 
-	switch t := setting.GetTransportSecurity().GetType().(type) {
+	transportSecurity := setting.GetTransportSecurity().GetType()
+	if transportSecurity == nil && (inProcess || strings.HasPrefix(setting.GetUrl(), "unix:")) {
+		transportSecurity = &nbictlpb.Config_TransportSecurity_Local{}
+	}
+	switch t := transportSecurity.(type) {
 	case *nbictlpb.Config_TransportSecurity_Insecure:
 		dialOpts = append(dialOpts, grpc.WithTransportCredentials(insecure.NewCredentials()))
 
+	case *nbictlpb.Config_TransportSecurity_Local:
+		// The in-process dial options already include their credentials.
+		if !inProcess {
+			dialOpts = append(dialOpts, grpc.WithTransportCredentials(local.NewCredentials()))
+		}
+
 	case *nbictlpb.Config_TransportSecurity_ServerCertificate_:
 		clientTLSFromFile, err := credentials.NewClientTLSFromFile(t.ServerCertificate.GetCertFilePath(), "")
 		if err != nil {
@@ -81,6 +191,28 @@ func getDialOpts(ctx context.Context, setting *nbictlpb.Config, httpClient *http
 		}
 		dialOpts = append(dialOpts, grpc.WithTransportCredentials(clientTLSFromFile))
 
+	case *nbictlpb.Config_TransportSecurity_MutualTls_:
+		creds, err := auth.NewMutualTLSCredentials(auth.MutualTLSConfig{
+			ClientCertFile: t.MutualTls.GetClientCertFilePath(),
+			ClientKeyFile:  t.MutualTls.GetClientKeyFilePath(),
+			CABundleFile:   t.MutualTls.GetCaBundleFilePath(),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("creating mutual TLS credentials: %w", err)
+		}
+		dialOpts = append(dialOpts, grpc.WithTransportCredentials(creds))
+
+	case *nbictlpb.Config_TransportSecurity_Spiffe_:
+		creds, closer, err := auth.NewSPIFFECredentials(ctx, auth.SPIFFEConfig{
+			WorkloadAPIAddr: t.Spiffe.GetWorkloadApiAddr(),
+			ServerID:        t.Spiffe.GetServerId(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		context.AfterFunc(ctx, func() { closer.Close() })
+		dialOpts = append(dialOpts, grpc.WithTransportCredentials(creds))
+
 	// SystemCertPoll is the default option in case transport_security is not set (nil).
 	case nil, *nbictlpb.Config_TransportSecurity_SystemCertPool:
 		cp, err := x509.SystemCertPool()
@@ -95,36 +227,298 @@ func getDialOpts(ctx context.Context, setting *nbictlpb.Config, httpClient *http
 
 	// Unless transport-security is set to Insecure, add Spacetime PerRPCCredentials.
 	if _, insecure := setting.GetTransportSecurity().GetType().(*nbictlpb.Config_TransportSecurity_Insecure); !insecure {
-		uri, err := url.Parse(setting.GetUrl())
+		creds, err := newPerRPCCredentials(ctx, setting, httpClient)
 		if err != nil {
-			return nil, fmt.Errorf("parsing %q: %w", setting.GetUrl(), err)
+			return nil, err
 		}
-		if setting.GetPrivKey() == "" {
-			return nil, errors.New("no private key set for chosen context")
+		dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(creds))
+	}
+
+	return dialOpts, nil
+}
+
+// grpcLogDialOpts returns the dial options that install the rpclog
+// interceptors if the `--grpc_log` flag is set. The interceptors are added
+// after the others so they observe the final outcome of each call.
+func grpcLogDialOpts(ctx context.Context, appCtx *cli.Context) ([]grpc.DialOption, error) {
+	path := appCtx.String("grpc_log")
+	if path == "" {
+		return nil, nil
+	}
+
+	w := appCtx.App.ErrWriter
+	if path != "-" {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open gRPC log file: %w", err)
 		}
-		pkeyBytes, err := os.ReadFile(setting.GetPrivKey())
+		context.AfterFunc(ctx, func() { f.Close() })
+		w = f
+	}
+
+	opts := rpclog.Options{Logger: slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: slog.LevelDebug}))}
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(rpclog.UnaryClientInterceptor(opts)),
+		grpc.WithChainStreamInterceptor(rpclog.StreamClientInterceptor(opts)),
+	}, nil
+}
+
+func newPerRPCCredentials(ctx context.Context, setting *nbictlpb.Config, httpClient *http.Client) (credentials.PerRPCCredentials, error) {
+	host, err := tokenAudience(setting)
+	if err != nil {
+		return nil, err
+	}
+	clock := clockwork.NewRealClock()
+
+	switch s := setting.GetAuthStrategy().GetType().(type) {
+	case *nbictlpb.Config_AuthStrategy_ClientCredentials_:
+		cc := s.ClientCredentials
+		if cc.GetClientSecretFile() == "" {
+			return nil, errors.New("no client secret file set for chosen context")
+		}
+		secret, err := os.ReadFile(cc.GetClientSecretFile())
 		if err != nil {
-			return nil, fmt.Errorf("unable to read the file: %w", err)
+			return nil, fmt.Errorf("unable to read the client secret file: %w", err)
 		}
-		privateKey := bytes.NewBuffer(pkeyBytes)
-		clock := clockwork.NewRealClock()
 
-		config := auth.Config{
+		scopes := cc.GetScopes()
+		if len(scopes) == 0 {
+			scopes = setting.GetScopes()
+		}
+		ts, err := auth.NewClientCredentialsTokenSource(ctx, auth.ClientCredentialsConfig{
 			Client:       httpClient,
 			Clock:        clock,
-			PrivateKey:   privateKey,
-			PrivateKeyID: setting.GetKeyId(),
-			Email:        setting.GetEmail(),
-			Host:         strings.TrimPrefix(cmp.Or(uri.Host, uri.Path), "/"),
+			TokenURL:     cc.GetTokenUrl(),
+			Issuer:       setting.GetIssuer(),
+			ClientID:     cc.GetClientId(),
+			ClientSecret: strings.TrimSpace(string(secret)),
+			Scopes:       scopes,
+			Audience:     cmp.Or(cc.GetAudience(), setting.GetAudience()),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to get client credentials token: %w", err)
+		}
+		return auth.NewTokenSourceCredentials(ts, nil), nil
+
+	case *nbictlpb.Config_AuthStrategy_ServiceAccountImpersonation_:
+		sai := s.ServiceAccountImpersonation
+		ts, err := auth.NewImpersonationTokenSource(ctx, auth.ImpersonationConfig{
+			Client:               httpClient,
+			Clock:                clock,
+			SourceToken:          auth.NewEnvTokenSource(cmp.Or(sai.GetSourceTokenEnvVar(), defaultImpersonationSourceTokenEnvVar)),
+			TargetServiceAccount: sai.GetTargetServiceAccount(),
+			Audience:             cmp.Or(sai.GetAudience(), host),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to impersonate service account: %w", err)
+		}
+		return auth.NewTokenSourceCredentials(ts, nil), nil
+
+	case *nbictlpb.Config_AuthStrategy_StaticToken_:
+		if s.StaticToken.GetEnvVar() == "" {
+			return nil, errors.New("no environment variable set for the static token")
+		}
+		ts := auth.NewEnvTokenSource(s.StaticToken.GetEnvVar())
+		// Fail early rather than on the first RPC if the token isn't available.
+		if _, err := ts.Token(ctx); err != nil {
+			return nil, err
 		}
+		return auth.NewTokenSourceCredentials(ts, nil), nil
+
+	default:
+		config, release, err := signingConfig(ctx, setting, httpClient, clock, host)
+		if err != nil {
+			return nil, err
+		}
+		// The key is needed to refresh tokens for as long as the connection
+		// is open.
+		context.AfterFunc(ctx, release)
 
 		creds, err := auth.NewCredentials(ctx, config)
 		if err != nil {
 			return nil, fmt.Errorf("unable to get new credentials with provided information: %w", err)
 		}
+		return creds, nil
+	}
+}
 
-		dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(creds))
+// tokenAudience returns the audience of the tokens that authenticate with
+// the server of `setting`: its configured audience, or else its host.
+func tokenAudience(setting *nbictlpb.Config) (string, error) {
+	if setting.GetAudience() != "" {
+		return setting.GetAudience(), nil
+	}
+	// URLs are usually given as host:port, which url.Parse would take for
+	// a scheme and an opaque path.
+	if _, port, err := net.SplitHostPort(setting.GetUrl()); err == nil && !strings.Contains(setting.GetUrl(), "/") {
+		if _, err := strconv.ParseUint(port, 10, 16); err == nil {
+			return setting.GetUrl(), nil
+		}
+	}
+	uri, err := url.Parse(setting.GetUrl())
+	if err != nil {
+		return "", fmt.Errorf("parsing %q: %w", setting.GetUrl(), err)
+	}
+	return strings.TrimPrefix(cmp.Or(uri.Host, uri.Path), "/"), nil
+}
+
+// signingConfig returns the config of the tokens that `setting` signs with
+// its signing key, for the auth strategies that use one, and a function
+// that releases the key once it's no longer needed.
+func signingConfig(ctx context.Context, setting *nbictlpb.Config, httpClient *http.Client, clock clockwork.Clock, host string) (auth.Config, func(), error) {
+	config := auth.Config{
+		Client:       httpClient,
+		Clock:        clock,
+		PrivateKeyID: setting.GetKeyId(),
+		Email:        setting.GetEmail(),
+		Host:         host,
+		Issuer:       setting.GetIssuer(),
+		Scopes:       setting.GetScopes(),
 	}
+	release := func() {}
 
-	return dialOpts, nil
+	switch s := setting.GetAuthStrategy().GetType().(type) {
+	// Private key signing is the default option in case auth_strategy is not set (nil).
+	case nil, *nbictlpb.Config_AuthStrategy_PrivateKey:
+		if setting.GetPrivKey() == "" {
+			return auth.Config{}, nil, errors.New("no private key set for chosen context")
+		}
+		pkeyBytes, err := os.ReadFile(setting.GetPrivKey())
+		if err != nil {
+			return auth.Config{}, nil, fmt.Errorf("unable to read the file: %w", err)
+		}
+		config.PrivateKey = bytes.NewBuffer(pkeyBytes)
+
+	case *nbictlpb.Config_AuthStrategy_Pkcs11_:
+		p := s.Pkcs11
+		pkcs11Conf := pkcs11.Config{
+			ModulePath: p.GetModulePath(),
+			TokenLabel: p.GetTokenLabel(),
+			KeyLabel:   p.GetKeyLabel(),
+			KeyID:      p.GetKeyId(),
+			PINEnvVar:  p.GetPinEnvVar(),
+		}
+		if p.Slot != nil {
+			slot := int(p.GetSlot())
+			pkcs11Conf.Slot = &slot
+		}
+		signer, err := pkcs11.NewSigner(pkcs11Conf)
+		if err != nil {
+			return auth.Config{}, nil, fmt.Errorf("unable to open the PKCS #11 signing key: %w", err)
+		}
+		config.Signer = signer
+		release = func() { signer.Close() }
+
+	case *nbictlpb.Config_AuthStrategy_AwsKms_:
+		signer, err := awskms.NewSigner(ctx, awskms.Config{
+			KeyID:  s.AwsKms.GetKeyId(),
+			Region: s.AwsKms.GetRegion(),
+		})
+		if err != nil {
+			return auth.Config{}, nil, fmt.Errorf("unable to use the AWS KMS signing key: %w", err)
+		}
+		config.Signer = signer
+
+	case *nbictlpb.Config_AuthStrategy_GcpKms_:
+		signer, err := gcpkms.NewSigner(ctx, gcpkms.Config{
+			KeyVersionName: s.GcpKms.GetKeyVersionName(),
+		})
+		if err != nil {
+			return auth.Config{}, nil, fmt.Errorf("unable to use the Cloud KMS signing key: %w", err)
+		}
+		config.Signer = signer
+		release = func() { signer.Close() }
+
+	case *nbictlpb.Config_AuthStrategy_VaultKv_:
+		pkeyBytes, err := vault.FetchPrivateKey(ctx, vault.KVConfig{
+			Config: vault.Config{Client: httpClient},
+			Mount:  s.VaultKv.GetMount(),
+			Path:   s.VaultKv.GetPath(),
+			Field:  s.VaultKv.GetField(),
+		})
+		if err != nil {
+			return auth.Config{}, nil, fmt.Errorf("unable to read the private key from Vault: %w", err)
+		}
+		config.PrivateKey = bytes.NewBuffer(pkeyBytes)
+
+	case *nbictlpb.Config_AuthStrategy_VaultTransit_:
+		signer, err := vault.NewTransitSigner(ctx, vault.TransitConfig{
+			Config:  vault.Config{Client: httpClient},
+			Mount:   s.VaultTransit.GetMount(),
+			KeyName: s.VaultTransit.GetKeyName(),
+		})
+		if err != nil {
+			return auth.Config{}, nil, fmt.Errorf("unable to use the Vault Transit signing key: %w", err)
+		}
+		config.Signer = signer
+
+	case *nbictlpb.Config_AuthStrategy_ClientCredentials_, *nbictlpb.Config_AuthStrategy_ServiceAccountImpersonation_, *nbictlpb.Config_AuthStrategy_StaticToken_:
+		return auth.Config{}, nil, fmt.Errorf("auth strategy %T doesn't use a signing key", s)
+
+	default:
+		return auth.Config{}, nil, fmt.Errorf("unexpected auth strategy selection: %T", s)
+	}
+	return config, release, nil
+}
+
+type connectionCacheKey struct{}
+
+// connectionCache shares connections between the commands run in a single
+// `shell` session, so each command doesn't have to dial and authenticate
+// again. Connections are opened with the session's context, rather than the
+// context of the command that first needed them, so that they outlive it.
+type connectionCache struct {
+	ctx   context.Context
+	mu    sync.Mutex
+	conns map[string]*grpc.ClientConn
+}
+
+func newConnectionCache(ctx context.Context) *connectionCache {
+	return &connectionCache{ctx: ctx, conns: map[string]*grpc.ClientConn{}}
+}
+
+func withConnectionCache(ctx context.Context, cache *connectionCache) context.Context {
+	return context.WithValue(ctx, connectionCacheKey{}, cache)
 }
+
+func connectionCacheFromContext(ctx context.Context) *connectionCache {
+	cache, _ := ctx.Value(connectionCacheKey{}).(*connectionCache)
+	return cache
+}
+
+func (c *connectionCache) get(key string, open func(context.Context) (*grpc.ClientConn, error)) (clientConn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if conn, ok := c.conns[key]; ok {
+		moduleLogger(c.ctx, logModuleConnection).Debug("reusing connection", "target", conn.Target())
+		return sharedConn{conn}, nil
+	}
+	conn, err := open(c.ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.conns[key] = conn
+	return sharedConn{conn}, nil
+}
+
+// Close closes every cached connection.
+func (c *connectionCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	errs := []error{}
+	for key, conn := range c.conns {
+		errs = append(errs, conn.Close())
+		delete(c.conns, key)
+	}
+	return errors.Join(errs...)
+}
+
+// sharedConn is a cached connection. Closing it is a no-op, since it's
+// closed along with the cache.
+type sharedConn struct {
+	*grpc.ClientConn
+}
+
+func (sharedConn) Close() error { return nil }
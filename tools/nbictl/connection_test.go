@@ -17,18 +17,24 @@ package nbictl
 import (
 	"context"
 	"crypto/tls"
+	"errors"
+	"net"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/bazelbuild/rules_go/go/tools/bazel"
 	"golang.org/x/sync/errgroup"
+	"google.golang.org/protobuf/proto"
 
 	nbi "aalyria.com/spacetime/api/nbi/v1alpha"
 	"aalyria.com/spacetime/auth/authtest"
+	"aalyria.com/spacetime/callctx"
 	"aalyria.com/spacetime/github/tools/nbictl/nbictlpb"
+	"aalyria.com/spacetime/inproc"
 )
 
 const (
@@ -199,6 +205,158 @@ func TestDial_serverCertificate(t *testing.T) {
 	}
 }
 
+func TestDial_staticToken(t *testing.T) {
+	t.Setenv("NBICTL_TEST_TOKEN", "static-token")
+
+	tmpDir, err := bazel.NewTmpDir("nbictl")
+	checkErr(t, err)
+	serverCertPath := filepath.Join(tmpDir, "localhost.crt.tls")
+	checkErr(t, os.WriteFile(serverCertPath, LocalhostCert, 0o644))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	g, ctx := errgroup.WithContext(ctx)
+	defer func() { checkErr(t, g.Wait()) }()
+	defer cancel()
+	cert, _ := tls.X509KeyPair(LocalhostCert, LocalhostKey)
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	checkErr(t, err)
+	fakeGrpcServer, err := startFakeNbiServer(ctx, g, lis)
+	checkErr(t, err)
+
+	nbiConf := &nbictlpb.Config{
+		Url:  "passthrough:///" + lis.Addr().String(),
+		Name: "test",
+		TransportSecurity: &nbictlpb.Config_TransportSecurity{
+			Type: &nbictlpb.Config_TransportSecurity_ServerCertificate_{
+				ServerCertificate: &nbictlpb.Config_TransportSecurity_ServerCertificate{
+					CertFilePath: serverCertPath,
+				},
+			},
+		},
+		AuthStrategy: &nbictlpb.Config_AuthStrategy{
+			Type: &nbictlpb.Config_AuthStrategy_StaticToken_{
+				StaticToken: &nbictlpb.Config_AuthStrategy_StaticToken{EnvVar: "NBICTL_TEST_TOKEN"},
+			},
+		},
+	}
+	conn, err := dial(ctx, nbiConf, nil)
+	checkErr(t, err)
+	defer conn.Close()
+
+	client := nbi.NewNetOpsClient(conn)
+	_, err = client.ListEntities(ctx, &nbi.ListEntitiesRequest{Type: nbi.EntityType_ANTENNA_PATTERN.Enum()})
+	checkErr(t, err)
+
+	if want, got := []string{"Bearer static-token"}, fakeGrpcServer.IncomingMetadata[0].Get(authHeader); !slices.Equal(want, got) {
+		t.Fatalf("fakeGrpcServer received the wrong authHeader header: got %+v, wanted %+v ", got, want)
+	}
+	// The static token strategy doesn't provide a proxy token.
+	if len(fakeGrpcServer.IncomingMetadata[0].Get(proxyAuthHeader)) > 0 {
+		t.Fatal("Unexpected Incoming Metadata: ", proxyAuthHeader)
+	}
+}
+
+func TestDial_localTransports(t *testing.T) {
+	t.Setenv("NBICTL_TEST_TOKEN", "static-token")
+
+	// Unix domain socket paths are limited to about 100 bytes, which the
+	// test's own temporary directory may exceed.
+	sockDir, err := os.MkdirTemp("", "nbictl")
+	checkErr(t, err)
+	t.Cleanup(func() { os.RemoveAll(sockDir) })
+	sock := filepath.Join(sockDir, "nbi.sock")
+
+	for _, tc := range []struct {
+		name   string
+		url    string
+		listen func() (net.Listener, error)
+	}{
+		{
+			name:   "unix",
+			url:    "unix://" + sock,
+			listen: func() (net.Listener, error) { return net.Listen("unix", sock) },
+		},
+		{
+			name:   "inproc",
+			url:    inproc.Scheme + ":///" + t.Name(),
+			listen: func() (net.Listener, error) { return inproc.Listen(t.Name()) },
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			g, ctx := errgroup.WithContext(ctx)
+			defer func() { checkErr(t, g.Wait()) }()
+			defer cancel()
+			lis, err := tc.listen()
+			checkErr(t, err)
+			srv, err := startFakeNbiServer(ctx, g, lis)
+			checkErr(t, err)
+
+			// Without a transport_security, the local default still sends
+			// the credentials, which require transport security.
+			conn, err := dial(ctx, &nbictlpb.Config{
+				Url: tc.url,
+				AuthStrategy: &nbictlpb.Config_AuthStrategy{
+					Type: &nbictlpb.Config_AuthStrategy_StaticToken_{
+						StaticToken: &nbictlpb.Config_AuthStrategy_StaticToken{EnvVar: "NBICTL_TEST_TOKEN"},
+					},
+				},
+			}, nil)
+			checkErr(t, err)
+			defer conn.Close()
+
+			_, err = nbi.NewNetOpsClient(conn).ListEntities(ctx, &nbi.ListEntitiesRequest{Type: nbi.EntityType_ANTENNA_PATTERN.Enum()})
+			checkErr(t, err)
+			if want, got := []string{"Bearer static-token"}, srv.IncomingMetadata[0].Get(authHeader); !slices.Equal(want, got) {
+				t.Errorf("server received the wrong %s header: got %+v, wanted %+v", authHeader, got, want)
+			}
+		})
+	}
+}
+
+func TestDial(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := bazel.NewTmpDir("nbictl")
+	checkErr(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	g, ctx := errgroup.WithContext(ctx)
+	defer func() { checkErr(t, g.Wait()) }()
+	defer cancel()
+	srv := startInsecureServer(ctx, t, g)
+
+	setConfig := func(contextName string, args ...string) {
+		t.Helper()
+		checkErr(t, newTestApp().Run(append([]string{
+			"nbictl", "--config_dir", tmpDir, "--context", contextName,
+			"set-config",
+			"--transport_security", "insecure",
+			"--url", srv.listener.Addr().String(),
+		}, args...)))
+	}
+	setConfig("rw")
+	setConfig("ro", "--read_only")
+	deleteEntity := func(contextName string) error {
+		conn, err := Dial(ctx, tmpDir, contextName)
+		checkErr(t, err)
+		defer conn.Close()
+		_, err = nbi.NewNetOpsClient(conn).DeleteEntity(ctx, &nbi.DeleteEntityRequest{Type: nbi.EntityType_NETWORK_NODE.Enum(), Id: proto.String("abc")})
+		return err
+	}
+
+	checkErr(t, deleteEntity("rw"))
+	if md := srv.IncomingMetadata[len(srv.IncomingMetadata)-1]; len(md.Get(callctx.RequestIDKey)) != 1 {
+		t.Errorf("DeleteEntity was sent without a request ID: %v", md)
+	}
+	if err := deleteEntity("ro"); !errors.Is(err, errReadOnly) {
+		t.Errorf("DeleteEntity with a read-only context: got %v, want %v", err, errReadOnly)
+	}
+	if _, err := Dial(ctx, tmpDir, ""); err == nil {
+		t.Errorf("Dial without a context name succeeded, want an error since there are two contexts")
+	}
+}
+
 func testingKey(s string) string {
 	return strings.ReplaceAll(s, "TESTING KEY", "PRIVATE KEY")
 }
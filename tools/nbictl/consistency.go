@@ -0,0 +1,83 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+)
+
+const (
+	readPollInitialInterval = 50 * time.Millisecond
+	readPollMaxInterval     = time.Second
+)
+
+var waitForReadsFlag = &cli.DurationFlag{
+	Name:  "wait_for_reads",
+	Usage: "How long to wait after each change until reading the entity reflects it, for scripts that use the entities right away. Changes that aren't visible in time are reported as failures. If unset or 0, nbictl doesn't wait.",
+	Action: func(_ *cli.Context, d time.Duration) error {
+		if d < 0 {
+			return fmt.Errorf("--wait_for_reads can't be negative, got %s", d)
+		}
+		return nil
+	},
+}
+
+// errNotYetReadable is returned by waitUntilReadable when reads don't reflect
+// a change before the timeout.
+var errNotYetReadable = errors.New("reads don't reflect the change yet")
+
+// waitUntilReadable polls the entity of type `typ` with ID `id` until reading
+// it reflects a mutation, for at most `timeout`. The commit timestamp
+// returned by a create or update acts as a version token: the mutation is
+// visible once GetEntity returns a version at least as recent. If `deleted`
+// is set, it's visible once GetEntity no longer finds the entity.
+func waitUntilReadable(ctx context.Context, client nbipb.NetOpsClient, typ nbipb.EntityType, id string, commitTimestamp int64, deleted bool, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	interval := readPollInitialInterval
+	for {
+		e, err := client.GetEntity(ctx, &nbipb.GetEntityRequest{Type: typ.Enum(), Id: proto.String(id)})
+		switch {
+		case ctx.Err() != nil:
+			return fmt.Errorf("%w after %s", errNotYetReadable, timeout)
+		case deleted && status.Code(err) == codes.NotFound:
+			return nil
+		case status.Code(err) == codes.NotFound, deleted && err == nil:
+			// The change hasn't propagated to this read yet.
+		case err != nil:
+			return fmt.Errorf("reading the entity back: %w", err)
+		case e.GetCommitTimestamp() >= commitTimestamp:
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w after %s", errNotYetReadable, timeout)
+		case <-time.After(interval):
+		}
+		interval = min(2*interval, readPollMaxInterval)
+	}
+}
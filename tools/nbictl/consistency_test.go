@@ -0,0 +1,76 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+)
+
+func TestWaitForReads(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	srv, client := startNBITestServer(t)
+	srv.SetReadLag(300 * time.Millisecond)
+	get := func(id string) (*nbipb.Entity, error) {
+		return client.GetEntity(ctx, &nbipb.GetEntityRequest{Type: nbipb.EntityType_NETWORK_NODE.Enum(), Id: proto.String(id)})
+	}
+	bulk := BulkOptions{WaitForReads: 10 * time.Second}
+
+	streams, _, _ := newTestStreams()
+	checkErr(t, CreateEntities(ctx, client, CreateOptions{Entities: []*nbipb.Entity{testNetworkNode("a", "a-v1")}, Bulk: bulk}, streams))
+	if _, err := get("a"); err != nil {
+		t.Errorf("GetEntity right after CreateEntities: %v", err)
+	}
+
+	checkErr(t, UpdateEntities(ctx, client, UpdateOptions{Entities: []*nbipb.Entity{testNetworkNode("a", "a-v2")}, Bulk: bulk}, streams))
+	if e, err := get("a"); err != nil || e.GetNetworkNode().GetName() != "a-v2" {
+		t.Errorf("GetEntity right after UpdateEntities = %v, %v; want a-v2", e, err)
+	}
+
+	checkErr(t, DeleteEntities(ctx, client, DeleteOptions{Entities: []*nbipb.Entity{testNetworkNode("a", "")}, IgnoreConsistencyCheck: true, Bulk: bulk}, streams))
+	if _, err := get("a"); status.Code(err) != codes.NotFound {
+		t.Errorf("GetEntity right after DeleteEntities: got %v, want NotFound", err)
+	}
+}
+
+func TestWaitForReads_timeout(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	srv, client := startNBITestServer(t)
+	srv.SetReadLag(time.Hour)
+
+	streams, _, _ := newTestStreams()
+	err := CreateEntities(ctx, client, CreateOptions{
+		Entities: []*nbipb.Entity{testNetworkNode("a", "a")},
+		Bulk:     BulkOptions{WaitForReads: 200 * time.Millisecond},
+	}, streams)
+	if !errors.Is(err, errNotYetReadable) {
+		t.Fatalf("CreateEntities() = %v, want %v", err, errNotYetReadable)
+	}
+	if _, ok := srv.Entity(nbipb.EntityType_NETWORK_NODE, "a"); !ok {
+		t.Errorf("the entity wasn't created")
+	}
+}
@@ -0,0 +1,382 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	commonpb "aalyria.com/spacetime/api/common"
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+	resourcespb "aalyria.com/spacetime/api/nbi/v1alpha/resources"
+)
+
+const (
+	wgs84EarthRadiusM = 6378137.0
+
+	// The frequencies of the generated links: satellites transmit to ground
+	// stations on the downlink frequency, and receive from them on the
+	// uplink frequency.
+	constellationDownlinkHz = 12_000_000_000
+	constellationUplinkHz   = 14_000_000_000
+)
+
+// defaultConstellationEpoch is the epoch of generated orbits unless another
+// is provided, fixed so that the same options always generate the same
+// entities.
+var defaultConstellationEpoch = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// ConstellationOptions are the options of ConstellationEntities.
+type ConstellationOptions struct {
+	// Satellites is the number of satellites, which are spread over Planes
+	// circular orbital planes in a Walker delta pattern.
+	Satellites int
+	Planes     int
+	// AltitudeKm and InclinationDeg are the altitude and inclination of
+	// every orbital plane.
+	AltitudeKm     float64
+	InclinationDeg float64
+	// GroundStations is the number of ground stations, which are placed at
+	// random locations.
+	GroundStations int
+	// Seed seeds every random choice, such as the locations of ground
+	// stations and the transmit powers and gains of each link budget, so
+	// the same options always generate the same entities.
+	Seed uint64
+	// IDPrefix is prepended to the ID of every entity, to keep generated
+	// entities apart from others and from other generated constellations.
+	IDPrefix string
+	// Epoch is the epoch of the satellites' orbital elements. Defaults to
+	// the start of 2024.
+	Epoch time.Time
+}
+
+// ConstellationEntities generates a synthetic constellation: a band profile
+// and antenna pattern shared by every link, and a platform definition and
+// network node for each satellite and ground station. Each satellite and
+// ground station has a transceiver model with its own randomly chosen
+// transmit power and amplifier gain.
+func ConstellationEntities(opts ConstellationOptions) ([]*nbipb.Entity, error) {
+	switch {
+	case opts.Satellites < 0 || opts.GroundStations < 0:
+		return nil, errors.New("the number of satellites and ground stations can't be negative")
+	case opts.Planes < 1:
+		return nil, fmt.Errorf("there must be at least 1 orbital plane, got %d", opts.Planes)
+	case opts.Satellites > 0 && opts.Planes > opts.Satellites:
+		return nil, fmt.Errorf("there can't be more orbital planes (%d) than satellites (%d)", opts.Planes, opts.Satellites)
+	case opts.AltitudeKm <= 0:
+		return nil, fmt.Errorf("the altitude must be positive, got %v km", opts.AltitudeKm)
+	case opts.InclinationDeg < 0 || opts.InclinationDeg > 180:
+		return nil, fmt.Errorf("the inclination must be between 0 and 180 degrees, got %v", opts.InclinationDeg)
+	}
+	if opts.Epoch.IsZero() {
+		opts.Epoch = defaultConstellationEpoch
+	}
+	g := &constellationGenerator{
+		opts:             opts,
+		rng:              rand.New(rand.NewPCG(opts.Seed, 0)),
+		bandProfileID:    opts.IDPrefix + "band-profile",
+		antennaPatternID: opts.IDPrefix + "antenna-pattern",
+	}
+
+	entities := []*nbipb.Entity{
+		{
+			Id:    proto.String(g.bandProfileID),
+			Group: &nbipb.EntityGroup{Type: nbipb.EntityType_BAND_PROFILE.Enum()},
+			Value: &nbipb.Entity_BandProfile{BandProfile: &commonpb.BandProfile{
+				ChannelWidthHz: proto.Uint64(250_000_000),
+				RateTable: &commonpb.AdaptiveDataRateTable{
+					ReceivedSignalPowerSteps: []*commonpb.AdaptiveDataRateTable_ReceivedSignalPowerDataRateMapping{
+						{MinReceivedSignalPowerDbw: proto.Float64(-100), TxDataRateBps: proto.Float64(1e8)},
+						{MinReceivedSignalPowerDbw: proto.Float64(-90), TxDataRateBps: proto.Float64(2e8)},
+						{MinReceivedSignalPowerDbw: proto.Float64(-80), TxDataRateBps: proto.Float64(3e8)},
+					},
+				},
+			}},
+		},
+		{
+			Id:    proto.String(g.antennaPatternID),
+			Group: &nbipb.EntityGroup{Type: nbipb.EntityType_ANTENNA_PATTERN.Enum()},
+			Value: &nbipb.Entity_AntennaPattern{AntennaPattern: &resourcespb.AntennaPattern{
+				PatternType: &resourcespb.AntennaPattern_ParabolicPattern{
+					ParabolicPattern: &resourcespb.AntennaPattern_ParabolicAntennaPattern{
+						DiameterM:         proto.Float64(1),
+						EfficiencyPercent: proto.Float64(0.9),
+						BacklobeGainDb:    proto.Float64(-60),
+					},
+				},
+			}},
+		},
+	}
+
+	// Satellites are spread as evenly as possible over the planes, and
+	// each plane is offset from the last by 1/Satellites of an orbit.
+	for plane := range opts.Planes {
+		inPlane := opts.Satellites / opts.Planes
+		if plane < opts.Satellites%opts.Planes {
+			inPlane++
+		}
+		for slot := range inPlane {
+			anomaly := 360*float64(slot)/float64(inPlane) + 360*float64(plane)/float64(opts.Satellites)
+			orbit := &commonpb.KeplerianElements{
+				SemimajorAxisM:         proto.Float64(wgs84EarthRadiusM + opts.AltitudeKm*1000),
+				Eccentricity:           proto.Float64(0),
+				InclinationDeg:         proto.Float64(opts.InclinationDeg),
+				ArgumentOfPeriapsisDeg: proto.Float64(0),
+				RaanDeg:                proto.Float64(360 * float64(plane) / float64(opts.Planes)),
+				TrueAnomalyDeg:         proto.Float64(math.Mod(anomaly, 360)),
+				Epoch:                  &commonpb.DateTime{UnixTimeUsec: proto.Int64(opts.Epoch.UnixMicro())},
+			}
+			entities = append(entities, g.node(
+				fmt.Sprintf("sat-%d-%d", plane, slot), "Satellite",
+				&commonpb.Motion{Type: &commonpb.Motion_KeplerianElements{KeplerianElements: orbit}},
+				constellationDownlinkHz, constellationUplinkHz, "REMOTE",
+				20, 100)...)
+		}
+	}
+
+	for i := range opts.GroundStations {
+		// Pick locations uniformly over the Earth's surface, rather than
+		// uniformly over latitudes, which would crowd the poles.
+		location := &commonpb.GeodeticWgs84{
+			LatitudeDeg:  proto.Float64(math.Asin(2*g.rng.Float64()-1) * 180 / math.Pi),
+			LongitudeDeg: proto.Float64(360*g.rng.Float64() - 180),
+			HeightWgs84M: proto.Float64(0),
+		}
+		entities = append(entities, g.node(
+			fmt.Sprintf("gs-%d", i), "GroundStation",
+			&commonpb.Motion{Type: &commonpb.Motion_GeodeticWgs84{GeodeticWgs84: location}},
+			constellationUplinkHz, constellationDownlinkHz, "HUB",
+			100, 500)...)
+	}
+	return entities, nil
+}
+
+type constellationGenerator struct {
+	opts                            ConstellationOptions
+	rng                             *rand.Rand
+	bandProfileID, antennaPatternID string
+	nodes                           int
+}
+
+// node returns the platform definition and network node of a satellite or
+// ground station called `name`, with a transceiver model that transmits on
+// `txHz` with a random power between `minWatts` and `maxWatts`, and
+// receives on `rxHz`.
+func (g *constellationGenerator) node(name, typ string, motion *commonpb.Motion, txHz, rxHz uint64, role string, minWatts, maxWatts float64) []*nbipb.Entity {
+	id := g.opts.IDPrefix + name
+	g.nodes++
+
+	amplifier := &commonpb.AmplifierDefinition{
+		AmplifierType: &commonpb.AmplifierDefinition_ConstantGain{
+			ConstantGain: &commonpb.AmplifierDefinition_ConstantGainAmplifierDefinition{
+				GainDb:                proto.Float64(math.Round(5 + 10*g.rng.Float64())),
+				NoiseFactor:           proto.Float64(1),
+				ReferenceTemperatureK: proto.Float64(290),
+			},
+		},
+	}
+	transceiver := &commonpb.TransceiverModel{
+		Id: proto.String("transceiver-model"),
+		Transmitter: &commonpb.TransmitterDefinition{
+			Name: proto.String("tx"),
+			ChannelSet: map[string]*commonpb.TxChannels{
+				g.bandProfileID: {Channel: map[uint64]*commonpb.TxChannels_TxChannelParams{
+					txHz: {MaxPowerWatts: proto.Float64(math.Round(minWatts + (maxWatts-minWatts)*g.rng.Float64()))},
+				}},
+			},
+			SignalProcessingStep: []*commonpb.TransmitSignalProcessor{
+				{Type: &commonpb.TransmitSignalProcessor_Amplifier{Amplifier: amplifier}},
+			},
+		},
+		Receiver: &commonpb.ReceiverDefinition{
+			Name: proto.String("rx"),
+			ChannelSet: map[string]*commonpb.RxChannels{
+				g.bandProfileID: {CenterFrequencyHz: []int64{int64(rxHz)}},
+			},
+			SignalProcessingStep: []*commonpb.ReceiveSignalProcessor{
+				{Type: &commonpb.ReceiveSignalProcessor_Amplifier{Amplifier: amplifier}},
+			},
+		},
+		Antenna: &commonpb.AntennaDefinition{
+			Name:             proto.String("antenna"),
+			AntennaPatternId: proto.String(g.antennaPatternID),
+			Targeting:        &commonpb.Targeting{},
+		},
+		Macs: []*commonpb.WirelessMac{{
+			Type:           proto.String("DVBS2"),
+			Role:           proto.String(role),
+			MaxConnections: proto.Int32(1),
+		}},
+	}
+
+	return []*nbipb.Entity{
+		{
+			Id:    proto.String(id),
+			Group: &nbipb.EntityGroup{Type: nbipb.EntityType_PLATFORM_DEFINITION.Enum()},
+			Value: &nbipb.Entity_Platform{Platform: &commonpb.PlatformDefinition{
+				Name:             proto.String(name),
+				Type:             proto.String(typ),
+				Coordinates:      motion,
+				TransceiverModel: []*commonpb.TransceiverModel{transceiver},
+			}},
+		},
+		{
+			Id:    proto.String(id),
+			Group: &nbipb.EntityGroup{Type: nbipb.EntityType_NETWORK_NODE.Enum()},
+			Value: &nbipb.Entity_NetworkNode{NetworkNode: &resourcespb.NetworkNode{
+				Name: proto.String(name),
+				Type: proto.String(typ),
+				// Addresses are handed out in order from 100.64.0.0/10.
+				Subnet: []string{fmt.Sprintf("100.%d.%d.%d/32", 64+g.nodes>>16&63, g.nodes>>8&255, g.nodes&255)},
+				NodeInterface: []*resourcespb.NetworkInterface{{
+					InterfaceId: proto.String("radio"),
+					InterfaceMedium: &resourcespb.NetworkInterface_Wireless{Wireless: &resourcespb.WirelessDevice{
+						TransceiverModelId: &commonpb.TransceiverModelId{
+							PlatformId:         proto.String(id),
+							TransceiverModelId: transceiver.Id,
+						},
+					}},
+				}},
+			}},
+		},
+	}
+}
+
+// writeEntityFile writes `entities` to `w` in the format newEntityDecoder
+// reads files named `path` in.
+func writeEntityFile(w io.Writer, path string, entities []*nbipb.Entity) error {
+	bw := bufio.NewWriter(w)
+	var err error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".ndjson", ".jsonl":
+		for _, e := range entities {
+			if err = writeEntityJSON(bw, e, "\n"); err != nil {
+				break
+			}
+		}
+	case ".json":
+		sep := "[\n"
+		for _, e := range entities {
+			fmt.Fprint(bw, sep)
+			if err = writeEntityJSON(bw, e, ""); err != nil {
+				break
+			}
+			sep = ",\n"
+		}
+		if len(entities) == 0 {
+			fmt.Fprint(bw, sep)
+		}
+		fmt.Fprint(bw, "\n]\n")
+	case ".yaml", ".yml":
+		// JSON is valid YAML, and keeps large files quick to write.
+		for _, e := range entities {
+			fmt.Fprint(bw, "---\n")
+			if err = writeEntityJSON(bw, e, "\n"); err != nil {
+				break
+			}
+		}
+	default:
+		var out []byte
+		if out, err = (outputOptions{}).marshal(&nbipb.TxtpbEntities{Entity: entities}); err == nil {
+			_, err = fmt.Fprintln(bw, string(out))
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func writeEntityJSON(w io.Writer, e *nbipb.Entity, suffix string) error {
+	data, err := protojson.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("encoding entity %s/%s: %w", e.GetGroup().GetType(), e.GetId(), err)
+	}
+	_, err = fmt.Fprintf(w, "%s%s", data, suffix)
+	return err
+}
+
+func GenerateConstellation(appCtx *cli.Context) error {
+	opts := ConstellationOptions{
+		Satellites:     appCtx.Int("satellites"),
+		Planes:         appCtx.Int("planes"),
+		AltitudeKm:     appCtx.Float64("altitude_km"),
+		InclinationDeg: appCtx.Float64("inclination_deg"),
+		GroundStations: appCtx.Int("ground_stations"),
+		Seed:           appCtx.Uint64("seed"),
+		IDPrefix:       appCtx.String("id_prefix"),
+	}
+	if ts := appCtx.Timestamp("epoch"); ts != nil {
+		opts.Epoch = *ts
+	}
+	entities, err := ConstellationEntities(opts)
+	if err != nil {
+		return err
+	}
+
+	return writeOrCreateEntities(appCtx, entities)
+}
+
+// writeOrCreateEntities writes `entities` to the file given by the
+// --output_file flag and creates them if --create is set, or prints them as
+// textproto if neither is.
+func writeOrCreateEntities(appCtx *cli.Context, entities []*nbipb.Entity) error {
+	outputFile := appCtx.Path("output_file")
+	switch {
+	case outputFile != "":
+		f, err := os.Create(outputFile)
+		if err != nil {
+			return err
+		}
+		if err := writeEntityFile(f, outputFile, entities); err != nil {
+			f.Close()
+			return fmt.Errorf("writing %s: %w", outputFile, err)
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		fmt.Fprintf(appCtx.App.ErrWriter, "wrote %d entities to %s\n", len(entities), outputFile)
+	case !appCtx.Bool("create"):
+		return writeEntityFile(appCtx.App.Writer, "", entities)
+	}
+	if !appCtx.Bool("create") {
+		return nil
+	}
+
+	bulk, err := bulkOptionsFromFlags(appCtx)
+	if err != nil {
+		return err
+	}
+	conn, err := openServiceConnection(appCtx, nbipb.NetOps_ServiceDesc.ServiceName)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return CreateEntities(appCtx.Context, nbipb.NewNetOpsClient(conn), CreateOptions{Entities: entities, Bulk: bulk}, ioStreamsFromContext(appCtx))
+}
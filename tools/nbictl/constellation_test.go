@@ -0,0 +1,179 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+)
+
+var testConstellationOptions = ConstellationOptions{
+	Satellites:     7,
+	Planes:         3,
+	AltitudeKm:     550,
+	InclinationDeg: 53,
+	GroundStations: 2,
+	Seed:           1,
+	IDPrefix:       "test-",
+}
+
+func TestConstellationEntities(t *testing.T) {
+	t.Parallel()
+
+	entities, err := ConstellationEntities(testConstellationOptions)
+	checkErr(t, err)
+
+	counts := map[nbipb.EntityType]int{}
+	satsPerPlane := map[float64]int{}
+	keys := map[string]bool{}
+	for _, e := range entities {
+		counts[e.GetGroup().GetType()]++
+		if orbit := e.GetPlatform().GetCoordinates().GetKeplerianElements(); orbit != nil {
+			satsPerPlane[orbit.GetRaanDeg()]++
+		}
+		if !strings.HasPrefix(e.GetId(), "test-") {
+			t.Errorf("entity ID %q doesn't start with the prefix", e.GetId())
+		}
+		key := e.GetGroup().GetType().String() + "/" + e.GetId()
+		if keys[key] {
+			t.Errorf("entity %s was generated twice", key)
+		}
+		keys[key] = true
+	}
+	wantCounts := map[nbipb.EntityType]int{
+		nbipb.EntityType_BAND_PROFILE:        1,
+		nbipb.EntityType_ANTENNA_PATTERN:     1,
+		nbipb.EntityType_PLATFORM_DEFINITION: 9,
+		nbipb.EntityType_NETWORK_NODE:        9,
+	}
+	if diff := cmp.Diff(wantCounts, counts); diff != "" {
+		t.Errorf("entity counts mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(map[float64]int{0: 3, 120: 2, 240: 2}, satsPerPlane); diff != "" {
+		t.Errorf("satellites per plane mismatch (-want +got):\n%s", diff)
+	}
+
+	// The same options always generate the same entities, and the seed
+	// changes the random choices.
+	again, err := ConstellationEntities(testConstellationOptions)
+	checkErr(t, err)
+	if diff := cmp.Diff(entities, again, protocmp.Transform()); diff != "" {
+		t.Errorf("entities generated with the same seed differ (-first +second):\n%s", diff)
+	}
+	reseeded := testConstellationOptions
+	reseeded.Seed = 2
+	other, err := ConstellationEntities(reseeded)
+	checkErr(t, err)
+	if cmp.Equal(entities, other, protocmp.Transform()) {
+		t.Error("entities generated with different seeds are the same")
+	}
+}
+
+func TestConstellationEntities_rejectsInvalidOptions(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name    string
+		modify  func(*ConstellationOptions)
+		wantErr string
+	}{
+		{"no planes", func(o *ConstellationOptions) { o.Planes = 0 }, "at least 1 orbital plane"},
+		{"more planes than satellites", func(o *ConstellationOptions) { o.Planes = 8 }, "more orbital planes"},
+		{"negative satellites", func(o *ConstellationOptions) { o.Satellites = -1 }, "can't be negative"},
+		{"zero altitude", func(o *ConstellationOptions) { o.AltitudeKm = 0 }, "altitude must be positive"},
+		{"retrograde beyond 180", func(o *ConstellationOptions) { o.InclinationDeg = 181 }, "inclination must be between"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			opts := testConstellationOptions
+			tc.modify(&opts)
+			if _, err := ConstellationEntities(opts); err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("expected error to contain %q, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestWriteEntityFile(t *testing.T) {
+	t.Parallel()
+
+	entities, err := ConstellationEntities(testConstellationOptions)
+	checkErr(t, err)
+	dir := t.TempDir()
+	for _, name := range []string{"entities.json", "entities.ndjson", "entities.yaml", "entities.textproto"} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(dir, name)
+			buf := &bytes.Buffer{}
+			checkErr(t, writeEntityFile(buf, path, entities))
+			checkErr(t, os.WriteFile(path, buf.Bytes(), 0o644))
+
+			got, err := readEntityFile(path)
+			checkErr(t, err)
+			if diff := cmp.Diff(entities, got, protocmp.Transform()); diff != "" {
+				t.Errorf("entities read back mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGenerateConstellation(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "constellation.ndjson")
+	app := newTestApp()
+	checkErr(t, app.Run([]string{"nbictl", "generate-constellation", "--satellites", "4", "--planes", "2", "--ground_stations", "1", "--output_file", path}))
+	entities, err := readEntityFile(path)
+	checkErr(t, err)
+	if len(entities) != 12 {
+		t.Errorf("expected 12 entities, got %d", len(entities))
+	}
+	if !strings.Contains(app.stderr.String(), "wrote 12 entities") {
+		t.Errorf("unexpected stderr: %q", app.stderr.String())
+	}
+
+	// Without --output_file or --create, the entities are printed.
+	app = newTestApp()
+	checkErr(t, app.Run([]string{"nbictl", "generate-constellation", "--satellites", "1", "--planes", "1", "--ground_stations", "0", "--id_prefix", "x-"}))
+	if out := app.stdout.String(); !strings.Contains(out, `"x-sat-0-0"`) || !strings.Contains(out, "keplerian_elements") {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+}
+
+func TestConstellationEntities_canBeCreated(t *testing.T) {
+	t.Parallel()
+
+	srv, client := startNBITestServer(t)
+	entities, err := ConstellationEntities(testConstellationOptions)
+	checkErr(t, err)
+	streams, _, _ := newTestStreams()
+	checkErr(t, CreateEntities(context.Background(), client, CreateOptions{Entities: entities}, streams))
+	for _, e := range entities {
+		if _, ok := srv.Entity(e.GetGroup().GetType(), e.GetId()); !ok {
+			t.Errorf("entity %s/%s wasn't created", e.GetGroup().GetType(), e.GetId())
+		}
+	}
+}
@@ -0,0 +1,293 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	commonpb "aalyria.com/spacetime/api/common"
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+	outputv1 "aalyria.com/spacetime/github/tools/nbictl/output/v1"
+)
+
+const (
+	// WGS 84 ellipsoid parameters.
+	wgs84SemiMajorAxisM = 6378137.0
+	wgs84Flattening     = 1 / 298.257223563
+	wgs84SemiMinorAxisM = wgs84SemiMajorAxisM * (1 - wgs84Flattening)
+
+	defaultContactStepSize = 10 * time.Second
+	// contactBoundaryPrecision is how precisely the start and end of each
+	// contact window is located once a transition has been detected.
+	contactBoundaryPrecision = 100 * time.Millisecond
+)
+
+// platformMotion provides a platform's position, in meters, in the
+// Earth-Centered, Earth-Fixed frame.
+type platformMotion interface {
+	positionECEF(time.Time) ([3]float64, error)
+}
+
+// fixedMotion is the motion of a platform that doesn't move relative to the
+// Earth, such as a ground station.
+type fixedMotion [3]float64
+
+func (f fixedMotion) positionECEF(time.Time) ([3]float64, error) { return f, nil }
+
+type contactWindow struct {
+	start, end time.Time
+}
+
+func PreviewContacts(appCtx *cli.Context) error {
+	ids := []string{appCtx.String("tx_platform_id"), appCtx.String("target_platform_id")}
+	motions, err := readPlatformMotions(appCtx.Context, appCtx.String("files"), ids)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	if ts := appCtx.Timestamp("analysis_start_timestamp"); ts != nil {
+		start = *ts
+	}
+	end := start.Add(24 * time.Hour)
+	if ts := appCtx.Timestamp("analysis_end_timestamp"); ts != nil {
+		end = *ts
+	}
+	if !end.After(start) {
+		return errors.New("--analysis_end_timestamp must be after --analysis_start_timestamp")
+	}
+	step := defaultContactStepSize
+	if appCtx.IsSet("step_size") {
+		step = appCtx.Duration("step_size")
+	}
+	if step <= 0 {
+		return errors.New("--step_size must be positive")
+	}
+
+	windows, err := contactWindows(motions[0], motions[1], start, end, step, appCtx.Float64("min_elevation_deg"))
+	if err != nil {
+		return err
+	}
+
+	if jsonOutputRequested(appCtx) {
+		list := outputv1.NewContactWindowList(ids...)
+		for _, cw := range windows {
+			list.Windows = append(list.Windows, outputv1.ContactWindow{
+				Start:           cw.start.UTC(),
+				End:             cw.end.UTC(),
+				DurationSeconds: cw.end.Sub(cw.start).Seconds(),
+			})
+		}
+		return writeJSONOutput(appCtx, list)
+	}
+
+	w := tabwriter.NewWriter(appCtx.App.Writer, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "START\tEND\tDURATION")
+	for _, cw := range windows {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", cw.start.UTC().Format(time.RFC3339), cw.end.UTC().Format(time.RFC3339), cw.end.Sub(cw.start).Round(time.Second))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(appCtx.App.ErrWriter, "predicted %d contact window(s) between %s and %s. these are local estimates based only on line of sight; use get-link-budget for authoritative results.\n", len(windows), ids[0], ids[1])
+	return nil
+}
+
+// readPlatformMotions returns the motion of each of the PlatformDefinition
+// entities with the provided IDs, in the same order.
+func readPlatformMotions(ctx context.Context, fileGlob string, ids []string) ([]platformMotion, error) {
+	mu := &sync.Mutex{}
+	platforms := map[string]*commonpb.PlatformDefinition{}
+	if err := processEntitiesFromFiles(ctx, fileGlob, func(_ context.Context, e *nbipb.Entity) error {
+		if e.GetGroup().GetType() != nbipb.EntityType_PLATFORM_DEFINITION {
+			return nil
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		platforms[e.GetId()] = e.GetPlatform()
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	motions := []platformMotion{}
+	for _, id := range ids {
+		pd, ok := platforms[id]
+		if !ok {
+			return nil, fmt.Errorf("no PlatformDefinition with ID %q found in %s", id, fileGlob)
+		}
+		m, err := motionFromProto(pd.GetCoordinates())
+		if err != nil {
+			return nil, fmt.Errorf("platform %q: %w", id, err)
+		}
+		motions = append(motions, m)
+	}
+	return motions, nil
+}
+
+func motionFromProto(m *commonpb.Motion) (platformMotion, error) {
+	switch t := m.GetType().(type) {
+	case *commonpb.Motion_Tle:
+		return parseTLE(t.Tle.GetLine1(), t.Tle.GetLine2())
+	case *commonpb.Motion_GeodeticWgs84:
+		return geodeticToECEF(t.GeodeticWgs84.GetLatitudeDeg(), t.GeodeticWgs84.GetLongitudeDeg(), t.GeodeticWgs84.GetHeightWgs84M()), nil
+	case *commonpb.Motion_GeodeticMsl:
+		// The difference between mean sea level and the ellipsoid is well
+		// below the precision of this estimate.
+		return geodeticToECEF(t.GeodeticMsl.GetLatitudeDeg(), t.GeodeticMsl.GetLongitudeDeg(), t.GeodeticMsl.GetHeightMslM()), nil
+	case *commonpb.Motion_EcefFixed:
+		pt := t.EcefFixed.GetPoint()
+		return fixedMotion{pt.GetXM(), pt.GetYM(), pt.GetZM()}, nil
+	case nil:
+		return nil, errors.New("coordinates are unset (motion_ref_id isn't supported)")
+	default:
+		return nil, fmt.Errorf("unsupported motion type %T; only TLEs and fixed positions can be propagated locally", t)
+	}
+}
+
+func geodeticToECEF(latDeg, lonDeg, heightM float64) fixedMotion {
+	lat, lon := latDeg*math.Pi/180, lonDeg*math.Pi/180
+	e2 := wgs84Flattening * (2 - wgs84Flattening)
+	n := wgs84SemiMajorAxisM / math.Sqrt(1-e2*math.Sin(lat)*math.Sin(lat))
+	return fixedMotion{
+		(n + heightM) * math.Cos(lat) * math.Cos(lon),
+		(n + heightM) * math.Cos(lat) * math.Sin(lon),
+		(n*(1-e2) + heightM) * math.Sin(lat),
+	}
+}
+
+// contactWindows samples the line of sight between `a` and `b` every `step`
+// over [start, end] and returns the intervals during which they can see each
+// other.
+func contactWindows(a, b platformMotion, start, end time.Time, step time.Duration, minElevationDeg float64) ([]contactWindow, error) {
+	return visibilityWindows(func(t time.Time) (bool, error) {
+		pa, err := a.positionECEF(t)
+		if err != nil {
+			return false, err
+		}
+		pb, err := b.positionECEF(t)
+		if err != nil {
+			return false, err
+		}
+		return isVisible(a, pa, pb, minElevationDeg) && isVisible(b, pb, pa, minElevationDeg), nil
+	}, start, end, step)
+}
+
+// visibilityWindows samples `visibleAt` every `step` over [start, end] and
+// returns the intervals during which it's true, with their boundaries
+// located to within contactBoundaryPrecision.
+func visibilityWindows(visibleAt func(time.Time) (bool, error), start, end time.Time, step time.Duration) ([]contactWindow, error) {
+	// boundary finds the instant in (lo, hi] at which visibility changes
+	// from `visibleAt(lo)`.
+	boundary := func(lo, hi time.Time, wasVisible bool) (time.Time, error) {
+		for hi.Sub(lo) > contactBoundaryPrecision {
+			mid := lo.Add(hi.Sub(lo) / 2)
+			v, err := visibleAt(mid)
+			if err != nil {
+				return time.Time{}, err
+			}
+			if v == wasVisible {
+				lo = mid
+			} else {
+				hi = mid
+			}
+		}
+		return hi, nil
+	}
+
+	windows := []contactWindow{}
+	var windowStart time.Time
+	wasVisible := false
+	prev := start
+	for t := start; ; t = t.Add(step) {
+		if t.After(end) {
+			t = end
+		}
+		v, err := visibleAt(t)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case v && !wasVisible:
+			windowStart = t
+			if !t.Equal(start) {
+				if windowStart, err = boundary(prev, t, wasVisible); err != nil {
+					return nil, err
+				}
+			}
+		case !v && wasVisible:
+			windowEnd, err := boundary(prev, t, wasVisible)
+			if err != nil {
+				return nil, err
+			}
+			windows = append(windows, contactWindow{start: windowStart, end: windowEnd})
+		}
+		wasVisible, prev = v, t
+		if t.Equal(end) {
+			break
+		}
+	}
+	if wasVisible {
+		windows = append(windows, contactWindow{start: windowStart, end: end})
+	}
+	return windows, nil
+}
+
+// isVisible reports whether `to` is visible from `from`, a position of the
+// platform with motion `m`. Fixed platforms are limited to targets above
+// their local horizon plus `minElevationDeg`; moving platforms are only
+// limited by the Earth blocking the line of sight.
+func isVisible(m platformMotion, from, to [3]float64, minElevationDeg float64) bool {
+	if _, ok := m.(fixedMotion); ok {
+		return elevationDeg(from, to) >= minElevationDeg
+	}
+	return !earthBlocksLineOfSight(from, to)
+}
+
+// elevationDeg returns the elevation of `to` above the horizon of `from`,
+// using the normal of the WGS 84 ellipsoid as the local vertical.
+func elevationDeg(from, to [3]float64) float64 {
+	a2, b2 := wgs84SemiMajorAxisM*wgs84SemiMajorAxisM, wgs84SemiMinorAxisM*wgs84SemiMinorAxisM
+	up := [3]float64{from[0] / a2, from[1] / a2, from[2] / b2}
+	los := [3]float64{to[0] - from[0], to[1] - from[1], to[2] - from[2]}
+	sinEl := dot(up, los) / (norm(up) * norm(los))
+	return math.Asin(math.Max(-1, math.Min(1, sinEl))) * 180 / math.Pi
+}
+
+// earthBlocksLineOfSight reports whether the segment between `a` and `b`
+// passes through the WGS 84 ellipsoid.
+func earthBlocksLineOfSight(a, b [3]float64) bool {
+	// Scale the z axis so the ellipsoid becomes a sphere.
+	k := wgs84SemiMajorAxisM / wgs84SemiMinorAxisM
+	a[2], b[2] = a[2]*k, b[2]*k
+
+	d := [3]float64{b[0] - a[0], b[1] - a[1], b[2] - a[2]}
+	// Find the point along the segment closest to the Earth's center.
+	t := math.Max(0, math.Min(1, -dot(a, d)/dot(d, d)))
+	closest := [3]float64{a[0] + t*d[0], a[1] + t*d[1], a[2] + t*d[2]}
+	return norm(closest) < wgs84SemiMajorAxisM
+}
+
+func dot(a, b [3]float64) float64 { return a[0]*b[0] + a[1]*b[1] + a[2]*b[2] }
+
+func norm(a [3]float64) float64 { return math.Sqrt(dot(a, a)) }
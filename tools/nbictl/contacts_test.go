@@ -0,0 +1,170 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/rules_go/go/tools/bazel"
+)
+
+const contactsTestPlatforms = `
+entity {
+  group { type: PLATFORM_DEFINITION }
+  id: "iss"
+  platform {
+    name: "ISS"
+    coordinates {
+      tle {
+        line1: "1 25544U 98067A   08264.51782528 -.00002182  00000-0 -11606-4 0  2927"
+        line2: "2 25544  51.6416 247.4627 0006703 130.5360 325.0288 15.72125391563537"
+      }
+    }
+  }
+}
+entity {
+  group { type: PLATFORM_DEFINITION }
+  id: "houston"
+  platform {
+    name: "Houston"
+    coordinates {
+      geodetic_wgs84 {
+        latitude_deg: 29.5593
+        longitude_deg: -95.0900
+        height_wgs84_m: 10
+      }
+    }
+  }
+}
+entity {
+  group { type: PLATFORM_DEFINITION }
+  id: "moving-ship"
+  platform {
+    coordinates {
+      keplerian_elements {}
+    }
+  }
+}
+`
+
+func writeContactsTestPlatforms(t *testing.T) string {
+	t.Helper()
+
+	tmpDir, err := bazel.NewTmpDir("nbictl")
+	checkErr(t, err)
+	path := filepath.Join(tmpDir, "platforms.textproto")
+	checkErr(t, os.WriteFile(path, []byte(contactsTestPlatforms), 0o644))
+	return path
+}
+
+func TestPreviewContacts(t *testing.T) {
+	t.Parallel()
+
+	platformsFile := writeContactsTestPlatforms(t)
+	app := newTestApp()
+	checkErr(t, app.Run([]string{
+		"nbictl", "preview-contacts",
+		"--files", platformsFile,
+		"--tx_platform_id", "iss",
+		"--target_platform_id", "houston",
+		"--analysis_start_timestamp", "2008-09-21T00:00:00Z",
+		"--analysis_end_timestamp", "2008-09-22T00:00:00Z",
+	}))
+
+	lines := strings.Split(strings.TrimSpace(app.stdout.String()), "\n")
+	// The ISS passes over any given mid-latitude site a handful of times a
+	// day.
+	if len(lines) < 3 || len(lines) > 9 {
+		t.Fatalf("expected between 2 and 8 contact windows, got:\n%s", app.stdout.String())
+	}
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		start, err := time.Parse(time.RFC3339, fields[0])
+		checkErr(t, err)
+		end, err := time.Parse(time.RFC3339, fields[1])
+		checkErr(t, err)
+		if d := end.Sub(start); d <= 0 || d > 15*time.Minute {
+			t.Errorf("expected a low Earth orbit pass to last less than 15 minutes, got %s", line)
+		}
+	}
+}
+
+func TestPreviewContacts_minElevationShortensWindows(t *testing.T) {
+	t.Parallel()
+
+	platformsFile := writeContactsTestPlatforms(t)
+	motions, err := readPlatformMotions(context.Background(), platformsFile, []string{"iss", "houston"})
+	checkErr(t, err)
+
+	start := time.Date(2008, time.September, 21, 0, 0, 0, 0, time.UTC)
+	totalContactTime := func(minElevationDeg float64) time.Duration {
+		windows, err := contactWindows(motions[0], motions[1], start, start.Add(24*time.Hour), defaultContactStepSize, minElevationDeg)
+		checkErr(t, err)
+		sum := time.Duration(0)
+		for _, w := range windows {
+			sum += w.end.Sub(w.start)
+		}
+		return sum
+	}
+
+	if horizon, masked := totalContactTime(0), totalContactTime(20); masked >= horizon {
+		t.Errorf("expected a 20 degree elevation mask to reduce the total contact time, got %s (vs. %s without)", masked, horizon)
+	}
+}
+
+func TestPreviewContacts_errors(t *testing.T) {
+	t.Parallel()
+
+	platformsFile := writeContactsTestPlatforms(t)
+	for _, tc := range []struct {
+		name string
+		args []string
+		want string
+	}{
+		{
+			name: "unknown platform",
+			args: []string{"--tx_platform_id", "iss", "--target_platform_id", "mars"},
+			want: `no PlatformDefinition with ID "mars" found`,
+		},
+		{
+			name: "unsupported motion",
+			args: []string{"--tx_platform_id", "iss", "--target_platform_id", "moving-ship"},
+			want: `platform "moving-ship": unsupported motion type`,
+		},
+		{
+			name: "empty interval",
+			args: []string{"--tx_platform_id", "iss", "--target_platform_id", "houston", "--analysis_start_timestamp", "2008-09-21T00:00:00Z", "--analysis_end_timestamp", "2008-09-21T00:00:00Z"},
+			want: "--analysis_end_timestamp must be after --analysis_start_timestamp",
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			args := append([]string{"nbictl", "preview-contacts", "--files", platformsFile}, tc.args...)
+			switch err := newTestApp().Run(args); {
+			case err == nil:
+				t.Fatalf("expected an error containing %q, got nil", tc.want)
+			case !strings.Contains(err.Error(), tc.want):
+				t.Fatalf("expected error to contain %q, but got %q", tc.want, err.Error())
+			}
+		})
+	}
+}
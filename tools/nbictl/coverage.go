@@ -0,0 +1,518 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	commonpb "aalyria.com/spacetime/api/common"
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+	outputv1 "aalyria.com/spacetime/github/tools/nbictl/output/v1"
+)
+
+const (
+	defaultCoverageDuration = 24 * time.Hour
+	defaultCoverageStepSize = time.Minute
+	defaultGridSpacingDeg   = 1.0
+)
+
+// coverageTarget is a ground site, region, or platform whose coverage is
+// computed. Regions are sampled at several points.
+type coverageTarget struct {
+	id     string
+	points []platformMotion
+}
+
+// coverageObserver is a platform that covers targets with the fields of
+// regard of its antennas.
+type coverageObserver struct {
+	id     string
+	motion platformMotion
+	// cones are the fields of regard of the platform's antennas. A
+	// platform without antennas is only limited by the line of sight.
+	cones []fieldOfRegardCone
+}
+
+// fieldOfRegardCone is a conic field of regard around the boresight of an
+// antenna, which is assumed to point at the local zenith for fixed
+// platforms, and at the center of the Earth for moving ones.
+type fieldOfRegardCone struct {
+	innerHalfAngleDeg, outerHalfAngleDeg float64
+}
+
+// unconstrainedCone covers every direction.
+var unconstrainedCone = fieldOfRegardCone{outerHalfAngleDeg: 180}
+
+// coverageOptions are the options of computeCoverage.
+type coverageOptions struct {
+	start, end time.Time
+	step       time.Duration
+	// minElevationDeg is the minimum elevation, above the horizon of the
+	// targets, of the platforms that cover them.
+	minElevationDeg float64
+}
+
+// newCoverageObserver returns the observer of the platform `id`, and a
+// warning for each of its antennas whose field of regard can't be modeled
+// and is treated as unconstrained.
+func newCoverageObserver(id string, pd *commonpb.PlatformDefinition) (coverageObserver, []string, error) {
+	m, err := motionFromProto(pd.GetCoordinates())
+	if err != nil {
+		return coverageObserver{}, nil, fmt.Errorf("platform %q: %w", id, err)
+	}
+	o := coverageObserver{id: id, motion: m}
+	warnings := []string{}
+	for _, tm := range pd.GetTransceiverModel() {
+		switch t := tm.GetAntenna().GetFieldOfRegard().GetShapeType().(type) {
+		case nil:
+			o.cones = append(o.cones, unconstrainedCone)
+		case *commonpb.Projection_Conic_:
+			c := fieldOfRegardCone{innerHalfAngleDeg: t.Conic.GetInnerHalfAngleDeg(), outerHalfAngleDeg: 180}
+			if t.Conic.OuterHalfAngleDeg != nil {
+				c.outerHalfAngleDeg = t.Conic.GetOuterHalfAngleDeg()
+			}
+			o.cones = append(o.cones, c)
+		default:
+			warnings = append(warnings, fmt.Sprintf("the field of regard of transceiver model %s/%s has an unsupported shape %T, treating it as unconstrained", id, tm.GetId(), t))
+			o.cones = append(o.cones, unconstrainedCone)
+		}
+	}
+	if len(o.cones) == 0 {
+		o.cones = []fieldOfRegardCone{unconstrainedCone}
+	}
+	return o, warnings, nil
+}
+
+// covers reports whether the observer, at `from`, covers the point at `to`.
+func (o coverageObserver) covers(from, to [3]float64) bool {
+	boresight := [3]float64{-from[0], -from[1], -from[2]}
+	if _, ok := o.motion.(fixedMotion); ok {
+		a2, b2 := wgs84SemiMajorAxisM*wgs84SemiMajorAxisM, wgs84SemiMinorAxisM*wgs84SemiMinorAxisM
+		boresight = [3]float64{from[0] / a2, from[1] / a2, from[2] / b2}
+	}
+	los := [3]float64{to[0] - from[0], to[1] - from[1], to[2] - from[2]}
+	angleDeg := math.Acos(math.Max(-1, math.Min(1, dot(boresight, los)/(norm(boresight)*norm(los))))) * 180 / math.Pi
+	return slices.ContainsFunc(o.cones, func(c fieldOfRegardCone) bool {
+		return angleDeg >= c.innerHalfAngleDeg && angleDeg <= c.outerHalfAngleDeg
+	})
+}
+
+// computeCoverage samples the coverage of `targets` by `observers` every
+// `opts.step` and returns, for each target, the intervals during which each
+// observer covers at least one of its points, and the gaps during which
+// some of its points aren't covered by any observer.
+func computeCoverage(targets []coverageTarget, observers []coverageObserver, opts coverageOptions) (*outputv1.CoverageReport, error) {
+	report := outputv1.NewCoverageReport(opts.start.UTC(), opts.end.UTC())
+	for _, target := range targets {
+		// visibleFrom returns, for each point of the target, which observers
+		// cover it at `t`.
+		visibleFrom := func(t time.Time) ([][]bool, error) {
+			positions := make([][3]float64, len(observers))
+			for i, o := range observers {
+				var err error
+				if positions[i], err = o.motion.positionECEF(t); err != nil {
+					return nil, fmt.Errorf("platform %q: %w", o.id, err)
+				}
+			}
+			visible := make([][]bool, len(target.points))
+			for i, p := range target.points {
+				pos, err := p.positionECEF(t)
+				if err != nil {
+					return nil, fmt.Errorf("target %q: %w", target.id, err)
+				}
+				visible[i] = make([]bool, len(observers))
+				for j, o := range observers {
+					visible[i][j] = isVisible(p, pos, positions[j], opts.minElevationDeg) && isVisible(o.motion, positions[j], pos, 0) && o.covers(positions[j], pos)
+				}
+			}
+			return visible, nil
+		}
+
+		tc := outputv1.CoverageTarget{ID: target.id, Points: len(target.points), Contacts: []outputv1.CoverageContact{}, Gaps: []outputv1.ContactWindow{}}
+		for j, o := range observers {
+			windows, err := visibilityWindows(func(t time.Time) (bool, error) {
+				visible, err := visibleFrom(t)
+				if err != nil {
+					return false, err
+				}
+				return slices.ContainsFunc(visible, func(v []bool) bool { return v[j] }), nil
+			}, opts.start, opts.end, opts.step)
+			if err != nil {
+				return nil, err
+			}
+			for _, w := range windows {
+				tc.Contacts = append(tc.Contacts, outputv1.CoverageContact{
+					PlatformID:      o.id,
+					Start:           w.start.UTC(),
+					End:             w.end.UTC(),
+					DurationSeconds: w.end.Sub(w.start).Seconds(),
+				})
+			}
+		}
+		slices.SortStableFunc(tc.Contacts, func(a, b outputv1.CoverageContact) int { return a.Start.Compare(b.Start) })
+
+		covered, err := visibilityWindows(func(t time.Time) (bool, error) {
+			visible, err := visibleFrom(t)
+			if err != nil {
+				return false, err
+			}
+			for _, v := range visible {
+				if !slices.Contains(v, true) {
+					return false, nil
+				}
+			}
+			return true, nil
+		}, opts.start, opts.end, opts.step)
+		if err != nil {
+			return nil, err
+		}
+		coveredDuration := time.Duration(0)
+		gapStart := opts.start
+		for _, w := range append(covered, contactWindow{start: opts.end, end: opts.end}) {
+			coveredDuration += w.end.Sub(w.start)
+			if w.start.After(gapStart) {
+				tc.Gaps = append(tc.Gaps, outputv1.ContactWindow{Start: gapStart.UTC(), End: w.start.UTC(), DurationSeconds: w.start.Sub(gapStart).Seconds()})
+			}
+			gapStart = w.end
+		}
+		tc.CoveragePercent = 100 * coveredDuration.Seconds() / opts.end.Sub(opts.start).Seconds()
+		report.Targets = append(report.Targets, tc)
+	}
+	return report, nil
+}
+
+// readCoverageRegions reads the Polygon features of a GeoJSON file and
+// samples each on a grid with a spacing of `spacingDeg`, in degrees of
+// latitude and longitude.
+func readCoverageRegions(r io.Reader, spacingDeg float64) ([]coverageTarget, error) {
+	obj := geoJSONObject{}
+	if err := json.NewDecoder(r).Decode(&obj); err != nil {
+		return nil, fmt.Errorf("invalid GeoJSON: %w", err)
+	}
+	features := obj.Features
+	if obj.Type == "Feature" {
+		features = []geoJSONObject{obj}
+	} else if obj.Type != "FeatureCollection" {
+		return nil, fmt.Errorf("invalid GeoJSON: expected a FeatureCollection or Feature, got %q", obj.Type)
+	}
+
+	targets := []coverageTarget{}
+	for i, f := range features {
+		id := cmp.Or(geoJSONPropertyString(f.Properties["id"]), geoJSONPropertyString(f.ID), geoJSONPropertyString(f.Properties["name"]), "region-"+strconv.Itoa(i))
+		rings := [][][]float64{}
+		if f.Geometry == nil || f.Geometry.Type != "Polygon" {
+			return nil, fmt.Errorf("feature %d: only Polygon geometries are supported", i)
+		}
+		if err := json.Unmarshal(f.Geometry.Coordinates, &rings); err != nil || len(rings) == 0 || len(rings[0]) < 3 {
+			return nil, fmt.Errorf("feature %d: invalid Polygon coordinates %s", i, f.Geometry.Coordinates)
+		}
+		targets = append(targets, coverageTarget{id: id, points: samplePolygon(rings, spacingDeg)})
+	}
+	return targets, nil
+}
+
+// samplePolygon returns the points of a grid with a spacing of `spacingDeg`
+// that are inside the polygon, whose first ring is its exterior and others
+// are holes. If the polygon is too small to contain any, its vertices'
+// centroid is used instead.
+func samplePolygon(rings [][][]float64, spacingDeg float64) []platformMotion {
+	minLon, minLat, maxLon, maxLat := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	for _, p := range rings[0] {
+		minLon, maxLon = min(minLon, p[0]), max(maxLon, p[0])
+		minLat, maxLat = min(minLat, p[1]), max(maxLat, p[1])
+	}
+	points := []platformMotion{}
+	for lat := minLat + spacingDeg/2; lat < maxLat; lat += spacingDeg {
+		for lon := minLon + spacingDeg/2; lon < maxLon; lon += spacingDeg {
+			inside := ringContains(rings[0], lon, lat)
+			for _, hole := range rings[1:] {
+				inside = inside && !ringContains(hole, lon, lat)
+			}
+			if inside {
+				points = append(points, geodeticToECEF(lat, lon, 0))
+			}
+		}
+	}
+	if len(points) == 0 {
+		lon, lat := 0.0, 0.0
+		for _, p := range rings[0] {
+			lon, lat = lon+p[0], lat+p[1]
+		}
+		n := float64(len(rings[0]))
+		points = append(points, geodeticToECEF(lat/n, lon/n, 0))
+	}
+	return points
+}
+
+// ringContains reports whether the point is inside the linear ring, using
+// the even-odd rule.
+func ringContains(ring [][]float64, lon, lat float64) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a[1] > lat) != (b[1] > lat) && lon < (b[0]-a[0])*(lat-a[1])/(b[1]-a[1])+a[0] {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// writeCoverageCSV writes the contacts or the gaps of `report` as CSV.
+func writeCoverageCSV(path string, report *outputv1.CoverageReport, gaps bool) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	if gaps {
+		w.Write([]string{"target_id", "start", "end", "duration_seconds"})
+	} else {
+		w.Write([]string{"target_id", "platform_id", "start", "end", "duration_seconds"})
+	}
+	formatSeconds := func(s float64) string { return strconv.FormatFloat(s, 'f', -1, 64) }
+	for _, t := range report.Targets {
+		if gaps {
+			for _, g := range t.Gaps {
+				w.Write([]string{t.ID, g.Start.Format(time.RFC3339), g.End.Format(time.RFC3339), formatSeconds(g.DurationSeconds)})
+			}
+			continue
+		}
+		for _, c := range t.Contacts {
+			w.Write([]string{t.ID, c.PlatformID, c.Start.Format(time.RFC3339), c.End.Format(time.RFC3339), formatSeconds(c.DurationSeconds)})
+		}
+	}
+	w.Flush()
+	return errors.Join(w.Error(), f.Close())
+}
+
+// writeCoverageReport writes a summary of the coverage of each target,
+// followed by the contacts and the gaps of every target.
+func writeCoverageReport(w io.Writer, report *outputv1.CoverageReport) error {
+	seconds := func(s float64) time.Duration { return time.Duration(s * float64(time.Second)).Round(time.Second) }
+	tables := []func(*tabwriter.Writer){
+		func(tw *tabwriter.Writer) {
+			fmt.Fprintln(tw, "TARGET\tPOINTS\tCOVERAGE\tGAPS\tLONGEST GAP")
+			for _, t := range report.Targets {
+				longest := 0.0
+				for _, g := range t.Gaps {
+					longest = max(longest, g.DurationSeconds)
+				}
+				fmt.Fprintf(tw, "%s\t%d\t%.1f%%\t%d\t%s\n", t.ID, t.Points, t.CoveragePercent, len(t.Gaps), seconds(longest))
+			}
+		},
+		func(tw *tabwriter.Writer) {
+			fmt.Fprintln(tw, "TARGET\tPLATFORM\tSTART\tEND\tDURATION")
+			for _, t := range report.Targets {
+				for _, c := range t.Contacts {
+					fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", t.ID, c.PlatformID, c.Start.Format(time.RFC3339), c.End.Format(time.RFC3339), seconds(c.DurationSeconds))
+				}
+			}
+		},
+		func(tw *tabwriter.Writer) {
+			fmt.Fprintln(tw, "TARGET\tGAP START\tGAP END\tDURATION")
+			for _, t := range report.Targets {
+				for _, g := range t.Gaps {
+					fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", t.ID, g.Start.Format(time.RFC3339), g.End.Format(time.RFC3339), seconds(g.DurationSeconds))
+				}
+			}
+		},
+	}
+	for i, table := range tables {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		table(tw)
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// coverageTargetsFromFlags returns the targets selected by the
+// --target_platform_id, --sites, and --regions flags.
+func coverageTargetsFromFlags(appCtx *cli.Context, platforms map[string]*commonpb.PlatformDefinition) ([]coverageTarget, error) {
+	targets := []coverageTarget{}
+	addPlatform := func(id string, pd *commonpb.PlatformDefinition) error {
+		m, err := motionFromProto(pd.GetCoordinates())
+		if err != nil {
+			return fmt.Errorf("target %q: %w", id, err)
+		}
+		targets = append(targets, coverageTarget{id: id, points: []platformMotion{m}})
+		return nil
+	}
+	for _, id := range appCtx.StringSlice("target_platform_id") {
+		pd, ok := platforms[id]
+		if !ok {
+			return nil, fmt.Errorf("no PlatformDefinition with ID %q found in %s", id, appCtx.String("files"))
+		}
+		if err := addPlatform(id, pd); err != nil {
+			return nil, err
+		}
+	}
+
+	if path := appCtx.Path("sites"); path != "" {
+		format, err := importFormat(path)
+		if err != nil {
+			return nil, err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		sites, err := ImportSites(f, ImportOptions{Format: format})
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+		for _, e := range sites {
+			if e.GetGroup().GetType() != nbipb.EntityType_PLATFORM_DEFINITION {
+				continue
+			}
+			if err := addPlatform(e.GetId(), e.GetPlatform()); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if path := appCtx.Path("regions"); path != "" {
+		spacingDeg := appCtx.Float64("grid_spacing_deg")
+		if spacingDeg <= 0 {
+			return nil, errors.New("--grid_spacing_deg must be positive")
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		regions, err := readCoverageRegions(f, spacingDeg)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+		targets = append(targets, regions...)
+	}
+
+	if len(targets) == 0 {
+		return nil, errors.New("at least one of --target_platform_id, --sites, or --regions is required")
+	}
+	seen := map[string]bool{}
+	for _, t := range targets {
+		if seen[t.id] {
+			return nil, fmt.Errorf("duplicate target ID %q", t.id)
+		}
+		seen[t.id] = true
+	}
+	return targets, nil
+}
+
+func AnalyzeCoverage(appCtx *cli.Context) error {
+	entities, err := readEntitiesFromFiles(appCtx.String("files"))
+	if err != nil {
+		return err
+	}
+	platforms := map[string]*commonpb.PlatformDefinition{}
+	for _, e := range entities {
+		if e.GetGroup().GetType() == nbipb.EntityType_PLATFORM_DEFINITION {
+			platforms[e.GetId()] = e.GetPlatform()
+		}
+	}
+	targets, err := coverageTargetsFromFlags(appCtx, platforms)
+	if err != nil {
+		return err
+	}
+
+	// Unless they're listed explicitly, every platform with a transceiver
+	// that isn't a target covers the targets, and those that can't be
+	// propagated locally are skipped.
+	ids := appCtx.StringSlice("platform_id")
+	explicit := len(ids) > 0
+	if !explicit {
+		for _, id := range slices.Sorted(maps.Keys(platforms)) {
+			if len(platforms[id].GetTransceiverModel()) > 0 && !slices.ContainsFunc(targets, func(t coverageTarget) bool { return t.id == id }) {
+				ids = append(ids, id)
+			}
+		}
+	}
+	observers := []coverageObserver{}
+	for _, id := range ids {
+		pd, ok := platforms[id]
+		if !ok {
+			return fmt.Errorf("no PlatformDefinition with ID %q found in %s", id, appCtx.String("files"))
+		}
+		o, warnings, err := newCoverageObserver(id, pd)
+		if err != nil && explicit {
+			return err
+		} else if err != nil {
+			fmt.Fprintf(appCtx.App.ErrWriter, "skipping platform %s: %v\n", id, err)
+			continue
+		}
+		for _, w := range warnings {
+			fmt.Fprintf(appCtx.App.ErrWriter, "warning: %s\n", w)
+		}
+		observers = append(observers, o)
+	}
+	if len(observers) == 0 {
+		return errors.New("no platforms to cover the targets with, use --platform_id")
+	}
+
+	opts := coverageOptions{start: time.Now(), step: appCtx.Duration("step_size"), minElevationDeg: appCtx.Float64("min_elevation_deg")}
+	if ts := appCtx.Timestamp("start_timestamp"); ts != nil {
+		opts.start = *ts
+	}
+	opts.end = opts.start.Add(defaultCoverageDuration)
+	if ts := appCtx.Timestamp("end_timestamp"); ts != nil {
+		opts.end = *ts
+	}
+	if !opts.end.After(opts.start) {
+		return errors.New("--end_timestamp must be after --start_timestamp")
+	}
+	if opts.step <= 0 {
+		return errors.New("--step_size must be positive")
+	}
+
+	report, err := computeCoverage(targets, observers, opts)
+	if err != nil {
+		return err
+	}
+	if path := appCtx.Path("contacts_csv"); path != "" {
+		if err := writeCoverageCSV(path, report, false); err != nil {
+			return err
+		}
+	}
+	if path := appCtx.Path("gaps_csv"); path != "" {
+		if err := writeCoverageCSV(path, report, true); err != nil {
+			return err
+		}
+	}
+	if jsonOutputRequested(appCtx) {
+		return writeJSONOutput(appCtx, report)
+	}
+	return writeCoverageReport(appCtx.App.Writer, report)
+}
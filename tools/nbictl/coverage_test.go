@@ -0,0 +1,220 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/rules_go/go/tools/bazel"
+	"github.com/google/go-cmp/cmp"
+
+	outputv1 "aalyria.com/spacetime/github/tools/nbictl/output/v1"
+)
+
+const coverageTestPlatforms = `
+entity {
+  group { type: PLATFORM_DEFINITION }
+  id: "iss"
+  platform {
+    coordinates {
+      tle {
+        line1: "1 25544U 98067A   08264.51782528 -.00002182  00000-0 -11606-4 0  2927"
+        line2: "2 25544  51.6416 247.4627 0006703 130.5360 325.0288 15.72125391563537"
+      }
+    }
+    transceiver_model {
+      id: "camera"
+      antenna { field_of_regard { conic { outer_half_angle_deg: 50 } } }
+    }
+    transceiver_model {
+      id: "scanner"
+      antenna { field_of_regard { rectangular { x_half_angle_deg: 10 y_half_angle_deg: 20 } } }
+    }
+  }
+}
+entity {
+  group { type: PLATFORM_DEFINITION }
+  id: "houston"
+  platform {
+    coordinates { geodetic_wgs84 { latitude_deg: 29.5593 longitude_deg: -95.09 height_wgs84_m: 10 } }
+  }
+}
+`
+
+// coverageTestRegions has a 10 degree square around Kansas with a hole in
+// its middle, and a triangle smaller than the grid spacing.
+const coverageTestRegions = `{
+  "type": "FeatureCollection",
+  "features": [
+    {
+      "type": "Feature",
+      "properties": {"name": "kansas"},
+      "geometry": {"type": "Polygon", "coordinates": [
+        [[-105, 35], [-95, 35], [-95, 45], [-105, 45], [-105, 35]],
+        [[-101, 39], [-99, 39], [-99, 41], [-101, 41], [-101, 39]]
+      ]}
+    },
+    {
+      "type": "Feature",
+      "id": 7,
+      "geometry": {"type": "Polygon", "coordinates": [[[0, 0], [0.1, 0], [0.1, 0.1], [0, 0]]]}
+    }
+  ]
+}`
+
+var coverageTestStart = time.Date(2008, time.September, 20, 11, 0, 0, 0, time.UTC)
+
+func TestComputeCoverage_matchesContactWindows(t *testing.T) {
+	t.Parallel()
+
+	iss, err := parseTLE(issTLELine1, issTLELine2)
+	checkErr(t, err)
+	site := geodeticToECEF(40, -100, 200)
+	end := coverageTestStart.Add(24 * time.Hour)
+	want, err := contactWindows(site, iss, coverageTestStart, end, time.Minute, 0)
+	checkErr(t, err)
+
+	report, err := computeCoverage(
+		[]coverageTarget{{id: "site", points: []platformMotion{site}}},
+		[]coverageObserver{{id: "iss", motion: iss, cones: []fieldOfRegardCone{unconstrainedCone}}},
+		coverageOptions{start: coverageTestStart, end: end, step: time.Minute},
+	)
+	checkErr(t, err)
+
+	target := report.Targets[0]
+	got := []contactWindow{}
+	for _, c := range target.Contacts {
+		got = append(got, contactWindow{start: c.Start, end: c.End})
+	}
+	if diff := cmp.Diff(want, got, cmp.AllowUnexported(contactWindow{})); diff != "" {
+		t.Errorf("contacts mismatch (-want +got):\n%s", diff)
+	}
+	// With a single observer, the gaps are the time between its contacts.
+	if len(target.Gaps) != len(want)+1 {
+		t.Fatalf("expected %d gaps around %d contacts, got %d", len(want)+1, len(want), len(target.Gaps))
+	}
+	for i, g := range target.Gaps[1:] {
+		if !g.Start.Equal(want[i].end) {
+			t.Errorf("expected gap %d to start at the end of contact %d (%s), got %s", i+1, i, want[i].end, g.Start)
+		}
+	}
+	gapSeconds := 0.0
+	for _, g := range target.Gaps {
+		gapSeconds += g.DurationSeconds
+	}
+	if coverage := 100 * (1 - gapSeconds/end.Sub(coverageTestStart).Seconds()); coverage-target.CoveragePercent > 1e-6 || target.CoveragePercent-coverage > 1e-6 {
+		t.Errorf("expected a coverage of %v%%, got %v%%", coverage, target.CoveragePercent)
+	}
+}
+
+func TestComputeCoverage_fieldOfRegardNarrowsContacts(t *testing.T) {
+	t.Parallel()
+
+	iss, err := parseTLE(issTLELine1, issTLELine2)
+	checkErr(t, err)
+	report, err := computeCoverage(
+		[]coverageTarget{{id: "site", points: []platformMotion{geodeticToECEF(40, -100, 200)}}},
+		[]coverageObserver{
+			{id: "wide", motion: iss, cones: []fieldOfRegardCone{unconstrainedCone}},
+			{id: "narrow", motion: iss, cones: []fieldOfRegardCone{{outerHalfAngleDeg: 50}}},
+		},
+		coverageOptions{start: coverageTestStart, end: coverageTestStart.Add(24 * time.Hour), step: time.Minute},
+	)
+	checkErr(t, err)
+
+	total := map[string]float64{}
+	for _, c := range report.Targets[0].Contacts {
+		total[c.PlatformID] += c.DurationSeconds
+	}
+	if total["narrow"] == 0 || total["narrow"] >= total["wide"] {
+		t.Errorf("expected the 50 degree field of regard to shorten the contacts, got %v", total)
+	}
+}
+
+func TestReadCoverageRegions(t *testing.T) {
+	t.Parallel()
+
+	regions, err := readCoverageRegions(strings.NewReader(coverageTestRegions), 2)
+	checkErr(t, err)
+	got := map[string]int{}
+	for _, r := range regions {
+		got[r.id] = len(r.points)
+	}
+	// The 5x5 grid of the square, less the point in the hole, and the
+	// centroid of the triangle.
+	if diff := cmp.Diff(map[string]int{"kansas": 24, "7": 1}, got); diff != "" {
+		t.Errorf("regions mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := readCoverageRegions(strings.NewReader(`{"type": "Feature", "geometry": {"type": "Point", "coordinates": [0, 0]}}`), 1); err == nil || !strings.Contains(err.Error(), "only Polygon geometries are supported") {
+		t.Errorf("expected an error about the geometry, got %v", err)
+	}
+}
+
+func TestAnalyzeCoverage(t *testing.T) {
+	t.Parallel()
+
+	dir, err := bazel.NewTmpDir("nbictl")
+	checkErr(t, err)
+	files := filepath.Join(dir, "platforms.textproto")
+	checkErr(t, os.WriteFile(files, []byte(coverageTestPlatforms), 0o644))
+	sites := filepath.Join(dir, "sites.csv")
+	checkErr(t, os.WriteFile(sites, []byte("id,name,lat,lon\nwichita,Wichita,37.69,-97.34\n"), 0o644))
+	regions := filepath.Join(dir, "regions.geojson")
+	checkErr(t, os.WriteFile(regions, []byte(coverageTestRegions), 0o644))
+	gapsCSV := filepath.Join(dir, "gaps.csv")
+
+	app := newTestApp()
+	checkErr(t, app.Run([]string{
+		"nbictl", "coverage", "--files", files,
+		"--target_platform_id", "houston", "--sites", sites, "--regions", regions, "--grid_spacing_deg", "2",
+		"--start_timestamp", "2008-09-20T11:00:00Z", "--end_timestamp", "2008-09-21T11:00:00Z",
+		"--gaps_csv", gapsCSV, "--output", "json",
+	}))
+
+	report := &outputv1.CoverageReport{}
+	checkErr(t, json.Unmarshal(app.stdout.Bytes(), report))
+	ids := []string{}
+	for _, target := range report.Targets {
+		ids = append(ids, target.ID)
+		for _, c := range target.Contacts {
+			if c.PlatformID != "iss" {
+				t.Errorf("unexpected contact with %s", c.PlatformID)
+			}
+		}
+	}
+	if diff := cmp.Diff([]string{"houston", "wichita", "kansas", "7"}, ids); diff != "" {
+		t.Errorf("targets mismatch (-want +got):\n%s", diff)
+	}
+	if !strings.Contains(app.stderr.String(), "warning: the field of regard of transceiver model iss/scanner has an unsupported shape") {
+		t.Errorf("expected a warning about the rectangular field of regard, got %q", app.stderr.String())
+	}
+
+	data, err := os.ReadFile(gapsCSV)
+	checkErr(t, err)
+	if !strings.HasPrefix(string(data), "target_id,start,end,duration_seconds\nhouston,") {
+		t.Errorf("unexpected gaps CSV:\n%s", data)
+	}
+
+	app = newTestApp()
+	if err := app.Run([]string{"nbictl", "coverage", "--files", files}); err == nil || !strings.Contains(err.Error(), "at least one of --target_platform_id, --sites, or --regions is required") {
+		t.Errorf("expected an error about the missing targets, got %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jonboulle/clockwork"
+	"github.com/urfave/cli/v2"
+
+	"aalyria.com/spacetime/auth"
+)
+
+// CreateToken prints a JWT signed with the key of the selected context,
+// without connecting to the server. It's meant for scripts and tools like
+// grpcurl that need a token of their own.
+func CreateToken(appCtx *cli.Context) error {
+	lifetime := appCtx.Duration("lifetime")
+	if lifetime <= 0 {
+		return fmt.Errorf("--lifetime must be positive, got %v", lifetime)
+	}
+
+	confFile, err := getConfFileForContext(appCtx)
+	if err != nil {
+		return err
+	}
+	setting, err := readConfig(appCtx.String("context"), confFile)
+	if err != nil {
+		return fmt.Errorf("unable to obtain context information: %w", err)
+	}
+	host, err := tokenAudience(setting)
+	if err != nil {
+		return err
+	}
+	if host == "" {
+		return errors.New("no url set for chosen context, which is the audience of the token")
+	}
+
+	config, release, err := signingConfig(appCtx.Context, setting, nil, clockwork.NewRealClock(), host)
+	if err != nil {
+		return err
+	}
+	defer release()
+	config.Lifetime = lifetime
+
+	ts, err := auth.NewSpacetimeTokenSource(appCtx.Context, config)
+	if err != nil {
+		return fmt.Errorf("unable to sign the token: %w", err)
+	}
+	token, err := ts.Token(appCtx.Context)
+	if err != nil {
+		return fmt.Errorf("unable to sign the token: %w", err)
+	}
+	_, err = fmt.Fprintln(appCtx.App.Writer, token)
+	return err
+}
@@ -0,0 +1,120 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/rules_go/go/tools/bazel"
+)
+
+func TestCreateToken(t *testing.T) {
+	t.Parallel()
+
+	dir, err := bazel.NewTmpDir("nbictl")
+	checkErr(t, err)
+	keys := generateKeysForTesting(t, dir, "--org", exampleCertOrganization)
+	checkErr(t, newTestApp().Run([]string{
+		"nbictl", "--config_dir", dir,
+		"set-config",
+		"--user_id", "usr1",
+		"--key_id", "key1",
+		"--priv_key", keys.key,
+		"--url", "spacetime.example.com:443",
+	}))
+
+	app := newTestApp()
+	checkErr(t, app.Run([]string{"nbictl", "--config_dir", dir, "create-token", "--lifetime", "10m"}))
+
+	parts := strings.Split(strings.TrimSpace(app.stdout.String()), ".")
+	if len(parts) != 3 {
+		t.Fatalf("expected a JWT, got %q", app.stdout.String())
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	checkErr(t, err)
+	claims := struct {
+		Aud string `json:"aud"`
+		Iss string `json:"iss"`
+		Kid string `json:"kid"`
+		Exp int64  `json:"exp"`
+		Iat int64  `json:"iat"`
+	}{}
+	checkErr(t, json.Unmarshal(payload, &claims))
+
+	switch {
+	case claims.Aud != "spacetime.example.com:443":
+		t.Errorf("expected audience %q, got %q", "spacetime.example.com:443", claims.Aud)
+	case claims.Iss != "usr1":
+		t.Errorf("expected issuer %q, got %q", "usr1", claims.Iss)
+	case claims.Kid != "key1":
+		t.Errorf("expected key ID %q, got %q", "key1", claims.Kid)
+	case claims.Exp-claims.Iat != 600:
+		t.Errorf("expected the token to be valid for 600s, got %ds", claims.Exp-claims.Iat)
+	}
+}
+
+func TestCreateToken_configuredClaims(t *testing.T) {
+	t.Parallel()
+
+	dir, err := bazel.NewTmpDir("nbictl")
+	checkErr(t, err)
+	keys := generateKeysForTesting(t, dir, "--org", exampleCertOrganization)
+	checkErr(t, newTestApp().Run([]string{
+		"nbictl", "--config_dir", dir,
+		"set-config",
+		"--user_id", "usr1",
+		"--key_id", "key1",
+		"--priv_key", keys.key,
+		"--url", "spacetime.example.com:443",
+		"--audience", "aud1",
+		"--issuer", "https://issuer.example.com",
+		"--scopes", "read",
+		"--scopes", "write",
+	}))
+
+	app := newTestApp()
+	checkErr(t, app.Run([]string{"nbictl", "--config_dir", dir, "create-token"}))
+
+	parts := strings.Split(strings.TrimSpace(app.stdout.String()), ".")
+	if len(parts) != 3 {
+		t.Fatalf("expected a JWT, got %q", app.stdout.String())
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	checkErr(t, err)
+	claims := struct {
+		Aud   string `json:"aud"`
+		Iss   string `json:"iss"`
+		Sub   string `json:"sub"`
+		Scope string `json:"scope"`
+	}{}
+	checkErr(t, json.Unmarshal(payload, &claims))
+
+	want := struct{ Aud, Iss, Sub, Scope string }{"aud1", "https://issuer.example.com", "usr1", "read write"}
+	if got := (struct{ Aud, Iss, Sub, Scope string }{claims.Aud, claims.Iss, claims.Sub, claims.Scope}); got != want {
+		t.Errorf("expected claims %+v, got %+v", want, got)
+	}
+}
+
+func TestCreateToken_invalidLifetime(t *testing.T) {
+	t.Parallel()
+
+	err := newTestApp().Run([]string{"nbictl", "--config_dir", t.TempDir(), "create-token", "--lifetime", "0s"})
+	if err == nil || !strings.Contains(err.Error(), "--lifetime must be positive") {
+		t.Errorf("expected an invalid lifetime error, got %v", err)
+	}
+}
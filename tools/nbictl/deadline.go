@@ -0,0 +1,102 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+var (
+	timeoutFlag = &cli.DurationFlag{
+		Name:  "timeout",
+		Usage: "Maximum time the command may take before it's canceled. Defaults to the default_timeout of the configuration profile, if it has one. Use 0 for no limit.",
+	}
+	deadlineFlag = &cli.TimestampFlag{
+		Name:   "deadline",
+		Layout: time.RFC3339,
+		Usage:  "An RFC3339 formatted timestamp by which the command must finish, after which it's canceled. Exclusive with --timeout.",
+	}
+)
+
+// deadlineCancelKey is the context key of the function that releases the
+// resources of the deadline set by applyDeadline.
+type deadlineCancelKey struct{}
+
+// applyDeadline is the app's Before hook. It bounds the context every command
+// runs with by the deadline given by the --timeout or --deadline flags, or
+// by the timeout of the configuration profile, so that no command waits
+// forever on an unresponsive server.
+func applyDeadline(appCtx *cli.Context) error {
+	if appCtx.Args().First() == "shell" {
+		// Each command run in the shell gets a deadline of its own.
+		return nil
+	}
+	deadline, err := commandDeadline(appCtx, time.Now())
+	if err != nil || deadline.IsZero() {
+		return err
+	}
+
+	ctx, cancel := context.WithDeadline(appCtx.Context, deadline)
+	appCtx.Context = context.WithValue(ctx, deadlineCancelKey{}, cancel)
+	return nil
+}
+
+// releaseDeadline is the app's After hook, which undoes applyDeadline.
+func releaseDeadline(appCtx *cli.Context) error {
+	if cancel, ok := appCtx.Context.Value(deadlineCancelKey{}).(context.CancelFunc); ok {
+		cancel()
+	}
+	return nil
+}
+
+// commandDeadline returns the deadline of a command started at `now`, or the
+// zero time if it has none.
+func commandDeadline(appCtx *cli.Context, now time.Time) (time.Time, error) {
+	switch {
+	case appCtx.IsSet(timeoutFlag.Name) && appCtx.IsSet(deadlineFlag.Name):
+		return time.Time{}, errors.New("only one of --timeout and --deadline can be set")
+	case appCtx.IsSet(deadlineFlag.Name):
+		return *appCtx.Timestamp(deadlineFlag.Name), nil
+	case appCtx.IsSet(timeoutFlag.Name):
+		return deadlineAfter(now, appCtx.Duration(timeoutFlag.Name), "--timeout")
+	}
+
+	// A missing or ambiguous profile isn't an error here; commands that need
+	// a connection will report it themselves.
+	confFile, err := getConfFileForContext(appCtx)
+	if err != nil {
+		return time.Time{}, nil
+	}
+	conf, err := readConfig(appCtx.String("context"), confFile)
+	if err != nil || conf.GetTimeout() == nil {
+		return time.Time{}, nil
+	}
+	return deadlineAfter(now, conf.GetTimeout().AsDuration(), fmt.Sprintf("the default_timeout of context %q", conf.GetName()))
+}
+
+func deadlineAfter(now time.Time, timeout time.Duration, source string) (time.Time, error) {
+	switch {
+	case timeout < 0:
+		return time.Time{}, fmt.Errorf("%s can't be negative, got %v", source, timeout)
+	case timeout == 0:
+		return time.Time{}, nil
+	}
+	return now.Add(timeout), nil
+}
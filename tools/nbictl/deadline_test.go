@@ -0,0 +1,104 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/rules_go/go/tools/bazel"
+	"github.com/urfave/cli/v2"
+)
+
+// runWithDeadline runs a command that records the deadline of its context
+// with the provided global flags, and returns the deadline.
+func runWithDeadline(t *testing.T, globalArgs ...string) (deadline time.Time, ok bool, err error) {
+	t.Helper()
+
+	app := newTestApp()
+	app.Commands = append(app.Commands, &cli.Command{
+		Name:   "record-deadline",
+		Hidden: true,
+		Action: func(appCtx *cli.Context) error {
+			deadline, ok = appCtx.Context.Deadline()
+			return nil
+		},
+	})
+	err = app.Run(append(append([]string{"nbictl"}, globalArgs...), "record-deadline"))
+	return deadline, ok, err
+}
+
+func TestDeadline(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := bazel.NewTmpDir("nbictl")
+	checkErr(t, err)
+
+	if _, ok, err := runWithDeadline(t, "--config_dir", tmpDir); err != nil || ok {
+		t.Errorf("command without a timeout got a deadline (%v, err: %v), want none", ok, err)
+	}
+
+	start := time.Now()
+	deadline, ok, err := runWithDeadline(t, "--config_dir", tmpDir, "--timeout", "1h")
+	checkErr(t, err)
+	if !ok || deadline.Before(start.Add(time.Hour)) || deadline.After(time.Now().Add(time.Hour)) {
+		t.Errorf("command with --timeout=1h got deadline %v (%v), want an hour after it started", deadline, ok)
+	}
+
+	want := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	deadline, ok, err = runWithDeadline(t, "--config_dir", tmpDir, "--deadline", want.Format(time.RFC3339))
+	checkErr(t, err)
+	if !ok || !deadline.Equal(want) {
+		t.Errorf("command with --deadline got deadline %v (%v), want %v", deadline, ok, want)
+	}
+
+	for _, args := range [][]string{
+		{"--timeout", "1h", "--deadline", want.Format(time.RFC3339)},
+		{"--timeout", "-1s"},
+	} {
+		if _, _, err := runWithDeadline(t, append([]string{"--config_dir", tmpDir}, args...)...); err == nil {
+			t.Errorf("command with %v succeeded, want an error", args)
+		}
+	}
+}
+
+func TestDeadline_fromConfig(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := bazel.NewTmpDir("nbictl")
+	checkErr(t, err)
+
+	setConfigApp := newTestApp()
+	checkErr(t, setConfigApp.Run([]string{
+		"nbictl", "--config_dir", tmpDir,
+		"set-config", "--url", "example.com", "--default_timeout", "1m",
+	}))
+	if want := "timeout:"; !strings.Contains(setConfigApp.stdout.String(), want) {
+		t.Errorf("set-config printed %q, want it to contain %q", setConfigApp.stdout.String(), want)
+	}
+
+	start := time.Now()
+	deadline, ok, err := runWithDeadline(t, "--config_dir", tmpDir)
+	checkErr(t, err)
+	if !ok || deadline.Before(start.Add(time.Minute)) || deadline.After(time.Now().Add(time.Minute)) {
+		t.Errorf("command with a default_timeout of 1m got deadline %v (%v), want a minute after it started", deadline, ok)
+	}
+
+	// The flags take precedence over the profile.
+	if _, ok, err := runWithDeadline(t, "--config_dir", tmpDir, "--timeout", "0"); err != nil || ok {
+		t.Errorf("command with --timeout=0 got a deadline (%v, err: %v), want none", ok, err)
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+)
+
+// entityDependencies returns, for each of `entities`, the indices of the
+// other entities in the slice that must be processed before it. When
+// creating or updating entities, an entity depends on the entities it
+// references, so they exist by the time it's stored. When deleting them,
+// `reverse` is set and an entity depends on the entities that reference it
+// instead, so that references are removed before their targets.
+//
+// References to entities that aren't in the slice are ignored, since they're
+// assumed to already be stored.
+func entityDependencies(entities []*nbipb.Entity, reverse bool) [][]int {
+	index := map[entityRef]int{}
+	for i, e := range entities {
+		index[entityRef{entityType: e.GetGroup().GetType(), id: e.GetId()}] = i
+	}
+
+	deps := make([][]int, len(entities))
+	seen := map[[2]int]bool{}
+	for i, e := range entities {
+		for _, ref := range collectReferences(e.ProtoReflect()) {
+			j, ok := index[ref]
+			if !ok || j == i {
+				continue
+			}
+			from, to := i, j
+			if reverse {
+				from, to = j, i
+			}
+			if seen[[2]int{from, to}] {
+				continue
+			}
+			seen[[2]int{from, to}] = true
+			deps[from] = append(deps[from], to)
+		}
+	}
+	return deps
+}
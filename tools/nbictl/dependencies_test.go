@@ -0,0 +1,42 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/encoding/prototext"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+)
+
+func TestEntityDependencies(t *testing.T) {
+	t.Parallel()
+
+	txtpb := &nbipb.TxtpbEntities{}
+	checkErr(t, prototext.Unmarshal([]byte(joinTestNodes+joinTestPlatforms), txtpb))
+	// node-a, node-b, sat, gs
+	entities := txtpb.GetEntity()
+
+	// node-a references sat (twice) and gs. node-b references a platform
+	// that isn't being processed.
+	if diff := cmp.Diff([][]int{{2, 3}, nil, nil, nil}, entityDependencies(entities, false)); diff != "" {
+		t.Errorf("unexpected dependencies (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([][]int{nil, nil, {0}, {0}}, entityDependencies(entities, true)); diff != "" {
+		t.Errorf("unexpected reverse dependencies (-want +got):\n%s", diff)
+	}
+}
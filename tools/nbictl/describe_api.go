@@ -0,0 +1,279 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/fullstorydev/grpcurl"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/urfave/cli/v2"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func DescribeAPI(appCtx *cli.Context) error {
+	if ln := appCtx.Args().Len(); ln > 1 {
+		return fmt.Errorf("describe-api expects at most 1 argument, got %d", ln)
+	}
+
+	var src grpcurl.DescriptorSource
+	switch {
+	case appCtx.IsSet("protoset"):
+		s, err := grpcurl.DescriptorSourceFromProtoSets(appCtx.StringSlice("protoset")...)
+		if err != nil {
+			return err
+		}
+		src = s
+	case appCtx.Bool("offline"):
+		s, err := bundledDescriptorSource()
+		if err != nil {
+			return err
+		}
+		src = s
+	default:
+		conn, err := openConnection(appCtx)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		s, cleanup, err := newInvokeDescriptorSource(appCtx.Context, conn)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		src = s
+	}
+
+	w := appCtx.App.Writer
+	if appCtx.Args().Len() == 0 {
+		return describeAPIServices(w, src)
+	}
+	dsc, err := resolveAPISymbol(src, appCtx.Args().First())
+	if err != nil {
+		return err
+	}
+	return describeAPIDescriptor(w, dsc)
+}
+
+// describeAPIServices lists the services in `src` along with the first line
+// of their comments, if the descriptors include them.
+func describeAPIServices(w io.Writer, src grpcurl.DescriptorSource) error {
+	svcs, err := src.ListServices()
+	if err != nil {
+		return err
+	}
+	sort.Strings(svcs)
+	for _, name := range svcs {
+		line := name
+		if dsc, err := src.FindSymbol(name); err == nil {
+			if summary, _, _ := strings.Cut(strings.TrimSpace(dsc.GetSourceInfo().GetLeadingComments()), "\n"); summary != "" {
+				line += "  // " + summary
+			}
+		}
+		fmt.Fprintln(w, line)
+	}
+	return nil
+}
+
+// resolveAPISymbol finds the descriptor of `symbol`, which is either fully
+// qualified or the unqualified name of a single message, enum or service,
+// like "NetworkNode".
+func resolveAPISymbol(src grpcurl.DescriptorSource, symbol string) (desc.Descriptor, error) {
+	dsc, err := src.FindSymbol(symbol)
+	if err == nil || strings.Contains(symbol, ".") {
+		return dsc, err
+	}
+
+	files, filesErr := grpcurl.GetAllFiles(src)
+	if filesErr != nil {
+		return nil, err
+	}
+	matches := map[string]desc.Descriptor{}
+	var visit func(desc.Descriptor)
+	visit = func(d desc.Descriptor) {
+		if d.GetName() == symbol {
+			matches[d.GetFullyQualifiedName()] = d
+		}
+		if md, ok := d.(*desc.MessageDescriptor); ok {
+			for _, nested := range md.GetNestedMessageTypes() {
+				visit(nested)
+			}
+			for _, nested := range md.GetNestedEnumTypes() {
+				visit(nested)
+			}
+		}
+	}
+	for _, fd := range files {
+		for _, md := range fd.GetMessageTypes() {
+			visit(md)
+		}
+		for _, ed := range fd.GetEnumTypes() {
+			visit(ed)
+		}
+		for _, sd := range fd.GetServices() {
+			visit(sd)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, err
+	case 1:
+		for _, d := range matches {
+			return d, nil
+		}
+	}
+	names := make([]string, 0, len(matches))
+	for name := range matches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("%q is ambiguous, use one of: %s", symbol, strings.Join(names, ", "))
+}
+
+// describeAPIDescriptor writes `dsc` in a form resembling its .proto
+// definition, with comments if the descriptors include them.
+func describeAPIDescriptor(w io.Writer, dsc desc.Descriptor) error {
+	switch d := dsc.(type) {
+	case *desc.MessageDescriptor:
+		writeAPIMessage(w, "", d.GetFullyQualifiedName(), d)
+	case *desc.EnumDescriptor:
+		writeAPIEnum(w, "", d.GetFullyQualifiedName(), d)
+	case *desc.ServiceDescriptor:
+		writeAPIComments(w, "", d.GetSourceInfo())
+		fmt.Fprintf(w, "service %s {\n", d.GetFullyQualifiedName())
+		for _, m := range d.GetMethods() {
+			writeAPIMethod(w, "  ", m)
+		}
+		fmt.Fprintln(w, "}")
+	case *desc.MethodDescriptor:
+		fmt.Fprintf(w, "// In service %s.\n", d.GetService().GetFullyQualifiedName())
+		writeAPIMethod(w, "", d)
+	case *desc.FieldDescriptor:
+		fmt.Fprintf(w, "// In message %s.\n", d.GetOwner().GetFullyQualifiedName())
+		writeAPIField(w, "", d)
+	case *desc.EnumValueDescriptor:
+		fmt.Fprintf(w, "// In enum %s.\n", d.GetEnum().GetFullyQualifiedName())
+		writeAPIComments(w, "", d.GetSourceInfo())
+		fmt.Fprintf(w, "%s = %d;\n", d.GetName(), d.GetNumber())
+	default:
+		return fmt.Errorf("%s is a %T, which can't be described", dsc.GetFullyQualifiedName(), dsc)
+	}
+	return nil
+}
+
+func writeAPIMessage(w io.Writer, indent, name string, md *desc.MessageDescriptor) {
+	writeAPIComments(w, indent, md.GetSourceInfo())
+	fmt.Fprintf(w, "%smessage %s {\n", indent, name)
+	inner := indent + "  "
+
+	written := map[*desc.OneOfDescriptor]bool{}
+	for _, fd := range md.GetFields() {
+		oo := fd.GetOneOf()
+		if oo == nil || oo.IsSynthetic() {
+			writeAPIField(w, inner, fd)
+			continue
+		}
+		if written[oo] {
+			continue
+		}
+		written[oo] = true
+		writeAPIComments(w, inner, oo.GetSourceInfo())
+		fmt.Fprintf(w, "%soneof %s {\n", inner, oo.GetName())
+		for _, choice := range oo.GetChoices() {
+			writeAPIField(w, inner+"  ", choice)
+		}
+		fmt.Fprintf(w, "%s}\n", inner)
+	}
+	for _, ed := range md.GetNestedEnumTypes() {
+		writeAPIEnum(w, inner, ed.GetName(), ed)
+	}
+	for _, nested := range md.GetNestedMessageTypes() {
+		if !nested.IsMapEntry() {
+			writeAPIMessage(w, inner, nested.GetName(), nested)
+		}
+	}
+	fmt.Fprintf(w, "%s}\n", indent)
+}
+
+func writeAPIEnum(w io.Writer, indent, name string, ed *desc.EnumDescriptor) {
+	writeAPIComments(w, indent, ed.GetSourceInfo())
+	fmt.Fprintf(w, "%senum %s {\n", indent, name)
+	for _, v := range ed.GetValues() {
+		writeAPIComments(w, indent+"  ", v.GetSourceInfo())
+		fmt.Fprintf(w, "%s  %s = %d;\n", indent, v.GetName(), v.GetNumber())
+	}
+	fmt.Fprintf(w, "%s}\n", indent)
+}
+
+func writeAPIMethod(w io.Writer, indent string, m *desc.MethodDescriptor) {
+	stream := func(s bool) string {
+		if s {
+			return "stream "
+		}
+		return ""
+	}
+	writeAPIComments(w, indent, m.GetSourceInfo())
+	fmt.Fprintf(w, "%srpc %s(%s%s) returns (%s%s);\n", indent, m.GetName(),
+		stream(m.IsClientStreaming()), m.GetInputType().GetFullyQualifiedName(),
+		stream(m.IsServerStreaming()), m.GetOutputType().GetFullyQualifiedName())
+}
+
+func writeAPIField(w io.Writer, indent string, fd *desc.FieldDescriptor) {
+	label := ""
+	switch {
+	case fd.IsMap() || fd.GetOneOf() != nil && !fd.GetOneOf().IsSynthetic():
+	case fd.IsRepeated():
+		label = "repeated "
+	case fd.IsRequired():
+		label = "required "
+	case fd.IsProto3Optional() || !fd.GetFile().IsProto3():
+		label = "optional "
+	}
+	writeAPIComments(w, indent, fd.GetSourceInfo())
+	fmt.Fprintf(w, "%s%s%s %s = %d;\n", indent, label, apiFieldType(fd), fd.GetName(), fd.GetNumber())
+}
+
+// apiFieldType returns the type of `fd` as it's written in .proto files,
+// with message and enum types fully qualified.
+func apiFieldType(fd *desc.FieldDescriptor) string {
+	if fd.IsMap() {
+		return fmt.Sprintf("map<%s, %s>", apiFieldType(fd.GetMapKeyType()), apiFieldType(fd.GetMapValueType()))
+	}
+	switch fd.GetType() {
+	case descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, descriptorpb.FieldDescriptorProto_TYPE_GROUP:
+		return fd.GetMessageType().GetFullyQualifiedName()
+	case descriptorpb.FieldDescriptorProto_TYPE_ENUM:
+		return fd.GetEnumType().GetFullyQualifiedName()
+	default:
+		return strings.ToLower(strings.TrimPrefix(fd.GetType().String(), "TYPE_"))
+	}
+}
+
+// writeAPIComments writes the comments attached to a definition, which are
+// only available if the descriptors were built with source info.
+func writeAPIComments(w io.Writer, indent string, loc *descriptorpb.SourceCodeInfo_Location) {
+	for _, c := range []string{loc.GetLeadingComments(), loc.GetTrailingComments()} {
+		if c = strings.TrimRight(c, "\n"); c == "" {
+			continue
+		}
+		for _, line := range strings.Split(c, "\n") {
+			fmt.Fprintf(w, "%s//%s\n", indent, strings.TrimRight(line, " "))
+		}
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jhump/protoreflect/desc/protoparse"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+const describeAPITestProto = `syntax = "proto2";
+package demo;
+
+// A thing.
+message Thing {
+  // The ID.
+  optional string id = 1;
+  repeated Kind kinds = 2;
+  map<string, Thing> children = 3;
+  oneof value {
+    int64 count = 4;
+    Other other = 5;
+  }
+  enum Kind {
+    KIND_UNSPECIFIED = 0;
+    BIG = 1;
+  }
+}
+
+message Other {}
+
+// Serves things.
+service Things {
+  // Gets a thing.
+  rpc Get(Other) returns (stream Thing);
+}
+`
+
+// writeTestProtoset compiles `src` with source info into a protoset file and
+// returns its path.
+func writeTestProtoset(t *testing.T, src string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	checkErr(t, os.WriteFile(filepath.Join(dir, "demo.proto"), []byte(src), 0o644))
+	fds, err := protoparse.Parser{ImportPaths: []string{dir}, IncludeSourceCodeInfo: true}.ParseFiles("demo.proto")
+	checkErr(t, err)
+	b, err := proto.Marshal(&descriptorpb.FileDescriptorSet{
+		File: []*descriptorpb.FileDescriptorProto{fds[0].AsFileDescriptorProto()},
+	})
+	checkErr(t, err)
+	path := filepath.Join(dir, "demo.protoset")
+	checkErr(t, os.WriteFile(path, b, 0o644))
+	return path
+}
+
+func TestDescribeAPI(t *testing.T) {
+	t.Parallel()
+
+	protoset := writeTestProtoset(t, describeAPITestProto)
+
+	testCases := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{
+			name: "services",
+			want: "demo.Things  // Serves things.\n",
+		},
+		{
+			name: "message by unqualified name",
+			args: []string{"Thing"},
+			want: `// A thing.
+message demo.Thing {
+  // The ID.
+  optional string id = 1;
+  repeated demo.Thing.Kind kinds = 2;
+  map<string, demo.Thing> children = 3;
+  oneof value {
+    int64 count = 4;
+    demo.Other other = 5;
+  }
+  enum Kind {
+    KIND_UNSPECIFIED = 0;
+    BIG = 1;
+  }
+}
+`,
+		},
+		{
+			name: "service",
+			args: []string{"demo.Things"},
+			want: `// Serves things.
+service demo.Things {
+  // Gets a thing.
+  rpc Get(demo.Other) returns (stream demo.Thing);
+}
+`,
+		},
+		{
+			name: "field",
+			args: []string{"demo.Thing.id"},
+			want: `// In message demo.Thing.
+// The ID.
+optional string id = 1;
+`,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			app := newTestApp()
+			checkErr(t, app.Run(append([]string{"nbictl", "describe-api", "--protoset", protoset}, tc.args...)))
+			if diff := cmp.Diff(tc.want, app.stdout.String()); diff != "" {
+				t.Errorf("output mismatch: (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDescribeAPI_offline(t *testing.T) {
+	t.Parallel()
+
+	app := newTestApp()
+	checkErr(t, app.Run([]string{"nbictl", "describe-api", "--offline", "NetworkNode"}))
+	if want := "message aalyria.spacetime.api.nbi.v1alpha.resources.NetworkNode {\n"; !strings.HasPrefix(app.stdout.String(), want) {
+		t.Errorf("expected output to start with %q, got:\n%s", want, app.stdout.String())
+	}
+}
+
+func TestDescribeAPI_rejectsTooManyArgs(t *testing.T) {
+	t.Parallel()
+
+	err := newTestApp().Run([]string{"nbictl", "describe-api", "--offline", "arg1", "arg2"})
+	if want := "describe-api expects at most 1 argument, got 2"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("expected error to contain %q, got %v", want, err)
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package entity
+
+import (
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+	respb "aalyria.com/spacetime/api/nbi/v1alpha/resources"
+)
+
+// AntennaPattern is an ANTENNA_PATTERN entity.
+type AntennaPattern struct {
+	Metadata
+	Pattern *respb.AntennaPattern
+}
+
+// Aperture describes the antennas modeled by a circular or square aperture.
+type Aperture struct {
+	DiameterM float64
+	// EfficiencyPercent is the aperture efficiency, in (0, 100].
+	EfficiencyPercent float64
+	BacklobeGainDb    float64
+}
+
+// NewIsotropicAntennaPattern returns the pattern of an antenna that radiates
+// equally in every direction.
+func NewIsotropicAntennaPattern(id string) *AntennaPattern {
+	return newAntennaPattern(id, &respb.AntennaPattern{PatternType: &respb.AntennaPattern_IsotropicPattern{
+		IsotropicPattern: &respb.AntennaPattern_IsotropicAntennaPattern{},
+	}})
+}
+
+// NewParabolicAntennaPattern returns the pattern of a parabolic dish.
+func NewParabolicAntennaPattern(id string, a Aperture) *AntennaPattern {
+	return newAntennaPattern(id, &respb.AntennaPattern{PatternType: &respb.AntennaPattern_ParabolicPattern{
+		ParabolicPattern: &respb.AntennaPattern_ParabolicAntennaPattern{
+			DiameterM:         proto.Float64(a.DiameterM),
+			EfficiencyPercent: proto.Float64(a.EfficiencyPercent),
+			BacklobeGainDb:    proto.Float64(a.BacklobeGainDb),
+		},
+	}})
+}
+
+// NewGaussianAntennaPattern returns a pattern whose main lobe is
+// approximated by a Gaussian function.
+func NewGaussianAntennaPattern(id string, a Aperture) *AntennaPattern {
+	return newAntennaPattern(id, &respb.AntennaPattern{PatternType: &respb.AntennaPattern_GaussianPattern{
+		GaussianPattern: &respb.AntennaPattern_GaussianAntennaPattern{
+			DiameterM:         proto.Float64(a.DiameterM),
+			EfficiencyPercent: proto.Float64(a.EfficiencyPercent),
+			BacklobeGainDb:    proto.Float64(a.BacklobeGainDb),
+		},
+	}})
+}
+
+func newAntennaPattern(id string, p *respb.AntennaPattern) *AntennaPattern {
+	return &AntennaPattern{Metadata: Metadata{ID: id}, Pattern: p}
+}
+
+// AntennaPatternFromProto returns the wrapper of `e`, an ANTENNA_PATTERN
+// entity.
+func AntennaPatternFromProto(e *nbipb.Entity) (*AntennaPattern, error) {
+	if err := checkType(e, nbipb.EntityType_ANTENNA_PATTERN); err != nil {
+		return nil, err
+	}
+	p := e.GetAntennaPattern()
+	if p == nil {
+		p = &respb.AntennaPattern{}
+	}
+	return &AntennaPattern{Metadata: metadataFromProto(e), Pattern: p}, nil
+}
+
+func (*AntennaPattern) Type() nbipb.EntityType { return nbipb.EntityType_ANTENNA_PATTERN }
+
+// Kind returns the name of the field of the pattern_type oneof that's set,
+// such as "parabolic_pattern", or "" if none is.
+func (a *AntennaPattern) Kind() string {
+	m := a.Pattern.ProtoReflect()
+	fd := m.WhichOneof(m.Descriptor().Oneofs().ByName("pattern_type"))
+	if fd == nil {
+		return ""
+	}
+	return string(fd.Name())
+}
+
+// Aperture returns the aperture of parabolic, Gaussian, and square horn
+// patterns.
+func (a *AntennaPattern) Aperture() (Aperture, bool) {
+	type aperture interface {
+		GetDiameterM() float64
+		GetEfficiencyPercent() float64
+		GetBacklobeGainDb() float64
+	}
+	var ap aperture
+	switch p := a.Pattern.GetPatternType().(type) {
+	case *respb.AntennaPattern_ParabolicPattern:
+		ap = p.ParabolicPattern
+	case *respb.AntennaPattern_GaussianPattern:
+		ap = p.GaussianPattern
+	case *respb.AntennaPattern_SquareHornPattern:
+		ap = p.SquareHornPattern
+	default:
+		return Aperture{}, false
+	}
+	return Aperture{DiameterM: ap.GetDiameterM(), EfficiencyPercent: ap.GetEfficiencyPercent(), BacklobeGainDb: ap.GetBacklobeGainDb()}, true
+}
+
+func (a *AntennaPattern) Validate() error {
+	errs := []error{a.Metadata.validate()}
+	if a.Kind() == "" {
+		errs = append(errs, errors.New("pattern_type is required"))
+	}
+	if ap, ok := a.Aperture(); ok {
+		if ap.DiameterM <= 0 {
+			errs = append(errs, fmt.Errorf("%s.diameter_m must be positive, got %v", a.Kind(), ap.DiameterM))
+		}
+		if ap.EfficiencyPercent <= 0 || ap.EfficiencyPercent > 100 {
+			errs = append(errs, fmt.Errorf("%s.efficiency_percent must be in (0, 100], got %v", a.Kind(), ap.EfficiencyPercent))
+		}
+	}
+	return invalid(a, errs)
+}
+
+func (a *AntennaPattern) Proto() *nbipb.Entity {
+	e := a.Metadata.proto(a.Type())
+	e.Value = &nbipb.Entity_AntennaPattern{AntennaPattern: a.Pattern}
+	return e
+}
@@ -0,0 +1,36 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package entity provides typed wrappers over the Entity protos of the
+// Spacetime NBI, so programs can build and inspect entities without
+// assembling the `value` oneof and its nested messages by hand.
+//
+// Each wrapper, such as [Platform] or [AntennaPattern], has a constructor,
+// accessors for its commonly used fields, a Validate method that checks it
+// locally before it's sent, and a Proto method that converts it to an
+// [nbipb.Entity]. [FromProto] converts an entity received from the NBI back
+// to its wrapper:
+//
+//	sat := entity.NewPlatform("sat-1", "Satellite 1", entity.TLE{Line1: l1, Line2: l2}.Motion())
+//	if err := sat.Validate(); err != nil {
+//		return err
+//	}
+//	_, err := client.CreateEntity(ctx, &nbipb.CreateEntityRequest{Entity: sat.Proto()})
+//
+// The wrappers keep the underlying proto message accessible, so fields that
+// don't have an accessor can still be read and set directly.
+//
+// [LinkBudget] similarly flattens the wireless link budgets returned by the
+// signal propagation service.
+package entity
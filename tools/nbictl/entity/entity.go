@@ -0,0 +1,114 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package entity
+
+import (
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+)
+
+// Entity is implemented by the typed wrappers of this package.
+type Entity interface {
+	// Type returns the type of the entity.
+	Type() nbipb.EntityType
+	// Meta returns the metadata shared by every entity.
+	Meta() Metadata
+	// Validate reports the problems that would make the NBI reject the
+	// entity, or that make it unusable, such as missing required fields.
+	Validate() error
+	// Proto returns the entity as an NBI proto.
+	Proto() *nbipb.Entity
+}
+
+// Metadata are the fields shared by every entity.
+type Metadata struct {
+	// ID is the ID of the entity, unique among the entities of its type.
+	ID string
+	// AppID is the name of the application that manages the entity.
+	AppID string
+	// CommitTimestamp is the time at which the entity was last modified, in
+	// microseconds since the Unix epoch, or 0 if it wasn't read from the NBI.
+	// When set, the NBI only updates or deletes the entity if it hasn't been
+	// modified since.
+	CommitTimestamp int64
+}
+
+// Meta returns the metadata itself, so that types embedding Metadata
+// implement that method of Entity.
+func (m Metadata) Meta() Metadata { return m }
+
+func (m Metadata) validate() error {
+	if m.ID == "" {
+		return errors.New("id is required")
+	}
+	return nil
+}
+
+// proto returns an entity of type `t` with the metadata set.
+func (m Metadata) proto(t nbipb.EntityType) *nbipb.Entity {
+	e := &nbipb.Entity{Group: &nbipb.EntityGroup{Type: t.Enum()}}
+	if m.ID != "" {
+		e.Id = proto.String(m.ID)
+	}
+	if m.AppID != "" {
+		e.Group.AppId = proto.String(m.AppID)
+	}
+	if m.CommitTimestamp != 0 {
+		e.CommitTimestamp = proto.Int64(m.CommitTimestamp)
+	}
+	return e
+}
+
+func metadataFromProto(e *nbipb.Entity) Metadata {
+	return Metadata{ID: e.GetId(), AppID: e.GetGroup().GetAppId(), CommitTimestamp: e.GetCommitTimestamp()}
+}
+
+// ErrUnsupportedType is returned by FromProto for the entity types that
+// don't have a wrapper in this package.
+var ErrUnsupportedType = errors.New("unsupported entity type")
+
+// FromProto returns the wrapper of `e`. The wrapper shares the nested
+// messages of `e`, so `e` shouldn't be modified afterwards.
+func FromProto(e *nbipb.Entity) (Entity, error) {
+	switch t := e.GetGroup().GetType(); t {
+	case nbipb.EntityType_PLATFORM_DEFINITION:
+		return PlatformFromProto(e)
+	case nbipb.EntityType_ANTENNA_PATTERN:
+		return AntennaPatternFromProto(e)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedType, t)
+	}
+}
+
+// invalid returns nil if `errs` only holds nil errors, and an error
+// identifying `e` that joins them otherwise.
+func invalid(e Entity, errs []error) error {
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("invalid %s %q: %w", e.Type(), e.Meta().ID, err)
+	}
+	return nil
+}
+
+// checkType returns an error unless `e` is of type `want`.
+func checkType(e *nbipb.Entity, want nbipb.EntityType) error {
+	if got := e.GetGroup().GetType(); got != want {
+		return fmt.Errorf("entity %q is a %s, not a %s", e.GetId(), got, want)
+	}
+	return nil
+}
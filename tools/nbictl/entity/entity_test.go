@@ -0,0 +1,236 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package entity
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+	respb "aalyria.com/spacetime/api/nbi/v1alpha/resources"
+)
+
+const (
+	issLine1 = "1 25544U 98067A   24001.50000000  .00016717  00000-0  10270-3 0  9005"
+	issLine2 = "2 25544  51.6416 247.4627 0006703 130.5360 325.0288 15.49815526 12345"
+)
+
+func TestPlatform_roundTrip(t *testing.T) {
+	t.Parallel()
+
+	want := &nbipb.Entity{}
+	if err := prototext.Unmarshal([]byte(`
+		group { type: PLATFORM_DEFINITION app_id: "planner" }
+		id: "gs-1"
+		commit_timestamp: 42
+		platform {
+			name: "Ground station"
+			coordinates { geodetic_wgs84 { latitude_deg: 47.6 longitude_deg: -122.3 height_wgs84_m: 10 } }
+			transceiver_model { id: "dish" }
+		}`), want); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := FromProto(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, ok := e.(*Platform)
+	if !ok {
+		t.Fatalf("expected a *Platform, got %T", e)
+	}
+	if err := p.Validate(); err != nil {
+		t.Errorf("unexpected validation error: %v", err)
+	}
+	if got, want := p.Meta(), (Metadata{ID: "gs-1", AppID: "planner", CommitTimestamp: 42}); got != want {
+		t.Errorf("unexpected metadata: want %+v, got %+v", want, got)
+	}
+	if pos, ok := p.Position(); !ok || pos != (Position{LatitudeDeg: 47.6, LongitudeDeg: -122.3, HeightM: 10}) {
+		t.Errorf("unexpected position: %+v, %v", pos, ok)
+	}
+	if _, ok := p.TLE(); ok {
+		t.Errorf("expected a fixed platform to have no TLE")
+	}
+	if diff := cmp.Diff([]string{"dish"}, p.TransceiverModelIDs()); diff != "" {
+		t.Errorf("unexpected transceiver model IDs (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(want, p.Proto(), protocmp.Transform()); diff != "" {
+		t.Errorf("unexpected proto (-want +got):\n%s", diff)
+	}
+}
+
+func TestPlatform_Validate(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name     string
+		platform *Platform
+		wantErrs []string
+	}{
+		{
+			name:     "valid TLE",
+			platform: NewPlatform("iss", "ISS", TLE{Line1: issLine1, Line2: issLine2}.Motion()),
+		},
+		{
+			name:     "missing motion",
+			platform: NewPlatform("sat", "", nil),
+			wantErrs: []string{`invalid PLATFORM_DEFINITION "sat"`, "either coordinates or motion_ref_id is required"},
+		},
+		{
+			name:     "missing ID and bad position",
+			platform: NewPlatform("", "", Position{LatitudeDeg: 91, LongitudeDeg: math.NaN()}.Motion()),
+			wantErrs: []string{"id is required", "latitude_deg must be in [-90, 90], got 91", "longitude_deg must be in [-180, 180], got NaN"},
+		},
+		{
+			name:     "truncated TLE",
+			platform: NewPlatform("iss", "", TLE{Line1: issLine1[:60], Line2: issLine1}.Motion()),
+			wantErrs: []string{"tle line1 must be 69 characters long, got 60", `tle line2 must start with "2 "`},
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := tc.platform.Validate()
+			switch {
+			case len(tc.wantErrs) == 0 && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case len(tc.wantErrs) > 0 && err == nil:
+				t.Fatalf("expected errors %q, got none", tc.wantErrs)
+			}
+			for _, want := range tc.wantErrs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("expected error to contain %q, got %v", want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestAntennaPattern(t *testing.T) {
+	t.Parallel()
+
+	a := NewParabolicAntennaPattern("dish", Aperture{DiameterM: 2.4, EfficiencyPercent: 55, BacklobeGainDb: -20})
+	if err := a.Validate(); err != nil {
+		t.Errorf("unexpected validation error: %v", err)
+	}
+	if got := a.Kind(); got != "parabolic_pattern" {
+		t.Errorf("unexpected kind: %q", got)
+	}
+
+	e, err := FromProto(a.Proto())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(a, e, protocmp.Transform()); diff != "" {
+		t.Errorf("unexpected round trip (-want +got):\n%s", diff)
+	}
+
+	bad := NewGaussianAntennaPattern("beam", Aperture{EfficiencyPercent: 120})
+	if err := bad.Validate(); err == nil || !strings.Contains(err.Error(), "gaussian_pattern.diameter_m must be positive") || !strings.Contains(err.Error(), "efficiency_percent must be in (0, 100]") {
+		t.Errorf("unexpected validation error: %v", err)
+	}
+	if err := NewIsotropicAntennaPattern("iso").Validate(); err != nil {
+		t.Errorf("unexpected validation error: %v", err)
+	}
+}
+
+func TestFromProto_errors(t *testing.T) {
+	t.Parallel()
+
+	_, err := FromProto(&nbipb.Entity{Group: &nbipb.EntityGroup{Type: nbipb.EntityType_INTENT.Enum()}})
+	if !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("expected ErrUnsupportedType, got %v", err)
+	}
+	if _, err := PlatformFromProto(NewIsotropicAntennaPattern("iso").Proto()); err == nil {
+		t.Errorf("expected an error converting an antenna pattern to a platform")
+	}
+}
+
+func TestLinkBudget(t *testing.T) {
+	t.Parallel()
+
+	want := &respb.WirelessLinkBudget{}
+	if err := prototext.Unmarshal([]byte(`
+		effective_isotropic_radiated_power_dbw: 30
+		propagation_loss_db: 180
+		received_isotropic_power_dbw: -150
+		carrier_to_noise_db: 12.5
+		component_propagation_loss_db { key: "free_space" value: 179 }`), want); err != nil {
+		t.Fatal(err)
+	}
+
+	b := LinkBudgetFromProto(want)
+	if !math.IsNaN(b.CarrierToNoisePlusInterferenceDb) {
+		t.Errorf("expected unset values to be NaN, got %v", b.CarrierToNoisePlusInterferenceDb)
+	}
+	if got := b.MarginDb(10); got != 2.5 {
+		t.Errorf("unexpected margin: %v", got)
+	}
+	if err := b.Validate(); err != nil {
+		t.Errorf("unexpected validation error: %v", err)
+	}
+	if diff := cmp.Diff(want, b.Proto(), protocmp.Transform()); diff != "" {
+		t.Errorf("unexpected proto (-want +got):\n%s", diff)
+	}
+
+	b.ReceivedIsotropicPowerDbw = 40
+	if err := b.Validate(); err == nil || !strings.Contains(err.Error(), "exceeds effective_isotropic_radiated_power_dbw") {
+		t.Errorf("unexpected validation error: %v", err)
+	}
+}
+
+func TestKinds(t *testing.T) {
+	t.Parallel()
+
+	fields := (&nbipb.Entity{}).ProtoReflect().Descriptor().Fields()
+	for _, k := range Kinds {
+		if fields.ByName(k.Field) == nil {
+			t.Errorf("%s: Entity has no field %q", k.Name, k.Field)
+		}
+	}
+}
+
+func TestKind_build(t *testing.T) {
+	t.Parallel()
+
+	k := Kinds[2]
+	e, err := k.Build("node", func(m proto.Message) error { return prototext.Unmarshal([]byte(`name: "node"`), m) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &nbipb.Entity{}
+	if err := prototext.Unmarshal([]byte(`group { type: NETWORK_NODE } id: "node" network_node { name: "node" }`), want); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, e, protocmp.Transform()); diff != "" {
+		t.Errorf("Build() mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(want.GetNetworkNode(), k.Value(e), protocmp.Transform()); diff != "" {
+		t.Errorf("Value() mismatch (-want +got):\n%s", diff)
+	}
+
+	wantErr := errors.New("boom")
+	if _, err := k.Build("node", func(proto.Message) error { return wantErr }); err != wantErr {
+		t.Errorf("Build() with a failing unmarshal = %v, want its error as is", err)
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package entity
+
+import (
+	"errors"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+)
+
+// Kind is an entity type whose entities are managed declaratively, as an ID
+// and the value of one field of the Entity message, by tools such as the
+// Kubernetes operator and the Terraform provider.
+type Kind struct {
+	// Name identifies the kind in those tools, such as "network_node".
+	Name string
+	Type nbipb.EntityType
+	// Field is the field of the Entity message that holds the value of the
+	// entities of this type.
+	Field       protoreflect.Name
+	Description string
+}
+
+// Kinds are the entity types that can be managed declaratively.
+var Kinds = []Kind{
+	{
+		Name:        "platform",
+		Type:        nbipb.EntityType_PLATFORM_DEFINITION,
+		Field:       "platform",
+		Description: "A platform: a physical object, such as a satellite or a ground station, that carries network nodes and antennas.",
+	},
+	{
+		Name:        "antenna_pattern",
+		Type:        nbipb.EntityType_ANTENNA_PATTERN,
+		Field:       "antenna_pattern",
+		Description: "An antenna pattern, which describes the gain of the antennas that refer to it.",
+	},
+	{
+		Name:        "network_node",
+		Type:        nbipb.EntityType_NETWORK_NODE,
+		Field:       "network_node",
+		Description: "A network node and its network interfaces.",
+	},
+	{
+		Name:        "interface_link",
+		Type:        nbipb.EntityType_INTERFACE_LINK_REPORT,
+		Field:       "interface_link_report",
+		Description: "A link between the network interfaces of two network nodes.",
+	},
+	{
+		Name:        "transceiver_link",
+		Type:        nbipb.EntityType_TRANSCEIVER_LINK_REPORT,
+		Field:       "transceiver_link_report",
+		Description: "A wireless link between the transceivers of two network interfaces.",
+	},
+}
+
+// Build returns the entity of kind `k` with the given ID, whose value is
+// decoded by `unmarshal` into an empty message of the type of k.Field, such
+// as with protojson.Unmarshal or prototext.Unmarshal. Errors returned by
+// `unmarshal` are returned as is.
+func (k Kind) Build(id string, unmarshal func(proto.Message) error) (*nbipb.Entity, error) {
+	e := &nbipb.Entity{
+		Group: &nbipb.EntityGroup{Type: k.Type.Enum()},
+		Id:    proto.String(id),
+	}
+	msg := e.ProtoReflect()
+	fd := msg.Descriptor().Fields().ByName(k.Field)
+	v := msg.NewField(fd)
+	if err := unmarshal(v.Message().Interface()); err != nil {
+		return nil, err
+	}
+	msg.Set(fd, v)
+	return e, nil
+}
+
+// Value returns the value of `e`, an entity of kind `k`.
+func (k Kind) Value(e *nbipb.Entity) proto.Message {
+	msg := e.ProtoReflect()
+	return msg.Get(msg.Descriptor().Fields().ByName(k.Field)).Message().Interface()
+}
+
+// Validate checks `e` with the Validate method of its wrapper, for the
+// entity types that have one. Entities of other types aren't checked.
+func Validate(e *nbipb.Entity) error {
+	w, err := FromProto(e)
+	switch {
+	case errors.Is(err, ErrUnsupportedType):
+		return nil
+	case err != nil:
+		return err
+	default:
+		return w.Validate()
+	}
+}
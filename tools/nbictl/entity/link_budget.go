@@ -0,0 +1,136 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package entity
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	respb "aalyria.com/spacetime/api/nbi/v1alpha/resources"
+)
+
+// LinkBudget is a wireless link budget, as returned by the signal
+// propagation service. Values that the service didn't compute are NaN.
+type LinkBudget struct {
+	TransmitterAntennaGainDb         float64
+	EIRPDbw                          float64
+	PropagationLossDb                float64
+	ReceivedIsotropicPowerDbw        float64
+	ReceivedPowerFluxDensityDbWPerM2 float64
+	ReceiverAntennaGainDb            float64
+	PowerAtReceiverOutputDbw         float64
+	CarrierToNoiseDb                 float64
+	CarrierToNoisePlusInterferenceDb float64
+	CarrierToNoiseDensityDbPerHz     float64
+	// ComponentPropagationLossDb maps the name of each propagation loss
+	// model to the loss attributable to it.
+	ComponentPropagationLossDb map[string]float64
+}
+
+// LinkBudgetFromProto returns the flattened representation of `b`.
+func LinkBudgetFromProto(b *respb.WirelessLinkBudget) LinkBudget {
+	opt := func(set bool, v float64) float64 {
+		if !set {
+			return math.NaN()
+		}
+		return v
+	}
+	components := map[string]float64{}
+	for k, v := range b.GetComponentPropagationLossDb() {
+		components[k] = v
+	}
+	return LinkBudget{
+		TransmitterAntennaGainDb:         opt(b.TransmitterAntennaGainInLinkDirectionDb != nil, b.GetTransmitterAntennaGainInLinkDirectionDb()),
+		EIRPDbw:                          opt(b.EffectiveIsotropicRadiatedPowerDbw != nil, b.GetEffectiveIsotropicRadiatedPowerDbw()),
+		PropagationLossDb:                opt(b.PropagationLossDb != nil, b.GetPropagationLossDb()),
+		ReceivedIsotropicPowerDbw:        opt(b.ReceivedIsotropicPowerDbw != nil, b.GetReceivedIsotropicPowerDbw()),
+		ReceivedPowerFluxDensityDbWPerM2: opt(b.ReceivedPowerFluxDensityDbWPerM2 != nil, b.GetReceivedPowerFluxDensityDbWPerM2()),
+		ReceiverAntennaGainDb:            opt(b.ReceiverAntennaGainInLinkDirectionDb != nil, b.GetReceiverAntennaGainInLinkDirectionDb()),
+		PowerAtReceiverOutputDbw:         opt(b.PowerAtReceiverOutputDbw != nil, b.GetPowerAtReceiverOutputDbw()),
+		CarrierToNoiseDb:                 opt(b.CarrierToNoiseDb != nil, b.GetCarrierToNoiseDb()),
+		CarrierToNoisePlusInterferenceDb: opt(b.CarrierToNoisePlusInterferenceDb != nil, b.GetCarrierToNoisePlusInterferenceDb()),
+		CarrierToNoiseDensityDbPerHz:     opt(b.CarrierToNoiseDensityDbPerHz != nil, b.GetCarrierToNoiseDensityDbPerHz()),
+		ComponentPropagationLossDb:       components,
+	}
+}
+
+// Proto returns the link budget as a proto, omitting the NaN values.
+func (b LinkBudget) Proto() *respb.WirelessLinkBudget {
+	opt := func(v float64) *float64 {
+		if math.IsNaN(v) {
+			return nil
+		}
+		return &v
+	}
+	p := &respb.WirelessLinkBudget{
+		TransmitterAntennaGainInLinkDirectionDb: opt(b.TransmitterAntennaGainDb),
+		EffectiveIsotropicRadiatedPowerDbw:      opt(b.EIRPDbw),
+		PropagationLossDb:                       opt(b.PropagationLossDb),
+		ReceivedIsotropicPowerDbw:               opt(b.ReceivedIsotropicPowerDbw),
+		ReceivedPowerFluxDensityDbWPerM2:        opt(b.ReceivedPowerFluxDensityDbWPerM2),
+		ReceiverAntennaGainInLinkDirectionDb:    opt(b.ReceiverAntennaGainDb),
+		PowerAtReceiverOutputDbw:                opt(b.PowerAtReceiverOutputDbw),
+		CarrierToNoiseDb:                        opt(b.CarrierToNoiseDb),
+		CarrierToNoisePlusInterferenceDb:        opt(b.CarrierToNoisePlusInterferenceDb),
+		CarrierToNoiseDensityDbPerHz:            opt(b.CarrierToNoiseDensityDbPerHz),
+	}
+	if len(b.ComponentPropagationLossDb) > 0 {
+		p.ComponentPropagationLossDb = map[string]float64{}
+		for k, v := range b.ComponentPropagationLossDb {
+			p.ComponentPropagationLossDb[k] = v
+		}
+	}
+	return p
+}
+
+// MarginDb returns the margin of the link over `requiredCNDb`, the minimum
+// carrier to noise-plus-interference ratio of the receiver, falling back to
+// the carrier to noise ratio when interference wasn't computed. It returns
+// NaN if neither was.
+func (b LinkBudget) MarginDb(requiredCNDb float64) float64 {
+	cn := b.CarrierToNoisePlusInterferenceDb
+	if math.IsNaN(cn) {
+		cn = b.CarrierToNoiseDb
+	}
+	return cn - requiredCNDb
+}
+
+// Validate checks that the computed values are finite and consistent with
+// each other. Values that weren't computed are ignored.
+func (b LinkBudget) Validate() error {
+	errs := []error{}
+	for _, f := range []struct {
+		name string
+		v    float64
+	}{
+		{"transmitter_antenna_gain_in_link_direction_db", b.TransmitterAntennaGainDb},
+		{"effective_isotropic_radiated_power_dbw", b.EIRPDbw},
+		{"propagation_loss_db", b.PropagationLossDb},
+		{"received_isotropic_power_dbw", b.ReceivedIsotropicPowerDbw},
+		{"carrier_to_noise_db", b.CarrierToNoiseDb},
+	} {
+		if math.IsInf(f.v, 0) {
+			errs = append(errs, fmt.Errorf("%s must be finite, got %v", f.name, f.v))
+		}
+	}
+	if b.PropagationLossDb < 0 {
+		errs = append(errs, fmt.Errorf("propagation_loss_db can't be negative, got %v", b.PropagationLossDb))
+	}
+	if b.ReceivedIsotropicPowerDbw > b.EIRPDbw {
+		errs = append(errs, fmt.Errorf("received_isotropic_power_dbw (%v) exceeds effective_isotropic_radiated_power_dbw (%v)", b.ReceivedIsotropicPowerDbw, b.EIRPDbw))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,180 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package entity
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"google.golang.org/protobuf/proto"
+
+	commonpb "aalyria.com/spacetime/api/common"
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+)
+
+// tleLineLength is the length of each line of a two-line element set.
+const tleLineLength = 69
+
+// Platform is a PLATFORM_DEFINITION entity.
+type Platform struct {
+	Metadata
+	Definition *commonpb.PlatformDefinition
+}
+
+// NewPlatform returns a platform with the provided ID, name, and motion,
+// which can be nil if the platform's motion is described by a separate
+// MotionDefinition entity.
+func NewPlatform(id, name string, motion *commonpb.Motion) *Platform {
+	p := &Platform{Metadata: Metadata{ID: id}, Definition: &commonpb.PlatformDefinition{Coordinates: motion}}
+	if name != "" {
+		p.Definition.Name = proto.String(name)
+	}
+	return p
+}
+
+// PlatformFromProto returns the wrapper of `e`, a PLATFORM_DEFINITION entity.
+func PlatformFromProto(e *nbipb.Entity) (*Platform, error) {
+	if err := checkType(e, nbipb.EntityType_PLATFORM_DEFINITION); err != nil {
+		return nil, err
+	}
+	def := e.GetPlatform()
+	if def == nil {
+		def = &commonpb.PlatformDefinition{}
+	}
+	return &Platform{Metadata: metadataFromProto(e), Definition: def}, nil
+}
+
+func (*Platform) Type() nbipb.EntityType { return nbipb.EntityType_PLATFORM_DEFINITION }
+
+func (p *Platform) Name() string { return p.Definition.GetName() }
+
+// Motion returns the motion of the platform, or nil if it's described by
+// the MotionDefinition entity returned by MotionRefID.
+func (p *Platform) Motion() *commonpb.Motion { return p.Definition.GetCoordinates() }
+
+func (p *Platform) MotionRefID() string { return p.Definition.GetMotionRefId() }
+
+// Position returns the position of a platform with a fixed WGS 84 position.
+func (p *Platform) Position() (Position, bool) {
+	g := p.Motion().GetGeodeticWgs84()
+	if g == nil {
+		return Position{}, false
+	}
+	return Position{LatitudeDeg: g.GetLatitudeDeg(), LongitudeDeg: g.GetLongitudeDeg(), HeightM: g.GetHeightWgs84M()}, true
+}
+
+// TLE returns the two-line element set of an orbiting platform.
+func (p *Platform) TLE() (TLE, bool) {
+	t := p.Motion().GetTle()
+	if t == nil {
+		return TLE{}, false
+	}
+	return TLE{Line1: t.GetLine1(), Line2: t.GetLine2()}, true
+}
+
+// TransceiverModelIDs returns the IDs of the platform's transceiver models.
+func (p *Platform) TransceiverModelIDs() []string {
+	ids := []string{}
+	for _, m := range p.Definition.GetTransceiverModel() {
+		ids = append(ids, m.GetId())
+	}
+	return ids
+}
+
+func (p *Platform) Validate() error {
+	errs := []error{p.Metadata.validate()}
+	switch m := p.Motion(); {
+	case m == nil && p.MotionRefID() == "":
+		errs = append(errs, errors.New("either coordinates or motion_ref_id is required"))
+	case m.GetGeodeticWgs84() != nil:
+		pos, _ := p.Position()
+		errs = append(errs, pos.validate())
+	case m.GetTle() != nil:
+		tle, _ := p.TLE()
+		errs = append(errs, tle.validate())
+	}
+	for i, m := range p.Definition.GetTransceiverModel() {
+		if m.GetId() == "" {
+			errs = append(errs, fmt.Errorf("transceiver_model[%d]: id is required", i))
+		}
+	}
+	return invalid(p, errs)
+}
+
+func (p *Platform) Proto() *nbipb.Entity {
+	e := p.Metadata.proto(p.Type())
+	e.Value = &nbipb.Entity_Platform{Platform: p.Definition}
+	return e
+}
+
+// Position is a fixed position relative to the WGS 84 ellipsoid.
+type Position struct {
+	LatitudeDeg, LongitudeDeg float64
+	// HeightM is the height above the WGS 84 ellipsoid, in meters.
+	HeightM float64
+}
+
+// Motion returns the motion of a platform at the position.
+func (p Position) Motion() *commonpb.Motion {
+	return &commonpb.Motion{Type: &commonpb.Motion_GeodeticWgs84{GeodeticWgs84: &commonpb.GeodeticWgs84{
+		LatitudeDeg:  proto.Float64(p.LatitudeDeg),
+		LongitudeDeg: proto.Float64(p.LongitudeDeg),
+		HeightWgs84M: proto.Float64(p.HeightM),
+	}}}
+}
+
+func (p Position) validate() error {
+	errs := []error{}
+	if math.IsNaN(p.LatitudeDeg) || p.LatitudeDeg < -90 || p.LatitudeDeg > 90 {
+		errs = append(errs, fmt.Errorf("latitude_deg must be in [-90, 90], got %v", p.LatitudeDeg))
+	}
+	if math.IsNaN(p.LongitudeDeg) || p.LongitudeDeg < -180 || p.LongitudeDeg > 180 {
+		errs = append(errs, fmt.Errorf("longitude_deg must be in [-180, 180], got %v", p.LongitudeDeg))
+	}
+	if math.IsNaN(p.HeightM) || math.IsInf(p.HeightM, 0) {
+		errs = append(errs, fmt.Errorf("height_wgs84_m must be finite, got %v", p.HeightM))
+	}
+	return errors.Join(errs...)
+}
+
+// TLE is a two-line element set describing the orbit of a satellite.
+type TLE struct {
+	Line1, Line2 string
+}
+
+// Motion returns the motion of a platform in the orbit described by the TLE.
+func (t TLE) Motion() *commonpb.Motion {
+	return &commonpb.Motion{Type: &commonpb.Motion_Tle{Tle: &commonpb.TwoLineElementSet{
+		Line1: proto.String(t.Line1),
+		Line2: proto.String(t.Line2),
+	}}}
+}
+
+// validate checks the format of the TLE's lines, but not the elements they
+// contain.
+func (t TLE) validate() error {
+	errs := []error{}
+	for i, line := range []string{t.Line1, t.Line2} {
+		prefix := fmt.Sprintf("%d ", i+1)
+		switch {
+		case len(line) != tleLineLength:
+			errs = append(errs, fmt.Errorf("tle line%d must be %d characters long, got %d", i+1, tleLineLength, len(line)))
+		case line[:2] != prefix:
+			errs = append(errs, fmt.Errorf("tle line%d must start with %q", i+1, prefix))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,465 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/prototext"
+	"gopkg.in/yaml.v3"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+)
+
+// maxEncodedEntityBytes bounds the size of a single entity in a JSON or YAML
+// entity file. These files are decoded one entity at a time, so memory use
+// doesn't grow with the size of the file beyond the decoded entities
+// themselves, and a file that's malformed in a way that makes an entity
+// appear unbounded fails rather than exhausting memory.
+const maxEncodedEntityBytes = 64 << 20
+
+// entityFileFormatsUsage describes the formats newEntityDecoder supports, for
+// the usage of flags that accept entity files.
+const entityFileFormatsUsage = "Files ending in .json hold a JSON-encoded Entity or a list of them, files ending in .ndjson or .jsonl hold one JSON-encoded Entity per line, files ending in .yaml or .yml hold one Entity per YAML document, and any other file holds a textproto-encoded TxtpbEntities message. JSON and YAML files are read one entity at a time."
+
+// errEntityTooLarge is returned when an entity exceeds maxEncodedEntityBytes.
+var errEntityTooLarge = fmt.Errorf("entity is larger than %d MiB", maxEncodedEntityBytes>>20)
+
+var streamFlag = &cli.BoolFlag{
+	Name:  "stream",
+	Usage: "Process the entities as they're read from the files, rather than reading them all first, so that only about --concurrency entities are held in memory at once. Entities must then come after those they reference, and only --max_request_bytes is checked, as each entity is sent rather than before any is.",
+}
+
+// entityDecoder reads the entities in a file one at a time.
+type entityDecoder interface {
+	// next returns the next entity, or io.EOF once there are none left.
+	next() (*nbipb.Entity, error)
+}
+
+// newEntityDecoder returns a decoder for the entity file `path`, based on its
+// extension:
+//
+//   - .ndjson and .jsonl files hold one JSON-encoded Entity per line (or, in
+//     fact, any sequence of JSON values).
+//   - .json files hold a single JSON-encoded Entity, an array of them, or a
+//     TxtpbEntities message. Arrays are read one element at a time.
+//   - .yaml and .yml files hold one Entity per document, using the same field
+//     names as JSON. A document can also be a list of entities, but it's
+//     decoded all at once.
+//   - Any other file holds a textproto-encoded TxtpbEntities message, which is
+//     read in full.
+func newEntityDecoder(path string, r io.Reader) entityDecoder {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".ndjson", ".jsonl":
+		return newJSONEntityDecoder(r, false)
+	case ".json":
+		return newJSONEntityDecoder(r, true)
+	case ".yaml", ".yml":
+		return newYAMLEntityDecoder(r)
+	default:
+		return &textprotoEntityDecoder{r: r}
+	}
+}
+
+// readEntityFile parses the entities in the entity file `path`.
+func readEntityFile(path string) ([]*nbipb.Entity, error) {
+	return readAllEntities(&entityFilesDecoder{files: []string{path}})
+}
+
+// readAllEntities returns the entities read from `dec`, which it closes.
+func readAllEntities(dec *entityFilesDecoder) ([]*nbipb.Entity, error) {
+	defer dec.Close()
+
+	entities := []*nbipb.Entity{}
+	for {
+		e, err := dec.next()
+		if errors.Is(err, io.EOF) {
+			return entities, nil
+		} else if err != nil {
+			return nil, err
+		}
+		entities = append(entities, e)
+	}
+}
+
+// entityFilesDecoder reads the entities in a list of entity files one at a
+// time, opening each file once the previous one has been read.
+type entityFilesDecoder struct {
+	files []string
+	// path is the file being read by dec, which reads from f.
+	path string
+	f    *os.File
+	dec  entityDecoder
+}
+
+// openEntityFiles returns a decoder for the entities in all of the entity
+// files matching `fileGlob`, in any of the formats supported by
+// newEntityDecoder. It must be closed.
+func openEntityFiles(fileGlob string) (*entityFilesDecoder, error) {
+	files, err := filepath.Glob(fileGlob)
+	if err != nil {
+		return nil, fmt.Errorf("unable to expand the file path %w", err)
+	} else if len(files) == 0 {
+		return nil, fmt.Errorf("no files found under the given file path: %s", fileGlob)
+	}
+	return &entityFilesDecoder{files: files}, nil
+}
+
+func (d *entityFilesDecoder) next() (*nbipb.Entity, error) {
+	for {
+		if d.dec == nil {
+			if len(d.files) == 0 {
+				return nil, io.EOF
+			}
+			f, err := os.Open(d.files[0])
+			if err != nil {
+				return nil, fmt.Errorf("invalid file path: %w", err)
+			}
+			d.path, d.files = d.files[0], d.files[1:]
+			d.f, d.dec = f, newEntityDecoder(d.path, bufio.NewReader(f))
+		}
+
+		e, err := d.dec.next()
+		if errors.Is(err, io.EOF) {
+			d.Close()
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("error while parsing file %s: %w", d.path, err)
+		}
+		return e, nil
+	}
+}
+
+// Close closes the file being read, if any.
+func (d *entityFilesDecoder) Close() error {
+	if d.f == nil {
+		return nil
+	}
+	err := d.f.Close()
+	d.f, d.dec = nil, nil
+	return err
+}
+
+// boundedReader fails reads once more than `limit` bytes have been read.
+// jsonEntityDecoder raises the limit as it finishes each entity, which
+// bounds the amount any one entity can make it buffer.
+type boundedReader struct {
+	r        io.Reader
+	n, limit int64
+}
+
+func (b *boundedReader) Read(p []byte) (int, error) {
+	if b.n >= b.limit {
+		return 0, errEntityTooLarge
+	}
+	if rest := b.limit - b.n; int64(len(p)) > rest {
+		p = p[:rest]
+	}
+	n, err := b.r.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+// jsonEntityDecoder decodes JSON-encoded entities using the tokens of a
+// json.Decoder, so that only one entity is held in memory at a time.
+type jsonEntityDecoder struct {
+	br  *boundedReader
+	dec *json.Decoder
+	// allowArray is set if the top-level value may be an array of entities
+	// or a TxtpbEntities message rather than a sequence of entities.
+	allowArray bool
+	started    bool
+	// inArray is set while reading the elements of an array.
+	inArray bool
+	// closers are the delimiters that must follow the array's last element.
+	closers []json.Delim
+}
+
+func newJSONEntityDecoder(r io.Reader, allowArray bool) *jsonEntityDecoder {
+	br := &boundedReader{r: r, limit: maxEncodedEntityBytes}
+	return &jsonEntityDecoder{br: br, dec: json.NewDecoder(br), allowArray: allowArray}
+}
+
+func (d *jsonEntityDecoder) next() (*nbipb.Entity, error) {
+	if d.allowArray && !d.started {
+		d.started = true
+		if err := d.openArray(); err != nil {
+			return nil, err
+		}
+	}
+
+	if d.inArray && !d.dec.More() {
+		// The array has been read in full.
+		for _, want := range d.closers {
+			if tok, err := d.dec.Token(); err != nil {
+				return nil, d.offsetErr(err)
+			} else if tok != want {
+				return nil, fmt.Errorf("at offset %d: expected %v, got %v", d.dec.InputOffset(), want, tok)
+			}
+		}
+		d.inArray = false
+		// Only whitespace may follow.
+		if _, err := d.dec.Token(); !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("at offset %d: unexpected data after entities", d.dec.InputOffset())
+		}
+		return nil, io.EOF
+	}
+
+	// The decoder buffers ahead of what it has decoded, so the entity starts
+	// at its input offset rather than at the bytes read so far.
+	d.br.limit = d.dec.InputOffset() + maxEncodedEntityBytes
+	raw := json.RawMessage{}
+	if err := d.dec.Decode(&raw); errors.Is(err, io.EOF) && !d.inArray {
+		return nil, io.EOF
+	} else if err != nil {
+		return nil, d.offsetErr(err)
+	}
+	e := &nbipb.Entity{}
+	if err := protojson.Unmarshal(raw, e); err != nil {
+		return nil, fmt.Errorf("entity ending at offset %d: %w", d.dec.InputOffset(), err)
+	}
+	return e, nil
+}
+
+// openArray consumes the tokens that precede the first entity of a JSON file
+// if the file holds an array of entities or a TxtpbEntities message, whose
+// entities are in its "entity" field. Otherwise, the file holds a single
+// entity, which is left to be decoded.
+func (d *jsonEntityDecoder) openArray() error {
+	// Peek at the first non-space byte without consuming it from the
+	// decoder.
+	br := bufio.NewReader(d.br)
+	first, err := peekNonSpace(br)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return io.EOF
+		}
+		return err
+	}
+	d.dec = json.NewDecoder(br)
+
+	switch first {
+	case '[':
+		if _, err := d.dec.Token(); err != nil {
+			return d.offsetErr(err)
+		}
+		d.inArray, d.closers = true, []json.Delim{']'}
+		return nil
+
+	case '{':
+		// Decoding a whole object would load every entity of a
+		// TxtpbEntities message at once, so tell the two apart using the
+		// first key. Entities don't have an "entity" field.
+		if !startsWithEntityKey(br) {
+			return nil
+		}
+		for _, want := range []any{json.Delim('{'), "entity", json.Delim('[')} {
+			if tok, err := d.dec.Token(); err != nil {
+				return d.offsetErr(err)
+			} else if tok != want {
+				return fmt.Errorf("at offset %d: expected %v, got %v", d.dec.InputOffset(), want, tok)
+			}
+		}
+		d.inArray, d.closers = true, []json.Delim{']', '}'}
+		return nil
+
+	default:
+		return nil
+	}
+}
+
+func (d *jsonEntityDecoder) offsetErr(err error) error {
+	if errors.Is(err, errEntityTooLarge) {
+		return fmt.Errorf("entity after offset %d: %w", d.dec.InputOffset(), err)
+	}
+	return fmt.Errorf("at offset %d: %w", d.dec.InputOffset(), err)
+}
+
+// startsWithEntityKey reports whether the JSON object at the start of `br`
+// starts with the key "entity", without consuming any input.
+func startsWithEntityKey(br *bufio.Reader) bool {
+	// Peek returns what's available if the input is shorter than asked for.
+	buf, _ := br.Peek(256)
+	const ws = " \t\r\n"
+	rest, ok := bytes.CutPrefix(bytes.TrimLeft(buf, ws), []byte("{"))
+	if !ok {
+		return false
+	}
+	rest, ok = bytes.CutPrefix(bytes.TrimLeft(rest, ws), []byte(`"entity"`))
+	return ok && bytes.HasPrefix(bytes.TrimLeft(rest, ws), []byte(":"))
+}
+
+func peekNonSpace(br *bufio.Reader) (byte, error) {
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return b, br.UnreadByte()
+	}
+}
+
+// yamlDocumentReader fails reads once a YAML document is longer than
+// `limit` bytes. yaml.Decoder buffers ahead of the document it decodes and
+// doesn't report how far it got, so rather than raising a limit between
+// documents like boundedReader, it finds where each one starts itself: at a
+// line that starts with a "---" marker.
+type yamlDocumentReader struct {
+	r     io.Reader
+	limit int64
+	// n is the number of bytes read since the start of the current
+	// document, and line holds the first bytes of the current line.
+	n    int64
+	line []byte
+	// tooLarge is set once a document exceeded the limit. The bytes read
+	// until then are returned without an error, since yaml.Decoder only
+	// keeps the message of the errors it gets.
+	tooLarge bool
+}
+
+func (y *yamlDocumentReader) Read(p []byte) (int, error) {
+	if y.tooLarge {
+		return 0, errEntityTooLarge
+	}
+	n, err := y.r.Read(p)
+	for i, c := range p[:n] {
+		y.n++
+		if len(y.line) < len("---") {
+			if y.line = append(y.line, c); string(y.line) == "---" {
+				y.n = int64(len(y.line))
+			}
+		}
+		if c == '\n' {
+			y.line = y.line[:0]
+		}
+		if y.n > y.limit {
+			y.tooLarge = true
+			return i, nil
+		}
+	}
+	return n, err
+}
+
+// yamlEntityDecoder decodes the documents of a YAML stream one at a time.
+type yamlEntityDecoder struct {
+	yr  *yamlDocumentReader
+	dec *yaml.Decoder
+	// pending are the remaining entities of a document that held a list.
+	pending []*nbipb.Entity
+	doc     int
+}
+
+func newYAMLEntityDecoder(r io.Reader) *yamlEntityDecoder {
+	yr := &yamlDocumentReader{r: r, limit: maxEncodedEntityBytes}
+	return &yamlEntityDecoder{yr: yr, dec: yaml.NewDecoder(yr)}
+}
+
+func (d *yamlEntityDecoder) next() (*nbipb.Entity, error) {
+	for len(d.pending) == 0 {
+		d.doc++
+		var doc any
+		if err := d.dec.Decode(&doc); errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		} else if err != nil && d.yr.tooLarge {
+			return nil, fmt.Errorf("document %d: %w", d.doc, errEntityTooLarge)
+		} else if err != nil {
+			return nil, fmt.Errorf("document %d: %w", d.doc, err)
+		}
+
+		docs := []any{doc}
+		switch v := doc.(type) {
+		case nil:
+			// Skip empty documents, such as one following a trailing "---".
+			continue
+		case []any:
+			docs = v
+		}
+		for i, v := range docs {
+			e, err := entityFromYAML(v)
+			if err != nil {
+				if len(docs) > 1 {
+					return nil, fmt.Errorf("document %d, entity %d: %w", d.doc, i, err)
+				}
+				return nil, fmt.Errorf("document %d: %w", d.doc, err)
+			}
+			d.pending = append(d.pending, e)
+		}
+	}
+
+	e := d.pending[0]
+	d.pending = d.pending[1:]
+	return e, nil
+}
+
+// entityFromYAML converts a decoded YAML value to an entity by way of its
+// JSON encoding, so it uses the same field names as JSON.
+func entityFromYAML(v any) (*nbipb.Entity, error) {
+	if _, ok := v.(map[string]any); !ok {
+		return nil, fmt.Errorf("expected a mapping, got %T", v)
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	e := &nbipb.Entity{}
+	if err := protojson.Unmarshal(raw, e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// textprotoEntityDecoder decodes a textproto-encoded TxtpbEntities message.
+// The text format can't be decoded incrementally, so the whole file is read
+// first.
+type textprotoEntityDecoder struct {
+	r        io.Reader
+	entities []*nbipb.Entity
+	read     bool
+}
+
+func (d *textprotoEntityDecoder) next() (*nbipb.Entity, error) {
+	if !d.read {
+		d.read = true
+		data, err := io.ReadAll(d.r)
+		if err != nil {
+			return nil, err
+		}
+		entities := &nbipb.TxtpbEntities{}
+		if err := prototext.Unmarshal(data, entities); err != nil {
+			return nil, err
+		}
+		d.entities = entities.GetEntity()
+	}
+	if len(d.entities) == 0 {
+		return nil, io.EOF
+	}
+	e := d.entities[0]
+	d.entities = d.entities[1:]
+	return e, nil
+}
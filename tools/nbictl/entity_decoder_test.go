@@ -0,0 +1,173 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+)
+
+func testEntity(t nbipb.EntityType, id string) *nbipb.Entity {
+	return &nbipb.Entity{Group: &nbipb.EntityGroup{Type: t.Enum()}, Id: &id}
+}
+
+func TestReadEntityFile(t *testing.T) {
+	t.Parallel()
+
+	want := []*nbipb.Entity{
+		testEntity(nbipb.EntityType_NETWORK_NODE, "a"),
+		testEntity(nbipb.EntityType_PLATFORM_DEFINITION, "b"),
+	}
+	for _, tc := range []struct {
+		name, contents string
+	}{
+		{
+			name: "entities.ndjson",
+			contents: `{"group": {"type": "NETWORK_NODE"}, "id": "a"}
+{"group": {"type": "PLATFORM_DEFINITION"}, "id": "b"}
+`,
+		},
+		{
+			name:     "entities.jsonl",
+			contents: `{"group": {"type": "NETWORK_NODE"}, "id": "a"}{"group": {"type": "PLATFORM_DEFINITION"}, "id": "b"}`,
+		},
+		{
+			name: "array.json",
+			contents: `[
+  {"group": {"type": "NETWORK_NODE"}, "id": "a"},
+  {"group": {"type": "PLATFORM_DEFINITION"}, "id": "b"}
+]`,
+		},
+		{
+			name:     "txtpb_entities.json",
+			contents: `{"entity": [{"group": {"type": "NETWORK_NODE"}, "id": "a"}, {"group": {"type": "PLATFORM_DEFINITION"}, "id": "b"}]}`,
+		},
+		{
+			name: "entities.yaml",
+			contents: `group: {type: NETWORK_NODE}
+id: a
+---
+group:
+  type: PLATFORM_DEFINITION
+id: b
+---
+`,
+		},
+		{
+			name: "list.yml",
+			contents: `- group: {type: NETWORK_NODE}
+  id: a
+- group: {type: PLATFORM_DEFINITION}
+  id: b
+`,
+		},
+		{
+			name: "entities.textproto",
+			contents: `entity { group { type: NETWORK_NODE } id: "a" }
+entity { group { type: PLATFORM_DEFINITION } id: "b" }`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(t.TempDir(), tc.name)
+			if err := os.WriteFile(path, []byte(tc.contents), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readEntityFile(path)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(want, got, protocmp.Transform()); diff != "" {
+				t.Errorf("readEntityFile() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestReadEntityFile_single(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "entity.json")
+	if err := os.WriteFile(path, []byte(`{"group": {"type": "NETWORK_NODE"}, "id": "a"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readEntityFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []*nbipb.Entity{testEntity(nbipb.EntityType_NETWORK_NODE, "a")}
+	if diff := cmp.Diff(want, got, protocmp.Transform()); diff != "" {
+		t.Errorf("readEntityFile() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestReadEntityFile_errors(t *testing.T) {
+	t.Parallel()
+
+	for name, contents := range map[string]string{
+		"truncated.json":     `[{"group": {"type": "NETWORK_NODE"}, "id": "a"}`,
+		"trailing.json":      `[{"group": {"type": "NETWORK_NODE"}, "id": "a"}] {}`,
+		"unknown_field.json": `[{"group": {"type": "NETWORK_NODE"}, "idd": "a"}]`,
+		"bad_line.ndjson":    "{\"id\": \"a\"}\n{\"id\": \n",
+		"scalar.yaml":        "a\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(t.TempDir(), name)
+			if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := readEntityFile(path); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+func TestJSONEntityDecoder_boundsEntities(t *testing.T) {
+	t.Parallel()
+
+	huge := `[{"id": "a"}, {"id": "` + strings.Repeat("x", maxEncodedEntityBytes) + `"}]`
+	dec := newEntityDecoder("huge.json", strings.NewReader(huge))
+	if _, err := dec.next(); err != nil {
+		t.Fatalf("unexpected error decoding the first entity: %v", err)
+	}
+	if _, err := dec.next(); !errors.Is(err, errEntityTooLarge) {
+		t.Errorf("got error %v, want %v", err, errEntityTooLarge)
+	}
+}
+
+func TestYAMLEntityDecoder_boundsDocuments(t *testing.T) {
+	t.Parallel()
+
+	huge := "id: a\n---\nid: " + strings.Repeat("x", maxEncodedEntityBytes) + "\n"
+	dec := newEntityDecoder("huge.yaml", strings.NewReader(huge))
+	if _, err := dec.next(); err != nil {
+		t.Fatalf("unexpected error decoding the first entity: %v", err)
+	}
+	if _, err := dec.next(); !errors.Is(err, errEntityTooLarge) {
+		t.Errorf("got error %v, want %v", err, errEntityTooLarge)
+	}
+}
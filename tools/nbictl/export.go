@@ -0,0 +1,391 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	commonpb "aalyria.com/spacetime/api/common"
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+)
+
+// Formats written by `export`.
+const (
+	exportFormatCZML = "czml"
+	exportFormatKML  = "kml"
+
+	defaultExportDuration = 6 * time.Hour
+	defaultExportStepSize = time.Minute
+	// maxExportSamples bounds the number of positions sampled for each
+	// moving platform, since viewers struggle to load many more.
+	maxExportSamples = 100_000
+)
+
+// ExportOptions are the options of ExportTopology.
+type ExportOptions struct {
+	// Format is "czml" or "kml".
+	Format     string
+	Start, End time.Time
+	// Step is how often the positions of moving platforms are sampled.
+	Step time.Duration
+}
+
+// platformTrack is the sampled motion of a platform over the exported window.
+type platformTrack struct {
+	id, name string
+	// fixed is set for platforms that don't move relative to the Earth,
+	// which only have one position.
+	fixed     bool
+	times     []time.Time
+	positions [][3]float64
+}
+
+// positionAt returns the last position sampled at or before `t`.
+func (p *platformTrack) positionAt(t time.Time) [3]float64 {
+	i := sort.Search(len(p.times), func(i int) bool { return p.times[i].After(t) })
+	return p.positions[max(i-1, 0)]
+}
+
+// ExportTopology writes the platforms among `entities`, and the links
+// reported between them, in a format that viewers such as Cesium (CZML) or
+// Google Earth (KML) display over [opts.Start, opts.End]. Platforms are
+// propagated locally, as preview-contacts does, and links are shown while
+// they're reported as accessible. Platforms whose motion can't be
+// propagated, and the links to them, are skipped with a warning.
+func ExportTopology(entities []*nbipb.Entity, opts ExportOptions, streams IOStreams) error {
+	switch {
+	case opts.Format != exportFormatCZML && opts.Format != exportFormatKML:
+		return fmt.Errorf("unknown format %q, expected %s or %s", opts.Format, exportFormatCZML, exportFormatKML)
+	case !opts.End.After(opts.Start):
+		return errors.New("the end of the exported window must be after its start")
+	case opts.Step <= 0:
+		return errors.New("the step size must be positive")
+	case opts.End.Sub(opts.Start)/opts.Step > maxExportSamples:
+		return fmt.Errorf("sampling %s every %s takes more than %d samples, use a larger step size", opts.End.Sub(opts.Start), opts.Step, maxExportSamples)
+	}
+
+	topo := newNetworkTopology(entities)
+	tracks := map[string]*platformTrack{}
+	ids := []string{}
+	for _, id := range topo.platformIDs() {
+		track, err := samplePlatformTrack(id, topo.platforms[id], opts)
+		if err != nil {
+			fmt.Fprintf(streams.ErrOut, "skipping platform %s: %v\n", id, err)
+			continue
+		}
+		tracks[id] = track
+		ids = append(ids, id)
+	}
+	links := []topologyLink{}
+	for _, l := range topo.links {
+		if tracks[l.src.platformID] == nil || tracks[l.dst.platformID] == nil {
+			fmt.Fprintf(streams.ErrOut, "skipping link %s -> %s of %s/%s: the position of one of its ends is unknown\n", l.src, l.dst, l.entityType, l.entityID)
+			continue
+		}
+		links = append(links, l)
+	}
+
+	w := bufio.NewWriter(streams.Out)
+	var err error
+	if opts.Format == exportFormatCZML {
+		err = writeCZML(w, ids, tracks, links, opts)
+	} else {
+		err = writeKML(w, ids, tracks, links, opts)
+	}
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+func samplePlatformTrack(id string, pd *commonpb.PlatformDefinition, opts ExportOptions) (*platformTrack, error) {
+	m, err := motionFromProto(pd.GetCoordinates())
+	if err != nil {
+		return nil, err
+	}
+	track := &platformTrack{id: id, name: pd.GetName()}
+	if track.name == "" {
+		track.name = id
+	}
+	if f, ok := m.(fixedMotion); ok {
+		track.fixed = true
+		track.times, track.positions = []time.Time{opts.Start}, [][3]float64{f}
+		return track, nil
+	}
+	for t := opts.Start; ; t = t.Add(opts.Step) {
+		if t.After(opts.End) {
+			t = opts.End
+		}
+		p, err := m.positionECEF(t)
+		if err != nil {
+			return nil, fmt.Errorf("propagating to %s: %w", t.UTC().Format(time.RFC3339), err)
+		}
+		track.times = append(track.times, t)
+		track.positions = append(track.positions, p)
+		if t.Equal(opts.End) {
+			return track, nil
+		}
+	}
+}
+
+// czmlTime formats `t` as CZML expects.
+func czmlTime(t time.Time) string { return t.UTC().Format(time.RFC3339Nano) }
+
+func czmlInterval(start, end time.Time) string { return czmlTime(start) + "/" + czmlTime(end) }
+
+func czmlColor(rgba [4]int) map[string]any { return map[string]any{"rgba": rgba} }
+
+var (
+	fixedPlatformColor  = [4]int{255, 165, 0, 255}
+	movingPlatformColor = [4]int{0, 191, 255, 255}
+	linkColor           = [4]int{50, 205, 50, 255}
+)
+
+// writeCZML writes a CZML document (see
+// https://github.com/AnalyticalGraphicsInc/czml-writer/wiki/CZML-Guide) with
+// a packet for each platform and link.
+func writeCZML(w io.Writer, ids []string, tracks map[string]*platformTrack, links []topologyLink, opts ExportOptions) error {
+	window := czmlInterval(opts.Start, opts.End)
+	packets := []map[string]any{{
+		"id":      "document",
+		"name":    "Spacetime network topology",
+		"version": "1.0",
+		"clock": map[string]any{
+			"interval":    window,
+			"currentTime": czmlTime(opts.Start),
+			"multiplier":  60,
+			"range":       "LOOP_STOP",
+			"step":        "SYSTEM_CLOCK_MULTIPLIER",
+		},
+	}}
+
+	for _, id := range ids {
+		track := tracks[id]
+		color := movingPlatformColor
+		position := map[string]any{"referenceFrame": "FIXED"}
+		if track.fixed {
+			color = fixedPlatformColor
+			position["cartesian"] = track.positions[0][:]
+		} else {
+			samples := make([]float64, 0, 4*len(track.times))
+			for i, t := range track.times {
+				p := track.positions[i]
+				samples = append(samples, t.Sub(opts.Start).Seconds(), p[0], p[1], p[2])
+			}
+			position["epoch"] = czmlTime(opts.Start)
+			position["interpolationAlgorithm"] = "LAGRANGE"
+			position["interpolationDegree"] = 5
+			position["cartesian"] = samples
+		}
+		packet := map[string]any{
+			"id":           "platform/" + id,
+			"name":         track.name,
+			"availability": window,
+			"position":     position,
+			"point":        map[string]any{"pixelSize": 8, "color": czmlColor(color)},
+			"label": map[string]any{
+				"text":             track.name,
+				"font":             "11pt sans-serif",
+				"horizontalOrigin": "LEFT",
+				"pixelOffset":      map[string]any{"cartesian2": []int{10, 0}},
+				"fillColor":        czmlColor(color),
+			},
+		}
+		if !track.fixed {
+			packet["path"] = map[string]any{
+				"width":      1,
+				"leadTime":   0,
+				"resolution": opts.Step.Seconds(),
+				"material":   map[string]any{"solidColor": map[string]any{"color": czmlColor(color)}},
+			}
+		}
+		packets = append(packets, packet)
+	}
+
+	for _, l := range links {
+		availability := []string{}
+		for _, win := range l.accessibleWindows(opts.Start, opts.End) {
+			availability = append(availability, czmlInterval(win.start, win.end))
+		}
+		if len(availability) == 0 {
+			continue
+		}
+		packets = append(packets, map[string]any{
+			"id":           "link/" + l.src.String() + "->" + l.dst.String(),
+			"name":         l.src.String() + " -> " + l.dst.String(),
+			"availability": availability,
+			"polyline": map[string]any{
+				"positions": map[string]any{"references": []string{"platform/" + l.src.platformID + "#position", "platform/" + l.dst.platformID + "#position"}},
+				"width":     2,
+				"arcType":   "NONE",
+				"material":  map[string]any{"solidColor": map[string]any{"color": czmlColor(linkColor)}},
+			},
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(packets)
+}
+
+// kmlCoordinates formats an ECEF position as KML coordinates.
+func kmlCoordinates(p [3]float64, sep string) string {
+	lat, lon, h := ecefToGeodetic(p)
+	return fmt.Sprintf("%.6f%s%.6f%s%.1f", lon, sep, lat, sep, h)
+}
+
+func kmlEscape(s string) string {
+	b := &strings.Builder{}
+	xml.EscapeText(b, []byte(s))
+	return b.String()
+}
+
+// writeKML writes a KML document, where moving platforms are gx:Tracks and
+// links are lines shown while they're accessible. Since KML lines can't
+// follow moving placemarks, a link to a moving platform is a line for each
+// step of its accessible windows.
+func writeKML(w io.Writer, ids []string, tracks map[string]*platformTrack, links []topologyLink, opts ExportOptions) error {
+	kmlColor := func(rgba [4]int) string {
+		// KML colors are aabbggrr.
+		return fmt.Sprintf("%02x%02x%02x%02x", rgba[3], rgba[2], rgba[1], rgba[0])
+	}
+	kmlTime := func(t time.Time) string { return t.UTC().Format(time.RFC3339) }
+
+	fmt.Fprint(w, xml.Header)
+	fmt.Fprintln(w, `<kml xmlns="http://www.opengis.net/kml/2.2" xmlns:gx="http://www.google.com/kml/ext/2.2">`)
+	fmt.Fprintln(w, "<Document>")
+	fmt.Fprintln(w, "<name>Spacetime network topology</name>")
+	for _, s := range []struct {
+		id    string
+		color [4]int
+	}{{"fixed-platform", fixedPlatformColor}, {"moving-platform", movingPlatformColor}} {
+		fmt.Fprintf(w, `<Style id="%s"><IconStyle><color>%s</color></IconStyle><LineStyle><color>%s</color><width>1</width></LineStyle></Style>`+"\n", s.id, kmlColor(s.color), kmlColor(s.color))
+	}
+	fmt.Fprintf(w, `<Style id="link"><LineStyle><color>%s</color><width>2</width></LineStyle></Style>`+"\n", kmlColor(linkColor))
+
+	fmt.Fprintln(w, "<Folder><name>Platforms</name>")
+	for _, id := range ids {
+		track := tracks[id]
+		fmt.Fprintf(w, "<Placemark><name>%s</name>", kmlEscape(track.name))
+		if track.fixed {
+			fmt.Fprintf(w, "<styleUrl>#fixed-platform</styleUrl><Point><altitudeMode>absolute</altitudeMode><coordinates>%s</coordinates></Point>", kmlCoordinates(track.positions[0], ","))
+		} else {
+			fmt.Fprint(w, "<styleUrl>#moving-platform</styleUrl><gx:Track><altitudeMode>absolute</altitudeMode>\n")
+			for _, t := range track.times {
+				fmt.Fprintf(w, "<when>%s</when>\n", kmlTime(t))
+			}
+			for _, p := range track.positions {
+				fmt.Fprintf(w, "<gx:coord>%s</gx:coord>\n", kmlCoordinates(p, " "))
+			}
+			fmt.Fprint(w, "</gx:Track>")
+		}
+		fmt.Fprintln(w, "</Placemark>")
+	}
+	fmt.Fprintln(w, "</Folder>")
+
+	fmt.Fprintln(w, "<Folder><name>Links</name>")
+	for _, l := range links {
+		src, dst := tracks[l.src.platformID], tracks[l.dst.platformID]
+		name := kmlEscape(l.src.String() + " -> " + l.dst.String())
+		for _, win := range l.accessibleWindows(opts.Start, opts.End) {
+			step := win.end.Sub(win.start)
+			if !src.fixed || !dst.fixed {
+				step = opts.Step
+			}
+			for t := win.start; t.Before(win.end); t = t.Add(step) {
+				end := t.Add(step)
+				if end.After(win.end) {
+					end = win.end
+				}
+				fmt.Fprintf(w, "<Placemark><name>%s</name><styleUrl>#link</styleUrl><TimeSpan><begin>%s</begin><end>%s</end></TimeSpan>", name, kmlTime(t), kmlTime(end))
+				fmt.Fprintf(w, "<LineString><altitudeMode>absolute</altitudeMode><coordinates>%s %s</coordinates></LineString></Placemark>\n", kmlCoordinates(src.positionAt(t), ","), kmlCoordinates(dst.positionAt(t), ","))
+			}
+		}
+	}
+	fmt.Fprintln(w, "</Folder>")
+	fmt.Fprintln(w, "</Document>")
+	_, err := fmt.Fprintln(w, "</kml>")
+	return err
+}
+
+// exportFormat returns the format implied by the extension of `path`.
+func exportFormat(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".czml":
+		return exportFormatCZML, nil
+	case ".kml":
+		return exportFormatKML, nil
+	default:
+		return "", fmt.Errorf("unable to tell the format of %s from its extension, use --format", path)
+	}
+}
+
+func ExportVisualization(appCtx *cli.Context) error {
+	outputFile := appCtx.Path("output_file")
+	format := appCtx.String("format")
+	if format == "" {
+		if outputFile == "" {
+			return errors.New("--format is required when --output_file isn't set")
+		}
+		var err error
+		if format, err = exportFormat(outputFile); err != nil {
+			return err
+		}
+	}
+	opts := ExportOptions{Format: format, Start: time.Now(), Step: appCtx.Duration("step_size")}
+	if ts := appCtx.Timestamp("start_timestamp"); ts != nil {
+		opts.Start = *ts
+	}
+	opts.End = opts.Start.Add(defaultExportDuration)
+	if ts := appCtx.Timestamp("end_timestamp"); ts != nil {
+		opts.End = *ts
+	}
+
+	entities, err := entitiesFromFlags(appCtx, topologyEntityTypes)
+	if err != nil {
+		return err
+	}
+
+	streams := ioStreamsFromContext(appCtx)
+	if outputFile == "" {
+		return ExportTopology(entities, opts, streams)
+	}
+	f, err := os.Create(outputFile)
+	if err != nil {
+		return err
+	}
+	streams.Out = f
+	if err := ExportTopology(entities, opts, streams); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(appCtx.App.ErrWriter, "wrote %s\n", outputFile)
+	return nil
+}
@@ -0,0 +1,168 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestExportTopology_czml(t *testing.T) {
+	t.Parallel()
+
+	streams, stdout, stderr := newTestStreams()
+	opts := ExportOptions{Format: exportFormatCZML, Start: testTopologyStart, End: testTopologyStart.Add(2 * time.Hour), Step: 10 * time.Minute}
+	checkErr(t, ExportTopology(testTopologyEntities(t), opts, streams))
+
+	packets := []struct {
+		ID           string `json:"id"`
+		Availability any    `json:"availability"`
+		Position     struct {
+			Epoch     string    `json:"epoch"`
+			Cartesian []float64 `json:"cartesian"`
+		} `json:"position"`
+		Polyline struct {
+			Positions struct {
+				References []string `json:"references"`
+			} `json:"positions"`
+		} `json:"polyline"`
+	}{}
+	checkErr(t, json.Unmarshal(stdout.Bytes(), &packets))
+
+	ids := []string{}
+	for _, p := range packets {
+		ids = append(ids, p.ID)
+	}
+	wantIDs := []string{"document", "platform/gs", "platform/iss", "link/gs-node/rf->sat-node/rf", "link/iss/tx->gs/tx"}
+	if diff := cmp.Diff(wantIDs, ids); diff != "" {
+		t.Fatalf("packet IDs mismatch (-want +got):\n%s", diff)
+	}
+	if n := len(packets[1].Position.Cartesian); n != 3 {
+		t.Errorf("expected the ground station to have a single position, got %d values", n)
+	}
+	// The ISS is sampled every 10 minutes over 2 hours, inclusive.
+	if n := len(packets[2].Position.Cartesian); n != 13*4 {
+		t.Errorf("expected 13 samples of the ISS position, got %d values", n)
+	}
+	if diff := cmp.Diff([]any{"2008-09-20T12:00:00Z/2008-09-20T13:00:00Z"}, packets[3].Availability); diff != "" {
+		t.Errorf("link availability mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"platform/iss#position", "platform/gs#position"}, packets[4].Polyline.Positions.References); diff != "" {
+		t.Errorf("link references mismatch (-want +got):\n%s", diff)
+	}
+
+	for _, want := range []string{"skipping platform referenced", "skipping link iss/tx -> referenced/tx"} {
+		if !strings.Contains(stderr.String(), want) {
+			t.Errorf("expected stderr to contain %q, got %q", want, stderr.String())
+		}
+	}
+}
+
+func TestExportTopology_kml(t *testing.T) {
+	t.Parallel()
+
+	streams, stdout, _ := newTestStreams()
+	opts := ExportOptions{Format: exportFormatKML, Start: testTopologyStart, End: testTopologyStart.Add(2 * time.Hour), Step: 10 * time.Minute}
+	checkErr(t, ExportTopology(testTopologyEntities(t), opts, streams))
+
+	// The document is well-formed, with a placemark for each platform, and
+	// one for each step of the accessible windows of the links to the ISS:
+	// 6 over the hour of the interface link, and 12 over the whole window
+	// for the transceiver link.
+	placemarks, tracks := 0, 0
+	dec := xml.NewDecoder(stdout)
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		checkErr(t, err)
+		if se, ok := tok.(xml.StartElement); ok {
+			switch se.Name.Local {
+			case "Placemark":
+				placemarks++
+			case "Track":
+				tracks++
+			}
+		}
+	}
+	if placemarks != 2+6+12 || tracks != 1 {
+		t.Errorf("expected 20 placemarks and 1 track, got %d and %d", placemarks, tracks)
+	}
+}
+
+func TestExportTopology_rejectsInvalidOptions(t *testing.T) {
+	t.Parallel()
+
+	valid := ExportOptions{Format: exportFormatCZML, Start: testTopologyStart, End: testTopologyStart.Add(time.Hour), Step: time.Minute}
+	for _, tc := range []struct {
+		name    string
+		modify  func(*ExportOptions)
+		wantErr string
+	}{
+		{"unknown format", func(o *ExportOptions) { o.Format = "gpx" }, `unknown format "gpx"`},
+		{"empty window", func(o *ExportOptions) { o.End = o.Start }, "must be after its start"},
+		{"no step", func(o *ExportOptions) { o.Step = 0 }, "step size must be positive"},
+		{"too many samples", func(o *ExportOptions) { o.Step = time.Millisecond }, "use a larger step size"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			opts := valid
+			tc.modify(&opts)
+			streams, _, _ := newTestStreams()
+			if err := ExportTopology(nil, opts, streams); err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("expected error to contain %q, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestExportVisualization(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	files := filepath.Join(dir, "entities.textproto")
+	checkErr(t, os.WriteFile(files, []byte(testTopologyTextproto), 0o644))
+	out := filepath.Join(dir, "topology.KML")
+
+	app := newTestApp()
+	checkErr(t, app.Run([]string{
+		"nbictl", "export", "--files", files, "--output_file", out,
+		"--start_timestamp", "2008-09-20T11:00:00Z", "--end_timestamp", "2008-09-20T12:00:00Z",
+	}))
+	data, err := os.ReadFile(out)
+	checkErr(t, err)
+	if !strings.Contains(string(data), "<name>Ground station</name>") {
+		t.Errorf("unexpected export:\n%s", data)
+	}
+	if !strings.Contains(app.stderr.String(), "wrote "+out) {
+		t.Errorf("unexpected stderr: %q", app.stderr.String())
+	}
+
+	app = newTestApp()
+	if err := app.Run([]string{"nbictl", "export", "--files", files}); err == nil || !strings.Contains(err.Error(), "--format is required") {
+		t.Errorf("expected an error asking for --format, got %v", err)
+	}
+}
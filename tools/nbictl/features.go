@@ -0,0 +1,93 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/urfave/cli/v2"
+
+	outputv1 "aalyria.com/spacetime/github/tools/nbictl/output/v1"
+)
+
+// featureGates lists the experimental features that can be enabled using
+// the `--enable_feature` flag or the `enabled_features` field of a
+// configuration profile, mapped to a short description of each. Experimental
+// commands should be marked as hidden and have their action wrapped using
+// [requireFeature] so that they ship dark until explicitly enabled.
+var featureGates = map[string]string{}
+
+// enabledFeatures returns the set of features enabled for this invocation,
+// combining the `--enable_feature` flag with the selected configuration
+// profile. Unknown feature names are reported and ignored, so that profiles
+// written by newer versions of nbictl remain usable.
+func enabledFeatures(appCtx *cli.Context) map[string]bool {
+	names := slices.Clone(appCtx.StringSlice("enable_feature"))
+	if confFile, err := getConfFileForContext(appCtx); err == nil {
+		// A missing or ambiguous profile isn't an error here; commands that
+		// need a connection will report it themselves.
+		if conf, err := readConfig(appCtx.String("context"), confFile); err == nil {
+			names = append(names, conf.GetEnabledFeatures()...)
+		}
+	}
+
+	enabled := map[string]bool{}
+	for _, name := range names {
+		if _, ok := featureGates[name]; !ok {
+			fmt.Fprintf(appCtx.App.ErrWriter, "ignoring unknown feature %q\n", name)
+			continue
+		}
+		enabled[name] = true
+	}
+	return enabled
+}
+
+// featureEnabled reports whether the named feature is enabled for this
+// invocation.
+func featureEnabled(appCtx *cli.Context, name string) bool {
+	return enabledFeatures(appCtx)[name]
+}
+
+// requireFeature wraps an action so that it fails with an explanatory
+// message unless the named feature has been enabled.
+func requireFeature(name string, action cli.ActionFunc) cli.ActionFunc {
+	return func(appCtx *cli.Context) error {
+		if !featureEnabled(appCtx, name) {
+			return fmt.Errorf("%q is an experimental feature; enable it with --enable_feature=%s or `set-config --enabled_features=%s`", name, name, name)
+		}
+		return action(appCtx)
+	}
+}
+
+func ListFeatures(appCtx *cli.Context) error {
+	enabled := enabledFeatures(appCtx)
+	if jsonOutputRequested(appCtx) {
+		list := outputv1.NewFeatureList()
+		for _, name := range slices.Sorted(maps.Keys(featureGates)) {
+			list.Features = append(list.Features, outputv1.Feature{Name: name, Description: featureGates[name], Enabled: enabled[name]})
+		}
+		return writeJSONOutput(appCtx, list)
+	}
+	for _, name := range slices.Sorted(maps.Keys(featureGates)) {
+		state := "disabled"
+		if enabled[name] {
+			state = "enabled"
+		}
+		fmt.Fprintf(appCtx.App.Writer, "%s\t%s\t%s\n", name, state, featureGates[name])
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/rules_go/go/tools/bazel"
+	"github.com/urfave/cli/v2"
+)
+
+const testFeature = "test_feature"
+
+func init() {
+	featureGates[testFeature] = "A feature that only exists in tests."
+}
+
+func newTestAppWithGatedCommand() (testApp, *bool) {
+	ran := false
+	app := newTestApp()
+	app.Commands = append(app.Commands, &cli.Command{
+		Name:   "experimental",
+		Hidden: true,
+		Action: requireFeature(testFeature, func(*cli.Context) error {
+			ran = true
+			return nil
+		}),
+	})
+	return app, &ran
+}
+
+func TestRequireFeature_disabledByDefault(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := bazel.NewTmpDir("nbictl")
+	checkErr(t, err)
+
+	app, ran := newTestAppWithGatedCommand()
+	switch want, err := "experimental feature", app.Run([]string{"nbictl", "--config_dir", tmpDir, "experimental"}); {
+	case err == nil:
+		t.Fatal("expected gated command to fail, got nil")
+	case !strings.Contains(err.Error(), want):
+		t.Fatalf("expected error to contain %q, but got %q", want, err.Error())
+	}
+	if *ran {
+		t.Fatal("gated action ran without the feature being enabled")
+	}
+}
+
+func TestRequireFeature_enabledByFlag(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := bazel.NewTmpDir("nbictl")
+	checkErr(t, err)
+
+	app, ran := newTestAppWithGatedCommand()
+	checkErr(t, app.Run([]string{"nbictl", "--config_dir", tmpDir, "--enable_feature", testFeature, "experimental"}))
+	if !*ran {
+		t.Fatal("gated action didn't run with the feature enabled")
+	}
+}
+
+func TestRequireFeature_enabledByConfig(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := bazel.NewTmpDir("nbictl")
+	checkErr(t, err)
+
+	checkErr(t, newTestApp().Run([]string{
+		"nbictl", "--config_dir", tmpDir,
+		"set-config", "--url", "example.com", "--enabled_features", testFeature,
+	}))
+
+	app, ran := newTestAppWithGatedCommand()
+	checkErr(t, app.Run([]string{"nbictl", "--config_dir", tmpDir, "experimental"}))
+	if !*ran {
+		t.Fatal("gated action didn't run with the feature enabled")
+	}
+}
+
+func TestListFeatures(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := bazel.NewTmpDir("nbictl")
+	checkErr(t, err)
+
+	app := newTestApp()
+	checkErr(t, app.Run([]string{"nbictl", "--config_dir", tmpDir, "--enable_feature", testFeature, "--enable_feature", "from_the_future", "list-features"}))
+
+	if want := testFeature + "\tenabled\t"; !strings.Contains(app.stdout.String(), want) {
+		t.Errorf("expected output to contain %q, got %q", want, app.stdout.String())
+	}
+	if want := `ignoring unknown feature "from_the_future"`; !strings.Contains(app.stderr.String(), want) {
+		t.Errorf("expected stderr to contain %q, got %q", want, app.stderr.String())
+	}
+}
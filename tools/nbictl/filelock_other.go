@@ -0,0 +1,50 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !unix
+
+package nbictl
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+const (
+	lockPollInterval = 50 * time.Millisecond
+	// A lock file older than this is assumed to have been left behind by a
+	// process that exited without releasing it.
+	staleLockAge = 1 * time.Minute
+)
+
+// lockFile blocks until it exclusively creates the named file. Platforms
+// without flock(2) fall back to using the existence of the file as the lock.
+func lockFile(path string) (func() error, error) {
+	for {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o600)
+		switch {
+		case err == nil:
+			return func() error { return errors.Join(f.Close(), os.Remove(path)) }, nil
+		case !errors.Is(err, os.ErrExist):
+			return nil, err
+		}
+
+		if fi, err := os.Stat(path); err == nil && time.Since(fi.ModTime()) > staleLockAge {
+			os.Remove(path)
+			continue
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
@@ -27,8 +27,12 @@ import (
 	"fmt"
 	"math"
 	"math/big"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/urfave/cli/v2"
@@ -36,6 +40,7 @@ import (
 
 const (
 	rsaKeysBitSize           = 4096
+	minRSAKeysBitSize        = 2048
 	generatedKeysDirDefault  = "keys"
 	defaultExpirationInYears = 1
 	lenKeyFileName           = 12
@@ -55,8 +60,34 @@ func GenerateKeys(appCtx *cli.Context) error {
 	org := appCtx.String("org")
 	state := appCtx.String("state")
 	location := appCtx.String("location")
+	commonName := appCtx.String("common_name")
+	keySize := appCtx.Int("key_size")
+	name := appCtx.String("name")
 
-	certIssuer := pkix.Name{}
+	if err := validateKeyName(name); err != nil {
+		return err
+	}
+
+	if keySize < minRSAKeysBitSize {
+		return fmt.Errorf("--key_size must be at least %d bits, got %d", minRSAKeysBitSize, keySize)
+	}
+
+	now := time.Now()
+	notAfter := now.AddDate(defaultExpirationInYears, 0, 0)
+	if appCtx.IsSet("expiration") {
+		exp, err := parseExpiration(now, appCtx.String("expiration"))
+		if err != nil {
+			return fmt.Errorf("invalid --expiration: %w", err)
+		}
+		notAfter = exp
+	}
+
+	sans, err := parseSANs(appCtx.StringSlice("san"))
+	if err != nil {
+		return fmt.Errorf("invalid --san: %w", err)
+	}
+
+	certIssuer := pkix.Name{CommonName: commonName}
 
 	if org == "" {
 		return errors.New("missing required key --org: organization for the certification must be provided")
@@ -74,13 +105,9 @@ func GenerateKeys(appCtx *cli.Context) error {
 		certIssuer.Locality = []string{location}
 	}
 
-	generatedKeysDir := directory
-	if generatedKeysDir == "" {
-		configDir, err := os.UserConfigDir()
-		if err != nil {
-			return err
-		}
-		generatedKeysDir = filepath.Join(configDir, appCtx.App.Name, generatedKeysDirDefault)
+	generatedKeysDir, err := getKeysDir(appCtx, directory)
+	if err != nil {
+		return err
 	}
 
 	if err := os.MkdirAll(generatedKeysDir, generatedKeysDirPerm); err != nil {
@@ -96,13 +123,12 @@ func GenerateKeys(appCtx *cli.Context) error {
 		return fmt.Errorf("directory does not have an appropriate permission: must have %v but have %v", generatedKeysDirPerm, dirPerm)
 	}
 
-	now := time.Now()
 	certSerialNumber, err := rand.Int(rand.Reader, big.NewInt(math.MaxInt64))
 	if err != nil {
 		return err
 	}
 
-	privateKey, err := rsa.GenerateKey(rand.Reader, rsaKeysBitSize)
+	privateKey, err := rsa.GenerateKey(rand.Reader, keySize)
 	if err != nil {
 		return fmt.Errorf("unable to generate private key: %w", err)
 	}
@@ -118,11 +144,15 @@ func GenerateKeys(appCtx *cli.Context) error {
 		Subject:               certIssuer,
 		Issuer:                certIssuer,
 		NotBefore:             now,
-		NotAfter:              now.AddDate(defaultExpirationInYears, 0, 0),
+		NotAfter:              notAfter,
 		ExtKeyUsage:           []x509.ExtKeyUsage{},
 		AuthorityKeyId:        authorityKeyId,
 		BasicConstraintsValid: true,
 		IsCA:                  true,
+		DNSNames:              sans.dnsNames,
+		EmailAddresses:        sans.emailAddresses,
+		IPAddresses:           sans.ipAddresses,
+		URIs:                  sans.uris,
 	}
 
 	cert, err := x509.CreateCertificate(rand.Reader, certTemplate, certTemplate, &publicKey, privateKey)
@@ -140,11 +170,23 @@ func GenerateKeys(appCtx *cli.Context) error {
 		Bytes: cert,
 	}
 
-	shaCert := sha256.Sum256(cert)
+	if name == "" {
+		shaCert := sha256.Sum256(cert)
+		name = hex.EncodeToString(shaCert[:lenKeyFileName])
+	}
 
 	rsaKeyPaths := RSAKeyPath{
-		PrivateKeyPath:  filepath.Join(generatedKeysDir, hex.EncodeToString(shaCert[:lenKeyFileName])+".key"),
-		CertificatePath: filepath.Join(generatedKeysDir, hex.EncodeToString(shaCert[:lenKeyFileName])+".crt"),
+		PrivateKeyPath:  filepath.Join(generatedKeysDir, name+".key"),
+		CertificatePath: filepath.Join(generatedKeysDir, name+".crt"),
+	}
+	// Check both paths up front so that a collision on the certificate doesn't
+	// leave behind an orphaned private key.
+	for _, path := range []string{rsaKeyPaths.PrivateKeyPath, rsaKeyPaths.CertificatePath} {
+		if _, err := os.Stat(path); err == nil {
+			return fmt.Errorf("%s already exists; choose a different --name or remove the existing key pair", path)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("unable to check for existing file: %w", err)
+		}
 	}
 
 	privFile, err := os.OpenFile(rsaKeyPaths.PrivateKeyPath, os.O_CREATE|os.O_RDWR|os.O_EXCL, privateKeysFilePerm)
@@ -171,3 +213,107 @@ func GenerateKeys(appCtx *cli.Context) error {
 	fmt.Fprintf(appCtx.App.ErrWriter, "certificate is stored under: %s\n", rsaKeyPaths.CertificatePath)
 	return nil
 }
+
+// getKeysDir returns the directory keys are stored in, which is either the
+// provided directory or the "keys" directory under the user's config
+// directory.
+func getKeysDir(appCtx *cli.Context, directory string) (string, error) {
+	if directory != "" {
+		return directory, nil
+	}
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, appCtx.App.Name, generatedKeysDirDefault), nil
+}
+
+// validateKeyName checks that the value of the `--name` flag can be used as
+// the basename of the generated files.
+func validateKeyName(name string) error {
+	switch {
+	case name == "":
+		return nil
+	case name == "." || name == "..", strings.ContainsAny(name, `/\`):
+		return fmt.Errorf("--name must be a file basename without path separators, got %q", name)
+	case strings.HasSuffix(name, ".key"), strings.HasSuffix(name, ".crt"):
+		return fmt.Errorf("--name must not include the .key or .crt extension, got %q", name)
+	}
+	return nil
+}
+
+// parseExpiration parses the value of the `--expiration` flag, which is either
+// a duration relative to now (a Go duration such as "720h", or a number of
+// days or years such as "90d" or "2y") or an absolute date in RFC 3339 or
+// YYYY-MM-DD format. The resulting time must be in the future.
+func parseExpiration(now time.Time, value string) (time.Time, error) {
+	var exp time.Time
+	if d, err := time.ParseDuration(value); err == nil {
+		exp = now.Add(d)
+	} else if n, unit, ok := parseDaysOrYears(value); ok {
+		switch unit {
+		case 'd':
+			exp = now.AddDate(0, 0, n)
+		case 'y':
+			exp = now.AddDate(n, 0, 0)
+		}
+	} else if t, err := time.Parse(time.RFC3339, value); err == nil {
+		exp = t
+	} else if t, err := time.ParseInLocation(time.DateOnly, value, now.Location()); err == nil {
+		exp = t
+	} else {
+		return time.Time{}, fmt.Errorf("%q is neither a duration (e.g. 720h, 90d, 2y) nor a date (YYYY-MM-DD or RFC 3339)", value)
+	}
+
+	if !exp.After(now) {
+		return time.Time{}, fmt.Errorf("expiration %s is not in the future", exp.Format(time.RFC3339))
+	}
+	return exp, nil
+}
+
+func parseDaysOrYears(value string) (n int, unit byte, ok bool) {
+	if len(value) < 2 {
+		return 0, 0, false
+	}
+	unit = value[len(value)-1]
+	if unit != 'd' && unit != 'y' {
+		return 0, 0, false
+	}
+	n, err := strconv.Atoi(value[:len(value)-1])
+	if err != nil {
+		return 0, 0, false
+	}
+	return n, unit, true
+}
+
+type subjectAltNames struct {
+	dnsNames       []string
+	emailAddresses []string
+	ipAddresses    []net.IP
+	uris           []*url.URL
+}
+
+// parseSANs sorts the values of the `--san` flag into the different kinds of
+// subject alternative names based on their syntax.
+func parseSANs(values []string) (subjectAltNames, error) {
+	sans := subjectAltNames{}
+	for _, v := range values {
+		switch {
+		case v == "":
+			return subjectAltNames{}, errors.New("subject alternative names can't be empty")
+		case net.ParseIP(v) != nil:
+			sans.ipAddresses = append(sans.ipAddresses, net.ParseIP(v))
+		case strings.Contains(v, "://"):
+			uri, err := url.Parse(v)
+			if err != nil {
+				return subjectAltNames{}, err
+			}
+			sans.uris = append(sans.uris, uri)
+		case strings.Contains(v, "@"):
+			sans.emailAddresses = append(sans.emailAddresses, v)
+		default:
+			sans.dnsNames = append(sans.dnsNames, v)
+		}
+	}
+	return sans, nil
+}
@@ -16,12 +16,16 @@ package nbictl
 
 import (
 	"bytes"
+	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/bazelbuild/rules_go/go/tools/bazel"
 )
@@ -197,3 +201,152 @@ func TestGenerateKey_DirPermision(t *testing.T) {
 		t.Fatal("unable to detect wrong directory permission (expected non-nil error, got nil)")
 	}
 }
+
+func TestGenerateKey_CustomKeySizeExpirationAndSANs(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := bazel.NewTmpDir("nbictl")
+	checkErr(t, err)
+
+	keys := generateKeysForTesting(t, tmpDir,
+		"--org", exampleCertOrganization,
+		"--key_size", "2048",
+		"--expiration", "90d",
+		"--common_name", "nbictl.example.com",
+		"--san", "nbictl.example.com",
+		"--san", "192.0.2.1",
+		"--san", "spiffe://example.com/nbictl",
+		"--san", "someone@example.com")
+
+	rawCert, err := os.ReadFile(keys.cert)
+	checkErr(t, err)
+	pemCrtBlock, _ := pem.Decode(rawCert)
+	cert, err := x509.ParseCertificate(pemCrtBlock.Bytes)
+	checkErr(t, err)
+
+	if got := cert.PublicKey.(*rsa.PublicKey).N.BitLen(); got != 2048 {
+		t.Errorf("key size mismatch: want 2048 got %d", got)
+	}
+	if validity := cert.NotAfter.Sub(cert.NotBefore); validity.Round(time.Hour) != 90*24*time.Hour {
+		t.Errorf("validity mismatch: want 90 days got %s", validity)
+	}
+	switch {
+	case cert.Subject.CommonName != "nbictl.example.com":
+		t.Errorf("common name mismatch: want nbictl.example.com got %s", cert.Subject.CommonName)
+	case len(cert.DNSNames) != 1 || cert.DNSNames[0] != "nbictl.example.com":
+		t.Errorf("unexpected DNS names: %v", cert.DNSNames)
+	case len(cert.IPAddresses) != 1 || !cert.IPAddresses[0].Equal(net.ParseIP("192.0.2.1")):
+		t.Errorf("unexpected IP addresses: %v", cert.IPAddresses)
+	case len(cert.URIs) != 1 || cert.URIs[0].String() != "spiffe://example.com/nbictl":
+		t.Errorf("unexpected URIs: %v", cert.URIs)
+	case len(cert.EmailAddresses) != 1 || cert.EmailAddresses[0] != "someone@example.com":
+		t.Errorf("unexpected email addresses: %v", cert.EmailAddresses)
+	}
+}
+
+func TestGenerateKey_RejectsInvalidFlags(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name string
+		args []string
+		want string
+	}{
+		{
+			name: "small key size",
+			args: []string{"--key_size", "1024"},
+			want: "--key_size must be at least 2048 bits",
+		},
+		{
+			name: "expiration in the past",
+			args: []string{"--expiration", "2001-01-01"},
+			want: "is not in the future",
+		},
+		{
+			name: "negative expiration",
+			args: []string{"--expiration", "-1h"},
+			want: "is not in the future",
+		},
+		{
+			name: "unparseable expiration",
+			args: []string{"--expiration", "next tuesday"},
+			want: "is neither a duration",
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			tmpDir, err := bazel.NewTmpDir("nbictl")
+			checkErr(t, err)
+
+			args := append([]string{"nbictl", "generate-keys", "--dir", tmpDir, "--org", exampleCertOrganization}, tc.args...)
+			switch err := newTestApp().Run(args); {
+			case err == nil:
+				t.Fatalf("expected %v to cause an error, got nil", tc.args)
+			case !strings.Contains(err.Error(), tc.want):
+				t.Fatalf("expected error to contain %q, but got %q", tc.want, err.Error())
+			}
+		})
+	}
+}
+
+func TestParseExpiration(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		value string
+		want  time.Time
+	}{
+		{value: "36h", want: now.Add(36 * time.Hour)},
+		{value: "30d", want: now.AddDate(0, 0, 30)},
+		{value: "2y", want: now.AddDate(2, 0, 0)},
+		{value: "2025-01-02", want: time.Date(2025, time.January, 2, 0, 0, 0, 0, time.UTC)},
+		{value: "2025-01-02T03:04:05Z", want: time.Date(2025, time.January, 2, 3, 4, 5, 0, time.UTC)},
+	} {
+		got, err := parseExpiration(now, tc.value)
+		checkErr(t, err)
+		if !got.Equal(tc.want) {
+			t.Errorf("parseExpiration(%q): want %s got %s", tc.value, tc.want, got)
+		}
+	}
+}
+
+func TestGenerateKey_Name(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := bazel.NewTmpDir("nbictl")
+	checkErr(t, err)
+
+	keys := generateKeysForTesting(t, tmpDir, "--org", exampleCertOrganization, "--name", "ci-runner")
+	if want := filepath.Join(tmpDir, "ci-runner.key"); keys.key != want {
+		t.Errorf("private key path mismatch: want %s got %s", want, keys.key)
+	}
+	if want := filepath.Join(tmpDir, "ci-runner.crt"); keys.cert != want {
+		t.Errorf("certificate path mismatch: want %s got %s", want, keys.cert)
+	}
+
+	args := []string{"nbictl", "generate-keys", "--dir", tmpDir, "--org", exampleCertOrganization, "--name", "ci-runner"}
+	switch want, err := "already exists", newTestApp().Run(args); {
+	case err == nil:
+		t.Fatal("expected a name collision to cause an error, got nil")
+	case !strings.Contains(err.Error(), want):
+		t.Fatalf("expected error to contain %q, but got %q", want, err.Error())
+	}
+}
+
+func TestGenerateKey_RejectsNameWithPathSeparator(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := bazel.NewTmpDir("nbictl")
+	checkErr(t, err)
+
+	args := []string{"nbictl", "generate-keys", "--dir", tmpDir, "--org", exampleCertOrganization, "--name", "../escape"}
+	switch want, err := "without path separators", newTestApp().Run(args); {
+	case err == nil:
+		t.Fatal("expected --name with a path separator to cause an error, got nil")
+	case !strings.Contains(err.Error(), want):
+		t.Fatalf("expected error to contain %q, but got %q", want, err.Error())
+	}
+}
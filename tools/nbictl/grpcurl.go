@@ -20,12 +20,9 @@ import (
 	"os"
 	"sort"
 
-	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
-	"github.com/fullstorydev/grpcurl"
 	"github.com/jhump/protoreflect/desc"
 	"github.com/jhump/protoreflect/grpcreflect"
 	"github.com/urfave/cli/v2"
-	"google.golang.org/protobuf/reflect/protoreflect"
 	"google.golang.org/protobuf/types/descriptorpb"
 )
 
@@ -208,50 +205,5 @@ func GRPCCall(appCtx *cli.Context) error {
 		r = rdr
 	}
 
-	conn, err := openConnection(appCtx)
-	if err != nil {
-		return err
-	}
-	defer conn.Close()
-
-	svcDescriptors, err := desc.WrapFiles([]protoreflect.FileDescriptor{
-		nbipb.File_api_nbi_v1alpha_nbi_proto,
-		nbipb.File_api_nbi_v1alpha_signal_propagation_proto,
-	})
-	if err != nil {
-		return err
-	}
-
-	descSrc, err := grpcurl.DescriptorSourceFromFileDescriptors(svcDescriptors...)
-	if err != nil {
-		return err
-	}
-
-	var format grpcurl.Format
-	if appCtx.IsSet("format") {
-		format = grpcurl.Format(appCtx.String("format"))
-	} else {
-		format = grpcurl.Format("json")
-	}
-
-	reqParser, formatter, err := grpcurl.RequestParserAndFormatter(format, descSrc, r, grpcurl.FormatOptions{
-		IncludeTextSeparator: true,
-	})
-	if err != nil {
-		return err
-	}
-
-	return grpcurl.InvokeRPC(
-		appCtx.Context,
-		descSrc,
-		conn,
-		method,
-		[]string{},
-		&grpcurl.DefaultEventHandler{
-			Out:            appCtx.App.Writer,
-			Formatter:      formatter,
-			VerbosityLevel: 0,
-		},
-		reqParser.Next,
-	)
+	return invokeMethod(appCtx, method, r)
 }
@@ -0,0 +1,180 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"google.golang.org/protobuf/proto"
+
+	commonpb "aalyria.com/spacetime/api/common"
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+	outputv1 "aalyria.com/spacetime/github/tools/nbictl/output/v1"
+)
+
+// HistoryOptions are the options of EntityHistory.
+type HistoryOptions struct {
+	Type nbipb.EntityType
+	ID   string
+	// Since and Until limit the versions shown to those committed in
+	// [Since, Until). A zero Since means the Unix epoch, and a zero Until
+	// means now.
+	Since, Until time.Time
+	// JSON prints an outputv1.EntityHistory document instead of text,
+	// rendered with Template if it's set.
+	JSON     bool
+	Template *template.Template
+	// Timestamps is the format of the timestamps of the JSON document.
+	// Defaults to TimestampsRFC3339.
+	Timestamps TimestampFormat
+}
+
+// EntityHistory prints the versions of an entity committed in the interval,
+// who committed each of them, and how each changed the entity.
+func EntityHistory(ctx context.Context, client nbipb.NetOpsClient, opts HistoryOptions, streams IOStreams) error {
+	if opts.Since.IsZero() {
+		opts.Since = time.Unix(0, 0)
+	}
+	if opts.Until.IsZero() {
+		opts.Until = time.Now()
+	}
+	if !opts.Since.Before(opts.Until) {
+		return fmt.Errorf("--since (%s) must be before --until (%s)", opts.Since.Format(time.RFC3339), opts.Until.Format(time.RFC3339))
+	}
+
+	rsp, err := client.ListEntitiesOverTime(ctx, &nbipb.ListEntitiesOverTimeRequest{
+		Type: opts.Type.Enum(),
+		Ids:  []string{opts.ID},
+		// Versions from before --since are needed to diff the first one
+		// shown against.
+		Interval: &commonpb.TimeInterval{
+			StartTime: &commonpb.DateTime{UnixTimeUsec: proto.Int64(0)},
+			EndTime:   &commonpb.DateTime{UnixTimeUsec: proto.Int64(opts.Until.UnixMicro())},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("listing the versions of %s/%s: %w", opts.Type, opts.ID, err)
+	}
+
+	history := entityVersions(opts, rsp.GetEntities())
+	if opts.JSON {
+		return writeDocument(streams.Out, opts.Template, opts.Timestamps, history)
+	}
+	if len(history.Versions) == 0 {
+		fmt.Fprintf(streams.ErrOut, "%s/%s has no versions committed in the interval\n", opts.Type, opts.ID)
+		return nil
+	}
+	for _, v := range history.Versions {
+		header := fmt.Sprintf("%s %s (commit_timestamp %d)", v.CommitTime.Format(time.RFC3339Nano), v.Change, v.CommitTimestamp)
+		if v.ModifiedBy != "" {
+			header += " by " + v.ModifiedBy
+		}
+		fmt.Fprintln(streams.Out, header)
+		if v.Diff == "" {
+			continue
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(v.Diff, "\n"), "\n") {
+			fmt.Fprintf(streams.Out, "    %s\n", line)
+		}
+		fmt.Fprintln(streams.Out)
+	}
+	return nil
+}
+
+// entityVersions converts the `entities` returned by ListEntitiesOverTime
+// into the versions committed since opts.Since. Earlier versions are only
+// used to compute the diff of the first one shown.
+func entityVersions(opts HistoryOptions, entities []*nbipb.Entity) *outputv1.EntityHistory {
+	history := outputv1.NewEntityHistory(opts.Type.String(), opts.ID)
+	since := opts.Since.UnixMicro()
+
+	var prev *nbipb.Entity
+	for _, e := range entities {
+		if e.GetId() != opts.ID {
+			continue
+		}
+		deleted := e.GetValue() == nil
+		if e.GetCommitTimestamp() < since {
+			if !deleted {
+				prev = e
+			}
+			continue
+		}
+
+		v := outputv1.EntityVersion{
+			CommitTime:      time.UnixMicro(e.GetCommitTimestamp()).UTC(),
+			CommitTimestamp: e.GetCommitTimestamp(),
+			ModifiedBy:      e.GetLastModifiedBy(),
+		}
+		switch {
+		case deleted:
+			v.Change = outputv1.ChangeDeleted
+		case prev == nil:
+			v.Change = outputv1.ChangeCreated
+			v.Diff = entityDiff(&nbipb.Entity{}, e)
+		default:
+			v.Change = outputv1.ChangeUpdated
+			v.Diff = entityDiff(prev, e)
+		}
+		history.Versions = append(history.Versions, v)
+
+		if deleted {
+			prev = nil
+		} else {
+			prev = e
+		}
+	}
+	return history
+}
+
+func History(appCtx *cli.Context) error {
+	if ln := appCtx.Args().Len(); ln != 1 {
+		return fmt.Errorf("history expects exactly 1 argument, the entity ID, got %d", ln)
+	}
+	entityType, found := nbipb.EntityType_value[appCtx.String("type")]
+	if !found {
+		return fmt.Errorf("invalid type: %q", appCtx.String("type"))
+	}
+	tmpl, err := outputTemplateFromFlags(appCtx)
+	if err != nil {
+		return err
+	}
+	opts := HistoryOptions{
+		Type:       nbipb.EntityType(entityType),
+		ID:         appCtx.Args().First(),
+		JSON:       jsonOutputRequested(appCtx),
+		Template:   tmpl,
+		Timestamps: timestampFormatFromFlags(appCtx),
+	}
+	if ts := appCtx.Timestamp("since"); ts != nil {
+		opts.Since = *ts
+	}
+	if ts := appCtx.Timestamp("until"); ts != nil {
+		opts.Until = *ts
+	}
+
+	conn, err := openServiceConnection(appCtx, nbipb.NetOps_ServiceDesc.ServiceName)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return EntityHistory(appCtx.Context, nbipb.NewNetOpsClient(conn), opts, ioStreamsFromContext(appCtx))
+}
@@ -0,0 +1,115 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/proto"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+	outputv1 "aalyria.com/spacetime/github/tools/nbictl/output/v1"
+)
+
+// hasDiffLine reports whether `diff` has a line starting with `op`, after
+// any indentation, that contains `substr`.
+func hasDiffLine(diff string, op byte, substr string) bool {
+	for _, line := range strings.Split(diff, "\n") {
+		if line = strings.TrimLeft(line, " "); line != "" && line[0] == op && strings.Contains(line, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestEntityHistory(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	srv, client := startNBITestServer(t)
+	v1 := srv.Put(testNetworkNode("a", "first"))[0]
+	v2 := srv.Put(testNetworkNode("a", "second"))[0]
+	if _, err := client.DeleteEntity(ctx, &nbipb.DeleteEntityRequest{
+		Type:                nbipb.EntityType_NETWORK_NODE.Enum(),
+		Id:                  proto.String("a"),
+		LastCommitTimestamp: v2.CommitTimestamp,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	v4 := srv.Put(testNetworkNode("a", "again"))[0]
+	srv.Put(testNetworkNode("b", "other"))
+
+	t.Run("json", func(t *testing.T) {
+		t.Parallel()
+
+		streams, stdout, _ := newTestStreams()
+		checkErr(t, EntityHistory(ctx, client, HistoryOptions{Type: nbipb.EntityType_NETWORK_NODE, ID: "a", JSON: true}, streams))
+
+		got := outputv1.EntityHistory{}
+		checkErr(t, json.Unmarshal(stdout.Bytes(), &got))
+		changes := []string{}
+		for _, v := range got.Versions {
+			changes = append(changes, v.Change)
+		}
+		want := []string{outputv1.ChangeCreated, outputv1.ChangeUpdated, outputv1.ChangeDeleted, outputv1.ChangeCreated}
+		if diff := cmp.Diff(want, changes); diff != "" {
+			t.Fatalf("changes mismatch: (-want +got):\n%s", diff)
+		}
+		if got.Versions[0].CommitTimestamp != v1.GetCommitTimestamp() || got.Versions[3].CommitTimestamp != v4.GetCommitTimestamp() {
+			t.Errorf("unexpected commit timestamps: %+v", got.Versions)
+		}
+		if d := got.Versions[1].Diff; !hasDiffLine(d, '-', `"first"`) || !hasDiffLine(d, '+', `"second"`) {
+			t.Errorf("expected the update's diff to change the name, got:\n%s", d)
+		}
+	})
+
+	t.Run("since", func(t *testing.T) {
+		t.Parallel()
+
+		streams, stdout, _ := newTestStreams()
+		checkErr(t, EntityHistory(ctx, client, HistoryOptions{
+			Type:  nbipb.EntityType_NETWORK_NODE,
+			ID:    "a",
+			Since: time.UnixMicro(v2.GetCommitTimestamp()),
+			Until: time.UnixMicro(v4.GetCommitTimestamp()),
+		}, streams))
+
+		out := stdout.String()
+		if n := strings.Count(out, "commit_timestamp "); n != 2 {
+			t.Errorf("expected 2 versions, got %d:\n%s", n, out)
+		}
+		// The update is still diffed against the version before --since.
+		if !strings.Contains(out, "updated") || !hasDiffLine(out, '-', `"first"`) || strings.Contains(out, "again") {
+			t.Errorf("unexpected output:\n%s", out)
+		}
+	})
+}
+
+func TestEntityHistory_rejectsEmptyInterval(t *testing.T) {
+	t.Parallel()
+
+	_, client := startNBITestServer(t)
+	streams, _, _ := newTestStreams()
+	now := time.Now()
+	err := EntityHistory(context.Background(), client, HistoryOptions{Type: nbipb.EntityType_NETWORK_NODE, ID: "a", Since: now, Until: now}, streams)
+	if want := "must be before --until"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("expected error to contain %q, got %v", want, err)
+	}
+}
@@ -0,0 +1,404 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+
+	commonpb "aalyria.com/spacetime/api/common"
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+	"aalyria.com/spacetime/github/tools/nbictl/entity"
+	"aalyria.com/spacetime/github/tools/nbictl/nbictlpb"
+)
+
+// Formats of the files read by `import`.
+const (
+	importFormatCSV     = "csv"
+	importFormatGeoJSON = "geojson"
+)
+
+// ImportOptions are the options of ImportSites.
+type ImportOptions struct {
+	// Format is "csv" or "geojson".
+	Format string
+	// Mapping maps the columns of the file to the fields of the entities.
+	// Its empty fields, or all of them if it's nil, take the defaults
+	// documented in ImportMapping.
+	Mapping *nbictlpb.ImportMapping
+}
+
+// ImportSites reads sites, such as ground stations, from a CSV or GeoJSON
+// file and returns a platform definition for each, along with an antenna
+// pattern if the mapping asks for one. Every site is checked, and the error
+// lists all of those that are invalid.
+func ImportSites(r io.Reader, opts ImportOptions) ([]*nbipb.Entity, error) {
+	var sites []site
+	var err error
+	switch opts.Format {
+	case importFormatCSV:
+		sites, err = readCSVSites(r)
+	case importFormatGeoJSON:
+		sites, err = readGeoJSONSites(r)
+	default:
+		return nil, fmt.Errorf("unknown format %q, expected %s or %s", opts.Format, importFormatCSV, importFormatGeoJSON)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m := withImportDefaults(opts.Mapping)
+	entities := []*nbipb.Entity{}
+	errs := []error{}
+	seen := map[string]string{}
+	for _, s := range sites {
+		es, err := m.siteEntities(s)
+		if err == nil {
+			if prev, ok := seen[es[0].GetId()]; ok {
+				err = fmt.Errorf("duplicate ID %q, also used by %s", es[0].GetId(), prev)
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", s.where, err))
+			continue
+		}
+		seen[es[0].GetId()] = s.where
+		entities = append(entities, es...)
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("%d of %d sites are invalid:\n%w", len(errs), len(sites), errors.Join(errs...))
+	}
+	return entities, nil
+}
+
+// site is a row of a CSV file, or a feature of a GeoJSON file.
+type site struct {
+	// where locates the site in the file, for errors.
+	where string
+	// values are keyed by the lowercased column name.
+	values map[string]string
+	// featureID is the ID of a GeoJSON feature, if it has one.
+	featureID string
+	// point holds the longitude, latitude and optionally the height of a
+	// GeoJSON Point feature.
+	point []float64
+}
+
+func (s site) get(column string) string { return s.values[strings.ToLower(column)] }
+
+// float returns the value of `column`, or `def` if it's empty.
+func (s site) float(column string, def float64) (float64, error) {
+	v := s.get(column)
+	if v == "" {
+		return def, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: not a number", column, v)
+	}
+	return f, nil
+}
+
+func readCSVSites(r io.Reader) ([]site, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("the file is empty")
+	} else if err != nil {
+		return nil, fmt.Errorf("reading the header: %w", err)
+	}
+	for i, h := range header {
+		// Spreadsheets often start their exports with a byte order mark.
+		header[i] = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+	}
+
+	sites := []site{}
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return sites, nil
+		} else if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		s := site{where: fmt.Sprintf("line %d", line), values: map[string]string{}}
+		for i, v := range row {
+			s.values[header[i]] = strings.TrimSpace(v)
+		}
+		sites = append(sites, s)
+	}
+}
+
+// geoJSONObject is a GeoJSON FeatureCollection or Feature.
+type geoJSONObject struct {
+	Type     string          `json:"type"`
+	Features []geoJSONObject `json:"features"`
+	// ID is the optional identifier of a Feature, a string or a number.
+	ID       any `json:"id"`
+	Geometry *struct {
+		Type        string          `json:"type"`
+		Coordinates json.RawMessage `json:"coordinates"`
+	} `json:"geometry"`
+	Properties map[string]any `json:"properties"`
+}
+
+func readGeoJSONSites(r io.Reader) ([]site, error) {
+	obj := geoJSONObject{}
+	if err := json.NewDecoder(r).Decode(&obj); err != nil {
+		return nil, fmt.Errorf("invalid GeoJSON: %w", err)
+	}
+	var features []geoJSONObject
+	switch obj.Type {
+	case "FeatureCollection":
+		features = obj.Features
+	case "Feature":
+		features = []geoJSONObject{obj}
+	default:
+		return nil, fmt.Errorf("invalid GeoJSON: expected a FeatureCollection or Feature, got %q", obj.Type)
+	}
+
+	sites := []site{}
+	for i, f := range features {
+		s := site{where: fmt.Sprintf("feature %d", i), values: map[string]string{}}
+		for k, v := range f.Properties {
+			s.values[strings.ToLower(k)] = geoJSONPropertyString(v)
+		}
+		if f.ID != nil {
+			s.featureID = geoJSONPropertyString(f.ID)
+		}
+		switch g := f.Geometry; {
+		case g == nil:
+		case g.Type != "Point":
+			return nil, fmt.Errorf("%s: unsupported geometry %s, only Point is supported", s.where, g.Type)
+		default:
+			if err := json.Unmarshal(g.Coordinates, &s.point); err != nil || len(s.point) < 2 {
+				return nil, fmt.Errorf("%s: invalid Point coordinates %s", s.where, g.Coordinates)
+			}
+		}
+		sites = append(sites, s)
+	}
+	return sites, nil
+}
+
+func geoJSONPropertyString(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.TrimSpace(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+}
+
+// importMapping is an ImportMapping with the defaults filled in.
+type importMapping struct {
+	*nbictlpb.ImportMapping
+}
+
+func withImportDefaults(m *nbictlpb.ImportMapping) importMapping {
+	m = proto.Clone(cmp.Or(m, &nbictlpb.ImportMapping{})).(*nbictlpb.ImportMapping)
+	m.IdColumn = cmp.Or(m.IdColumn, "id")
+	m.NameColumn = cmp.Or(m.NameColumn, "name")
+	m.LatitudeColumn = cmp.Or(m.LatitudeColumn, "lat")
+	m.LongitudeColumn = cmp.Or(m.LongitudeColumn, "lon")
+	m.AltitudeColumn = cmp.Or(m.AltitudeColumn, "altitude")
+	m.TypeColumn = cmp.Or(m.TypeColumn, "type")
+	if a := m.Antenna; a != nil {
+		a.PatternColumn = cmp.Or(a.PatternColumn, "antenna_pattern")
+		a.DiameterMColumn = cmp.Or(a.DiameterMColumn, "antenna_diameter_m")
+		a.EfficiencyPercentColumn = cmp.Or(a.EfficiencyPercentColumn, "antenna_efficiency_percent")
+		a.BacklobeGainDbColumn = cmp.Or(a.BacklobeGainDbColumn, "antenna_backlobe_gain_db")
+	}
+	return importMapping{m}
+}
+
+// siteEntities returns the platform definition of `s`, followed by its
+// antenna pattern if the mapping has one.
+func (m importMapping) siteEntities(s site) ([]*nbipb.Entity, error) {
+	id := cmp.Or(s.get(m.IdColumn), s.featureID)
+	if id == "" {
+		return nil, fmt.Errorf("missing %s", m.IdColumn)
+	}
+	name := cmp.Or(s.get(m.NameColumn), id)
+	id = m.IdPrefix + id
+
+	errs := []error{}
+	pos := entity.Position{}
+	if len(s.point) >= 2 {
+		pos.LongitudeDeg, pos.LatitudeDeg = s.point[0], s.point[1]
+		if len(s.point) > 2 {
+			pos.HeightM = s.point[2]
+		}
+	} else {
+		for _, c := range []struct {
+			column string
+			dst    *float64
+		}{{m.LatitudeColumn, &pos.LatitudeDeg}, {m.LongitudeColumn, &pos.LongitudeDeg}} {
+			if s.get(c.column) == "" {
+				errs = append(errs, fmt.Errorf("missing %s", c.column))
+				continue
+			}
+			var err error
+			if *c.dst, err = s.float(c.column, 0); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	var err error
+	if pos.HeightM, err = s.float(m.AltitudeColumn, pos.HeightM); err != nil {
+		errs = append(errs, err)
+	}
+
+	platform := entity.NewPlatform(id, name, pos.Motion())
+	if typ := cmp.Or(s.get(m.TypeColumn), m.DefaultType); typ != "" {
+		platform.Definition.Type = proto.String(typ)
+	}
+	var pattern *entity.AntennaPattern
+	if m.Antenna != nil {
+		if pattern, err = m.antennaPattern(id+"-antenna", s); err != nil {
+			errs = append(errs, err)
+		} else {
+			platform.Definition.TransceiverModel = append(platform.Definition.TransceiverModel, &commonpb.TransceiverModel{
+				Id: proto.String("transceiver-model"),
+				Antenna: &commonpb.AntennaDefinition{
+					Name:             proto.String("antenna"),
+					AntennaPatternId: proto.String(pattern.ID),
+				},
+			})
+			errs = append(errs, pattern.Validate())
+		}
+	}
+	if len(errs) == 0 {
+		// Only validate the platform once its fields could all be read, so
+		// errors aren't reported twice.
+		errs = append(errs, platform.Validate())
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	entities := []*nbipb.Entity{platform.Proto()}
+	if pattern != nil {
+		entities = append(entities, pattern.Proto())
+	}
+	return entities, nil
+}
+
+func (m importMapping) antennaPattern(id string, s site) (*entity.AntennaPattern, error) {
+	a := m.Antenna
+	kind := strings.ToLower(cmp.Or(s.get(a.PatternColumn), a.DefaultPattern))
+	switch kind {
+	case "isotropic":
+		return entity.NewIsotropicAntennaPattern(id), nil
+	case "parabolic", "gaussian":
+		errs := []error{}
+		ap := entity.Aperture{}
+		for _, c := range []struct {
+			column string
+			def    float64
+			dst    *float64
+		}{
+			{a.DiameterMColumn, a.DefaultDiameterM, &ap.DiameterM},
+			{a.EfficiencyPercentColumn, a.DefaultEfficiencyPercent, &ap.EfficiencyPercent},
+			{a.BacklobeGainDbColumn, a.DefaultBacklobeGainDb, &ap.BacklobeGainDb},
+		} {
+			var err error
+			if *c.dst, err = s.float(c.column, c.def); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if err := errors.Join(errs...); err != nil {
+			return nil, err
+		}
+		if kind == "parabolic" {
+			return entity.NewParabolicAntennaPattern(id, ap), nil
+		}
+		return entity.NewGaussianAntennaPattern(id, ap), nil
+	case "":
+		return nil, fmt.Errorf("missing %s, and the mapping has no default_pattern", a.PatternColumn)
+	default:
+		return nil, fmt.Errorf("unknown %s %q, expected parabolic, gaussian or isotropic", a.PatternColumn, kind)
+	}
+}
+
+// importFormat returns the format of `path` implied by its extension.
+func importFormat(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return importFormatCSV, nil
+	case ".geojson", ".json":
+		return importFormatGeoJSON, nil
+	default:
+		return "", fmt.Errorf("unable to tell the format of %s from its extension, use --format", path)
+	}
+}
+
+func readImportMapping(path string) (*nbictlpb.ImportMapping, error) {
+	m := &nbictlpb.ImportMapping{}
+	if path == "" {
+		return m, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := prototext.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("invalid mapping %s: %w", path, err)
+	}
+	return m, nil
+}
+
+func ImportEntities(appCtx *cli.Context) error {
+	path := appCtx.Path("file")
+	format := appCtx.String("format")
+	if format == "" {
+		var err error
+		if format, err = importFormat(path); err != nil {
+			return err
+		}
+	}
+	mapping, err := readImportMapping(appCtx.Path("mapping"))
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	entities, err := ImportSites(f, ImportOptions{Format: format, Mapping: mapping})
+	if err != nil {
+		return fmt.Errorf("importing %s: %w", path, err)
+	}
+	return writeOrCreateEntities(appCtx, entities)
+}
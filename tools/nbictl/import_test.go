@@ -0,0 +1,185 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	commonpb "aalyria.com/spacetime/api/common"
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+	"aalyria.com/spacetime/github/tools/nbictl/entity"
+	"aalyria.com/spacetime/github/tools/nbictl/nbictlpb"
+)
+
+// testSite returns the platform definition that's expected to be imported
+// for a site, with an antenna pattern if `pattern` isn't nil.
+func testSite(id, name, typ string, pos entity.Position, pattern *entity.AntennaPattern) []*nbipb.Entity {
+	p := entity.NewPlatform(id, name, pos.Motion())
+	if typ != "" {
+		p.Definition.Type = proto.String(typ)
+	}
+	if pattern == nil {
+		return []*nbipb.Entity{p.Proto()}
+	}
+	p.Definition.TransceiverModel = []*commonpb.TransceiverModel{{
+		Id: proto.String("transceiver-model"),
+		Antenna: &commonpb.AntennaDefinition{
+			Name:             proto.String("antenna"),
+			AntennaPatternId: proto.String(pattern.ID),
+		},
+	}}
+	return []*nbipb.Entity{p.Proto(), pattern.Proto()}
+}
+
+func TestImportSites_csv(t *testing.T) {
+	t.Parallel()
+
+	// Spreadsheets export a byte order mark and whatever case the header
+	// was typed in.
+	in := "\ufeffID, Name ,LAT,lon,altitude,type\n" +
+		"svalbard,Svalbard,78.23,15.39,500,GROUND_STATION\n" +
+		"hawaii,,19.82,-155.47,,\n"
+	got, err := ImportSites(strings.NewReader(in), ImportOptions{Format: importFormatCSV})
+	checkErr(t, err)
+
+	want := append(
+		testSite("svalbard", "Svalbard", "GROUND_STATION", entity.Position{LatitudeDeg: 78.23, LongitudeDeg: 15.39, HeightM: 500}, nil),
+		testSite("hawaii", "hawaii", "", entity.Position{LatitudeDeg: 19.82, LongitudeDeg: -155.47}, nil)...)
+	if diff := cmp.Diff(want, got, protocmp.Transform()); diff != "" {
+		t.Errorf("imported entities mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestImportSites_mapping(t *testing.T) {
+	t.Parallel()
+
+	in := "site,latitude,longitude,dish,dish_m\n" +
+		"a,10,20,,3.7\n" +
+		"b,-10,-20,isotropic,\n"
+	got, err := ImportSites(strings.NewReader(in), ImportOptions{
+		Format: importFormatCSV,
+		Mapping: &nbictlpb.ImportMapping{
+			IdColumn:        "site",
+			IdPrefix:        "gs-",
+			LatitudeColumn:  "latitude",
+			LongitudeColumn: "longitude",
+			DefaultType:     "GROUND_STATION",
+			Antenna: &nbictlpb.ImportMapping_Antenna{
+				PatternColumn:            "dish",
+				DiameterMColumn:          "dish_m",
+				DefaultPattern:           "parabolic",
+				DefaultEfficiencyPercent: 55,
+			},
+		},
+	})
+	checkErr(t, err)
+
+	want := append(
+		testSite("gs-a", "a", "GROUND_STATION", entity.Position{LatitudeDeg: 10, LongitudeDeg: 20},
+			entity.NewParabolicAntennaPattern("gs-a-antenna", entity.Aperture{DiameterM: 3.7, EfficiencyPercent: 55})),
+		testSite("gs-b", "b", "GROUND_STATION", entity.Position{LatitudeDeg: -10, LongitudeDeg: -20},
+			entity.NewIsotropicAntennaPattern("gs-b-antenna"))...)
+	if diff := cmp.Diff(want, got, protocmp.Transform()); diff != "" {
+		t.Errorf("imported entities mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestImportSites_geoJSON(t *testing.T) {
+	t.Parallel()
+
+	in := `{
+  "type": "FeatureCollection",
+  "features": [
+    {"type": "Feature", "id": 7, "geometry": {"type": "Point", "coordinates": [15.39, 78.23, 500]}, "properties": {"Name": "Svalbard"}},
+    {"type": "Feature", "geometry": {"type": "Point", "coordinates": [-155.47, 19.82]}, "properties": {"id": "hawaii", "altitude": 4200}}
+  ]
+}`
+	got, err := ImportSites(strings.NewReader(in), ImportOptions{Format: importFormatGeoJSON})
+	checkErr(t, err)
+
+	want := append(
+		testSite("7", "Svalbard", "", entity.Position{LatitudeDeg: 78.23, LongitudeDeg: 15.39, HeightM: 500}, nil),
+		testSite("hawaii", "hawaii", "", entity.Position{LatitudeDeg: 19.82, LongitudeDeg: -155.47, HeightM: 4200}, nil)...)
+	if diff := cmp.Diff(want, got, protocmp.Transform()); diff != "" {
+		t.Errorf("imported entities mismatch (-want +got):\n%s", diff)
+	}
+
+	_, err = ImportSites(strings.NewReader(`{"type": "Feature", "geometry": {"type": "LineString", "coordinates": [[0, 0], [1, 1]]}}`), ImportOptions{Format: importFormatGeoJSON})
+	if err == nil || !strings.Contains(err.Error(), "only Point is supported") {
+		t.Errorf("expected an unsupported geometry error, got %v", err)
+	}
+}
+
+func TestImportSites_reportsEveryInvalidSite(t *testing.T) {
+	t.Parallel()
+
+	in := "id,lat,lon\n" +
+		"ok,1,2\n" +
+		",1,2\n" +
+		"north,91,0\n" +
+		"text,one,2\n" +
+		"ok,3,4\n"
+	_, err := ImportSites(strings.NewReader(in), ImportOptions{Format: importFormatCSV})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, want := range []string{
+		"4 of 5 sites are invalid",
+		"line 3: missing id",
+		"line 4: ",
+		`line 5: invalid lat "one": not a number`,
+		`line 6: duplicate ID "ok", also used by line 2`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected error to contain %q, got:\n%v", want, err)
+		}
+	}
+}
+
+func TestImportEntities(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	sites := filepath.Join(dir, "sites.csv")
+	checkErr(t, os.WriteFile(sites, []byte("name,lat,lon\nsvalbard,78.23,15.39\n"), 0o644))
+	mapping := filepath.Join(dir, "mapping.textproto")
+	checkErr(t, os.WriteFile(mapping, []byte(`id_column: "name" id_prefix: "gs-" antenna { default_pattern: "isotropic" }`), 0o644))
+	out := filepath.Join(dir, "entities.ndjson")
+
+	app := newTestApp()
+	checkErr(t, app.Run([]string{"nbictl", "import", "--file", sites, "--mapping", mapping, "--output_file", out}))
+	got, err := readEntityFile(out)
+	checkErr(t, err)
+	want := testSite("gs-svalbard", "svalbard", "", entity.Position{LatitudeDeg: 78.23, LongitudeDeg: 15.39}, entity.NewIsotropicAntennaPattern("gs-svalbard-antenna"))
+	if diff := cmp.Diff(want, got, protocmp.Transform()); diff != "" {
+		t.Errorf("imported entities mismatch (-want +got):\n%s", diff)
+	}
+	if !strings.Contains(app.stderr.String(), "wrote 2 entities") {
+		t.Errorf("unexpected stderr: %q", app.stderr.String())
+	}
+
+	// The format can't be told from the extension of a .txt file.
+	app = newTestApp()
+	if err := app.Run([]string{"nbictl", "import", "--file", filepath.Join(dir, "sites.txt")}); err == nil || !strings.Contains(err.Error(), "use --format") {
+		t.Errorf("expected an error asking for --format, got %v", err)
+	}
+}
@@ -0,0 +1,335 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"google.golang.org/protobuf/proto"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+	"aalyria.com/spacetime/github/tools/nbictl/entity"
+)
+
+const defaultTLEIDPrefix = "norad-"
+
+// SatelliteTLE is a two-line element set read from a TLE file.
+type SatelliteTLE struct {
+	entity.TLE
+	// Name is the title line that preceded the element set, if any.
+	Name string
+	// NoradID is the satellite catalog number, without leading zeros.
+	NoradID string
+	Epoch   time.Time
+}
+
+// ReadTLEs reads the element sets of a TLE file, in either the two-line or
+// three-line format, where each set is preceded by the name of the satellite
+// (optionally prefixed with "0 ", as Space-Track does). Every set is
+// checked, and the error lists all of those that are invalid.
+func ReadTLEs(r io.Reader) ([]SatelliteTLE, error) {
+	tles := []SatelliteTLE{}
+	errs := []error{}
+	name, pending, pendingLine := "", "", 0
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimRight(s.Text(), " \t\r")
+		if n == 1 {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+		switch {
+		case strings.TrimSpace(line) == "":
+		case strings.HasPrefix(line, "1 "):
+			if pending != "" {
+				errs = append(errs, fmt.Errorf("line %d: line 1 isn't followed by line 2", pendingLine))
+			}
+			pending, pendingLine = line, n
+		case strings.HasPrefix(line, "2 ") && pending != "":
+			t, err := parseSatelliteTLE(name, pending, line)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("line %d: %w", pendingLine, err))
+			} else {
+				tles = append(tles, t)
+			}
+			name, pending = "", ""
+		case strings.HasPrefix(line, "2 "):
+			errs = append(errs, fmt.Errorf("line %d: line 2 isn't preceded by line 1", n))
+		default:
+			if pending != "" {
+				errs = append(errs, fmt.Errorf("line %d: line 1 isn't followed by line 2", pendingLine))
+				pending = ""
+			}
+			name = strings.TrimSpace(strings.TrimPrefix(line, "0 "))
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if pending != "" {
+		errs = append(errs, fmt.Errorf("line %d: line 1 isn't followed by line 2", pendingLine))
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("%d element sets are invalid:\n%w", len(errs), errors.Join(errs...))
+	}
+	return tles, nil
+}
+
+func parseSatelliteTLE(name, line1, line2 string) (SatelliteTLE, error) {
+	p, err := parseTLE(line1, line2)
+	if err != nil {
+		return SatelliteTLE{}, err
+	}
+	id1, id2 := tleNoradID(line1), tleNoradID(line2)
+	if id1 != id2 {
+		return SatelliteTLE{}, fmt.Errorf("line 1 is for satellite %s, but line 2 is for satellite %s", id1, id2)
+	}
+	return SatelliteTLE{TLE: entity.TLE{Line1: line1, Line2: line2}, Name: name, NoradID: id1, Epoch: p.epoch}, nil
+}
+
+// tleNoradID returns the satellite catalog number of a TLE line, or "" if
+// the line is too short to hold one.
+func tleNoradID(line string) string {
+	if len(line) < 7 {
+		return ""
+	}
+	return normalizeNoradID(line[2:7])
+}
+
+// normalizeNoradID strips the spaces and leading zeros of a NORAD ID.
+func normalizeNoradID(id string) string {
+	id = strings.TrimSpace(id)
+	if trimmed := strings.TrimLeft(id, "0"); trimmed != "" || id == "" {
+		return trimmed
+	}
+	return "0"
+}
+
+// TLEImportOptions are the options of ImportTLEs.
+type TLEImportOptions struct {
+	TLEs []SatelliteTLE
+	// IDPrefix is prepended to the NORAD ID of a satellite to form the ID of
+	// the platform created for it.
+	IDPrefix string
+	// UpdateOnly skips the satellites that no platform matches, instead of
+	// creating platforms for them.
+	UpdateOnly bool
+	Bulk       BulkOptions
+}
+
+// ImportTLEs matches each element set to the platform definitions whose TLE
+// has the same NORAD ID, and updates their motion if the element set is
+// newer. Satellites that no platform matches get a new platform, unless
+// opts.UpdateOnly is set. When a file holds several element sets for the
+// same satellite, the one with the latest epoch is used.
+func ImportTLEs(ctx context.Context, client nbipb.NetOpsClient, opts TLEImportOptions, streams IOStreams) error {
+	latest := map[string]SatelliteTLE{}
+	order := []string{}
+	for _, t := range opts.TLEs {
+		prev, ok := latest[t.NoradID]
+		if !ok {
+			order = append(order, t.NoradID)
+		}
+		if !ok || t.Epoch.After(prev.Epoch) {
+			latest[t.NoradID] = t
+		}
+	}
+
+	res, err := client.ListEntities(ctx, &nbipb.ListEntitiesRequest{Type: nbipb.EntityType_PLATFORM_DEFINITION.Enum()})
+	if err != nil {
+		return fmt.Errorf("listing platform definitions: %w", err)
+	}
+	platforms := map[string][]*nbipb.Entity{}
+	for _, e := range res.GetEntities() {
+		if id := tleNoradID(e.GetPlatform().GetCoordinates().GetTle().GetLine1()); id != "" {
+			platforms[id] = append(platforms[id], e)
+		}
+	}
+
+	creates, updates := []*nbipb.Entity{}, []*nbipb.Entity{}
+	upToDate := 0
+	for _, id := range order {
+		t := latest[id]
+		matches := platforms[id]
+		if len(matches) == 0 {
+			if opts.UpdateOnly {
+				fmt.Fprintf(streams.ErrOut, "skipping satellite %s: no platform definition has its NORAD ID\n", id)
+				continue
+			}
+			creates = append(creates, entity.NewPlatform(opts.IDPrefix+id, t.Name, t.Motion()).Proto())
+			continue
+		}
+		for _, e := range matches {
+			current := e.GetPlatform().GetCoordinates().GetTle()
+			if current.GetLine1() == t.Line1 && current.GetLine2() == t.Line2 {
+				upToDate++
+				continue
+			}
+			if p, err := parseTLE(current.GetLine1(), current.GetLine2()); err == nil && !p.epoch.Before(t.Epoch) {
+				fmt.Fprintf(streams.ErrOut, "keeping the TLE of platform %s: its epoch is %s, which isn't older than %s\n", e.GetId(), p.epoch.Format(time.RFC3339), t.Epoch.Format(time.RFC3339))
+				upToDate++
+				continue
+			}
+			// The commit timestamp of the listed entity is kept, so that
+			// changes made since it was listed aren't overwritten.
+			u := proto.Clone(e).(*nbipb.Entity)
+			u.GetPlatform().Coordinates = t.Motion()
+			updates = append(updates, u)
+		}
+	}
+	fmt.Fprintf(streams.ErrOut, "%d platform definitions to create, %d to update, %d up to date\n", len(creates), len(updates), upToDate)
+
+	errs := []error{}
+	if len(updates) > 0 {
+		errs = append(errs, UpdateEntities(ctx, client, UpdateOptions{Entities: updates, Bulk: opts.Bulk}, streams))
+	}
+	if len(creates) > 0 {
+		errs = append(errs, CreateEntities(ctx, client, CreateOptions{Entities: creates, Bulk: opts.Bulk}, streams))
+	}
+	return errors.Join(errs...)
+}
+
+func readTLEFile(path string) ([]SatelliteTLE, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	tles, err := ReadTLEs(f)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return tles, nil
+}
+
+// tleSourceFromFlags returns a description of the source of element sets
+// selected by the flags, and a function that reads them from it.
+func tleSourceFromFlags(appCtx *cli.Context) (string, func(context.Context) ([]SatelliteTLE, error), error) {
+	path, group, spaceTrack := appCtx.Path("file"), appCtx.String("celestrak_group"), appCtx.Bool("space_track")
+	noradIDs := []string{}
+	for _, id := range strings.Split(appCtx.String("norad_ids"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			noradIDs = append(noradIDs, normalizeNoradID(id))
+		}
+	}
+	// onlyNoradIDs keeps the element sets of --norad_ids, if it's set.
+	onlyNoradIDs := func(tles []SatelliteTLE) []SatelliteTLE {
+		if len(noradIDs) == 0 {
+			return tles
+		}
+		return slices.DeleteFunc(tles, func(t SatelliteTLE) bool { return !slices.Contains(noradIDs, t.NoradID) })
+	}
+	httpClient := &http.Client{Timeout: tleFetchTimeout}
+
+	n := 0
+	for _, set := range []bool{path != "", group != "", spaceTrack} {
+		if set {
+			n++
+		}
+	}
+	switch {
+	case n == 0:
+		return "", nil, errors.New("one of --file, --celestrak_group, or --space_track is required")
+	case n > 1:
+		return "", nil, errors.New("only one of --file, --celestrak_group, or --space_track can be set")
+	case path != "":
+		return path, func(context.Context) ([]SatelliteTLE, error) {
+			tles, err := readTLEFile(path)
+			return onlyNoradIDs(tles), err
+		}, nil
+	case group != "":
+		return "Celestrak group " + group, func(ctx context.Context) ([]SatelliteTLE, error) {
+			tles, err := FetchCelestrakTLEs(ctx, httpClient, appCtx.String("celestrak_url"), group)
+			return onlyNoradIDs(tles), err
+		}, nil
+	default:
+		creds := SpaceTrackCredentials{Identity: appCtx.String("space_track_identity"), Password: appCtx.String("space_track_password")}
+		if creds.Identity == "" || creds.Password == "" {
+			return "", nil, errors.New("--space_track requires --space_track_identity and --space_track_password")
+		}
+		return "Space-Track", func(ctx context.Context) ([]SatelliteTLE, error) {
+			return FetchSpaceTrackTLEs(ctx, httpClient, appCtx.String("space_track_url"), creds, noradIDs)
+		}, nil
+	}
+}
+
+// ImportTLEEntities imports the element sets of the --file flag, or fetched
+// from Celestrak or Space-Track. With --refresh, it keeps running, reading
+// them again at that interval, so the platforms are kept up to date without
+// a cron job.
+func ImportTLEEntities(appCtx *cli.Context) error {
+	refresh := appCtx.Duration("refresh")
+	if refresh < 0 {
+		return fmt.Errorf("--refresh can't be negative, got %s", refresh)
+	}
+	source, readTLEs, err := tleSourceFromFlags(appCtx)
+	if err != nil {
+		return err
+	}
+	if refresh > 0 && refresh < minTLEFetchInterval && appCtx.Path("file") == "" {
+		return fmt.Errorf("--refresh must be at least %s when fetching element sets, got %s", minTLEFetchInterval, refresh)
+	}
+	// Report an invalid source before connecting.
+	tles, err := readTLEs(appCtx.Context)
+	if err != nil {
+		return err
+	}
+	bulk, err := bulkOptionsFromFlags(appCtx)
+	if err != nil {
+		return err
+	}
+	conn, err := openServiceConnection(appCtx, nbipb.NetOps_ServiceDesc.ServiceName)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	client := nbipb.NewNetOpsClient(conn)
+	streams := ioStreamsFromContext(appCtx)
+	for {
+		if err == nil {
+			err = ImportTLEs(appCtx.Context, client, TLEImportOptions{
+				TLEs:       tles,
+				IDPrefix:   appCtx.String("id_prefix"),
+				UpdateOnly: appCtx.Bool("update_only"),
+				Bulk:       bulk,
+			}, streams)
+		}
+		if refresh == 0 {
+			return err
+		}
+		if err != nil {
+			// Keep refreshing, since the next element sets or a later
+			// attempt might succeed.
+			fmt.Fprintf(appCtx.App.ErrWriter, "importing from %s failed: %v\n", source, err)
+		}
+		fmt.Fprintf(appCtx.App.ErrWriter, "refreshing at %s\n", time.Now().Add(refresh).Local().Format(time.RFC3339))
+		select {
+		case <-appCtx.Context.Done():
+			return appCtx.Context.Err()
+		case <-time.After(refresh):
+		}
+		tles, err = readTLEs(appCtx.Context)
+	}
+}
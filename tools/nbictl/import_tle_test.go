@@ -0,0 +1,157 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+	"aalyria.com/spacetime/github/tools/nbictl/entity"
+)
+
+const (
+	issTLELine1 = "1 25544U 98067A   08264.51782528 -.00002182  00000-0 -11606-4 0  2927"
+	issTLELine2 = "2 25544  51.6416 247.4627 0006703 130.5360 325.0288 15.72125391563537"
+	// issNextTLELine1 has an epoch a day after that of issTLELine1.
+	issNextTLELine1 = "1 25544U 98067A   08265.51782528 -.00002182  00000-0 -11606-4 0  2928"
+)
+
+func TestReadTLEs(t *testing.T) {
+	t.Parallel()
+
+	in := "0 ISS (ZARYA)\n" + issTLELine1 + "\n" + issTLELine2 + "\r\n\n" +
+		vanguardTLELine1 + "\n" + vanguardTLELine2 + "\n"
+	got, err := ReadTLEs(strings.NewReader(in))
+	checkErr(t, err)
+
+	want := []SatelliteTLE{
+		{
+			TLE:     entity.TLE{Line1: issTLELine1, Line2: issTLELine2},
+			Name:    "ISS (ZARYA)",
+			NoradID: "25544",
+			Epoch:   time.Date(2008, time.September, 20, 12, 25, 40, 104_192_000, time.UTC),
+		},
+		{
+			TLE:     entity.TLE{Line1: vanguardTLELine1, Line2: vanguardTLELine2},
+			NoradID: "5",
+			Epoch:   time.Date(2000, time.June, 27, 18, 50, 19, 733_568_000, time.UTC),
+		},
+	}
+	if diff := cmp.Diff(want, got, cmp.Comparer(func(a, b time.Time) bool { return a.Sub(b).Abs() < time.Millisecond })); diff != "" {
+		t.Errorf("element sets mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestReadTLEs_reportsEveryInvalidSet(t *testing.T) {
+	t.Parallel()
+
+	in := strings.Join([]string{
+		issTLELine1[:68] + "0",
+		issTLELine2,
+		vanguardTLELine2,
+		vanguardTLELine1,
+		"VANGUARD 1",
+		vanguardTLELine1,
+		issTLELine2,
+	}, "\n")
+	_, err := ReadTLEs(strings.NewReader(in))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, want := range []string{
+		"4 element sets are invalid",
+		"line 1: TLE line 1 has an invalid checksum",
+		"line 3: line 2 isn't preceded by line 1",
+		"line 4: line 1 isn't followed by line 2",
+		"line 6: line 1 is for satellite 5, but line 2 is for satellite 25544",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected error to contain %q, got:\n%v", want, err)
+		}
+	}
+}
+
+func TestImportTLEs(t *testing.T) {
+	t.Parallel()
+
+	srv, client := startNBITestServer(t)
+	srv.Put(
+		entity.NewPlatform("iss", "ISS", entity.TLE{Line1: issTLELine1, Line2: issTLELine2}.Motion()).Proto(),
+		entity.NewPlatform("gs", "Ground station", entity.Position{LatitudeDeg: 1, LongitudeDeg: 2}.Motion()).Proto(),
+	)
+	importTLEs := func(opts TLEImportOptions, in string) string {
+		t.Helper()
+
+		tles, err := ReadTLEs(strings.NewReader(in))
+		checkErr(t, err)
+		opts.TLEs = tles
+		streams, _, stderr := newTestStreams()
+		checkErr(t, ImportTLEs(context.Background(), client, opts, streams))
+		return stderr.String()
+	}
+	platformTLE := func(id string) entity.TLE {
+		t.Helper()
+
+		e, ok := srv.Entity(nbipb.EntityType_PLATFORM_DEFINITION, id)
+		if !ok {
+			t.Fatalf("platform definition %s doesn't exist", id)
+		}
+		p, err := entity.PlatformFromProto(e)
+		checkErr(t, err)
+		tle, _ := p.TLE()
+		return tle
+	}
+
+	// The ISS is updated, and a platform is created for Vanguard, but not
+	// with --update_only.
+	next := "ISS\n" + issNextTLELine1 + "\n" + issTLELine2 + "\nVANGUARD 1\n" + vanguardTLELine1 + "\n" + vanguardTLELine2 + "\n"
+	if out := importTLEs(TLEImportOptions{IDPrefix: "norad-", UpdateOnly: true}, next); !strings.Contains(out, "skipping satellite 5") {
+		t.Errorf("unexpected stderr: %q", out)
+	}
+	if _, ok := srv.Entity(nbipb.EntityType_PLATFORM_DEFINITION, "norad-5"); ok {
+		t.Error("a platform definition was created with --update_only")
+	}
+	importTLEs(TLEImportOptions{IDPrefix: "norad-"}, next)
+	if diff := cmp.Diff(entity.TLE{Line1: issNextTLELine1, Line2: issTLELine2}, platformTLE("iss")); diff != "" {
+		t.Errorf("ISS TLE mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(entity.TLE{Line1: vanguardTLELine1, Line2: vanguardTLELine2}, platformTLE("norad-5")); diff != "" {
+		t.Errorf("Vanguard TLE mismatch (-want +got):\n%s", diff)
+	}
+	vanguard, _ := srv.Entity(nbipb.EntityType_PLATFORM_DEFINITION, "norad-5")
+	if got := vanguard.GetPlatform().GetName(); got != "VANGUARD 1" {
+		t.Errorf("expected the new platform to be named VANGUARD 1, got %q", got)
+	}
+
+	// Importing the same file again, or an older element set, changes
+	// nothing.
+	before, _ := srv.Entity(nbipb.EntityType_PLATFORM_DEFINITION, "iss")
+	if out := importTLEs(TLEImportOptions{IDPrefix: "norad-"}, next); !strings.Contains(out, "0 platform definitions to create, 0 to update, 2 up to date") {
+		t.Errorf("unexpected stderr: %q", out)
+	}
+	if out := importTLEs(TLEImportOptions{IDPrefix: "norad-"}, issTLELine1+"\n"+issTLELine2); !strings.Contains(out, "keeping the TLE of platform iss") {
+		t.Errorf("unexpected stderr: %q", out)
+	}
+	after, _ := srv.Entity(nbipb.EntityType_PLATFORM_DEFINITION, "iss")
+	if diff := cmp.Diff(before, after, protocmp.Transform()); diff != "" {
+		t.Errorf("ISS changed (-before +after):\n%s", diff)
+	}
+}
@@ -0,0 +1,278 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// jwtHashes are the hashes of the JWT signing algorithms that inspect-token
+// can verify, keyed by their "alg" header.
+var jwtHashes = map[string]crypto.Hash{
+	"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+	"PS256": crypto.SHA256, "PS384": crypto.SHA384, "PS512": crypto.SHA512,
+	"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+}
+
+// InspectToken decodes a JWT and checks the things that most often make the
+// server reject one: whether it has expired, whether its audience is the
+// server of the selected context, and whether it was signed by the key of a
+// certificate. It prints the outcome of each check, and fails if any of
+// them did.
+func InspectToken(appCtx *cli.Context) error {
+	raw, err := tokenArg(appCtx)
+	if err != nil {
+		return err
+	}
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("expected a JWT of 3 dot-separated parts, got %d", len(parts))
+	}
+	header, err := decodeJWTPart(parts[0])
+	if err != nil {
+		return fmt.Errorf("decoding the header: %w", err)
+	}
+	claims, err := decodeJWTPart(parts[1])
+	if err != nil {
+		return fmt.Errorf("decoding the claims: %w", err)
+	}
+
+	w := appCtx.App.Writer
+	for _, p := range []struct {
+		name string
+		part map[string]any
+	}{{"header", header}, {"claims", claims}} {
+		b, err := json.MarshalIndent(p.part, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s: %s\n", p.name, b)
+	}
+
+	failed := 0
+	report := func(check string, ok bool, format string, args ...any) {
+		result := "ok"
+		if !ok {
+			result = "FAILED"
+			failed++
+		}
+		fmt.Fprintf(w, "%-10s %-7s %s\n", check+":", result, fmt.Sprintf(format, args...))
+	}
+	skip := func(check string, format string, args ...any) {
+		fmt.Fprintf(w, "%-10s %-7s %s\n", check+":", "skipped", fmt.Sprintf(format, args...))
+	}
+
+	now := time.Now()
+	switch exp, ok := numericClaim(claims, "exp"); {
+	case !ok:
+		report("expiry", false, "the token has no exp claim")
+	case now.After(exp):
+		report("expiry", false, "expired %s ago, at %s", now.Sub(exp).Round(time.Second), exp.Format(time.RFC3339))
+	default:
+		report("expiry", true, "expires in %s, at %s", exp.Sub(now).Round(time.Second), exp.Format(time.RFC3339))
+	}
+	if nbf, ok := numericClaim(claims, "nbf"); ok && now.Before(nbf) {
+		report("not-before", false, "not valid for another %s", nbf.Sub(now).Round(time.Second))
+	}
+
+	confFile, err := getConfFileForContext(appCtx)
+	if err != nil {
+		return err
+	}
+	setting, err := readConfig(appCtx.String("context"), confFile)
+	if err != nil {
+		skip("audience", "no context to compare against: %v", err)
+	} else {
+		want, err := tokenAudience(setting)
+		if err != nil {
+			return err
+		}
+		got := audiences(claims["aud"])
+		if slices.Contains(got, want) {
+			report("audience", true, "%q matches context %q", want, setting.GetName())
+		} else {
+			report("audience", false, "the token is for %q, but the server of context %q expects %q", strings.Join(got, ", "), setting.GetName(), want)
+		}
+	}
+
+	certPath := appCtx.Path("cert")
+	if certPath == "" {
+		certPath = profileCert(setting.GetPrivKey())
+	}
+	if certPath == "" {
+		skip("signature", "pass --cert to verify it")
+	} else {
+		alg, _ := header["alg"].(string)
+		if err := verifyJWTSignature(alg, parts, certPath); err != nil {
+			report("signature", false, "not signed by the key of %s: %v", certPath, err)
+		} else {
+			report("signature", true, "signed by the key of %s", certPath)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("the token failed %d check(s)", failed)
+	}
+	return nil
+}
+
+// tokenArg returns the token passed on the command line, read from stdin if
+// it's "-".
+func tokenArg(appCtx *cli.Context) (string, error) {
+	if appCtx.NArg() != 1 {
+		return "", errors.New("expected exactly one token, or - to read it from stdin")
+	}
+	raw := appCtx.Args().First()
+	if raw == "-" {
+		b, err := io.ReadAll(appCtx.App.Reader)
+		if err != nil {
+			return "", fmt.Errorf("reading the token from stdin: %w", err)
+		}
+		raw = string(b)
+	}
+	// Accept tokens copied along with their authorization header.
+	raw = strings.TrimPrefix(strings.TrimSpace(raw), "Bearer ")
+	return raw, nil
+}
+
+func decodeJWTPart(part string) (map[string]any, error) {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]any{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// numericClaim returns the time of a NumericDate claim, if it's set.
+func numericClaim(claims map[string]any, name string) (time.Time, bool) {
+	n, ok := claims[name].(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	secs, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(secs*float64(time.Second))), true
+}
+
+// audiences returns the values of an "aud" claim, which can be a string or
+// an array of them.
+func audiences(aud any) []string {
+	switch a := aud.(type) {
+	case string:
+		return []string{a}
+	case []any:
+		auds := []string{}
+		for _, v := range a {
+			if s, ok := v.(string); ok {
+				auds = append(auds, s)
+			}
+		}
+		return auds
+	default:
+		return nil
+	}
+}
+
+// profileCert returns the certificate that generate-keys writes next to the
+// private key at `privKey`, if it exists.
+func profileCert(privKey string) string {
+	if !strings.HasSuffix(privKey, ".key") {
+		return ""
+	}
+	cert := strings.TrimSuffix(privKey, ".key") + ".crt"
+	if _, err := os.Stat(cert); err != nil {
+		return ""
+	}
+	return cert
+}
+
+// verifyJWTSignature checks that the JWT made of `parts` was signed with
+// `alg` by the key of the PEM-encoded certificate at `certPath`.
+func verifyJWTSignature(alg string, parts []string, certPath string) error {
+	hash, ok := jwtHashes[alg]
+	if !ok {
+		return fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return err
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return errors.New("the certificate isn't PEM-encoded")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return fmt.Errorf("decoding the signature: %w", err)
+	}
+
+	h := hash.New()
+	h.Write([]byte(parts[0] + "." + parts[1]))
+	digest := h.Sum(nil)
+
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		switch alg[:2] {
+		case "RS":
+			return rsa.VerifyPKCS1v15(pub, hash, digest, sig)
+		case "PS":
+			return rsa.VerifyPSS(pub, hash, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+	case *ecdsa.PublicKey:
+		if alg[:2] != "ES" {
+			break
+		}
+		// JWS encodes ECDSA signatures as the concatenation of r and s.
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return fmt.Errorf("expected a %d byte signature, got %d bytes", 2*size, len(sig))
+		}
+		r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("the %T key of the certificate can't verify %s signatures", cert.PublicKey, alg)
+}
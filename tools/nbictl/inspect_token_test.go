@@ -0,0 +1,92 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/rules_go/go/tools/bazel"
+)
+
+func TestInspectToken(t *testing.T) {
+	t.Parallel()
+
+	dir, err := bazel.NewTmpDir("nbictl")
+	checkErr(t, err)
+	keys := generateKeysForTesting(t, dir, "--org", exampleCertOrganization)
+	setConfig := func(url string) {
+		checkErr(t, newTestApp().Run([]string{
+			"nbictl", "--config_dir", dir,
+			"set-config",
+			"--user_id", "usr1",
+			"--key_id", "key1",
+			"--priv_key", keys.key,
+			"--url", url,
+		}))
+	}
+	setConfig("spacetime.example.com:443")
+
+	app := newTestApp()
+	checkErr(t, app.Run([]string{"nbictl", "--config_dir", dir, "create-token"}))
+	token := strings.TrimSpace(app.stdout.String())
+
+	t.Run("valid", func(t *testing.T) {
+		app := newTestApp()
+		checkErr(t, app.Run([]string{"nbictl", "--config_dir", dir, "inspect-token", token}))
+		out := app.stdout.String()
+		for _, want := range []string{`"alg": "RS384"`, `"iss": "usr1"`, "expiry:    ok", "audience:  ok", "signature: ok"} {
+			if !strings.Contains(out, want) {
+				t.Errorf("expected output to contain %q, got:\n%s", want, out)
+			}
+		}
+	})
+
+	t.Run("from stdin", func(t *testing.T) {
+		app := newTestApp()
+		app.Reader = strings.NewReader("Bearer " + token + "\n")
+		checkErr(t, app.Run([]string{"nbictl", "--config_dir", dir, "inspect-token", "-"}))
+	})
+
+	t.Run("tampered", func(t *testing.T) {
+		parts := strings.Split(token, ".")
+		tampered := parts[0] + "." + parts[1] + "." + strings.Repeat("A", len(parts[2]))
+		app := newTestApp()
+		err := app.Run([]string{"nbictl", "--config_dir", dir, "inspect-token", "--cert", keys.cert, tampered})
+		if err == nil || !strings.Contains(app.stdout.String(), "signature: FAILED") {
+			t.Errorf("expected the signature check to fail, got error %v and output:\n%s", err, app.stdout.String())
+		}
+	})
+
+	t.Run("wrong audience", func(t *testing.T) {
+		// Not parallel: changes the URL of the context the other subtests
+		// check the audience against.
+		setConfig("other.example.com:443")
+		app := newTestApp()
+		err := app.Run([]string{"nbictl", "--config_dir", dir, "inspect-token", token})
+		if err == nil || !strings.Contains(app.stdout.String(), `expects "other.example.com:443"`) {
+			t.Errorf("expected the audience check to fail, got error %v and output:\n%s", err, app.stdout.String())
+		}
+	})
+}
+
+func TestInspectToken_malformed(t *testing.T) {
+	t.Parallel()
+
+	err := newTestApp().Run([]string{"nbictl", "--config_dir", t.TempDir(), "inspect-token", "not-a-jwt"})
+	if err == nil || !strings.Contains(err.Error(), "expected a JWT") {
+		t.Errorf("expected a malformed token error, got %v", err)
+	}
+}
@@ -0,0 +1,249 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/fullstorydev/grpcurl"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/grpcreflect"
+	"github.com/urfave/cli/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// spacetimeProtoPackage is the prefix of the proto package of every Spacetime
+// API that nbictl bundles descriptors for.
+const spacetimeProtoPackage = "aalyria.spacetime."
+
+func Invoke(appCtx *cli.Context) error {
+	args := positionalArgs(appCtx)
+	if ln := len(args); ln != 1 {
+		return fmt.Errorf("invoke expects exactly 1 argument, got %d", ln)
+	}
+
+	r, err := invokeRequestData(appCtx, appCtx.String("data"))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	return invokeMethod(appCtx, args[0], r)
+}
+
+type positionalArgsKey struct{}
+
+// withInterspersedFlags lets the flags of a command follow its arguments, as
+// in `invoke SERVICE.METHOD -d @request.json`. urfave/cli stops parsing flags
+// at the first argument, so the remaining ones are parsed here and set on the
+// command's context before `action` runs. The arguments that aren't flags are
+// then returned by positionalArgs.
+func withInterspersedFlags(action cli.ActionFunc) cli.ActionFunc {
+	return func(appCtx *cli.Context) error {
+		set := flag.NewFlagSet(appCtx.Command.Name, flag.ContinueOnError)
+		set.SetOutput(io.Discard)
+		for _, f := range appCtx.Command.Flags {
+			if err := f.Apply(set); err != nil {
+				return err
+			}
+		}
+
+		rest, args := appCtx.Args().Slice(), []string{}
+		for len(rest) > 0 {
+			if err := set.Parse(rest); err != nil {
+				return err
+			}
+			if n := len(rest) - len(set.Args()); n > 0 && rest[n-1] == "--" {
+				// Everything after a "--" is an argument.
+				args = append(args, set.Args()...)
+				break
+			}
+			if rest = set.Args(); len(rest) > 0 {
+				args, rest = append(args, rest[0]), rest[1:]
+			}
+		}
+
+		visited := map[string]*flag.Flag{}
+		set.Visit(func(f *flag.Flag) { visited[f.Name] = f })
+		for _, f := range appCtx.Command.Flags {
+			i := slices.IndexFunc(f.Names(), func(name string) bool { return visited[name] != nil })
+			if i < 0 {
+				continue
+			}
+			// Like urfave/cli, set every alias of the flag so it can be
+			// looked up by any of them.
+			value := visited[f.Names()[i]].Value.String()
+			for _, name := range f.Names() {
+				if err := appCtx.Set(name, value); err != nil {
+					return err
+				}
+			}
+			// urfave/cli only runs the actions of the flags it parsed itself.
+			if af, ok := f.(cli.ActionableFlag); ok {
+				if err := af.RunAction(appCtx); err != nil {
+					return err
+				}
+			}
+		}
+
+		appCtx.Context = context.WithValue(appCtx.Context, positionalArgsKey{}, args)
+		return action(appCtx)
+	}
+}
+
+// positionalArgs returns the arguments of the command, without the flags
+// that withInterspersedFlags parsed among them.
+func positionalArgs(appCtx *cli.Context) []string {
+	if args, ok := appCtx.Context.Value(positionalArgsKey{}).([]string); ok {
+		return args
+	}
+	return appCtx.Args().Slice()
+}
+
+// invokeRequestData returns the request body given by the value of the
+// --data flag: "@-" reads it from stdin, "@path" from a file, and anything
+// else is used as-is. An empty value sends an empty request.
+func invokeRequestData(appCtx *cli.Context, data string) (io.ReadCloser, error) {
+	switch {
+	case data == "@-":
+		return io.NopCloser(appCtx.App.Reader), nil
+	case strings.HasPrefix(data, "@"):
+		return os.Open(strings.TrimPrefix(data, "@"))
+	default:
+		return io.NopCloser(strings.NewReader(data)), nil
+	}
+}
+
+// invokeMethod calls the fully-qualified `method` with the requests read from
+// `r` and writes the responses to the app's writer, both encoded in the
+// format selected by the --format flag.
+func invokeMethod(appCtx *cli.Context, method string, r io.Reader) error {
+	conn, err := openConnection(appCtx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	descSrc, cleanup, err := newInvokeDescriptorSource(appCtx.Context, conn)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	format := grpcurl.Format("json")
+	if appCtx.IsSet("format") {
+		format = grpcurl.Format(appCtx.String("format"))
+	}
+
+	reqParser, formatter, err := grpcurl.RequestParserAndFormatter(format, descSrc, r, grpcurl.FormatOptions{
+		IncludeTextSeparator: true,
+	})
+	if err != nil {
+		return err
+	}
+	if format == grpcurl.FormatJSON {
+		formatter = timestampFormatFromFlags(appCtx).grpcurlFormatter(formatter)
+	}
+
+	h := &grpcurl.DefaultEventHandler{
+		Out:            appCtx.App.Writer,
+		Formatter:      formatter,
+		VerbosityLevel: 0,
+	}
+	if err := grpcurl.InvokeRPC(appCtx.Context, descSrc, conn, method, []string{}, h, reqParser.Next); err != nil {
+		return err
+	}
+	if h.Status != nil && h.Status.Err() != nil {
+		return fmt.Errorf("calling %s: %w", method, h.Status.Err())
+	}
+	return nil
+}
+
+// newInvokeDescriptorSource returns a descriptor source that asks the
+// server's reflection service about symbols first, and falls back to the
+// descriptors of the Spacetime APIs bundled into nbictl for servers that
+// don't support reflection or don't expose a symbol through it.
+func newInvokeDescriptorSource(ctx context.Context, conn grpc.ClientConnInterface) (grpcurl.DescriptorSource, func(), error) {
+	bundled, err := bundledDescriptorSource()
+	if err != nil {
+		return nil, nil, err
+	}
+	refClient := grpcreflect.NewClientAuto(ctx, conn)
+	return &fallbackDescriptorSource{
+		primary:  grpcurl.DescriptorSourceFromServer(ctx, refClient),
+		fallback: bundled,
+	}, refClient.Reset, nil
+}
+
+// bundledDescriptorSource returns a descriptor source for every Spacetime
+// service linked into nbictl.
+func bundledDescriptorSource() (grpcurl.DescriptorSource, error) {
+	files := []protoreflect.FileDescriptor{}
+	protoregistry.GlobalFiles.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		if strings.HasPrefix(string(fd.Package()), spacetimeProtoPackage) && fd.Services().Len() > 0 {
+			files = append(files, fd)
+		}
+		return true
+	})
+	sort.Slice(files, func(i, j int) bool { return files[i].Path() < files[j].Path() })
+
+	wrapped, err := desc.WrapFiles(files)
+	if err != nil {
+		return nil, err
+	}
+	return grpcurl.DescriptorSourceFromFileDescriptors(wrapped...)
+}
+
+// fallbackDescriptorSource is a grpcurl.DescriptorSource that looks symbols
+// up in `fallback` when `primary` can't provide them.
+type fallbackDescriptorSource struct {
+	primary, fallback grpcurl.DescriptorSource
+}
+
+func (s *fallbackDescriptorSource) ListServices() ([]string, error) {
+	svcs, err := s.primary.ListServices()
+	if err != nil {
+		return s.fallback.ListServices()
+	}
+	return svcs, nil
+}
+
+func (s *fallbackDescriptorSource) FindSymbol(fullyQualifiedName string) (desc.Descriptor, error) {
+	d, err := s.primary.FindSymbol(fullyQualifiedName)
+	if err == nil {
+		return d, nil
+	}
+	if d, fallbackErr := s.fallback.FindSymbol(fullyQualifiedName); fallbackErr == nil {
+		return d, nil
+	}
+	return nil, err
+}
+
+func (s *fallbackDescriptorSource) AllExtensionsForType(typeName string) ([]*desc.FieldDescriptor, error) {
+	exts, err := s.primary.AllExtensionsForType(typeName)
+	if err != nil {
+		return s.fallback.AllExtensionsForType(typeName)
+	}
+	return exts, nil
+}
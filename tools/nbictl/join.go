@@ -0,0 +1,275 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+)
+
+var joinFlag = &cli.StringSliceFlag{
+	Name:  "join",
+	Usage: "Field of referenced entities to show next to each reference, e.g. `platform.name` to show the name of the platform referenced by each platform_id. The first part of the path selects the type of the referenced entities. Can be repeated. In text output the values are added as textproto comments, and in table output as extra columns.",
+}
+
+// entityJoin is a field of referenced entities, such as "platform.name",
+// that's shown alongside the references to them.
+type entityJoin struct {
+	path       string
+	entityType nbipb.EntityType
+	// fields is the path from the Entity message to the joined field.
+	fields []protoreflect.FieldDescriptor
+}
+
+// parseJoins resolves the paths provided with `--join` against the Entity
+// message.
+func parseJoins(specs []string) ([]entityJoin, error) {
+	joins := []entityJoin{}
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		j := entityJoin{path: spec}
+		md := (&nbipb.Entity{}).ProtoReflect().Descriptor()
+		for i, name := range strings.Split(spec, ".") {
+			if md == nil {
+				return nil, fmt.Errorf("invalid --join %q: %s isn't a message", spec, strings.Join(strings.Split(spec, ".")[:i], "."))
+			}
+			fd := md.Fields().ByTextName(name)
+			if fd == nil {
+				return nil, fmt.Errorf("invalid --join %q: %s has no field named %q", spec, md.FullName(), name)
+			}
+			if fd.IsList() || fd.IsMap() {
+				return nil, fmt.Errorf("invalid --join %q: repeated field %s isn't supported", spec, fd.TextName())
+			}
+			j.fields = append(j.fields, fd)
+			md = fd.Message()
+		}
+
+		found := false
+		for _, t := range entityTypeList {
+			et := nbipb.EntityType(nbipb.EntityType_value[t])
+			if entityValueFieldName(et) == j.fields[0].Name() {
+				j.entityType, found = et, true
+				break
+			}
+		}
+		if !found || len(j.fields) < 2 {
+			return nil, fmt.Errorf("invalid --join %q: the path must start with the field that holds an entity's value, such as platform or network_node", spec)
+		}
+		joins = append(joins, j)
+	}
+	return joins, nil
+}
+
+type entityRef struct {
+	entityType nbipb.EntityType
+	id         string
+}
+
+// collectReferences returns the references to other entities within `m`, in
+// the order they appear.
+func collectReferences(m protoreflect.Message) []entityRef {
+	refs := []entityRef{}
+	var walk func(m protoreflect.Message)
+	walkValue := func(fd protoreflect.FieldDescriptor, v protoreflect.Value) {
+		switch {
+		case fd.Message() != nil:
+			walk(v.Message())
+		case fd.Kind() == protoreflect.StringKind:
+			if t, ok := entityReferences[fd.FullName()]; ok {
+				refs = append(refs, entityRef{entityType: t, id: v.String()})
+			}
+		}
+	}
+	walk = func(m protoreflect.Message) {
+		m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+			switch {
+			case fd.IsMap():
+				v.Map().Range(func(_ protoreflect.MapKey, mv protoreflect.Value) bool {
+					walkValue(fd.MapValue(), mv)
+					return true
+				})
+			case fd.IsList():
+				for i := 0; i < v.List().Len(); i++ {
+					walkValue(fd, v.List().Get(i))
+				}
+			default:
+				walkValue(fd, v)
+			}
+			return true
+		})
+	}
+	walk(m)
+	return refs
+}
+
+// joinedEntities holds the referenced entities needed to render the joins
+// requested for a listing.
+type joinedEntities struct {
+	joins    []entityJoin
+	entities map[nbipb.EntityType]map[string]*nbipb.Entity
+}
+
+// resolveJoins looks up the entities referenced by `entities`. Each type of
+// entity is fetched at most once, with a single ListEntities call that's
+// restricted to the joined fields, and types that aren't referenced aren't
+// fetched at all.
+func resolveJoins(ctx context.Context, client nbipb.NetOpsClient, joins []entityJoin, entities []*nbipb.Entity) (*joinedEntities, error) {
+	if len(joins) == 0 {
+		return nil, nil
+	}
+
+	referenced := map[nbipb.EntityType]bool{}
+	for _, e := range entities {
+		for _, ref := range collectReferences(e.ProtoReflect()) {
+			referenced[ref.entityType] = true
+		}
+	}
+	// Entities without any of the masked fields set are omitted from the
+	// response unless "id" is also requested, which would make them look
+	// like they don't exist.
+	masks := map[nbipb.EntityType][]string{}
+	for _, j := range joins {
+		if !referenced[j.entityType] {
+			continue
+		}
+		if masks[j.entityType] == nil {
+			masks[j.entityType] = []string{"id"}
+		}
+		if !slices.Contains(masks[j.entityType], j.path) {
+			masks[j.entityType] = append(masks[j.entityType], j.path)
+		}
+	}
+
+	je := &joinedEntities{joins: joins, entities: map[nbipb.EntityType]map[string]*nbipb.Entity{}}
+	mu := &sync.Mutex{}
+	g, gCtx := errgroup.WithContext(ctx)
+	for t, fieldMasks := range masks {
+		g.Go(func() error {
+			res, err := client.ListEntities(gCtx, &nbipb.ListEntitiesRequest{Type: t.Enum(), Filter: &nbipb.EntityFilter{FieldMasks: fieldMasks}})
+			if err != nil {
+				return fmt.Errorf("unable to list %s entities to join: %w", t, err)
+			}
+			byID := map[string]*nbipb.Entity{}
+			for _, e := range res.GetEntities() {
+				byID[e.GetId()] = e
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			je.entities[t] = byID
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return je, nil
+}
+
+// value returns the joined field of the entity referenced by `ref`, and
+// whether the entity was found and the field is set.
+func (je *joinedEntities) value(j entityJoin, ref entityRef) (protoreflect.FieldDescriptor, protoreflect.Value, bool) {
+	e, ok := je.entities[ref.entityType][ref.id]
+	if !ok {
+		return nil, protoreflect.Value{}, false
+	}
+	m := e.ProtoReflect()
+	for i, fd := range j.fields {
+		if !m.Has(fd) {
+			return nil, protoreflect.Value{}, false
+		}
+		if i == len(j.fields)-1 {
+			return fd, m.Get(fd), true
+		}
+		m = m.Get(fd).Message()
+	}
+	return nil, protoreflect.Value{}, false
+}
+
+// annotation returns the textproto comment added after a reference field, or
+// the empty string if there's nothing to add.
+func (je *joinedEntities) annotation(w *textWriter, fd protoreflect.FieldDescriptor, v protoreflect.Value) string {
+	t, ok := entityReferences[fd.FullName()]
+	if !ok {
+		return ""
+	}
+	ref := entityRef{entityType: t, id: v.String()}
+	if _, ok := je.entities[t]; !ok {
+		return ""
+	}
+	if _, ok := je.entities[t][ref.id]; !ok {
+		return fmt.Sprintf("%s %q not found", t, ref.id)
+	}
+
+	parts := []string{}
+	for _, j := range je.joins {
+		if j.entityType != t {
+			continue
+		}
+		if jfd, jv, ok := je.value(j, ref); ok {
+			parts = append(parts, j.path+": "+formatJoinedValue(jfd, jv, w.formatScalar))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// cell returns the table cell for `j`: the distinct values of the joined
+// field across all of the entity's references, in order of appearance.
+func (je *joinedEntities) cell(j entityJoin, e *nbipb.Entity) string {
+	values := []string{}
+	for _, ref := range collectReferences(e.ProtoReflect()) {
+		if ref.entityType != j.entityType {
+			continue
+		}
+		jfd, jv, ok := je.value(j, ref)
+		if !ok {
+			continue
+		}
+		s := formatJoinedValue(jfd, jv, func(fd protoreflect.FieldDescriptor, v protoreflect.Value) string {
+			if fd.Kind() == protoreflect.StringKind {
+				return v.String()
+			}
+			return (&textWriter{}).formatScalar(fd, v)
+		})
+		if !slices.Contains(values, s) {
+			values = append(values, s)
+		}
+	}
+	return strings.Join(values, ", ")
+}
+
+func formatJoinedValue(fd protoreflect.FieldDescriptor, v protoreflect.Value, formatScalar func(protoreflect.FieldDescriptor, protoreflect.Value) string) string {
+	if fd.Message() != nil {
+		txt, err := prototext.MarshalOptions{}.Marshal(v.Message().Interface())
+		if err != nil {
+			return err.Error()
+		}
+		return "{" + strings.TrimSpace(string(txt)) + "}"
+	}
+	return formatScalar(fd, v)
+}
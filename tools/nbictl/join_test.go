@@ -0,0 +1,182 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+)
+
+const joinTestNodes = `entity {
+  group { type: NETWORK_NODE }
+  id: "node-a"
+  network_node {
+    node_interface {
+      interface_id: "radio-1"
+      wireless { transceiver_model_id { platform_id: "sat" transceiver_model_id: "radio" } }
+    }
+    node_interface {
+      interface_id: "radio-2"
+      wireless { transceiver_model_id { platform_id: "sat" transceiver_model_id: "radio" } }
+    }
+    node_interface {
+      interface_id: "eth0"
+      wired { platform_id: "gs" }
+    }
+  }
+}
+entity {
+  group { type: NETWORK_NODE }
+  id: "node-b"
+  network_node {
+    node_interface {
+      interface_id: "eth0"
+      wired { platform_id: "ghost" }
+    }
+  }
+}
+`
+
+const joinTestPlatforms = `entity {
+  group { type: PLATFORM_DEFINITION }
+  id: "sat"
+  platform { name: "Satellite" }
+}
+entity {
+  group { type: PLATFORM_DEFINITION }
+  id: "gs"
+  platform { name: "Ground Station" }
+}
+`
+
+// listOnlyNetOpsClient serves ListEntities from a fixed set of entities and
+// records the requests it receives.
+type listOnlyNetOpsClient struct {
+	nbipb.NetOpsClient
+
+	entities []*nbipb.Entity
+	mu       sync.Mutex
+	requests []*nbipb.ListEntitiesRequest
+}
+
+func (c *listOnlyNetOpsClient) ListEntities(_ context.Context, req *nbipb.ListEntitiesRequest, _ ...grpc.CallOption) (*nbipb.ListEntitiesResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests = append(c.requests, req)
+
+	res := &nbipb.ListEntitiesResponse{}
+	for _, e := range c.entities {
+		if e.GetGroup().GetType() == req.GetType() {
+			res.Entities = append(res.Entities, e)
+		}
+	}
+	return res, nil
+}
+
+func parseTestEntities(t *testing.T, txt string) []*nbipb.Entity {
+	t.Helper()
+
+	entities := &nbipb.TxtpbEntities{}
+	checkErr(t, prototext.Unmarshal([]byte(txt), entities))
+	return entities.GetEntity()
+}
+
+func TestResolveJoins(t *testing.T) {
+	t.Parallel()
+
+	nodes := parseTestEntities(t, joinTestNodes)
+	client := &listOnlyNetOpsClient{entities: parseTestEntities(t, joinTestPlatforms)}
+	joins, err := parseJoins([]string{"platform.name", "network_node.name"})
+	checkErr(t, err)
+
+	joined, err := resolveJoins(context.Background(), client, joins, nodes)
+	checkErr(t, err)
+
+	// Only the referenced type is fetched, once, and only the joined fields
+	// are requested.
+	wantRequests := []*nbipb.ListEntitiesRequest{{
+		Type:   nbipb.EntityType_PLATFORM_DEFINITION.Enum(),
+		Filter: &nbipb.EntityFilter{FieldMasks: []string{"id", "platform.name"}},
+	}}
+	if diff := cmp.Diff(wantRequests, client.requests, protocmp.Transform()); diff != "" {
+		t.Errorf("unexpected ListEntities requests (-want +got):\n%s", diff)
+	}
+
+	t.Run("text", func(t *testing.T) {
+		t.Parallel()
+
+		out, err := outputOptions{joins: joined}.marshal(&nbipb.TxtpbEntities{Entity: nodes})
+		checkErr(t, err)
+		for _, want := range []string{
+			`platform_id: "sat"  # platform.name: "Satellite"`,
+			`platform_id: "gs"  # platform.name: "Ground Station"`,
+			`platform_id: "ghost"  # PLATFORM_DEFINITION "ghost" not found`,
+		} {
+			if !strings.Contains(string(out), want) {
+				t.Errorf("expected output to contain %q, got:\n%s", want, out)
+			}
+		}
+	})
+
+	t.Run("table", func(t *testing.T) {
+		t.Parallel()
+
+		out := &bytes.Buffer{}
+		checkErr(t, writeEntityTable(out, nodes, nil, joined, tableLayout{}))
+		want := []string{
+			"TYPE          ID      platform.name              network_node.name",
+			"NETWORK_NODE  node-a  Satellite, Ground Station",
+			"NETWORK_NODE  node-b",
+		}
+		got := strings.Split(strings.TrimSpace(out.String()), "\n")
+		for i := range got {
+			got[i] = strings.TrimRight(got[i], " ")
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("unexpected table (-want +got):\n%s", diff)
+		}
+	})
+}
+
+func TestParseJoins_errors(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		spec    string
+		wantErr string
+	}{
+		{spec: "platform", wantErr: "the path must start with the field that holds an entity's value"},
+		{spec: "id.name", wantErr: "id isn't a message"},
+		{spec: "platform.nmae", wantErr: `has no field named "nmae"`},
+		{spec: "platform.transceiver_model", wantErr: "repeated field transceiver_model isn't supported"},
+	} {
+		switch _, err := parseJoins([]string{tc.spec}); {
+		case err == nil:
+			t.Errorf("parseJoins(%q): expected error %q, got nil", tc.spec, tc.wantErr)
+		case !strings.Contains(err.Error(), tc.wantErr):
+			t.Errorf("parseJoins(%q): expected error to contain %q, but got %q", tc.spec, tc.wantErr, err.Error())
+		}
+	}
+}
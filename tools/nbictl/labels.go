@@ -0,0 +1,359 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v2"
+	"google.golang.org/protobuf/encoding/prototext"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+	"aalyria.com/spacetime/github/tools/nbictl/nbictlpb"
+)
+
+const labelsFileName = "labels.textproto"
+
+var labelSelectorFlag = &cli.StringFlag{
+	Name:    "selector",
+	Usage:   "Only include the entities whose labels, set with label set, match every comma-separated requirement, e.g. env=prod,tier!=edge,canary. KEY matches entities with the label, whatever its value, and !KEY those without it.",
+	Aliases: []string{"l"},
+}
+
+type labelOp int
+
+const (
+	labelExists labelOp = iota
+	labelNotExists
+	labelEquals
+	labelNotEquals
+)
+
+type labelRequirement struct {
+	key, value string
+	op         labelOp
+}
+
+// labelSelector matches the labels that satisfy all of its requirements.
+type labelSelector []labelRequirement
+
+func parseLabelSelector(s string) (labelSelector, error) {
+	sel := labelSelector{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		r := labelRequirement{op: labelExists, key: part}
+		switch {
+		case strings.Contains(part, "!="):
+			r.op = labelNotEquals
+			r.key, r.value, _ = strings.Cut(part, "!=")
+		case strings.Contains(part, "="):
+			r.op = labelEquals
+			r.key, r.value, _ = strings.Cut(part, "=")
+		case strings.HasPrefix(part, "!"):
+			r.op = labelNotExists
+			r.key = part[1:]
+		}
+		r.key, r.value = strings.TrimSpace(r.key), strings.TrimSpace(r.value)
+		if err := validateLabelKey(r.key); err != nil {
+			return nil, fmt.Errorf("invalid selector %q: %w", s, err)
+		}
+		sel = append(sel, r)
+	}
+	return sel, nil
+}
+
+func (s labelSelector) matches(labels map[string]string) bool {
+	for _, r := range s {
+		v, ok := labels[r.key]
+		switch r.op {
+		case labelExists:
+			if !ok {
+				return false
+			}
+		case labelNotExists:
+			if ok {
+				return false
+			}
+		case labelEquals:
+			if !ok || v != r.value {
+				return false
+			}
+		case labelNotEquals:
+			if ok && v == r.value {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func validateLabelKey(key string) error {
+	switch {
+	case key == "":
+		return errors.New("label keys can't be empty")
+	case strings.ContainsAny(key, "=!, \t\n"):
+		return fmt.Errorf("label key %q can't contain '=', '!', ',' or whitespace", key)
+	}
+	return nil
+}
+
+// parseLabelAssignments parses the KEY=VALUE arguments of `label set`.
+func parseLabelAssignments(args []string) (map[string]string, error) {
+	if len(args) == 0 {
+		return nil, errors.New("expected the labels to set, as KEY=VALUE arguments")
+	}
+	labels := map[string]string{}
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid label %q, expected KEY=VALUE", arg)
+		}
+		if err := validateLabelKey(key); err != nil {
+			return nil, err
+		}
+		if strings.Contains(value, ",") {
+			return nil, fmt.Errorf("the value of label %q can't contain ','", key)
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+func formatLabels(labels map[string]string) string {
+	pairs := []string{}
+	for _, k := range slices.Sorted(maps.Keys(labels)) {
+		pairs = append(pairs, k+"="+labels[k])
+	}
+	return strings.Join(pairs, ",")
+}
+
+// labelStoreForContext returns the path of the label store and the name of
+// the context whose entities' labels are used by this invocation.
+func labelStoreForContext(appCtx *cli.Context) (path, context string, _ error) {
+	confDir, err := getAppConfDir(appCtx)
+	if err != nil {
+		return "", "", err
+	}
+	setting, err := readConfig(appCtx.String("context"), filepath.Join(confDir, confFileName))
+	if err != nil {
+		return "", "", fmt.Errorf("unable to obtain context information: %w", err)
+	}
+	return filepath.Join(confDir, labelsFileName), setting.GetName(), nil
+}
+
+func readLabelStore(path string) (*nbictlpb.LabelStore, error) {
+	s := &nbictlpb.LabelStore{}
+	data, err := os.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		return s, nil
+	case err != nil:
+		return nil, fmt.Errorf("unable to read the labels: %w", err)
+	}
+	if err := prototext.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("invalid labels file %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// updateLabelStore applies `fn` to the labels stored at `path` while holding
+// their lock.
+func updateLabelStore(path string, fn func(*nbictlpb.LabelStore) error) error {
+	return withFileLock(path, func() error {
+		s, err := readLabelStore(path)
+		if err != nil {
+			return err
+		}
+		if err := fn(s); err != nil {
+			return err
+		}
+		data, err := prototext.MarshalOptions{Multiline: true}.Marshal(s)
+		if err != nil {
+			return err
+		}
+		return writeFileAtomic(path, data, 0o600)
+	})
+}
+
+// contextLabels returns the labels of the entities of type `typ` in
+// `context`, by ID.
+func contextLabels(s *nbictlpb.LabelStore, context string, typ nbipb.EntityType) map[string]map[string]string {
+	labels := map[string]map[string]string{}
+	for _, el := range s.GetEntities() {
+		if el.GetContext() == context && el.GetType() == typ.String() {
+			labels[el.GetId()] = el.GetLabels()
+		}
+	}
+	return labels
+}
+
+// labelMatcherFromFlags returns a function that reports whether an entity of
+// type `typ` matches the --selector flag, or nil if the flag isn't set.
+func labelMatcherFromFlags(appCtx *cli.Context, typ nbipb.EntityType) (func(*nbipb.Entity) bool, error) {
+	if !appCtx.IsSet(labelSelectorFlag.Name) {
+		return nil, nil
+	}
+	sel, err := parseLabelSelector(appCtx.String(labelSelectorFlag.Name))
+	if err != nil {
+		return nil, err
+	}
+	path, context, err := labelStoreForContext(appCtx)
+	if err != nil {
+		return nil, err
+	}
+	store, err := readLabelStore(path)
+	if err != nil {
+		return nil, err
+	}
+	labels := contextLabels(store, context, typ)
+	return func(e *nbipb.Entity) bool { return sel.matches(labels[e.GetId()]) }, nil
+}
+
+func entityTypeFromFlag(appCtx *cli.Context) (nbipb.EntityType, error) {
+	entityType := appCtx.String("type")
+	entityTypeEnumValue, found := nbipb.EntityType_value[entityType]
+	if !found {
+		return 0, fmt.Errorf("unknown entity type %q is not one of [%s]", entityType, strings.Join(entityTypeList, ", "))
+	}
+	return nbipb.EntityType(entityTypeEnumValue), nil
+}
+
+func SetLabels(appCtx *cli.Context) error {
+	if err := checkLocalStateWritable(appCtx); err != nil {
+		return err
+	}
+	typ, err := entityTypeFromFlag(appCtx)
+	if err != nil {
+		return err
+	}
+	id := appCtx.String("id")
+	labels, err := parseLabelAssignments(appCtx.Args().Slice())
+	if err != nil {
+		return err
+	}
+
+	// Check that the entity exists, so a typo doesn't label nothing.
+	conn, err := openServiceConnection(appCtx, nbipb.NetOps_ServiceDesc.ServiceName)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := nbipb.NewNetOpsClient(conn).GetEntity(appCtx.Context, &nbipb.GetEntityRequest{Type: typ.Enum(), Id: &id}); err != nil {
+		return fmt.Errorf("unable to get entity %s/%s: %w", typ, id, err)
+	}
+
+	path, context, err := labelStoreForContext(appCtx)
+	if err != nil {
+		return err
+	}
+	return updateLabelStore(path, func(s *nbictlpb.LabelStore) error {
+		i := slices.IndexFunc(s.GetEntities(), func(el *nbictlpb.EntityLabels) bool {
+			return el.GetContext() == context && el.GetType() == typ.String() && el.GetId() == id
+		})
+		if i < 0 {
+			s.Entities = append(s.Entities, &nbictlpb.EntityLabels{Context: context, Type: typ.String(), Id: id, Labels: map[string]string{}})
+			i = len(s.Entities) - 1
+		}
+		maps.Copy(s.Entities[i].Labels, labels)
+		fmt.Fprintf(appCtx.App.ErrWriter, "labels of %s/%s: %s\n", typ, id, formatLabels(s.Entities[i].GetLabels()))
+		return nil
+	})
+}
+
+func RemoveLabels(appCtx *cli.Context) error {
+	if err := checkLocalStateWritable(appCtx); err != nil {
+		return err
+	}
+	typ, err := entityTypeFromFlag(appCtx)
+	if err != nil {
+		return err
+	}
+	id := appCtx.String("id")
+	keys := appCtx.Args().Slice()
+	if len(keys) == 0 && !appCtx.Bool("all") {
+		return errors.New("expected the keys of the labels to remove, or --all")
+	}
+
+	path, context, err := labelStoreForContext(appCtx)
+	if err != nil {
+		return err
+	}
+	return updateLabelStore(path, func(s *nbictlpb.LabelStore) error {
+		i := slices.IndexFunc(s.GetEntities(), func(el *nbictlpb.EntityLabels) bool {
+			return el.GetContext() == context && el.GetType() == typ.String() && el.GetId() == id
+		})
+		if i < 0 {
+			return fmt.Errorf("%s/%s has no labels", typ, id)
+		}
+		el := s.Entities[i]
+		if appCtx.Bool("all") {
+			clear(el.Labels)
+		}
+		for _, k := range keys {
+			if _, ok := el.GetLabels()[k]; !ok {
+				return fmt.Errorf("%s/%s has no label %q", typ, id, k)
+			}
+			delete(el.Labels, k)
+		}
+		if len(el.GetLabels()) == 0 {
+			s.Entities = slices.Delete(s.Entities, i, i+1)
+		}
+		fmt.Fprintf(appCtx.App.ErrWriter, "labels of %s/%s: %s\n", typ, id, formatLabels(el.GetLabels()))
+		return nil
+	})
+}
+
+func ListLabels(appCtx *cli.Context) error {
+	sel := labelSelector{}
+	if appCtx.IsSet(labelSelectorFlag.Name) {
+		var err error
+		if sel, err = parseLabelSelector(appCtx.String(labelSelectorFlag.Name)); err != nil {
+			return err
+		}
+	}
+	if appCtx.IsSet("type") {
+		if _, err := entityTypeFromFlag(appCtx); err != nil {
+			return err
+		}
+	}
+	path, context, err := labelStoreForContext(appCtx)
+	if err != nil {
+		return err
+	}
+	store, err := readLabelStore(path)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(appCtx.App.Writer, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TYPE\tID\tLABELS")
+	for _, el := range store.GetEntities() {
+		switch {
+		case el.GetContext() != context:
+		case appCtx.IsSet("type") && el.GetType() != appCtx.String("type"):
+		case !sel.matches(el.GetLabels()):
+		default:
+			fmt.Fprintf(w, "%s\t%s\t%s\n", el.GetType(), el.GetId(), formatLabels(el.GetLabels()))
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,117 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/rules_go/go/tools/bazel"
+	"golang.org/x/sync/errgroup"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+)
+
+func TestLabelSelector(t *testing.T) {
+	t.Parallel()
+
+	labels := map[string]string{"env": "prod", "tier": "core"}
+	for selector, want := range map[string]bool{
+		"env=prod":           true,
+		"env=staging":        false,
+		"env!=staging":       true,
+		"tier":               true,
+		"!tier":              false,
+		"!canary":            true,
+		"env=prod, tier!=ga": true,
+		"env=prod,canary":    false,
+		"region!=us":         true,
+	} {
+		sel, err := parseLabelSelector(selector)
+		checkErr(t, err)
+		if got := sel.matches(labels); got != want {
+			t.Errorf("selector %q matches %v = %t, want %t", selector, labels, got, want)
+		}
+	}
+
+	for _, selector := range []string{"", "env=prod,", "=prod", "!", "my env=prod"} {
+		if _, err := parseLabelSelector(selector); err == nil {
+			t.Errorf("parseLabelSelector(%q) succeeded, want an error", selector)
+		}
+	}
+}
+
+func TestLabels(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	g, ctx := errgroup.WithContext(ctx)
+	defer func() { checkErr(t, g.Wait()) }()
+	defer cancel()
+
+	tmpDir, err := bazel.NewTmpDir("nbictl")
+	checkErr(t, err)
+	srv := startInsecureServer(ctx, t, g)
+	srv.ListEntityResponse = &nbipb.ListEntitiesResponse{Entities: []*nbipb.Entity{
+		testNetworkNode("a", "node-a"), testNetworkNode("b", "node-b"), testNetworkNode("c", "node-c"),
+	}}
+	keys := generateKeysForTesting(t, tmpDir, "--org", "example org")
+	checkErr(t, newTestApp().Run([]string{
+		"nbictl", "--config_dir", tmpDir,
+		"set-config",
+		"--transport_security", "insecure",
+		"--user_id", "usr1",
+		"--key_id", "key1",
+		"--priv_key", keys.key,
+		"--url", srv.listener.Addr().String(),
+	}))
+
+	run := func(args ...string) testApp {
+		t.Helper()
+		app := newTestApp()
+		checkErr(t, app.RunContext(ctx, append([]string{"nbictl", "--config_dir", tmpDir}, args...)))
+		return app
+	}
+	run("label", "set", "--type", "NETWORK_NODE", "--id", "a", "env=prod", "tier=core")
+	run("label", "set", "--type", "NETWORK_NODE", "--id", "b", "env=staging", "tier=core")
+	run("label", "set", "--type", "NETWORK_NODE", "--id", "c", "env=prod")
+	run("label", "set", "--type", "NETWORK_NODE", "--id", "c", "tier=edge")
+	run("label", "remove", "--type", "NETWORK_NODE", "--id", "b", "tier")
+
+	if got, want := run("label", "list", "--selector", "env=prod").stdout.String(), ""+
+		"TYPE          ID  LABELS\n"+
+		"NETWORK_NODE  a   env=prod,tier=core\n"+
+		"NETWORK_NODE  c   env=prod,tier=edge\n"; got != want {
+		t.Errorf("label list printed:\n%s\nwant:\n%s", got, want)
+	}
+
+	listed := run("list", "--type", "NETWORK_NODE", "--selector", "!tier,env", "--output", "table", "--columns", "id").stdout.String()
+	if want := "ID\nb\n"; listed != want {
+		t.Errorf("list --selector printed %q, want %q", listed, want)
+	}
+
+	app := newTestApp()
+	err = app.RunContext(ctx, []string{"nbictl", "--config_dir", tmpDir, "delete", "--type", "NETWORK_NODE", "--selector", "tier=edge", "--dry_run"})
+	checkErr(t, err)
+	if out := app.stdout.String(); !strings.Contains(out, "NETWORK_NODE  c") || strings.Contains(out, "NETWORK_NODE  a") {
+		t.Errorf("delete --selector --dry_run printed %q, want only entity c", out)
+	}
+
+	if err := newTestApp().RunContext(ctx, []string{"nbictl", "--config_dir", tmpDir, "label", "remove", "--type", "NETWORK_NODE", "--id", "a", "region"}); err == nil || !strings.Contains(err.Error(), `has no label "region"`) {
+		t.Errorf("removing a missing label: got error %v, want one about the label", err)
+	}
+}
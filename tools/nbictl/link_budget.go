@@ -0,0 +1,507 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"google.golang.org/protobuf/proto"
+
+	commonpb "aalyria.com/spacetime/api/common"
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+	resourcespb "aalyria.com/spacetime/api/nbi/v1alpha/resources"
+	outputv1 "aalyria.com/spacetime/github/tools/nbictl/output/v1"
+)
+
+const (
+	speedOfLightMPerS = 299792458.0
+	// boltzmannDBWPerKHz is Boltzmann's constant, in dBW/K/Hz.
+	boltzmannDBWPerKHz = -228.5991672
+	// referenceNoiseTemperatureK is the standard temperature used to convert
+	// noise factors and passive losses to noise temperatures.
+	referenceNoiseTemperatureK = 290.0
+)
+
+// linkBudgetEntityTypes are the types of the entities that describe the
+// RF parameters of transceivers.
+var linkBudgetEntityTypes = []nbipb.EntityType{
+	nbipb.EntityType_PLATFORM_DEFINITION,
+	nbipb.EntityType_NETWORK_NODE,
+	nbipb.EntityType_ANTENNA_PATTERN,
+	nbipb.EntityType_BAND_PROFILE,
+}
+
+// LinkBudgetOptions selects the link that ComputeLinkBudget evaluates.
+type LinkBudgetOptions struct {
+	Transmitter, Target *commonpb.TransceiverModelId
+	// BandProfileID may be left empty if the transmitter only has channels
+	// for one band profile.
+	BandProfileID string
+	// CenterFrequencyHz may be left unset if the transmitter only has one
+	// channel for the band profile.
+	CenterFrequencyHz uint64
+	// Time is when the range between the platforms is evaluated.
+	Time time.Time
+	// RangeM, if positive, is used instead of the range between the
+	// platforms at Time.
+	RangeM float64
+	// AntennaNoiseTemperatureK is the noise temperature of the target's
+	// antenna, which depends on what it's pointed at.
+	AntennaNoiseTemperatureK float64
+}
+
+// ComputeLinkBudget estimates the link budget between two transceivers from
+// the RF parameters modeled in `entities`. Both antennas are assumed to be
+// pointed at each other, so their boresight gain is used, and only free
+// space path loss is accounted for. It's meant to catch mistakes in link
+// definitions, not to replace the SignalPropagation service.
+func ComputeLinkBudget(entities []*nbipb.Entity, opts LinkBudgetOptions) (*outputv1.LinkBudget, error) {
+	platforms := map[string]*commonpb.PlatformDefinition{}
+	patterns := map[string]*resourcespb.AntennaPattern{}
+	bands := map[string]*commonpb.BandProfile{}
+	for _, e := range entities {
+		switch e.GetGroup().GetType() {
+		case nbipb.EntityType_PLATFORM_DEFINITION:
+			platforms[e.GetId()] = e.GetPlatform()
+		case nbipb.EntityType_ANTENNA_PATTERN:
+			patterns[e.GetId()] = e.GetAntennaPattern()
+		case nbipb.EntityType_BAND_PROFILE:
+			bands[e.GetId()] = e.GetBandProfile()
+		}
+	}
+
+	tx, err := findTransceiverModel(platforms, opts.Transmitter)
+	if err != nil {
+		return nil, err
+	}
+	rx, err := findTransceiverModel(platforms, opts.Target)
+	if err != nil {
+		return nil, err
+	}
+	if tx.GetTransmitter() == nil {
+		return nil, fmt.Errorf("transceiver model %s has no transmitter", transceiverName(opts.Transmitter))
+	}
+	if rx.GetReceiver() == nil {
+		return nil, fmt.Errorf("transceiver model %s has no receiver", transceiverName(opts.Target))
+	}
+
+	bandID := opts.BandProfileID
+	if bandID == "" {
+		ids := slices.Sorted(maps.Keys(tx.GetTransmitter().GetChannelSet()))
+		if len(ids) != 1 {
+			return nil, fmt.Errorf("transmitter %s has channels for %d band profiles (%s), choose one with --band_profile_id", transceiverName(opts.Transmitter), len(ids), strings.Join(ids, ", "))
+		}
+		bandID = ids[0]
+	}
+	band, ok := bands[bandID]
+	if !ok {
+		return nil, fmt.Errorf("no BandProfile with ID %q found", bandID)
+	}
+	if band.GetChannelWidthHz() == 0 {
+		return nil, fmt.Errorf("band profile %q has no channel_width_hz", bandID)
+	}
+
+	channels := tx.GetTransmitter().GetChannelSet()[bandID].GetChannel()
+	freq := opts.CenterFrequencyHz
+	if freq == 0 {
+		freqs := slices.Sorted(maps.Keys(channels))
+		if len(freqs) != 1 {
+			return nil, fmt.Errorf("transmitter %s has %d channels for band profile %q, choose one with --center_frequency_hz", transceiverName(opts.Transmitter), len(freqs), bandID)
+		}
+		freq = freqs[0]
+	}
+	channel, ok := channels[freq]
+	if !ok {
+		return nil, fmt.Errorf("transmitter %s has no channel at %d Hz for band profile %q", transceiverName(opts.Transmitter), freq, bandID)
+	}
+	if rxChannels, ok := rx.GetReceiver().GetChannelSet()[bandID]; !ok || !slices.Contains(rxChannels.GetCenterFrequencyHz(), int64(freq)) {
+		return nil, fmt.Errorf("receiver %s has no channel at %d Hz for band profile %q", transceiverName(opts.Target), freq, bandID)
+	}
+	if channel.GetMaxPowerWatts() <= 0 {
+		return nil, fmt.Errorf("transmitter %s has no max_power_watts for its channel at %d Hz", transceiverName(opts.Transmitter), freq)
+	}
+
+	rangeM := opts.RangeM
+	if rangeM <= 0 {
+		if rangeM, err = platformRange(platforms, opts.Transmitter.GetPlatformId(), opts.Target.GetPlatformId(), opts.Time); err != nil {
+			return nil, fmt.Errorf("computing the range between the platforms: %w; set --range_m to provide it", err)
+		}
+	}
+
+	txChainGainDB, err := transmitChainGain(tx.GetTransmitter().GetSignalProcessingStep())
+	if err != nil {
+		return nil, fmt.Errorf("transmitter %s: %w", transceiverName(opts.Transmitter), err)
+	}
+	txAntennaGainDBi, err := antennaGain(patterns, tx.GetAntenna().GetAntennaPatternId(), float64(freq), true)
+	if err != nil {
+		return nil, fmt.Errorf("antenna of %s: %w", transceiverName(opts.Transmitter), err)
+	}
+	rxChainGainDB, rxChainNoiseK, err := receiveChain(rx.GetReceiver().GetSignalProcessingStep())
+	if err != nil {
+		return nil, fmt.Errorf("receiver %s: %w", transceiverName(opts.Target), err)
+	}
+	rxAntennaGainDBi, err := antennaGain(patterns, rx.GetAntenna().GetAntennaPatternId(), float64(freq), false)
+	if err != nil {
+		return nil, fmt.Errorf("antenna of %s: %w", transceiverName(opts.Target), err)
+	}
+
+	lb := outputv1.NewLinkBudget(
+		outputv1.TransceiverRef{PlatformID: opts.Transmitter.GetPlatformId(), TransceiverModelID: opts.Transmitter.GetTransceiverModelId()},
+		outputv1.TransceiverRef{PlatformID: opts.Target.GetPlatformId(), TransceiverModelID: opts.Target.GetTransceiverModelId()},
+	)
+	lb.BandProfileID = bandID
+	lb.Time = opts.Time.UTC()
+	lb.RangeM = rangeM
+	lb.CenterFrequencyHz = float64(freq)
+	lb.ChannelWidthHz = float64(band.GetChannelWidthHz())
+
+	lb.TxPowerDBW = linearToDB(channel.GetMaxPowerWatts())
+	lb.TxChainGainDB = txChainGainDB
+	lb.TxAntennaGainDBi = txAntennaGainDBi
+	lb.EIRPDBW = lb.TxPowerDBW + txChainGainDB + txAntennaGainDBi
+	lb.FreeSpacePathLossDB = 20 * math.Log10(4*math.Pi*rangeM*lb.CenterFrequencyHz/speedOfLightMPerS)
+
+	lb.RxAntennaGainDBi = rxAntennaGainDBi
+	lb.SystemNoiseTemperatureK = opts.AntennaNoiseTemperatureK + rxChainNoiseK
+	lb.GOverTDBPerK = rxAntennaGainDBi - linearToDB(lb.SystemNoiseTemperatureK)
+	lb.ReceivedPowerDBW = lb.EIRPDBW - lb.FreeSpacePathLossDB + rxAntennaGainDBi
+	lb.CarrierToNoiseDensityDB = lb.EIRPDBW - lb.FreeSpacePathLossDB + lb.GOverTDBPerK - boltzmannDBWPerKHz
+	lb.CarrierToNoiseDB = lb.CarrierToNoiseDensityDB - linearToDB(lb.ChannelWidthHz)
+
+	table := band.GetRateTable()
+	switch {
+	case len(table.GetCarrierToNoisePlusInterferenceSteps()) > 0:
+		lb.DataRateSource = outputv1.DataRateSourceRateTable
+		for _, step := range table.GetCarrierToNoisePlusInterferenceSteps() {
+			if step.GetMinCarrierToNoisePlusInterferenceDb() <= lb.CarrierToNoiseDB && step.GetTxDataRateBps() > lb.DataRateBps {
+				lb.DataRateBps, lb.ModCod = step.GetTxDataRateBps(), step.GetModCodSchemeName()
+			}
+		}
+	case len(table.GetReceivedSignalPowerSteps()) > 0:
+		// The steps are thresholds of the power at the output of the
+		// receiver, after its signal processing chain.
+		lb.DataRateSource = outputv1.DataRateSourceRateTable
+		for _, step := range table.GetReceivedSignalPowerSteps() {
+			if step.GetMinReceivedSignalPowerDbw() <= lb.ReceivedPowerDBW+rxChainGainDB && step.GetTxDataRateBps() > lb.DataRateBps {
+				lb.DataRateBps = step.GetTxDataRateBps()
+			}
+		}
+	default:
+		lb.DataRateSource = outputv1.DataRateSourceShannonLimit
+		lb.DataRateBps = lb.ChannelWidthHz * math.Log2(1+dbToLinear(lb.CarrierToNoiseDB))
+	}
+	return lb, nil
+}
+
+func transceiverName(id *commonpb.TransceiverModelId) string {
+	return id.GetPlatformId() + "/" + id.GetTransceiverModelId()
+}
+
+func findTransceiverModel(platforms map[string]*commonpb.PlatformDefinition, id *commonpb.TransceiverModelId) (*commonpb.TransceiverModel, error) {
+	pd, ok := platforms[id.GetPlatformId()]
+	if !ok {
+		return nil, fmt.Errorf("no PlatformDefinition with ID %q found", id.GetPlatformId())
+	}
+	for _, tm := range pd.GetTransceiverModel() {
+		if tm.GetId() == id.GetTransceiverModelId() {
+			return tm, nil
+		}
+	}
+	return nil, fmt.Errorf("platform %q has no transceiver model %q", id.GetPlatformId(), id.GetTransceiverModelId())
+}
+
+// platformRange returns the distance, in meters, between two platforms at
+// `t`.
+func platformRange(platforms map[string]*commonpb.PlatformDefinition, a, b string, t time.Time) (float64, error) {
+	positions := [2][3]float64{}
+	for i, id := range []string{a, b} {
+		m, err := motionFromProto(platforms[id].GetCoordinates())
+		if err != nil {
+			return 0, fmt.Errorf("platform %q: %w", id, err)
+		}
+		if positions[i], err = m.positionECEF(t); err != nil {
+			return 0, fmt.Errorf("platform %q: %w", id, err)
+		}
+	}
+	return math.Sqrt(sq(positions[0][0]-positions[1][0]) + sq(positions[0][1]-positions[1][1]) + sq(positions[0][2]-positions[1][2])), nil
+}
+
+// transmitChainGain returns the total gain, in dB, of the signal processing
+// steps of a transmitter.
+func transmitChainGain(steps []*commonpb.TransmitSignalProcessor) (float64, error) {
+	gainDB := 0.0
+	for i, step := range steps {
+		switch t := step.GetType().(type) {
+		case *commonpb.TransmitSignalProcessor_Amplifier:
+			for _, s := range amplifierStages(t.Amplifier) {
+				gainDB += s.gainDB
+			}
+		case *commonpb.TransmitSignalProcessor_GainOrLoss:
+			gainDB += t.GainOrLoss.GetGainOrLossDb()
+		default:
+			return 0, fmt.Errorf("unsupported signal processing step %d: %T", i, t)
+		}
+	}
+	return gainDB, nil
+}
+
+// receiveChain returns the total gain, in dB, of the signal processing steps
+// of a receiver, and their noise temperature referred to the antenna output,
+// using the Friis formula.
+func receiveChain(steps []*commonpb.ReceiveSignalProcessor) (gainDB, noiseTemperatureK float64, err error) {
+	gain := 1.0
+	add := func(s noiseStage) {
+		noiseTemperatureK += s.noiseTemperatureK / gain
+		gain *= dbToLinear(s.gainDB)
+	}
+	for i, step := range steps {
+		switch t := step.GetType().(type) {
+		case *commonpb.ReceiveSignalProcessor_Filter:
+			add(noiseStage{noiseTemperatureK: t.Filter.GetNoiseTemperatureK()})
+		case *commonpb.ReceiveSignalProcessor_Amplifier:
+			for _, s := range amplifierStages(t.Amplifier) {
+				add(s)
+			}
+		case *commonpb.ReceiveSignalProcessor_GainOrLoss:
+			add(passiveStage(t.GainOrLoss.GetGainOrLossDb()))
+		default:
+			return 0, 0, fmt.Errorf("unsupported signal processing step %d: %T", i, t)
+		}
+	}
+	return linearToDB(gain), noiseTemperatureK, nil
+}
+
+// noiseStage is a stage of a receive chain.
+type noiseStage struct {
+	gainDB            float64
+	noiseTemperatureK float64
+}
+
+// passiveStage returns the stage of a passive gain or loss, whose loss adds
+// thermal noise at the reference temperature.
+func passiveStage(gainDB float64) noiseStage {
+	if gainDB >= 0 {
+		return noiseStage{gainDB: gainDB}
+	}
+	return noiseStage{gainDB: gainDB, noiseTemperatureK: (dbToLinear(-gainDB) - 1) * referenceNoiseTemperatureK}
+}
+
+func amplifierStages(a *commonpb.AmplifierDefinition) []noiseStage {
+	noiseTemperatureK := func(noiseFactor, referenceK float64) float64 {
+		if referenceK == 0 {
+			referenceK = referenceNoiseTemperatureK
+		}
+		return max(noiseFactor-1, 0) * referenceK
+	}
+	switch t := a.GetAmplifierType().(type) {
+	case *commonpb.AmplifierDefinition_ConstantGain:
+		cg := t.ConstantGain
+		return []noiseStage{{gainDB: cg.GetGainDb(), noiseTemperatureK: noiseTemperatureK(cg.GetNoiseFactor(), cg.GetReferenceTemperatureK())}}
+	case *commonpb.AmplifierDefinition_LowNoise:
+		lna := t.LowNoise
+		return []noiseStage{
+			passiveStage(lna.GetPreLnaGainDb()),
+			{gainDB: lna.GetLnaGainDb(), noiseTemperatureK: noiseTemperatureK(lna.GetNoiseFactor(), lna.GetReferenceTemperatureK())},
+			passiveStage(lna.GetPostLnaGainDb()),
+		}
+	default:
+		return nil
+	}
+}
+
+// antennaGain returns the boresight gain, in dBi, of the antenna pattern
+// with ID `id` at `frequencyHz`. Patterns that differ when transmitting and
+// receiving are resolved with `transmit`.
+func antennaGain(patterns map[string]*resourcespb.AntennaPattern, id string, frequencyHz float64, transmit bool) (float64, error) {
+	if id == "" {
+		return 0, errors.New("antenna_pattern_id is unset")
+	}
+	p, ok := patterns[id]
+	if !ok {
+		return 0, fmt.Errorf("no AntennaPattern with ID %q found", id)
+	}
+	return patternGain(p, frequencyHz, transmit)
+}
+
+func patternGain(p *resourcespb.AntennaPattern, frequencyHz float64, transmit bool) (float64, error) {
+	wavelengthM := speedOfLightMPerS / frequencyHz
+	// apertureGain is the gain of an aperture of area `areaM2`.
+	apertureGain := func(areaM2, efficiencyPercent float64) (float64, error) {
+		if areaM2 <= 0 || efficiencyPercent <= 0 {
+			return 0, errors.New("the diameter and efficiency of the antenna must be positive")
+		}
+		return linearToDB(efficiencyPercent / 100 * 4 * math.Pi * areaM2 / sq(wavelengthM)), nil
+	}
+
+	switch t := p.GetPatternType().(type) {
+	case *resourcespb.AntennaPattern_IsotropicPattern:
+		return 0, nil
+	case *resourcespb.AntennaPattern_ParabolicPattern:
+		return apertureGain(math.Pi*sq(t.ParabolicPattern.GetDiameterM()/2), t.ParabolicPattern.GetEfficiencyPercent())
+	case *resourcespb.AntennaPattern_GaussianPattern:
+		return apertureGain(math.Pi*sq(t.GaussianPattern.GetDiameterM()/2), t.GaussianPattern.GetEfficiencyPercent())
+	case *resourcespb.AntennaPattern_SquareHornPattern:
+		return apertureGain(sq(t.SquareHornPattern.GetDiameterM()), t.SquareHornPattern.GetEfficiencyPercent())
+	case *resourcespb.AntennaPattern_HelicalPattern:
+		// Kraus's approximation for an axial-mode helix.
+		h := t.HelicalPattern
+		circumferenceM := math.Pi * h.GetDiameterM()
+		gain := 15 * h.GetNumberOfTurns() * h.GetTurnSpacingM() * sq(circumferenceM) / (sq(wavelengthM) * wavelengthM)
+		if gain <= 0 {
+			return 0, errors.New("the diameter, number of turns, and turn spacing of the helix must be positive")
+		}
+		return linearToDB(gain), nil
+	case *resourcespb.AntennaPattern_CustomPhiThetaPattern:
+		gains := []float64{}
+		for _, v := range t.CustomPhiThetaPattern.GetGainValue() {
+			gains = append(gains, v.GetGainDb())
+		}
+		return maxGain(gains)
+	case *resourcespb.AntennaPattern_CustomAzElPattern:
+		gains := []float64{}
+		for _, v := range t.CustomAzElPattern.GetGainValues() {
+			gains = append(gains, v.GetGainDb())
+		}
+		return maxGain(gains)
+	case *resourcespb.AntennaPattern_NearAndFarFieldPattern:
+		return patternGain(t.NearAndFarFieldPattern.GetFarFieldPattern(), frequencyHz, transmit)
+	case *resourcespb.AntennaPattern_TransmitterAndReceiverPattern:
+		if transmit {
+			return patternGain(t.TransmitterAndReceiverPattern.GetTransmitterPattern(), frequencyHz, transmit)
+		}
+		return patternGain(t.TransmitterAndReceiverPattern.GetReceiverPattern(), frequencyHz, transmit)
+	case nil:
+		return 0, errors.New("the antenna pattern is unset")
+	default:
+		return 0, fmt.Errorf("unsupported antenna pattern %T", t)
+	}
+}
+
+func maxGain(gains []float64) (float64, error) {
+	if len(gains) == 0 {
+		return 0, errors.New("the custom antenna pattern has no gain values")
+	}
+	return slices.Max(gains), nil
+}
+
+func sq(f float64) float64          { return f * f }
+func linearToDB(f float64) float64  { return 10 * math.Log10(f) }
+func dbToLinear(db float64) float64 { return math.Pow(10, db/10) }
+
+// transceiverFromFlags returns the transceiver model selected by the
+// --<prefix>_platform_id and --<prefix>_transceiver_model_id flags, or by
+// the wireless interface of the --<prefix>_interface flag.
+func transceiverFromFlags(appCtx *cli.Context, prefix string, entities []*nbipb.Entity) (*commonpb.TransceiverModelId, error) {
+	platformID := appCtx.String(prefix + "_platform_id")
+	modelID := appCtx.String(prefix + "_transceiver_model_id")
+	iface := appCtx.String(prefix + "_interface")
+	switch {
+	case iface != "" && (platformID != "" || modelID != ""):
+		return nil, fmt.Errorf("--%[1]s_interface can't be combined with --%[1]s_platform_id or --%[1]s_transceiver_model_id", prefix)
+	case iface != "":
+		nodeID, interfaceID, ok := strings.Cut(iface, "/")
+		if !ok {
+			return nil, fmt.Errorf("--%s_interface must be NODE_ID/INTERFACE_ID, got %q", prefix, iface)
+		}
+		e := newNetworkTopology(entities).interfaceEndpoint(&commonpb.NetworkInterfaceId{NodeId: proto.String(nodeID), InterfaceId: proto.String(interfaceID)})
+		if e.transceiverID == "" {
+			return nil, fmt.Errorf("interface %s isn't a wireless interface of a known network node", iface)
+		}
+		platformID, modelID = e.platformID, e.transceiverID
+	case platformID == "" || modelID == "":
+		return nil, fmt.Errorf("either --%[1]s_interface or both --%[1]s_platform_id and --%[1]s_transceiver_model_id are required", prefix)
+	}
+	return &commonpb.TransceiverModelId{PlatformId: proto.String(platformID), TransceiverModelId: proto.String(modelID)}, nil
+}
+
+func EvaluateLinkBudget(appCtx *cli.Context) error {
+	entities, err := entitiesFromFlags(appCtx, linkBudgetEntityTypes)
+	if err != nil {
+		return err
+	}
+	opts := LinkBudgetOptions{
+		BandProfileID:            appCtx.String("band_profile_id"),
+		CenterFrequencyHz:        appCtx.Uint64("center_frequency_hz"),
+		Time:                     time.Now(),
+		RangeM:                   appCtx.Float64("range_m"),
+		AntennaNoiseTemperatureK: appCtx.Float64("antenna_noise_temperature_k"),
+	}
+	if ts := appCtx.Timestamp("timestamp"); ts != nil {
+		opts.Time = *ts
+	}
+	if opts.Transmitter, err = transceiverFromFlags(appCtx, "tx", entities); err != nil {
+		return err
+	}
+	if opts.Target, err = transceiverFromFlags(appCtx, "target", entities); err != nil {
+		return err
+	}
+
+	lb, err := ComputeLinkBudget(entities, opts)
+	if err != nil {
+		return err
+	}
+	if jsonOutputRequested(appCtx) {
+		return writeJSONOutput(appCtx, lb)
+	}
+	if err := writeLinkBudget(appCtx.App.Writer, lb); err != nil {
+		return err
+	}
+	fmt.Fprintln(appCtx.App.ErrWriter, "this is a local estimate assuming the antennas are pointed at each other and only free space path loss; use get-link-budget for authoritative results.")
+	return nil
+}
+
+func writeLinkBudget(w io.Writer, lb *outputv1.LinkBudget) error {
+	dataRate := formatWithUnit("_bps", lb.DataRateBps)
+	switch {
+	case lb.DataRateSource == outputv1.DataRateSourceShannonLimit:
+		dataRate += " (Shannon limit, the band profile has no rate table)"
+	case lb.ModCod != "":
+		dataRate += " (" + lb.ModCod + ")"
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, row := range [][2]string{
+		{"Transmitter", lb.Transmitter.PlatformID + "/" + lb.Transmitter.TransceiverModelID},
+		{"Target", lb.Target.PlatformID + "/" + lb.Target.TransceiverModelID},
+		{"Band profile", lb.BandProfileID},
+		{"Time", lb.Time.Format(time.RFC3339)},
+		{"Range", fmt.Sprintf("%.3f km", lb.RangeM/1000)},
+		{"Center frequency", formatWithUnit("_hz", lb.CenterFrequencyHz)},
+		{"Channel width", formatWithUnit("_hz", lb.ChannelWidthHz)},
+		{"Transmit power", fmt.Sprintf("%.2f dBW", lb.TxPowerDBW)},
+		{"Transmit chain gain", fmt.Sprintf("%.2f dB", lb.TxChainGainDB)},
+		{"Transmit antenna gain", fmt.Sprintf("%.2f dBi", lb.TxAntennaGainDBi)},
+		{"EIRP", fmt.Sprintf("%.2f dBW", lb.EIRPDBW)},
+		{"Free space path loss", fmt.Sprintf("%.2f dB", lb.FreeSpacePathLossDB)},
+		{"Receive antenna gain", fmt.Sprintf("%.2f dBi", lb.RxAntennaGainDBi)},
+		{"System noise temperature", fmt.Sprintf("%.1f K", lb.SystemNoiseTemperatureK)},
+		{"G/T", fmt.Sprintf("%.2f dB/K", lb.GOverTDBPerK)},
+		{"Received power", fmt.Sprintf("%.2f dBW", lb.ReceivedPowerDBW)},
+		{"C/N0", fmt.Sprintf("%.2f dB-Hz", lb.CarrierToNoiseDensityDB)},
+		{"C/N", fmt.Sprintf("%.2f dB", lb.CarrierToNoiseDB)},
+		{"Data rate", dataRate},
+	} {
+		fmt.Fprintf(tw, "%s\t%s\n", row[0], row[1])
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,251 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+
+	commonpb "aalyria.com/spacetime/api/common"
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+	outputv1 "aalyria.com/spacetime/github/tools/nbictl/output/v1"
+)
+
+// testLinkBudgetTextproto describes a Ku band link between a satellite and
+// a ground station 1000 km below it, both with 1 m dishes.
+const testLinkBudgetTextproto = `
+entity {
+  group { type: PLATFORM_DEFINITION }
+  id: "sat"
+  platform {
+    coordinates { ecef_fixed { point { x_m: 7378137 } } }
+    transceiver_model {
+      id: "tx"
+      transmitter {
+        channel_set {
+          key: "ku"
+          value { channel { key: 12000000000 value { max_power_watts: 10 } } }
+        }
+        signal_processing_step { gain_or_loss { name: "cable" gain_or_loss_db: -1 } }
+      }
+      antenna { antenna_pattern_id: "dish" }
+    }
+  }
+}
+entity {
+  group { type: PLATFORM_DEFINITION }
+  id: "gs"
+  platform {
+    coordinates { ecef_fixed { point { x_m: 6378137 } } }
+    transceiver_model {
+      id: "rx"
+      receiver {
+        channel_set {
+          key: "ku"
+          value { center_frequency_hz: 12000000000 }
+        }
+        signal_processing_step { amplifier { constant_gain { gain_db: 20 noise_factor: 2 } } }
+      }
+      antenna { antenna_pattern_id: "dish" }
+    }
+  }
+}
+entity {
+  group { type: NETWORK_NODE }
+  id: "gs-node"
+  network_node {
+    node_interface {
+      interface_id: "ku"
+      wireless { transceiver_model_id { platform_id: "gs" transceiver_model_id: "rx" } }
+    }
+  }
+}
+entity {
+  group { type: ANTENNA_PATTERN }
+  id: "dish"
+  antenna_pattern { parabolic_pattern { diameter_m: 1 efficiency_percent: 60 } }
+}
+entity {
+  group { type: BAND_PROFILE }
+  id: "ku"
+  band_profile {
+    channel_width_hz: 250000000
+    rate_table {
+      carrier_to_noise_plus_interference_steps { min_carrier_to_noise_plus_interference_db: 5 tx_data_rate_bps: 1e8 mod_cod_scheme_name: "QPSK 1/2" }
+      carrier_to_noise_plus_interference_steps { min_carrier_to_noise_plus_interference_db: 20 tx_data_rate_bps: 5e8 mod_cod_scheme_name: "16APSK 3/4" }
+      carrier_to_noise_plus_interference_steps { min_carrier_to_noise_plus_interference_db: 40 tx_data_rate_bps: 1e9 mod_cod_scheme_name: "256APSK 9/10" }
+    }
+  }
+}
+`
+
+func testLinkBudgetEntities(t *testing.T) []*nbipb.Entity {
+	t.Helper()
+
+	entities := &nbipb.TxtpbEntities{}
+	checkErr(t, prototext.Unmarshal([]byte(testLinkBudgetTextproto), entities))
+	return entities.GetEntity()
+}
+
+func testLinkBudgetOptions() LinkBudgetOptions {
+	return LinkBudgetOptions{
+		Transmitter:              &commonpb.TransceiverModelId{PlatformId: proto.String("sat"), TransceiverModelId: proto.String("tx")},
+		Target:                   &commonpb.TransceiverModelId{PlatformId: proto.String("gs"), TransceiverModelId: proto.String("rx")},
+		Time:                     time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC),
+		AntennaNoiseTemperatureK: 290,
+	}
+}
+
+func TestComputeLinkBudget(t *testing.T) {
+	t.Parallel()
+
+	lb, err := ComputeLinkBudget(testLinkBudgetEntities(t), testLinkBudgetOptions())
+	checkErr(t, err)
+
+	for _, tc := range []struct {
+		name      string
+		got, want float64
+	}{
+		{"range", lb.RangeM, 1e6},
+		{"center frequency", lb.CenterFrequencyHz, 12e9},
+		{"transmit power", lb.TxPowerDBW, 10},
+		{"transmit chain gain", lb.TxChainGainDB, -1},
+		{"transmit antenna gain", lb.TxAntennaGainDBi, 39.7717},
+		{"EIRP", lb.EIRPDBW, 48.7717},
+		{"free space path loss", lb.FreeSpacePathLossDB, 174.0314},
+		{"system noise temperature", lb.SystemNoiseTemperatureK, 580},
+		{"G/T", lb.GOverTDBPerK, 12.1374},
+		{"received power", lb.ReceivedPowerDBW, -85.4880},
+		{"C/N0", lb.CarrierToNoiseDensityDB, 115.4769},
+		{"C/N", lb.CarrierToNoiseDB, 31.4975},
+		{"data rate", lb.DataRateBps, 5e8},
+	} {
+		if math.Abs(tc.got-tc.want) > 1e-3 {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.want, tc.got)
+		}
+	}
+	if lb.BandProfileID != "ku" || lb.ModCod != "16APSK 3/4" || lb.DataRateSource != outputv1.DataRateSourceRateTable {
+		t.Errorf("unexpected band profile %q, MODCOD %q, or data rate source %q", lb.BandProfileID, lb.ModCod, lb.DataRateSource)
+	}
+}
+
+func TestComputeLinkBudget_rangeOverride(t *testing.T) {
+	t.Parallel()
+
+	opts := testLinkBudgetOptions()
+	opts.RangeM = 2e6
+	lb, err := ComputeLinkBudget(testLinkBudgetEntities(t), opts)
+	checkErr(t, err)
+	// Doubling the range adds 6 dB of path loss.
+	if got, want := lb.FreeSpacePathLossDB, 174.0314+20*math.Log10(2); math.Abs(got-want) > 1e-3 {
+		t.Errorf("expected a path loss of %v, got %v", want, got)
+	}
+}
+
+func TestComputeLinkBudget_shannonLimitWithoutRateTable(t *testing.T) {
+	t.Parallel()
+
+	entities := testLinkBudgetEntities(t)
+	for _, e := range entities {
+		if bp := e.GetBandProfile(); bp != nil {
+			bp.RateTable = nil
+		}
+	}
+	lb, err := ComputeLinkBudget(entities, testLinkBudgetOptions())
+	checkErr(t, err)
+	want := 250e6 * math.Log2(1+math.Pow(10, lb.CarrierToNoiseDB/10))
+	if lb.DataRateSource != outputv1.DataRateSourceShannonLimit || math.Abs(lb.DataRateBps-want) > 1 {
+		t.Errorf("expected the Shannon limit of %v bps, got %v bps from %s", want, lb.DataRateBps, lb.DataRateSource)
+	}
+}
+
+func TestComputeLinkBudget_rejectsInvalidLinks(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name    string
+		modify  func(*LinkBudgetOptions)
+		wantErr string
+	}{
+		{
+			name:    "unknown platform",
+			modify:  func(o *LinkBudgetOptions) { o.Target.PlatformId = proto.String("nope") },
+			wantErr: `no PlatformDefinition with ID "nope" found`,
+		},
+		{
+			name:    "target can't receive",
+			modify:  func(o *LinkBudgetOptions) { o.Target = o.Transmitter },
+			wantErr: "transceiver model sat/tx has no receiver",
+		},
+		{
+			name:    "unknown band profile",
+			modify:  func(o *LinkBudgetOptions) { o.BandProfileID = "ka" },
+			wantErr: `no BandProfile with ID "ka" found`,
+		},
+		{
+			name:    "frequency the transmitter doesn't have",
+			modify:  func(o *LinkBudgetOptions) { o.CenterFrequencyHz = 11e9 },
+			wantErr: "transmitter sat/tx has no channel at 11000000000 Hz",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			opts := testLinkBudgetOptions()
+			tc.modify(&opts)
+			if _, err := ComputeLinkBudget(testLinkBudgetEntities(t), opts); err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("expected an error containing %q, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestEvaluateLinkBudget(t *testing.T) {
+	t.Parallel()
+
+	files := filepath.Join(t.TempDir(), "entities.textproto")
+	checkErr(t, os.WriteFile(files, []byte(testLinkBudgetTextproto), 0o644))
+
+	app := newTestApp()
+	checkErr(t, app.Run([]string{
+		"nbictl", "link-budget", "--files", files,
+		"--tx_platform_id", "sat", "--tx_transceiver_model_id", "tx", "--target_interface", "gs-node/ku",
+	}))
+	for _, want := range []string{
+		"Target                    gs/rx\n",
+		"Range                     1000.000 km\n",
+		"Center frequency          12 GHz\n",
+		"EIRP                      48.77 dBW\n",
+		"G/T                       12.14 dB/K\n",
+		"Data rate                 500 Mbps (16APSK 3/4)\n",
+	} {
+		if !strings.Contains(app.stdout.String(), want) {
+			t.Errorf("expected output to contain %q, got:\n%s", want, app.stdout.String())
+		}
+	}
+
+	app = newTestApp()
+	err := app.Run([]string{"nbictl", "link-budget", "--files", files, "--tx_platform_id", "sat", "--target_interface", "gs-node/ku"})
+	if err == nil || !strings.Contains(err.Error(), "--tx_transceiver_model_id are required") {
+		t.Errorf("expected an error about the missing transmitter flags, got %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	outputv1 "aalyria.com/spacetime/github/tools/nbictl/output/v1"
+)
+
+type keyInfo struct {
+	name          string
+	subject       string
+	notAfter      time.Time
+	fingerprint   string
+	hasPrivateKey bool
+}
+
+func ListKeys(appCtx *cli.Context) error {
+	keysDir, err := getKeysDir(appCtx, appCtx.String("dir"))
+	if err != nil {
+		return err
+	}
+	keys, err := readKeyInfos(keysDir)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	checkExpiry := appCtx.IsSet("check_expiry")
+	expiryDeadline := now.AddDate(0, 0, appCtx.Int("check_expiry"))
+	expiring := []string{}
+	for _, k := range keys {
+		if checkExpiry && k.notAfter.Before(expiryDeadline) {
+			expiring = append(expiring, k.name)
+		}
+	}
+
+	if jsonOutputRequested(appCtx) {
+		list := outputv1.NewKeyList()
+		for _, k := range keys {
+			list.Keys = append(list.Keys, outputv1.Key{
+				Name:              k.name,
+				Subject:           k.subject,
+				NotAfter:          k.notAfter.UTC(),
+				FingerprintSHA256: k.fingerprint,
+				HasPrivateKey:     k.hasPrivateKey,
+			})
+		}
+		if err := writeJSONOutput(appCtx, list); err != nil {
+			return err
+		}
+	} else {
+		w := tabwriter.NewWriter(appCtx.App.Writer, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tSUBJECT\tEXPIRES\tSHA-256 FINGERPRINT\tPRIVATE KEY")
+		for _, k := range keys {
+			privKey := "missing"
+			if k.hasPrivateKey {
+				privKey = "present"
+			}
+			expires := k.notAfter.Format(time.DateOnly)
+			if k.notAfter.Before(now) {
+				expires += " (expired)"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", k.name, k.subject, expires, k.fingerprint, privKey)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if len(expiring) > 0 {
+		return fmt.Errorf("%d certificate(s) expire within %d days: %s", len(expiring), appCtx.Int("check_expiry"), strings.Join(expiring, ", "))
+	}
+	return nil
+}
+
+// readKeyInfos parses every certificate in the keys directory, in lexical
+// order of their file names.
+func readKeyInfos(keysDir string) ([]keyInfo, error) {
+	certPaths, err := filepath.Glob(filepath.Join(keysDir, "*.crt"))
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []keyInfo{}
+	for _, certPath := range certPaths {
+		k, err := readKeyInfo(certPath)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", certPath, err)
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+func readKeyInfo(certPath string) (keyInfo, error) {
+	rawCert, err := os.ReadFile(certPath)
+	if err != nil {
+		return keyInfo{}, fmt.Errorf("unable to read certificate: %w", err)
+	}
+	block, _ := pem.Decode(rawCert)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return keyInfo{}, errors.New("not a PEM-encoded certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return keyInfo{}, fmt.Errorf("unable to parse certificate: %w", err)
+	}
+
+	name := strings.TrimSuffix(filepath.Base(certPath), ".crt")
+	_, err = os.Stat(filepath.Join(filepath.Dir(certPath), name+".key"))
+	switch {
+	case err == nil, errors.Is(err, os.ErrNotExist):
+	default:
+		return keyInfo{}, fmt.Errorf("unable to check for private key: %w", err)
+	}
+
+	fingerprint := sha256.Sum256(cert.Raw)
+	return keyInfo{
+		name:          name,
+		subject:       cert.Subject.String(),
+		notAfter:      cert.NotAfter,
+		fingerprint:   hex.EncodeToString(fingerprint[:]),
+		hasPrivateKey: err == nil,
+	}, nil
+}
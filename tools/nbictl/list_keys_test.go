@@ -0,0 +1,74 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/rules_go/go/tools/bazel"
+)
+
+func TestListKeys(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := bazel.NewTmpDir("nbictl")
+	checkErr(t, err)
+
+	generateKeysForTesting(t, tmpDir, "--org", exampleCertOrganization, "--name", "with-key", "--key_size", "2048", "--expiration", "30d")
+	checkErr(t, newTestApp().Run([]string{"nbictl", "generate-keys", "--dir", tmpDir, "--org", exampleCertOrganization, "--name", "without-key", "--key_size", "2048"}))
+	checkErr(t, os.Remove(filepath.Join(tmpDir, "without-key.key")))
+
+	app := newTestApp()
+	checkErr(t, app.Run([]string{"nbictl", "list-keys", "--dir", tmpDir}))
+
+	lines := strings.Split(strings.TrimSpace(app.stdout.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected a header and 2 keys, got:\n%s", app.stdout.String())
+	}
+	for _, tc := range []struct{ line, name, privKey string }{
+		{lines[1], "with-key", "present"},
+		{lines[2], "without-key", "missing"},
+	} {
+		fields := strings.Fields(tc.line)
+		switch {
+		case fields[0] != tc.name:
+			t.Errorf("expected key %q, got line %q", tc.name, tc.line)
+		case fields[len(fields)-1] != tc.privKey:
+			t.Errorf("expected private key to be %s, got line %q", tc.privKey, tc.line)
+		case !strings.Contains(tc.line, "O="+exampleCertOrganization):
+			t.Errorf("expected subject to contain the organization, got line %q", tc.line)
+		}
+	}
+}
+
+func TestListKeys_checkExpiry(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := bazel.NewTmpDir("nbictl")
+	checkErr(t, err)
+	generateKeysForTesting(t, tmpDir, "--org", exampleCertOrganization, "--name", "soon", "--key_size", "2048", "--expiration", "10d")
+
+	checkErr(t, newTestApp().Run([]string{"nbictl", "list-keys", "--dir", tmpDir, "--check_expiry", "5"}))
+
+	switch want, err := "1 certificate(s) expire within 30 days: soon", newTestApp().Run([]string{"nbictl", "list-keys", "--dir", tmpDir, "--check_expiry", "30"}); {
+	case err == nil:
+		t.Fatal("expected --check_expiry to fail, got nil")
+	case !strings.Contains(err.Error(), want):
+		t.Fatalf("expected error to contain %q, but got %q", want, err.Error())
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/urfave/cli/v2"
+)
+
+// errNoLocalState is returned by operations that would modify files in the
+// configuration directory when the `--no_local_state` flag is set.
+var errNoLocalState = errors.New("local state is disabled by --no_local_state")
+
+// checkLocalStateWritable returns an error if the invocation isn't allowed to
+// modify files in the configuration directory.
+func checkLocalStateWritable(appCtx *cli.Context) error {
+	if appCtx.Bool("no_local_state") {
+		return errNoLocalState
+	}
+	return nil
+}
+
+// withFileLock runs fn while holding an exclusive, advisory lock associated
+// with the file at path. The lock is held on a sibling ".lock" file so that
+// the file itself can be atomically replaced while the lock is held.
+func withFileLock(path string, fn func() error) (err error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
+		return fmt.Errorf("unable to create directory: %w", err)
+	}
+
+	unlock, err := lockFile(path + ".lock")
+	if err != nil {
+		return fmt.Errorf("unable to lock %s: %w", path, err)
+	}
+	defer func() { err = errors.Join(err, unlock()) }()
+
+	return fn()
+}
+
+// writeFileAtomic writes data to the named file by writing to a temporary
+// file in the same directory and renaming it into place, so concurrent
+// readers observe either the old or the new contents but never a partial
+// write.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) (err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err := tmp.Write(data); err != nil {
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,170 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+// Modules that write diagnostic logs, which can be enabled individually with
+// --log_modules.
+const (
+	logModuleBulk       = "bulk"
+	logModuleCache      = "cache"
+	logModuleConnection = "connection"
+)
+
+// levelNone is a level above every other, at which nothing is logged.
+const levelNone = slog.Level(math.MaxInt)
+
+var (
+	logLevelFlag = &cli.StringFlag{
+		Name:  "log_level",
+		Usage: "Minimum level (one of debug, info, warn, error, or none) of the diagnostic logs written to stderr.",
+		Value: "warn",
+	}
+	logFormatFlag = &cli.StringFlag{
+		Name:  "log_format",
+		Usage: "Format (one of text or json) of the diagnostic logs written to stderr.",
+		Value: "text",
+	}
+	logModulesFlag = &cli.StringFlag{
+		Name:  "log_modules",
+		Usage: "Comma-separated MODULE=LEVEL pairs (e.g. connection=debug,cache=info) that override --log_level for the logs of individual modules: " + strings.Join([]string{logModuleBulk, logModuleCache, logModuleConnection}, ", ") + ".",
+	}
+)
+
+// logConfig is the configuration of the diagnostic logs set by the --log_*
+// flags.
+type logConfig struct {
+	out     io.Writer
+	json    bool
+	level   slog.Level
+	modules map[string]slog.Level
+	// timestamps is the format of the time of each record of JSON logs.
+	timestamps TimestampFormat
+}
+
+type logConfigKey struct{}
+
+// configureLogging reads the --log_* flags and stores the resulting
+// configuration in the context of the command, for moduleLogger to use.
+func configureLogging(appCtx *cli.Context) error {
+	conf := logConfig{out: appCtx.App.ErrWriter, modules: map[string]slog.Level{}}
+	switch format := appCtx.String(logFormatFlag.Name); format {
+	case "text":
+	case "json":
+		conf.json = true
+		conf.timestamps = timestampFormatFromFlags(appCtx)
+	default:
+		return fmt.Errorf("invalid --%s %q (must be text or json)", logFormatFlag.Name, format)
+	}
+
+	var err error
+	if conf.level, err = parseLogLevel(appCtx.String(logLevelFlag.Name)); err != nil {
+		return fmt.Errorf("invalid --%s: %w", logLevelFlag.Name, err)
+	}
+	for _, pair := range strings.Split(appCtx.String(logModulesFlag.Name), ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		module, level, ok := strings.Cut(pair, "=")
+		if !ok || module == "" {
+			return fmt.Errorf("invalid --%s entry %q (must be MODULE=LEVEL)", logModulesFlag.Name, pair)
+		}
+		if conf.modules[module], err = parseLogLevel(level); err != nil {
+			return fmt.Errorf("invalid --%s level for module %q: %w", logModulesFlag.Name, module, err)
+		}
+	}
+
+	appCtx.Context = context.WithValue(appCtx.Context, logConfigKey{}, conf)
+	return nil
+}
+
+func parseLogLevel(s string) (slog.Level, error) {
+	if strings.EqualFold(s, "none") {
+		return levelNone, nil
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, err
+	}
+	return level, nil
+}
+
+// moduleLogger returns a logger for the diagnostics of `module`, which
+// discards everything if logging wasn't configured.
+func moduleLogger(ctx context.Context, module string) *slog.Logger {
+	conf, ok := ctx.Value(logConfigKey{}).(logConfig)
+	if !ok {
+		return slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: levelNone}))
+	}
+	return conf.logger(module, conf.out)
+}
+
+// moduleLoggerTo is like moduleLogger, but writes to `w`.
+func moduleLoggerTo(ctx context.Context, module string, w io.Writer) *slog.Logger {
+	conf, ok := ctx.Value(logConfigKey{}).(logConfig)
+	if !ok {
+		return moduleLogger(ctx, module)
+	}
+	return conf.logger(module, w)
+}
+
+func (c logConfig) logger(module string, w io.Writer) *slog.Logger {
+	level, ok := c.modules[module]
+	if !ok {
+		level = c.level
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler = slog.NewTextHandler(w, opts)
+	if c.json {
+		if c.timestamps == TimestampsEpochMillis {
+			opts.ReplaceAttr = epochMillisTime
+		}
+		h = slog.NewJSONHandler(w, opts)
+	}
+	return slog.New(h).With("module", module)
+}
+
+// epochMillisTime replaces the time of a record with milliseconds since the
+// Unix epoch.
+func epochMillisTime(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) == 0 && a.Key == slog.TimeKey && a.Value.Kind() == slog.KindTime {
+		return slog.Int64(slog.TimeKey, a.Value.Time().UnixMilli())
+	}
+	return a
+}
+
+// beforeCommand is the app's Before hook.
+func beforeCommand(appCtx *cli.Context) error {
+	if err := configureLogging(appCtx); err != nil {
+		return err
+	}
+	if err := applyCallInfo(appCtx); err != nil {
+		return err
+	}
+	if err := autoMigrateConfig(appCtx); err != nil {
+		return err
+	}
+	return applyDeadline(appCtx)
+}
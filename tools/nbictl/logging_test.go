@@ -0,0 +1,138 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/urfave/cli/v2"
+)
+
+// runWithLogs runs a command that logs a line at each level for the
+// connection and cache modules with the provided global flags, and returns
+// what was written to stderr.
+func runWithLogs(t *testing.T, globalArgs ...string) (string, error) {
+	t.Helper()
+
+	app := newTestApp()
+	app.Commands = append(app.Commands, &cli.Command{
+		Name:   "log-everything",
+		Hidden: true,
+		Action: func(appCtx *cli.Context) error {
+			for _, module := range []string{logModuleConnection, logModuleCache} {
+				log := moduleLogger(appCtx.Context, module)
+				log.Debug("debug")
+				log.Info("info")
+				log.Warn("warn")
+				log.Error("error")
+			}
+			return nil
+		},
+	})
+	err := app.Run(append(append([]string{"nbictl"}, globalArgs...), "log-everything"))
+	return app.stderr.String(), err
+}
+
+func TestModuleLogger(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name string
+		args []string
+		want []map[string]string
+	}{
+		{
+			name: "defaults",
+			want: []map[string]string{
+				{"level": "WARN", "module": "connection", "msg": "warn"},
+				{"level": "ERROR", "module": "connection", "msg": "error"},
+				{"level": "WARN", "module": "cache", "msg": "warn"},
+				{"level": "ERROR", "module": "cache", "msg": "error"},
+			},
+		},
+		{
+			name: "modules override the level",
+			args: []string{"--log_level", "error", "--log_modules", "connection=debug, cache=none"},
+			want: []map[string]string{
+				{"level": "DEBUG", "module": "connection", "msg": "debug"},
+				{"level": "INFO", "module": "connection", "msg": "info"},
+				{"level": "WARN", "module": "connection", "msg": "warn"},
+				{"level": "ERROR", "module": "connection", "msg": "error"},
+			},
+		},
+		{
+			name: "disabled",
+			args: []string{"--log_level", "none"},
+			want: []map[string]string{},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			stderr, err := runWithLogs(t, append([]string{"--log_format", "json"}, tc.args...)...)
+			checkErr(t, err)
+
+			got := []map[string]string{}
+			for _, line := range strings.Split(strings.TrimSpace(stderr), "\n") {
+				if line == "" {
+					continue
+				}
+				entry := map[string]any{}
+				if err := json.Unmarshal([]byte(line), &entry); err != nil {
+					t.Fatalf("unmarshalling log line %q: %v", line, err)
+				}
+				got = append(got, map[string]string{
+					"level":  entry["level"].(string),
+					"module": entry["module"].(string),
+					"msg":    entry["msg"].(string),
+				})
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("logged lines mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestModuleLogger_textFormat(t *testing.T) {
+	t.Parallel()
+
+	stderr, err := runWithLogs(t, "--log_modules", "cache=error")
+	checkErr(t, err)
+	if want := `level=WARN msg=warn module=connection`; !strings.Contains(stderr, want) {
+		t.Errorf("stderr = %q, want it to contain %q", stderr, want)
+	}
+	if unwanted := `level=WARN msg=warn module=cache`; strings.Contains(stderr, unwanted) {
+		t.Errorf("stderr = %q, want no warnings from the cache module", stderr)
+	}
+}
+
+func TestConfigureLogging_invalidFlags(t *testing.T) {
+	t.Parallel()
+
+	for _, args := range [][]string{
+		{"--log_format", "xml"},
+		{"--log_level", "loud"},
+		{"--log_modules", "connection"},
+		{"--log_modules", "connection=loud"},
+	} {
+		if _, err := runWithLogs(t, args...); err == nil {
+			t.Errorf("command with %v succeeded, want an error", args)
+		}
+	}
+}
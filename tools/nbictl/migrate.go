@@ -0,0 +1,219 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"github.com/urfave/cli/v2"
+	"google.golang.org/protobuf/encoding/prototext"
+
+	"aalyria.com/spacetime/github/tools/nbictl/nbictlpb"
+)
+
+// configSchemaVersion is the version of the format of the configuration
+// file that this version of nbictl reads and writes. Files written before
+// the format was versioned have no schema_version, which is version 0.
+const configSchemaVersion = 1
+
+// configMigration upgrades a configuration to schema version `to` from the
+// version before it.
+type configMigration struct {
+	to          uint32
+	description string
+	// migrate updates `conf` in place and returns a description of each
+	// change it made.
+	migrate func(conf *nbictlpb.AppConfig) []string
+}
+
+// configMigrations are applied in order to configurations with an older
+// schema version. Each must preserve the behavior of the configurations it
+// migrates.
+var configMigrations = []configMigration{
+	{
+		to:          1,
+		description: "move the audience and scopes of the client_credentials auth strategy to the context",
+		migrate:     migrateClientCredentialsClaims,
+	},
+}
+
+// migrateClientCredentialsClaims moves the audience and scopes of the
+// client_credentials strategy to the context, where set-config manages them,
+// unless the context sets its own.
+func migrateClientCredentialsClaims(conf *nbictlpb.AppConfig) []string {
+	changes := []string{}
+	for _, c := range conf.GetConfigs() {
+		cc := c.GetAuthStrategy().GetClientCredentials()
+		if cc == nil {
+			continue
+		}
+		if cc.GetAudience() != "" && c.GetAudience() == "" {
+			c.Audience, cc.Audience = cc.GetAudience(), ""
+			changes = append(changes, fmt.Sprintf("context %q: moved auth_strategy.client_credentials.audience to audience", c.GetName()))
+		}
+		if len(cc.GetScopes()) > 0 && len(c.GetScopes()) == 0 {
+			c.Scopes, cc.Scopes = cc.GetScopes(), nil
+			changes = append(changes, fmt.Sprintf("context %q: moved auth_strategy.client_credentials.scopes to scopes", c.GetName()))
+		}
+	}
+	return changes
+}
+
+// migrateAppConfig upgrades `conf` in place to configSchemaVersion and
+// returns a description of each change, grouped by migration.
+func migrateAppConfig(conf *nbictlpb.AppConfig) ([]string, error) {
+	from := conf.GetSchemaVersion()
+	if from > configSchemaVersion {
+		return nil, fmt.Errorf("the configuration was written by a newer version of nbictl (schema version %d, but this version only supports up to %d); upgrade nbictl, or restore a backup of the configuration", from, configSchemaVersion)
+	}
+	changes := []string{}
+	for _, m := range configMigrations {
+		if m.to <= from {
+			continue
+		}
+		changes = append(changes, fmt.Sprintf("schema version %d: %s", m.to, m.description))
+		for _, c := range m.migrate(conf) {
+			changes = append(changes, "  "+c)
+		}
+	}
+	conf.SchemaVersion = configSchemaVersion
+	return changes, nil
+}
+
+// configMigrationResult describes the migration of a configuration file.
+type configMigrationResult struct {
+	from, to uint32
+	changes  []string
+	// backup is the path the previous version was saved to, if it was.
+	backup string
+}
+
+// migrateConfigFile upgrades the configuration file at `confFile` to
+// configSchemaVersion, first copying the previous version to a backup file
+// next to it. With `dryRun`, it only reports what it would change.
+func migrateConfigFile(confFile string, dryRun bool) (configMigrationResult, error) {
+	// The file is only locked once there's something to migrate, since this
+	// runs before every command.
+	res, err := migrateConfigFileLocked(confFile, true)
+	if err != nil || dryRun || res.from == res.to {
+		return res, err
+	}
+	err = withFileLock(confFile, func() (err error) {
+		res, err = migrateConfigFileLocked(confFile, false)
+		return err
+	})
+	return res, err
+}
+
+// migrateConfigFileLocked is like migrateConfigFile, but callers that
+// aren't doing a dry run must hold the lock of `confFile`.
+func migrateConfigFileLocked(confFile string, dryRun bool) (configMigrationResult, error) {
+	res := configMigrationResult{from: configSchemaVersion, to: configSchemaVersion}
+	data, err := os.ReadFile(confFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return res, nil
+	} else if err != nil {
+		return res, fmt.Errorf("unable to read file: %w", err)
+	}
+	conf := &nbictlpb.AppConfig{}
+	if err := prototext.Unmarshal(data, conf); err != nil {
+		return res, fmt.Errorf("invalid file content: %w", err)
+	}
+	if res.from = conf.GetSchemaVersion(); res.from == configSchemaVersion {
+		return res, nil
+	}
+	if res.changes, err = migrateAppConfig(conf); err != nil || dryRun {
+		return res, err
+	}
+
+	migrated, err := prototext.Marshal(conf)
+	if err != nil {
+		return res, fmt.Errorf("unable to convert proto into textproto format: %w", err)
+	}
+	res.backup = fmt.Sprintf("%s.v%d.bak", confFile, res.from)
+	if err := writeFileAtomic(res.backup, data, 0o600); err != nil {
+		return res, fmt.Errorf("unable to back up the configuration: %w", err)
+	}
+	if err := writeFileAtomic(confFile, migrated, 0o777); err != nil {
+		return res, fmt.Errorf("unable to update the configuration information: %w", err)
+	}
+	return res, nil
+}
+
+// printMigration describes `res` to `w`.
+func printMigration(w io.Writer, confFile string, res configMigrationResult, dryRun bool) {
+	verb := "migrated"
+	if dryRun {
+		verb = "would migrate"
+	}
+	fmt.Fprintf(w, "%s %s from schema version %d to %d\n", verb, confFile, res.from, res.to)
+	for _, c := range res.changes {
+		fmt.Fprintf(w, "  %s\n", c)
+	}
+	if res.backup != "" {
+		fmt.Fprintf(w, "the previous version was backed up to %s\n", res.backup)
+	}
+}
+
+// autoMigrateConfig upgrades the configuration file when it was written by
+// an older version of nbictl, so that it's only migrated, and backed up,
+// once. Commands that can't modify the configuration directory migrate it
+// in memory instead, each time it's read.
+func autoMigrateConfig(appCtx *cli.Context) error {
+	if appCtx.Bool("no_local_state") || slices.Contains([]string{"migrate-config", "help", "h"}, appCtx.Args().First()) {
+		return nil
+	}
+	confFile, err := getConfFileForContext(appCtx)
+	if err != nil {
+		return err
+	}
+	res, err := migrateConfigFile(confFile, false)
+	if err != nil {
+		return fmt.Errorf("migrating %s: %w", confFile, err)
+	}
+	if res.from != res.to {
+		printMigration(appCtx.App.ErrWriter, confFile, res, false)
+	}
+	return nil
+}
+
+// MigrateConfig upgrades the configuration file to the schema of this
+// version of nbictl, backing up the previous version first.
+func MigrateConfig(appCtx *cli.Context) error {
+	dryRun := appCtx.Bool("dry_run")
+	if !dryRun {
+		if err := checkLocalStateWritable(appCtx); err != nil {
+			return err
+		}
+	}
+	confFile, err := getConfFileForContext(appCtx)
+	if err != nil {
+		return err
+	}
+	res, err := migrateConfigFile(confFile, dryRun)
+	if err != nil {
+		return err
+	}
+	if res.from == res.to {
+		fmt.Fprintf(appCtx.App.Writer, "%s is already at schema version %d\n", confFile, res.to)
+		return nil
+	}
+	printMigration(appCtx.App.Writer, confFile, res, dryRun)
+	return nil
+}
@@ -0,0 +1,107 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/rules_go/go/tools/bazel"
+	"google.golang.org/protobuf/encoding/prototext"
+
+	"aalyria.com/spacetime/github/tools/nbictl/nbictlpb"
+)
+
+const unversionedConfig = `configs: {
+  name: "prod"
+  url: "spacetime.example.com:443"
+  auth_strategy: {
+    client_credentials: {
+      token_url: "https://issuer.example.com/token"
+      client_id: "nbictl"
+      scopes: "spacetime"
+      audience: "spacetime-prod"
+    }
+  }
+}
+`
+
+func TestMigrateConfig(t *testing.T) {
+	t.Parallel()
+
+	dir, err := bazel.NewTmpDir("nbictl")
+	checkErr(t, err)
+	confFile := filepath.Join(dir, confFileName)
+	checkErr(t, os.WriteFile(confFile, []byte(unversionedConfig), 0o600))
+
+	app := newTestApp()
+	checkErr(t, app.Run([]string{"nbictl", "--config_dir", dir, "migrate-config", "--dry_run"}))
+	for _, want := range []string{
+		"would migrate " + confFile + " from schema version 0 to 1",
+		`context "prod": moved auth_strategy.client_credentials.audience to audience`,
+		`context "prod": moved auth_strategy.client_credentials.scopes to scopes`,
+	} {
+		if !strings.Contains(app.stdout.String(), want) {
+			t.Errorf("expected the dry run to print %q, got:\n%s", want, app.stdout.String())
+		}
+	}
+	if data, err := os.ReadFile(confFile); err != nil || string(data) != unversionedConfig {
+		t.Fatalf("expected the dry run to leave the configuration unchanged, got %q (%v)", data, err)
+	}
+
+	// Any other command migrates the configuration on first run.
+	app = newTestApp()
+	checkErr(t, app.Run([]string{"nbictl", "--config_dir", dir, "list-configs"}))
+	if !strings.Contains(app.stderr.String(), "migrated "+confFile) {
+		t.Errorf("expected the migration to be reported, got:\n%s", app.stderr.String())
+	}
+	if backup, err := os.ReadFile(confFile + ".v0.bak"); err != nil || string(backup) != unversionedConfig {
+		t.Errorf("expected the previous configuration to be backed up, got %q (%v)", backup, err)
+	}
+	data, err := os.ReadFile(confFile)
+	checkErr(t, err)
+	conf := &nbictlpb.AppConfig{}
+	checkErr(t, prototext.Unmarshal(data, conf))
+	prod := conf.GetConfigs()[0]
+	switch {
+	case conf.GetSchemaVersion() != configSchemaVersion:
+		t.Errorf("expected schema version %d, got %d", configSchemaVersion, conf.GetSchemaVersion())
+	case prod.GetAudience() != "spacetime-prod" || prod.GetAuthStrategy().GetClientCredentials().GetAudience() != "":
+		t.Errorf("expected the audience to move to the context, got %v", prod)
+	case len(prod.GetScopes()) != 1 || len(prod.GetAuthStrategy().GetClientCredentials().GetScopes()) != 0:
+		t.Errorf("expected the scopes to move to the context, got %v", prod)
+	}
+
+	app = newTestApp()
+	checkErr(t, app.Run([]string{"nbictl", "--config_dir", dir, "migrate-config"}))
+	if want := "is already at schema version"; !strings.Contains(app.stdout.String(), want) {
+		t.Errorf("expected %q, got:\n%s", want, app.stdout.String())
+	}
+}
+
+func TestReadConfigs_newerSchemaVersion(t *testing.T) {
+	t.Parallel()
+
+	dir, err := bazel.NewTmpDir("nbictl")
+	checkErr(t, err)
+	confFile := filepath.Join(dir, confFileName)
+	checkErr(t, os.WriteFile(confFile, []byte("schema_version: 99\n"+unversionedConfig), 0o600))
+
+	if _, err := readConfigs(confFile); err == nil || !strings.Contains(err.Error(), "newer version of nbictl") {
+		t.Errorf("expected readConfigs to refuse a newer schema version, got %v", err)
+	}
+}
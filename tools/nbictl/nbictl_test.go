@@ -17,10 +17,12 @@ package nbictl
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"net"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -36,6 +38,7 @@ import (
 	commonpb "aalyria.com/spacetime/api/common"
 	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
 	respb "aalyria.com/spacetime/api/nbi/v1alpha/resources"
+	outputv1 "aalyria.com/spacetime/github/tools/nbictl/output/v1"
 )
 
 type testApp struct {
@@ -142,7 +145,7 @@ func TestDelete_requiresID(t *testing.T) {
 	}))
 
 	args := []string{"nbictl", "--config_dir", tmpDir, "--context", "DEFAULT", "delete", "--type", "NETWORK_NODE"}
-	switch want, err := `either the "type" and "id" flags must be set, or the "files" flag must be set.`, newTestApp().Run(args); {
+	switch want, err := `either the "type" and "id" flags must be set, the "type" flag and the "ids", "filter" or "selector" flags must be set, or the "files" flag must be set.`, newTestApp().Run(args); {
 	case err == nil:
 		t.Fatal("expected missing --id to cause an error, got nil")
 	case !strings.Contains(err.Error(), want):
@@ -255,6 +258,40 @@ func TestGrpcurlCall_rejectsInvalidFormat(t *testing.T) {
 	}
 }
 
+func TestInvoke_rejectsTooManyArgs(t *testing.T) {
+	t.Parallel()
+
+	switch want, err := "invoke expects exactly 1 argument, got 2", newTestApp().Run([]string{
+		"nbictl", "invoke", "arg1", "arg2",
+	}); {
+	case err == nil:
+		t.Fatal("expected too many arguments to cause an error, got nil")
+	case !strings.Contains(err.Error(), want):
+		t.Fatalf("expected error to contain %v, but got %s", want, err.Error())
+	}
+}
+
+func TestBundledDescriptorSource(t *testing.T) {
+	t.Parallel()
+
+	src, err := bundledDescriptorSource()
+	checkErr(t, err)
+
+	svcs, err := src.ListServices()
+	checkErr(t, err)
+	for _, want := range []string{
+		"aalyria.spacetime.api.nbi.v1alpha.NetOps",
+		"aalyria.spacetime.api.nbi.v1alpha.SignalPropagation",
+	} {
+		if !slices.Contains(svcs, want) {
+			t.Errorf("expected bundled services to include %s, got %v", want, svcs)
+		}
+	}
+	if _, err := src.FindSymbol("aalyria.spacetime.api.nbi.v1alpha.NetOps.GetEntity"); err != nil {
+		t.Errorf("FindSymbol(NetOps.GetEntity): %v", err)
+	}
+}
+
 func startInsecureServer(ctx context.Context, t *testing.T, g *errgroup.Group) *FakeNetOpsServer {
 	lis, err := net.Listen("tcp", ":0")
 	checkErr(t, err)
@@ -342,7 +379,11 @@ func TestEndToEnd(t *testing.T) {
 		// These are written to a temporary directory, which is
 		// passed in the --files argument.
 		entitiesFiles [][]*nbipb.Entity
-		expectFn      func([]byte) error
+		// Provided to the command on stdin, if set.
+		stdin    string
+		expectFn func([]byte) error
+		// Verifies the contents of stderr, if set.
+		expectStderrFn func([]byte) error
 		// Whether to verify the output of the test based on the
 		// state of the fake server.
 		expectServerStateFn func(*FakeNetOpsServer) error
@@ -362,6 +403,15 @@ func TestEndToEnd(t *testing.T) {
 		}
 	}
 
+	expectContains := func(want string) func([]byte) error {
+		return func(gotData []byte) error {
+			if !strings.Contains(string(gotData), want) {
+				return fmt.Errorf("expected output to contain %q, got:\n%s", want, gotData)
+			}
+			return nil
+		}
+	}
+
 	// We can't use expectLines for prototext output because the format isn't
 	// stable (the authors intentionally sometimes vary the format by using two
 	// spaces between field name + colon and value), so instead we just verify
@@ -379,6 +429,19 @@ func TestEndToEnd(t *testing.T) {
 		}
 	}
 
+	expectBatchResult := func(want *outputv1.BatchResult) func([]byte) error {
+		return func(gotData []byte) error {
+			got := &outputv1.BatchResult{}
+			if err := json.Unmarshal(gotData, got); err != nil {
+				return err
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				return fmt.Errorf("output mismatch: (-want +got):\n%s", diff)
+			}
+			return nil
+		}
+	}
+
 	// Verifies that all of the expected entities were processed by the server.
 	expectEntityIDs := func(expectedEntities [][]*nbipb.Entity) func(*FakeNetOpsServer) error {
 		return func(testServer *FakeNetOpsServer) error {
@@ -468,6 +531,23 @@ func TestEndToEnd(t *testing.T) {
 				"aalyria.spacetime.api.nbi.v1alpha.NetOps.VersionInfo",
 			),
 		},
+		{
+			name: "invoke",
+			cmd:  []string{"invoke", "aalyria.spacetime.api.nbi.v1alpha.NetOps/GetEntity", "-d", `{"type": "NETWORK_NODE", "id": "b0ba-cafe"}`},
+			expectServerStateFn: expectLatestRequest(&nbipb.GetEntityRequest{
+				Type: nbipb.EntityType_NETWORK_NODE.Enum(),
+				Id:   proto.String("b0ba-cafe"),
+			}),
+		},
+		{
+			name:  "invoke with request from stdin",
+			cmd:   []string{"invoke", "--format", "text", "-d", "@-", "aalyria.spacetime.api.nbi.v1alpha.NetOps.GetEntity"},
+			stdin: `type: NETWORK_NODE id: "b0ba-cafe"`,
+			expectServerStateFn: expectLatestRequest(&nbipb.GetEntityRequest{
+				Type: nbipb.EntityType_NETWORK_NODE.Enum(),
+				Id:   proto.String("b0ba-cafe"),
+			}),
+		},
 		{
 			name: "list",
 			cmd:  []string{"list", "-t", "NETWORK_NODE"},
@@ -484,12 +564,57 @@ func TestEndToEnd(t *testing.T) {
 				Filter: &nbipb.EntityFilter{FieldMasks: []string{"network_node.name", "network_node.type"}},
 			}),
 		},
+		{
+			name: "list as table",
+			cmd:  []string{"list", "-t", "NETWORK_NODE", "-o", "table", "--column", "modified_by=last_modified_by", "--column", "delta=next_commit_timestamp - commit_timestamp"},
+			changeServer: func(srv *FakeNetOpsServer) {
+				srv.ListEntityResponse = listResponse
+			},
+			expectFn: expectLines(
+				"TYPE                     ID         modified_by         delta",
+				"ENTITY_TYPE_UNSPECIFIED  b0ba-cafe  your friend Ciaran  1",
+			),
+		},
+		{
+			name: "list with grpc log",
+			cmd:  []string{"--grpc_log", "-", "list", "-t", "NETWORK_NODE"},
+			changeServer: func(srv *FakeNetOpsServer) {
+				srv.ListEntityResponse = listResponse
+			},
+			expectStderrFn: expectContains(`"msg":"grpc call","method":"/aalyria.spacetime.api.nbi.v1alpha.NetOps/ListEntities"`),
+		},
+		{
+			name:  "shell",
+			cmd:   []string{"--no_local_state", "--grpc_log", "-", "shell"},
+			stdin: "list -t NETWORK_NODE\nbogus-command\nlist --type NETWORK_NODE --output table\nexit\n",
+			changeServer: func(srv *FakeNetOpsServer) {
+				srv.ListEntityResponse = listResponse
+			},
+			expectFn: func(got []byte) error {
+				if n := strings.Count(string(got), "b0ba-cafe"); n != 2 {
+					return fmt.Errorf("expected the entity to be listed twice, got:\n%s", got)
+				}
+				return nil
+			},
+			expectStderrFn: func(got []byte) error {
+				if n := strings.Count(string(got), `"msg":"grpc call"`); n != 2 {
+					return fmt.Errorf("expected 2 logged calls, got %d:\n%s", n, got)
+				}
+				return expectContains(`No help topic for 'bogus-command'`)(got)
+			},
+		},
 		{
 			name:                "create",
 			cmd:                 []string{"create"},
 			entitiesFiles:       defaultTestEntities,
 			expectServerStateFn: expectEntityIDs(defaultTestEntities),
 		},
+		{
+			name:                "create with limited concurrency",
+			cmd:                 []string{"create", "--concurrency", "1", "--qps", "1000"},
+			entitiesFiles:       defaultTestEntities,
+			expectServerStateFn: expectEntityIDs(defaultTestEntities),
+		},
 		{
 			name:                "update",
 			cmd:                 []string{"update"},
@@ -509,6 +634,31 @@ func TestEndToEnd(t *testing.T) {
 				},
 			}}),
 		},
+		{
+			name: "create with json output",
+			cmd:  []string{"create", "-o", "json"},
+			// The last file built by buildTestEntities has every entity once.
+			entitiesFiles: buildTestEntities(1, 2)[1:],
+			expectFn: expectBatchResult(&outputv1.BatchResult{
+				TypeMeta:  outputv1.TypeMeta{APIVersion: outputv1.APIVersion, Kind: "BatchResult"},
+				Operation: outputv1.OperationCreate,
+				Results: []outputv1.EntityResult{
+					{EntityType: "PLATFORM_DEFINITION", EntityID: "entity-0-0"},
+					{EntityType: "PLATFORM_DEFINITION", EntityID: "entity-0-1"},
+				},
+				Succeeded: 2,
+			}),
+		},
+		{
+			name: "delete single entity with json output",
+			cmd:  []string{"delete", "--type", "PLATFORM_DEFINITION", "--id", "my-id", "--ignore_consistency_check", "--output", "json"},
+			expectFn: expectBatchResult(&outputv1.BatchResult{
+				TypeMeta:  outputv1.TypeMeta{APIVersion: outputv1.APIVersion, Kind: "BatchResult"},
+				Operation: outputv1.OperationDelete,
+				Results:   []outputv1.EntityResult{{EntityType: "PLATFORM_DEFINITION", EntityID: "my-id"}},
+				Succeeded: 1,
+			}),
+		},
 		{
 			name:                "delete from files",
 			cmd:                 []string{"delete"},
@@ -559,10 +709,16 @@ func TestEndToEnd(t *testing.T) {
 				args = append(args, "--files", entitiesFilesGlob)
 			}
 
+			if tc.stdin != "" {
+				app.Reader = strings.NewReader(tc.stdin)
+			}
 			checkErr(t, app.Run(args))
 			if tc.expectFn != nil {
 				checkErr(t, tc.expectFn(app.stdout.Bytes()))
 			}
+			if tc.expectStderrFn != nil {
+				checkErr(t, tc.expectStderrFn(app.stderr.Bytes()))
+			}
 			if tc.expectServerStateFn != nil {
 				checkErr(t, tc.expectServerStateFn(srv))
 			}
@@ -0,0 +1,170 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbitest
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/protobuf/proto"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+)
+
+var (
+	// metaBucket holds the store's metadata, such as lastCommitKey.
+	metaBucket    = []byte("meta")
+	lastCommitKey = []byte("last_commit_timestamp")
+	// entitiesBucket holds a bucket per entity type, named after the type,
+	// which holds a bucket per entity ID. Those hold the entity's versions,
+	// keyed by their big-endian commit timestamps so they're in order.
+	entitiesBucket = []byte("entities")
+)
+
+// Version values start with one of these flags, followed by the
+// binary-encoded entity.
+const (
+	versionFlagStored  byte = 0
+	versionFlagDeleted byte = 1
+)
+
+// BoltStore is a [Store] that persists entities to a BoltDB database file,
+// so that a Server's state survives restarts and datasets larger than
+// memory can be served.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// OpenBoltStore opens the BoltDB database at `path`, creating it if it
+// doesn't exist. The database is locked until the store is closed.
+func OpenBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0o600, nil)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{metaBucket, entitiesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("initializing %s: %w", path, err)
+	}
+	return &BoltStore{db: db}, nil
+}
+
+// Close closes the database.
+func (b *BoltStore) Close() error {
+	return b.db.Close()
+}
+
+func (b *BoltStore) Versions(typ nbipb.EntityType, id string) ([]Version, error) {
+	var versions []Version
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := entityBucket(tx, typ, id)
+		if bucket == nil {
+			return nil
+		}
+		var err error
+		versions, err = decodeVersions(bucket)
+		return err
+	})
+	return versions, err
+}
+
+func (b *BoltStore) Append(v Version) error {
+	value, err := proto.Marshal(v.Entity)
+	if err != nil {
+		return err
+	}
+	flag := versionFlagStored
+	if v.Deleted {
+		flag = versionFlagDeleted
+	}
+	commit := binary.BigEndian.AppendUint64(nil, uint64(v.Entity.GetCommitTimestamp()))
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		types, err := tx.Bucket(entitiesBucket).CreateBucketIfNotExists([]byte(v.Entity.GetGroup().GetType().String()))
+		if err != nil {
+			return err
+		}
+		entity, err := types.CreateBucketIfNotExists([]byte(v.Entity.GetId()))
+		if err != nil {
+			return err
+		}
+		if err := entity.Put(commit, append([]byte{flag}, value...)); err != nil {
+			return err
+		}
+		return tx.Bucket(metaBucket).Put(lastCommitKey, commit)
+	})
+}
+
+func (b *BoltStore) ForEach(typ nbipb.EntityType, f func([]Version) error) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		types := tx.Bucket(entitiesBucket).Bucket([]byte(typ.String()))
+		if types == nil {
+			return nil
+		}
+		return types.ForEachBucket(func(id []byte) error {
+			versions, err := decodeVersions(types.Bucket(id))
+			if err != nil {
+				return err
+			}
+			return f(versions)
+		})
+	})
+}
+
+func (b *BoltStore) LastCommitTimestamp() (int64, error) {
+	var last int64
+	err := b.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(metaBucket).Get(lastCommitKey); v != nil {
+			last = int64(binary.BigEndian.Uint64(v))
+		}
+		return nil
+	})
+	return last, err
+}
+
+func entityBucket(tx *bolt.Tx, typ nbipb.EntityType, id string) *bolt.Bucket {
+	types := tx.Bucket(entitiesBucket).Bucket([]byte(typ.String()))
+	if types == nil {
+		return nil
+	}
+	return types.Bucket([]byte(id))
+}
+
+// decodeVersions decodes the versions in an entity's bucket. Values are only
+// valid during the transaction, so they're copied by proto.Unmarshal.
+func decodeVersions(bucket *bolt.Bucket) ([]Version, error) {
+	versions := []Version{}
+	err := bucket.ForEach(func(k, v []byte) error {
+		if len(v) == 0 {
+			return fmt.Errorf("empty version at commit timestamp %d", binary.BigEndian.Uint64(k))
+		}
+		e := &nbipb.Entity{}
+		if err := proto.Unmarshal(v[1:], e); err != nil {
+			return fmt.Errorf("decoding version at commit timestamp %d: %w", binary.BigEndian.Uint64(k), err)
+		}
+		versions = append(versions, Version{Entity: e, Deleted: v[0] == versionFlagDeleted})
+		return nil
+	})
+	return versions, err
+}
@@ -0,0 +1,105 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbitest
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	commonpb "aalyria.com/spacetime/api/common"
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+)
+
+func TestBoltStore_survivesRestarts(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "nbi.db")
+
+	// open returns a Server backed by the database, which is closed when the
+	// returned function is called.
+	open := func() (*Server, func()) {
+		store, err := OpenBoltStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv, err := NewWithStore(store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return srv, func() {
+			if err := store.Close(); err != nil {
+				t.Error(err)
+			}
+		}
+	}
+
+	srv, closeStore := open()
+	created, err := srv.CreateEntity(ctx, &nbipb.CreateEntityRequest{Entity: networkNode("node-a", "first")})
+	if err != nil {
+		t.Fatalf("CreateEntity: %v", err)
+	}
+	update := networkNode("node-a", "second")
+	update.CommitTimestamp = created.CommitTimestamp
+	updated, err := srv.UpdateEntity(ctx, &nbipb.UpdateEntityRequest{Entity: update})
+	if err != nil {
+		t.Fatalf("UpdateEntity: %v", err)
+	}
+	deleted := srv.Put(networkNode("node-b", "b"))[0]
+	if _, err := srv.DeleteEntity(ctx, &nbipb.DeleteEntityRequest{
+		Type:                nbipb.EntityType_NETWORK_NODE.Enum(),
+		Id:                  proto.String("node-b"),
+		LastCommitTimestamp: deleted.CommitTimestamp,
+	}); err != nil {
+		t.Fatalf("DeleteEntity: %v", err)
+	}
+	closeStore()
+
+	srv, closeStore = open()
+	defer closeStore()
+
+	got, ok := srv.Entity(nbipb.EntityType_NETWORK_NODE, "node-a")
+	if !ok || !proto.Equal(got, updated) {
+		t.Errorf("Entity after reopening the store: got %v, want %v", got, updated)
+	}
+	if _, ok := srv.Entity(nbipb.EntityType_NETWORK_NODE, "node-b"); ok {
+		t.Errorf("deleted entity node-b exists after reopening the store")
+	}
+
+	history, err := srv.ListEntitiesOverTime(ctx, &nbipb.ListEntitiesOverTimeRequest{
+		Type: nbipb.EntityType_NETWORK_NODE.Enum(),
+		Ids:  []string{"node-a"},
+		Interval: &commonpb.TimeInterval{
+			StartTime: &commonpb.DateTime{UnixTimeUsec: proto.Int64(0)},
+			EndTime:   &commonpb.DateTime{UnixTimeUsec: proto.Int64(time.Now().Add(time.Hour).UnixMicro())},
+		},
+	})
+	if err != nil {
+		t.Fatalf("ListEntitiesOverTime: %v", err)
+	}
+	if n := len(history.GetEntities()); n != 2 {
+		t.Errorf("ListEntitiesOverTime: got %d versions of node-a, want 2", n)
+	}
+
+	// Commit timestamps keep increasing across restarts.
+	again := srv.Put(networkNode("node-c", "c"))[0]
+	if again.GetCommitTimestamp() <= updated.GetCommitTimestamp() {
+		t.Errorf("commit_timestamp after reopening the store is %d, want more than %d", again.GetCommitTimestamp(), updated.GetCommitTimestamp())
+	}
+}
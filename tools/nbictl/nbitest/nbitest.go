@@ -0,0 +1,626 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package nbitest provides an in-memory implementation of the NetOps
+// service for hermetic tests of nbictl and other NBI clients. It stores
+// entities and their history, assigns commit timestamps and enforces
+// consistency checks like a Spacetime instance, and can inject faults such
+// as latency or errors into any RPC, or make reads eventually consistent.
+//
+// Entities can instead be persisted to a [BoltStore], so a sandbox's state
+// survives restarts and datasets larger than memory can be served, and
+// loaded from snapshots with [Server.LoadSnapshot].
+//
+// Other versions of the NBI can be served alongside v1alpha, from the same
+// entities, with [Server.AddAPIVersion].
+//
+// Entity filters aren't supported: requests that set one fail with
+// Unimplemented rather than returning unfiltered results.
+package nbitest
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+	"aalyria.com/spacetime/callctx"
+)
+
+// AllMethods applies a fault injected with [Server.InjectFault] to every
+// RPC.
+const AllMethods = ""
+
+// DefaultBuildVersion is the version a Server reports until
+// [Server.SetBuildVersion] is called.
+const DefaultBuildVersion = "0.0.0-nbitest"
+
+// Fault is a failure to inject into an RPC.
+type Fault struct {
+	// Latency delays the RPC, before it's handled or fails with Err.
+	Latency time.Duration
+	// Err, if set, is returned instead of handling the RPC. Use errors
+	// created by the status package, such as
+	// status.Error(codes.Unavailable, "..."), to control the code clients
+	// see.
+	Err error
+	// Times is how many RPCs the fault applies to. 0 applies it to every RPC
+	// until [Server.ClearFaults] is called.
+	Times int
+}
+
+type injectedFault struct {
+	method string
+	Fault
+}
+
+type entityKey struct {
+	typ nbipb.EntityType
+	id  string
+}
+
+type requestKey struct {
+	method string
+	id     string
+}
+
+// reply is the result of a call that modifies state, kept so that a retry of
+// the call with the same request ID gets it again instead of applying the
+// call twice.
+type reply struct {
+	resp proto.Message
+	err  error
+}
+
+// Server is a NetOps service that stores entities in memory or, optionally,
+// in a persistent [Store]. Its methods are safe for concurrent use.
+//
+// Calls that modify entities and are sent with a request ID (see
+// callctx.WithRequestID) are only applied once: a retry with the same ID gets
+// the result of the first call again.
+type Server struct {
+	nbipb.UnimplementedNetOpsServer
+
+	mu           sync.Mutex
+	store        Store
+	lastCommit   int64
+	faults       []*injectedFault
+	buildVersion string
+	readLag      time.Duration
+	// versions are the services of other API versions added with
+	// AddAPIVersion.
+	versions []*grpc.ServiceDesc
+	// replies are the results of the calls that modified state, by the
+	// request ID they were sent with (see callctx.WithRequestID).
+	replies map[requestKey]reply
+}
+
+// New returns a Server with no entities, which keeps them in memory.
+func New() *Server {
+	return &Server{
+		store:        NewMemoryStore(),
+		buildVersion: DefaultBuildVersion,
+	}
+}
+
+// NewWithStore returns a Server that serves the entities in `store`, such as
+// a [BoltStore], and stores new versions in it. The caller remains
+// responsible for closing the store once the Server is no longer used.
+func NewWithStore(store Store) (*Server, error) {
+	last, err := store.LastCommitTimestamp()
+	if err != nil {
+		return nil, fmt.Errorf("reading the last commit timestamp: %w", err)
+	}
+	return &Server{
+		store:        store,
+		lastCommit:   last,
+		buildVersion: DefaultBuildVersion,
+	}, nil
+}
+
+// Register registers the NetOps service, and those of the API versions added
+// with [Server.AddAPIVersion], with `r`, for use alongside other services or
+// with custom server options.
+func (s *Server) Register(r grpc.ServiceRegistrar) {
+	nbipb.RegisterNetOpsServer(r, s)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, desc := range s.versions {
+		r.RegisterService(desc, s)
+	}
+}
+
+// Serve accepts connections on `lis` until `ctx` is done. Connections are
+// plaintext unless `opts` configure transport credentials.
+func (s *Server) Serve(ctx context.Context, lis net.Listener, opts ...grpc.ServerOption) error {
+	srv := grpc.NewServer(opts...)
+	s.Register(srv)
+
+	stop := context.AfterFunc(ctx, srv.Stop)
+	defer stop()
+
+	if err := srv.Serve(lis); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+		return err
+	}
+	return nil
+}
+
+// InjectFault makes the RPC called `method`, such as "UpdateEntity", or
+// every RPC if `method` is [AllMethods], fail with `f`. Faults apply in the
+// order they're injected, and only the first fault matching an RPC applies
+// to it.
+func (s *Server) InjectFault(method string, f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, &injectedFault{method: method, Fault: f})
+}
+
+// ClearFaults removes every injected fault.
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = nil
+}
+
+// SetBuildVersion sets the version reported by VersionInfo.
+func (s *Server) SetBuildVersion(v string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buildVersion = v
+}
+
+// SetReadLag makes GetEntity and ListEntities return the entities as they
+// were `lag` ago, like a replica that lags behind the one mutations are
+// applied to. Mutations and their consistency checks always see the latest
+// versions. A lag of 0, the default, makes reads strongly consistent.
+func (s *Server) SetReadLag(lag time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.readLag = lag
+}
+
+// Put stores `entities` as if they'd been created or updated through the
+// NBI, without consistency checks or injected faults. It returns the entities
+// as stored, with their commit timestamps. Put panics if the store fails,
+// which only persistent stores can; use [Server.LoadSnapshot] to handle
+// those errors instead.
+func (s *Server) Put(entities ...*nbipb.Entity) []*nbipb.Entity {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := []*nbipb.Entity{}
+	for _, e := range entities {
+		e, err := s.put(e)
+		if err != nil {
+			panic(err)
+		}
+		stored = append(stored, e)
+	}
+	return stored
+}
+
+// LoadSnapshot stores the entities of the textproto-encoded TxtpbEntities
+// message read from `r`, like Put. Entities are stored as new versions, so
+// loading a snapshot into a Server that already has entities updates them.
+func (s *Server) LoadSnapshot(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	snapshot := &nbipb.TxtpbEntities{}
+	if err := prototext.Unmarshal(data, snapshot); err != nil {
+		return fmt.Errorf("parsing snapshot: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range snapshot.GetEntity() {
+		if err := validateKey(e.GetGroup().GetType(), e.GetId()); err != nil {
+			return fmt.Errorf("loading snapshot: %w", err)
+		}
+		if _, err := s.put(e); err != nil {
+			return fmt.Errorf("loading snapshot: storing %s %q: %w", e.GetGroup().GetType(), e.GetId(), err)
+		}
+	}
+	return nil
+}
+
+// Entity returns the current version of the entity with the provided type
+// and ID, if it exists. Like Put, it panics if the store fails.
+func (s *Server) Entity(typ nbipb.EntityType, id string) (*nbipb.Entity, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok, err := s.current(entityKey{typ, id})
+	if err != nil {
+		panic(err)
+	}
+	if !ok {
+		return nil, false
+	}
+	return proto.Clone(e).(*nbipb.Entity), true
+}
+
+// applyFault waits out and returns the first injected fault matching
+// `method`, if any.
+func (s *Server) applyFault(ctx context.Context, method string) error {
+	s.mu.Lock()
+	var fault *Fault
+	for i, f := range s.faults {
+		if f.method != AllMethods && f.method != method {
+			continue
+		}
+		fault = &f.Fault
+		if f.Times > 0 {
+			if f.Times--; f.Times == 0 {
+				s.faults = append(s.faults[:i:i], s.faults[i+1:]...)
+			}
+		}
+		break
+	}
+	s.mu.Unlock()
+	if fault == nil {
+		return nil
+	}
+
+	if fault.Latency > 0 {
+		t := time.NewTimer(fault.Latency)
+		defer t.Stop()
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-t.C:
+		}
+	}
+	return fault.Err
+}
+
+// dedupe returns the result of `apply`, which handles a call to `method`,
+// unless a call to `method` with the same request ID as the one being served
+// with `ctx` was already handled, in which case its result is returned again.
+// Calls without a request ID are always applied. The caller must hold s.mu.
+func dedupe[T proto.Message](ctx context.Context, s *Server, method string, apply func() (T, error)) (T, error) {
+	id := callctx.RequestIDFromIncomingContext(ctx)
+	if id == "" {
+		return apply()
+	}
+	k := requestKey{method, id}
+	if r, ok := s.replies[k]; ok {
+		return r.resp.(T), r.err
+	}
+	resp, err := apply()
+	if s.replies == nil {
+		s.replies = map[requestKey]reply{}
+	}
+	s.replies[k] = reply{resp, err}
+	return resp, err
+}
+
+func keyOf(e *nbipb.Entity) entityKey {
+	return entityKey{e.GetGroup().GetType(), e.GetId()}
+}
+
+// storeError converts an error from the store into the status RPCs fail
+// with.
+func storeError(err error) error {
+	return status.Errorf(codes.Internal, "nbitest store: %v", err)
+}
+
+// current returns the current version of the entity with key `k`. The caller
+// must hold s.mu.
+func (s *Server) current(k entityKey) (*nbipb.Entity, bool, error) {
+	versions, err := s.store.Versions(k.typ, k.id)
+	if err != nil {
+		return nil, false, storeError(err)
+	}
+	e, ok := latestVersion(versions, 0)
+	return e, ok, nil
+}
+
+// visible returns the version of the entity with key `k` that reads observe,
+// given the read lag. The caller must hold s.mu.
+func (s *Server) visible(k entityKey) (*nbipb.Entity, bool, error) {
+	versions, err := s.store.Versions(k.typ, k.id)
+	if err != nil {
+		return nil, false, storeError(err)
+	}
+	e, ok := latestVersion(versions, s.readLag)
+	return e, ok, nil
+}
+
+// latestVersion returns the latest of `versions` committed at least `lag`
+// ago, unless it's a delete.
+func latestVersion(versions []Version, lag time.Duration) (*nbipb.Entity, bool) {
+	i := len(versions) - 1
+	if lag > 0 {
+		cutoff := time.Now().Add(-lag).UnixMicro()
+		for i >= 0 && versions[i].Entity.GetCommitTimestamp() > cutoff {
+			i--
+		}
+	}
+	if i < 0 || versions[i].Deleted {
+		return nil, false
+	}
+	return versions[i].Entity, true
+}
+
+// nextCommitTimestamp returns a commit timestamp, in microseconds, later
+// than any assigned before. The caller must hold s.mu.
+func (s *Server) nextCommitTimestamp() int64 {
+	s.lastCommit = max(time.Now().UnixMicro(), s.lastCommit+1)
+	return s.lastCommit
+}
+
+// put records a new version of `e` and returns it. The caller must hold
+// s.mu.
+func (s *Server) put(e *nbipb.Entity) (*nbipb.Entity, error) {
+	stored := proto.Clone(e).(*nbipb.Entity)
+	stored.CommitTimestamp = proto.Int64(s.nextCommitTimestamp())
+	stored.NextCommitTimestamp = nil
+	if err := s.store.Append(Version{Entity: stored}); err != nil {
+		return nil, storeError(err)
+	}
+	return proto.Clone(stored).(*nbipb.Entity), nil
+}
+
+func validateKey(typ nbipb.EntityType, id string) error {
+	switch {
+	case typ == nbipb.EntityType_ENTITY_TYPE_UNSPECIFIED:
+		return status.Error(codes.InvalidArgument, "the entity type is required")
+	case id == "":
+		return status.Error(codes.InvalidArgument, "the entity ID is required")
+	default:
+		return nil
+	}
+}
+
+// GetEntity implements the NetOps service.
+func (s *Server) GetEntity(ctx context.Context, req *nbipb.GetEntityRequest) (*nbipb.Entity, error) {
+	if err := s.applyFault(ctx, "GetEntity"); err != nil {
+		return nil, err
+	}
+	if err := validateKey(req.GetType(), req.GetId()); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok, err := s.visible(entityKey{req.GetType(), req.GetId()})
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "%s %q not found", req.GetType(), req.GetId())
+	}
+	return proto.Clone(e).(*nbipb.Entity), nil
+}
+
+// CreateEntity implements the NetOps service. An ID is generated for
+// entities that don't have one.
+func (s *Server) CreateEntity(ctx context.Context, req *nbipb.CreateEntityRequest) (*nbipb.Entity, error) {
+	if err := s.applyFault(ctx, "CreateEntity"); err != nil {
+		return nil, err
+	}
+	e := proto.Clone(req.GetEntity()).(*nbipb.Entity)
+	if e.Id == nil {
+		e.Id = proto.String(uuid.NewString())
+	}
+	if err := validateKey(e.GetGroup().GetType(), e.GetId()); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return dedupe(ctx, s, "CreateEntity", func() (*nbipb.Entity, error) {
+		if _, ok, err := s.current(keyOf(e)); err != nil {
+			return nil, err
+		} else if ok {
+			return nil, status.Errorf(codes.AlreadyExists, "%s %q already exists", e.GetGroup().GetType(), e.GetId())
+		}
+		return s.put(e)
+	})
+}
+
+// UpdateEntity implements the NetOps service.
+func (s *Server) UpdateEntity(ctx context.Context, req *nbipb.UpdateEntityRequest) (*nbipb.Entity, error) {
+	if err := s.applyFault(ctx, "UpdateEntity"); err != nil {
+		return nil, err
+	}
+	e := req.GetEntity()
+	if err := validateKey(e.GetGroup().GetType(), e.GetId()); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return dedupe(ctx, s, "UpdateEntity", func() (*nbipb.Entity, error) {
+		cur, ok, err := s.current(keyOf(e))
+		if err != nil {
+			return nil, err
+		}
+		if ok && !req.GetIgnoreConsistencyCheck() && e.GetCommitTimestamp() != cur.GetCommitTimestamp() {
+			return nil, status.Errorf(codes.FailedPrecondition,
+				"the provided commit_timestamp (%d) doesn't match that of the stored %s %q (%d)",
+				e.GetCommitTimestamp(), e.GetGroup().GetType(), e.GetId(), cur.GetCommitTimestamp())
+		}
+		return s.put(e)
+	})
+}
+
+// DeleteEntity implements the NetOps service.
+func (s *Server) DeleteEntity(ctx context.Context, req *nbipb.DeleteEntityRequest) (*nbipb.DeleteEntityResponse, error) {
+	if err := s.applyFault(ctx, "DeleteEntity"); err != nil {
+		return nil, err
+	}
+	if err := validateKey(req.GetType(), req.GetId()); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return dedupe(ctx, s, "DeleteEntity", func() (*nbipb.DeleteEntityResponse, error) {
+		k := entityKey{req.GetType(), req.GetId()}
+		cur, ok, err := s.current(k)
+		switch {
+		case err != nil:
+			return nil, err
+		case !ok:
+			return nil, status.Errorf(codes.NotFound, "%s %q not found", req.GetType(), req.GetId())
+		case !req.GetIgnoreConsistencyCheck() && req.GetLastCommitTimestamp() != cur.GetCommitTimestamp():
+			return nil, status.Errorf(codes.FailedPrecondition,
+				"the provided last_commit_timestamp (%d) doesn't match that of the stored %s %q (%d)",
+				req.GetLastCommitTimestamp(), req.GetType(), req.GetId(), cur.GetCommitTimestamp())
+		}
+
+		tombstone := &nbipb.Entity{
+			Group:           &nbipb.EntityGroup{Type: req.GetType().Enum()},
+			Id:              proto.String(req.GetId()),
+			CommitTimestamp: proto.Int64(s.nextCommitTimestamp()),
+		}
+		if err := s.store.Append(Version{Entity: tombstone, Deleted: true}); err != nil {
+			return nil, storeError(err)
+		}
+		return &nbipb.DeleteEntityResponse{}, nil
+	})
+}
+
+// ListEntities implements the NetOps service. Only the latest entities can
+// be listed.
+func (s *Server) ListEntities(ctx context.Context, req *nbipb.ListEntitiesRequest) (*nbipb.ListEntitiesResponse, error) {
+	if err := s.applyFault(ctx, "ListEntities"); err != nil {
+		return nil, err
+	}
+	switch {
+	case req.GetType() == nbipb.EntityType_ENTITY_TYPE_UNSPECIFIED:
+		return nil, status.Error(codes.InvalidArgument, "the entity type is required")
+	case req.Interval != nil:
+		return nil, status.Error(codes.Unimplemented, "nbitest doesn't support listing entities over an interval; use ListEntitiesOverTime")
+	case req.Filter != nil:
+		return nil, status.Error(codes.Unimplemented, "nbitest doesn't support entity filters")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rsp := &nbipb.ListEntitiesResponse{}
+	err := s.store.ForEach(req.GetType(), func(versions []Version) error {
+		if e, ok := latestVersion(versions, s.readLag); ok {
+			rsp.Entities = append(rsp.Entities, proto.Clone(e).(*nbipb.Entity))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, storeError(err)
+	}
+	sortEntities(rsp.Entities)
+	return rsp, nil
+}
+
+// ListEntitiesOverTime implements the NetOps service. Every version of each
+// entity that existed during the interval is returned, including the empty
+// entities that represent deletes, with next_commit_timestamp set on the
+// versions superseded within it.
+func (s *Server) ListEntitiesOverTime(ctx context.Context, req *nbipb.ListEntitiesOverTimeRequest) (*nbipb.ListEntitiesOverTimeResponse, error) {
+	if err := s.applyFault(ctx, "ListEntitiesOverTime"); err != nil {
+		return nil, err
+	}
+	switch {
+	case req.GetType() == nbipb.EntityType_ENTITY_TYPE_UNSPECIFIED:
+		return nil, status.Error(codes.InvalidArgument, "the entity type is required")
+	case req.GetInterval().GetStartTime().UnixTimeUsec == nil || req.GetInterval().GetEndTime().UnixTimeUsec == nil:
+		return nil, status.Error(codes.InvalidArgument, "the interval's start and end times are required, as unix_time_usec")
+	case req.Filter != nil:
+		return nil, status.Error(codes.Unimplemented, "nbitest doesn't support entity filters")
+	case req.GetDiff():
+		return nil, status.Error(codes.Unimplemented, "nbitest doesn't support diffs")
+	}
+	start, end := req.GetInterval().GetStartTime().GetUnixTimeUsec(), req.GetInterval().GetEndTime().GetUnixTimeUsec()
+	ids := map[string]bool{}
+	for _, id := range req.GetIds() {
+		ids[id] = true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rsp := &nbipb.ListEntitiesOverTimeResponse{}
+	err := s.store.ForEach(req.GetType(), func(versions []Version) error {
+		if len(ids) > 0 && !ids[versions[0].Entity.GetId()] {
+			return nil
+		}
+		for i, v := range versions {
+			commit := v.Entity.GetCommitTimestamp()
+			next := int64(-1)
+			if i+1 < len(versions) {
+				next = versions[i+1].Entity.GetCommitTimestamp()
+			}
+			// Skip versions superseded before the interval, and those
+			// committed after it.
+			if (next >= 0 && next <= start) || commit >= end || (v.Deleted && commit < start) {
+				continue
+			}
+			e := proto.Clone(v.Entity).(*nbipb.Entity)
+			if next >= 0 && next < end {
+				e.NextCommitTimestamp = proto.Int64(next)
+			}
+			rsp.Entities = append(rsp.Entities, e)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, storeError(err)
+	}
+	sortEntities(rsp.Entities)
+	return rsp, nil
+}
+
+// VersionInfo implements the NetOps service.
+func (s *Server) VersionInfo(ctx context.Context, _ *nbipb.VersionInfoRequest) (*nbipb.VersionInfoResponse, error) {
+	if err := s.applyFault(ctx, "VersionInfo"); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &nbipb.VersionInfoResponse{BuildVersion: proto.String(s.buildVersion)}, nil
+}
+
+// sortEntities orders entities by ID and then commit timestamp, so responses
+// are deterministic.
+func sortEntities(entities []*nbipb.Entity) {
+	slices.SortFunc(entities, func(a, b *nbipb.Entity) int {
+		return cmp.Or(
+			strings.Compare(a.GetId(), b.GetId()),
+			cmp.Compare(a.GetCommitTimestamp(), b.GetCommitTimestamp()))
+	})
+}
@@ -0,0 +1,287 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbitest
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	commonpb "aalyria.com/spacetime/api/common"
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+	respb "aalyria.com/spacetime/api/nbi/v1alpha/resources"
+	"aalyria.com/spacetime/callctx"
+)
+
+func startServer(t *testing.T) (*Server, nbipb.NetOpsClient) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	g, ctx := errgroup.WithContext(ctx)
+	t.Cleanup(func() {
+		cancel()
+		if err := g.Wait(); err != nil {
+			t.Errorf("serving: %v", err)
+		}
+	})
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := New()
+	g.Go(func() error { return srv.Serve(ctx, lis) })
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return srv, nbipb.NewNetOpsClient(conn)
+}
+
+func networkNode(id, name string) *nbipb.Entity {
+	return &nbipb.Entity{
+		Group: &nbipb.EntityGroup{Type: nbipb.EntityType_NETWORK_NODE.Enum()},
+		Id:    proto.String(id),
+		Value: &nbipb.Entity_NetworkNode{NetworkNode: &respb.NetworkNode{Name: proto.String(name)}},
+	}
+}
+
+func TestServer_tracksVersions(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	_, client := startServer(t)
+
+	created, err := client.CreateEntity(ctx, &nbipb.CreateEntityRequest{Entity: networkNode("node-a", "first")})
+	if err != nil {
+		t.Fatalf("CreateEntity: %v", err)
+	}
+	if _, err := client.CreateEntity(ctx, &nbipb.CreateEntityRequest{Entity: networkNode("node-a", "again")}); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("CreateEntity of an existing entity: got %v, want AlreadyExists", err)
+	}
+
+	stale := networkNode("node-a", "stale")
+	stale.CommitTimestamp = proto.Int64(created.GetCommitTimestamp() - 1)
+	if _, err := client.UpdateEntity(ctx, &nbipb.UpdateEntityRequest{Entity: stale}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("UpdateEntity with a stale commit_timestamp: got %v, want FailedPrecondition", err)
+	}
+
+	update := networkNode("node-a", "second")
+	update.CommitTimestamp = proto.Int64(created.GetCommitTimestamp())
+	updated, err := client.UpdateEntity(ctx, &nbipb.UpdateEntityRequest{Entity: update})
+	if err != nil {
+		t.Fatalf("UpdateEntity: %v", err)
+	}
+	if updated.GetCommitTimestamp() <= created.GetCommitTimestamp() {
+		t.Errorf("commit_timestamp went from %d to %d, want it to increase", created.GetCommitTimestamp(), updated.GetCommitTimestamp())
+	}
+
+	got, err := client.GetEntity(ctx, &nbipb.GetEntityRequest{Type: nbipb.EntityType_NETWORK_NODE.Enum(), Id: proto.String("node-a")})
+	if err != nil {
+		t.Fatalf("GetEntity: %v", err)
+	}
+	if !proto.Equal(got, updated) {
+		t.Errorf("GetEntity: got %v, want %v", got, updated)
+	}
+
+	if _, err := client.DeleteEntity(ctx, &nbipb.DeleteEntityRequest{
+		Type:                nbipb.EntityType_NETWORK_NODE.Enum(),
+		Id:                  proto.String("node-a"),
+		LastCommitTimestamp: proto.Int64(updated.GetCommitTimestamp()),
+	}); err != nil {
+		t.Fatalf("DeleteEntity: %v", err)
+	}
+	list, err := client.ListEntities(ctx, &nbipb.ListEntitiesRequest{Type: nbipb.EntityType_NETWORK_NODE.Enum()})
+	if err != nil {
+		t.Fatalf("ListEntities: %v", err)
+	}
+	if len(list.GetEntities()) != 0 {
+		t.Errorf("ListEntities after deleting the only entity: got %v, want none", list.GetEntities())
+	}
+
+	history, err := client.ListEntitiesOverTime(ctx, &nbipb.ListEntitiesOverTimeRequest{
+		Type: nbipb.EntityType_NETWORK_NODE.Enum(),
+		Interval: &commonpb.TimeInterval{
+			StartTime: &commonpb.DateTime{UnixTimeUsec: proto.Int64(created.GetCommitTimestamp())},
+			EndTime:   &commonpb.DateTime{UnixTimeUsec: proto.Int64(time.Now().Add(time.Hour).UnixMicro())},
+		},
+	})
+	if err != nil {
+		t.Fatalf("ListEntitiesOverTime: %v", err)
+	}
+	if n := len(history.GetEntities()); n != 3 {
+		t.Fatalf("ListEntitiesOverTime: got %d versions, want 3 (created, updated, deleted): %v", n, history.GetEntities())
+	}
+	if got, want := history.GetEntities()[0].GetNextCommitTimestamp(), updated.GetCommitTimestamp(); got != want {
+		t.Errorf("next_commit_timestamp of the first version: got %d, want %d", got, want)
+	}
+	if deleted := history.GetEntities()[2]; deleted.GetValue() != nil {
+		t.Errorf("the delete was recorded as %v, want an entity with no value", deleted)
+	}
+}
+
+func TestServer_injectsFaults(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	srv, client := startServer(t)
+	srv.Put(networkNode("node-a", "a"))
+	get := func() error {
+		_, err := client.GetEntity(ctx, &nbipb.GetEntityRequest{Type: nbipb.EntityType_NETWORK_NODE.Enum(), Id: proto.String("node-a")})
+		return err
+	}
+
+	srv.InjectFault("GetEntity", Fault{Err: status.Error(codes.Unavailable, "try again"), Times: 2})
+	srv.InjectFault(AllMethods, Fault{Err: status.Error(codes.PermissionDenied, "go away")})
+	for i, want := range []codes.Code{codes.Unavailable, codes.Unavailable, codes.PermissionDenied} {
+		if got := status.Code(get()); got != want {
+			t.Errorf("call %d: got %s, want %s", i, got, want)
+		}
+	}
+	if _, err := client.VersionInfo(ctx, &nbipb.VersionInfoRequest{}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("VersionInfo: got %v, want PermissionDenied", err)
+	}
+
+	srv.ClearFaults()
+	srv.InjectFault("GetEntity", Fault{Latency: time.Second})
+	shortCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err := client.GetEntity(shortCtx, &nbipb.GetEntityRequest{Type: nbipb.EntityType_NETWORK_NODE.Enum(), Id: proto.String("node-a")})
+	if status.Code(err) != codes.DeadlineExceeded && !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GetEntity with latency beyond the deadline: got %v, want DeadlineExceeded", err)
+	}
+
+	srv.ClearFaults()
+	if err := get(); err != nil {
+		t.Errorf("GetEntity after clearing faults: %v", err)
+	}
+}
+
+func TestServer_dedupesRequestIDs(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	srv, client := startServer(t)
+	create := func(ctx context.Context) *nbipb.Entity {
+		t.Helper()
+		// Without an ID, every CreateEntity the server applies creates a
+		// new entity.
+		e := networkNode("", "a")
+		e.Id = nil
+		created, err := client.CreateEntity(ctx, &nbipb.CreateEntityRequest{Entity: e})
+		if err != nil {
+			t.Fatalf("CreateEntity: %v", err)
+		}
+		return created
+	}
+
+	reqCtx := metadata.AppendToOutgoingContext(ctx, callctx.RequestIDKey, "request-1")
+	first, replayed := create(reqCtx), create(reqCtx)
+	if !proto.Equal(first, replayed) {
+		t.Errorf("replayed CreateEntity: got %v, want the entity of the first call %v", replayed, first)
+	}
+	list, err := srv.ListEntities(ctx, &nbipb.ListEntitiesRequest{Type: nbipb.EntityType_NETWORK_NODE.Enum()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(list.GetEntities()); got != 1 {
+		t.Errorf("after replaying CreateEntity: got %d entities, want 1", got)
+	}
+
+	create(metadata.AppendToOutgoingContext(ctx, callctx.RequestIDKey, "request-2"))
+	create(ctx)
+	list, err = srv.ListEntities(ctx, &nbipb.ListEntitiesRequest{Type: nbipb.EntityType_NETWORK_NODE.Enum()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(list.GetEntities()); got != 3 {
+		t.Errorf("after CreateEntity with another request ID and without one: got %d entities, want 3", got)
+	}
+}
+
+func TestServer_readLag(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	srv, client := startServer(t)
+	stored := srv.Put(networkNode("node-a", "a"))[0]
+	get := func() (*nbipb.Entity, error) {
+		return client.GetEntity(ctx, &nbipb.GetEntityRequest{Type: nbipb.EntityType_NETWORK_NODE.Enum(), Id: proto.String("node-a")})
+	}
+
+	srv.SetReadLag(time.Hour)
+	if _, err := get(); status.Code(err) != codes.NotFound {
+		t.Errorf("GetEntity of a recent entity with a read lag: got %v, want NotFound", err)
+	}
+	list, err := client.ListEntities(ctx, &nbipb.ListEntitiesRequest{Type: nbipb.EntityType_NETWORK_NODE.Enum()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.GetEntities()) != 0 {
+		t.Errorf("ListEntities with a read lag returned %d entities, want 0", len(list.GetEntities()))
+	}
+	// Consistency checks aren't affected by the lag.
+	update := proto.Clone(networkNode("node-a", "b")).(*nbipb.Entity)
+	update.CommitTimestamp = stored.CommitTimestamp
+	if _, err := client.UpdateEntity(ctx, &nbipb.UpdateEntityRequest{Entity: update}); err != nil {
+		t.Fatalf("UpdateEntity with a read lag: %v", err)
+	}
+
+	srv.SetReadLag(0)
+	got, err := get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.GetNetworkNode().GetName() != "b" {
+		t.Errorf("GetEntity without a read lag returned name %q, want %q", got.GetNetworkNode().GetName(), "b")
+	}
+}
+
+func TestServer_loadSnapshot(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	srv := New()
+	if err := srv.LoadSnapshot(strings.NewReader(`
+entity { group { type: NETWORK_NODE } id: "node-a" network_node { name: "a" } }
+entity { group { type: NETWORK_NODE } id: "node-b" network_node { name: "b" } }
+`)); err != nil {
+		t.Fatalf("LoadSnapshot: %v", err)
+	}
+	list, err := srv.ListEntities(ctx, &nbipb.ListEntitiesRequest{Type: nbipb.EntityType_NETWORK_NODE.Enum()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(list.GetEntities()); n != 2 {
+		t.Errorf("ListEntities after loading a snapshot returned %d entities, want 2", n)
+	}
+
+	if err := srv.LoadSnapshot(strings.NewReader(`entity { id: "no-type" }`)); err == nil {
+		t.Errorf("LoadSnapshot of an entity without a type: got no error")
+	}
+}
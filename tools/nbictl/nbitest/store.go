@@ -0,0 +1,88 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbitest
+
+import (
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+)
+
+// Version is a single revision of an entity. Deletes are recorded as
+// versions too, so the entity's history can be replayed.
+type Version struct {
+	// Entity is the entity as stored, with its commit timestamp set. For
+	// deletes, only its type, ID and commit timestamp are set.
+	Entity  *nbipb.Entity
+	Deleted bool
+}
+
+// Store holds the history of every entity served by a [Server]. The Server
+// serializes calls to its Store, so implementations don't need to be safe
+// for concurrent use. Stores mustn't retain or modify the entities passed to
+// or returned by them after the call returns, except that returned entities
+// may be shared with the store as long as the Server doesn't modify them.
+type Store interface {
+	// Versions returns the versions of the entity with the provided type and
+	// ID, oldest first, or none if it has never existed.
+	Versions(typ nbipb.EntityType, id string) ([]Version, error)
+	// Append records a new version of an entity, whose commit timestamp is
+	// later than that of any version appended before.
+	Append(v Version) error
+	// ForEach calls `f` with the versions of each entity of type `typ`, in
+	// no particular order, until `f` returns an error, which ForEach
+	// returns.
+	ForEach(typ nbipb.EntityType, f func(versions []Version) error) error
+	// LastCommitTimestamp returns the commit timestamp of the latest version
+	// appended, or 0 if there are none. Commit timestamps keep increasing
+	// across restarts of a Server using a persistent Store.
+	LastCommitTimestamp() (int64, error)
+}
+
+// memoryStore is the default Store, which keeps every version in memory.
+type memoryStore struct {
+	history    map[entityKey][]Version
+	lastCommit int64
+}
+
+// NewMemoryStore returns an empty Store that keeps every version in memory.
+func NewMemoryStore() Store {
+	return &memoryStore{history: map[entityKey][]Version{}}
+}
+
+func (m *memoryStore) Versions(typ nbipb.EntityType, id string) ([]Version, error) {
+	return m.history[entityKey{typ, id}], nil
+}
+
+func (m *memoryStore) Append(v Version) error {
+	k := keyOf(v.Entity)
+	m.history[k] = append(m.history[k], v)
+	m.lastCommit = max(m.lastCommit, v.Entity.GetCommitTimestamp())
+	return nil
+}
+
+func (m *memoryStore) ForEach(typ nbipb.EntityType, f func([]Version) error) error {
+	for k, versions := range m.history {
+		if k.typ != typ {
+			continue
+		}
+		if err := f(versions); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *memoryStore) LastCommitTimestamp() (int64, error) {
+	return m.lastCommit, nil
+}
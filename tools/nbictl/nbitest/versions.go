@@ -0,0 +1,149 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbitest
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+)
+
+// Translator converts the messages of another version of the NBI to and
+// from their v1alpha equivalents, so a Server can serve that version from
+// the same entities.
+type Translator interface {
+	// ToV1alpha fills `dst`, an empty v1alpha request, from `src`, the
+	// request received by the other version's method of the same name.
+	ToV1alpha(method string, src, dst proto.Message) error
+	// FromV1alpha fills `dst`, an empty response of the other version's
+	// method, from `src`, the v1alpha method's response.
+	FromV1alpha(method string, src, dst proto.Message) error
+}
+
+// WireTranslator translates messages by re-encoding them, which preserves
+// every field whose number and type are the same in both versions. Fields
+// only one version has are kept as unknown fields.
+type WireTranslator struct{}
+
+// ToV1alpha implements Translator.
+func (WireTranslator) ToV1alpha(_ string, src, dst proto.Message) error { return reencode(src, dst) }
+
+// FromV1alpha implements Translator.
+func (WireTranslator) FromV1alpha(_ string, src, dst proto.Message) error { return reencode(src, dst) }
+
+func reencode(src, dst proto.Message) error {
+	b, err := proto.Marshal(src)
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(b, dst)
+}
+
+// AddAPIVersion makes the Server also serve `svc`, the NetOps service of
+// another version of the NBI, by translating its requests with `t` and
+// handling them like the v1alpha method of the same name. Both versions see
+// the same entities, and faults injected for a method apply to it in every
+// version, which lets clients be migrated between versions against a single
+// Server. It must be called before [Server.Register] or [Server.Serve].
+//
+// Every method of `svc` must be a unary method with a v1alpha equivalent.
+func (s *Server) AddAPIVersion(svc protoreflect.ServiceDescriptor, t Translator) error {
+	if string(svc.FullName()) == nbipb.NetOps_ServiceDesc.ServiceName {
+		return fmt.Errorf("%s is always served", svc.FullName())
+	}
+	v1alpha := map[string]grpc.MethodDesc{}
+	for _, m := range nbipb.NetOps_ServiceDesc.Methods {
+		v1alpha[m.MethodName] = m
+	}
+
+	desc := &grpc.ServiceDesc{
+		ServiceName: string(svc.FullName()),
+		HandlerType: (*any)(nil),
+		Metadata:    svc.ParentFile().Path(),
+	}
+	methods := svc.Methods()
+	for i := range methods.Len() {
+		m := methods.Get(i)
+		name := string(m.Name())
+		if m.IsStreamingClient() || m.IsStreamingServer() {
+			return fmt.Errorf("%s: streaming methods aren't supported", m.FullName())
+		}
+		target, ok := v1alpha[name]
+		if !ok {
+			return fmt.Errorf("%s: v1alpha NetOps has no method called %s", m.FullName(), name)
+		}
+		desc.Methods = append(desc.Methods, grpc.MethodDesc{
+			MethodName: name,
+			Handler:    s.translatingHandler(m, target, t),
+		})
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.versions = append(s.versions, desc)
+	return nil
+}
+
+// translatingHandler returns a handler for the method `m` of another
+// version that translates its request and calls the v1alpha `target`.
+func (s *Server) translatingHandler(m protoreflect.MethodDescriptor, target grpc.MethodDesc, t Translator) func(any, context.Context, func(any) error, grpc.UnaryServerInterceptor) (any, error) {
+	name := string(m.Name())
+	fullMethod := fmt.Sprintf("/%s/%s", m.Parent().FullName(), name)
+
+	return func(_ any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+		req := newMessage(m.Input())
+		if err := dec(req); err != nil {
+			return nil, err
+		}
+		handle := func(ctx context.Context, req any) (any, error) {
+			rsp, err := target.Handler(s, ctx, func(v1alphaReq any) error {
+				if err := t.ToV1alpha(name, req.(proto.Message), v1alphaReq.(proto.Message)); err != nil {
+					return status.Errorf(codes.InvalidArgument, "translating the request to v1alpha: %v", err)
+				}
+				return nil
+			}, nil)
+			if err != nil {
+				return nil, err
+			}
+			out := newMessage(m.Output())
+			if err := t.FromV1alpha(name, rsp.(proto.Message), out); err != nil {
+				return nil, status.Errorf(codes.Internal, "translating the v1alpha response: %v", err)
+			}
+			return out, nil
+		}
+		if interceptor == nil {
+			return handle(ctx, req)
+		}
+		return interceptor(ctx, req, &grpc.UnaryServerInfo{Server: s, FullMethod: fullMethod}, handle)
+	}
+}
+
+// newMessage returns an empty message of type `md`, using its generated Go
+// type if it's linked in, so translators can use type assertions.
+func newMessage(md protoreflect.MessageDescriptor) proto.Message {
+	if mt, err := protoregistry.GlobalTypes.FindMessageByName(md.FullName()); err == nil {
+		return mt.New().Interface()
+	}
+	return dynamicpb.NewMessage(md)
+}
@@ -0,0 +1,171 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbitest
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+)
+
+// fakeNextVersion returns a NetOps service of a made-up version of the NBI
+// with a single method, GetEntity, whose Entity is wire compatible with
+// v1alpha's apart from a new display_name field.
+func fakeNextVersion(t *testing.T, extraMethods ...string) protoreflect.ServiceDescriptor {
+	t.Helper()
+
+	field := func(name string, num int32, typ descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			Number:   proto.Int32(num),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:     typ.Enum(),
+			JsonName: proto.String(name),
+		}
+	}
+	method := func(name string) *descriptorpb.MethodDescriptorProto {
+		return &descriptorpb.MethodDescriptorProto{
+			Name:       proto.String(name),
+			InputType:  proto.String(".nbitest.next.GetEntityRequest"),
+			OutputType: proto.String(".nbitest.next.Entity"),
+		}
+	}
+	svc := &descriptorpb.ServiceDescriptorProto{
+		Name:   proto.String("NetOps"),
+		Method: []*descriptorpb.MethodDescriptorProto{method("GetEntity")},
+	}
+	for _, m := range extraMethods {
+		svc.Method = append(svc.Method, method(m))
+	}
+
+	fd, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:    proto.String("nbitest/next.proto"),
+		Package: proto.String("nbitest.next"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("GetEntityRequest"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("type", 1, descriptorpb.FieldDescriptorProto_TYPE_INT32),
+					field("id", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+				},
+			},
+			{
+				Name: proto.String("Entity"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("id", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+					field("commit_timestamp", 3, descriptorpb.FieldDescriptorProto_TYPE_INT64),
+					field("display_name", 100, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+				},
+			},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{svc},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fd.Services().Get(0)
+}
+
+// displayNameTranslator fills the display_name of the next version's Entity
+// from the name of v1alpha network nodes.
+type displayNameTranslator struct{ WireTranslator }
+
+func (d displayNameTranslator) FromV1alpha(method string, src, dst proto.Message) error {
+	if err := d.WireTranslator.FromV1alpha(method, src, dst); err != nil {
+		return err
+	}
+	if e, ok := src.(*nbipb.Entity); ok {
+		m := dst.ProtoReflect()
+		m.Set(m.Descriptor().Fields().ByName("display_name"), protoreflect.ValueOfString(e.GetNetworkNode().GetName()))
+	}
+	return nil
+}
+
+func TestServer_servesOtherAPIVersions(t *testing.T) {
+	t.Parallel()
+
+	next := fakeNextVersion(t)
+	srv := New()
+	if err := srv.AddAPIVersion(next, displayNameTranslator{}); err != nil {
+		t.Fatalf("AddAPIVersion: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	g, ctx := errgroup.WithContext(ctx)
+	t.Cleanup(func() {
+		cancel()
+		if err := g.Wait(); err != nil {
+			t.Errorf("serving: %v", err)
+		}
+	})
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.Go(func() error { return srv.Serve(ctx, lis) })
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	client := nbipb.NewNetOpsClient(conn)
+	created, err := client.CreateEntity(ctx, &nbipb.CreateEntityRequest{Entity: networkNode("node-a", "first")})
+	if err != nil {
+		t.Fatalf("v1alpha CreateEntity: %v", err)
+	}
+
+	method := next.Methods().ByName("GetEntity")
+	req := dynamicpb.NewMessage(method.Input())
+	req.Set(method.Input().Fields().ByName("type"), protoreflect.ValueOfInt32(int32(nbipb.EntityType_NETWORK_NODE)))
+	req.Set(method.Input().Fields().ByName("id"), protoreflect.ValueOfString("node-a"))
+	rsp := dynamicpb.NewMessage(method.Output())
+	if err := conn.Invoke(ctx, "/nbitest.next.NetOps/GetEntity", req, rsp); err != nil {
+		t.Fatalf("next version GetEntity: %v", err)
+	}
+
+	fields := method.Output().Fields()
+	if got := rsp.Get(fields.ByName("id")).String(); got != "node-a" {
+		t.Errorf("id: got %q, want %q", got, "node-a")
+	}
+	if got := rsp.Get(fields.ByName("commit_timestamp")).Int(); got != created.GetCommitTimestamp() {
+		t.Errorf("commit_timestamp: got %d, want %d", got, created.GetCommitTimestamp())
+	}
+	if got := rsp.Get(fields.ByName("display_name")).String(); got != "first" {
+		t.Errorf("display_name: got %q, want %q", got, "first")
+	}
+}
+
+func TestServer_addAPIVersionRequiresV1alphaEquivalents(t *testing.T) {
+	t.Parallel()
+
+	err := New().AddAPIVersion(fakeNextVersion(t, "Frobnicate"), WireTranslator{})
+	if want := "no method called Frobnicate"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("AddAPIVersion: got %v, want an error containing %q", err, want)
+	}
+}
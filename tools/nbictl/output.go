@@ -0,0 +1,475 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/urfave/cli/v2"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+	outputv1 "aalyria.com/spacetime/github/tools/nbictl/output/v1"
+)
+
+var (
+	rawEnumsFlag = &cli.BoolFlag{
+		Name:  "raw_enums",
+		Usage: "Print enum values as their integer values instead of their names.",
+	}
+	humanReadableFlag = &cli.BoolFlag{
+		Name:  "human_readable",
+		Usage: "Annotate bit rates, frequencies, and byte counts with human-readable values (e.g. `# 1.2 Gbps`). The annotations are textproto comments, so the output can still be parsed.",
+	}
+	outputFormatFlag = &cli.StringFlag{
+		Name:    "output",
+		Usage:   "Output format. Allowed values: [text, json, go-template=TEMPLATE, go-template-file=PATH]. The json output follows a versioned schema that only changes in backwards-compatible ways; run `nbictl output-schema` to print it. Templates are Go text/templates (https://pkg.go.dev/text/template) executed against the json output, with Sprig-style helpers such as date, ago, trunc, and abbrev.",
+		Aliases: []string{"o"},
+		Value:   "text",
+		Action:  validateOutputFormat,
+	}
+)
+
+func validateOutputFormat(_ *cli.Context, f string) error {
+	switch {
+	case f == "text", f == "json":
+		return nil
+	case isTemplateFormat(f):
+		_, err := parseOutputTemplate(f)
+		return err
+	default:
+		return fmt.Errorf("unknown output format %q", f)
+	}
+}
+
+// jsonOutputRequested reports whether the command should print one of the
+// documents defined in the outputv1 package instead of its text output,
+// either as JSON or through a template.
+func jsonOutputRequested(appCtx *cli.Context) bool {
+	f := appCtx.String(outputFormatFlag.Name)
+	return f == "json" || isTemplateFormat(f)
+}
+
+// outputTemplateFromFlags returns the template selected by --output, or nil
+// if it doesn't select one.
+func outputTemplateFromFlags(appCtx *cli.Context) (*template.Template, error) {
+	return parseOutputTemplate(appCtx.String(outputFormatFlag.Name))
+}
+
+func writeJSONOutput(appCtx *cli.Context, v any) error {
+	tmpl, err := outputTemplateFromFlags(appCtx)
+	if err != nil {
+		return err
+	}
+	return writeDocument(appCtx.App.Writer, tmpl, timestampFormatFromFlags(appCtx), v)
+}
+
+// writeDocument prints `v` as JSON with its timestamps written in the
+// format `ts`, or renders it with `tmpl` if it's set. Templates always see
+// RFC 3339 timestamps, which their date helpers expect.
+func writeDocument(w io.Writer, tmpl *template.Template, ts TimestampFormat, v any) error {
+	js, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if tmpl != nil {
+		return executeTemplate(w, tmpl, js)
+	}
+	if js, err = ts.formatDocument(js, v); err != nil {
+		return err
+	}
+	out := &bytes.Buffer{}
+	if err := json.Indent(out, js, "", "  "); err != nil {
+		return err
+	}
+	out.WriteByte('\n')
+	_, err = out.WriteTo(w)
+	return err
+}
+
+// OutputSchema prints the JSON schema of the documents produced with
+// `--output=json`.
+func OutputSchema(appCtx *cli.Context) error {
+	schema, err := outputv1.JSONSchema()
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(appCtx.App.Writer, string(schema))
+	return err
+}
+
+// batchRecorder collects the outcome of an operation applied to many
+// entities concurrently.
+type batchRecorder struct {
+	mu     sync.Mutex
+	result *outputv1.BatchResult
+}
+
+func newBatchRecorder(operation string) *batchRecorder {
+	return &batchRecorder{result: outputv1.NewBatchResult(operation)}
+}
+
+// record adds the outcome of the operation on an entity and returns `err`
+// unchanged.
+func (b *batchRecorder) record(entityType nbipb.EntityType, id string, err error) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	r := outputv1.EntityResult{EntityType: entityType.String(), EntityID: id}
+	if err != nil {
+		r.Error = err.Error()
+		b.result.Failed++
+	} else {
+		b.result.Succeeded++
+	}
+	b.result.Results = append(b.result.Results, r)
+	return err
+}
+
+// finish prints the collected results to `out` if `opts.JSON` is set, and
+// returns `err`, the error of the operation as a whole. The results are
+// printed even if the operation failed so scripts can tell which entities
+// were affected.
+func (b *batchRecorder) finish(opts BulkOptions, out io.Writer, err error) error {
+	if !opts.JSON {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	slices.SortFunc(b.result.Results, func(x, y outputv1.EntityResult) int {
+		return cmp.Or(cmp.Compare(x.EntityType, y.EntityType), cmp.Compare(x.EntityID, y.EntityID))
+	})
+	if werr := writeDocument(out, opts.Template, opts.Timestamps, b.result); werr != nil && err == nil {
+		err = werr
+	}
+	return err
+}
+
+// outputOptions controls how response messages are rendered.
+type outputOptions struct {
+	rawEnums      bool
+	humanReadable bool
+	// joins, if set, annotates references to other entities with fields of
+	// the referenced entities.
+	joins *joinedEntities
+}
+
+func outputOptionsFromFlags(appCtx *cli.Context) outputOptions {
+	return outputOptions{
+		rawEnums:      appCtx.Bool(rawEnumsFlag.Name),
+		humanReadable: appCtx.Bool(humanReadableFlag.Name),
+	}
+}
+
+// marshalOutput renders `m` as a multiline textproto according to the
+// command's output flags.
+func marshalOutput(appCtx *cli.Context, m proto.Message) ([]byte, error) {
+	return outputOptionsFromFlags(appCtx).marshal(m)
+}
+
+func (o outputOptions) marshal(m proto.Message) ([]byte, error) {
+	if o == (outputOptions{}) {
+		return prototext.MarshalOptions{Multiline: true}.Marshal(m)
+	}
+
+	w := &textWriter{opts: o}
+	if err := w.writeFields(m.ProtoReflect(), 0); err != nil {
+		return nil, err
+	}
+	return w.buf.Bytes(), nil
+}
+
+// textWriter writes messages in the textproto format. Unlike prototext, it
+// can render enums as numbers and annotate quantities with readable units.
+type textWriter struct {
+	opts outputOptions
+	buf  bytes.Buffer
+}
+
+func (w *textWriter) writeFields(m protoreflect.Message, indent int) error {
+	if m.Descriptor().FullName() == "google.protobuf.Any" {
+		if ok, err := w.writeExpandedAny(m, indent); ok || err != nil {
+			return err
+		}
+	}
+
+	fields := []protoreflect.FieldDescriptor{}
+	m.Range(func(fd protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+		fields = append(fields, fd)
+		return true
+	})
+	// Match prototext: regular fields in declaration order, then extensions
+	// ordered by their full name.
+	slices.SortStableFunc(fields, func(a, b protoreflect.FieldDescriptor) int {
+		switch {
+		case a.IsExtension() != b.IsExtension():
+			if a.IsExtension() {
+				return 1
+			}
+			return -1
+		case a.IsExtension():
+			return cmp.Compare(a.FullName(), b.FullName())
+		default:
+			return cmp.Compare(a.Index(), b.Index())
+		}
+	})
+
+	for _, fd := range fields {
+		v := m.Get(fd)
+		switch {
+		case fd.IsMap():
+			if err := w.writeMap(fd, v.Map(), indent); err != nil {
+				return err
+			}
+		case fd.IsList():
+			list := v.List()
+			for i := 0; i < list.Len(); i++ {
+				if err := w.writeField(fd.TextName(), fd, list.Get(i), indent); err != nil {
+					return err
+				}
+			}
+		default:
+			if err := w.writeField(fd.TextName(), fd, v, indent); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeExpandedAny writes an Any message using the `[type_url] { ... }`
+// syntax if its contents can be resolved.
+func (w *textWriter) writeExpandedAny(m protoreflect.Message, indent int) (bool, error) {
+	a, ok := m.Interface().(*anypb.Any)
+	if !ok {
+		return false, nil
+	}
+	mt, err := protoregistry.GlobalTypes.FindMessageByURL(a.GetTypeUrl())
+	if err != nil {
+		return false, nil
+	}
+	inner := mt.New()
+	if err := proto.Unmarshal(a.GetValue(), inner.Interface()); err != nil {
+		return false, nil
+	}
+	w.writeIndent(indent)
+	fmt.Fprintf(&w.buf, "[%s] {\n", a.GetTypeUrl())
+	if err := w.writeFields(inner, indent+1); err != nil {
+		return true, err
+	}
+	w.writeIndent(indent)
+	w.buf.WriteString("}\n")
+	return true, nil
+}
+
+func (w *textWriter) writeMap(fd protoreflect.FieldDescriptor, m protoreflect.Map, indent int) error {
+	keys := []protoreflect.MapKey{}
+	m.Range(func(k protoreflect.MapKey, _ protoreflect.Value) bool {
+		keys = append(keys, k)
+		return true
+	})
+	slices.SortFunc(keys, compareMapKeys)
+
+	for _, k := range keys {
+		w.writeIndent(indent)
+		fmt.Fprintf(&w.buf, "%s {\n", fd.TextName())
+		if err := w.writeField("key", fd.MapKey(), k.Value(), indent+1); err != nil {
+			return err
+		}
+		if err := w.writeField("value", fd.MapValue(), m.Get(k), indent+1); err != nil {
+			return err
+		}
+		w.writeIndent(indent)
+		w.buf.WriteString("}\n")
+	}
+	return nil
+}
+
+func (w *textWriter) writeField(name string, fd protoreflect.FieldDescriptor, v protoreflect.Value, indent int) error {
+	w.writeIndent(indent)
+	if fd.Kind() == protoreflect.MessageKind || fd.Kind() == protoreflect.GroupKind {
+		fmt.Fprintf(&w.buf, "%s {\n", name)
+		if err := w.writeFields(v.Message(), indent+1); err != nil {
+			return err
+		}
+		w.writeIndent(indent)
+		w.buf.WriteString("}\n")
+		return nil
+	}
+
+	fmt.Fprintf(&w.buf, "%s: %s", name, w.formatScalar(fd, v))
+	if w.opts.humanReadable {
+		if hr := humanReadableValue(fd, v); hr != "" {
+			fmt.Fprintf(&w.buf, "  # %s", hr)
+		}
+	}
+	if w.opts.joins != nil {
+		if a := w.opts.joins.annotation(w, fd, v); a != "" {
+			fmt.Fprintf(&w.buf, "  # %s", a)
+		}
+	}
+	w.buf.WriteByte('\n')
+	return nil
+}
+
+func (w *textWriter) formatScalar(fd protoreflect.FieldDescriptor, v protoreflect.Value) string {
+	switch fd.Kind() {
+	case protoreflect.EnumKind:
+		if !w.opts.rawEnums {
+			if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+				return string(ev.Name())
+			}
+		}
+		return strconv.FormatInt(int64(v.Enum()), 10)
+	case protoreflect.StringKind:
+		return strconv.Quote(v.String())
+	case protoreflect.BytesKind:
+		return strconv.Quote(string(v.Bytes()))
+	case protoreflect.FloatKind:
+		return formatFloat(v.Float(), 32)
+	case protoreflect.DoubleKind:
+		return formatFloat(v.Float(), 64)
+	default:
+		return v.String()
+	}
+}
+
+func (w *textWriter) writeIndent(indent int) {
+	w.buf.WriteString(strings.Repeat("  ", indent))
+}
+
+func formatFloat(f float64, bitSize int) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	default:
+		return strconv.FormatFloat(f, 'g', -1, bitSize)
+	}
+}
+
+func compareMapKeys(a, b protoreflect.MapKey) int {
+	switch av := a.Interface().(type) {
+	case bool:
+		bv := b.Bool()
+		switch {
+		case av == bv:
+			return 0
+		case !av:
+			return -1
+		default:
+			return 1
+		}
+	case int32, int64:
+		return cmp.Compare(a.Int(), b.Int())
+	case uint32, uint64:
+		return cmp.Compare(a.Uint(), b.Uint())
+	default:
+		return cmp.Compare(a.String(), b.String())
+	}
+}
+
+type unitSuffix struct {
+	suffix string
+	unit   string
+	base   float64
+	prefix []string
+}
+
+// scale returns `f` divided by the largest power of the unit's base that
+// keeps it at least 1, with the matching prefix, e.g. "1.2 Gbps". It
+// returns the empty string if the value is too small to benefit from
+// scaling.
+func (u unitSuffix) scale(f float64) string {
+	exp := 0
+	for math.Abs(f) >= u.base && exp < len(u.prefix)-1 {
+		f /= u.base
+		exp++
+	}
+	if exp == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(math.Round(f*100)/100, 'f', -1, 64) + " " + u.prefix[exp] + u.unit
+}
+
+// unitSuffixes maps field name suffixes used throughout the API to the unit
+// they're measured in.
+var unitSuffixes = []unitSuffix{
+	{"_bps", "bps", 1000, []string{"", "k", "M", "G", "T", "P", "E"}},
+	{"_hz", "Hz", 1000, []string{"", "k", "M", "G", "T", "P", "E"}},
+	{"_bytes", "B", 1024, []string{"", "Ki", "Mi", "Gi", "Ti", "Pi", "Ei"}},
+}
+
+// formatWithUnit returns `f` in the unit of the field name suffix
+// `suffix`, scaled if it's large enough, e.g. "1.2 Gbps" or "500 bps".
+func formatWithUnit(suffix string, f float64) string {
+	for _, u := range unitSuffixes {
+		if u.suffix != suffix {
+			continue
+		}
+		if s := u.scale(f); s != "" {
+			return s
+		}
+		return strconv.FormatFloat(f, 'f', -1, 64) + " " + u.unit
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// humanReadableValue returns a scaled representation of a numeric field
+// whose name indicates its unit, e.g. "1.2 Gbps" for a `data_rate_bps` of
+// 1200000000. It returns the empty string if the field has no known unit or
+// the value is too small to benefit from scaling.
+func humanReadableValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) string {
+	var f float64
+	switch fd.Kind() {
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		f = float64(v.Int())
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		f = float64(v.Uint())
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		f = v.Float()
+	default:
+		return ""
+	}
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return ""
+	}
+
+	name := string(fd.Name())
+	for _, u := range unitSuffixes {
+		if strings.HasSuffix(name, u.suffix) {
+			return u.scale(f)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,43 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*
+Package v1 defines version 1 of the machine-readable output of nbictl, which
+is produced by commands invoked with `--output=json`.
+
+Every document is a JSON object with an "apiVersion" of [APIVersion] and a
+"kind" naming one of the types in this package, so consumers can dispatch on
+the kind and detect documents they don't understand. The JSON schema for all
+kinds is available from [JSONSchema] or by running `nbictl output-schema`, and
+is checked in alongside this package as schema.json.
+
+# Compatibility
+
+Within a version, changes to the output are strictly additive:
+
+  - Fields are never renamed, removed, or changed to a different type.
+  - New fields may be added to existing kinds. Optional fields (those marked
+    `omitempty`) may be absent, and consumers must ignore fields they don't
+    recognize.
+  - New kinds may be added for new commands.
+  - The meaning of existing values is never changed.
+
+Changes that can't be made within these rules are introduced in a new version
+(e.g. v2) with its own apiVersion. nbictl keeps producing the previous version
+for at least one release after a new version is introduced.
+
+Human-readable (text) output isn't covered by these guarantees and may change
+at any time.
+*/
+package v1
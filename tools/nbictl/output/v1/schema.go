@@ -0,0 +1,165 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// SchemaID is the `$id` of the JSON schema returned by [JSONSchema].
+const SchemaID = "https://aalyria.com/spacetime/nbictl/output/v1/schema.json"
+
+// kinds are the top-level document types, keyed by the value of their "kind"
+// field.
+var kinds = map[string]reflect.Type{
+	"BatchResult":       reflect.TypeFor[BatchResult](),
+	"BenchmarkResult":   reflect.TypeFor[BenchmarkResult](),
+	"ContactWindowList": reflect.TypeFor[ContactWindowList](),
+	"CoverageReport":    reflect.TypeFor[CoverageReport](),
+	"EntityHistory":     reflect.TypeFor[EntityHistory](),
+	"FeatureList":       reflect.TypeFor[FeatureList](),
+	"KeyList":           reflect.TypeFor[KeyList](),
+	"LinkBudget":        reflect.TypeFor[LinkBudget](),
+	"ValidationReport":  reflect.TypeFor[ValidationReport](),
+}
+
+// Kinds returns the names of the top-level document kinds, in sorted order.
+func Kinds() []string {
+	return slices.Sorted(maps.Keys(kinds))
+}
+
+// JSONSchema returns a JSON schema (draft 2020-12) that describes every kind
+// of document in this version.
+func JSONSchema() ([]byte, error) {
+	g := &schemaGenerator{defs: map[string]any{}}
+	oneOf := []any{}
+	for _, kind := range Kinds() {
+		def, err := g.structSchema(kinds[kind])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", kind, err)
+		}
+		props := def["properties"].(map[string]any)
+		props["apiVersion"] = map[string]any{"const": APIVersion}
+		props["kind"] = map[string]any{"const": kind}
+		g.defs[kind] = def
+		oneOf = append(oneOf, map[string]any{"$ref": "#/$defs/" + kind})
+	}
+
+	// encoding/json sorts map keys, so the output is stable.
+	return json.MarshalIndent(map[string]any{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"$id":     SchemaID,
+		"title":   "nbictl output (" + APIVersion + ")",
+		"oneOf":   oneOf,
+		"$defs":   g.defs,
+	}, "", "  ")
+}
+
+type schemaGenerator struct {
+	defs map[string]any
+}
+
+func (g *schemaGenerator) schema(t reflect.Type) (map[string]any, error) {
+	if t == reflect.TypeFor[time.Time]() {
+		// Timestamps are RFC 3339 strings unless nbictl is run with
+		// --json_timestamps=epoch_millis.
+		return map[string]any{"anyOf": []any{
+			map[string]any{"type": "string", "format": "date-time"},
+			map[string]any{"type": "integer", "description": "Milliseconds since the Unix epoch."},
+		}}, nil
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}, nil
+	case reflect.String:
+		return map[string]any{"type": "string"}, nil
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}, nil
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}, nil
+	case reflect.Pointer:
+		return g.schema(t.Elem())
+	case reflect.Slice:
+		items, err := g.schema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"type": "array", "items": items}, nil
+	case reflect.Struct:
+		if _, ok := g.defs[t.Name()]; !ok {
+			// Reserve the name first in case the type is recursive.
+			g.defs[t.Name()] = nil
+			def, err := g.structSchema(t)
+			if err != nil {
+				return nil, err
+			}
+			g.defs[t.Name()] = def
+		}
+		return map[string]any{"$ref": "#/$defs/" + t.Name()}, nil
+	default:
+		return nil, fmt.Errorf("unsupported type %s", t)
+	}
+}
+
+func (g *schemaGenerator) structSchema(t reflect.Type) (map[string]any, error) {
+	props := map[string]any{}
+	required := []string{}
+	if err := g.addFields(t, props, &required); err != nil {
+		return nil, err
+	}
+	slices.Sort(required)
+	return map[string]any{
+		"type":       "object",
+		"properties": props,
+		"required":   required,
+	}, nil
+}
+
+func (g *schemaGenerator) addFields(t reflect.Type, props map[string]any, required *[]string) error {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		if f.Anonymous {
+			if err := g.addFields(f.Type, props, required); err != nil {
+				return err
+			}
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		s, err := g.schema(f.Type)
+		if err != nil {
+			return fmt.Errorf("field %s: %w", f.Name, err)
+		}
+		props[name] = s
+		if opts != "omitempty" {
+			*required = append(*required, name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestJSONSchema_matchesCheckedInSchema guards the compatibility guarantees:
+// any change to the types shows up as a diff to schema.json in review.
+func TestJSONSchema_matchesCheckedInSchema(t *testing.T) {
+	t.Parallel()
+
+	want, err := os.ReadFile("schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := JSONSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bytes.TrimSpace(got), bytes.TrimSpace(want)) {
+		t.Errorf("schema.json doesn't match the generated schema. Run the following command to regenerate:\n" +
+			"bazel run //tools/nbictl/cmd/nbictl -- output-schema > $PWD/tools/nbictl/output/v1/schema.json")
+	}
+}
+
+func TestConstructors_populateRequiredFields(t *testing.T) {
+	t.Parallel()
+
+	schema := struct {
+		Defs map[string]struct {
+			Required []string `json:"required"`
+		} `json:"$defs"`
+	}{}
+	data, err := JSONSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	for kind, doc := range map[string]any{
+		"BatchResult":       NewBatchResult(OperationCreate),
+		"BenchmarkResult":   NewBenchmarkResult("ListEntities"),
+		"ContactWindowList": NewContactWindowList("a", "b"),
+		"CoverageReport":    NewCoverageReport(time.Unix(0, 0), time.Unix(60, 0)),
+		"EntityHistory":     NewEntityHistory("NETWORK_NODE", "a"),
+		"FeatureList":       NewFeatureList(),
+		"KeyList":           NewKeyList(),
+		"LinkBudget":        NewLinkBudget(TransceiverRef{"a", "tx"}, TransceiverRef{"b", "rx"}),
+		"ValidationReport":  NewValidationReport(),
+	} {
+		data, err := json.Marshal(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := map[string]any{}
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatal(err)
+		}
+
+		if got["apiVersion"] != APIVersion || got["kind"] != kind {
+			t.Errorf("%s: got apiVersion %v and kind %v, want %s and %s", kind, got["apiVersion"], got["kind"], APIVersion, kind)
+		}
+		for _, field := range schema.Defs[kind].Required {
+			// Empty lists must be encoded as [] rather than null.
+			if v, ok := got[field]; !ok || v == nil {
+				t.Errorf("%s: required field %q is missing or null in %s", kind, field, data)
+			}
+		}
+	}
+
+	if want := []string{"BatchResult", "BenchmarkResult", "ContactWindowList", "CoverageReport", "EntityHistory", "FeatureList", "KeyList", "LinkBudget", "ValidationReport"}; !slices.Equal(Kinds(), want) {
+		t.Errorf("Kinds() = %v, want %v", Kinds(), want)
+	}
+}
@@ -0,0 +1,312 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import "time"
+
+// APIVersion identifies documents that conform to this version of the output
+// schema.
+const APIVersion = "nbictl.spacetime.aalyria.com/v1"
+
+// TypeMeta is embedded in every top-level output document.
+type TypeMeta struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+}
+
+func typeMeta(kind string) TypeMeta {
+	return TypeMeta{APIVersion: APIVersion, Kind: kind}
+}
+
+// Operations reported in a [BatchResult].
+const (
+	OperationCreate = "create"
+	OperationUpdate = "update"
+	OperationDelete = "delete"
+)
+
+// BatchResult reports the outcome of an operation applied to one or more
+// entities, such as `create`, `update`, or `delete`.
+type BatchResult struct {
+	TypeMeta
+	Operation string         `json:"operation"`
+	Results   []EntityResult `json:"results"`
+	Succeeded int            `json:"succeeded"`
+	Failed    int            `json:"failed"`
+}
+
+// NewBatchResult returns an empty [BatchResult] for `operation`.
+func NewBatchResult(operation string) *BatchResult {
+	return &BatchResult{TypeMeta: typeMeta("BatchResult"), Operation: operation, Results: []EntityResult{}}
+}
+
+// EntityResult is the outcome of an operation on a single entity.
+type EntityResult struct {
+	EntityType string `json:"entityType"`
+	EntityID   string `json:"entityId"`
+	// Error is the reason the operation failed. It's absent if the operation
+	// succeeded.
+	Error string `json:"error,omitempty"`
+}
+
+// ValidationReport lists the problems found by `validate`.
+type ValidationReport struct {
+	TypeMeta
+	FilesChecked    int                 `json:"filesChecked"`
+	EntitiesChecked int                 `json:"entitiesChecked"`
+	Problems        []ValidationProblem `json:"problems"`
+}
+
+// NewValidationReport returns an empty [ValidationReport].
+func NewValidationReport() *ValidationReport {
+	return &ValidationReport{TypeMeta: typeMeta("ValidationReport"), Problems: []ValidationProblem{}}
+}
+
+// ValidationProblem is a single problem found in an entity file.
+type ValidationProblem struct {
+	File string `json:"file"`
+	// Line is the 1-based line the problem was found on. It's absent if the
+	// line couldn't be determined.
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"message"`
+}
+
+// KeyList lists the certificates found by `list-keys`.
+type KeyList struct {
+	TypeMeta
+	Keys []Key `json:"keys"`
+}
+
+// NewKeyList returns an empty [KeyList].
+func NewKeyList() *KeyList {
+	return &KeyList{TypeMeta: typeMeta("KeyList"), Keys: []Key{}}
+}
+
+// Key describes a certificate and its private key.
+type Key struct {
+	Name              string    `json:"name"`
+	Subject           string    `json:"subject"`
+	NotAfter          time.Time `json:"notAfter"`
+	FingerprintSHA256 string    `json:"fingerprintSha256"`
+	HasPrivateKey     bool      `json:"hasPrivateKey"`
+}
+
+// ContactWindowList lists the contact windows predicted by
+// `preview-contacts`.
+type ContactWindowList struct {
+	TypeMeta
+	Platforms []string        `json:"platforms"`
+	Windows   []ContactWindow `json:"windows"`
+}
+
+// NewContactWindowList returns an empty [ContactWindowList] between
+// `platforms`.
+func NewContactWindowList(platforms ...string) *ContactWindowList {
+	return &ContactWindowList{TypeMeta: typeMeta("ContactWindowList"), Platforms: platforms, Windows: []ContactWindow{}}
+}
+
+// ContactWindow is an interval during which two platforms are in contact.
+type ContactWindow struct {
+	Start           time.Time `json:"start"`
+	End             time.Time `json:"end"`
+	DurationSeconds float64   `json:"durationSeconds"`
+}
+
+// CoverageReport reports the coverage of targets by platforms, as computed
+// by `coverage`.
+type CoverageReport struct {
+	TypeMeta
+	Start   time.Time        `json:"start"`
+	End     time.Time        `json:"end"`
+	Targets []CoverageTarget `json:"targets"`
+}
+
+// NewCoverageReport returns an empty [CoverageReport] over [start, end].
+func NewCoverageReport(start, end time.Time) *CoverageReport {
+	return &CoverageReport{TypeMeta: typeMeta("CoverageReport"), Start: start, End: end, Targets: []CoverageTarget{}}
+}
+
+// CoverageTarget is the coverage of a ground site, region, or platform.
+type CoverageTarget struct {
+	ID string `json:"id"`
+	// Points is the number of points the target was sampled at: 1 for sites
+	// and platforms, and the number of grid points inside regions.
+	Points int `json:"points"`
+	// CoveragePercent is the percentage of the time during which every
+	// point of the target was covered by at least one platform.
+	CoveragePercent float64           `json:"coveragePercent"`
+	Contacts        []CoverageContact `json:"contacts"`
+	// Gaps are the intervals during which at least one point of the target
+	// wasn't covered by any platform.
+	Gaps []ContactWindow `json:"gaps"`
+}
+
+// CoverageContact is an interval during which a platform covered at least
+// one point of a target.
+type CoverageContact struct {
+	PlatformID      string    `json:"platformId"`
+	Start           time.Time `json:"start"`
+	End             time.Time `json:"end"`
+	DurationSeconds float64   `json:"durationSeconds"`
+}
+
+// LinkBudget is the link budget between two transceivers estimated by
+// `link-budget`. Gains and losses are in dB, and powers in dBW.
+type LinkBudget struct {
+	TypeMeta
+	Transmitter       TransceiverRef `json:"transmitter"`
+	Target            TransceiverRef `json:"target"`
+	BandProfileID     string         `json:"bandProfileId"`
+	Time              time.Time      `json:"time"`
+	RangeM            float64        `json:"rangeM"`
+	CenterFrequencyHz float64        `json:"centerFrequencyHz"`
+	ChannelWidthHz    float64        `json:"channelWidthHz"`
+
+	TxPowerDBW          float64 `json:"txPowerDbw"`
+	TxChainGainDB       float64 `json:"txChainGainDb"`
+	TxAntennaGainDBi    float64 `json:"txAntennaGainDbi"`
+	EIRPDBW             float64 `json:"eirpDbw"`
+	FreeSpacePathLossDB float64 `json:"freeSpacePathLossDb"`
+
+	RxAntennaGainDBi        float64 `json:"rxAntennaGainDbi"`
+	SystemNoiseTemperatureK float64 `json:"systemNoiseTemperatureK"`
+	GOverTDBPerK            float64 `json:"gOverTDbPerK"`
+	ReceivedPowerDBW        float64 `json:"receivedPowerDbw"`
+	CarrierToNoiseDensityDB float64 `json:"carrierToNoiseDensityDbHz"`
+	CarrierToNoiseDB        float64 `json:"carrierToNoiseDb"`
+
+	DataRateBps float64 `json:"dataRateBps"`
+	// DataRateSource is how the data rate was determined: from the rate
+	// table of the band profile, or as the Shannon limit of the channel if
+	// the band profile has no rate table.
+	DataRateSource string `json:"dataRateSource"`
+	// ModCod is the name of the modulation and coding scheme of the step of
+	// the rate table that was used, if it has one.
+	ModCod string `json:"modCod,omitempty"`
+}
+
+// Sources of the data rate of a [LinkBudget].
+const (
+	DataRateSourceRateTable    = "rate_table"
+	DataRateSourceShannonLimit = "shannon_limit"
+)
+
+// NewLinkBudget returns an empty [LinkBudget] between `transmitter` and
+// `target`.
+func NewLinkBudget(transmitter, target TransceiverRef) *LinkBudget {
+	return &LinkBudget{TypeMeta: typeMeta("LinkBudget"), Transmitter: transmitter, Target: target}
+}
+
+// TransceiverRef identifies a transceiver model on a platform.
+type TransceiverRef struct {
+	PlatformID         string `json:"platformId"`
+	TransceiverModelID string `json:"transceiverModelId"`
+}
+
+// FeatureList lists the experimental features known to `list-features`.
+type FeatureList struct {
+	TypeMeta
+	Features []Feature `json:"features"`
+}
+
+// NewFeatureList returns an empty [FeatureList].
+func NewFeatureList() *FeatureList {
+	return &FeatureList{TypeMeta: typeMeta("FeatureList"), Features: []Feature{}}
+}
+
+// Feature describes an experimental feature.
+type Feature struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Enabled     bool   `json:"enabled"`
+}
+
+// EntityHistory lists the versions of an entity shown by `history`, oldest
+// first.
+type EntityHistory struct {
+	TypeMeta
+	EntityType string          `json:"entityType"`
+	EntityID   string          `json:"entityId"`
+	Versions   []EntityVersion `json:"versions"`
+}
+
+// NewEntityHistory returns an empty [EntityHistory] of the entity with
+// `entityType` and `id`.
+func NewEntityHistory(entityType, id string) *EntityHistory {
+	return &EntityHistory{TypeMeta: typeMeta("EntityHistory"), EntityType: entityType, EntityID: id, Versions: []EntityVersion{}}
+}
+
+// Changes reported in an [EntityVersion].
+const (
+	ChangeCreated = "created"
+	ChangeUpdated = "updated"
+	ChangeDeleted = "deleted"
+)
+
+// EntityVersion is a single version of an entity.
+type EntityVersion struct {
+	CommitTime time.Time `json:"commitTime"`
+	// CommitTimestamp is the commit_timestamp of the version, in
+	// microseconds since the Unix epoch.
+	CommitTimestamp int64 `json:"commitTimestamp"`
+	// ModifiedBy identifies who committed the version. It's absent if the
+	// server didn't record it.
+	ModifiedBy string `json:"modifiedBy,omitempty"`
+	Change     string `json:"change"`
+	// Diff is a line diff of the textproto representation of the entity
+	// against its previous version. It's absent for deletes.
+	Diff string `json:"diff,omitempty"`
+}
+
+// BenchmarkResult summarizes the calls made by `bench`.
+type BenchmarkResult struct {
+	TypeMeta
+	RPC             string  `json:"rpc"`
+	Concurrency     int     `json:"concurrency"`
+	DurationSeconds float64 `json:"durationSeconds"`
+	Calls           int     `json:"calls"`
+	Errors          int     `json:"errors"`
+	// ErrorRate is the fraction of calls that failed, between 0 and 1.
+	ErrorRate      float64 `json:"errorRate"`
+	CallsPerSecond float64 `json:"callsPerSecond"`
+	// Latency summarizes the latency of the calls that succeeded. It's
+	// absent if none did.
+	Latency *LatencySummary `json:"latency,omitempty"`
+	// ErrorCodes counts the calls that failed by their gRPC status code,
+	// most common first.
+	ErrorCodes []ErrorCount `json:"errorCodes"`
+}
+
+// NewBenchmarkResult returns an empty [BenchmarkResult] of calls to `rpc`.
+func NewBenchmarkResult(rpc string) *BenchmarkResult {
+	return &BenchmarkResult{TypeMeta: typeMeta("BenchmarkResult"), RPC: rpc, ErrorCodes: []ErrorCount{}}
+}
+
+// LatencySummary describes the distribution of a set of latencies, in
+// milliseconds.
+type LatencySummary struct {
+	MinMs  float64 `json:"minMs"`
+	MeanMs float64 `json:"meanMs"`
+	P50Ms  float64 `json:"p50Ms"`
+	P90Ms  float64 `json:"p90Ms"`
+	P99Ms  float64 `json:"p99Ms"`
+	MaxMs  float64 `json:"maxMs"`
+}
+
+// ErrorCount is the number of calls that failed with a gRPC status code.
+type ErrorCount struct {
+	Code  string `json:"code"`
+	Count int    `json:"count"`
+}
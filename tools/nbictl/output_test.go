@@ -0,0 +1,130 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+	respb "aalyria.com/spacetime/api/nbi/v1alpha/resources"
+)
+
+func testOutputEntities() *nbipb.TxtpbEntities {
+	return &nbipb.TxtpbEntities{
+		Entity: []*nbipb.Entity{
+			{
+				Group: &nbipb.EntityGroup{Type: nbipb.EntityType_NETWORK_NODE.Enum()},
+				Id:    proto.String("node-a"),
+				Value: &nbipb.Entity_NetworkNode{
+					NetworkNode: &respb.NetworkNode{
+						Name:    proto.String("Node \"A\""),
+						Storage: &respb.NetworkNode_Storage{AvailableBytes: proto.Int64(3 << 30)},
+					},
+				},
+			},
+			{
+				Group: &nbipb.EntityGroup{Type: nbipb.EntityType_INTERFACE_LINK_REPORT.Enum()},
+				Id:    proto.String("link"),
+			},
+		},
+	}
+}
+
+func TestOutputOptions_marshal(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name       string
+		opts       outputOptions
+		want       []string
+		wantAbsent []string
+	}{
+		{
+			name:       "defaults",
+			opts:       outputOptions{},
+			want:       []string{"NETWORK_NODE", "3221225472"},
+			wantAbsent: []string{"GiB"},
+		},
+		{
+			name:       "raw enums",
+			opts:       outputOptions{rawEnums: true},
+			want:       []string{"type: " + strconv.Itoa(int(nbipb.EntityType_NETWORK_NODE))},
+			wantAbsent: []string{"NETWORK_NODE", "GiB"},
+		},
+		{
+			name: "human readable",
+			opts: outputOptions{humanReadable: true},
+			want: []string{"type: NETWORK_NODE", "available_bytes: 3221225472  # 3 GiB"},
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			out, err := tc.opts.marshal(testOutputEntities())
+			checkErr(t, err)
+			for _, s := range tc.want {
+				if !strings.Contains(string(out), s) {
+					t.Errorf("expected output to contain %q, got:\n%s", s, out)
+				}
+			}
+			for _, s := range tc.wantAbsent {
+				if strings.Contains(string(out), s) {
+					t.Errorf("expected output not to contain %q, got:\n%s", s, out)
+				}
+			}
+
+			// Regardless of the options, the output must remain parseable.
+			got := &nbipb.TxtpbEntities{}
+			checkErr(t, prototext.Unmarshal(out, got))
+			if diff := cmp.Diff(testOutputEntities(), got, protocmp.Transform()); diff != "" {
+				t.Errorf("round-tripped output differs (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestHumanReadableValue(t *testing.T) {
+	t.Parallel()
+
+	fields := (&respb.WiredDevice{}).ProtoReflect().Descriptor().Fields()
+	rateField := fields.ByName("max_data_rate_bps")
+	nameField := fields.ByName("platform_id")
+
+	for _, tc := range []struct {
+		value float64
+		want  string
+	}{
+		{value: 999, want: ""},
+		{value: 1200, want: "1.2 kbps"},
+		{value: 1.2e9, want: "1.2 Gbps"},
+		{value: 1234567890, want: "1.23 Gbps"},
+	} {
+		if got := humanReadableValue(rateField, protoreflect.ValueOfFloat64(tc.value)); got != tc.want {
+			t.Errorf("humanReadableValue(%v): got %q, want %q", tc.value, got, tc.want)
+		}
+	}
+	if got := humanReadableValue(nameField, protoreflect.ValueOfFloat64(1e9)); got != "" {
+		t.Errorf("expected fields without a unit suffix to be left alone, got %q", got)
+	}
+}
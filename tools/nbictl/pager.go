@@ -0,0 +1,109 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"github.com/urfave/cli/v2"
+)
+
+// defaultPager is used when neither $NBICTL_PAGER nor $PAGER are set. The
+// flags make less exit immediately if the output fits on one screen, keep
+// colors, and leave the output on the screen once it exits.
+const defaultPager = "less -FRX"
+
+// withPager wraps a command's action so that, if stdout is a terminal, its
+// output is piped through a pager. The pager reads the output as it's
+// written, so it's displayed as soon as it's available rather than once the
+// command completes.
+func withPager(action cli.ActionFunc) cli.ActionFunc {
+	return func(appCtx *cli.Context) error {
+		pager := pagerCommand()
+		if appCtx.Bool("no_pager") || pager == "" || !isTerminal(appCtx.App.Writer) {
+			return action(appCtx)
+		}
+
+		out := appCtx.App.Writer
+		w, wait, err := startPager(pager, out, appCtx.App.ErrWriter)
+		if err != nil {
+			// Not having a pager shouldn't stop the command from working.
+			fmt.Fprintf(appCtx.App.ErrWriter, "unable to start pager %q, disable it with --no_pager: %v\n", pager, err)
+			return action(appCtx)
+		}
+		appCtx.App.Writer = &pagedWriter{WriteCloser: w, terminal: out}
+		defer func() { appCtx.App.Writer = out }()
+
+		err = action(appCtx)
+		return errors.Join(ignoreBrokenPipe(err), ignoreBrokenPipe(w.Close()), wait())
+	}
+}
+
+// pagedWriter is the input of a pager that displays the output on
+// `terminal`, so it can still be formatted for the terminal's size.
+type pagedWriter struct {
+	io.WriteCloser
+	terminal io.Writer
+}
+
+// pagerCommand returns the pager to use, or "" if output shouldn't be
+// paged.
+func pagerCommand() string {
+	for _, env := range []string{"NBICTL_PAGER", "PAGER"} {
+		if pager, ok := os.LookupEnv(env); ok {
+			if strings.TrimSpace(pager) == "cat" {
+				return ""
+			}
+			return strings.TrimSpace(pager)
+		}
+	}
+	return defaultPager
+}
+
+// startPager starts `pager`, which writes to `out`, and returns the writer
+// connected to its input. `wait` must be called after the writer is closed.
+func startPager(pager string, out, errOut io.Writer) (w io.WriteCloser, wait func() error, err error) {
+	args := strings.Fields(pager)
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout, cmd.Stderr = out, errOut
+	w, err = cmd.StdinPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, nil, err
+	}
+	return w, func() error {
+		if err := cmd.Wait(); err != nil {
+			return fmt.Errorf("pager %q: %w", pager, err)
+		}
+		return nil
+	}, nil
+}
+
+// ignoreBrokenPipe drops the error returned by writes to a pager that the
+// user already quit, since the rest of the output isn't wanted anyway.
+func ignoreBrokenPipe(err error) error {
+	if errors.Is(err, syscall.EPIPE) || errors.Is(err, os.ErrClosed) {
+		return nil
+	}
+	return err
+}
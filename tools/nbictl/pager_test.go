@@ -0,0 +1,97 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/urfave/cli/v2"
+)
+
+func TestStartPager(t *testing.T) {
+	t.Parallel()
+
+	out, errOut := &bytes.Buffer{}, &bytes.Buffer{}
+	w, wait, err := startPager("cat", out, errOut)
+	checkErr(t, err)
+	fmt.Fprintln(w, "line 1")
+	fmt.Fprintln(w, "line 2")
+	checkErr(t, w.Close())
+	checkErr(t, wait())
+
+	if got, want := out.String(), "line 1\nline 2\n"; got != want {
+		t.Fatalf("expected the pager to receive %q, got %q", want, got)
+	}
+}
+
+func TestStartPager_quitEarly(t *testing.T) {
+	t.Parallel()
+
+	w, wait, err := startPager("true", &bytes.Buffer{}, &bytes.Buffer{})
+	checkErr(t, err)
+	checkErr(t, wait())
+
+	// Writing to a pager that exited fails, but isn't worth reporting.
+	_, err = w.Write([]byte(strings.Repeat("x", 1<<20)))
+	if err == nil {
+		t.Fatal("expected writing to an exited pager to fail")
+	}
+	checkErr(t, ignoreBrokenPipe(err))
+}
+
+func TestWithPager_notATerminal(t *testing.T) {
+	t.Parallel()
+
+	app := newTestApp()
+	ran := false
+	app.Action = withPager(func(appCtx *cli.Context) error {
+		ran = true
+		if appCtx.App.Writer != app.stdout {
+			t.Error("expected output that isn't going to a terminal not to be paged")
+		}
+		return nil
+	})
+	checkErr(t, app.Run([]string{"nbictl"}))
+	if !ran {
+		t.Fatal("expected the action to run")
+	}
+}
+
+func TestPagerCommand(t *testing.T) {
+	for _, tc := range []struct {
+		nbictlPager, pager string
+		want               string
+	}{
+		{want: defaultPager},
+		{pager: "more", want: "more"},
+		{nbictlPager: "less -S", pager: "more", want: "less -S"},
+		{pager: "cat", want: ""},
+	} {
+		for env, v := range map[string]string{"NBICTL_PAGER": tc.nbictlPager, "PAGER": tc.pager} {
+			// Setenv restores the variable once the test completes.
+			t.Setenv(env, v)
+			if v == "" {
+				os.Unsetenv(env)
+			}
+		}
+		if got := pagerCommand(); got != tc.want {
+			t.Errorf("pagerCommand() with NBICTL_PAGER=%q PAGER=%q: want %q, got %q", tc.nbictlPager, tc.pager, tc.want, got)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/urfave/cli/v2"
+	"google.golang.org/protobuf/proto"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+)
+
+const (
+	// defaultMaxRequestBytes is the default limit on the size of messages
+	// received by gRPC servers, which the NBI doesn't raise.
+	defaultMaxRequestBytes = 4 << 20
+
+	// preflightWarnRatio is how close to a limit a request or entity count
+	// has to be for a warning to be printed.
+	preflightWarnRatio = 0.9
+)
+
+var (
+	maxRequestBytesFlag = &cli.IntFlag{
+		Name:  "max_request_bytes",
+		Usage: "Largest request the server accepts. Entities whose requests would be larger are rejected before any entity is sent.",
+		Value: defaultMaxRequestBytes,
+	}
+	maxEntitiesPerTypeFlag = &cli.IntFlag{
+		Name:  "max_entities_per_type",
+		Usage: "Number of entities of each type the server allows to be stored. If set, the stored entities are counted before any entity is sent, and the change is rejected if it would exceed the limit.",
+	}
+	skipPreflightFlag = &cli.BoolFlag{
+		Name:  "skip_preflight",
+		Usage: "Send the entities without checking them against --max_request_bytes and --max_entities_per_type first.",
+	}
+)
+
+// PreflightOptions are the server limits that entities are checked against
+// before any of them are sent, so that a large change fails up front rather
+// than part way through.
+type PreflightOptions struct {
+	// MaxRequestBytes is the size of the largest request the server
+	// accepts. Defaults to 4 MiB, the default gRPC limit.
+	MaxRequestBytes int
+	// MaxEntitiesPerType, if positive, is the number of entities of each
+	// type the server allows to be stored. Checking it requires listing the
+	// IDs of the stored entities of every type being changed.
+	MaxEntitiesPerType int
+	// Skip disables the checks.
+	Skip bool
+}
+
+func preflightOptionsFromFlags(appCtx *cli.Context) PreflightOptions {
+	return PreflightOptions{
+		MaxRequestBytes:    appCtx.Int(maxRequestBytesFlag.Name),
+		MaxEntitiesPerType: appCtx.Int(maxEntitiesPerTypeFlag.Name),
+		Skip:               appCtx.Bool(skipPreflightFlag.Name),
+	}
+}
+
+// preflight checks that none of the requests to create or update `entities`
+// exceed the server's limits, and warns about those that come close. It
+// returns an error describing every violation.
+func preflight(ctx context.Context, client nbipb.NetOpsClient, opts PreflightOptions, entities []*nbipb.Entity, log io.Writer) error {
+	if opts.Skip {
+		return nil
+	}
+	maxBytes := opts.MaxRequestBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxRequestBytes
+	}
+
+	errs := []error{}
+	for _, e := range entities {
+		if err := checkRequestSize(e, maxBytes, log); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if opts.MaxEntitiesPerType > 0 {
+		countErrs, err := checkEntityCounts(ctx, client, opts.MaxEntitiesPerType, entities, log)
+		if err != nil {
+			return err
+		}
+		errs = append(errs, countErrs...)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("pre-flight checks failed, no entities were sent:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// bulkPreflight checks `entities` against the limits of `opts` before any
+// of them is sent, or, if `streaming`, returns the check to run on each
+// entity as it's read (see streamPreflight). Otherwise, the returned check
+// always succeeds.
+func bulkPreflight(ctx context.Context, client nbipb.NetOpsClient, opts PreflightOptions, entities []*nbipb.Entity, streaming bool, log io.Writer) (func(*nbipb.Entity) error, error) {
+	if streaming {
+		return streamPreflight(opts, log)
+	}
+	if err := preflight(ctx, client, opts, entities, log); err != nil {
+		return nil, err
+	}
+	return func(*nbipb.Entity) error { return nil }, nil
+}
+
+// checkRequestSize returns an error if the request to create or update `e`
+// is larger than `maxBytes`, and warns if it comes close.
+func checkRequestSize(e *nbipb.Entity, maxBytes int, log io.Writer) error {
+	// Update requests are a superset of create requests, so their size
+	// bounds both.
+	size := proto.Size(&nbipb.UpdateEntityRequest{Entity: e, IgnoreConsistencyCheck: proto.Bool(true)})
+	switch {
+	case size > maxBytes:
+		return fmt.Errorf("entity %s/%s is %d bytes, over the %d byte request limit", e.GetGroup().GetType(), e.GetId(), size, maxBytes)
+	case float64(size) > preflightWarnRatio*float64(maxBytes):
+		fmt.Fprintf(log, "warning: entity %s/%s is %d bytes, close to the %d byte request limit\n", e.GetGroup().GetType(), e.GetId(), size, maxBytes)
+	}
+	return nil
+}
+
+// streamPreflight returns the check to run on each entity before it's sent,
+// when entities are processed as they're read and so can't all be checked
+// up front. Only the request size can be checked this way.
+func streamPreflight(opts PreflightOptions, log io.Writer) (func(*nbipb.Entity) error, error) {
+	switch {
+	case opts.Skip:
+		return func(*nbipb.Entity) error { return nil }, nil
+	case opts.MaxEntitiesPerType > 0:
+		return nil, errors.New("the number of entities per type can't be checked when entities are processed as they're read")
+	}
+	maxBytes := opts.MaxRequestBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxRequestBytes
+	}
+	return func(e *nbipb.Entity) error { return checkRequestSize(e, maxBytes, log) }, nil
+}
+
+// checkEntityCounts returns an error for each entity type that would have
+// more than `limit` entities stored once `entities` are created.
+func checkEntityCounts(ctx context.Context, client nbipb.NetOpsClient, limit int, entities []*nbipb.Entity, log io.Writer) ([]error, error) {
+	idsByType := map[nbipb.EntityType]map[string]bool{}
+	for _, e := range entities {
+		t := e.GetGroup().GetType()
+		if idsByType[t] == nil {
+			idsByType[t] = map[string]bool{}
+		}
+		idsByType[t][e.GetId()] = true
+	}
+	types := make([]nbipb.EntityType, 0, len(idsByType))
+	for t := range idsByType {
+		types = append(types, t)
+	}
+	slices.Sort(types)
+
+	errs := []error{}
+	for _, t := range types {
+		ids := idsByType[t]
+		res, err := client.ListEntities(ctx, &nbipb.ListEntitiesRequest{
+			Type:   t.Enum(),
+			Filter: &nbipb.EntityFilter{FieldMasks: []string{"id"}},
+		})
+		if isUnimplemented(err) {
+			// Not every server supports filters, and only the IDs are
+			// needed, so fall back to listing the whole entities.
+			res, err = client.ListEntities(ctx, &nbipb.ListEntitiesRequest{Type: t.Enum()})
+		}
+		if err != nil {
+			return nil, fmt.Errorf("counting the stored %s entities: %w", t, err)
+		}
+		total := len(ids)
+		for _, stored := range res.GetEntities() {
+			if !ids[stored.GetId()] {
+				total++
+			}
+		}
+		switch {
+		case total > limit:
+			errs = append(errs, fmt.Errorf("%d %s entities would be stored, over the limit of %d", total, t, limit))
+		case float64(total) > preflightWarnRatio*float64(limit):
+			fmt.Fprintf(log, "warning: %d %s entities will be stored, close to the limit of %d\n", total, t, limit)
+		}
+	}
+	return errs, nil
+}
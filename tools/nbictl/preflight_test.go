@@ -0,0 +1,131 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+)
+
+func TestCreateEntities_preflightRequestSize(t *testing.T) {
+	t.Parallel()
+
+	srv, client := startNBITestServer(t)
+	entities := []*nbipb.Entity{
+		testNetworkNode("small", "small"),
+		testNetworkNode("large", strings.Repeat("x", 2048)),
+	}
+	streams := IOStreams{Out: &bytes.Buffer{}, ErrOut: &bytes.Buffer{}}
+	opts := CreateOptions{Entities: entities, Bulk: BulkOptions{Preflight: PreflightOptions{MaxRequestBytes: 1024}}}
+
+	err := CreateEntities(context.Background(), client, opts, streams)
+	if err == nil || !strings.Contains(err.Error(), "entity NETWORK_NODE/large is") {
+		t.Fatalf("CreateEntities() = %v, want an error about the large entity", err)
+	}
+	// Neither entity is sent, even though the small one is within the limit.
+	if _, ok := srv.Entity(nbipb.EntityType_NETWORK_NODE, "small"); ok {
+		t.Errorf("the small entity was created despite the pre-flight checks failing")
+	}
+
+	opts.Bulk.Preflight.Skip = true
+	checkErr(t, CreateEntities(context.Background(), client, opts, streams))
+}
+
+func TestCreateEntities_preflightStream(t *testing.T) {
+	t.Parallel()
+
+	srv, client := startNBITestServer(t)
+	entities := []*nbipb.Entity{
+		testNetworkNode("small", "small"),
+		testNetworkNode("large", strings.Repeat("x", 2048)),
+	}
+	next := func() (*nbipb.Entity, error) {
+		if len(entities) == 0 {
+			return nil, io.EOF
+		}
+		e := entities[0]
+		entities = entities[1:]
+		return e, nil
+	}
+	streams := IOStreams{Out: &bytes.Buffer{}, ErrOut: &bytes.Buffer{}}
+	opts := CreateOptions{Source: next, Bulk: BulkOptions{Preflight: PreflightOptions{MaxRequestBytes: 1024}}}
+
+	// Entities that are read as they're created are checked one at a time,
+	// so only the large one fails.
+	err := CreateEntities(context.Background(), client, opts, streams)
+	if err == nil || !strings.Contains(err.Error(), "entity NETWORK_NODE/large is") {
+		t.Fatalf("CreateEntities() = %v, want an error about the large entity", err)
+	}
+	if _, ok := srv.Entity(nbipb.EntityType_NETWORK_NODE, "small"); !ok {
+		t.Errorf("the small entity wasn't created")
+	}
+
+	opts.Bulk.Preflight.MaxEntitiesPerType = 10
+	if err := CreateEntities(context.Background(), client, opts, streams); err == nil {
+		t.Errorf("CreateEntities() with MaxEntitiesPerType succeeded, want an error since entity counts can't be checked")
+	}
+}
+
+func TestPreflight_entityCounts(t *testing.T) {
+	t.Parallel()
+
+	srv, client := startNBITestServer(t)
+	srv.Put(testNetworkNode("a", "a"), testNetworkNode("b", "b"), testNetworkNode("c", "c"))
+
+	for _, tc := range []struct {
+		name     string
+		ids      []string
+		wantErr  string
+		wantWarn string
+	}{
+		{
+			name: "updates don't count",
+			ids:  []string{"a", "b"},
+		},
+		{
+			name:     "close to the limit",
+			ids:      []string{"a", "d"},
+			wantWarn: "warning: 4 NETWORK_NODE entities will be stored, close to the limit of 4",
+		},
+		{
+			name:    "over the limit",
+			ids:     []string{"d", "e"},
+			wantErr: "5 NETWORK_NODE entities would be stored, over the limit of 4",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			entities := []*nbipb.Entity{}
+			for _, id := range tc.ids {
+				entities = append(entities, testNetworkNode(id, id))
+			}
+			log := &bytes.Buffer{}
+			err := preflight(context.Background(), client, PreflightOptions{MaxEntitiesPerType: 4}, entities, log)
+			switch {
+			case tc.wantErr == "" && err != nil:
+				t.Fatalf("preflight() = %v, want no error", err)
+			case tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+				t.Fatalf("preflight() = %v, want an error containing %q", err, tc.wantErr)
+			}
+			if got := strings.TrimSpace(log.String()); got != tc.wantWarn {
+				t.Errorf("preflight() logged %q, want %q", got, tc.wantWarn)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	cpuProfileFileName  = "cpu.pprof"
+	heapProfileFileName = "heap.pprof"
+)
+
+// profileOutFlag is added to commands that can be expensive enough to
+// warrant profiling, such as those that process many files or entities.
+var profileOutFlag = &cli.StringFlag{
+	Name:  "profile_out",
+	Usage: fmt.Sprintf("Directory to write CPU (%s) and heap (%s) profiles of the command to, in the format read by `go tool pprof`.", cpuProfileFileName, heapProfileFileName),
+}
+
+// withProfiling wraps a command's action so that, if the `--profile_out`
+// flag is set, a CPU profile is recorded for the duration of the action and
+// a heap profile is written once it completes.
+func withProfiling(action cli.ActionFunc) cli.ActionFunc {
+	return func(appCtx *cli.Context) (err error) {
+		outDir := appCtx.String(profileOutFlag.Name)
+		if outDir == "" {
+			return action(appCtx)
+		}
+
+		if err := os.MkdirAll(outDir, 0o777); err != nil {
+			return fmt.Errorf("unable to create profile directory: %w", err)
+		}
+		cpuFile, err := os.Create(filepath.Join(outDir, cpuProfileFileName))
+		if err != nil {
+			return fmt.Errorf("unable to create CPU profile: %w", err)
+		}
+		if err := pprof.StartCPUProfile(cpuFile); err != nil {
+			cpuFile.Close()
+			return fmt.Errorf("unable to start CPU profile: %w", err)
+		}
+
+		defer func() {
+			pprof.StopCPUProfile()
+			err = errors.Join(err, cpuFile.Close(), writeHeapProfile(filepath.Join(outDir, heapProfileFileName)))
+			if err == nil {
+				fmt.Fprintf(appCtx.App.ErrWriter, "profiles written to %s\n", outDir)
+			}
+		}()
+
+		return action(appCtx)
+	}
+}
+
+func writeHeapProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("unable to create heap profile: %w", err)
+	}
+	// Get up-to-date statistics, otherwise the profile only reflects the
+	// state as of the last garbage collection.
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		f.Close()
+		return fmt.Errorf("unable to write heap profile: %w", err)
+	}
+	return f.Close()
+}
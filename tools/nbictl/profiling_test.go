@@ -0,0 +1,60 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/rules_go/go/tools/bazel"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestList_writesProfiles(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := bazel.NewTmpDir("nbictl")
+	checkErr(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 90*time.Second)
+	g, ctx := errgroup.WithContext(ctx)
+	defer func() { checkErr(t, g.Wait()) }()
+	defer cancel()
+	srv := startInsecureServer(ctx, t, g)
+
+	checkErr(t, newTestApp().Run([]string{
+		"nbictl", "--config_dir", tmpDir,
+		"set-config",
+		"--transport_security", "insecure",
+		"--url", srv.listener.Addr().String(),
+	}))
+
+	profileDir := filepath.Join(tmpDir, "profiles")
+	checkErr(t, newTestApp().Run([]string{
+		"nbictl", "--config_dir", tmpDir,
+		"list", "--type", "NETWORK_NODE", "--profile_out", profileDir,
+	}))
+
+	for _, name := range []string{cpuProfileFileName, heapProfileFileName} {
+		fi, err := os.Stat(filepath.Join(profileDir, name))
+		checkErr(t, err)
+		if fi.Size() == 0 {
+			t.Errorf("expected %s to be non-empty", name)
+		}
+	}
+}
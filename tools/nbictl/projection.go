@@ -0,0 +1,128 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+)
+
+var fieldsFlag = &cli.StringSliceFlag{
+	Name:  "fields",
+	Usage: "Comma-separated paths of the only fields to return and print, e.g. `platform.name,platform.type`, which avoids transferring large fields such as motion data. The entity's ID, type, and commit timestamps are always included. Paths have the same format as the aalyria.spacetime.api.nbi.v1alpha.EntityFilter.field_masks.",
+}
+
+// alwaysProjectedFields are the fields of an Entity that are kept by every
+// projection, like the NBI does when applying field masks.
+var alwaysProjectedFields = []string{"id", "group.type", "commit_timestamp", "next_commit_timestamp"}
+
+// fieldProjection is a tree of the fields to keep in a message. A nil
+// projection keeps the whole message.
+type fieldProjection map[protoreflect.Name]fieldProjection
+
+// parseFieldProjection checks that each of `paths` names a field of an
+// Entity, in the format of EntityFilter.field_masks, and returns the
+// projection that keeps them along with the alwaysProjectedFields.
+func parseFieldProjection(paths []string) (fieldProjection, error) {
+	root := fieldProjection{}
+	for _, path := range append(slices.Clip(paths), alwaysProjectedFields...) {
+		names, err := resolveFieldPath(path)
+		if err != nil {
+			return nil, err
+		}
+		node := root
+		for _, name := range names[:len(names)-1] {
+			child, ok := node[name]
+			if ok && child == nil {
+				// An earlier path keeps the whole field.
+				node = nil
+				break
+			}
+			if !ok {
+				child = fieldProjection{}
+				node[name] = child
+			}
+			node = child
+		}
+		if node != nil {
+			node[names[len(names)-1]] = nil
+		}
+	}
+	return root, nil
+}
+
+// resolveFieldPath returns the names of the fields along `path`, starting
+// from an Entity.
+func resolveFieldPath(path string) ([]protoreflect.Name, error) {
+	desc := (&nbipb.Entity{}).ProtoReflect().Descriptor()
+	names := []protoreflect.Name{}
+	for i, name := range strings.Split(path, ".") {
+		if i > 0 {
+			switch fd := desc.Fields().ByName(names[i-1]); {
+			case fd.IsMap():
+				return nil, fmt.Errorf("invalid field path %q: map fields can only be selected as a whole", path)
+			case fd.Message() == nil:
+				return nil, fmt.Errorf("invalid field path %q: %s isn't a message", path, fd.FullName())
+			default:
+				desc = fd.Message()
+			}
+		}
+		fd := desc.Fields().ByName(protoreflect.Name(name))
+		if fd == nil {
+			return nil, fmt.Errorf("invalid field path %q: %s has no field %q", path, desc.FullName(), name)
+		}
+		names = append(names, fd.Name())
+	}
+	return names, nil
+}
+
+// apply clears the fields of `m` that aren't part of the projection.
+func (p fieldProjection) apply(m protoreflect.Message) {
+	if p == nil {
+		return
+	}
+	cleared := []protoreflect.FieldDescriptor{}
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		child, ok := p[fd.Name()]
+		switch {
+		case !ok:
+			cleared = append(cleared, fd)
+		case child == nil:
+		case fd.IsList():
+			for i, list := 0, v.List(); i < list.Len(); i++ {
+				child.apply(list.Get(i).Message())
+			}
+		default:
+			child.apply(v.Message())
+		}
+		return true
+	})
+	for _, fd := range cleared {
+		m.Clear(fd)
+	}
+}
+
+// project applies the projection to each of `entities`.
+func (p fieldProjection) project(entities []*nbipb.Entity) {
+	for _, e := range entities {
+		p.apply(e.ProtoReflect())
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+)
+
+const projectionTestPlatform = `entity {
+  group { type: PLATFORM_DEFINITION }
+  id: "sat"
+  commit_timestamp: 123
+  platform {
+    name: "Satellite"
+    type: "LEO"
+    coordinates { geodetic_wgs84 { latitude_deg: 1 longitude_deg: 2 } }
+  }
+}
+`
+
+// getOnlyNetOpsClient serves GetEntity with a fixed entity.
+type getOnlyNetOpsClient struct {
+	nbipb.NetOpsClient
+
+	entity *nbipb.Entity
+}
+
+func (c *getOnlyNetOpsClient) GetEntity(context.Context, *nbipb.GetEntityRequest, ...grpc.CallOption) (*nbipb.Entity, error) {
+	return c.entity, nil
+}
+
+func TestFieldProjection(t *testing.T) {
+	t.Parallel()
+
+	entities := parseTestEntities(t, projectionTestPlatform)
+	proj, err := parseFieldProjection([]string{"platform.name", "platform.coordinates.geodetic_wgs84.latitude_deg"})
+	checkErr(t, err)
+	proj.project(entities)
+
+	want := parseTestEntities(t, `entity {
+  group { type: PLATFORM_DEFINITION }
+  id: "sat"
+  commit_timestamp: 123
+  platform {
+    name: "Satellite"
+    coordinates { geodetic_wgs84 { latitude_deg: 1 } }
+  }
+}`)
+	if diff := cmp.Diff(want, entities, protocmp.Transform()); diff != "" {
+		t.Errorf("unexpected projection (-want +got):\n%s", diff)
+	}
+}
+
+func TestFieldProjection_wholeFieldWins(t *testing.T) {
+	t.Parallel()
+
+	entities := parseTestEntities(t, projectionTestPlatform)
+	proj, err := parseFieldProjection([]string{"platform", "platform.name"})
+	checkErr(t, err)
+	proj.project(entities)
+
+	if diff := cmp.Diff(parseTestEntities(t, projectionTestPlatform), entities, protocmp.Transform()); diff != "" {
+		t.Errorf("unexpected projection (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseFieldProjection_rejectsInvalidPaths(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct{ path, want string }{
+		{"platform.nope", `has no field "nope"`},
+		{"id.value", "isn't a message"},
+		{"", `has no field ""`},
+	} {
+		switch _, err := parseFieldProjection([]string{tc.path}); {
+		case err == nil:
+			t.Errorf("parseFieldProjection(%q): expected an error, got nil", tc.path)
+		case !strings.Contains(err.Error(), tc.want):
+			t.Errorf("parseFieldProjection(%q): expected error to contain %q, got %q", tc.path, tc.want, err.Error())
+		}
+	}
+}
+
+func TestGetEntity_fields(t *testing.T) {
+	t.Parallel()
+
+	client := &getOnlyNetOpsClient{entity: parseTestEntities(t, projectionTestPlatform)[0]}
+	streams, stdout, _ := newTestStreams()
+	opts := GetOptions{Type: nbipb.EntityType_PLATFORM_DEFINITION, ID: "sat", Fields: []string{"platform.name"}}
+	checkErr(t, GetEntity(context.Background(), client, opts, streams))
+
+	// The whole entity is returned, so the fields are projected locally.
+	if out := stdout.String(); !strings.Contains(out, "Satellite") || strings.Contains(out, "LEO") || strings.Contains(out, "latitude_deg") {
+		t.Errorf("expected only the platform's name to be printed, got:\n%s", out)
+	}
+}
+
+func TestListEntities_fields(t *testing.T) {
+	t.Parallel()
+
+	client := &listOnlyNetOpsClient{entities: parseTestEntities(t, joinTestPlatforms)}
+	streams, _, _ := newTestStreams()
+	opts := ListOptions{Type: nbipb.EntityType_PLATFORM_DEFINITION, Fields: []string{"platform.name"}}
+	checkErr(t, ListEntities(context.Background(), client, opts, streams))
+
+	// "id" keeps entities without a name in the listing.
+	want := []*nbipb.ListEntitiesRequest{{
+		Type:   nbipb.EntityType_PLATFORM_DEFINITION.Enum(),
+		Filter: &nbipb.EntityFilter{FieldMasks: []string{"platform.name", "id"}},
+	}}
+	if diff := cmp.Diff(want, client.requests, protocmp.Transform()); diff != "" {
+		t.Errorf("unexpected requests (-want +got):\n%s", diff)
+	}
+
+	opts.FieldMasks = []string{"platform.type"}
+	if err := ListEntities(context.Background(), client, opts, streams); err == nil {
+		t.Error("expected combining fields with field masks to cause an error, got nil")
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"google.golang.org/grpc"
+
+	"aalyria.com/spacetime/github/tools/nbictl/nbictlpb"
+)
+
+var readOnlyFlag = &cli.BoolFlag{
+	Name:    "read_only",
+	Usage:   "Refuse to call any method that may modify state on the server, such as CreateEntity or DeleteEntity, before it's sent. Useful for monitoring scripts and dashboards that reuse powerful credentials. Contexts with read_only set always behave this way.",
+	EnvVars: []string{"NBICTL_READ_ONLY"},
+}
+
+// errReadOnly is returned, wrapped, for the calls refused in read-only mode.
+var errReadOnly = errors.New("refused to call a method that may modify state in read-only mode")
+
+// readOnlyServices are the services all of whose methods are known not to
+// modify state.
+var readOnlyServices = []string{
+	"aalyria.spacetime.api.nbi.v1alpha.SignalPropagation",
+	"grpc.health.v1.Health",
+	"grpc.reflection.v1.ServerReflection",
+	"grpc.reflection.v1alpha.ServerReflection",
+}
+
+// readOnlyMethodPrefixes are the prefixes of the names of methods that, by
+// the conventions of the Spacetime APIs, don't modify state.
+var readOnlyMethodPrefixes = []string{"Get", "List", "Watch", "Describe", "VersionInfo"}
+
+// isReadOnlyMethod reports whether the method with the full name `method`,
+// such as "/aalyria.spacetime.api.nbi.v1alpha.NetOps/GetEntity", is known
+// not to modify state. Methods that aren't known to be safe, including
+// those of services nbictl doesn't know, are assumed to modify state.
+func isReadOnlyMethod(method string) bool {
+	service, name, ok := strings.Cut(strings.TrimPrefix(method, "/"), "/")
+	if !ok {
+		return false
+	}
+	for _, s := range readOnlyServices {
+		if service == s {
+			return true
+		}
+	}
+	for _, p := range readOnlyMethodPrefixes {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// readOnlyRequested reports whether calls that may modify state must be
+// refused, because of the --read_only flag or the read_only setting of
+// `setting`, which may be nil.
+func readOnlyRequested(appCtx *cli.Context, setting *nbictlpb.Config) bool {
+	return appCtx.Bool(readOnlyFlag.Name) || setting.GetReadOnly()
+}
+
+// readOnlyDialOpts returns the dial options that enforce read-only mode, if
+// it's requested. They must come before the rpclog interceptors, so that
+// refused calls aren't logged as if they had been sent.
+func readOnlyDialOpts(appCtx *cli.Context, setting *nbictlpb.Config) []grpc.DialOption {
+	if !readOnlyRequested(appCtx, setting) {
+		return nil
+	}
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(readOnlyUnaryInterceptor),
+		grpc.WithChainStreamInterceptor(readOnlyStreamInterceptor),
+	}
+}
+
+func readOnlyUnaryInterceptor(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if !isReadOnlyMethod(method) {
+		return fmt.Errorf("%s: %w", method, errReadOnly)
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+func readOnlyStreamInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	if !isReadOnlyMethod(method) {
+		return nil, fmt.Errorf("%s: %w", method, errReadOnly)
+	}
+	return streamer(ctx, desc, cc, method, opts...)
+}
@@ -0,0 +1,115 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/rules_go/go/tools/bazel"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestIsReadOnlyMethod(t *testing.T) {
+	t.Parallel()
+
+	for method, want := range map[string]bool{
+		"/aalyria.spacetime.api.nbi.v1alpha.NetOps/GetEntity":                 true,
+		"/aalyria.spacetime.api.nbi.v1alpha.NetOps/ListEntities":              true,
+		"/aalyria.spacetime.api.nbi.v1alpha.NetOps/ListEntitiesOverTime":      true,
+		"/aalyria.spacetime.api.nbi.v1alpha.NetOps/VersionInfo":               true,
+		"/aalyria.spacetime.api.nbi.v1alpha.SignalPropagation/Evaluate":       true,
+		"/grpc.reflection.v1.ServerReflection/ServerReflectionInfo":           true,
+		"/aalyria.spacetime.api.nbi.v1alpha.NetOps/CreateEntity":              false,
+		"/aalyria.spacetime.api.nbi.v1alpha.NetOps/UpdateEntity":              false,
+		"/aalyria.spacetime.api.nbi.v1alpha.NetOps/DeleteEntity":              false,
+		"/aalyria.spacetime.api.agentops.v1alpha.AgentOps/RestartBackend":     false,
+		"/aalyria.spacetime.api.agentops.v1alpha.AgentOps/CaptureDiagnostics": false,
+		"/example.Unknown/Frobnicate":                                         false,
+		"malformed":                                                           false,
+	} {
+		if got := isReadOnlyMethod(method); got != want {
+			t.Errorf("isReadOnlyMethod(%q) = %v, want %v", method, got, want)
+		}
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := bazel.NewTmpDir("nbictl")
+	checkErr(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	g, ctx := errgroup.WithContext(ctx)
+	defer func() { checkErr(t, g.Wait()) }()
+	defer cancel()
+	srv := startInsecureServer(ctx, t, g)
+
+	setConfig := func(args ...string) {
+		t.Helper()
+		checkErr(t, newTestApp().Run(append([]string{
+			"nbictl", "--config_dir", tmpDir,
+			"set-config",
+			"--transport_security", "insecure",
+			"--url", srv.listener.Addr().String(),
+		}, args...)))
+	}
+	run := func(globalArgs ...string) error {
+		return newTestApp().Run(append(append([]string{"nbictl", "--config_dir", tmpDir}, globalArgs...),
+			"delete", "--type", "NETWORK_NODE", "--id", "abc", "--ignore_consistency_check"))
+	}
+	deleted := func() bool {
+		srv.mu.Lock()
+		defer srv.mu.Unlock()
+		_, ok := srv.EntityIDsModified["abc"]
+		delete(srv.EntityIDsModified, "abc")
+		return ok
+	}
+	isRefused := func(err error) bool {
+		return err != nil && strings.Contains(err.Error(), errReadOnly.Error())
+	}
+
+	setConfig()
+	if err := run("--read_only"); !isRefused(err) {
+		t.Errorf("delete with --read_only got error %v, want it to be refused", err)
+	}
+	if deleted() {
+		t.Errorf("delete with --read_only reached the server")
+	}
+	checkErr(t, newTestApp().Run([]string{"nbictl", "--config_dir", tmpDir, "--read_only", "list", "--type", "NETWORK_NODE"}))
+
+	setConfig("--read_only")
+	if err := run(); !isRefused(err) {
+		t.Errorf("delete with a read-only context got error %v, want it to be refused", err)
+	}
+	if deleted() {
+		t.Errorf("delete with a read-only context reached the server")
+	}
+
+	// Updating other settings keeps the context read-only.
+	setConfig("--default_timeout", "1m")
+	if err := run(); !isRefused(err) {
+		t.Errorf("delete with a read-only context got error %v after updating it, want it to be refused", err)
+	}
+
+	setConfig("--read_only=false")
+	checkErr(t, run())
+	if !deleted() {
+		t.Errorf("delete after lifting read-only mode didn't reach the server")
+	}
+}
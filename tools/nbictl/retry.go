@@ -0,0 +1,113 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/urfave/cli/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"aalyria.com/spacetime/callctx"
+)
+
+const (
+	defaultRetries = 3
+	// retryBackoff is how long to wait before the first retry of a call. It
+	// doubles with each retry, up to retryMaxBackoff.
+	retryBackoff    = 250 * time.Millisecond
+	retryMaxBackoff = 5 * time.Second
+)
+
+var (
+	retriesFlag = &cli.IntFlag{
+		Name:    "retries",
+		Usage:   "Number of times to retry a call that fails because the server is unavailable, with an exponential backoff. Only calls that don't modify state are retried, unless --retry_mutations is set. Use 0 to never retry.",
+		Value:   defaultRetries,
+		EnvVars: []string{"NBICTL_RETRIES"},
+		Action: func(_ *cli.Context, n int) error {
+			if n < 0 {
+				return fmt.Errorf("--retries can't be negative, got %d", n)
+			}
+			return nil
+		},
+	}
+	retryMutationsFlag = &cli.BoolFlag{
+		Name:    "retry_mutations",
+		Usage:   "Also retry calls that may modify state, such as CreateEntity. Every attempt is sent with the same request ID (the spacetime-request-id metadata), but only servers that recognize it avoid applying a retry twice, e.g. creating a duplicate entity when the response to the first attempt was lost.",
+		EnvVars: []string{"NBICTL_RETRY_MUTATIONS"},
+	}
+)
+
+// retryDialOpts returns the dial options that retry failed calls as
+// requested by the --retries and --retry_mutations flags. They must come
+// before the call context and rpclog interceptors, so that each attempt is
+// sent with the request ID and logged.
+func retryDialOpts(appCtx *cli.Context) []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(retryUnaryInterceptor(appCtx.Int(retriesFlag.Name), appCtx.Bool(retryMutationsFlag.Name), retryBackoff)),
+	}
+}
+
+// retryUnaryInterceptor returns an interceptor that retries calls that fail
+// with UNAVAILABLE up to `retries` times, waiting `backoff` before the first
+// retry and twice as long before each of the next ones. Calls that may
+// modify state (see isReadOnlyMethod) are given a request ID, if they don't
+// have one yet, that's sent with every attempt, and are only retried if
+// `retryMutations` is set: a server that doesn't recognize the request ID
+// would apply a retried change twice.
+//
+// Streams aren't retried, since the messages already exchanged can't be
+// replayed safely.
+func retryUnaryInterceptor(retries int, retryMutations bool, backoff time.Duration) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		readOnly := isReadOnlyMethod(method)
+		if !readOnly && callctx.RequestIDFromContext(ctx) == "" {
+			ctx = callctx.WithRequestID(ctx, uuid.NewString())
+		}
+		maxRetries := retries
+		if !readOnly && !retryMutations {
+			maxRetries = 0
+		}
+		log := moduleLogger(ctx, logModuleConnection)
+
+		delay := backoff
+		for attempt := 0; ; attempt++ {
+			err := invoker(ctx, method, req, reply, cc, opts...)
+			if status.Code(err) != codes.Unavailable || attempt >= maxRetries {
+				return err
+			}
+
+			// Jitter the delay by up to 50% either way, so that many
+			// clients cut off at once don't retry in lockstep.
+			wait := delay/2 + rand.N(delay+1)
+			log.Warn("retrying call", "method", method, "attempt", attempt+1, "backoff", wait, "error", err, "request_id", callctx.RequestIDFromContext(ctx))
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			case <-timer.C:
+			}
+			delay = min(2*delay, retryMaxBackoff)
+		}
+	}
+}
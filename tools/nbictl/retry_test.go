@@ -0,0 +1,163 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"aalyria.com/spacetime/callctx"
+)
+
+// fakeInvoker returns the errors in `errs` in turn, then succeeds, recording
+// the request ID of each attempt.
+func fakeInvoker(errs ...error) (grpc.UnaryInvoker, *[]string) {
+	ids := &[]string{}
+	return func(ctx context.Context, _ string, _, _ any, _ *grpc.ClientConn, _ ...grpc.CallOption) error {
+		*ids = append(*ids, callctx.RequestIDFromContext(ctx))
+		if len(*ids) <= len(errs) {
+			return errs[len(*ids)-1]
+		}
+		return nil
+	}, ids
+}
+
+func TestRetryUnaryInterceptor(t *testing.T) {
+	t.Parallel()
+
+	const (
+		createEntity = "/aalyria.spacetime.api.nbi.v1alpha.NetOps/CreateEntity"
+		deleteEntity = "/aalyria.spacetime.api.nbi.v1alpha.NetOps/DeleteEntity"
+	)
+	unavailable := status.Error(codes.Unavailable, "connection reset")
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		name           string
+		method         string
+		retries        int
+		retryMutations bool
+		errs           []error
+		wantCode       codes.Code
+		wantAttempts   int
+		wantIDs        bool
+	}{
+		{
+			name:           "mutation retried with the same request ID",
+			method:         createEntity,
+			retries:        3,
+			retryMutations: true,
+			errs:           []error{unavailable, unavailable},
+			wantCode:       codes.OK,
+			wantAttempts:   3,
+			wantIDs:        true,
+		},
+		{
+			name:         "mutations aren't retried by default",
+			method:       createEntity,
+			retries:      3,
+			errs:         []error{unavailable},
+			wantCode:     codes.Unavailable,
+			wantAttempts: 1,
+			wantIDs:      true,
+		},
+		{
+			name:           "gives up after the retries",
+			method:         createEntity,
+			retries:        2,
+			retryMutations: true,
+			errs:           []error{unavailable, unavailable, unavailable, unavailable},
+			wantCode:       codes.Unavailable,
+			wantAttempts:   3,
+			wantIDs:        true,
+		},
+		{
+			name:           "other errors aren't retried",
+			method:         createEntity,
+			retries:        3,
+			retryMutations: true,
+			errs:           []error{status.Error(codes.AlreadyExists, "exists")},
+			wantCode:       codes.AlreadyExists,
+			wantAttempts:   1,
+			wantIDs:        true,
+		},
+		{
+			name:           "no retries",
+			method:         createEntity,
+			retries:        0,
+			retryMutations: true,
+			errs:           []error{unavailable},
+			wantCode:       codes.Unavailable,
+			wantAttempts:   1,
+			wantIDs:        true,
+		},
+		{
+			name:         "reads are retried without a request ID",
+			method:       "/aalyria.spacetime.api.nbi.v1alpha.NetOps/GetEntity",
+			retries:      3,
+			errs:         []error{unavailable},
+			wantCode:     codes.OK,
+			wantAttempts: 2,
+		},
+		{
+			// The first attempt may or may not have reached the server, so
+			// the error isn't hidden.
+			name:           "deleting a missing entity after a retry",
+			method:         deleteEntity,
+			retries:        3,
+			retryMutations: true,
+			errs:           []error{unavailable, status.Error(codes.NotFound, "no such entity")},
+			wantCode:       codes.NotFound,
+			wantAttempts:   2,
+			wantIDs:        true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			invoker, ids := fakeInvoker(tc.errs...)
+			err := retryUnaryInterceptor(tc.retries, tc.retryMutations, 0)(ctx, tc.method, nil, nil, nil, invoker)
+			if got := status.Code(err); got != tc.wantCode {
+				t.Errorf("got %v, want code %v", err, tc.wantCode)
+			}
+			if len(*ids) != tc.wantAttempts {
+				t.Fatalf("got %d attempts, want %d", len(*ids), tc.wantAttempts)
+			}
+			for _, id := range *ids {
+				switch {
+				case !tc.wantIDs && id != "":
+					t.Errorf("attempt sent with request ID %q, want none", id)
+				case tc.wantIDs && (id == "" || id != (*ids)[0]):
+					t.Errorf("attempts sent with request IDs %q, want the same one for each", *ids)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryUnaryInterceptor_keepsRequestID(t *testing.T) {
+	t.Parallel()
+
+	invoker, ids := fakeInvoker()
+	ctx := callctx.WithRequestID(context.Background(), "req-1")
+	checkErr(t, retryUnaryInterceptor(0, false, 0)(ctx, "/aalyria.spacetime.api.nbi.v1alpha.NetOps/DeleteEntity", nil, nil, nil, invoker))
+	if (*ids)[0] != "req-1" {
+		t.Errorf("call sent with request ID %q, want %q", (*ids)[0], "req-1")
+	}
+}
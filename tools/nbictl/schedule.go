@@ -0,0 +1,298 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+	"aalyria.com/spacetime/github/tools/nbictl/nbictlpb"
+)
+
+const scheduleFileName = "schedule.textproto"
+
+var scheduleAtFlag = &cli.TimestampFlag{
+	Name:   "at",
+	Layout: time.RFC3339,
+	Usage:  "An RFC3339 formatted timestamp at which to apply the change instead of applying it now. The entities are read when the change is scheduled, and it's applied by the first run of schedule run after that time, e.g. from cron.",
+}
+
+// scheduleMutation queues `m` to be applied at the time of the --at flag by
+// `schedule run`, in the context of this invocation. It must only be called
+// if the flag is set.
+func scheduleMutation(appCtx *cli.Context, m *nbictlpb.ScheduledMutation) error {
+	if err := checkLocalStateWritable(appCtx); err != nil {
+		return err
+	}
+	at := *appCtx.Timestamp(scheduleAtFlag.Name)
+	if !at.After(time.Now()) {
+		return fmt.Errorf("--at must be in the future, got %s", at.Format(time.RFC3339))
+	}
+
+	confDir, err := getAppConfDir(appCtx)
+	if err != nil {
+		return err
+	}
+	// The context is resolved now, so the mutation is applied to the same
+	// instance even if contexts are added before it runs.
+	setting, err := readConfig(appCtx.String("context"), filepath.Join(confDir, confFileName))
+	if err != nil {
+		return fmt.Errorf("unable to obtain context information: %w", err)
+	}
+	id, err := newScheduledMutationID()
+	if err != nil {
+		return err
+	}
+	m.Id = id
+	m.Context = setting.GetName()
+	m.RunAt = timestamppb.New(at)
+	m.ScheduledAt = timestamppb.Now()
+	m.State = nbictlpb.ScheduledMutation_PENDING
+
+	if err := updateSchedule(filepath.Join(confDir, scheduleFileName), func(s *nbictlpb.Schedule) error {
+		s.Mutations = append(s.Mutations, m)
+		return nil
+	}); err != nil {
+		return err
+	}
+	fmt.Fprintf(appCtx.App.ErrWriter, "scheduled the %s of %d entities as %s, to be applied at %s by `%s schedule run`\n",
+		operationName(m.GetOperation()), len(m.GetEntities()), m.GetId(), at.Format(time.RFC3339), appName)
+	return nil
+}
+
+func newScheduledMutationID() (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating the ID of the scheduled mutation: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func operationName(op nbictlpb.ScheduledMutation_Operation) string {
+	switch op {
+	case nbictlpb.ScheduledMutation_CREATE:
+		return "creation"
+	case nbictlpb.ScheduledMutation_UPDATE:
+		return "update"
+	case nbictlpb.ScheduledMutation_DELETE:
+		return "deletion"
+	default:
+		return op.String()
+	}
+}
+
+func scheduleFileForContext(appCtx *cli.Context) (string, error) {
+	confDir, err := getAppConfDir(appCtx)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(confDir, scheduleFileName), nil
+}
+
+func readSchedule(path string) (*nbictlpb.Schedule, error) {
+	s := &nbictlpb.Schedule{}
+	data, err := os.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		return s, nil
+	case err != nil:
+		return nil, fmt.Errorf("unable to read the schedule: %w", err)
+	}
+	if err := prototext.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("invalid schedule %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// updateSchedule applies `fn` to the schedule stored at `path` while holding
+// its lock, so concurrent runners don't claim the same mutations.
+func updateSchedule(path string, fn func(*nbictlpb.Schedule) error) error {
+	return withFileLock(path, func() error {
+		s, err := readSchedule(path)
+		if err != nil {
+			return err
+		}
+		if err := fn(s); err != nil {
+			return err
+		}
+		data, err := prototext.MarshalOptions{Multiline: true}.Marshal(s)
+		if err != nil {
+			return err
+		}
+		return writeFileAtomic(path, data, 0o600)
+	})
+}
+
+func ListScheduled(appCtx *cli.Context) error {
+	path, err := scheduleFileForContext(appCtx)
+	if err != nil {
+		return err
+	}
+	s, err := readSchedule(path)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(appCtx.App.Writer, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tOPERATION\tENTITIES\tCONTEXT\tRUN_AT\tSTATE\tERROR")
+	for _, m := range s.GetMutations() {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\t%s\n", m.GetId(), m.GetOperation(), len(m.GetEntities()), m.GetContext(),
+			m.GetRunAt().AsTime().Local().Format(time.RFC3339), m.GetState(), m.GetError())
+	}
+	return w.Flush()
+}
+
+func CancelScheduled(appCtx *cli.Context) error {
+	if err := checkLocalStateWritable(appCtx); err != nil {
+		return err
+	}
+	ids := appCtx.Args().Slice()
+	if len(ids) == 0 {
+		return errors.New("expected the IDs of the scheduled mutations to cancel")
+	}
+	path, err := scheduleFileForContext(appCtx)
+	if err != nil {
+		return err
+	}
+	if err := updateSchedule(path, func(s *nbictlpb.Schedule) error {
+		for _, id := range ids {
+			i := slices.IndexFunc(s.GetMutations(), func(m *nbictlpb.ScheduledMutation) bool { return m.GetId() == id })
+			if i < 0 {
+				return fmt.Errorf("no scheduled mutation with ID %q", id)
+			}
+			s.Mutations = slices.Delete(s.Mutations, i, i+1)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	fmt.Fprintf(appCtx.App.ErrWriter, "canceled %d scheduled mutations\n", len(ids))
+	return nil
+}
+
+// RunScheduled applies the pending mutations that are due. With --wait, it
+// keeps running until there are no pending mutations left, waiting for each
+// of them to be due.
+func RunScheduled(appCtx *cli.Context) error {
+	if err := checkLocalStateWritable(appCtx); err != nil {
+		return err
+	}
+	path, err := scheduleFileForContext(appCtx)
+	if err != nil {
+		return err
+	}
+
+	errs := []error{}
+	for {
+		next, err := runDueMutations(appCtx, path, time.Now())
+		errs = append(errs, err)
+		if !appCtx.Bool("wait") || next.IsZero() {
+			return errors.Join(errs...)
+		}
+		fmt.Fprintf(appCtx.App.ErrWriter, "waiting until %s for the next scheduled mutation\n", next.Local().Format(time.RFC3339))
+		select {
+		case <-appCtx.Context.Done():
+			return errors.Join(append(errs, appCtx.Context.Err())...)
+		case <-time.After(time.Until(next)):
+		}
+	}
+}
+
+// runDueMutations claims and applies the pending mutations whose time is at
+// or before `now`, removing those that succeed and marking the others as
+// failed. It returns when the earliest of the remaining pending mutations is
+// due, or the zero time if there are none.
+func runDueMutations(appCtx *cli.Context, path string, now time.Time) (next time.Time, _ error) {
+	due := []*nbictlpb.ScheduledMutation{}
+	if err := updateSchedule(path, func(s *nbictlpb.Schedule) error {
+		for _, m := range s.GetMutations() {
+			switch {
+			case m.GetState() != nbictlpb.ScheduledMutation_PENDING:
+			case !m.GetRunAt().AsTime().After(now):
+				m.State = nbictlpb.ScheduledMutation_RUNNING
+				due = append(due, proto.Clone(m).(*nbictlpb.ScheduledMutation))
+			case next.IsZero() || m.GetRunAt().AsTime().Before(next):
+				next = m.GetRunAt().AsTime()
+			}
+		}
+		return nil
+	}); err != nil {
+		return time.Time{}, err
+	}
+
+	errs := []error{}
+	for _, m := range due {
+		fmt.Fprintf(appCtx.App.ErrWriter, "applying scheduled mutation %s: the %s of %d entities in context %q\n",
+			m.GetId(), operationName(m.GetOperation()), len(m.GetEntities()), m.GetContext())
+		runErr := runScheduledMutation(appCtx, m)
+		if runErr != nil {
+			errs = append(errs, fmt.Errorf("scheduled mutation %s: %w", m.GetId(), runErr))
+		}
+		if err := updateSchedule(path, func(s *nbictlpb.Schedule) error {
+			i := slices.IndexFunc(s.GetMutations(), func(other *nbictlpb.ScheduledMutation) bool { return other.GetId() == m.GetId() })
+			switch {
+			case i < 0:
+				// Canceled while it was running.
+			case runErr == nil:
+				s.Mutations = slices.Delete(s.Mutations, i, i+1)
+			default:
+				s.Mutations[i].State = nbictlpb.ScheduledMutation_FAILED
+				s.Mutations[i].Error = runErr.Error()
+			}
+			return nil
+		}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return next, errors.Join(errs...)
+}
+
+func runScheduledMutation(appCtx *cli.Context, m *nbictlpb.ScheduledMutation) error {
+	if err := appCtx.Set("context", m.GetContext()); err != nil {
+		return err
+	}
+	conn, err := openServiceConnection(appCtx, nbipb.NetOps_ServiceDesc.ServiceName)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	client := nbipb.NewNetOpsClient(conn)
+	bulk := BulkOptions{Concurrency: int(m.GetConcurrency()), QPS: m.GetQps()}
+	streams := ioStreamsFromContext(appCtx)
+	switch m.GetOperation() {
+	case nbictlpb.ScheduledMutation_CREATE:
+		return CreateEntities(appCtx.Context, client, CreateOptions{Entities: m.GetEntities(), Bulk: bulk}, streams)
+	case nbictlpb.ScheduledMutation_UPDATE:
+		return UpdateEntities(appCtx.Context, client, UpdateOptions{Entities: m.GetEntities(), Force: m.GetIgnoreConsistencyCheck(), Bulk: bulk}, streams)
+	case nbictlpb.ScheduledMutation_DELETE:
+		return DeleteEntities(appCtx.Context, client, DeleteOptions{Entities: m.GetEntities(), IgnoreConsistencyCheck: m.GetIgnoreConsistencyCheck(), Bulk: bulk}, streams)
+	default:
+		return fmt.Errorf("unknown operation %s", m.GetOperation())
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/rules_go/go/tools/bazel"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"aalyria.com/spacetime/github/tools/nbictl/nbictlpb"
+)
+
+func TestSchedule(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	g, ctx := errgroup.WithContext(ctx)
+	defer func() { checkErr(t, g.Wait()) }()
+	defer cancel()
+
+	tmpDir, err := bazel.NewTmpDir("nbictl")
+	checkErr(t, err)
+	srv := startInsecureServer(ctx, t, g)
+	keys := generateKeysForTesting(t, tmpDir, "--org", "example org")
+	checkErr(t, newTestApp().Run([]string{
+		"nbictl", "--config_dir", tmpDir,
+		"set-config",
+		"--transport_security", "insecure",
+		"--user_id", "usr1",
+		"--key_id", "key1",
+		"--priv_key", keys.key,
+		"--url", srv.listener.Addr().String(),
+	}))
+
+	entitiesDir, err := bazel.NewTmpDir("entities")
+	checkErr(t, err)
+	checkErr(t, writeEntitiesToFiles(buildTestEntities(3, 1), entitiesDir))
+
+	run := func(args ...string) (testApp, error) {
+		app := newTestApp()
+		return app, app.RunContext(ctx, append([]string{"nbictl", "--config_dir", tmpDir}, args...))
+	}
+	schedulePath := filepath.Join(tmpDir, scheduleFileName)
+	backdate := func() {
+		checkErr(t, updateSchedule(schedulePath, func(s *nbictlpb.Schedule) error {
+			for _, m := range s.GetMutations() {
+				m.RunAt = timestamppb.New(time.Now().Add(-time.Minute))
+			}
+			return nil
+		}))
+	}
+
+	if _, err := run("update", "--files", entitiesDir+"/*", "--at", time.Now().Add(-time.Hour).Format(time.RFC3339)); err == nil || !strings.Contains(err.Error(), "--at must be in the future") {
+		t.Fatalf("scheduling an update in the past: got error %v, want one about --at", err)
+	}
+
+	at := time.Now().Add(time.Hour).Format(time.RFC3339)
+	_, err = run("update", "--files", entitiesDir+"/*", "--at", at)
+	checkErr(t, err)
+	_, err = run("delete", "--type", "NETWORK_NODE", "--id", "abc", "--ignore_consistency_check", "--at", at)
+	checkErr(t, err)
+
+	app, err := run("schedule", "list")
+	checkErr(t, err)
+	lines := strings.Split(strings.TrimSpace(app.stdout.String()), "\n")
+	if len(lines) != 3 || strings.Fields(lines[1])[1] != "UPDATE" || strings.Fields(lines[2])[1] != "DELETE" || !strings.Contains(lines[2], "PENDING") {
+		t.Fatalf("schedule list printed:\n%s\nwant a pending update and deletion", app.stdout.String())
+	}
+
+	// Nothing is due yet.
+	_, err = run("schedule", "run")
+	checkErr(t, err)
+	if len(srv.EntityIDsModified) != 0 {
+		t.Fatalf("schedule run applied mutations before they were due: %v", srv.EntityIDsModified)
+	}
+
+	backdate()
+	_, err = run("schedule", "run")
+	checkErr(t, err)
+	if len(srv.EntityIDsModified) != 4 {
+		t.Errorf("schedule run modified %v, want the 3 updated entities and the deleted one", srv.EntityIDsModified)
+	}
+	s, err := readSchedule(schedulePath)
+	checkErr(t, err)
+	if len(s.GetMutations()) != 0 {
+		t.Errorf("applied mutations weren't removed from the schedule: %v", s)
+	}
+}
+
+func TestSchedule_failedMutationsAreKept(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	schedulePath := filepath.Join(tmpDir, scheduleFileName)
+	checkErr(t, updateSchedule(schedulePath, func(s *nbictlpb.Schedule) error {
+		s.Mutations = append(s.Mutations, &nbictlpb.ScheduledMutation{
+			Id:      "0badf00d",
+			Context: "missing",
+			RunAt:   timestamppb.New(time.Now().Add(-time.Minute)),
+			State:   nbictlpb.ScheduledMutation_PENDING,
+		})
+		return nil
+	}))
+
+	app := newTestApp()
+	err := app.Run([]string{"nbictl", "--config_dir", tmpDir, "schedule", "run"})
+	if err == nil || !strings.Contains(err.Error(), "scheduled mutation 0badf00d") {
+		t.Fatalf("schedule run: got error %v, want one about the mutation", err)
+	}
+	s, err := readSchedule(schedulePath)
+	checkErr(t, err)
+	if got := s.GetMutations()[0]; got.GetState() != nbictlpb.ScheduledMutation_FAILED || got.GetError() == "" {
+		t.Errorf("the mutation should be marked as failed with its error, got %v", got)
+	}
+
+	// Failed mutations aren't retried.
+	checkErr(t, newTestApp().Run([]string{"nbictl", "--config_dir", tmpDir, "schedule", "run"}))
+
+	checkErr(t, newTestApp().Run([]string{"nbictl", "--config_dir", tmpDir, "schedule", "cancel", "0badf00d"}))
+	s, err = readSchedule(schedulePath)
+	checkErr(t, err)
+	if len(s.GetMutations()) != 0 {
+		t.Errorf("schedule cancel didn't remove the mutation: %v", s)
+	}
+}
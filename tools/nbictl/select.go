@@ -0,0 +1,110 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+)
+
+// compileEntityFilter compiles `expr`, a CEL expression that selects the
+// entities for which it's true.
+func compileEntityFilter(expr string) (cel.Program, error) {
+	env, err := newEntityExprEnv()
+	if err != nil {
+		return nil, err
+	}
+	ast, iss := env.Compile(expr)
+	if iss.Err() != nil {
+		return nil, fmt.Errorf("invalid filter: %w", iss.Err())
+	}
+	if ast.OutputType() != cel.BoolType {
+		return nil, fmt.Errorf("invalid filter: the expression must evaluate to a bool, not %s", ast.OutputType())
+	}
+	prg, err := env.Program(ast)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter: %w", err)
+	}
+	return prg, nil
+}
+
+// selectEntities returns the stored entities of type `typ` with one of `ids`,
+// if any are provided, for which `filter` is true, if it's set. It fails if
+// any of `ids` doesn't exist, so a typo doesn't go unnoticed.
+func selectEntities(ctx context.Context, client nbipb.NetOpsClient, typ nbipb.EntityType, ids []string, filter cel.Program) ([]*nbipb.Entity, error) {
+	res, err := client.ListEntities(ctx, &nbipb.ListEntitiesRequest{Type: typ.Enum()})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list entities: %w", err)
+	}
+
+	found := map[string]bool{}
+	selected := []*nbipb.Entity{}
+	for _, e := range res.GetEntities() {
+		if len(ids) > 0 && !slices.Contains(ids, e.GetId()) {
+			continue
+		}
+		found[e.GetId()] = true
+		if filter != nil {
+			vars, err := cel.ContextProtoVars(e)
+			if err != nil {
+				return nil, err
+			}
+			val, _, err := filter.Eval(vars)
+			if err != nil {
+				return nil, fmt.Errorf("evaluating the filter for entity %s/%s: %w", typ, e.GetId(), err)
+			}
+			if ok, _ := val.Value().(bool); !ok {
+				continue
+			}
+		}
+		selected = append(selected, e)
+	}
+
+	missing := []string{}
+	for _, id := range ids {
+		if !found[id] {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("no %s entities with IDs: %s", typ, strings.Join(missing, ", "))
+	}
+	return selected, nil
+}
+
+// confirm asks `question` on ErrOut and reports whether the answer read from
+// In is yes. Anything else, including the end of the input, is a no.
+func confirm(streams IOStreams, question string) (bool, error) {
+	fmt.Fprintf(streams.ErrOut, "%s [y/N]: ", question)
+	answer, err := bufio.NewReader(streams.In).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, err
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
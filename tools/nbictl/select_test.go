@@ -0,0 +1,202 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/rules_go/go/tools/bazel"
+	"github.com/google/cel-go/cel"
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/sync/errgroup"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+)
+
+func TestSelectEntities(t *testing.T) {
+	t.Parallel()
+
+	srv, client := startNBITestServer(t)
+	srv.Put(testNetworkNode("a", "test-a"), testNetworkNode("b", "test-b"), testNetworkNode("c", "prod-c"))
+
+	for _, tc := range []struct {
+		name    string
+		ids     []string
+		filter  string
+		want    []string
+		wantErr string
+	}{
+		{name: "ids", ids: []string{"c", "a"}, want: []string{"a", "c"}},
+		{name: "filter", filter: `network_node.name.startsWith("test-")`, want: []string{"a", "b"}},
+		{name: "ids and filter", ids: []string{"a", "c"}, filter: `network_node.name.startsWith("test-")`, want: []string{"a"}},
+		{name: "nothing matches", filter: `network_node.name == "staging"`, want: []string{}},
+		{name: "missing ids", ids: []string{"a", "x", "y"}, wantErr: "no NETWORK_NODE entities with IDs: x, y"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var filter cel.Program
+			if tc.filter != "" {
+				prg, err := compileEntityFilter(tc.filter)
+				checkErr(t, err)
+				filter = prg
+			}
+			entities, err := selectEntities(context.Background(), client, nbipb.EntityType_NETWORK_NODE, tc.ids, filter)
+			switch {
+			case tc.wantErr != "":
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("selectEntities() = %v, want error containing %q", err, tc.wantErr)
+				}
+				return
+			case err != nil:
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, e := range entities {
+				got = append(got, e.GetId())
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("selected entities mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCompileEntityFilter_errors(t *testing.T) {
+	t.Parallel()
+
+	for _, expr := range []string{"network_node.nmae == 'a'", "network_node.name", "id =="} {
+		if _, err := compileEntityFilter(expr); err == nil {
+			t.Errorf("compileEntityFilter(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestConfirm(t *testing.T) {
+	t.Parallel()
+
+	for in, want := range map[string]bool{"y\n": true, " YES \n": true, "yes": true, "n\n": false, "\n": false, "": false, "sure\n": false} {
+		streams, _, stderr := newTestStreams()
+		streams.In = strings.NewReader(in)
+		got, err := confirm(streams, "delete?")
+		checkErr(t, err)
+		if got != want {
+			t.Errorf("confirm() with input %q = %t, want %t", in, got, want)
+		}
+		if stderr.String() != "delete? [y/N]: " {
+			t.Errorf("confirm() asked %q", stderr.String())
+		}
+	}
+}
+
+func TestDelete_selection(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name        string
+		args        []string
+		stdin       string
+		wantErr     string
+		wantDeleted []string
+		wantStdout  string
+	}{
+		{
+			name:        "confirmed",
+			args:        []string{"--filter", `network_node.name.startsWith("test-")`},
+			stdin:       "y\n",
+			wantDeleted: []string{"a", "b"},
+		},
+		{
+			name:    "declined",
+			args:    []string{"--ids", "a,c"},
+			stdin:   "n\n",
+			wantErr: "deletion canceled",
+		},
+		{
+			name:        "yes",
+			args:        []string{"--ids", "a,c", "--yes"},
+			wantDeleted: []string{"a", "c"},
+		},
+		{
+			name:       "dry run",
+			args:       []string{"--ids", "c", "--dry_run"},
+			wantStdout: "NETWORK_NODE  c",
+		},
+		{
+			name:    "can't be combined with --id",
+			args:    []string{"--id", "a", "--filter", "true"},
+			wantErr: `the "ids", "filter" and "selector" flags can't be combined with the "id" or "files" flags.`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			g, ctx := errgroup.WithContext(ctx)
+			defer func() { checkErr(t, g.Wait()) }()
+			defer cancel()
+
+			tmpDir, err := bazel.NewTmpDir("nbictl")
+			checkErr(t, err)
+			srv := startInsecureServer(ctx, t, g)
+			srv.ListEntityResponse = &nbipb.ListEntitiesResponse{Entities: []*nbipb.Entity{
+				testNetworkNode("a", "test-a"), testNetworkNode("b", "test-b"), testNetworkNode("c", "prod-c"),
+			}}
+			keys := generateKeysForTesting(t, tmpDir, "--org", "example org")
+			checkErr(t, newTestApp().Run([]string{
+				"nbictl", "--config_dir", tmpDir,
+				"set-config",
+				"--transport_security", "insecure",
+				"--user_id", "usr1",
+				"--key_id", "key1",
+				"--priv_key", keys.key,
+				"--url", srv.listener.Addr().String(),
+			}))
+
+			app := newTestApp()
+			app.Reader = strings.NewReader(tc.stdin)
+			err = app.Run(append([]string{"nbictl", "--config_dir", tmpDir, "--context", "DEFAULT", "delete", "--type", "NETWORK_NODE"}, tc.args...))
+			switch {
+			case tc.wantErr != "":
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("delete: got error %v, want one containing %q", err, tc.wantErr)
+				}
+			case err != nil:
+				t.Fatal(err)
+			}
+
+			srv.mu.Lock()
+			defer srv.mu.Unlock()
+			deleted := []string{}
+			for id := range srv.EntityIDsModified {
+				deleted = append(deleted, id)
+			}
+			sort.Strings(deleted)
+			if tc.wantDeleted == nil {
+				tc.wantDeleted = []string{}
+			}
+			if diff := cmp.Diff(tc.wantDeleted, deleted); diff != "" {
+				t.Errorf("deleted entities mismatch (-want +got):\n%s", diff)
+			}
+			if !strings.Contains(app.stdout.String(), tc.wantStdout) {
+				t.Errorf("delete printed %q, want it to contain %q", app.stdout.String(), tc.wantStdout)
+			}
+		})
+	}
+}
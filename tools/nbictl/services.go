@@ -0,0 +1,83 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/jhump/protoreflect/grpcreflect"
+	"github.com/urfave/cli/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errServiceUnavailable indicates that the connected deployment doesn't
+// expose the API service a command relies on. Deployments enable different
+// subsets of the Spacetime APIs, so this isn't necessarily a misconfiguration.
+var errServiceUnavailable = errors.New("service is not available on this deployment")
+
+// openServiceConnection opens a connection like [openConnection] and then
+// checks that the server exposes the named gRPC service, so that commands can
+// fail with a clear message before doing any work instead of partway through.
+func openServiceConnection(appCtx *cli.Context, service string) (clientConn, error) {
+	conn, err := openConnection(appCtx)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkServiceAvailable(appCtx.Context, conn, service); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// checkServiceAvailable uses server reflection to determine whether the
+// server exposes the named service. Servers that don't support reflection
+// are assumed to expose it; calls to missing methods are still reported
+// clearly by [unimplementedInterceptor].
+func checkServiceAvailable(ctx context.Context, conn grpc.ClientConnInterface, service string) error {
+	refClient := grpcreflect.NewClientAuto(ctx, conn)
+	defer refClient.Reset()
+
+	svcs, err := refClient.ListServices()
+	if err != nil {
+		return nil
+	}
+	if !slices.Contains(svcs, service) {
+		return fmt.Errorf("%s: %w", service, errServiceUnavailable)
+	}
+	return nil
+}
+
+// unimplementedInterceptor annotates UNIMPLEMENTED errors with the method
+// that was called, since the status message on its own rarely makes it clear
+// that the server simply doesn't offer that part of the API.
+func unimplementedInterceptor(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	if status.Code(err) == codes.Unimplemented {
+		return fmt.Errorf("%s: %w (%w)", method, errServiceUnavailable, err)
+	}
+	return err
+}
+
+// isUnimplemented reports whether `err` is the error of a call to a method
+// the server doesn't offer.
+func isUnimplemented(err error) bool {
+	return errors.Is(err, errServiceUnavailable) || status.Code(err) == codes.Unimplemented
+}
@@ -0,0 +1,96 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/rules_go/go/tools/bazel"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+)
+
+func TestGetLinkBudget_failsFastWhenServiceIsMissing(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := bazel.NewTmpDir("nbictl")
+	checkErr(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 90*time.Second)
+	g, ctx := errgroup.WithContext(ctx)
+	defer func() { checkErr(t, g.Wait()) }()
+	defer cancel()
+	// The fake server only exposes the NetOps service.
+	srv := startInsecureServer(ctx, t, g)
+
+	checkErr(t, newTestApp().Run([]string{
+		"nbictl", "--config_dir", tmpDir,
+		"set-config",
+		"--transport_security", "insecure",
+		"--url", srv.listener.Addr().String(),
+	}))
+
+	err = newTestApp().Run([]string{
+		"nbictl", "--config_dir", tmpDir,
+		"get-link-budget",
+		"--tx_platform_id", "tx",
+		"--tx_transceiver_model_id", "model",
+		"--band_profile_id", "band",
+	})
+	if !errors.Is(err, errServiceUnavailable) {
+		t.Fatalf("expected get-link-budget to fail with %v, got %v", errServiceUnavailable, err)
+	}
+}
+
+func TestUnimplementedInterceptor(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	g, ctx := errgroup.WithContext(ctx)
+	defer func() { checkErr(t, g.Wait()) }()
+	defer cancel()
+
+	// A server without reflection or any registered services.
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	checkErr(t, err)
+	server := grpc.NewServer()
+	g.Go(func() error { return server.Serve(lis) })
+	g.Go(func() error {
+		<-ctx.Done()
+		server.Stop()
+		return nil
+	})
+
+	conn, err := grpc.NewClient(lis.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithChainUnaryInterceptor(unimplementedInterceptor))
+	checkErr(t, err)
+	defer conn.Close()
+
+	// Servers without reflection are assumed to expose every service.
+	checkErr(t, checkServiceAvailable(ctx, conn, nbipb.NetOps_ServiceDesc.ServiceName))
+
+	_, err = nbipb.NewNetOpsClient(conn).VersionInfo(ctx, &nbipb.VersionInfoRequest{})
+	if !errors.Is(err, errServiceUnavailable) {
+		t.Fatalf("expected VersionInfo to fail with %v, got %v", errServiceUnavailable, err)
+	}
+}
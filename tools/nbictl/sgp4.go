@@ -0,0 +1,390 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// This file implements the near-Earth portion of the SGP4 orbit propagator,
+// following "Revisiting Spacetrack Report #3" (Vallado et al., AIAA
+// 2006-6753) with the WGS-72 constants TLEs are generated against. It's
+// intended for quick, local previews only; the server remains the source of
+// truth for accesses.
+
+const (
+	sgp4EarthRadiusKm = 6378.135
+	sgp4Mu            = 398600.8 // km^3/s^2
+	sgp4J2            = 0.001082616
+	sgp4J3            = -0.00000253881
+	sgp4J4            = -0.00000165597
+	sgp4J3OverJ2      = sgp4J3 / sgp4J2
+	twoPi             = 2 * math.Pi
+	minutesPerDay     = 1440.0
+)
+
+// sgp4Xke is sqrt(mu) in units of Earth radii^1.5 per minute.
+var sgp4Xke = 60 / math.Sqrt(sgp4EarthRadiusKm*sgp4EarthRadiusKm*sgp4EarthRadiusKm/sgp4Mu)
+
+// sgp4Propagator holds the initialized SGP4 state for a single TLE.
+type sgp4Propagator struct {
+	epoch time.Time
+
+	// Mean elements at epoch.
+	bstar, ecco, inclo, argpo, nodeo, mo, no float64
+
+	isimp                                      bool
+	aycof, con41, cc1, cc4, cc5, d2, d3, d4    float64
+	delmo, eta, argpdot, omgcof, sinmao        float64
+	t2cof, t3cof, t4cof, t5cof, x1mth2, x7thm1 float64
+	mdot, nodedot, xlcof, xmcof, nodecf        float64
+}
+
+// parseTLE parses and initializes a propagator from a two-line element set.
+func parseTLE(line1, line2 string) (*sgp4Propagator, error) {
+	line1, line2 = strings.TrimRight(line1, " \r\n"), strings.TrimRight(line2, " \r\n")
+	for i, l := range []string{line1, line2} {
+		switch {
+		case len(l) != 69:
+			return nil, fmt.Errorf("TLE line %d must be 69 characters long, got %d", i+1, len(l))
+		case l[0] != byte('1'+i):
+			return nil, fmt.Errorf("TLE line %d must start with %q", i+1, rune('1'+i))
+		case tleChecksum(l) != int(l[68]-'0'):
+			return nil, fmt.Errorf("TLE line %d has an invalid checksum", i+1)
+		}
+	}
+
+	errs := []error{}
+	float := func(s string) float64 {
+		f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		return f
+	}
+
+	epochYear := int(float(line1[18:20]))
+	epochDay := float(line1[20:32])
+	bstar := parseTLEExponent(line1[53:61], &errs)
+	incl := float(line2[8:16])
+	raan := float(line2[17:25])
+	ecc := float("0." + strings.TrimSpace(line2[26:33]))
+	argp := float(line2[34:42])
+	meanAnomaly := float(line2[43:51])
+	meanMotion := float(line2[52:63])
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("parsing TLE: %w", errors.Join(errs...))
+	}
+
+	// Two-digit years from 57 onwards are in the 20th century.
+	if epochYear < 57 {
+		epochYear += 2000
+	} else {
+		epochYear += 1900
+	}
+	epoch := time.Date(epochYear, time.January, 1, 0, 0, 0, 0, time.UTC).
+		Add(time.Duration((epochDay - 1) * float64(24*time.Hour)))
+
+	deg := math.Pi / 180
+	return newSGP4Propagator(epoch, bstar, ecc, incl*deg, argp*deg, raan*deg, meanAnomaly*deg, meanMotion*twoPi/minutesPerDay)
+}
+
+// tleChecksum returns the modulo-10 checksum of the first 68 characters of a
+// TLE line, where digits count as their value and minus signs count as 1.
+func tleChecksum(line string) int {
+	sum := 0
+	for _, c := range line[:68] {
+		switch {
+		case c >= '0' && c <= '9':
+			sum += int(c - '0')
+		case c == '-':
+			sum++
+		}
+	}
+	return sum % 10
+}
+
+// parseTLEExponent parses fields like " 28098-4", which encode 0.28098e-4.
+func parseTLEExponent(s string, errs *[]error) float64 {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0
+	}
+	sign := ""
+	if s[0] == '-' || s[0] == '+' {
+		sign, s = s[:1], s[1:]
+	}
+	i := strings.LastIndexAny(s, "+-")
+	if i <= 0 {
+		*errs = append(*errs, fmt.Errorf("invalid TLE exponent field %q", s))
+		return 0
+	}
+	f, err := strconv.ParseFloat(sign+"0."+s[:i]+"e"+s[i:], 64)
+	if err != nil {
+		*errs = append(*errs, err)
+	}
+	return f
+}
+
+func newSGP4Propagator(epoch time.Time, bstar, ecco, inclo, argpo, nodeo, mo, noKozai float64) (*sgp4Propagator, error) {
+	const x2o3 = 2.0 / 3.0
+
+	p := &sgp4Propagator{
+		epoch: epoch, bstar: bstar, ecco: ecco, inclo: inclo, argpo: argpo, nodeo: nodeo, mo: mo,
+	}
+
+	// Recover the original mean motion and semimajor axis from the
+	// Kozai mean motion in the TLE.
+	eccsq := ecco * ecco
+	omeosq := 1 - eccsq
+	rteosq := math.Sqrt(omeosq)
+	cosio := math.Cos(inclo)
+	cosio2 := cosio * cosio
+	ak := math.Pow(sgp4Xke/noKozai, x2o3)
+	d1 := 0.75 * sgp4J2 * (3*cosio2 - 1) / (rteosq * omeosq)
+	del := d1 / (ak * ak)
+	adel := ak * (1 - del*del - del*(1.0/3.0+134*del*del/81))
+	del = d1 / (adel * adel)
+	p.no = noKozai / (1 + del)
+
+	ao := math.Pow(sgp4Xke/p.no, x2o3)
+	sinio := math.Sin(inclo)
+	po := ao * omeosq
+	con42 := 1 - 5*cosio2
+	p.con41 = -con42 - cosio2 - cosio2
+	posq := po * po
+	rp := ao * (1 - ecco)
+
+	if twoPi/p.no >= 225 {
+		return nil, errors.New("deep-space orbits (periods of 225 minutes or more) aren't supported")
+	}
+	if omeosq < 0 || p.no < 0 {
+		return nil, errors.New("invalid orbital elements")
+	}
+
+	// Low perigees use a simplified drag model.
+	p.isimp = rp < 220/sgp4EarthRadiusKm+1
+
+	sfour := 78/sgp4EarthRadiusKm + 1
+	qzms24 := math.Pow((120-78)/sgp4EarthRadiusKm, 4)
+	if perige := (rp - 1) * sgp4EarthRadiusKm; perige < 156 {
+		sfour = perige - 78
+		if perige < 98 {
+			sfour = 20
+		}
+		qzms24 = math.Pow((120-sfour)/sgp4EarthRadiusKm, 4)
+		sfour = sfour/sgp4EarthRadiusKm + 1
+	}
+
+	pinvsq := 1 / posq
+	tsi := 1 / (ao - sfour)
+	p.eta = ao * ecco * tsi
+	etasq := p.eta * p.eta
+	eeta := ecco * p.eta
+	psisq := math.Abs(1 - etasq)
+	coef := qzms24 * math.Pow(tsi, 4)
+	coef1 := coef / math.Pow(psisq, 3.5)
+	cc2 := coef1 * p.no * (ao*(1+1.5*etasq+eeta*(4+etasq)) +
+		0.375*sgp4J2*tsi/psisq*p.con41*(8+3*etasq*(8+etasq)))
+	p.cc1 = bstar * cc2
+	cc3 := 0.0
+	if ecco > 1e-4 {
+		cc3 = -2 * coef * tsi * sgp4J3OverJ2 * p.no * sinio / ecco
+	}
+	p.x1mth2 = 1 - cosio2
+	p.cc4 = 2 * p.no * coef1 * ao * omeosq * (p.eta*(2+0.5*etasq) + ecco*(0.5+2*etasq) -
+		sgp4J2*tsi/(ao*psisq)*(-3*p.con41*(1-2*eeta+etasq*(1.5-0.5*eeta))+
+			0.75*p.x1mth2*(2*etasq-eeta*(1+etasq))*math.Cos(2*argpo)))
+	p.cc5 = 2 * coef1 * ao * omeosq * (1 + 2.75*(etasq+eeta) + eeta*etasq)
+
+	cosio4 := cosio2 * cosio2
+	temp1 := 1.5 * sgp4J2 * pinvsq * p.no
+	temp2 := 0.5 * temp1 * sgp4J2 * pinvsq
+	temp3 := -0.46875 * sgp4J4 * pinvsq * pinvsq * p.no
+	p.mdot = p.no + 0.5*temp1*rteosq*p.con41 + 0.0625*temp2*rteosq*(13-78*cosio2+137*cosio4)
+	p.argpdot = -0.5*temp1*con42 + 0.0625*temp2*(7-114*cosio2+395*cosio4) + temp3*(3-36*cosio2+49*cosio4)
+	xhdot1 := -temp1 * cosio
+	p.nodedot = xhdot1 + (0.5*temp2*(4-19*cosio2)+2*temp3*(3-7*cosio2))*cosio
+	p.omgcof = bstar * cc3 * math.Cos(argpo)
+	if ecco > 1e-4 {
+		p.xmcof = -x2o3 * coef * bstar / eeta
+	}
+	p.nodecf = 3.5 * omeosq * xhdot1 * p.cc1
+	p.t2cof = 1.5 * p.cc1
+	if math.Abs(cosio+1) > 1.5e-12 {
+		p.xlcof = -0.25 * sgp4J3OverJ2 * sinio * (3 + 5*cosio) / (1 + cosio)
+	} else {
+		p.xlcof = -0.25 * sgp4J3OverJ2 * sinio * (3 + 5*cosio) / 1.5e-12
+	}
+	p.aycof = -0.5 * sgp4J3OverJ2 * sinio
+	p.delmo = math.Pow(1+p.eta*math.Cos(mo), 3)
+	p.sinmao = math.Sin(mo)
+	p.x7thm1 = 7*cosio2 - 1
+
+	if !p.isimp {
+		cc1sq := p.cc1 * p.cc1
+		p.d2 = 4 * ao * tsi * cc1sq
+		temp := p.d2 * tsi * p.cc1 / 3
+		p.d3 = (17*ao + sfour) * temp
+		p.d4 = 0.5 * temp * ao * tsi * (221*ao + 31*sfour) * p.cc1
+		p.t3cof = p.d2 + 2*cc1sq
+		p.t4cof = 0.25 * (3*p.d3 + p.cc1*(12*p.d2+10*cc1sq))
+		p.t5cof = 0.2 * (3*p.d4 + 12*p.cc1*p.d3 + 6*p.d2*p.d2 + 15*cc1sq*(2*p.d2+cc1sq))
+	}
+	return p, nil
+}
+
+// positionTEME returns the position, in kilometers, in the True Equator Mean
+// Equinox frame at time `t`.
+func (p *sgp4Propagator) positionTEME(t time.Time) ([3]float64, error) {
+	const x2o3 = 2.0 / 3.0
+	tsince := t.Sub(p.epoch).Minutes()
+
+	// Secular gravity and atmospheric drag.
+	xmdf := p.mo + p.mdot*tsince
+	argpdf := p.argpo + p.argpdot*tsince
+	nodedf := p.nodeo + p.nodedot*tsince
+	argpm := argpdf
+	mm := xmdf
+	t2 := tsince * tsince
+	nodem := nodedf + p.nodecf*t2
+	tempa := 1 - p.cc1*tsince
+	tempe := p.bstar * p.cc4 * tsince
+	templ := p.t2cof * t2
+
+	if !p.isimp {
+		delomg := p.omgcof * tsince
+		delm := p.xmcof * (math.Pow(1+p.eta*math.Cos(xmdf), 3) - p.delmo)
+		temp := delomg + delm
+		mm = xmdf + temp
+		argpm = argpdf - temp
+		t3 := t2 * tsince
+		t4 := t3 * tsince
+		tempa = tempa - p.d2*t2 - p.d3*t3 - p.d4*t4
+		tempe = tempe + p.bstar*p.cc5*(math.Sin(mm)-p.sinmao)
+		templ = templ + p.t3cof*t3 + t4*(p.t4cof+tsince*p.t5cof)
+	}
+
+	am := math.Pow(sgp4Xke/p.no, x2o3) * tempa * tempa
+	em := p.ecco - tempe
+	if em >= 1 || em < -0.001 {
+		return [3]float64{}, fmt.Errorf("eccentricity out of range at %s; the orbit has likely decayed", t.Format(time.RFC3339))
+	}
+	em = math.Max(em, 1e-6)
+	mm += p.no * templ
+	xlm := mm + argpm + nodem
+	nodem = math.Mod(nodem, twoPi)
+	argpm = math.Mod(argpm, twoPi)
+	xlm = math.Mod(xlm, twoPi)
+	mm = math.Mod(xlm-argpm-nodem, twoPi)
+
+	sinip, cosip := math.Sin(p.inclo), math.Cos(p.inclo)
+
+	// Long period periodics.
+	axnl := em * math.Cos(argpm)
+	temp := 1 / (am * (1 - em*em))
+	aynl := em*math.Sin(argpm) + temp*p.aycof
+	xl := mm + argpm + nodem + temp*p.xlcof*axnl
+
+	// Solve Kepler's equation.
+	u := math.Mod(xl-nodem, twoPi)
+	eo1 := u
+	var sineo1, coseo1 float64
+	for i, tem5 := 0, 1.0; math.Abs(tem5) >= 1e-12 && i < 10; i++ {
+		sineo1, coseo1 = math.Sin(eo1), math.Cos(eo1)
+		tem5 = 1 - coseo1*axnl - sineo1*aynl
+		tem5 = (u - aynl*coseo1 + axnl*sineo1 - eo1) / tem5
+		if math.Abs(tem5) >= 0.95 {
+			tem5 = math.Copysign(0.95, tem5)
+		}
+		eo1 += tem5
+	}
+
+	// Short period preliminary quantities.
+	ecose := axnl*coseo1 + aynl*sineo1
+	esine := axnl*sineo1 - aynl*coseo1
+	el2 := axnl*axnl + aynl*aynl
+	pl := am * (1 - el2)
+	if pl < 0 {
+		return [3]float64{}, fmt.Errorf("semi-latus rectum is negative at %s", t.Format(time.RFC3339))
+	}
+	rl := am * (1 - ecose)
+	betal := math.Sqrt(1 - el2)
+	temp = esine / (1 + betal)
+	sinu := am / rl * (sineo1 - aynl - axnl*temp)
+	cosu := am / rl * (coseo1 - axnl + aynl*temp)
+	su := math.Atan2(sinu, cosu)
+	sin2u := (cosu + cosu) * sinu
+	cos2u := 1 - 2*sinu*sinu
+	temp = 1 / pl
+	temp1 := 0.5 * sgp4J2 * temp
+	temp2 := temp1 * temp
+
+	// Update for short period periodics.
+	mrt := rl*(1-1.5*temp2*betal*p.con41) + 0.5*temp1*p.x1mth2*cos2u
+	su -= 0.25 * temp2 * p.x7thm1 * sin2u
+	xnode := nodem + 1.5*temp2*cosip*sin2u
+	xinc := p.inclo + 1.5*temp2*cosip*sinip*cos2u
+	if mrt < 1 {
+		return [3]float64{}, fmt.Errorf("satellite has decayed by %s", t.Format(time.RFC3339))
+	}
+
+	sinsu, cossu := math.Sin(su), math.Cos(su)
+	snod, cnod := math.Sin(xnode), math.Cos(xnode)
+	sini, cosi := math.Sin(xinc), math.Cos(xinc)
+	xmx := -snod * cosi
+	xmy := cnod * cosi
+	r := mrt * sgp4EarthRadiusKm
+	return [3]float64{
+		r * (xmx*sinsu + cnod*cossu),
+		r * (xmy*sinsu + snod*cossu),
+		r * (sini * sinsu),
+	}, nil
+}
+
+// positionECEF returns the position, in meters, in the Earth-Centered,
+// Earth-Fixed frame at time `t`. Polar motion is ignored.
+func (p *sgp4Propagator) positionECEF(t time.Time) ([3]float64, error) {
+	teme, err := p.positionTEME(t)
+	if err != nil {
+		return [3]float64{}, err
+	}
+	g := greenwichMeanSiderealTime(t)
+	sing, cosg := math.Sin(g), math.Cos(g)
+	return [3]float64{
+		1000 * (cosg*teme[0] + sing*teme[1]),
+		1000 * (-sing*teme[0] + cosg*teme[1]),
+		1000 * teme[2],
+	}, nil
+}
+
+// greenwichMeanSiderealTime returns the IAU-82 Greenwich mean sidereal time,
+// in radians, at time `t` (treated as UT1).
+func greenwichMeanSiderealTime(t time.Time) float64 {
+	jd := float64(t.UnixNano())/float64(24*time.Hour) + 2440587.5
+	tut1 := (jd - 2451545) / 36525
+	secs := -6.2e-6*tut1*tut1*tut1 + 0.093104*tut1*tut1 +
+		(876600*3600+8640184.812866)*tut1 + 67310.54841
+	g := math.Mod(secs*math.Pi/180/240, twoPi)
+	if g < 0 {
+		g += twoPi
+	}
+	return g
+}
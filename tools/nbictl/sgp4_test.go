@@ -0,0 +1,93 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	vanguardTLELine1 = "1 00005U 58002B   00179.78495062  .00000023  00000-0  28098-4 0  4753"
+	vanguardTLELine2 = "2 00005  34.2682 348.7242 1859667 331.7664  19.3264 10.82419157413667"
+)
+
+func TestSGP4_matchesVerificationVectors(t *testing.T) {
+	t.Parallel()
+
+	p, err := parseTLE(vanguardTLELine1, vanguardTLELine2)
+	checkErr(t, err)
+
+	// From the verification output published alongside "Revisiting
+	// Spacetrack Report #3".
+	for _, tc := range []struct {
+		minutes float64
+		want    [3]float64
+	}{
+		{0, [3]float64{7022.46529266, -1400.08296755, 0.03995155}},
+		{360, [3]float64{-7154.03120202, -3783.17682504, -3536.19412294}},
+		{720, [3]float64{-7134.59340119, 6531.68641334, 3260.27186483}},
+	} {
+		got, err := p.positionTEME(p.epoch.Add(time.Duration(tc.minutes * float64(time.Minute))))
+		checkErr(t, err)
+		for i := range got {
+			if math.Abs(got[i]-tc.want[i]) > 1e-3 {
+				t.Errorf("position at t+%vm: got %v, want %v", tc.minutes, got, tc.want)
+				break
+			}
+		}
+	}
+}
+
+func TestParseTLE_rejectsInvalidLines(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name, line1, line2, want string
+	}{
+		{
+			name:  "short line",
+			line1: vanguardTLELine1[:60],
+			line2: vanguardTLELine2,
+			want:  "TLE line 1 must be 69 characters long",
+		},
+		{
+			name:  "swapped lines",
+			line1: vanguardTLELine2,
+			line2: vanguardTLELine1,
+			want:  "TLE line 1 must start with '1'",
+		},
+		{
+			name:  "bad checksum",
+			line1: vanguardTLELine1,
+			line2: vanguardTLELine2[:68] + "0",
+			want:  "TLE line 2 has an invalid checksum",
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			switch _, err := parseTLE(tc.line1, tc.line2); {
+			case err == nil:
+				t.Fatalf("expected an error containing %q, got nil", tc.want)
+			case !strings.Contains(err.Error(), tc.want):
+				t.Fatalf("expected error to contain %q, but got %q", tc.want, err.Error())
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/chzyer/readline"
+	"github.com/urfave/cli/v2"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+)
+
+const (
+	shellHistoryFileName = "shell_history"
+	shellPrompt          = appName + "> "
+)
+
+var errNestedShell = errors.New("already running in a shell")
+
+// Shell runs an interactive session in which each line is run as an nbictl
+// command. Connections are reused between commands, and the global flags the
+// shell was started with apply to every command.
+func Shell(appCtx *cli.Context) error {
+	if connectionCacheFromContext(appCtx.Context) != nil {
+		return errNestedShell
+	}
+	conns := newConnectionCache(appCtx.Context)
+	defer conns.Close()
+	appCtx.Context = withConnectionCache(appCtx.Context, conns)
+
+	historyFile := ""
+	if checkLocalStateWritable(appCtx) == nil {
+		confDir, err := getAppConfDir(appCtx)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(confDir, 0o777); err != nil {
+			return fmt.Errorf("unable to create config directory: %w", err)
+		}
+		historyFile = filepath.Join(confDir, shellHistoryFileName)
+	}
+
+	sh := &shell{appCtx: appCtx, globalArgs: inheritedGlobalArgs(appCtx)}
+	rl, err := readline.NewEx(&readline.Config{
+		Prompt:          shellPrompt,
+		HistoryFile:     historyFile,
+		AutoComplete:    &commandCompleter{commands: App().Commands, extraCommands: []string{"exit"}, entityIDs: sh.entityIDs},
+		InterruptPrompt: "^C",
+		EOFPrompt:       "exit",
+		Stdin:           io.NopCloser(appCtx.App.Reader),
+		Stdout:          appCtx.App.Writer,
+		Stderr:          appCtx.App.ErrWriter,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to start the shell: %w", err)
+	}
+	defer rl.Close()
+
+	fmt.Fprintln(appCtx.App.ErrWriter, `type "help" for a list of commands and "exit" to quit`)
+	for {
+		line, err := rl.Readline()
+		switch {
+		case errors.Is(err, readline.ErrInterrupt):
+			continue
+		case errors.Is(err, io.EOF):
+			return nil
+		case err != nil:
+			return err
+		}
+
+		if exit := sh.run(line); exit {
+			return nil
+		}
+	}
+}
+
+type shell struct {
+	appCtx     *cli.Context
+	globalArgs []string
+
+	mu  sync.Mutex
+	ids map[nbipb.EntityType][]string
+}
+
+// run runs a single line of input and reports whether the shell should exit.
+// Errors are printed rather than returned so the session continues.
+func (s *shell) run(line string) bool {
+	args, err := splitShellWords(line)
+	if err != nil {
+		fmt.Fprintf(s.appCtx.App.ErrWriter, "error: %v\n", err)
+		return false
+	}
+	if len(args) == 0 {
+		return false
+	}
+	switch args[0] {
+	case "exit", "quit":
+		return true
+	case "shell":
+		fmt.Fprintf(s.appCtx.App.ErrWriter, "error: %v\n", errNestedShell)
+		return false
+	}
+
+	// Interrupting a command returns to the prompt instead of exiting.
+	ctx, stop := signal.NotifyContext(s.appCtx.Context, os.Interrupt)
+	defer stop()
+
+	app := App()
+	app.Reader, app.Writer, app.ErrWriter = s.appCtx.App.Reader, s.appCtx.App.Writer, s.appCtx.App.ErrWriter
+	// Errors like unknown commands would otherwise exit the whole process.
+	app.ExitErrHandler = func(*cli.Context, error) {}
+	if err := app.RunContext(ctx, slices.Concat([]string{appName}, s.globalArgs, args)); err != nil {
+		fmt.Fprintf(s.appCtx.App.ErrWriter, "error: %v\n", err)
+	}
+	// Entities may have been created or deleted, so fetch IDs again for
+	// completion.
+	s.mu.Lock()
+	s.ids = nil
+	s.mu.Unlock()
+	return false
+}
+
+// entityIDs returns the IDs of the entities of type `t`, which are fetched
+// the first time they're needed and cached until the next command runs.
+func (s *shell) entityIDs(t nbipb.EntityType) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ids, ok := s.ids[t]; ok {
+		return ids
+	}
+
+	ids := fetchEntityIDs(s.appCtx, t)
+	if s.ids == nil {
+		s.ids = map[nbipb.EntityType][]string{}
+	}
+	s.ids[t] = ids
+	return ids
+}
+
+// inheritedGlobalArgs returns the global flags the shell was started with, so
+// they can be passed to each command run in it.
+func inheritedGlobalArgs(appCtx *cli.Context) []string {
+	args := []string{}
+	for _, f := range appCtx.App.Flags {
+		name := f.Names()[0]
+		if !appCtx.IsSet(name) {
+			continue
+		}
+		switch f := f.(type) {
+		case *cli.BoolFlag:
+			args = append(args, fmt.Sprintf("--%s=%t", name, appCtx.Bool(name)))
+		case *cli.StringSliceFlag:
+			for _, v := range appCtx.StringSlice(name) {
+				args = append(args, "--"+name, v)
+			}
+		case *cli.TimestampFlag:
+			args = append(args, "--"+name, appCtx.Timestamp(name).Format(f.Layout))
+		default:
+			args = append(args, "--"+name, appCtx.String(name))
+		}
+	}
+	return args
+}
+
+// splitShellWords splits a line into words like a POSIX shell would, with
+// support for single quotes, double quotes, and backslash escapes.
+func splitShellWords(line string) ([]string, error) {
+	words := []string{}
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+
+	for _, r := range line {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\\':
+			escaped, inWord = true, true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, errors.New("unterminated quote or escape")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
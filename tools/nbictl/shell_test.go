@@ -0,0 +1,87 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestSplitShellWords(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		line string
+		want []string
+	}{
+		{line: "", want: []string{}},
+		{line: "  list   -t NETWORK_NODE ", want: []string{"list", "-t", "NETWORK_NODE"}},
+		{line: `list --column 'name=network_node.name' --column "a b=1"`, want: []string{"list", "--column", "name=network_node.name", "--column", "a b=1"}},
+		{line: `get --id my\ node`, want: []string{"get", "--id", "my node"}},
+		{line: `get --id ""`, want: []string{"get", "--id", ""}},
+		{line: `echo "it's" 'a "b"'`, want: []string{"echo", "it's", `a "b"`}},
+	} {
+		tc := tc
+		t.Run(tc.line, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := splitShellWords(tc.line)
+			checkErr(t, err)
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Fatalf("unexpected words (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	if _, err := splitShellWords(`get --id "my-id`); err == nil {
+		t.Fatal("expected an unterminated quote to cause an error, got nil")
+	}
+}
+
+func TestConnectionCache_reusesConnections(t *testing.T) {
+	t.Parallel()
+
+	cache := newConnectionCache(context.Background())
+	opened := 0
+	open := func(ctx context.Context) (*grpc.ClientConn, error) {
+		opened++
+		return grpc.NewClient("localhost:0", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}
+
+	a, err := cache.get("a", open)
+	checkErr(t, err)
+	checkErr(t, a.Close())
+	again, err := cache.get("a", open)
+	checkErr(t, err)
+	if _, err := cache.get("b", open); err != nil {
+		t.Fatal(err)
+	}
+	if opened != 2 {
+		t.Fatalf("expected 2 connections to be opened, got %d", opened)
+	}
+	if a.(sharedConn).ClientConn != again.(sharedConn).ClientConn {
+		t.Fatal("expected the connection to be reused after being closed by a command")
+	}
+
+	checkErr(t, cache.Close())
+	if state := again.(sharedConn).GetState(); state != connectivity.Shutdown {
+		t.Fatalf("expected closing the cache to close its connections, got state %v", state)
+	}
+}
@@ -0,0 +1,402 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/urfave/cli/v2"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+)
+
+var entityOutputFormatFlag = &cli.StringFlag{
+	Name:    "output",
+	Usage:   "Output format. Allowed values: [text, table, go-template=TEMPLATE, go-template-file=PATH]. The text format prints the entities as textproto, and the table format prints one row per entity with its type, ID, and any --column values. Templates are Go text/templates (https://pkg.go.dev/text/template) executed against the JSON representation of the entity, or of the ListEntitiesResponse for list, with Sprig-style helpers such as date, fromUnixMicro, trunc, and abbrev.",
+	Aliases: []string{"o"},
+	Value:   "text",
+	Action: func(_ *cli.Context, f string) error {
+		switch {
+		case f == "text", f == "table":
+			return nil
+		case isTemplateFormat(f):
+			_, err := parseOutputTemplate(f)
+			return err
+		default:
+			return fmt.Errorf("unknown output format %q", f)
+		}
+	},
+}
+
+var (
+	columnsFlag = &cli.StringSliceFlag{
+		Name:  "columns",
+		Usage: "Comma-separated names of the columns to print with --output=table, in order. Besides the names of --column and --join columns, the available columns are type, id, updated (the commit timestamp), and modified_by. Defaults to type, id, and every --column and --join column. Cells are truncated to fit the width of the terminal, or $COLUMNS if set.",
+	}
+	sortByFlag = &cli.StringSliceFlag{
+		Name:  "sort_by",
+		Usage: "Comma-separated names of the columns to sort the --output=table rows by, each prefixed with - to sort in descending order, e.g. type,-updated. Numbers are sorted numerically.",
+	}
+)
+
+const columnFlagName = "column"
+
+// newColumnFlag returns the --column flag. Unlike a StringSliceFlag, values
+// aren't split on commas, since expressions often contain them. The flag
+// holds its own value, so a new one is created for each command.
+func newColumnFlag() cli.Flag {
+	return &cli.GenericFlag{
+		Name:  columnFlagName,
+		Usage: "A computed column for --output=table, as `NAME=EXPRESSION`. The expression is written in CEL (https://cel.dev) and can refer to any field of the Entity message by name, e.g. `name=platform.name` or `models=size(platform.transceiver_model)`. Can be repeated.",
+		Value: &repeatedFlagValues{},
+	}
+}
+
+// repeatedFlagValues collects the values of each occurrence of a flag that
+// can be repeated, such as --column.
+type repeatedFlagValues []string
+
+func (c *repeatedFlagValues) Set(v string) error {
+	*c = append(*c, v)
+	return nil
+}
+
+func (c *repeatedFlagValues) String() string { return strings.Join(*c, " ") }
+
+// tableColumn is a column of an entity table whose value is computed by
+// evaluating a CEL expression against each entity.
+type tableColumn struct {
+	name string
+	prg  cel.Program
+}
+
+// parseTableColumns compiles the `--column` flag values.
+func parseTableColumns(appCtx *cli.Context) ([]tableColumn, error) {
+	opts, err := entityOutputOptionsFromFlags(appCtx)
+	if err != nil {
+		return nil, err
+	}
+	return compileTableColumns(opts.Columns)
+}
+
+// newEntityExprEnv returns the environment of CEL expressions evaluated
+// against an entity, in which the fields of the Entity message are
+// variables.
+func newEntityExprEnv() (*cel.Env, error) {
+	env, err := cel.NewEnv(
+		cel.Types(&nbipb.Entity{}),
+		cel.DeclareContextProto((&nbipb.Entity{}).ProtoReflect().Descriptor()),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("creating the expression environment: %w", err)
+	}
+	return env, nil
+}
+
+// compileTableColumns compiles column specs of the form NAME=EXPRESSION.
+// Expressions are type-checked against the Entity message, so typos in field
+// names are reported before any requests are made.
+func compileTableColumns(specs []string) ([]tableColumn, error) {
+	env, err := newEntityExprEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	cols := []tableColumn{}
+	for _, spec := range specs {
+		name, expr, ok := strings.Cut(spec, "=")
+		name, expr = strings.TrimSpace(name), strings.TrimSpace(expr)
+		if !ok || name == "" || expr == "" {
+			return nil, fmt.Errorf("invalid column %q: expected NAME=EXPRESSION", spec)
+		}
+		ast, iss := env.Compile(expr)
+		if iss.Err() != nil {
+			return nil, fmt.Errorf("invalid expression for column %q: %w", name, iss.Err())
+		}
+		prg, err := env.Program(ast)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expression for column %q: %w", name, err)
+		}
+		cols = append(cols, tableColumn{name: name, prg: prg})
+	}
+	return cols, nil
+}
+
+// minTableColumnWidth is the width below which columns aren't truncated to
+// fit the table in the terminal.
+const minTableColumnWidth = 8
+
+// builtinTableColumns are the columns available in every entity table, in
+// addition to the computed and joined columns.
+var builtinTableColumns = []struct {
+	name, header string
+	value        func(*nbipb.Entity) string
+}{
+	{name: "type", header: "TYPE", value: func(e *nbipb.Entity) string { return e.GetGroup().GetType().String() }},
+	{name: "id", header: "ID", value: func(e *nbipb.Entity) string { return e.GetId() }},
+	{name: "updated", header: "UPDATED", value: func(e *nbipb.Entity) string {
+		if e.CommitTimestamp == nil {
+			return ""
+		}
+		// A fixed number of fractional digits keeps the values sortable.
+		return time.UnixMicro(e.GetCommitTimestamp()).UTC().Format("2006-01-02T15:04:05.000000Z")
+	}},
+	{name: "modified_by", header: "MODIFIED_BY", value: func(e *nbipb.Entity) string { return e.GetLastModifiedBy() }},
+}
+
+// tableLayout selects, orders, and sizes the columns of an entity table.
+type tableLayout struct {
+	// columns are the names of the columns to print, in order: any of the
+	// builtinTableColumns, computed columns, or joined field paths. If
+	// empty, the type, ID, computed columns, and joined fields are printed.
+	columns []string
+	// sortBy are the names of the columns to sort the rows by, each
+	// optionally prefixed with "-" to sort in descending order. Rows are
+	// otherwise printed in the order of the entities.
+	sortBy []string
+	// maxWidth, if positive, is the width the widest columns are truncated
+	// to fit in.
+	maxWidth int
+}
+
+// writeEntityTable prints one row per entity with its type, ID, the value of
+// each of `cols`, and the values of any joined fields, or the columns
+// selected by `layout`.
+func writeEntityTable(out io.Writer, entities []*nbipb.Entity, cols []tableColumn, joins *joinedEntities, layout tableLayout) error {
+	// Every column is computed, then the rows are sorted and the selected
+	// columns picked out, so rows can be sorted by columns that aren't
+	// printed.
+	header, names := []string{}, []string{}
+	cells := make([][]string, len(entities))
+	for _, b := range builtinTableColumns {
+		header, names = append(header, b.header), append(names, b.name)
+		for i, e := range entities {
+			cells[i] = append(cells[i], b.value(e))
+		}
+	}
+	defaultColumns := []string{"type", "id"}
+
+	for i, e := range entities {
+		vars, err := cel.ContextProtoVars(e)
+		if err != nil {
+			return err
+		}
+		for _, col := range cols {
+			val, _, err := col.prg.Eval(vars)
+			if err != nil {
+				return fmt.Errorf("evaluating column %q for entity %s/%s: %w", col.name, e.GetGroup().GetType(), e.GetId(), err)
+			}
+			cell, err := formatCELValue(val)
+			if err != nil {
+				return fmt.Errorf("formatting column %q for entity %s/%s: %w", col.name, e.GetGroup().GetType(), e.GetId(), err)
+			}
+			cells[i] = append(cells[i], cell)
+		}
+		if joins != nil {
+			for _, j := range joins.joins {
+				cells[i] = append(cells[i], joins.cell(j, e))
+			}
+		}
+	}
+	for _, col := range cols {
+		header, names, defaultColumns = append(header, col.name), append(names, col.name), append(defaultColumns, col.name)
+	}
+	if joins != nil {
+		for _, j := range joins.joins {
+			header, names, defaultColumns = append(header, j.path), append(names, j.path), append(defaultColumns, j.path)
+		}
+	}
+
+	// Computed and joined columns take precedence over built-in columns
+	// with the same name.
+	index := func(name string) (int, error) {
+		for i := len(names) - 1; i >= 0; i-- {
+			if names[i] == name {
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("unknown column %q; available columns are: %s", name, strings.Join(names, ", "))
+	}
+
+	type sortKey struct {
+		col  int
+		desc bool
+	}
+	sortKeys := []sortKey{}
+	for _, s := range layout.sortBy {
+		name, desc := strings.CutPrefix(s, "-")
+		col, err := index(name)
+		if err != nil {
+			return fmt.Errorf("invalid sort key: %w", err)
+		}
+		sortKeys = append(sortKeys, sortKey{col: col, desc: desc})
+	}
+	sort.SliceStable(cells, func(i, j int) bool {
+		for _, k := range sortKeys {
+			if c := compareTableCells(cells[i][k.col], cells[j][k.col]); c != 0 {
+				return (c < 0) != k.desc
+			}
+		}
+		return false
+	})
+
+	selected := layout.columns
+	if len(selected) == 0 {
+		selected = defaultColumns
+	}
+	rows := [][]string{{}}
+	for _, name := range selected {
+		col, err := index(name)
+		if err != nil {
+			return err
+		}
+		rows[0] = append(rows[0], header[col])
+	}
+	for _, row := range cells {
+		r := []string{}
+		for _, name := range selected {
+			col, _ := index(name)
+			r = append(r, row[col])
+		}
+		rows = append(rows, r)
+	}
+	if layout.maxWidth > 0 {
+		truncateTable(rows, layout.maxWidth)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
+
+// compareTableCells orders cells numerically if both are numbers, and
+// lexicographically otherwise.
+func compareTableCells(a, b string) int {
+	x, errX := strconv.ParseFloat(a, 64)
+	y, errY := strconv.ParseFloat(b, 64)
+	if errX == nil && errY == nil {
+		return cmp.Compare(x, y)
+	}
+	return strings.Compare(a, b)
+}
+
+// truncateTable shortens the widest columns of `rows`, one character at a
+// time, until the table fits in `maxWidth` or every column is down to
+// minTableColumnWidth. Truncated cells end with an ellipsis.
+func truncateTable(rows [][]string, maxWidth int) {
+	if len(rows) == 0 {
+		return
+	}
+	widths := make([]int, len(rows[0]))
+	for _, row := range rows {
+		for i, cell := range row {
+			widths[i] = max(widths[i], utf8.RuneCountInString(cell))
+		}
+	}
+	// Columns are separated by two spaces of padding.
+	total := 2 * (len(widths) - 1)
+	for _, w := range widths {
+		total += w
+	}
+	for total > maxWidth {
+		widest := 0
+		for i, w := range widths {
+			if w > widths[widest] {
+				widest = i
+			}
+		}
+		if widths[widest] <= minTableColumnWidth {
+			break
+		}
+		widths[widest]--
+		total--
+	}
+
+	for _, row := range rows {
+		for i, cell := range row {
+			if utf8.RuneCountInString(cell) > widths[i] {
+				row[i] = string([]rune(cell)[:widths[i]-1]) + "…"
+			}
+		}
+	}
+}
+
+// terminalWidth returns the width of the terminal `w` writes to, directly or
+// through a pager, or 0 if it doesn't write to one. $COLUMNS takes
+// precedence over the size reported by the terminal.
+func terminalWidth(w io.Writer) int {
+	if p, ok := w.(*pagedWriter); ok {
+		w = p.terminal
+	}
+	if !isTerminal(w) {
+		return 0
+	}
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		return n
+	}
+	return windowWidth(w.(*os.File))
+}
+
+// formatCELValue renders the result of an expression on a single line.
+// Scalars are printed as-is, messages as compact textproto, and lists and
+// maps as JSON.
+func formatCELValue(v ref.Val) (string, error) {
+	switch v := v.(type) {
+	case types.String:
+		return string(v), nil
+	case types.Bool:
+		return strconv.FormatBool(bool(v)), nil
+	case types.Int:
+		return strconv.FormatInt(int64(v), 10), nil
+	case types.Uint:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case types.Double:
+		return formatFloat(float64(v), 64), nil
+	case types.Timestamp:
+		return v.Time.UTC().Format(time.RFC3339Nano), nil
+	case types.Duration:
+		return v.Duration.String(), nil
+	}
+
+	if m, ok := v.Value().(proto.Message); ok {
+		txt, err := prototext.MarshalOptions{Multiline: false}.Marshal(m)
+		return strings.TrimSpace(string(txt)), err
+	}
+	native, err := v.ConvertToNative(reflect.TypeFor[*structpb.Value]())
+	if err != nil {
+		return "", err
+	}
+	js, err := json.Marshal(native.(*structpb.Value).AsInterface())
+	return string(js), err
+}
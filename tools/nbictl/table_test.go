@@ -0,0 +1,225 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"bytes"
+	"flag"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/urfave/cli/v2"
+	"google.golang.org/protobuf/proto"
+
+	commonpb "aalyria.com/spacetime/api/common"
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+	resourcespb "aalyria.com/spacetime/api/nbi/v1alpha/resources"
+)
+
+func newColumnsContext(t *testing.T, args ...string) *cli.Context {
+	t.Helper()
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	for _, f := range []cli.Flag{entityOutputFormatFlag, newColumnFlag()} {
+		checkErr(t, f.Apply(fs))
+	}
+	checkErr(t, fs.Parse(args))
+	return cli.NewContext(cli.NewApp(), fs, nil)
+}
+
+func TestWriteEntityTable(t *testing.T) {
+	t.Parallel()
+
+	entities := []*nbipb.Entity{
+		{
+			Group: &nbipb.EntityGroup{Type: nbipb.EntityType_PLATFORM_DEFINITION.Enum()},
+			Id:    proto.String("sat-1"),
+			Value: &nbipb.Entity_Platform{Platform: &commonpb.PlatformDefinition{
+				Name: proto.String("Satellite 1"),
+				Coordinates: &commonpb.Motion{Type: &commonpb.Motion_GeodeticWgs84{
+					GeodeticWgs84: &commonpb.GeodeticWgs84{LatitudeDeg: proto.Float64(10), LongitudeDeg: proto.Float64(20)},
+				}},
+			}},
+		},
+		{
+			Group: &nbipb.EntityGroup{Type: nbipb.EntityType_PLATFORM_DEFINITION.Enum()},
+			Id:    proto.String("gs-1"),
+			Value: &nbipb.Entity_Platform{Platform: &commonpb.PlatformDefinition{
+				Name: proto.String("Ground Station"),
+			}},
+		},
+	}
+
+	cols, err := parseTableColumns(newColumnsContext(t,
+		"--output", "table",
+		"--column", "name=platform.name",
+		"--column", "lat_plus_lon = platform.coordinates.geodetic_wgs84.latitude_deg + platform.coordinates.geodetic_wgs84.longitude_deg",
+		"--column", "has_coords=has(platform.coordinates)",
+		"--column", "tags=[id, platform.name]",
+	))
+	checkErr(t, err)
+
+	out := &bytes.Buffer{}
+	checkErr(t, writeEntityTable(out, entities, cols, nil, tableLayout{}))
+
+	want := []string{
+		`TYPE                 ID     name            lat_plus_lon  has_coords  tags`,
+		`PLATFORM_DEFINITION  sat-1  Satellite 1     30            true        ["sat-1","Satellite 1"]`,
+		`PLATFORM_DEFINITION  gs-1   Ground Station  0             false       ["gs-1","Ground Station"]`,
+	}
+	if diff := cmp.Diff(want, strings.Split(strings.TrimSpace(out.String()), "\n")); diff != "" {
+		t.Errorf("unexpected table (-want +got):\n%s", diff)
+	}
+}
+
+func TestWriteEntityTable_layout(t *testing.T) {
+	t.Parallel()
+
+	node := func(id, name string, ts int64) *nbipb.Entity {
+		return &nbipb.Entity{
+			Group:           &nbipb.EntityGroup{Type: nbipb.EntityType_NETWORK_NODE.Enum()},
+			Id:              proto.String(id),
+			CommitTimestamp: proto.Int64(ts),
+			Value:           &nbipb.Entity_NetworkNode{NetworkNode: &resourcespb.NetworkNode{Name: proto.String(name)}},
+		}
+	}
+	entities := []*nbipb.Entity{
+		node("node-10", "a node with a particularly long name", 1_700_000_000_000_000),
+		node("node-9", "short", 1_700_000_000_000_001),
+		node("node-2", "short", 1_600_000_000_000_000),
+	}
+	cols, err := compileTableColumns([]string{"name=network_node.name", "len=size(network_node.name)"})
+	checkErr(t, err)
+
+	for _, tc := range []struct {
+		name    string
+		layout  tableLayout
+		want    []string
+		wantErr string
+	}{
+		{
+			name:   "selected columns",
+			layout: tableLayout{columns: []string{"id", "updated", "len"}},
+			want: []string{
+				"ID       UPDATED                      len",
+				"node-10  2023-11-14T22:13:20.000000Z  36",
+				"node-9   2023-11-14T22:13:20.000001Z  5",
+				"node-2   2020-09-13T12:26:40.000000Z  5",
+			},
+		},
+		{
+			name:   "sorted by multiple columns",
+			layout: tableLayout{columns: []string{"id", "len"}, sortBy: []string{"len", "-updated"}},
+			want: []string{
+				"ID       len",
+				"node-9   5",
+				"node-2   5",
+				"node-10  36",
+			},
+		},
+		{
+			name:   "numbers are sorted numerically",
+			layout: tableLayout{columns: []string{"id"}, sortBy: []string{"-len", "id"}},
+			want: []string{
+				"ID",
+				"node-10",
+				"node-2",
+				"node-9",
+			},
+		},
+		{
+			name:   "truncated to the width",
+			layout: tableLayout{columns: []string{"id", "name"}, maxWidth: 30},
+			want: []string{
+				"ID       name",
+				"node-10  a node with a partic…",
+				"node-9   short",
+				"node-2   short",
+			},
+		},
+		{
+			name:    "unknown column",
+			layout:  tableLayout{columns: []string{"id", "nmae"}},
+			wantErr: `unknown column "nmae"; available columns are: type, id, updated, modified_by, name, len`,
+		},
+		{
+			name:    "unknown sort key",
+			layout:  tableLayout{sortBy: []string{"-nmae"}},
+			wantErr: `invalid sort key: unknown column "nmae"`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			out := &bytes.Buffer{}
+			err := writeEntityTable(out, entities, cols, nil, tc.layout)
+			switch {
+			case tc.wantErr != "":
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("writeEntityTable() = %v, want error containing %q", err, tc.wantErr)
+				}
+				return
+			case err != nil:
+				t.Fatal(err)
+			}
+			got := strings.Split(strings.TrimSpace(out.String()), "\n")
+			for i := range got {
+				got[i] = strings.TrimRight(got[i], " ")
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("unexpected table (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParseTableColumns_errors(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name    string
+		args    []string
+		wantErr string
+	}{
+		{
+			name:    "requires table output",
+			args:    []string{"--column", "name=platform.name"},
+			wantErr: "--column requires --output=table",
+		},
+		{
+			name:    "missing expression",
+			args:    []string{"--output", "table", "--column", "name"},
+			wantErr: `invalid column "name": expected NAME=EXPRESSION`,
+		},
+		{
+			name:    "unknown field",
+			args:    []string{"--output", "table", "--column", "name=platform.nmae"},
+			wantErr: `invalid expression for column "name"`,
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			switch _, err := parseTableColumns(newColumnsContext(t, tc.args...)); {
+			case err == nil:
+				t.Fatalf("expected error %q, got nil", tc.wantErr)
+			case !strings.Contains(err.Error(), tc.wantErr):
+				t.Fatalf("expected error to contain %q, but got %q", tc.wantErr, err.Error())
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	goTemplateFormatPrefix     = "go-template="
+	goTemplateFileFormatPrefix = "go-template-file="
+)
+
+// templateFuncs are the functions available to --output=go-template, in
+// addition to the text/template builtins. Their names and argument order
+// follow the Sprig library (https://masterminds.github.io/sprig/), so the
+// value being transformed comes last and can be piped in, as in
+// `{{.commitTimestamp | fromUnixMicro | date "2006-01-02"}}`.
+var templateFuncs = template.FuncMap{
+	"now":           time.Now,
+	"date":          templateDate,
+	"toDate":        time.Parse,
+	"ago":           templateAgo,
+	"unixEpoch":     templateUnixEpoch,
+	"fromUnixMicro": templateFromUnixMicro,
+	"trunc":         templateTrunc,
+	"abbrev":        templateAbbrev,
+	"upper":         strings.ToUpper,
+	"lower":         strings.ToLower,
+	"trim":          strings.TrimSpace,
+	"contains":      func(substr, s string) bool { return strings.Contains(s, substr) },
+	"replace":       func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	"join":          templateJoin,
+	"default":       templateDefault,
+	"toJson":        templateToJSON,
+}
+
+// parseOutputTemplate returns the template selected by an --output value of
+// the form go-template=TEMPLATE or go-template-file=PATH, or nil if `format`
+// doesn't select a template.
+func parseOutputTemplate(format string) (*template.Template, error) {
+	var text string
+	switch {
+	case strings.HasPrefix(format, goTemplateFormatPrefix):
+		text = strings.TrimPrefix(format, goTemplateFormatPrefix)
+	case strings.HasPrefix(format, goTemplateFileFormatPrefix):
+		path := strings.TrimPrefix(format, goTemplateFileFormatPrefix)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading the output template: %w", err)
+		}
+		text = string(data)
+	default:
+		return nil, nil
+	}
+	tmpl, err := template.New("output").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// isTemplateFormat reports whether an --output value selects a template.
+func isTemplateFormat(format string) bool {
+	return strings.HasPrefix(format, goTemplateFormatPrefix) || strings.HasPrefix(format, goTemplateFileFormatPrefix)
+}
+
+// executeTemplate renders the JSON document `js` with `tmpl`. The document is
+// decoded into maps and slices, so templates refer to fields by their JSON
+// names, and numbers keep their exact value.
+func executeTemplate(w io.Writer, tmpl *template.Template, js []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+	var data any
+	if err := dec.Decode(&data); err != nil {
+		return err
+	}
+	if err := tmpl.Execute(w, data); err != nil {
+		return fmt.Errorf("executing the output template: %w", err)
+	}
+	return nil
+}
+
+// templateTime converts the values found in output documents to a time:
+// RFC 3339 strings, which is how timestamps are encoded, and numbers, which
+// are taken to be seconds since the Unix epoch.
+func templateTime(v any) (time.Time, error) {
+	switch v := v.(type) {
+	case time.Time:
+		return v, nil
+	case *time.Time:
+		return *v, nil
+	case json.Number:
+		return templateTime(v.String())
+	case int:
+		return time.Unix(int64(v), 0), nil
+	case int64:
+		return time.Unix(v, 0), nil
+	case float64:
+		return time.Unix(0, int64(v*float64(time.Second))), nil
+	case string:
+		if secs, err := strconv.ParseFloat(v, 64); err == nil {
+			return templateTime(secs)
+		}
+		return time.Parse(time.RFC3339Nano, v)
+	default:
+		return time.Time{}, fmt.Errorf("can't convert %T to a time", v)
+	}
+}
+
+func templateTimeFunc(v any, f func(time.Time) string) (string, error) {
+	t, err := templateTime(v)
+	if err != nil {
+		return "", err
+	}
+	return f(t), nil
+}
+
+func templateDate(layout string, v any) (string, error) {
+	return templateTimeFunc(v, func(t time.Time) string { return t.Format(layout) })
+}
+
+func templateAgo(v any) (string, error) {
+	return templateTimeFunc(v, func(t time.Time) string { return time.Since(t).Round(time.Second).String() })
+}
+
+func templateUnixEpoch(v any) (string, error) {
+	return templateTimeFunc(v, func(t time.Time) string { return strconv.FormatInt(t.Unix(), 10) })
+}
+
+// templateFromUnixMicro converts microseconds since the Unix epoch, the unit
+// of the commit timestamps of entities, to a time.
+func templateFromUnixMicro(v any) (time.Time, error) {
+	s := fmt.Sprint(v)
+	usec, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q isn't a number of microseconds", s)
+	}
+	return time.UnixMicro(usec), nil
+}
+
+// templateTrunc truncates `s` to `n` characters, or, if `n` is negative, to
+// its last -n characters.
+func templateTrunc(n int, s string) string {
+	r := []rune(s)
+	switch {
+	case n >= 0 && len(r) > n:
+		return string(r[:n])
+	case n < 0 && len(r)+n > 0:
+		return string(r[len(r)+n:])
+	}
+	return s
+}
+
+// templateAbbrev truncates `s` to `width` characters, replacing the end with
+// an ellipsis if anything was removed.
+func templateAbbrev(width int, s string) string {
+	r := []rune(s)
+	if width < 4 || len(r) <= width {
+		return s
+	}
+	return string(r[:width-3]) + "..."
+}
+
+func templateJoin(sep string, v any) string {
+	list, ok := v.([]any)
+	if !ok {
+		return fmt.Sprint(v)
+	}
+	parts := make([]string, 0, len(list))
+	for _, item := range list {
+		parts = append(parts, fmt.Sprint(item))
+	}
+	return strings.Join(parts, sep)
+}
+
+// templateDefault returns `def` if `v` is missing or empty.
+func templateDefault(def, v any) any {
+	switch v := v.(type) {
+	case nil:
+		return def
+	case string:
+		if v == "" {
+			return def
+		}
+	case []any:
+		if len(v) == 0 {
+			return def
+		}
+	case map[string]any:
+		if len(v) == 0 {
+			return def
+		}
+	case bool:
+		if !v {
+			return def
+		}
+	}
+	return v
+}
+
+func templateToJSON(v any) (string, error) {
+	js, err := json.Marshal(v)
+	return string(js), err
+}
@@ -0,0 +1,139 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+	outputv1 "aalyria.com/spacetime/github/tools/nbictl/output/v1"
+)
+
+func renderTestTemplate(t *testing.T, text string, js string) string {
+	t.Helper()
+
+	tmpl, err := parseOutputTemplate(goTemplateFormatPrefix + text)
+	checkErr(t, err)
+	buf := &bytes.Buffer{}
+	checkErr(t, executeTemplate(buf, tmpl, []byte(js)))
+	return buf.String()
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	t.Parallel()
+
+	const doc = `{"name": "a-very-long-platform-name", "ts": "2024-03-04T05:06:07Z", "secs": 1709528767, "usec": "1709528767000000", "tags": ["x", "y"], "empty": ""}`
+	for _, tc := range []struct{ tmpl, want string }{
+		{`{{.name | trunc 6}}`, "a-very"},
+		{`{{.name | trunc -4}}`, "name"},
+		{`{{.name | abbrev 10}}`, "a-very-..."},
+		{`{{.name | abbrev 100}}`, "a-very-long-platform-name"},
+		{`{{.ts | date "2006-01-02 15:04"}}`, "2024-03-04 05:06"},
+		{`{{.secs | date "2006-01-02"}}`, time.Unix(1709528767, 0).Format("2006-01-02")},
+		{`{{.usec | fromUnixMicro | unixEpoch}}`, "1709528767"},
+		{`{{.ts | unixEpoch}}`, "1709528767"},
+		{`{{(toDate "2006-01-02" "2024-03-04").Year}}`, "2024"},
+		{`{{.tags | join ","}}`, "x,y"},
+		{`{{.empty | default "none"}} {{.missing | default "none"}} {{.name | default "none" | upper | trunc 3}}`, "none none A-V"},
+		{`{{.tags | toJson}}`, `["x","y"]`},
+		{`{{if contains "long" .name}}{{.name | replace "-" "_"}}{{end}}`, "a_very_long_platform_name"},
+		{`{{.secs}}`, "1709528767"},
+	} {
+		if got := renderTestTemplate(t, tc.tmpl, doc); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.tmpl, got, tc.want)
+		}
+	}
+}
+
+func TestParseOutputTemplate(t *testing.T) {
+	t.Parallel()
+
+	if tmpl, err := parseOutputTemplate("json"); tmpl != nil || err != nil {
+		t.Errorf(`parseOutputTemplate("json") = %v, %v; want nil, nil`, tmpl, err)
+	}
+	if _, err := parseOutputTemplate(goTemplateFormatPrefix + "{{.id"); err == nil || !strings.Contains(err.Error(), "invalid output template") {
+		t.Errorf("expected an unterminated action to be rejected, got %v", err)
+	}
+	if _, err := parseOutputTemplate(goTemplateFileFormatPrefix + filepath.Join(t.TempDir(), "missing.tmpl")); err == nil {
+		t.Error("expected a missing template file to be rejected, got nil")
+	}
+
+	path := filepath.Join(t.TempDir(), "id.tmpl")
+	checkErr(t, os.WriteFile(path, []byte(`{{.id}}`), 0o600))
+	tmpl, err := parseOutputTemplate(goTemplateFileFormatPrefix + path)
+	checkErr(t, err)
+	buf := &bytes.Buffer{}
+	checkErr(t, executeTemplate(buf, tmpl, []byte(`{"id": "from-file"}`)))
+	if got := buf.String(); got != "from-file" {
+		t.Errorf("got %q, want %q", got, "from-file")
+	}
+}
+
+func TestGetEntity_template(t *testing.T) {
+	t.Parallel()
+
+	tmpl, err := parseOutputTemplate(goTemplateFormatPrefix + `{{.id}} {{.platform.name}} {{.group.type}} {{.commitTimestamp}}`)
+	checkErr(t, err)
+	client := &getOnlyNetOpsClient{entity: parseTestEntities(t, projectionTestPlatform)[0]}
+	streams, stdout, _ := newTestStreams()
+	opts := GetOptions{Type: nbipb.EntityType_PLATFORM_DEFINITION, ID: "sat", Output: EntityOutputOptions{Template: tmpl}}
+	checkErr(t, GetEntity(context.Background(), client, opts, streams))
+
+	if got, want := stdout.String(), "sat Satellite PLATFORM_DEFINITION 123"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestListEntities_template(t *testing.T) {
+	t.Parallel()
+
+	tmpl, err := parseOutputTemplate(goTemplateFormatPrefix + `{{range .entities}}{{.id}}={{.platform.name}}{{"\n"}}{{end}}`)
+	checkErr(t, err)
+	client := &listOnlyNetOpsClient{entities: parseTestEntities(t, projectionTestPlatform)}
+	streams, stdout, _ := newTestStreams()
+	opts := ListOptions{Type: nbipb.EntityType_PLATFORM_DEFINITION, Output: EntityOutputOptions{Template: tmpl}}
+	checkErr(t, ListEntities(context.Background(), client, opts, streams))
+
+	if got, want := stdout.String(), "sat=Satellite\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	opts.Joins = []string{"platform.name"}
+	if err := ListEntities(context.Background(), client, opts, streams); err == nil || !strings.Contains(err.Error(), "can't be combined") {
+		t.Errorf("expected joins to be rejected with a template, got %v", err)
+	}
+}
+
+func TestWriteDocument_template(t *testing.T) {
+	t.Parallel()
+
+	tmpl, err := parseOutputTemplate(goTemplateFormatPrefix + `{{.kind}}: {{.succeeded}} ok, {{range .results}}{{.entityId}}{{end}}`)
+	checkErr(t, err)
+	result := outputv1.NewBatchResult(outputv1.OperationCreate)
+	result.Succeeded = 1
+	result.Results = append(result.Results, outputv1.EntityResult{EntityType: "NETWORK_NODE", EntityID: "n1"})
+
+	buf := &bytes.Buffer{}
+	checkErr(t, writeDocument(buf, tmpl, TimestampsRFC3339, result))
+	if got, want := buf.String(), "BatchResult: 1 ok, n1"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
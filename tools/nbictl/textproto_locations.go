@@ -0,0 +1,203 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// textprotoLocations maps the paths of the fields in a textproto file, such
+// as "entity[1].platform.coordinates", to the line they appear on. Elements
+// of repeated fields are indexed in the order they appear in the file, which
+// matches the order of the elements once parsed.
+//
+// prototext doesn't expose the position of parsed fields, so the locations
+// are recovered by re-scanning the file. The scanner assumes the file has
+// already been parsed successfully.
+type textprotoLocations map[string]int
+
+// line returns the line of the field at `path`, or of its closest ancestor
+// that appears in the file. It returns 0 if none of them do.
+func (l textprotoLocations) line(path string) int {
+	for path != "" {
+		if line, ok := l[path]; ok {
+			return line
+		}
+		i := strings.LastIndexByte(path, '.')
+		if i < 0 {
+			break
+		}
+		path = path[:i]
+	}
+	return 0
+}
+
+type textprotoToken struct {
+	text string
+	line int
+}
+
+func (t textprotoToken) isString() bool {
+	return strings.HasPrefix(t.text, `"`) || strings.HasPrefix(t.text, `'`)
+}
+
+func tokenizeTextproto(data []byte) []textprotoToken {
+	toks := []textprotoToken{}
+	line := 1
+	for i := 0; i < len(data); {
+		c := data[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '#':
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+		case c == '"' || c == '\'':
+			start := i
+			for i++; i < len(data) && data[i] != c && data[i] != '\n'; i++ {
+				if data[i] == '\\' {
+					i++
+				}
+			}
+			i = min(i+1, len(data))
+			toks = append(toks, textprotoToken{text: string(data[start:i]), line: line})
+		case strings.IndexByte("{}<>[]:;,", c) >= 0:
+			toks = append(toks, textprotoToken{text: string(c), line: line})
+			i++
+		default:
+			start := i
+			for i < len(data) && strings.IndexByte(" \t\r\n#\"'{}<>[]:;,", data[i]) < 0 {
+				i++
+			}
+			toks = append(toks, textprotoToken{text: string(data[start:i]), line: line})
+		}
+	}
+	return toks
+}
+
+type textprotoFrame struct {
+	desc   protoreflect.MessageDescriptor
+	prefix string
+	counts map[string]int
+
+	// listOf is set for frames that represent a `field: [...]` list, and
+	// points to the frame of the message containing the field.
+	listOf    *textprotoFrame
+	listField string
+}
+
+// indexTextprotoLocations scans `data`, a textproto representation of a
+// message described by `desc`, and returns the locations of its fields.
+func indexTextprotoLocations(data []byte, desc protoreflect.MessageDescriptor) textprotoLocations {
+	locs := textprotoLocations{}
+	toks := tokenizeTextproto(data)
+	stack := []*textprotoFrame{{desc: desc, counts: map[string]int{}}}
+
+	next := func(i *int) textprotoToken {
+		if *i >= len(toks) {
+			return textprotoToken{}
+		}
+		*i++
+		return toks[*i-1]
+	}
+	push := func(desc protoreflect.MessageDescriptor, path string) {
+		stack = append(stack, &textprotoFrame{desc: desc, prefix: path + ".", counts: map[string]int{}})
+	}
+	pop := func() {
+		if len(stack) > 1 {
+			stack = stack[:len(stack)-1]
+		}
+	}
+
+	for i := 0; i < len(toks); {
+		top := stack[len(stack)-1]
+		tok := next(&i)
+
+		if parent := top.listOf; parent != nil {
+			path := fmt.Sprintf("%s%s[%d]", parent.prefix, top.listField, parent.counts[top.listField])
+			switch tok.text {
+			case "]":
+				pop()
+			case ",":
+			case "{", "<":
+				parent.counts[top.listField]++
+				locs[path] = tok.line
+				push(top.desc, path)
+			default:
+				parent.counts[top.listField]++
+				locs[path] = tok.line
+			}
+			continue
+		}
+
+		switch {
+		case tok.text == "}" || tok.text == ">":
+			pop()
+			continue
+		case tok.text == "," || tok.text == ";" || tok.isString():
+			// Separators and the continuations of concatenated strings.
+			continue
+		}
+
+		name := tok.text
+		if name == "[" {
+			// Extension or Any type URL.
+			parts := []string{}
+			for t := next(&i); t.text != "]" && t.text != ""; t = next(&i) {
+				parts = append(parts, t.text)
+			}
+			name = "[" + strings.Join(parts, "") + "]"
+		}
+
+		var fd protoreflect.FieldDescriptor
+		if top.desc != nil {
+			fd = top.desc.Fields().ByTextName(name)
+		}
+		var childDesc protoreflect.MessageDescriptor
+		if fd != nil {
+			childDesc = fd.Message()
+		}
+		path := top.prefix + name
+		if fd != nil && (fd.IsList() || fd.IsMap()) {
+			path = fmt.Sprintf("%s[%d]", path, top.counts[name])
+		}
+
+		value := next(&i)
+		if value.text == ":" {
+			value = next(&i)
+		}
+		switch value.text {
+		case "[":
+			stack = append(stack, &textprotoFrame{desc: childDesc, listOf: top, listField: name})
+			continue
+		case "{", "<":
+			push(childDesc, path)
+		}
+		if fd != nil && (fd.IsList() || fd.IsMap()) {
+			top.counts[name]++
+		}
+		if _, ok := locs[path]; !ok {
+			locs[path] = tok.line
+		}
+	}
+	return locs
+}
@@ -0,0 +1,382 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fullstorydev/grpcurl"
+	"github.com/urfave/cli/v2"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/runtime/protoiface"
+)
+
+// TimestampFormat selects how timestamps are written in JSON output.
+type TimestampFormat string
+
+const (
+	// TimestampsRFC3339 writes timestamps as RFC 3339 strings, such as
+	// "2024-05-01T12:00:00Z". It's the default.
+	TimestampsRFC3339 TimestampFormat = "rfc3339"
+	// TimestampsEpochMillis writes timestamps as the number of milliseconds
+	// since the Unix epoch, truncating anything finer.
+	TimestampsEpochMillis TimestampFormat = "epoch_millis"
+)
+
+const timestampMessageName = "google.protobuf.Timestamp"
+
+var jsonTimestampsFlag = &cli.StringFlag{
+	Name:    "json_timestamps",
+	Usage:   "How timestamps are written in JSON output: documents printed with --output=json, responses printed by `invoke`, and diagnostic logs with --log_format=json. Allowed values: [rfc3339, epoch_millis]. Templates and entity files, which nbictl reads back, always use RFC 3339.",
+	Value:   string(TimestampsRFC3339),
+	EnvVars: []string{"NBICTL_JSON_TIMESTAMPS"},
+	Action: func(_ *cli.Context, f string) error {
+		_, err := parseTimestampFormat(f)
+		return err
+	},
+}
+
+func parseTimestampFormat(s string) (TimestampFormat, error) {
+	switch f := TimestampFormat(s); f {
+	case "", TimestampsRFC3339, TimestampsEpochMillis:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown timestamp format %q (must be %s or %s)", s, TimestampsRFC3339, TimestampsEpochMillis)
+	}
+}
+
+func timestampFormatFromFlags(appCtx *cli.Context) TimestampFormat {
+	// The flag's Action has already validated the value.
+	f, _ := parseTimestampFormat(appCtx.String(jsonTimestampsFlag.Name))
+	return f
+}
+
+// formatDocument rewrites the timestamps of the JSON encoding `js` of the
+// outputv1 document `v` according to `f`.
+func (f TimestampFormat) formatDocument(js []byte, v any) ([]byte, error) {
+	return f.rewrite(js, goTimestampNode(reflect.TypeOf(v)))
+}
+
+// formatMessage rewrites the google.protobuf.Timestamp fields of the JSON
+// encoding `js` of a message described by `md` according to `f`.
+func (f TimestampFormat) formatMessage(js []byte, md protoreflect.MessageDescriptor) ([]byte, error) {
+	return f.rewrite(js, protoTimestampNode(md, false))
+}
+
+// grpcurlFormatter wraps the JSON formatter of `invoke` so that it writes
+// timestamps according to `f`. Messages that aren't linked into nbictl,
+// such as those only known to the server's reflection service, are left
+// as they are.
+func (f TimestampFormat) grpcurlFormatter(next grpcurl.Formatter) grpcurl.Formatter {
+	if f != TimestampsEpochMillis {
+		return next
+	}
+	return func(m protoiface.MessageV1) (string, error) {
+		out, err := next(m)
+		if err != nil {
+			return "", err
+		}
+		named, ok := m.(interface{ XXX_MessageName() string })
+		if !ok {
+			return out, nil
+		}
+		d, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(named.XXX_MessageName()))
+		if err != nil {
+			return out, nil
+		}
+		md, ok := d.(protoreflect.MessageDescriptor)
+		if !ok {
+			return out, nil
+		}
+		js, err := f.formatMessage([]byte(out), md)
+		if err != nil {
+			return "", err
+		}
+		indented := &bytes.Buffer{}
+		if err := json.Indent(indented, js, "", "  "); err != nil {
+			return "", err
+		}
+		return indented.String(), nil
+	}
+}
+
+// timestampNode describes which values of a JSON document are timestamps.
+// A nil node means nothing at or below that point is.
+type timestampNode interface {
+	isTimestamp() bool
+	// field returns the node of the value of the object's `name` member.
+	field(name string) timestampNode
+	// elem returns the node of the elements of an array.
+	elem() timestampNode
+}
+
+// anyTimestampNode is implemented by nodes of google.protobuf.Any messages,
+// whose contents are only known once their "@type" has been read.
+type anyTimestampNode interface {
+	resolve(typeURL string) timestampNode
+}
+
+// rewrite returns `js` with the values that `root` identifies as RFC 3339
+// timestamps converted according to `f`. The order of object members is
+// preserved, but the result is compact.
+func (f TimestampFormat) rewrite(js []byte, root timestampNode) ([]byte, error) {
+	if f != TimestampsEpochMillis || root == nil {
+		return js, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+	out := &bytes.Buffer{}
+	if err := rewriteTimestamps(dec, out, root); err != nil {
+		return nil, fmt.Errorf("formatting timestamps: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
+func rewriteTimestamps(dec *json.Decoder, out *bytes.Buffer, n timestampNode) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	switch tok := tok.(type) {
+	case json.Delim:
+		if tok == '[' {
+			return rewriteArray(dec, out, n)
+		}
+		return rewriteObject(dec, out, n)
+	case string:
+		if n != nil && n.isTimestamp() {
+			if t, err := time.Parse(time.RFC3339Nano, tok); err == nil {
+				out.WriteString(strconv.FormatInt(t.UnixMilli(), 10))
+				return nil
+			}
+		}
+		return writeJSONString(out, tok)
+	case json.Number:
+		out.WriteString(tok.String())
+	case bool:
+		out.WriteString(strconv.FormatBool(tok))
+	case nil:
+		out.WriteString("null")
+	}
+	return nil
+}
+
+func rewriteObject(dec *json.Decoder, out *bytes.Buffer, n timestampNode) error {
+	out.WriteByte('{')
+	for i := 0; dec.More(); i++ {
+		if i > 0 {
+			out.WriteByte(',')
+		}
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key := tok.(string)
+		if err := writeJSONString(out, key); err != nil {
+			return err
+		}
+		out.WriteByte(':')
+
+		var child timestampNode
+		if n != nil {
+			child = n.field(key)
+		}
+		if a, ok := n.(anyTimestampNode); ok && key == "@type" {
+			tok, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			url, _ := tok.(string)
+			// The remaining members are those of the packed message.
+			n = a.resolve(url)
+			if err := writeJSONString(out, url); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := rewriteTimestamps(dec, out, child); err != nil {
+			return err
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	out.WriteByte('}')
+	return nil
+}
+
+func rewriteArray(dec *json.Decoder, out *bytes.Buffer, n timestampNode) error {
+	out.WriteByte('[')
+	var elem timestampNode
+	if n != nil {
+		elem = n.elem()
+	}
+	for i := 0; dec.More(); i++ {
+		if i > 0 {
+			out.WriteByte(',')
+		}
+		if err := rewriteTimestamps(dec, out, elem); err != nil {
+			return err
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	out.WriteByte(']')
+	return nil
+}
+
+func writeJSONString(out *bytes.Buffer, s string) error {
+	js, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	out.Write(js)
+	return nil
+}
+
+// goNode finds the time.Time values of a document encoded by encoding/json.
+type goNode struct{ t reflect.Type }
+
+func goTimestampNode(t reflect.Type) timestampNode {
+	if t == nil {
+		return nil
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return goNode{t: t}
+}
+
+func (n goNode) isTimestamp() bool { return n.t == reflect.TypeFor[time.Time]() }
+
+func (n goNode) elem() timestampNode {
+	if n.t.Kind() == reflect.Slice || n.t.Kind() == reflect.Array {
+		return goTimestampNode(n.t.Elem())
+	}
+	return nil
+}
+
+func (n goNode) field(name string) timestampNode {
+	switch n.t.Kind() {
+	case reflect.Map:
+		return goTimestampNode(n.t.Elem())
+	case reflect.Struct:
+		if t, ok := jsonFieldType(n.t, name); ok {
+			return goTimestampNode(t)
+		}
+	}
+	return nil
+}
+
+// jsonFieldType returns the type of the field of the struct `t`, or of the
+// structs embedded in it, that encoding/json encodes as `name`.
+func jsonFieldType(t reflect.Type, name string) (reflect.Type, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if f.Anonymous && tag == "" && f.Type.Kind() == reflect.Struct {
+			if ft, ok := jsonFieldType(f.Type, name); ok {
+				return ft, true
+			}
+			continue
+		}
+		if tag == "" {
+			tag = f.Name
+		}
+		if tag == name {
+			return f.Type, true
+		}
+	}
+	return nil, false
+}
+
+// protoNode finds the google.protobuf.Timestamp values of a message encoded
+// by protojson or jsonpb, which name fields by their JSON or proto names.
+type protoNode struct {
+	md protoreflect.MessageDescriptor
+	// repeated is set for the values of list and map fields, which hold
+	// messages described by `md`.
+	repeated bool
+	// packed is set for a google.protobuf.Any holding a well-known type,
+	// whose JSON encoding is found in its "value" member.
+	packed bool
+}
+
+func protoTimestampNode(md protoreflect.MessageDescriptor, repeated bool) timestampNode {
+	if md == nil {
+		return nil
+	}
+	return protoNode{md: md, repeated: repeated}
+}
+
+func (n protoNode) isTimestamp() bool {
+	return !n.repeated && !n.packed && n.md.FullName() == timestampMessageName
+}
+
+func (n protoNode) elem() timestampNode {
+	if n.repeated {
+		return protoTimestampNode(n.md, false)
+	}
+	return nil
+}
+
+func (n protoNode) field(name string) timestampNode {
+	switch {
+	case n.repeated:
+		// A map entry.
+		return protoTimestampNode(n.md, false)
+	case n.packed:
+		if name == "value" {
+			return protoTimestampNode(n.md, false)
+		}
+		return nil
+	}
+	fields := n.md.Fields()
+	fd := fields.ByJSONName(name)
+	if fd == nil {
+		fd = fields.ByName(protoreflect.Name(name))
+	}
+	switch {
+	case fd == nil:
+		return nil
+	case fd.IsMap():
+		return protoTimestampNode(fd.MapValue().Message(), true)
+	default:
+		return protoTimestampNode(fd.Message(), fd.IsList())
+	}
+}
+
+func (n protoNode) resolve(typeURL string) timestampNode {
+	if n.repeated || n.md.FullName() != "google.protobuf.Any" {
+		return n
+	}
+	mt, err := protoregistry.GlobalTypes.FindMessageByURL(typeURL)
+	if err != nil {
+		return nil
+	}
+	md := mt.Descriptor()
+	return protoNode{md: md, packed: md.FullName() == timestampMessageName}
+}
@@ -0,0 +1,230 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/genproto/googleapis/type/interval"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+	outputv1 "aalyria.com/spacetime/github/tools/nbictl/output/v1"
+)
+
+var testTimestamp = time.Date(2024, 5, 1, 12, 0, 0, 123456789, time.UTC)
+
+// decodeJSON decodes `js` for comparison, keeping numbers exact.
+func decodeJSON(t *testing.T, js []byte) any {
+	t.Helper()
+
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		t.Fatalf("decoding %s: %v", js, err)
+	}
+	return v
+}
+
+func TestWriteDocument_timestamps(t *testing.T) {
+	t.Parallel()
+
+	list := outputv1.NewContactWindowList("a", "b")
+	list.Windows = append(list.Windows, outputv1.ContactWindow{Start: testTimestamp, End: testTimestamp.Add(time.Minute), DurationSeconds: 60})
+
+	for _, tc := range []struct {
+		name       string
+		ts         TimestampFormat
+		start, end any
+	}{
+		{name: "default", ts: "", start: "2024-05-01T12:00:00.123456789Z", end: "2024-05-01T12:01:00.123456789Z"},
+		{name: "rfc3339", ts: TimestampsRFC3339, start: "2024-05-01T12:00:00.123456789Z", end: "2024-05-01T12:01:00.123456789Z"},
+		{name: "epoch_millis", ts: TimestampsEpochMillis, start: json.Number("1714564800123"), end: json.Number("1714564860123")},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			out := &bytes.Buffer{}
+			if err := writeDocument(out, nil, tc.ts, list); err != nil {
+				t.Fatal(err)
+			}
+			want := map[string]any{
+				"apiVersion": outputv1.APIVersion,
+				"kind":       "ContactWindowList",
+				"platforms":  []any{"a", "b"},
+				"windows": []any{map[string]any{
+					"start":           tc.start,
+					"end":             tc.end,
+					"durationSeconds": json.Number("60"),
+				}},
+			}
+			if diff := cmp.Diff(want, decodeJSON(t, out.Bytes())); diff != "" {
+				t.Errorf("unexpected document (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWriteDocument_templatesSeeRFC3339(t *testing.T) {
+	t.Parallel()
+
+	tmpl, err := parseOutputTemplate(`go-template={{range .keys}}{{.notAfter}}{{end}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := outputv1.NewKeyList()
+	list.Keys = append(list.Keys, outputv1.Key{Name: "k", NotAfter: testTimestamp})
+
+	out := &bytes.Buffer{}
+	if err := writeDocument(out, tmpl, TimestampsEpochMillis, list); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "2024-05-01T12:00:00.123456789Z"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestTimestampFormat_formatMessage(t *testing.T) {
+	t.Parallel()
+
+	req := &nbipb.SignalPropagationRequest{
+		AnalysisTime: &nbipb.SignalPropagationRequest_AnalysisInterval{
+			AnalysisInterval: &interval.Interval{StartTime: timestamppb.New(testTimestamp), EndTime: timestamppb.New(testTimestamp)},
+		},
+		ReferenceDataTime:      timestamppb.New(testTimestamp),
+		ExplainInaccessibility: proto.Bool(true),
+	}
+	packedReq, err := anypb.New(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packedTimestamp, err := anypb.New(timestamppb.New(testTimestamp))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	millis := json.Number("1714564800123")
+	for _, tc := range []struct {
+		name string
+		msg  proto.Message
+		opts protojson.MarshalOptions
+		want map[string]any
+	}{
+		{
+			name: "json names",
+			msg:  req,
+			want: map[string]any{
+				"analysisInterval":       map[string]any{"startTime": millis, "endTime": millis},
+				"referenceDataTime":      millis,
+				"explainInaccessibility": true,
+			},
+		},
+		{
+			name: "proto names",
+			msg:  req,
+			opts: protojson.MarshalOptions{UseProtoNames: true},
+			want: map[string]any{
+				"analysis_interval":       map[string]any{"start_time": millis, "end_time": millis},
+				"reference_data_time":     millis,
+				"explain_inaccessibility": true,
+			},
+		},
+		{
+			name: "packed message",
+			msg:  packedReq,
+			want: map[string]any{
+				"@type":                  "type.googleapis.com/aalyria.spacetime.api.nbi.v1alpha.SignalPropagationRequest",
+				"analysisInterval":       map[string]any{"startTime": millis, "endTime": millis},
+				"referenceDataTime":      millis,
+				"explainInaccessibility": true,
+			},
+		},
+		{
+			name: "packed timestamp",
+			msg:  packedTimestamp,
+			want: map[string]any{
+				"@type": "type.googleapis.com/google.protobuf.Timestamp",
+				"value": millis,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			js, err := tc.opts.Marshal(tc.msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := TimestampsEpochMillis.formatMessage(js, tc.msg.ProtoReflect().Descriptor())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(any(tc.want), decodeJSON(t, got)); diff != "" {
+				t.Errorf("unexpected JSON (-want +got):\n%s", diff)
+			}
+
+			unchanged, err := TimestampsRFC3339.formatMessage(js, tc.msg.ProtoReflect().Descriptor())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(unchanged, js) {
+				t.Errorf("rfc3339 changed the JSON to %s, want %s", unchanged, js)
+			}
+		})
+	}
+}
+
+func TestLogConfig_epochMillis(t *testing.T) {
+	t.Parallel()
+
+	out := &bytes.Buffer{}
+	conf := logConfig{json: true, level: slog.LevelInfo, timestamps: TimestampsEpochMillis}
+	logger := conf.logger(logModuleBulk, out)
+	logger.Info("hello", slog.Time("at", testTimestamp))
+
+	got, ok := decodeJSON(t, out.Bytes()).(map[string]any)
+	if !ok {
+		t.Fatalf("got %s, want a JSON object", out)
+	}
+	if _, ok := got["time"].(json.Number); !ok {
+		t.Errorf("got time %v, want milliseconds since the epoch", got["time"])
+	}
+	// Only the time of the record is rewritten.
+	if got["at"] != "2024-05-01T12:00:00.123456789Z" {
+		t.Errorf("got at %v, want it unchanged", got["at"])
+	}
+}
+
+func TestParseTimestampFormat(t *testing.T) {
+	t.Parallel()
+
+	for _, s := range []string{"", "rfc3339", "epoch_millis"} {
+		if _, err := parseTimestampFormat(s); err != nil {
+			t.Errorf("parseTimestampFormat(%q): %v", s, err)
+		}
+	}
+	if _, err := parseTimestampFormat("epoch_seconds"); err == nil {
+		t.Error("parseTimestampFormat(\"epoch_seconds\") succeeded, want an error")
+	}
+}
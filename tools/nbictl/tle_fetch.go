@@ -0,0 +1,157 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	defaultCelestrakURL  = "https://celestrak.org"
+	defaultSpaceTrackURL = "https://www.space-track.org"
+
+	// minTLEFetchInterval is the shortest --refresh interval allowed when
+	// fetching from Celestrak or Space-Track, which both ask clients not to
+	// download the same element sets more often than they're published.
+	minTLEFetchInterval = time.Hour
+	tleFetchTimeout     = time.Minute
+	// maxTLEResponseBytes bounds the size of a response, which is far larger
+	// than the largest Celestrak group.
+	maxTLEResponseBytes = 64 << 20
+)
+
+// FetchCelestrakTLEs fetches the current element sets of a Celestrak group
+// of satellites, such as "stations" or "starlink", from the Celestrak
+// instance at `baseURL`.
+func FetchCelestrakTLEs(ctx context.Context, httpClient *http.Client, baseURL, group string) ([]SatelliteTLE, error) {
+	u := strings.TrimSuffix(baseURL, "/") + "/NORAD/elements/gp.php?" + url.Values{"GROUP": {group}, "FORMAT": {"tle"}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	tles, err := fetchTLEs(httpClient, req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching Celestrak group %q: %w", group, err)
+	}
+	return tles, nil
+}
+
+// SpaceTrackCredentials are the credentials of a Space-Track account.
+type SpaceTrackCredentials struct {
+	Identity, Password string
+}
+
+// FetchSpaceTrackTLEs logs into the Space-Track instance at `baseURL` and
+// fetches the latest element sets of the satellites with the provided NORAD
+// IDs.
+func FetchSpaceTrackTLEs(ctx context.Context, httpClient *http.Client, baseURL string, creds SpaceTrackCredentials, noradIDs []string) ([]SatelliteTLE, error) {
+	if len(noradIDs) == 0 {
+		return nil, errors.New("fetching from Space-Track requires the NORAD IDs of the satellites")
+	}
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	// The session is kept in a cookie, so use a client with its own jar.
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	c := *httpClient
+	c.Jar = jar
+
+	login, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+"/ajaxauth/login",
+		strings.NewReader(url.Values{"identity": {creds.Identity}, "password": {creds.Password}}.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	login.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	body, err := doTLERequest(&c, login)
+	if err != nil {
+		return nil, fmt.Errorf("logging into Space-Track: %w", err)
+	}
+	// Space-Track reports failed logins with a successful response.
+	if strings.Contains(string(body), `"Login":"Failed"`) {
+		return nil, errors.New("logging into Space-Track: invalid identity or password")
+	}
+	defer func() {
+		if logout, err := http.NewRequestWithContext(context.WithoutCancel(ctx), http.MethodGet, baseURL+"/ajaxauth/logout", nil); err == nil {
+			doTLERequest(&c, logout)
+		}
+	}()
+
+	ids := []string{}
+	for _, id := range noradIDs {
+		ids = append(ids, url.PathEscape(id))
+	}
+	slices.Sort(ids)
+	query, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/basicspacedata/query/class/gp/NORAD_CAT_ID/"+strings.Join(ids, ",")+"/orderby/NORAD_CAT_ID/format/3le", nil)
+	if err != nil {
+		return nil, err
+	}
+	tles, err := fetchTLEs(&c, query)
+	if err != nil {
+		return nil, fmt.Errorf("fetching from Space-Track: %w", err)
+	}
+	return tles, nil
+}
+
+func fetchTLEs(httpClient *http.Client, req *http.Request) ([]SatelliteTLE, error) {
+	body, err := doTLERequest(httpClient, req)
+	if err != nil {
+		return nil, err
+	}
+	tles, err := ReadTLEs(strings.NewReader(string(body)))
+	switch {
+	case err != nil:
+		return nil, err
+	case len(tles) == 0:
+		// Celestrak answers unknown groups with a message instead of an
+		// error status.
+		return nil, fmt.Errorf("no element sets found: %q", responseSnippet(body))
+	}
+	return tles, nil
+}
+
+func doTLERequest(httpClient *http.Client, req *http.Request) ([]byte, error) {
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxTLEResponseBytes))
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %q", res.Status, responseSnippet(body))
+	}
+	return body, nil
+}
+
+// responseSnippet returns the start of a response body, for errors.
+func responseSnippet(body []byte) string {
+	s := strings.TrimSpace(string(body))
+	if len(s) > 100 {
+		s = s[:100] + "..."
+	}
+	return s
+}
@@ -0,0 +1,130 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const issThreeLineTLE = "ISS (ZARYA)\n" + issTLELine1 + "\n" + issTLELine2 + "\n"
+
+func TestFetchCelestrakTLEs(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path != "/NORAD/elements/gp.php" || r.URL.Query().Get("FORMAT") != "tle":
+			http.NotFound(w, r)
+		case r.URL.Query().Get("GROUP") == "stations":
+			fmt.Fprint(w, issThreeLineTLE)
+		default:
+			fmt.Fprint(w, "No GP data found")
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	tles, err := FetchCelestrakTLEs(context.Background(), srv.Client(), srv.URL+"/", "stations")
+	checkErr(t, err)
+	if len(tles) != 1 || tles[0].Name != "ISS (ZARYA)" || tles[0].NoradID != "25544" {
+		t.Errorf("unexpected element sets: %+v", tles)
+	}
+
+	_, err = FetchCelestrakTLEs(context.Background(), srv.Client(), srv.URL, "unknown")
+	if err == nil || !strings.Contains(err.Error(), `no element sets found: "No GP data found"`) {
+		t.Errorf("expected an error about the missing group, got %v", err)
+	}
+}
+
+func TestFetchSpaceTrackTLEs(t *testing.T) {
+	t.Parallel()
+
+	mu := sync.Mutex{}
+	loggedOut := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch r.URL.Path {
+		case "/ajaxauth/login":
+			if r.Method != http.MethodPost || r.PostFormValue("identity") != "user" || r.PostFormValue("password") != "secret" {
+				fmt.Fprint(w, `{"Login":"Failed"}`)
+				return
+			}
+			http.SetCookie(w, &http.Cookie{Name: "chocolatechip", Value: "session", Path: "/"})
+		case "/ajaxauth/logout":
+			loggedOut = true
+		case "/basicspacedata/query/class/gp/NORAD_CAT_ID/25544,5/orderby/NORAD_CAT_ID/format/3le":
+			if c, err := r.Cookie("chocolatechip"); err != nil || c.Value != "session" {
+				http.Error(w, "not logged in", http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, "0 VANGUARD 1\n"+vanguardTLELine1+"\n"+vanguardTLELine2+"\n0 "+issThreeLineTLE)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	tles, err := FetchSpaceTrackTLEs(context.Background(), srv.Client(), srv.URL, SpaceTrackCredentials{Identity: "user", Password: "secret"}, []string{"5", "25544"})
+	checkErr(t, err)
+	got := []string{}
+	for _, tle := range tles {
+		got = append(got, tle.NoradID+" "+tle.Name)
+	}
+	if want := "5 VANGUARD 1, 25544 ISS (ZARYA)"; strings.Join(got, ", ") != want {
+		t.Errorf("expected element sets %s, got %s", want, strings.Join(got, ", "))
+	}
+	mu.Lock()
+	if !loggedOut {
+		t.Error("the session wasn't logged out")
+	}
+	mu.Unlock()
+
+	_, err = FetchSpaceTrackTLEs(context.Background(), srv.Client(), srv.URL, SpaceTrackCredentials{Identity: "user", Password: "wrong"}, []string{"5"})
+	if err == nil || !strings.Contains(err.Error(), "invalid identity or password") {
+		t.Errorf("expected a login error, got %v", err)
+	}
+}
+
+func TestImportTLE_rejectsInvalidSources(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name    string
+		args    []string
+		wantErr string
+	}{
+		{"no source", nil, "one of --file, --celestrak_group, or --space_track is required"},
+		{"two sources", []string{"--file", "sats.tle", "--celestrak_group", "stations"}, "only one of"},
+		{"no credentials", []string{"--space_track", "--norad_ids", "25544"}, "requires --space_track_identity"},
+		{"fetching too often", []string{"--celestrak_group", "stations", "--refresh", "10m"}, "--refresh must be at least 1h0m0s"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			app := newTestApp()
+			err := app.Run(append([]string{"nbictl", "import-tle"}, tc.args...))
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("expected error to contain %q, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
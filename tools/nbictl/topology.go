@@ -0,0 +1,425 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"google.golang.org/protobuf/proto"
+
+	commonpb "aalyria.com/spacetime/api/common"
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+	resourcespb "aalyria.com/spacetime/api/nbi/v1alpha/resources"
+)
+
+// Formats of the `topology` command.
+const (
+	topologyFormatDOT  = "dot"
+	topologyFormatText = "text"
+)
+
+// Statuses of a link at a point in time.
+const (
+	linkStatusUp       = "up"
+	linkStatusMarginal = "marginal"
+	linkStatusDown     = "down"
+	linkStatusUnknown  = "unknown"
+)
+
+// linkStatusStyles are the Graphviz color and style of the edges of links
+// with each status.
+var linkStatusStyles = map[string][2]string{
+	linkStatusUp:       {"forestgreen", "solid"},
+	linkStatusMarginal: {"orange", "solid"},
+	linkStatusDown:     {"red", "dashed"},
+	linkStatusUnknown:  {"gray", "dotted"},
+}
+
+// topologyEntityTypes are the types of the entities that describe the
+// network topology.
+var topologyEntityTypes = []nbipb.EntityType{
+	nbipb.EntityType_PLATFORM_DEFINITION,
+	nbipb.EntityType_NETWORK_NODE,
+	nbipb.EntityType_INTERFACE_LINK_REPORT,
+	nbipb.EntityType_TRANSCEIVER_LINK_REPORT,
+}
+
+// networkTopology is the network described by a set of entities: its
+// platforms, network nodes, and the links between them.
+type networkTopology struct {
+	platforms map[string]*commonpb.PlatformDefinition
+	nodes     map[string]*resourcespb.NetworkNode
+	links     []topologyLink
+}
+
+// topologyLink is a link in one direction between two interfaces (from an
+// InterfaceLinkReport) or two transceivers (from a TransceiverLinkReport).
+type topologyLink struct {
+	// entityType and entityID identify the entity that reports the link.
+	entityType nbipb.EntityType
+	entityID   string
+	src, dst   linkEndpoint
+	// intervals are the reported intervals of the link, in order.
+	intervals []linkInterval
+}
+
+// linkEndpoint is an end of a link. Interfaces are resolved to the platform
+// of their transceiver, or of their wired device, if their node is known.
+type linkEndpoint struct {
+	nodeID, interfaceID       string
+	platformID, transceiverID string
+}
+
+func (e linkEndpoint) String() string {
+	if e.nodeID != "" {
+		return e.nodeID + "/" + e.interfaceID
+	}
+	return e.platformID + "/" + e.transceiverID
+}
+
+// linkInterval is an interval during which a link has the same
+// accessibility. A zero start or end means it's open ended.
+type linkInterval struct {
+	start, end    time.Time
+	accessibility resourcespb.Accessibility
+	// dataRateBps is the modeled data rate of the link, or 0 if unknown.
+	// For wireless links, it's the highest of the sampled data rates.
+	dataRateBps float64
+}
+
+func (i linkInterval) contains(t time.Time) bool {
+	return (i.start.IsZero() || !t.Before(i.start)) && (i.end.IsZero() || t.Before(i.end))
+}
+
+// entitiesFromFlags reads the entities from the files of the --files flag
+// or, if it isn't set, lists those of each of `types` from the NBI.
+func entitiesFromFlags(appCtx *cli.Context, types []nbipb.EntityType) ([]*nbipb.Entity, error) {
+	if files := appCtx.String("files"); files != "" {
+		return readEntitiesFromFiles(files)
+	}
+	conn, err := openServiceConnection(appCtx, nbipb.NetOps_ServiceDesc.ServiceName)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return listEntitiesOfTypes(appCtx.Context, nbipb.NewNetOpsClient(conn), types)
+}
+
+// listEntitiesOfTypes lists the entities of each of `types`.
+func listEntitiesOfTypes(ctx context.Context, client nbipb.NetOpsClient, types []nbipb.EntityType) ([]*nbipb.Entity, error) {
+	entities := []*nbipb.Entity{}
+	for _, t := range types {
+		res, err := client.ListEntities(ctx, &nbipb.ListEntitiesRequest{Type: t.Enum()})
+		if err != nil {
+			return nil, fmt.Errorf("listing %s entities: %w", t, err)
+		}
+		entities = append(entities, res.GetEntities()...)
+	}
+	return entities, nil
+}
+
+// newNetworkTopology returns the topology described by `entities`, ignoring
+// those of types other than topologyEntityTypes.
+func newNetworkTopology(entities []*nbipb.Entity) *networkTopology {
+	t := &networkTopology{
+		platforms: map[string]*commonpb.PlatformDefinition{},
+		nodes:     map[string]*resourcespb.NetworkNode{},
+	}
+	for _, e := range entities {
+		switch e.GetGroup().GetType() {
+		case nbipb.EntityType_PLATFORM_DEFINITION:
+			t.platforms[e.GetId()] = e.GetPlatform()
+		case nbipb.EntityType_NETWORK_NODE:
+			t.nodes[e.GetId()] = e.GetNetworkNode()
+		}
+	}
+
+	for _, e := range entities {
+		switch e.GetGroup().GetType() {
+		case nbipb.EntityType_INTERFACE_LINK_REPORT:
+			r := e.GetInterfaceLinkReport()
+			l := topologyLink{
+				entityType: e.GetGroup().GetType(),
+				entityID:   e.GetId(),
+				src:        t.interfaceEndpoint(r.GetSrc()),
+				dst:        t.interfaceEndpoint(r.GetDst()),
+			}
+			for _, ai := range r.GetAccessIntervals() {
+				l.intervals = append(l.intervals, newLinkInterval(ai.GetInterval(), ai.GetAccessibility(), ai.GetDataRateBps()))
+			}
+			t.links = append(t.links, l)
+		case nbipb.EntityType_TRANSCEIVER_LINK_REPORT:
+			r := e.GetTransceiverLinkReport()
+			src := linkEndpoint{platformID: r.GetSrc().GetPlatformId(), transceiverID: r.GetSrc().GetTransceiverModelId()}
+			for _, wl := range r.GetLinks() {
+				l := topologyLink{
+					entityType: e.GetGroup().GetType(),
+					entityID:   e.GetId(),
+					src:        src,
+					dst:        linkEndpoint{platformID: wl.GetDst().GetPlatformId(), transceiverID: wl.GetDst().GetTransceiverModelId()},
+				}
+				for _, ai := range wl.GetAccessIntervals() {
+					dataRateBps := 0.0
+					for _, m := range ai.GetSampledMetrics() {
+						dataRateBps = max(dataRateBps, m.GetDataRateBps())
+					}
+					l.intervals = append(l.intervals, newLinkInterval(ai.GetInterval(), ai.GetAccessibility(), dataRateBps))
+				}
+				t.links = append(t.links, l)
+			}
+		}
+	}
+	for i := range t.links {
+		slices.SortFunc(t.links[i].intervals, func(a, b linkInterval) int { return a.start.Compare(b.start) })
+	}
+	slices.SortFunc(t.links, func(a, b topologyLink) int {
+		return strings.Compare(a.src.String()+" "+a.dst.String(), b.src.String()+" "+b.dst.String())
+	})
+	return t
+}
+
+func newLinkInterval(ti *commonpb.TimeInterval, a resourcespb.Accessibility, dataRateBps float64) linkInterval {
+	li := linkInterval{accessibility: a, dataRateBps: dataRateBps}
+	if st := ti.GetStartTime(); st != nil && st.UnixTimeUsec != nil {
+		li.start = time.UnixMicro(st.GetUnixTimeUsec())
+	}
+	if et := ti.GetEndTime(); et != nil && et.UnixTimeUsec != nil {
+		li.end = time.UnixMicro(et.GetUnixTimeUsec())
+	}
+	return li
+}
+
+func (t *networkTopology) interfaceEndpoint(id *commonpb.NetworkInterfaceId) linkEndpoint {
+	e := linkEndpoint{nodeID: id.GetNodeId(), interfaceID: id.GetInterfaceId()}
+	for _, ni := range t.nodes[e.nodeID].GetNodeInterface() {
+		if ni.GetInterfaceId() != e.interfaceID {
+			continue
+		}
+		if tm := ni.GetWireless().GetTransceiverModelId(); tm != nil {
+			e.platformID, e.transceiverID = tm.GetPlatformId(), tm.GetTransceiverModelId()
+		} else {
+			e.platformID = ni.GetWired().GetPlatformId()
+		}
+	}
+	return e
+}
+
+// platformIDs returns the IDs of the platforms, sorted.
+func (t *networkTopology) platformIDs() []string {
+	ids := make([]string, 0, len(t.platforms))
+	for id := range t.platforms {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// status returns the status of the link at `t`, from the interval that
+// contains it, and its data rate, if known.
+func (l topologyLink) status(t time.Time) (status string, dataRateBps float64) {
+	for _, i := range l.intervals {
+		if !i.contains(t) {
+			continue
+		}
+		switch i.accessibility {
+		case resourcespb.Accessibility_ACCESS_EXISTS:
+			return linkStatusUp, i.dataRateBps
+		case resourcespb.Accessibility_ACCESS_MARGINAL:
+			return linkStatusMarginal, i.dataRateBps
+		case resourcespb.Accessibility_NO_ACCESS:
+			return linkStatusDown, 0
+		}
+	}
+	return linkStatusUnknown, 0
+}
+
+// accessibleWindows returns the parts of [start, end) during which the link
+// is reported as accessible, in order.
+func (l topologyLink) accessibleWindows(start, end time.Time) []contactWindow {
+	windows := []contactWindow{}
+	for _, i := range l.intervals {
+		if i.accessibility != resourcespb.Accessibility_ACCESS_EXISTS {
+			continue
+		}
+		w := contactWindow{start: start, end: end}
+		if !i.start.IsZero() && i.start.After(w.start) {
+			w.start = i.start
+		}
+		if !i.end.IsZero() && i.end.Before(w.end) {
+			w.end = i.end
+		}
+		if !w.end.After(w.start) {
+			continue
+		}
+		// Merge windows that touch, such as those of consecutive intervals
+		// with different data rates.
+		if n := len(windows); n > 0 && !w.start.After(windows[n-1].end) {
+			windows[n-1].end = maxTime(windows[n-1].end, w.end)
+			continue
+		}
+		windows = append(windows, w)
+	}
+	return windows
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// ecefToGeodetic converts a position in the Earth-Centered, Earth-Fixed
+// frame, in meters, to WGS 84 geodetic coordinates, using Bowring's method.
+func ecefToGeodetic(p [3]float64) (latDeg, lonDeg, heightM float64) {
+	e2 := wgs84Flattening * (2 - wgs84Flattening)
+	ep2 := e2 / (1 - e2)
+	r := math.Hypot(p[0], p[1])
+	beta := math.Atan2(wgs84SemiMajorAxisM*p[2], wgs84SemiMinorAxisM*r)
+	lat := math.Atan2(p[2]+ep2*wgs84SemiMinorAxisM*math.Pow(math.Sin(beta), 3), r-e2*wgs84SemiMajorAxisM*math.Pow(math.Cos(beta), 3))
+	// One more iteration makes the error negligible at orbital altitudes.
+	beta = math.Atan2((1-wgs84Flattening)*math.Sin(lat), math.Cos(lat))
+	lat = math.Atan2(p[2]+ep2*wgs84SemiMinorAxisM*math.Pow(math.Sin(beta), 3), r-e2*wgs84SemiMajorAxisM*math.Pow(math.Cos(beta), 3))
+	n := wgs84SemiMajorAxisM / math.Sqrt(1-e2*math.Sin(lat)*math.Sin(lat))
+	if math.Abs(math.Cos(lat)) > 1e-9 {
+		heightM = r/math.Cos(lat) - n
+	} else {
+		heightM = math.Abs(p[2]) - wgs84SemiMinorAxisM
+	}
+	return lat * 180 / math.Pi, math.Atan2(p[1], p[0]) * 180 / math.Pi, heightM
+}
+
+// dotQuote returns `s` as a quoted Graphviz ID.
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+// topologyCluster is a group of link endpoints drawn together: those on the
+// same platform or, if it's unknown, the same network node.
+type topologyCluster struct {
+	label     string
+	endpoints map[string]bool
+}
+
+// WriteTopologyDOT writes the topology described by `entities` as a
+// Graphviz digraph, with the interfaces and transceivers as nodes grouped by
+// platform, and the links as edges annotated with their status and data
+// rate at `at`. Everything is written in a stable order, so the output of
+// two versions of a network can be diffed.
+func WriteTopologyDOT(w io.Writer, entities []*nbipb.Entity, at time.Time) error {
+	topo := newNetworkTopology(entities)
+	clusters := map[string]*topologyCluster{}
+	addEndpoint := func(e linkEndpoint) {
+		key, label := "node/"+e.nodeID, "node "+e.nodeID
+		if e.platformID != "" {
+			key, label = "platform/"+e.platformID, e.platformID
+			if name := topo.platforms[e.platformID].GetName(); name != "" {
+				label = fmt.Sprintf("%s (%s)", name, e.platformID)
+			}
+		}
+		c := clusters[key]
+		if c == nil {
+			c = &topologyCluster{label: label, endpoints: map[string]bool{}}
+			clusters[key] = c
+		}
+		c.endpoints[e.String()] = true
+	}
+	for id, node := range topo.nodes {
+		for _, ni := range node.GetNodeInterface() {
+			addEndpoint(topo.interfaceEndpoint(&commonpb.NetworkInterfaceId{NodeId: proto.String(id), InterfaceId: proto.String(ni.GetInterfaceId())}))
+		}
+	}
+	for _, l := range topo.links {
+		addEndpoint(l.src)
+		addEndpoint(l.dst)
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph topology {")
+	fmt.Fprintln(bw, "  rankdir=LR;")
+	fmt.Fprintln(bw, `  node [shape=box, fontname="sans-serif"];`)
+	fmt.Fprintln(bw, `  edge [fontname="sans-serif", fontsize=10];`)
+	for _, key := range slices.Sorted(maps.Keys(clusters)) {
+		c := clusters[key]
+		fmt.Fprintf(bw, "  subgraph %s {\n", dotQuote("cluster_"+key))
+		fmt.Fprintf(bw, "    label=%s;\n", dotQuote(c.label))
+		for _, e := range slices.Sorted(maps.Keys(c.endpoints)) {
+			fmt.Fprintf(bw, "    %s;\n", dotQuote(e))
+		}
+		fmt.Fprintln(bw, "  }")
+	}
+	for _, l := range topo.links {
+		status, rate := l.status(at)
+		label := status
+		if rate > 0 {
+			label = formatWithUnit("_bps", rate) + "\n" + status
+		}
+		style := linkStatusStyles[status]
+		fmt.Fprintf(bw, "  %s -> %s [label=%s, color=%s, style=%s, status=%s", dotQuote(l.src.String()), dotQuote(l.dst.String()), dotQuote(label), style[0], style[1], status)
+		if rate > 0 {
+			fmt.Fprintf(bw, ", data_rate_bps=%s", strconv.FormatFloat(rate, 'f', -1, 64))
+		}
+		fmt.Fprintf(bw, ", tooltip=%s];\n", dotQuote(l.entityType.String()+"/"+l.entityID))
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+// writeTopologyTable writes the links of the topology described by
+// `entities` as a table, with their status and data rate at `at`.
+func writeTopologyTable(w io.Writer, entities []*nbipb.Entity, at time.Time) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SOURCE\tDESTINATION\tSTATUS\tDATA RATE\tREPORTED BY")
+	for _, l := range newNetworkTopology(entities).links {
+		status, rate := l.status(at)
+		rateText := "-"
+		if rate > 0 {
+			rateText = formatWithUnit("_bps", rate)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s/%s\n", l.src, l.dst, status, rateText, l.entityType, l.entityID)
+	}
+	return tw.Flush()
+}
+
+func ShowTopology(appCtx *cli.Context) error {
+	format := appCtx.String("format")
+	if format != topologyFormatDOT && format != topologyFormatText {
+		return fmt.Errorf("unknown format %q, expected %s or %s", format, topologyFormatDOT, topologyFormatText)
+	}
+	at := time.Now()
+	if ts := appCtx.Timestamp("timestamp"); ts != nil {
+		at = *ts
+	}
+	entities, err := entitiesFromFlags(appCtx, topologyEntityTypes)
+	if err != nil {
+		return err
+	}
+	streams := ioStreamsFromContext(appCtx)
+	if format == topologyFormatDOT {
+		return WriteTopologyDOT(streams.Out, entities, at)
+	}
+	return writeTopologyTable(streams.Out, entities, at)
+}
@@ -0,0 +1,290 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/encoding/prototext"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+)
+
+// testTopologyStart is an hour before the access intervals of
+// testTopologyTextproto start, and shortly after the epoch of the ISS TLE.
+var testTopologyStart = time.Date(2008, time.September, 20, 11, 0, 0, 0, time.UTC)
+
+const testTopologyTextproto = `
+entity {
+  group { type: PLATFORM_DEFINITION }
+  id: "gs"
+  platform {
+    name: "Ground station"
+    coordinates { geodetic_wgs84 { latitude_deg: 40 longitude_deg: -100 height_wgs84_m: 200 } }
+  }
+}
+entity {
+  group { type: PLATFORM_DEFINITION }
+  id: "iss"
+  platform {
+    coordinates {
+      tle {
+        line1: "1 25544U 98067A   08264.51782528 -.00002182  00000-0 -11606-4 0  2927"
+        line2: "2 25544  51.6416 247.4627 0006703 130.5360 325.0288 15.72125391563537"
+      }
+    }
+  }
+}
+entity {
+  group { type: PLATFORM_DEFINITION }
+  id: "referenced"
+  platform { motion_ref_id: "elsewhere" }
+}
+entity {
+  group { type: NETWORK_NODE }
+  id: "gs-node"
+  network_node {
+    node_interface {
+      interface_id: "rf"
+      wireless { transceiver_model_id { platform_id: "gs" transceiver_model_id: "tx" } }
+    }
+    node_interface {
+      interface_id: "eth"
+      wired { platform_id: "gs" }
+    }
+  }
+}
+entity {
+  group { type: NETWORK_NODE }
+  id: "sat-node"
+  network_node {
+    node_interface {
+      interface_id: "rf"
+      wireless { transceiver_model_id { platform_id: "iss" transceiver_model_id: "tx" } }
+    }
+  }
+}
+entity {
+  group { type: INTERFACE_LINK_REPORT }
+  id: "gs-to-sat"
+  interface_link_report {
+    src { node_id: "gs-node" interface_id: "rf" }
+    dst { node_id: "sat-node" interface_id: "rf" }
+    access_intervals {
+      interval { start_time { unix_time_usec: 1221915600000000 } end_time { unix_time_usec: 1221919200000000 } }
+      accessibility: ACCESS_EXISTS
+      data_rate_bps: 1e9
+    }
+    access_intervals {
+      interval { start_time { unix_time_usec: 1221912000000000 } end_time { unix_time_usec: 1221915600000000 } }
+      accessibility: ACCESS_EXISTS
+      data_rate_bps: 5e8
+    }
+    access_intervals {
+      interval { start_time { unix_time_usec: 1221919200000000 } end_time { unix_time_usec: 1221922800000000 } }
+      accessibility: NO_ACCESS
+    }
+    access_intervals {
+      interval { start_time { unix_time_usec: 1221922800000000 } }
+      accessibility: ACCESS_EXISTS
+    }
+  }
+}
+entity {
+  group { type: TRANSCEIVER_LINK_REPORT }
+  id: "sat-tx"
+  transceiver_link_report {
+    src { platform_id: "iss" transceiver_model_id: "tx" }
+    links {
+      dst { platform_id: "gs" transceiver_model_id: "tx" }
+      access_intervals { accessibility: ACCESS_EXISTS }
+    }
+    links {
+      dst { platform_id: "referenced" transceiver_model_id: "tx" }
+      access_intervals { accessibility: ACCESS_EXISTS }
+    }
+  }
+}
+`
+
+func testTopologyEntities(t *testing.T) []*nbipb.Entity {
+	t.Helper()
+
+	entities := &nbipb.TxtpbEntities{}
+	checkErr(t, prototext.Unmarshal([]byte(testTopologyTextproto), entities))
+	return entities.GetEntity()
+}
+
+func TestNewNetworkTopology(t *testing.T) {
+	t.Parallel()
+
+	topo := newNetworkTopology(testTopologyEntities(t))
+	if diff := cmp.Diff([]string{"gs", "iss", "referenced"}, topo.platformIDs()); diff != "" {
+		t.Errorf("platform IDs mismatch (-want +got):\n%s", diff)
+	}
+
+	got := []string{}
+	for _, l := range topo.links {
+		got = append(got, l.src.String()+" ("+l.src.platformID+") -> "+l.dst.String()+" ("+l.dst.platformID+")")
+	}
+	want := []string{
+		"gs-node/rf (gs) -> sat-node/rf (iss)",
+		"iss/tx (iss) -> gs/tx (gs)",
+		"iss/tx (iss) -> referenced/tx (referenced)",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("links mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestTopologyLink_accessibleWindows(t *testing.T) {
+	t.Parallel()
+
+	topo := newNetworkTopology(testTopologyEntities(t))
+	at := func(hours float64) time.Time { return testTopologyStart.Add(time.Duration(hours * float64(time.Hour))) }
+	for _, tc := range []struct {
+		name       string
+		link       int
+		start, end time.Time
+		want       []contactWindow
+	}{
+		{
+			name:  "consecutive intervals are merged, and open ones clipped",
+			link:  0,
+			start: at(0),
+			end:   at(6),
+			want:  []contactWindow{{start: at(1), end: at(3)}, {start: at(4), end: at(6)}},
+		},
+		{
+			name:  "clipped to the window",
+			link:  0,
+			start: at(2),
+			end:   at(3.5),
+			want:  []contactWindow{{start: at(2), end: at(3)}},
+		},
+		{
+			name:  "unbounded",
+			link:  1,
+			start: at(0),
+			end:   at(1),
+			want:  []contactWindow{{start: at(0), end: at(1)}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := topo.links[tc.link].accessibleWindows(tc.start, tc.end)
+			if diff := cmp.Diff(tc.want, got, cmp.AllowUnexported(contactWindow{})); diff != "" {
+				t.Errorf("windows mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestECEFToGeodetic(t *testing.T) {
+	t.Parallel()
+
+	for _, want := range [][3]float64{{0, 0, 0}, {45, 90, 1000}, {-33.9, 18.4, 50}, {51.6, -120, 550e3}, {10, 179.9, 36e6}} {
+		lat, lon, h := ecefToGeodetic(geodeticToECEF(want[0], want[1], want[2]))
+		if math.Abs(lat-want[0]) > 1e-7 || math.Abs(lon-want[1]) > 1e-7 || math.Abs(h-want[2]) > 1e-3 {
+			t.Errorf("expected %v after a round trip, got [%v %v %v]", want, lat, lon, h)
+		}
+	}
+}
+
+func TestWriteTopologyDOT(t *testing.T) {
+	t.Parallel()
+
+	b := &strings.Builder{}
+	checkErr(t, WriteTopologyDOT(b, testTopologyEntities(t), testTopologyStart.Add(150*time.Minute)))
+	want := `digraph topology {
+  rankdir=LR;
+  node [shape=box, fontname="sans-serif"];
+  edge [fontname="sans-serif", fontsize=10];
+  subgraph "cluster_platform/gs" {
+    label="Ground station (gs)";
+    "gs-node/eth";
+    "gs-node/rf";
+    "gs/tx";
+  }
+  subgraph "cluster_platform/iss" {
+    label="iss";
+    "iss/tx";
+    "sat-node/rf";
+  }
+  subgraph "cluster_platform/referenced" {
+    label="referenced";
+    "referenced/tx";
+  }
+  "gs-node/rf" -> "sat-node/rf" [label="1 Gbps\nup", color=forestgreen, style=solid, status=up, data_rate_bps=1000000000, tooltip="INTERFACE_LINK_REPORT/gs-to-sat"];
+  "iss/tx" -> "gs/tx" [label="up", color=forestgreen, style=solid, status=up, tooltip="TRANSCEIVER_LINK_REPORT/sat-tx"];
+  "iss/tx" -> "referenced/tx" [label="up", color=forestgreen, style=solid, status=up, tooltip="TRANSCEIVER_LINK_REPORT/sat-tx"];
+}
+`
+	if diff := cmp.Diff(want, b.String()); diff != "" {
+		t.Errorf("DOT mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestTopologyLink_status(t *testing.T) {
+	t.Parallel()
+
+	link := newNetworkTopology(testTopologyEntities(t)).links[0]
+	for _, tc := range []struct {
+		hours      float64
+		wantStatus string
+		wantRate   float64
+	}{
+		{hours: 0.5, wantStatus: linkStatusUnknown},
+		{hours: 1, wantStatus: linkStatusUp, wantRate: 5e8},
+		{hours: 2, wantStatus: linkStatusUp, wantRate: 1e9},
+		{hours: 3.5, wantStatus: linkStatusDown},
+		{hours: 100, wantStatus: linkStatusUp},
+	} {
+		status, rate := link.status(testTopologyStart.Add(time.Duration(tc.hours * float64(time.Hour))))
+		if status != tc.wantStatus || rate != tc.wantRate {
+			t.Errorf("at %vh: expected (%s, %v), got (%s, %v)", tc.hours, tc.wantStatus, tc.wantRate, status, rate)
+		}
+	}
+}
+
+func TestShowTopology(t *testing.T) {
+	t.Parallel()
+
+	files := filepath.Join(t.TempDir(), "entities.textproto")
+	checkErr(t, os.WriteFile(files, []byte(testTopologyTextproto), 0o644))
+
+	app := newTestApp()
+	checkErr(t, app.Run([]string{"nbictl", "topology", "--files", files, "--format", "text", "--timestamp", "2008-09-20T14:30:00Z"}))
+	want := `SOURCE      DESTINATION    STATUS  DATA RATE  REPORTED BY
+gs-node/rf  sat-node/rf    down    -          INTERFACE_LINK_REPORT/gs-to-sat
+iss/tx      gs/tx          up      -          TRANSCEIVER_LINK_REPORT/sat-tx
+iss/tx      referenced/tx  up      -          TRANSCEIVER_LINK_REPORT/sat-tx
+`
+	if diff := cmp.Diff(want, app.stdout.String()); diff != "" {
+		t.Errorf("output mismatch (-want +got):\n%s", diff)
+	}
+
+	app = newTestApp()
+	if err := app.Run([]string{"nbictl", "topology", "--files", files, "--format", "svg"}); err == nil || !strings.Contains(err.Error(), `unknown format "svg"`) {
+		t.Errorf("expected an error about the format, got %v", err)
+	}
+}
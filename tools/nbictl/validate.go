@@ -0,0 +1,360 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"cmp"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	commonpb "aalyria.com/spacetime/api/common"
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+	resourcespb "aalyria.com/spacetime/api/nbi/v1alpha/resources"
+	outputv1 "aalyria.com/spacetime/github/tools/nbictl/output/v1"
+)
+
+// entityFileExtensions are the extensions of the files read when a directory
+// is provided to `validate`.
+var entityFileExtensions = []string{".textproto", ".txtpb", ".textpb", ".pbtxt"}
+
+// entityReferences are the fields that hold the ID of another entity, keyed
+// by the field's full name.
+var entityReferences = map[protoreflect.FullName]nbipb.EntityType{
+	"aalyria.spacetime.api.common.AntennaDefinition.antenna_pattern_id":              nbipb.EntityType_ANTENNA_PATTERN,
+	"aalyria.spacetime.api.common.NetworkInterfaceId.node_id":                        nbipb.EntityType_NETWORK_NODE,
+	"aalyria.spacetime.api.common.PlatformDefinition.motion_ref_id":                  nbipb.EntityType_MOTION_DEFINITION,
+	"aalyria.spacetime.api.common.TransceiverModelId.platform_id":                    nbipb.EntityType_PLATFORM_DEFINITION,
+	"aalyria.spacetime.api.nbi.v1alpha.resources.Radio.band_profile_id":              nbipb.EntityType_BAND_PROFILE,
+	"aalyria.spacetime.api.nbi.v1alpha.resources.ServiceRequest.dst_node_id":         nbipb.EntityType_NETWORK_NODE,
+	"aalyria.spacetime.api.nbi.v1alpha.resources.ServiceRequest.src_node_id":         nbipb.EntityType_NETWORK_NODE,
+	"aalyria.spacetime.api.nbi.v1alpha.resources.WiredDevice.platform_id":            nbipb.EntityType_PLATFORM_DEFINITION,
+	"aalyria.spacetime.api.nbi.v1alpha.resources.WirelessLinkReport.band_profile_id": nbipb.EntityType_BAND_PROFILE,
+}
+
+type valueRange struct {
+	min, max float64
+	// maxExclusive is set if `max` itself is out of range.
+	maxExclusive bool
+}
+
+func (r valueRange) contains(f float64) bool {
+	if r.maxExclusive {
+		return f >= r.min && f < r.max
+	}
+	return f >= r.min && f <= r.max
+}
+
+func (r valueRange) String() string {
+	if r.maxExclusive {
+		return fmt.Sprintf("[%v, %v)", r.min, r.max)
+	}
+	return fmt.Sprintf("[%v, %v]", r.min, r.max)
+}
+
+// fieldRanges are the valid ranges of numeric fields, keyed by field name.
+var fieldRanges = map[protoreflect.Name]valueRange{
+	"latitude_deg":    {min: -90, max: 90},
+	"longitude_deg":   {min: -180, max: 180},
+	"inclination_deg": {min: 0, max: 180},
+	"eccentricity":    {min: 0, max: 1, maxExclusive: true},
+}
+
+// nonNegativeFieldSuffixes are the suffixes of the names of numeric fields,
+// like frequencies and data rates, that can't be negative.
+var nonNegativeFieldSuffixes = []string{"_hz", "_bps"}
+
+var textprotoErrorLineRe = regexp.MustCompile(`\(line (\d+):\d+\)`)
+
+type validationProblem struct {
+	file string
+	line int
+	msg  string
+}
+
+func (p validationProblem) String() string {
+	if p.line == 0 {
+		return fmt.Sprintf("%s: %s", p.file, p.msg)
+	}
+	return fmt.Sprintf("%s:%d: %s", p.file, p.line, p.msg)
+}
+
+type parsedEntity struct {
+	file   string
+	path   string
+	entity *nbipb.Entity
+	locs   textprotoLocations
+}
+
+func (e *parsedEntity) String() string {
+	return fmt.Sprintf("%s/%s", e.entity.GetGroup().GetType(), e.entity.GetId())
+}
+
+type entityValidator struct {
+	entities []*parsedEntity
+	byID     map[nbipb.EntityType]map[string]*parsedEntity
+	problems []validationProblem
+}
+
+func Validate(appCtx *cli.Context) error {
+	files, err := expandEntityFiles(appCtx.String("files"))
+	if err != nil {
+		return err
+	}
+
+	v := &entityValidator{byID: map[nbipb.EntityType]map[string]*parsedEntity{}}
+	for _, file := range files {
+		if err := v.addFile(file); err != nil {
+			return err
+		}
+	}
+	v.validate()
+
+	if jsonOutputRequested(appCtx) {
+		report := outputv1.NewValidationReport()
+		report.FilesChecked, report.EntitiesChecked = len(files), len(v.entities)
+		for _, p := range v.problems {
+			report.Problems = append(report.Problems, outputv1.ValidationProblem{File: p.file, Line: p.line, Message: p.msg})
+		}
+		if err := writeJSONOutput(appCtx, report); err != nil {
+			return err
+		}
+	} else {
+		for _, p := range v.problems {
+			fmt.Fprintln(appCtx.App.Writer, p)
+		}
+	}
+	if len(v.problems) > 0 {
+		return fmt.Errorf("found %d problem(s) in %d file(s)", len(v.problems), len(files))
+	}
+	fmt.Fprintf(appCtx.App.ErrWriter, "successfully validated %d entities in %d file(s)\n", len(v.entities), len(files))
+	return nil
+}
+
+// expandEntityFiles returns the entity files under `pattern` if it's a
+// directory, or the files matching it if it's a glob.
+func expandEntityFiles(pattern string) ([]string, error) {
+	files := []string{}
+	if info, err := os.Stat(pattern); err == nil && info.IsDir() {
+		err := filepath.WalkDir(pattern, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && slices.Contains(entityFileExtensions, filepath.Ext(path)) {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to read directory %s: %w", pattern, err)
+		}
+	} else if files, err = filepath.Glob(pattern); err != nil {
+		return nil, fmt.Errorf("unable to expand the file path %w", err)
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files found under the given file path: %s", pattern)
+	}
+	return files, nil
+}
+
+// addFile parses the entities in `file`. Syntax errors are recorded as
+// problems rather than returned, so every file can be checked in one pass.
+func (v *entityValidator) addFile(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("invalid file path: %w", err)
+	}
+
+	entities := &nbipb.TxtpbEntities{}
+	if err := prototext.Unmarshal(data, entities); err != nil {
+		line := 0
+		if m := textprotoErrorLineRe.FindStringSubmatch(err.Error()); m != nil {
+			line, _ = strconv.Atoi(m[1])
+		}
+		v.problems = append(v.problems, validationProblem{file: file, line: line, msg: err.Error()})
+		return nil
+	}
+
+	locs := indexTextprotoLocations(data, entities.ProtoReflect().Descriptor())
+	for i, e := range entities.GetEntity() {
+		v.entities = append(v.entities, &parsedEntity{
+			file:   file,
+			path:   fmt.Sprintf("entity[%d]", i),
+			entity: e,
+			locs:   locs,
+		})
+	}
+	return nil
+}
+
+func (v *entityValidator) addProblem(e *parsedEntity, path string, format string, args ...any) {
+	v.problems = append(v.problems, validationProblem{
+		file: e.file,
+		line: e.locs.line(joinFieldPath(e.path, path)),
+		msg:  e.String() + ": " + fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *entityValidator) validate() {
+	for _, e := range v.entities {
+		entityType, id := e.entity.GetGroup().GetType(), e.entity.GetId()
+		if entityType == nbipb.EntityType_ENTITY_TYPE_UNSPECIFIED || id == "" {
+			continue
+		}
+		if v.byID[entityType] == nil {
+			v.byID[entityType] = map[string]*parsedEntity{}
+		}
+		if prev, ok := v.byID[entityType][id]; ok {
+			v.addProblem(e, "id", "duplicate entity; also defined at %s:%d", prev.file, prev.locs.line(prev.path))
+			continue
+		}
+		v.byID[entityType][id] = e
+	}
+
+	for _, e := range v.entities {
+		v.validateEntity(e)
+	}
+
+	slices.SortStableFunc(v.problems, func(a, b validationProblem) int {
+		return cmp.Or(cmp.Compare(a.file, b.file), cmp.Compare(a.line, b.line))
+	})
+}
+
+func (v *entityValidator) validateEntity(e *parsedEntity) {
+	entityType := e.entity.GetGroup().GetType()
+	if entityType == nbipb.EntityType_ENTITY_TYPE_UNSPECIFIED {
+		v.addProblem(e, "group", "missing required field group.type")
+	}
+	if e.entity.GetId() == "" {
+		v.addProblem(e, "", "missing required field id")
+	}
+
+	m := e.entity.ProtoReflect()
+	valueField := m.WhichOneof(m.Descriptor().Oneofs().ByName("value"))
+	switch {
+	case valueField == nil:
+		v.addProblem(e, "", "the entity has no value")
+	case entityType != nbipb.EntityType_ENTITY_TYPE_UNSPECIFIED && valueField.Name() != entityValueFieldName(entityType):
+		v.addProblem(e, string(valueField.Name()), "the entity's type is %s, but its %s field is set", entityType, valueField.Name())
+	}
+
+	v.validateMessage(e, m, "")
+}
+
+// entityValueFieldName returns the name of the field in the Entity's `value`
+// oneof that holds entities of type `t`.
+func entityValueFieldName(t nbipb.EntityType) protoreflect.Name {
+	if t == nbipb.EntityType_PLATFORM_DEFINITION {
+		return "platform"
+	}
+	return protoreflect.Name(strings.ToLower(t.String()))
+}
+
+func (v *entityValidator) validateMessage(e *parsedEntity, m protoreflect.Message, path string) {
+	switch msg := m.Interface().(type) {
+	case *commonpb.TwoLineElementSet:
+		if _, err := parseTLE(msg.GetLine1(), msg.GetLine2()); err != nil {
+			v.addProblem(e, path, "invalid TLE: %v", err)
+		}
+	case *commonpb.TransceiverModelId:
+		if pd := v.byID[nbipb.EntityType_PLATFORM_DEFINITION][msg.GetPlatformId()]; pd != nil && msg.TransceiverModelId != nil {
+			if !slices.ContainsFunc(pd.entity.GetPlatform().GetTransceiverModel(), func(tm *commonpb.TransceiverModel) bool {
+				return tm.GetId() == msg.GetTransceiverModelId()
+			}) {
+				v.addProblem(e, joinFieldPath(path, "transceiver_model_id"), "platform %q has no transceiver model %q", msg.GetPlatformId(), msg.GetTransceiverModelId())
+			}
+		}
+	case *commonpb.NetworkInterfaceId:
+		if node := v.byID[nbipb.EntityType_NETWORK_NODE][msg.GetNodeId()]; node != nil && msg.InterfaceId != nil {
+			if !slices.ContainsFunc(node.entity.GetNetworkNode().GetNodeInterface(), func(ni *resourcespb.NetworkInterface) bool {
+				return ni.GetInterfaceId() == msg.GetInterfaceId()
+			}) {
+				v.addProblem(e, joinFieldPath(path, "interface_id"), "network node %q has no interface %q", msg.GetNodeId(), msg.GetInterfaceId())
+			}
+		}
+	}
+
+	m.Range(func(fd protoreflect.FieldDescriptor, val protoreflect.Value) bool {
+		fieldPath := joinFieldPath(path, fd.TextName())
+		switch {
+		case fd.IsList():
+			list := val.List()
+			for i := 0; i < list.Len(); i++ {
+				v.validateValue(e, fd, list.Get(i), fmt.Sprintf("%s[%d]", fieldPath, i))
+			}
+		case fd.IsMap():
+			val.Map().Range(func(_ protoreflect.MapKey, mv protoreflect.Value) bool {
+				v.validateValue(e, fd.MapValue(), mv, fieldPath)
+				return true
+			})
+		default:
+			v.validateValue(e, fd, val, fieldPath)
+		}
+		return true
+	})
+}
+
+func (v *entityValidator) validateValue(e *parsedEntity, fd protoreflect.FieldDescriptor, val protoreflect.Value, path string) {
+	if fd.Message() != nil {
+		v.validateMessage(e, val.Message(), path)
+		return
+	}
+
+	if refType, ok := entityReferences[fd.FullName()]; ok {
+		if _, found := v.byID[refType][val.String()]; !found {
+			v.addProblem(e, path, "%s references %s %q, which isn't defined in any of the validated files", fd.TextName(), refType, val.String())
+		}
+	}
+
+	var f float64
+	switch fd.Kind() {
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		f = val.Float()
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		f = float64(val.Int())
+	default:
+		return
+	}
+	if r, ok := fieldRanges[fd.Name()]; ok && !r.contains(f) {
+		v.addProblem(e, path, "%s: %v is outside of the valid range %s", fd.TextName(), f, r)
+	}
+	for _, suffix := range nonNegativeFieldSuffixes {
+		if strings.HasSuffix(string(fd.Name()), suffix) && f < 0 {
+			v.addProblem(e, path, "%s: %v must not be negative", fd.TextName(), f)
+		}
+	}
+}
+
+func joinFieldPath(parent, child string) string {
+	switch {
+	case parent == "":
+		return child
+	case child == "":
+		return parent
+	default:
+		return parent + "." + child
+	}
+}
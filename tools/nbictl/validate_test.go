@@ -0,0 +1,187 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bazelbuild/rules_go/go/tools/bazel"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+
+	outputv1 "aalyria.com/spacetime/github/tools/nbictl/output/v1"
+)
+
+const validateTestPlatform = `entity {
+  group { type: PLATFORM_DEFINITION }
+  id: "sat"
+  platform {
+    name: "sat"
+    coordinates {
+      geodetic_wgs84 {
+        latitude_deg: 91
+        longitude_deg: 0
+      }
+    }
+    transceiver_model {
+      id: "radio"
+    }
+  }
+}
+`
+
+const validateTestNodes = `# Nodes referencing the platform.
+entity {
+  group { type: NETWORK_NODE }
+  id: "node-a"
+  network_node {
+    node_interface {
+      interface_id: "good"
+      wireless {
+        transceiver_model_id {
+          platform_id: "sat"
+          transceiver_model_id: "radio"
+        }
+      }
+    }
+    node_interface {
+      interface_id: "bad"
+      wireless {
+        transceiver_model_id {
+          platform_id: "sat"
+          transceiver_model_id: "missing-radio"
+        }
+      }
+    }
+  }
+}
+entity {
+  group { type: NETWORK_NODE }
+  id: "node-b"
+  network_node {
+    node_interface {
+      interface_id: "wired"
+      wired { platform_id: "unknown-platform" }
+    }
+  }
+}
+entity {
+  group { type: NETWORK_NODE }
+  id: "node-a"
+  platform {}
+}
+`
+
+func TestValidate_entitySamples(t *testing.T) {
+	t.Parallel()
+
+	app := newTestApp()
+	checkErr(t, app.Run([]string{"nbictl", "validate", "-f", "../../entity_samples/build_a_scenario_tutorial"}))
+	if got := app.stdout.String(); got != "" {
+		t.Errorf("expected no problems, got:\n%s", got)
+	}
+	if want := "successfully validated"; !strings.Contains(app.stderr.String(), want) {
+		t.Errorf("expected stderr to contain %q, got %q", want, app.stderr.String())
+	}
+}
+
+func TestValidate_reportsProblemsWithLocations(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := bazel.NewTmpDir("nbictl")
+	checkErr(t, err)
+	checkErr(t, os.MkdirAll(filepath.Join(tmpDir, "nodes"), 0o755))
+	checkErr(t, os.WriteFile(filepath.Join(tmpDir, "platform.textproto"), []byte(validateTestPlatform), 0o644))
+	checkErr(t, os.WriteFile(filepath.Join(tmpDir, "nodes", "nodes.txtpb"), []byte(validateTestNodes), 0o644))
+	checkErr(t, os.WriteFile(filepath.Join(tmpDir, "nodes", "broken.textproto"), []byte("entity {\n  id: \"x\"\n  bogus_field: 1\n}\n"), 0o644))
+	checkErr(t, os.WriteFile(filepath.Join(tmpDir, "README.md"), []byte("not an entity"), 0o644))
+
+	app := newTestApp()
+	switch want, err := "found 6 problem(s) in 3 file(s)", app.Run([]string{"nbictl", "validate", "--files", tmpDir}); {
+	case err == nil:
+		t.Fatalf("expected validation to fail, got nil")
+	case !strings.Contains(err.Error(), want):
+		t.Fatalf("expected error to contain %q, but got %q", want, err.Error())
+	}
+
+	nodesFile := filepath.Join(tmpDir, "nodes", "nodes.txtpb")
+	want := []string{
+		filepath.Join(tmpDir, "nodes", "broken.textproto") + `:3:`,
+		nodesFile + `:20: NETWORK_NODE/node-a: platform "sat" has no transceiver model "missing-radio"`,
+		nodesFile + `:32: NETWORK_NODE/node-b: platform_id references PLATFORM_DEFINITION "unknown-platform", which isn't defined in any of the validated files`,
+		nodesFile + `:38: NETWORK_NODE/node-a: duplicate entity; also defined at ` + nodesFile + `:2`,
+		nodesFile + `:39: NETWORK_NODE/node-a: the entity's type is NETWORK_NODE, but its platform field is set`,
+		filepath.Join(tmpDir, "platform.textproto") + `:8: PLATFORM_DEFINITION/sat: latitude_deg: 91 is outside of the valid range [-90, 90]`,
+	}
+	got := strings.Split(strings.TrimSpace(app.stdout.String()), "\n")
+	if len(got) != len(want) {
+		t.Fatalf("unexpected problems (-want +got):\n%s", cmp.Diff(want, got))
+	}
+	for i := range want {
+		if !strings.HasPrefix(got[i], want[i]) {
+			t.Errorf("problem %d: expected prefix %q, got %q", i, want[i], got[i])
+		}
+	}
+}
+
+func TestValidate_jsonOutput(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := bazel.NewTmpDir("nbictl")
+	checkErr(t, err)
+	platformFile := filepath.Join(tmpDir, "platform.textproto")
+	checkErr(t, os.WriteFile(platformFile, []byte(validateTestPlatform), 0o644))
+
+	app := newTestApp()
+	if err := app.Run([]string{"nbictl", "validate", "--files", tmpDir, "-o", "json"}); err == nil {
+		t.Fatalf("expected validation to fail, got nil")
+	}
+
+	got := &outputv1.ValidationReport{}
+	checkErr(t, json.Unmarshal(app.stdout.Bytes(), got))
+	want := &outputv1.ValidationReport{
+		TypeMeta:        outputv1.TypeMeta{APIVersion: outputv1.APIVersion, Kind: "ValidationReport"},
+		FilesChecked:    1,
+		EntitiesChecked: 1,
+		Problems: []outputv1.ValidationProblem{{
+			File:    platformFile,
+			Line:    8,
+			Message: "PLATFORM_DEFINITION/sat: latitude_deg: 91 is outside of the valid range [-90, 90]",
+		}},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected report (-want +got):\n%s", diff)
+	}
+}
+
+func TestEntityReferences_areValidFields(t *testing.T) {
+	t.Parallel()
+
+	for name := range entityReferences {
+		d, err := protoregistry.GlobalFiles.FindDescriptorByName(name)
+		if err != nil {
+			t.Errorf("entity reference %s: %v", name, err)
+			continue
+		}
+		if fd, ok := d.(protoreflect.FieldDescriptor); !ok || fd.Kind() != protoreflect.StringKind {
+			t.Errorf("entity reference %s is not a string field", name)
+		}
+	}
+}
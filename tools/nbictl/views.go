@@ -0,0 +1,308 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v2"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+
+	"aalyria.com/spacetime/github/tools/nbictl/nbictlpb"
+)
+
+// viewCommands are the commands that can be saved as views. Views are meant
+// to be run repeatedly without a second thought, so only commands that don't
+// modify anything are allowed.
+var viewCommands = []string{"get", "list"}
+
+var viewNameRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+func SaveView(appCtx *cli.Context) error {
+	if err := checkLocalStateWritable(appCtx); err != nil {
+		return err
+	}
+	args := appCtx.Args().Slice()
+	if len(args) < 2 {
+		return errors.New("expected a view name followed by the command to save, e.g. `view save links-down list --type INTERFACE_LINK_REPORT`")
+	}
+	view := &nbictlpb.View{Name: args[0], Description: appCtx.String("description"), Args: args[1:]}
+	if err := validateView(view); err != nil {
+		return err
+	}
+
+	confFile, err := getConfFileForContext(appCtx)
+	if err != nil {
+		return err
+	}
+	replaced := false
+	if err := updateAppConfig(confFile, func(conf *nbictlpb.AppConfig) error {
+		i := slices.IndexFunc(conf.GetViews(), func(v *nbictlpb.View) bool { return v.GetName() == view.GetName() })
+		switch {
+		case i < 0:
+			conf.Views = append(conf.Views, view)
+		case !appCtx.Bool("overwrite"):
+			return fmt.Errorf("view %q already exists; use --overwrite to replace it", view.GetName())
+		default:
+			conf.Views[i], replaced = view, true
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	verb := "saved"
+	if replaced {
+		verb = "replaced"
+	}
+	fmt.Fprintf(appCtx.App.ErrWriter, "%s view %q; run it with `%s view run %s`\n", verb, view.GetName(), appName, view.GetName())
+	return nil
+}
+
+func RunView(appCtx *cli.Context) error {
+	args := appCtx.Args().Slice()
+	if len(args) == 0 {
+		return errors.New("expected the name of the view to run")
+	}
+	view, err := findView(appCtx, args[0])
+	if err != nil {
+		return err
+	}
+
+	// The view runs as a separate invocation, with the global flags of this one
+	// and with any extra arguments appended to the saved ones.
+	app := App()
+	app.Reader, app.Writer, app.ErrWriter = appCtx.App.Reader, appCtx.App.Writer, appCtx.App.ErrWriter
+	app.ExitErrHandler = func(*cli.Context, error) {}
+	if err := app.RunContext(appCtx.Context, slices.Concat([]string{appName}, inheritedGlobalArgs(appCtx), view.GetArgs(), args[1:])); err != nil {
+		return fmt.Errorf("view %q: %w", view.GetName(), err)
+	}
+	return nil
+}
+
+func ListViews(appCtx *cli.Context) error {
+	confFile, err := getConfFileForContext(appCtx)
+	if err != nil {
+		return err
+	}
+	conf, err := readConfigs(confFile)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(appCtx.App.Writer, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tCOMMAND\tDESCRIPTION")
+	for _, v := range conf.GetViews() {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", v.GetName(), joinShellWords(v.GetArgs()), v.GetDescription())
+	}
+	return w.Flush()
+}
+
+func DeleteView(appCtx *cli.Context) error {
+	if err := checkLocalStateWritable(appCtx); err != nil {
+		return err
+	}
+	if appCtx.Args().Len() != 1 {
+		return fmt.Errorf("expected 1 argument, the name of the view to delete, got %d", appCtx.Args().Len())
+	}
+	name := appCtx.Args().First()
+
+	confFile, err := getConfFileForContext(appCtx)
+	if err != nil {
+		return err
+	}
+	if err := updateAppConfig(confFile, func(conf *nbictlpb.AppConfig) error {
+		i := slices.IndexFunc(conf.GetViews(), func(v *nbictlpb.View) bool { return v.GetName() == name })
+		if i < 0 {
+			return fmt.Errorf("no view named %q", name)
+		}
+		conf.Views = slices.Delete(conf.Views, i, i+1)
+		return nil
+	}); err != nil {
+		return err
+	}
+	fmt.Fprintf(appCtx.App.ErrWriter, "deleted view %q\n", name)
+	return nil
+}
+
+// ExportViews prints the saved views in the format of the configuration file,
+// without any of the contexts, so they can be shared without also sharing
+// connection settings and credentials.
+func ExportViews(appCtx *cli.Context) error {
+	confFile, err := getConfFileForContext(appCtx)
+	if err != nil {
+		return err
+	}
+	conf, err := readConfigs(confFile)
+	if err != nil {
+		return err
+	}
+
+	exported := &nbictlpb.AppConfig{}
+	for _, v := range conf.GetViews() {
+		if names := appCtx.StringSlice("name"); len(names) == 0 || slices.Contains(names, v.GetName()) {
+			exported.Views = append(exported.Views, v)
+		}
+	}
+	out, err := prototext.MarshalOptions{Multiline: true}.Marshal(exported)
+	if err != nil {
+		return fmt.Errorf("unable to convert the views into textproto format: %w", err)
+	}
+	_, err = appCtx.App.Writer.Write(out)
+	return err
+}
+
+// ImportViews adds the views from a file produced by `view export`, or from a
+// configuration file. Any contexts in the file are ignored.
+func ImportViews(appCtx *cli.Context) error {
+	if err := checkLocalStateWritable(appCtx); err != nil {
+		return err
+	}
+
+	var data []byte
+	var err error
+	if file := appCtx.String("file"); file == "-" {
+		data, err = io.ReadAll(appCtx.App.Reader)
+	} else {
+		data, err = os.ReadFile(file)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read views: %w", err)
+	}
+	imported := &nbictlpb.AppConfig{}
+	if err := prototext.Unmarshal(data, imported); err != nil {
+		return fmt.Errorf("invalid views file: %w", err)
+	}
+	for _, v := range imported.GetViews() {
+		if err := validateView(v); err != nil {
+			return err
+		}
+	}
+
+	confFile, err := getConfFileForContext(appCtx)
+	if err != nil {
+		return err
+	}
+	if err := updateAppConfig(confFile, func(conf *nbictlpb.AppConfig) error {
+		for _, v := range imported.GetViews() {
+			i := slices.IndexFunc(conf.GetViews(), func(existing *nbictlpb.View) bool { return existing.GetName() == v.GetName() })
+			switch {
+			case i < 0:
+				conf.Views = append(conf.Views, v)
+			case proto.Equal(conf.Views[i], v):
+			case !appCtx.Bool("overwrite"):
+				return fmt.Errorf("a different view named %q already exists; use --overwrite to replace it", v.GetName())
+			default:
+				conf.Views[i] = v
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	fmt.Fprintf(appCtx.App.ErrWriter, "imported %d view(s)\n", len(imported.GetViews()))
+	return nil
+}
+
+func findView(appCtx *cli.Context, name string) (*nbictlpb.View, error) {
+	confFile, err := getConfFileForContext(appCtx)
+	if err != nil {
+		return nil, err
+	}
+	conf, err := readConfigs(confFile)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, v := range conf.GetViews() {
+		if v.GetName() == name {
+			return v, nil
+		}
+		names = append(names, v.GetName())
+	}
+	return nil, fmt.Errorf("no view named %q (expected one of [%s])", name, strings.Join(names, ", "))
+}
+
+// validateView checks that `v` has a valid name and that its arguments are
+// an invocation of one of the `viewCommands` with flags that it accepts.
+func validateView(v *nbictlpb.View) error {
+	if !viewNameRegexp.MatchString(v.GetName()) {
+		return fmt.Errorf("invalid view name %q: must match %s", v.GetName(), viewNameRegexp)
+	}
+	args := v.GetArgs()
+	if len(args) == 0 || !slices.Contains(viewCommands, args[0]) {
+		return fmt.Errorf("view %q: only the [%s] commands can be saved as views", v.GetName(), strings.Join(viewCommands, ", "))
+	}
+
+	cmd := findCommand(App().Commands, args[0])
+	set := flag.NewFlagSet(cmd.Name, flag.ContinueOnError)
+	set.SetOutput(io.Discard)
+	for _, f := range cmd.Flags {
+		if err := f.Apply(set); err != nil {
+			return err
+		}
+	}
+	if err := set.Parse(args[1:]); err != nil {
+		return fmt.Errorf("view %q: invalid arguments for %s: %w", v.GetName(), cmd.Name, err)
+	}
+	return nil
+}
+
+// updateAppConfig applies `fn` to the configuration stored in `confFile`,
+// holding the file's lock across the read-modify-write.
+func updateAppConfig(confFile string, fn func(*nbictlpb.AppConfig) error) error {
+	return withFileLock(confFile, func() error {
+		conf, err := readConfigs(confFile)
+		if err != nil {
+			return fmt.Errorf("unable to get configs from file %s: %w", confFile, err)
+		}
+		if err := fn(conf); err != nil {
+			return err
+		}
+
+		data, err := prototext.Marshal(conf)
+		if err != nil {
+			return fmt.Errorf("unable to convert proto into textproto format: %w", err)
+		}
+		if err := writeFileAtomic(confFile, data, 0o777); err != nil {
+			return fmt.Errorf("unable to update the configuration information: %w", err)
+		}
+		return nil
+	})
+}
+
+// joinShellWords is the inverse of splitShellWords, quoting any words that
+// wouldn't otherwise survive being split again.
+func joinShellWords(words []string) string {
+	quoted := []string{}
+	for _, w := range words {
+		if w != "" && !strings.ContainsAny(w, " \t'\"\\") {
+			quoted = append(quoted, w)
+			continue
+		}
+		quoted = append(quoted, "'"+strings.ReplaceAll(w, "'", `'\''`)+"'")
+	}
+	return strings.Join(quoted, " ")
+}
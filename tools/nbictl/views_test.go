@@ -0,0 +1,167 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nbictl
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bazelbuild/rules_go/go/tools/bazel"
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+	respb "aalyria.com/spacetime/api/nbi/v1alpha/resources"
+	"aalyria.com/spacetime/github/tools/nbictl/nbictlpb"
+)
+
+func TestViews_saveListExportImportDelete(t *testing.T) {
+	t.Parallel()
+
+	confDir, err := bazel.NewTmpDir("nbictl")
+	checkErr(t, err)
+	run := func(args ...string) testApp {
+		t.Helper()
+		app := newTestApp()
+		checkErr(t, app.Run(append([]string{"nbictl", "--config_dir", confDir, "view"}, args...)))
+		return app
+	}
+
+	run("save", "--description", "links that are down", "links-down", "list", "--type", "INTERFACE_LINK_REPORT", "--output", "table", "--column", "up=interface_link_report.up")
+	run("save", "nodes", "list", "-t", "NETWORK_NODE")
+
+	list := run("list").stdout.String()
+	for _, want := range []string{
+		"links-down  list --type INTERFACE_LINK_REPORT --output table --column up=interface_link_report.up  links that are down",
+		"nodes       list -t NETWORK_NODE",
+	} {
+		if !strings.Contains(list, want) {
+			t.Errorf("expected `view list` to contain %q, got:\n%s", want, list)
+		}
+	}
+
+	exported := run("export", "--name", "nodes").stdout.String()
+	gotExport := &nbictlpb.AppConfig{}
+	checkErr(t, prototext.Unmarshal([]byte(exported), gotExport))
+	wantExport := &nbictlpb.AppConfig{Views: []*nbictlpb.View{{Name: "nodes", Args: []string{"list", "-t", "NETWORK_NODE"}}}}
+	if diff := cmp.Diff(wantExport, gotExport, protocmp.Transform()); diff != "" {
+		t.Fatalf("unexpected export (-want +got):\n%s", diff)
+	}
+
+	otherDir, err := bazel.NewTmpDir("nbictl")
+	checkErr(t, err)
+	exportFile := filepath.Join(otherDir, "views.textproto")
+	checkErr(t, os.WriteFile(exportFile, []byte(exported), 0o644))
+	importer := newTestApp()
+	checkErr(t, importer.Run([]string{"nbictl", "--config_dir", otherDir, "view", "import", "--file", exportFile}))
+	// Importing the same views again is a no-op.
+	checkErr(t, importer.Run([]string{"nbictl", "--config_dir", otherDir, "view", "import", "--file", exportFile}))
+	lister := newTestApp()
+	checkErr(t, lister.Run([]string{"nbictl", "--config_dir", otherDir, "view", "list"}))
+	if got := lister.stdout.String(); !strings.Contains(got, "nodes") {
+		t.Fatalf("expected the imported view to be listed, got:\n%s", got)
+	}
+
+	run("delete", "links-down")
+	if list := run("list").stdout.String(); strings.Contains(list, "links-down") {
+		t.Fatalf("expected the deleted view not to be listed, got:\n%s", list)
+	}
+}
+
+func TestSaveView_errors(t *testing.T) {
+	t.Parallel()
+
+	confDir, err := bazel.NewTmpDir("nbictl")
+	checkErr(t, err)
+	checkErr(t, newTestApp().Run([]string{"nbictl", "--config_dir", confDir, "view", "save", "existing", "list", "-t", "NETWORK_NODE"}))
+
+	for _, tc := range []struct {
+		name string
+		args []string
+		want string
+	}{
+		{name: "missing command", args: []string{"save", "nodes"}, want: "expected a view name followed by the command"},
+		{name: "invalid name characters", args: []string{"save", "my view", "list"}, want: `invalid view name "my view"`},
+		{name: "mutating command", args: []string{"save", "nuke", "delete", "--type", "NETWORK_NODE", "--id", "a"}, want: "only the [get, list] commands"},
+		{name: "unknown flag", args: []string{"save", "nodes", "list", "--sort", "id"}, want: "invalid arguments for list: flag provided but not defined: -sort"},
+		{name: "existing view", args: []string{"save", "existing", "list"}, want: `view "existing" already exists`},
+		{name: "unknown view", args: []string{"run", "missing"}, want: `no view named "missing" (expected one of [existing])`},
+		{name: "delete unknown view", args: []string{"delete", "missing"}, want: `no view named "missing"`},
+		{name: "no local state", args: []string{"save", "--overwrite", "existing", "list"}, want: "local state is disabled"},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			args := []string{"nbictl", "--config_dir", confDir}
+			if tc.name == "no local state" {
+				args = append(args, "--no_local_state")
+			}
+			switch err := newTestApp().Run(append(append(args, "view"), tc.args...)); {
+			case err == nil:
+				t.Fatalf("expected %v to cause an error, got nil", tc.args)
+			case !strings.Contains(err.Error(), tc.want):
+				t.Fatalf("expected error to contain %q, but got %q", tc.want, err.Error())
+			}
+		})
+	}
+}
+
+func TestRunView(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 90*time.Second)
+	g, ctx := errgroup.WithContext(ctx)
+	defer func() { checkErr(t, g.Wait()) }()
+	defer cancel()
+
+	confDir, err := bazel.NewTmpDir("nbictl")
+	checkErr(t, err)
+	srv := startInsecureServer(ctx, t, g)
+	srv.ListEntityResponse = &nbipb.ListEntitiesResponse{Entities: []*nbipb.Entity{{
+		Group: &nbipb.EntityGroup{Type: nbipb.EntityType_NETWORK_NODE.Enum()},
+		Id:    proto.String("node-a"),
+		Value: &nbipb.Entity_NetworkNode{NetworkNode: &respb.NetworkNode{Name: proto.String("Node A")}},
+	}}}
+
+	keys := generateKeysForTesting(t, confDir, "--org", "example org")
+	checkErr(t, newTestApp().Run([]string{
+		"nbictl", "--config_dir", confDir,
+		"set-config",
+		"--transport_security", "insecure",
+		"--user_id", "usr1",
+		"--key_id", "key1",
+		"--priv_key", keys.key,
+		"--url", srv.listener.Addr().String(),
+	}))
+	checkErr(t, newTestApp().Run([]string{"nbictl", "--config_dir", confDir, "view", "save", "nodes", "list", "--type", "NETWORK_NODE", "--output", "table"}))
+
+	app := newTestApp()
+	checkErr(t, app.Run([]string{"nbictl", "--config_dir", confDir, "--context", "DEFAULT", "view", "run", "nodes", "--column", "name=network_node.name"}))
+	want := []string{
+		"TYPE          ID      name",
+		"NETWORK_NODE  node-a  Node A",
+	}
+	if diff := cmp.Diff(want, strings.Split(strings.TrimSpace(app.stdout.String()), "\n")); diff != "" {
+		t.Fatalf("unexpected output (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build js && wasm
+
+// package main exposes the parts of the Go client SDK that don't need a
+// network connection to JavaScript, so browser-based tools can mint
+// Spacetime tokens and check entities without reimplementing them.
+//
+// Build it with `GOOS=js GOARCH=wasm go build -o spacetime.wasm`, and load it
+// with the wasm_exec.js shipped in $(go env GOROOT)/lib/wasm. Once running,
+// it defines a global `spacetime` object whose functions each return an
+// object with either a `result` or an `error` string:
+//
+//	spacetime.mintToken(privateKeyPEM, privateKeyID, email, host)
+//	spacetime.entityToJSON(textproto)
+//	spacetime.entityFromJSON(json)
+//	spacetime.validateEntity(textproto)
+//
+// Requests themselves are left to the caller's transport, such as
+// gRPC-Web, using the minted token as the bearer token.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"syscall/js"
+
+	"github.com/jonboulle/clockwork"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/prototext"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+	"aalyria.com/spacetime/auth"
+	"aalyria.com/spacetime/github/tools/nbictl/entity"
+)
+
+func main() {
+	js.Global().Set("spacetime", js.ValueOf(map[string]any{
+		"mintToken":      jsFunc(4, mintToken),
+		"entityToJSON":   jsFunc(1, entityToJSON),
+		"entityFromJSON": jsFunc(1, entityFromJSON),
+		"validateEntity": jsFunc(1, validateEntity),
+	}))
+	// Keep the functions available for the lifetime of the page.
+	select {}
+}
+
+// jsFunc wraps `f`, which takes `nargs` string arguments, into a JavaScript
+// function returning `{result: ...}` or `{error: ...}`.
+func jsFunc(nargs int, f func(args []string) (string, error)) js.Func {
+	return js.FuncOf(func(_ js.Value, args []js.Value) any {
+		if len(args) != nargs {
+			return map[string]any{"error": fmt.Sprintf("expected %d arguments, got %d", nargs, len(args))}
+		}
+		strs := make([]string, len(args))
+		for i, a := range args {
+			if a.Type() != js.TypeString {
+				return map[string]any{"error": fmt.Sprintf("argument %d must be a string, got %s", i, a.Type())}
+			}
+			strs[i] = a.String()
+		}
+		res, err := f(strs)
+		if err != nil {
+			return map[string]any{"error": err.Error()}
+		}
+		return map[string]any{"result": res}
+	})
+}
+
+func mintToken(args []string) (string, error) {
+	src, err := auth.NewSpacetimeTokenSource(context.Background(), auth.Config{
+		Clock:        clockwork.NewRealClock(),
+		PrivateKey:   strings.NewReader(args[0]),
+		PrivateKeyID: args[1],
+		Email:        args[2],
+		Host:         args[3],
+	})
+	if err != nil {
+		return "", err
+	}
+	return src.Token(context.Background())
+}
+
+func entityToJSON(args []string) (string, error) {
+	e := &nbipb.Entity{}
+	if err := prototext.Unmarshal([]byte(args[0]), e); err != nil {
+		return "", fmt.Errorf("invalid textproto: %w", err)
+	}
+	out, err := protojson.Marshal(e)
+	return string(out), err
+}
+
+func entityFromJSON(args []string) (string, error) {
+	e := &nbipb.Entity{}
+	if err := protojson.Unmarshal([]byte(args[0]), e); err != nil {
+		return "", fmt.Errorf("invalid JSON: %w", err)
+	}
+	out, err := prototext.MarshalOptions{Multiline: true, Indent: "  "}.Marshal(e)
+	return string(out), err
+}
+
+// validateEntity returns an error describing the problems with the entity,
+// if any. Entity types without a wrapper in the entity package are only
+// checked for syntax.
+func validateEntity(args []string) (string, error) {
+	e := &nbipb.Entity{}
+	if err := prototext.Unmarshal([]byte(args[0]), e); err != nil {
+		return "", fmt.Errorf("invalid textproto: %w", err)
+	}
+	w, err := entity.FromProto(e)
+	switch {
+	case errors.Is(err, entity.ErrUnsupportedType):
+		return "", nil
+	case err != nil:
+		return "", err
+	}
+	return "", w.Validate()
+}
@@ -0,0 +1,266 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tfprovider
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringplanmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+	"aalyria.com/spacetime/github/tools/nbictl/entity"
+)
+
+// entityModel is the state of an entity resource.
+type entityModel struct {
+	ID              types.String `tfsdk:"id"`
+	Textproto       types.String `tfsdk:"textproto"`
+	CommitTimestamp types.Int64  `tfsdk:"commit_timestamp"`
+}
+
+type entityResource struct {
+	kind   entity.Kind
+	client nbipb.NetOpsClient
+}
+
+var (
+	_ resource.ResourceWithConfigure      = (*entityResource)(nil)
+	_ resource.ResourceWithImportState    = (*entityResource)(nil)
+	_ resource.ResourceWithValidateConfig = (*entityResource)(nil)
+)
+
+func (r *entityResource) Metadata(_ context.Context, req resource.MetadataRequest, resp *resource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_" + r.kind.Name
+}
+
+func (r *entityResource) Schema(_ context.Context, _ resource.SchemaRequest, resp *resource.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		Description: r.kind.Description,
+		Attributes: map[string]schema.Attribute{
+			"id": schema.StringAttribute{
+				Required:      true,
+				Description:   "The ID of the entity. Changing it replaces the entity.",
+				PlanModifiers: []planmodifier.String{stringplanmodifier.RequiresReplace()},
+			},
+			"textproto": schema.StringAttribute{
+				Required:    true,
+				Description: fmt.Sprintf("The value of the entity, in the protobuf text format: the contents of the `%s` field of the entity, as nbictl prints it.", r.kind.Field),
+			},
+			"commit_timestamp": schema.Int64Attribute{
+				Computed:    true,
+				Description: "The commit timestamp of the last change to the entity, in microseconds.",
+			},
+		},
+	}
+}
+
+func (r *entityResource) Configure(_ context.Context, req resource.ConfigureRequest, resp *resource.ConfigureResponse) {
+	// ProviderData is nil until the provider has been configured.
+	if req.ProviderData == nil {
+		return
+	}
+	client, ok := req.ProviderData.(nbipb.NetOpsClient)
+	if !ok {
+		resp.Diagnostics.AddError("Unexpected provider data", fmt.Sprintf("expected a NetOpsClient, got %T", req.ProviderData))
+		return
+	}
+	r.client = client
+}
+
+func (r *entityResource) ValidateConfig(ctx context.Context, req resource.ValidateConfigRequest, resp *resource.ValidateConfigResponse) {
+	var m entityModel
+	resp.Diagnostics.Append(req.Config.Get(ctx, &m)...)
+	// The values may only be known once other resources have been applied.
+	if resp.Diagnostics.HasError() || m.ID.IsUnknown() || m.Textproto.IsUnknown() {
+		return
+	}
+	if err := validateEntity(r.kind, m.ID.ValueString(), m.Textproto.ValueString()); err != nil {
+		resp.Diagnostics.AddAttributeError(path.Root("textproto"), "Invalid entity", err.Error())
+	}
+}
+
+func (r *entityResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
+	var m entityModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &m)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	if err := createEntity(ctx, r.client, r.kind, &m); err != nil {
+		resp.Diagnostics.AddError("Unable to create the entity", err.Error())
+		return
+	}
+	resp.Diagnostics.Append(resp.State.Set(ctx, &m)...)
+}
+
+func (r *entityResource) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
+	var m entityModel
+	resp.Diagnostics.Append(req.State.Get(ctx, &m)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	found, err := readEntity(ctx, r.client, r.kind, &m)
+	switch {
+	case err != nil:
+		resp.Diagnostics.AddError("Unable to read the entity", err.Error())
+	case !found:
+		// The entity was deleted outside of Terraform, so it needs to be
+		// created again.
+		resp.State.RemoveResource(ctx)
+	default:
+		resp.Diagnostics.Append(resp.State.Set(ctx, &m)...)
+	}
+}
+
+func (r *entityResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
+	var plan, state entityModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &plan)...)
+	resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	plan.CommitTimestamp = state.CommitTimestamp
+	if err := updateEntity(ctx, r.client, r.kind, &plan); err != nil {
+		resp.Diagnostics.AddError("Unable to update the entity", err.Error())
+		return
+	}
+	resp.Diagnostics.Append(resp.State.Set(ctx, &plan)...)
+}
+
+func (r *entityResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
+	var m entityModel
+	resp.Diagnostics.Append(req.State.Get(ctx, &m)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	if err := deleteEntity(ctx, r.client, r.kind, &m); err != nil {
+		resp.Diagnostics.AddError("Unable to delete the entity", err.Error())
+	}
+}
+
+func (r *entityResource) ImportState(ctx context.Context, req resource.ImportStateRequest, resp *resource.ImportStateResponse) {
+	resource.ImportStatePassthroughID(ctx, path.Root("id"), req, resp)
+}
+
+// buildEntity returns the entity of kind `k` with the given ID whose value
+// is `text`, in the protobuf text format.
+func buildEntity(k entity.Kind, id, text string) (*nbipb.Entity, error) {
+	e, err := k.Build(id, func(m proto.Message) error { return prototext.Unmarshal([]byte(text), m) })
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse the %s: %w", k.Field, err)
+	}
+	return e, nil
+}
+
+// validateEntity checks that `text` is a valid value for an entity of kind
+// `k`, including the checks of the typed wrappers for the types that have
+// one.
+func validateEntity(k entity.Kind, id, text string) error {
+	e, err := buildEntity(k, id, text)
+	if err != nil {
+		return err
+	}
+	return entity.Validate(e)
+}
+
+func createEntity(ctx context.Context, client nbipb.NetOpsClient, k entity.Kind, m *entityModel) error {
+	e, err := buildEntity(k, m.ID.ValueString(), m.Textproto.ValueString())
+	if err != nil {
+		return err
+	}
+	created, err := client.CreateEntity(ctx, &nbipb.CreateEntityRequest{Entity: e})
+	if err != nil {
+		return err
+	}
+	m.CommitTimestamp = types.Int64Value(created.GetCommitTimestamp())
+	return nil
+}
+
+// readEntity refreshes `m` with the entity on the server, and reports
+// whether it exists. The text of the value is only replaced if it no longer
+// matches the entity, so that formatting differences don't show up as
+// changes.
+func readEntity(ctx context.Context, client nbipb.NetOpsClient, k entity.Kind, m *entityModel) (found bool, err error) {
+	e, err := client.GetEntity(ctx, &nbipb.GetEntityRequest{Type: k.Type.Enum(), Id: proto.String(m.ID.ValueString())})
+	switch {
+	case status.Code(err) == codes.NotFound:
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+
+	value := k.Value(e)
+	if prev, err := buildEntity(k, m.ID.ValueString(), m.Textproto.ValueString()); err != nil || !proto.Equal(k.Value(prev), value) {
+		text, err := prototext.MarshalOptions{Multiline: true}.Marshal(value)
+		if err != nil {
+			return false, fmt.Errorf("unable to format the %s: %w", k.Field, err)
+		}
+		m.Textproto = types.StringValue(string(text))
+	}
+	m.CommitTimestamp = types.Int64Value(e.GetCommitTimestamp())
+	return true, nil
+}
+
+// updateEntity replaces the value of the entity with that of `m`. The update
+// fails if the entity has changed since the commit timestamp of `m`, so
+// changes made outside of Terraform aren't overwritten before they've been
+// refreshed.
+func updateEntity(ctx context.Context, client nbipb.NetOpsClient, k entity.Kind, m *entityModel) error {
+	e, err := buildEntity(k, m.ID.ValueString(), m.Textproto.ValueString())
+	if err != nil {
+		return err
+	}
+	e.CommitTimestamp = proto.Int64(m.CommitTimestamp.ValueInt64())
+	updated, err := client.UpdateEntity(ctx, &nbipb.UpdateEntityRequest{Entity: e})
+	if err != nil {
+		return conflictError(err)
+	}
+	m.CommitTimestamp = types.Int64Value(updated.GetCommitTimestamp())
+	return nil
+}
+
+// deleteEntity deletes the entity of `m`, unless it has changed since the
+// commit timestamp of `m`. An entity that's already gone isn't an error.
+func deleteEntity(ctx context.Context, client nbipb.NetOpsClient, k entity.Kind, m *entityModel) error {
+	_, err := client.DeleteEntity(ctx, &nbipb.DeleteEntityRequest{
+		Type:                k.Type.Enum(),
+		Id:                  proto.String(m.ID.ValueString()),
+		LastCommitTimestamp: proto.Int64(m.CommitTimestamp.ValueInt64()),
+	})
+	if status.Code(err) == codes.NotFound {
+		return nil
+	}
+	return conflictError(err)
+}
+
+// conflictError explains how to resolve `err` if it's the server rejecting a
+// change because the entity was modified since Terraform last read it.
+func conflictError(err error) error {
+	switch status.Code(err) {
+	case codes.FailedPrecondition, codes.Aborted:
+		return fmt.Errorf("the entity was modified after it was last read; refresh the state and review the changes before applying them again: %w", err)
+	default:
+		return err
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tfprovider
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/proto"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+	"aalyria.com/spacetime/github/tools/nbictl/entity"
+	"aalyria.com/spacetime/github/tools/nbictl/nbitest"
+)
+
+const platformText = `name: "gs" coordinates { geodetic_wgs84 { latitude_deg: 1 longitude_deg: 2 } }`
+
+var platformKind = entity.Kinds[0]
+
+func startNBITestServer(t *testing.T) (*nbitest.Server, nbipb.NetOpsClient) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	g, ctx := errgroup.WithContext(ctx)
+	t.Cleanup(func() {
+		cancel()
+		if err := g.Wait(); err != nil {
+			t.Errorf("serving: %v", err)
+		}
+	})
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := nbitest.New()
+	g.Go(func() error { return srv.Serve(ctx, lis) })
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return srv, nbipb.NewNetOpsClient(conn)
+}
+
+func TestEntityLifecycle(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	srv, client := startNBITestServer(t)
+
+	m := &entityModel{ID: types.StringValue("gs"), Textproto: types.StringValue(platformText)}
+	if err := createEntity(ctx, client, platformKind, m); err != nil {
+		t.Fatalf("createEntity: %v", err)
+	}
+	stored, ok := srv.Entity(nbipb.EntityType_PLATFORM_DEFINITION, "gs")
+	if !ok {
+		t.Fatal("the entity wasn't created")
+	}
+	if got, want := m.CommitTimestamp.ValueInt64(), stored.GetCommitTimestamp(); got != want {
+		t.Errorf("commit_timestamp = %d, want %d", got, want)
+	}
+
+	// Refreshing an unchanged entity keeps the text as written.
+	if found, err := readEntity(ctx, client, platformKind, m); err != nil || !found {
+		t.Fatalf("readEntity() = %t, %v, want true, nil", found, err)
+	}
+	if got := m.Textproto.ValueString(); got != platformText {
+		t.Errorf("textproto after refresh = %q, want it unchanged", got)
+	}
+
+	// Changes made outside of Terraform are picked up, and updates made
+	// before refreshing them are rejected.
+	stale := *m
+	stored.GetPlatform().Name = proto.String("renamed")
+	srv.Put(stored)
+	stale.Textproto = types.StringValue(`name: "other"`)
+	if err := updateEntity(ctx, client, platformKind, &stale); err == nil || !strings.Contains(err.Error(), "modified after it was last read") {
+		t.Errorf("updateEntity() with a stale commit timestamp = %v, want a conflict", err)
+	}
+	if err := deleteEntity(ctx, client, platformKind, &stale); err == nil {
+		t.Error("deleteEntity() with a stale commit timestamp succeeded")
+	}
+	if _, err := readEntity(ctx, client, platformKind, m); err != nil {
+		t.Fatalf("readEntity: %v", err)
+	}
+	if got := m.Textproto.ValueString(); !strings.Contains(got, `name: "renamed"`) {
+		t.Errorf("textproto after refresh = %q, want the renamed platform", got)
+	}
+
+	m.Textproto = types.StringValue(platformText)
+	if err := updateEntity(ctx, client, platformKind, m); err != nil {
+		t.Fatalf("updateEntity: %v", err)
+	}
+	if stored, _ := srv.Entity(nbipb.EntityType_PLATFORM_DEFINITION, "gs"); stored.GetPlatform().GetName() != "gs" {
+		t.Errorf("stored platform = %v, want it updated", stored)
+	}
+
+	if err := deleteEntity(ctx, client, platformKind, m); err != nil {
+		t.Fatalf("deleteEntity: %v", err)
+	}
+	if found, err := readEntity(ctx, client, platformKind, m); err != nil || found {
+		t.Errorf("readEntity() after deleting = %t, %v, want false, nil", found, err)
+	}
+	if err := deleteEntity(ctx, client, platformKind, m); err != nil {
+		t.Errorf("deleteEntity() of a deleted entity = %v, want nil", err)
+	}
+}
+
+func TestValidateEntity(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name, text string
+		wantErr    string
+	}{
+		{name: "valid", text: platformText},
+		{name: "syntax error", text: `name: `, wantErr: "unable to parse the platform"},
+		{name: "unknown field", text: `nme: "gs"`, wantErr: "unable to parse the platform"},
+		{name: "no motion", text: `name: "gs"`, wantErr: "either coordinates or motion_ref_id is required"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := validateEntity(platformKind, "gs", tc.text)
+			switch {
+			case tc.wantErr == "" && err != nil:
+				t.Errorf("validateEntity() = %v, want nil", err)
+			case tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+				t.Errorf("validateEntity() = %v, want an error containing %q", err, tc.wantErr)
+			}
+		})
+	}
+
+	// Types without a typed wrapper are only checked for syntax.
+	if err := validateEntity(entity.Kinds[2], "node", `name: "node"`); err != nil {
+		t.Errorf("validateEntity() of a network node = %v, want nil", err)
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2024 Aalyria Technologies, Inc., and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tfprovider implements a Terraform provider that manages the
+// entities of a Spacetime instance through the NBI, so that network models
+// can be kept in the same infrastructure-as-code workflows as the rest of an
+// organization's infrastructure.
+//
+// The provider connects with the settings and credentials of an nbictl
+// context (see nbictl.Dial). Each resource manages the entities of one type,
+// whose value is given in the textproto format nbictl uses.
+package tfprovider
+
+import (
+	"context"
+
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/provider"
+	"github.com/hashicorp/terraform-plugin-framework/provider/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+
+	nbipb "aalyria.com/spacetime/api/nbi/v1alpha"
+	"aalyria.com/spacetime/github/tools/nbictl"
+	"aalyria.com/spacetime/github/tools/nbictl/entity"
+)
+
+// Address is the registry address the provider is published under.
+const Address = "registry.terraform.io/aalyria/spacetime"
+
+type spacetimeProvider struct {
+	version string
+}
+
+// providerModel is the provider's configuration.
+type providerModel struct {
+	ConfigDir types.String `tfsdk:"config_dir"`
+	Context   types.String `tfsdk:"context"`
+}
+
+// New returns a function that creates the provider, reporting `version` as
+// its version.
+func New(version string) func() provider.Provider {
+	return func() provider.Provider {
+		return &spacetimeProvider{version: version}
+	}
+}
+
+func (p *spacetimeProvider) Metadata(_ context.Context, _ provider.MetadataRequest, resp *provider.MetadataResponse) {
+	resp.TypeName = "spacetime"
+	resp.Version = p.version
+}
+
+func (p *spacetimeProvider) Schema(_ context.Context, _ provider.SchemaRequest, resp *provider.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		Description: "Manages the entities of a Spacetime instance through the NBI.",
+		Attributes: map[string]schema.Attribute{
+			"config_dir": schema.StringAttribute{
+				Optional:    true,
+				Description: "The nbictl configuration directory to read the connection settings from. Defaults to that of nbictl, $XDG_CONFIG_HOME/nbictl.",
+			},
+			"context": schema.StringAttribute{
+				Optional:    true,
+				Description: "The nbictl context (configuration profile) to connect with. May be omitted if the configuration defines a single context.",
+			},
+		},
+	}
+}
+
+func (p *spacetimeProvider) Configure(ctx context.Context, req provider.ConfigureRequest, resp *provider.ConfigureResponse) {
+	var conf providerModel
+	resp.Diagnostics.Append(req.Config.Get(ctx, &conf)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	conn, err := nbictl.Dial(ctx, conf.ConfigDir.ValueString(), conf.Context.ValueString())
+	if err != nil {
+		resp.Diagnostics.AddError("Unable to connect to the NBI", err.Error())
+		return
+	}
+	client := nbipb.NewNetOpsClient(conn)
+	resp.ResourceData = client
+	resp.DataSourceData = client
+}
+
+func (p *spacetimeProvider) Resources(_ context.Context) []func() resource.Resource {
+	resources := []func() resource.Resource{}
+	for _, k := range entity.Kinds {
+		resources = append(resources, func() resource.Resource { return &entityResource{kind: k} })
+	}
+	return resources
+}
+
+func (p *spacetimeProvider) DataSources(_ context.Context) []func() datasource.DataSource {
+	return nil
+}